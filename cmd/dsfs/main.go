@@ -0,0 +1,45 @@
+// Command dsfs is the distributed file store: one binary that runs the
+// central API, a storage node, or a load generator against a cluster.
+//
+//	dsfs central [-config file.json] [-port 8000]
+//	dsfs storage [-port 9001] [-region singapore]
+//	dsfs bench [-central URL] [-c 8] [-d 10s] [-size 64KiB,1MiB]
+//	dsfs fsck [-central URL] [-token TOKEN] [-repair] [-json]
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/bench"
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/central"
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/fsck"
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/storage"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: dsfs central [flags]")
+	fmt.Fprintln(os.Stderr, "       dsfs storage [flags]")
+	fmt.Fprintln(os.Stderr, "       dsfs bench [flags]")
+	fmt.Fprintln(os.Stderr, "       dsfs fsck [flags]")
+	fmt.Fprintln(os.Stderr, "Run 'dsfs <command> -h' for the flags of a command.")
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	switch os.Args[1] {
+	case "central":
+		central.Main(os.Args[2:])
+	case "storage":
+		storage.Main(os.Args[2:])
+	case "bench":
+		bench.Main(os.Args[2:])
+	case "fsck":
+		fsck.Main(os.Args[2:])
+	default:
+		usage()
+	}
+}
@@ -0,0 +1,3 @@
+module github.com/hongkhy-kong/Distributed_mission_1
+
+go 1.24
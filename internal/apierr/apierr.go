@@ -0,0 +1,170 @@
+// Package apierr is the body the central API and the storage nodes answer
+// a failed request with, so clients can tell failures apart without
+// matching on messages:
+//
+//	{"status":404,"code":"not_found","message":"File not found","request_id":"9f3c2a7e1b04d5c6","retryable":false}
+//
+// The code is a short snake_case name, by default the status's (see
+// CodeFor); retryable says whether the same request could succeed if sent
+// again later. The request ID is the X-Request-Id of the response, which
+// RequestIDs gives every request.
+package apierr
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// RequestIDHeader carries a request's ID: the client's, if it sent one,
+// or one made up by RequestIDs.
+const RequestIDHeader = "X-Request-Id"
+
+// Request IDs from clients longer than this are replaced.
+const maxRequestID = 128
+
+// Error is a failed request's body.
+type Error struct {
+	Status    int    `json:"status"`
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+	Retryable bool   `json:"retryable"`
+}
+
+func (e *Error) Error() string {
+	s := fmt.Sprintf("%d %s: %s", e.Status, e.Code, e.Message)
+	if e.RequestID != "" {
+		s += " (request " + e.RequestID + ")"
+	}
+	return s
+}
+
+// New returns the error for status with msg, under code or, if code is
+// empty, the status's.
+func New(status int, code, msg string) *Error {
+	if code == "" {
+		code = CodeFor(status)
+	}
+	return &Error{Status: status, Code: code, Message: msg, Retryable: RetryableStatus(status)}
+}
+
+// Write answers with e, under the request ID already set on w.
+func Write(w http.ResponseWriter, e *Error) {
+	e.RequestID = w.Header().Get(RequestIDHeader)
+	h := w.Header()
+	// Whatever the handler meant to send with a success is stale; the same
+	// headers http.Error drops.
+	h.Del("Content-Length")
+	h.Del("Content-Encoding")
+	h.Del("Etag")
+	h.Del("Last-Modified")
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(e.Status)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(e)
+}
+
+// Send answers with msg and status under the status's code: http.Error
+// with a JSON body.
+func Send(w http.ResponseWriter, msg string, status int) {
+	Write(w, New(status, "", msg))
+}
+
+// SendCode is Send under code.
+func SendCode(w http.ResponseWriter, status int, code, msg string) {
+	Write(w, New(status, code, msg))
+}
+
+// CodeFor is the code of a status with nothing more specific to say:
+// its text in snake case, e.g. "not_found" for 404.
+func CodeFor(status int) string {
+	text := http.StatusText(status)
+	if text == "" {
+		return "error"
+	}
+	text = strings.NewReplacer("-", " ", "'", "").Replace(strings.ToLower(text))
+	return strings.Join(strings.Fields(text), "_")
+}
+
+// RetryableStatus reports whether a request that failed with status could
+// succeed if sent again: the server was overloaded, failed internally or
+// could not reach what it depends on. Other 4xx won't change by asking
+// again, nor will "not implemented" or "disk full".
+func RetryableStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// Parse reads the error in the body of a response with status. A body
+// that is not an error of this package, such as one from an older node
+// or a proxy, becomes the message of the status's error.
+func Parse(status int, body []byte) *Error {
+	var e Error
+	if json.Unmarshal(body, &e) == nil && e.Code != "" {
+		if e.Status == 0 {
+			e.Status = status
+		}
+		return &e
+	}
+	return New(status, "", string(bytes.TrimSpace(body)))
+}
+
+// FromResponse reads the error out of resp, which failed. It does not
+// close the body.
+func FromResponse(resp *http.Response) *Error {
+	var b bytes.Buffer
+	b.ReadFrom(io.LimitReader(resp.Body, 64<<10))
+	e := Parse(resp.StatusCode, b.Bytes())
+	if e.RequestID == "" {
+		e.RequestID = resp.Header.Get(RequestIDHeader)
+	}
+	return e
+}
+
+// RequestIDs gives every request handled by next an ID, set on the
+// response before next runs: the client's X-Request-Id, if it sent a
+// usable one, or a new one.
+func RequestIDs(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = NewRequestID()
+			r.Header.Set(RequestIDHeader, id)
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// NewRequestID returns a random request ID.
+func NewRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validRequestID accepts IDs of printable ASCII without spaces, so they
+// can be logged and echoed as they are.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestID {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
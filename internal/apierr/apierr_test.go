@@ -0,0 +1,58 @@
+package apierr
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCodeFor(t *testing.T) {
+	for status, want := range map[int]string{
+		http.StatusNotFound:             "not_found",
+		http.StatusInsufficientStorage:  "insufficient_storage",
+		http.StatusTeapot:               "im_a_teapot",
+		http.StatusNonAuthoritativeInfo: "non_authoritative_information",
+		599:                             "error",
+	} {
+		if got := CodeFor(status); got != want {
+			t.Errorf("CodeFor(%d) = %q, want %q", status, got, want)
+		}
+	}
+}
+
+func TestRequestIDs(t *testing.T) {
+	h := RequestIDs(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Send(w, "No such <file>", http.StatusServiceUnavailable)
+	}))
+	for _, sent := range []string{"", "client-id-1", "bad id"} {
+		req := httptest.NewRequest("GET", "/", nil)
+		if sent != "" {
+			req.Header.Set(RequestIDHeader, sent)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		var e Error
+		if err := json.Unmarshal(rec.Body.Bytes(), &e); err != nil {
+			t.Fatalf("%s: %v", rec.Body, err)
+		}
+		id := rec.Header().Get(RequestIDHeader)
+		if id == "" || e.RequestID != id || (sent == "client-id-1") != (id == sent) {
+			t.Errorf("sent %q: header %q, body %q", sent, id, e.RequestID)
+		}
+		want := Error{Status: 503, Code: "service_unavailable", Message: "No such <file>", RequestID: id, Retryable: true}
+		if e != want {
+			t.Errorf("body = %+v, want %+v", e, want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	if e := Parse(502, []byte("bad gateway\n")); e.Code != "bad_gateway" || e.Message != "bad gateway" || !e.Retryable {
+		t.Errorf("plain text = %+v", e)
+	}
+	if e := Parse(507, []byte(`{"code":"quota_exceeded","message":"full"}`)); e.Status != 507 || e.Code != "quota_exceeded" || e.Retryable {
+		t.Errorf("envelope = %+v", e)
+	}
+}
@@ -0,0 +1,278 @@
+// Package bench drives concurrent uploads and downloads against a running
+// cluster and reports throughput and latency per storage node.
+package bench
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	mrand "math/rand/v2"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Options configures a benchmark run.
+type Options struct {
+	Central     string        // central API base URL
+	Concurrency int           // parallel workers
+	Duration    time.Duration // run length, when Ops is 0
+	Ops         int           // total operations (0 = run for Duration)
+	Sizes       []int64       // upload sizes, picked at random per upload
+	ReadRatio   float64       // fraction of operations that are downloads
+	Prefix      string        // name prefix of the files it creates
+	Cleanup     bool          // delete the files afterwards
+	Location    url.Values    // lat/lon sent with every request, if set
+}
+
+// Main runs: dsfs bench [flags].
+func Main(args []string) {
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	central := flags.String("central", "http://localhost:8000", "central API URL")
+	concurrency := flags.Int("c", 8, "concurrent workers")
+	duration := flags.Duration("d", 10*time.Second, "how long to run (ignored with -n)")
+	ops := flags.Int("n", 0, "total operations to run (0 = run for -d)")
+	sizes := flags.String("size", "64KiB,1MiB", "comma separated upload sizes (B, KiB, MiB, GiB, KB, MB, GB)")
+	read := flags.Float64("read", 0.7, "fraction of operations that are downloads")
+	prefix := flags.String("prefix", "bench-", "name prefix for uploaded files")
+	keep := flags.Bool("keep", false, "keep the uploaded files instead of deleting them")
+	lat := flags.String("lat", "", "client latitude to send (default: let the central API decide)")
+	lon := flags.String("lon", "", "client longitude to send")
+	flags.Parse(args)
+
+	opts := Options{
+		Central:     strings.TrimSuffix(*central, "/"),
+		Concurrency: *concurrency,
+		Duration:    *duration,
+		Ops:         *ops,
+		ReadRatio:   *read,
+		Prefix:      *prefix,
+		Cleanup:     !*keep,
+	}
+	for _, s := range strings.Split(*sizes, ",") {
+		n, err := ParseSize(s)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "bench:", err)
+			os.Exit(2)
+		}
+		opts.Sizes = append(opts.Sizes, n)
+	}
+	if *lat != "" || *lon != "" {
+		opts.Location = url.Values{"lat": {*lat}, "lon": {*lon}}
+	}
+
+	report, err := Run(opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "bench:", err)
+		os.Exit(1)
+	}
+	fmt.Print(report)
+}
+
+// ParseSize reads sizes like "512", "64KiB", "10MB" or "1GiB".
+func ParseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	units := []struct {
+		suffix string
+		mult   int64
+	}{
+		{"GiB", 1 << 30}, {"MiB", 1 << 20}, {"KiB", 1 << 10},
+		{"GB", 1e9}, {"MB", 1e6}, {"KB", 1e3}, {"B", 1},
+	}
+	mult := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			s, mult = strings.TrimSuffix(s, u.suffix), u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * mult, nil
+}
+
+// Run executes the benchmark and returns its report.
+func Run(opts Options) (*Report, error) {
+	if opts.Concurrency < 1 {
+		return nil, errors.New("concurrency must be at least 1")
+	}
+	if len(opts.Sizes) == 0 {
+		return nil, errors.New("at least one size is required")
+	}
+	if opts.Ops <= 0 && opts.Duration <= 0 {
+		return nil, errors.New("either a duration or an operation count is required")
+	}
+
+	r := &runner{
+		opts:   opts,
+		report: newReport(),
+		client: &http.Client{
+			Timeout: 5 * time.Minute,
+			// Downloads are redirected to a node; follow them by hand to
+			// see which node served the file.
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+			Transport: &http.Transport{
+				MaxIdleConnsPerHost: opts.Concurrency,
+				IdleConnTimeout:     30 * time.Second,
+			},
+		},
+	}
+
+	deadline := time.Now().Add(opts.Duration)
+	var remaining atomic.Int64
+	remaining.Store(int64(opts.Ops))
+	next := func() bool {
+		if opts.Ops > 0 {
+			return remaining.Add(-1) >= 0
+		}
+		return time.Now().Before(deadline)
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for w := 0; w < opts.Concurrency; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for seq := 0; next(); seq++ {
+				name, ok := r.pick()
+				if !ok || mrand.Float64() >= opts.ReadRatio {
+					r.upload(fmt.Sprintf("%s%d-%d-%d", opts.Prefix, start.Unix(), w, seq))
+				} else {
+					r.download(name)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	r.report.Elapsed = time.Since(start)
+
+	if opts.Cleanup {
+		r.cleanup()
+	}
+	return r.report, nil
+}
+
+type runner struct {
+	opts   Options
+	report *Report
+	client *http.Client
+
+	mu    sync.Mutex
+	files []string
+}
+
+// pick returns a random file uploaded so far.
+func (r *runner) pick() (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.files) == 0 {
+		return "", false
+	}
+	return r.files[mrand.IntN(len(r.files))], true
+}
+
+func (r *runner) url(path string, extra url.Values) string {
+	q := url.Values{}
+	for k, v := range r.opts.Location {
+		q[k] = v
+	}
+	for k, v := range extra {
+		q[k] = v
+	}
+	if len(q) == 0 {
+		return r.opts.Central + path
+	}
+	return r.opts.Central + path + "?" + q.Encode()
+}
+
+// upload sends one file through the central API, which replicates it before
+// answering, so the latency includes replication.
+func (r *runner) upload(name string) {
+	size := r.opts.Sizes[mrand.IntN(len(r.opts.Sizes))]
+	payload := make([]byte, size)
+	rand.Read(payload)
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("file", name)
+	fw.Write(payload)
+	mw.Close()
+
+	req, err := http.NewRequest("POST", r.url("/upload", nil), &body)
+	if err != nil {
+		r.report.record("upload", "central", 0, 0, err)
+		return
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Accept", "application/json")
+
+	start := time.Now()
+	resp, err := r.client.Do(req)
+	if err == nil {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			err = fmt.Errorf("upload %s: %s", name, resp.Status)
+		}
+	}
+	r.report.record("upload", "central", size, time.Since(start), err)
+	if err == nil {
+		r.mu.Lock()
+		r.files = append(r.files, name)
+		r.mu.Unlock()
+	}
+}
+
+// download fetches a file through the central API's redirect and charges
+// the time to the node that served it.
+func (r *runner) download(name string) {
+	start := time.Now()
+	resp, err := r.client.Get(r.url("/get/"+url.PathEscape(name), nil))
+	if err != nil {
+		r.report.record("download", "central", 0, time.Since(start), err)
+		return
+	}
+	resp.Body.Close()
+	node := resp.Header.Get("X-Storage-Node")
+	if node == "" {
+		node = "central"
+	}
+	loc, err := resp.Location()
+	if err != nil {
+		r.report.record("download", node, 0, time.Since(start), fmt.Errorf("get %s: %s", name, resp.Status))
+		return
+	}
+
+	resp, err = r.client.Get(loc.String())
+	if err != nil {
+		r.report.record("download", node, 0, time.Since(start), err)
+		return
+	}
+	n, err := io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if err == nil && resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("download %s from %s: %s", name, node, resp.Status)
+	}
+	r.report.record("download", node, n, time.Since(start), err)
+}
+
+func (r *runner) cleanup() {
+	for _, name := range r.files {
+		resp, err := r.client.PostForm(r.url("/delete", nil), url.Values{"filename": {name}})
+		if err == nil {
+			resp.Body.Close()
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package bench
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseSize(t *testing.T) {
+	for in, want := range map[string]int64{
+		"512": 512, "64KiB": 64 << 10, "1MiB": 1 << 20, "10MB": 10e6, " 2 GiB": 2 << 30, "3B": 3,
+	} {
+		got, err := ParseSize(in)
+		if err != nil || got != want {
+			t.Errorf("ParseSize(%q) = %d, %v; want %d", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "MiB", "-1KiB", "1TiB", "x"} {
+		if _, err := ParseSize(in); err == nil {
+			t.Errorf("ParseSize(%q) succeeded", in)
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	s := &Series{}
+	for i := 100; i >= 1; i-- {
+		s.Latencies = append(s.Latencies, time.Duration(i)*time.Millisecond)
+	}
+	for p, want := range map[float64]time.Duration{50: 50, 90: 90, 99: 99, 100: 100, 0: 1} {
+		if got := s.Percentile(p); got != want*time.Millisecond {
+			t.Errorf("p%v = %s, want %s", p, got, want*time.Millisecond)
+		}
+	}
+}
+
+// fakeCluster stands in for the central API and two storage nodes.
+func fakeCluster(t *testing.T) (*httptest.Server, map[string]int) {
+	var mu sync.Mutex
+	files := map[string][]byte{}
+	deleted := map[string]int{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /upload", func(w http.ResponseWriter, r *http.Request) {
+		f, h, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		b, _ := io.ReadAll(f)
+		mu.Lock()
+		files[h.Filename] = b
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"status":"done"}`)
+	})
+	mux.HandleFunc("GET /get/{name}", func(w http.ResponseWriter, r *http.Request) {
+		node := "n1"
+		if len(r.PathValue("name"))%2 == 0 {
+			node = "n2"
+		}
+		w.Header().Set("X-Storage-Node", node)
+		http.Redirect(w, r, "/"+node+"/files/"+r.PathValue("name"), http.StatusFound)
+	})
+	mux.HandleFunc("GET /{node}/files/{name}", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		b, ok := files[r.PathValue("name")]
+		mu.Unlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(b)
+	})
+	mux.HandleFunc("POST /delete", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		deleted[r.FormValue("filename")]++
+		mu.Unlock()
+		http.Redirect(w, r, "/files", http.StatusSeeOther)
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv, deleted
+}
+
+func TestRun(t *testing.T) {
+	srv, deleted := fakeCluster(t)
+
+	report, err := Run(Options{
+		Central:     srv.URL,
+		Concurrency: 4,
+		Ops:         200,
+		Sizes:       []int64{1 << 10, 4 << 10},
+		ReadRatio:   0.8,
+		Prefix:      "bench-",
+		Cleanup:     true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ops := map[string]int{}
+	total := 0
+	for _, s := range report.Series() {
+		if s.Errors > 0 {
+			t.Errorf("%s on %s: %d errors, last: %s", s.Op, s.Node, s.Errors, s.LastError)
+		}
+		if s.Op == "download" && s.Bytes < int64(len(s.Latencies))<<10 {
+			t.Errorf("download from %s read %d bytes for %d files", s.Node, s.Bytes, len(s.Latencies))
+		}
+		ops[s.Op+"/"+s.Node] += len(s.Latencies)
+		total += len(s.Latencies)
+	}
+	if total != 200 {
+		t.Errorf("recorded %d operations, want 200", total)
+	}
+	if ops["upload/central"] == 0 || ops["download/n1"] == 0 || ops["download/n2"] == 0 {
+		t.Errorf("operations per node = %v, want uploads and downloads from both nodes", ops)
+	}
+	if len(deleted) != ops["upload/central"] {
+		t.Errorf("cleanup deleted %d files, want %d", len(deleted), ops["upload/central"])
+	}
+
+	out := report.String()
+	for _, want := range []string{"upload", "download", "n1", "n2", "p99"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("report is missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestRunRequiresWork(t *testing.T) {
+	if _, err := Run(Options{Concurrency: 1, Sizes: []int64{1}}); err == nil {
+		t.Error("Run without a duration or operation count succeeded")
+	}
+}
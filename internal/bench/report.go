@@ -0,0 +1,114 @@
+package bench
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Report holds the per operation and per node measurements of a run.
+type Report struct {
+	Elapsed time.Duration
+
+	mu     sync.Mutex
+	series map[seriesKey]*Series
+}
+
+type seriesKey struct {
+	op, node string
+}
+
+// Series is the measurements for one operation against one node.
+type Series struct {
+	Op, Node  string
+	Bytes     int64
+	Errors    int
+	Latencies []time.Duration // successful operations only
+	LastError string
+}
+
+func newReport() *Report {
+	return &Report{series: map[seriesKey]*Series{}}
+}
+
+func (r *Report) record(op, node string, n int64, d time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	k := seriesKey{op, node}
+	s, ok := r.series[k]
+	if !ok {
+		s = &Series{Op: op, Node: node}
+		r.series[k] = s
+	}
+	if err != nil {
+		s.Errors++
+		s.LastError = err.Error()
+		return
+	}
+	s.Bytes += n
+	s.Latencies = append(s.Latencies, d)
+}
+
+// Series returns every series, ordered by operation and node.
+func (r *Report) Series() []*Series {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []*Series
+	for _, s := range r.series {
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Op != out[j].Op {
+			return out[i].Op > out[j].Op // uploads first
+		}
+		return out[i].Node < out[j].Node
+	})
+	return out
+}
+
+// Percentile returns the p-th percentile (0-100) latency.
+func (s *Series) Percentile(p float64) time.Duration {
+	if len(s.Latencies) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), s.Latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	i := int(p/100*float64(len(sorted))+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
+
+func (r *Report) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Ran for %s\n\n", r.Elapsed.Round(time.Millisecond))
+	tw := tabwriter.NewWriter(&b, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "op\tnode\tok\terrors\tops/s\tMB/s\tp50\tp90\tp99\tmax\t")
+	secs := r.Elapsed.Seconds()
+	for _, s := range r.Series() {
+		ok := len(s.Latencies)
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%.1f\t%.2f\t%s\t%s\t%s\t%s\t\n",
+			s.Op, s.Node, ok, s.Errors,
+			float64(ok)/secs, float64(s.Bytes)/1e6/secs,
+			ms(s.Percentile(50)), ms(s.Percentile(90)), ms(s.Percentile(99)), ms(s.Percentile(100)))
+	}
+	tw.Flush()
+	for _, s := range r.Series() {
+		if s.LastError != "" {
+			fmt.Fprintf(&b, "\nlast %s error on %s: %s", s.Op, s.Node, s.LastError)
+		}
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+func ms(d time.Duration) string {
+	return fmt.Sprintf("%.1fms", float64(d)/float64(time.Millisecond))
+}
@@ -0,0 +1,72 @@
+// Package buildinfo is what the binary knows about how it was built. Release
+// builds stamp it with
+//
+//	go build -ldflags "-X github.com/hongkhy-kong/Distributed_mission_1/internal/buildinfo.Version=v1.4.0
+//	  -X github.com/hongkhy-kong/Distributed_mission_1/internal/buildinfo.Commit=$(git rev-parse HEAD)
+//	  -X github.com/hongkhy-kong/Distributed_mission_1/internal/buildinfo.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Without the flags the version is "dev", and the commit and build time
+// come from the VCS stamp go build adds inside a checkout, if there is one.
+package buildinfo
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+// Set with -ldflags -X.
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildTime = ""
+)
+
+// Info describes one build.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildTime string `json:"build_time,omitempty"`
+	Go        string `json:"go,omitempty"`
+}
+
+// Get returns this binary's build.
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit, BuildTime: BuildTime, Go: runtime.Version()}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch {
+			case s.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = s.Value
+			case s.Key == "vcs.time" && info.BuildTime == "":
+				info.BuildTime = s.Value
+			}
+		}
+	}
+	return info
+}
+
+// ShortCommit is the first 7 characters of the commit, enough to tell
+// builds apart.
+func (i Info) ShortCommit() string {
+	if len(i.Commit) > 7 {
+		return i.Commit[:7]
+	}
+	return i.Commit
+}
+
+// String is the version and short commit, e.g. "v1.4.0 (3f2a9c1)". Two
+// builds with the same String run the same code.
+func (i Info) String() string {
+	if c := i.ShortCommit(); c != "" {
+		return i.Version + " (" + c + ")"
+	}
+	return i.Version
+}
+
+// Handler serves Get as JSON, for GET /version.
+func Handler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Get())
+}
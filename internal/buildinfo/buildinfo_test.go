@@ -0,0 +1,18 @@
+package buildinfo
+
+import "testing"
+
+func TestString(t *testing.T) {
+	for _, tc := range []struct {
+		info Info
+		want string
+	}{
+		{Info{Version: "dev"}, "dev"},
+		{Info{Version: "v1.4.0", Commit: "3f2a9c1d0e"}, "v1.4.0 (3f2a9c1)"},
+		{Info{Version: "v1.4.0", Commit: "3f2a"}, "v1.4.0 (3f2a)"},
+	} {
+		if got := tc.info.String(); got != tc.want {
+			t.Errorf("%+v: %q, want %q", tc.info, got, tc.want)
+		}
+	}
+}
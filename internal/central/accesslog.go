@@ -0,0 +1,616 @@
+package central
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+)
+
+// ---------------------------
+// Access Log
+// ---------------------------
+
+// Every request for a file (downloads, redirects, previews, uploads,
+// deletes, ...) becomes one AccessRecord per file. Records go to the
+// configured sink as JSON lines, and are summed up by day, file and region
+// for /api/v1/analytics/access. Redirects count the bytes of the redirect
+// only: the file itself comes from the node.
+
+// AccessRecord is one access to one file.
+type AccessRecord struct {
+	Time   time.Time `json:"time"`
+	Method string    `json:"method"`
+	Route  string    `json:"route"`
+	File   string    `json:"file"`
+	User   string    `json:"user,omitempty"`
+	// ImpersonatedBy is the admin who made the request as User.
+	ImpersonatedBy string  `json:"impersonated_by,omitempty"`
+	IP             string  `json:"ip"`
+	Region         string  `json:"region"`
+	Status         int     `json:"status"`
+	BytesIn        int64   `json:"bytes_in"`
+	BytesOut       int64   `json:"bytes_out"`
+	LatencyMs      float64 `json:"latency_ms"`
+	RequestID      string  `json:"request_id,omitempty"`
+}
+
+// accessSink receives the records; writes must not block for long.
+type accessSink interface {
+	write(AccessRecord)
+	close() error
+}
+
+var accessLog accessSink // nil = no sink, aggregates only
+
+// newAccessSink opens the sink spec names: "" for none, "-" for stdout,
+// an http(s) URL to POST batches of JSON lines to, or a file path, rotated
+// once it reaches maxBytes with backups old files kept.
+func newAccessSink(spec string, maxBytes int64, backups int) (accessSink, error) {
+	switch {
+	case spec == "":
+		return nil, nil
+	case spec == "-":
+		return &writerSink{w: os.Stdout}, nil
+	case strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://"):
+		if _, err := url.Parse(spec); err != nil {
+			return nil, fmt.Errorf("access_log: %w", err)
+		}
+		return newHTTPSink(spec), nil
+	}
+	return openRotatingFile(spec, maxBytes, backups)
+}
+
+// writerSink writes JSON lines to w.
+type writerSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *writerSink) write(rec AccessRecord) {
+	b, _ := json.Marshal(rec)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.w.Write(append(b, '\n'))
+}
+
+func (s *writerSink) close() error { return nil }
+
+// rotatingFile writes JSON lines to path; when it would grow past
+// maxBytes it becomes path.1, path.1 becomes path.2 and so on, keeping
+// backups of them.
+type rotatingFile struct {
+	mu       sync.Mutex
+	path     string
+	maxBytes int64
+	backups  int
+	f        *os.File
+	size     int64
+}
+
+func openRotatingFile(path string, maxBytes int64, backups int) (*rotatingFile, error) {
+	rf := &rotatingFile{path: path, maxBytes: maxBytes, backups: backups}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *rotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(rf.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.f, rf.size = f, fi.Size()
+	return nil
+}
+
+func (rf *rotatingFile) rotate() error {
+	rf.f.Close()
+	os.Remove(fmt.Sprintf("%s.%d", rf.path, rf.backups))
+	for i := rf.backups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", rf.path, i), fmt.Sprintf("%s.%d", rf.path, i+1))
+	}
+	if rf.backups > 0 {
+		os.Rename(rf.path, rf.path+".1")
+	} else {
+		os.Remove(rf.path)
+	}
+	return rf.open()
+}
+
+func (rf *rotatingFile) write(rec AccessRecord) {
+	b, _ := json.Marshal(rec)
+	b = append(b, '\n')
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.f == nil {
+		return
+	}
+	if rf.maxBytes > 0 && rf.size > 0 && rf.size+int64(len(b)) > rf.maxBytes {
+		if err := rf.rotate(); err != nil {
+			fmt.Println("Access log rotation failed:", err)
+			rf.f = nil
+			return
+		}
+	}
+	n, err := rf.f.Write(b)
+	rf.size += int64(n)
+	if err != nil {
+		fmt.Println("Access log write failed:", err)
+	}
+}
+
+func (rf *rotatingFile) close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.f == nil {
+		return nil
+	}
+	err := rf.f.Close()
+	rf.f = nil
+	return err
+}
+
+// httpSink POSTs records to a collector in batches, at least once a
+// second. Records that do not fit in the queue are dropped and counted,
+// rather than slowing down requests.
+type httpSink struct {
+	url     string
+	queue   chan AccessRecord
+	dropped atomic.Int64
+	done    chan struct{}
+}
+
+const (
+	httpSinkQueue = 4096
+	httpSinkBatch = 500
+)
+
+func newHTTPSink(u string) *httpSink {
+	s := &httpSink{url: u, queue: make(chan AccessRecord, httpSinkQueue), done: make(chan struct{})}
+	go s.run()
+	return s
+}
+
+func (s *httpSink) write(rec AccessRecord) {
+	select {
+	case s.queue <- rec:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+func (s *httpSink) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	var batch []AccessRecord
+	for {
+		select {
+		case rec, ok := <-s.queue:
+			if !ok {
+				s.send(batch)
+				return
+			}
+			if batch = append(batch, rec); len(batch) >= httpSinkBatch {
+				s.send(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			s.send(batch)
+			batch = nil
+		}
+	}
+}
+
+func (s *httpSink) send(batch []AccessRecord) {
+	if n := s.dropped.Swap(0); n > 0 {
+		fmt.Println("Access log: dropped", n, "record(s), the collector is not keeping up")
+	}
+	if len(batch) == 0 {
+		return
+	}
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, rec := range batch {
+		enc.Encode(rec)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, &body)
+	if err != nil {
+		fmt.Println("Access log:", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		fmt.Println("Access log: cannot reach collector:", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		fmt.Println("Access log: collector answered", resp.Status)
+	}
+}
+
+// close sends what is queued and stops.
+func (s *httpSink) close() error {
+	close(s.queue)
+	<-s.done
+	return nil
+}
+
+// ---------------------------
+// Access Logging Middleware
+// ---------------------------
+
+type accessNoteKey struct{}
+
+// accessNote carries the files a handler names for the access log when
+// they are not in the URL (uploads); nil-safe like requestTrace.
+type accessNote struct {
+	mu    sync.Mutex
+	files []accessFile
+}
+
+type accessFile struct {
+	name string
+	size int64 // bytes of the request that were this file
+}
+
+func accessNoteFrom(ctx context.Context) *accessNote {
+	n, _ := ctx.Value(accessNoteKey{}).(*accessNote)
+	return n
+}
+
+// file names a file the request carried, with its size.
+func (n *accessNote) file(name string, size int64) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.files = append(n.files, accessFile{name, size})
+}
+
+// logAccess wraps the API handlers with the access log. It sits inside
+// the mux's caller so that after the handler has run, the request it was
+// given carries the matched route and path values.
+func logAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		var read atomic.Int64
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = &countingReadCloser{countingReader{r: r.Body, n: &read}, r.Body}
+		}
+		tw := &traceWriter{ResponseWriter: w}
+		note := &accessNote{}
+		outer := r
+		r = r.WithContext(context.WithValue(r.Context(), accessNoteKey{}, note))
+
+		next.ServeHTTP(tw, r)
+		outer.Pattern = r.Pattern // for logSlowRequests, as the mux would have
+
+		files := note.files
+		if len(files) == 0 {
+			name := accessedFile(r)
+			if name == "" {
+				return
+			}
+			files = []accessFile{{name: name, size: read.Load()}}
+		}
+		status := tw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		rec := AccessRecord{
+			Time:      start.UTC(),
+			Method:    r.Method,
+			Route:     r.Pattern,
+			User:      requestUser(r),
+			IP:        getClientIP(r),
+			Status:    status,
+			BytesOut:  tw.written,
+			LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+			RequestID: w.Header().Get(apierr.RequestIDHeader),
+		}
+		if imp, _, ok := impersonating(r); ok {
+			rec.ImpersonatedBy = imp.Admin
+		}
+		if client, err := locateClient(r); err == nil {
+			rec.Region = clientRegion(client)
+		} else {
+			rec.Region = "unknown"
+		}
+		for i, f := range files {
+			rec.File, rec.BytesIn = f.name, f.size
+			if i > 0 {
+				rec.BytesOut = 0 // the response is counted once
+			}
+			accessStats.add(rec)
+			if accessLog != nil {
+				accessLog.write(rec)
+			}
+		}
+	})
+}
+
+// accessedFile is the file a request is about, from its route or query,
+// or "" for requests that are not about one file.
+func accessedFile(r *http.Request) string {
+	for _, key := range []string{"filename", "name", "video"} {
+		if v := r.PathValue(key); v != "" {
+			return v
+		}
+	}
+	if r.Pattern == "/files/" {
+		if name := path.Base(r.URL.Path); name != "files" && name != "/" {
+			return name
+		}
+	}
+	return r.URL.Query().Get("filename")
+}
+
+// requestUser is who made the request, as far as the central API knows:
+// the user an impersonation token acts as, the user its session is signed
+// in as, or the name a client sent with basic auth.
+func requestUser(r *http.Request) string {
+	if imp, _, ok := impersonating(r); ok {
+		return imp.User
+	}
+	if u := sessionUser(r); u != "" {
+		return u
+	}
+	if u, _, ok := r.BasicAuth(); ok {
+		return u
+	}
+	return ""
+}
+
+// ---------------------------
+// Access Aggregates
+// ---------------------------
+
+// AccessAggregate sums the access records of one group; the grouping
+// fields left out of the query are empty.
+type AccessAggregate struct {
+	Day          string  `json:"day,omitempty"` // UTC, 2006-01-02
+	File         string  `json:"file,omitempty"`
+	Region       string  `json:"region,omitempty"`
+	Requests     int64   `json:"requests"`
+	Errors       int64   `json:"errors"` // status 400 and up
+	BytesIn      int64   `json:"bytes_in"`
+	BytesOut     int64   `json:"bytes_out"`
+	AvgLatencyMs float64 `json:"avg_latency_ms"`
+}
+
+type accessKey struct{ Day, File, Region string }
+
+type accessCounters struct {
+	Requests  int64   `json:"requests"`
+	Errors    int64   `json:"errors"`
+	BytesIn   int64   `json:"bytes_in"`
+	BytesOut  int64   `json:"bytes_out"`
+	LatencyMs float64 `json:"latency_ms"` // sum
+}
+
+// accessAggregates keeps the day/file/region sums for accessRetentionDays,
+// saved with the download counts.
+type accessAggregates struct {
+	mu    sync.Mutex
+	path  string
+	sums  map[accessKey]*accessCounters
+	dirty bool
+}
+
+// savedAccessSum is one entry of the saved aggregates.
+type savedAccessSum struct {
+	accessKey
+	accessCounters
+}
+
+var accessStats = &accessAggregates{sums: map[accessKey]*accessCounters{}}
+
+// accessRetentionDays is how many days of aggregates are kept.
+const accessRetentionDays = 90
+
+func (a *accessAggregates) add(rec AccessRecord) {
+	key := accessKey{rec.Time.Format(time.DateOnly), rec.File, rec.Region}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	c := a.sums[key]
+	if c == nil {
+		c = &accessCounters{}
+		a.sums[key] = c
+	}
+	c.Requests++
+	if rec.Status >= 400 {
+		c.Errors++
+	}
+	c.BytesIn += rec.BytesIn
+	c.BytesOut += rec.BytesOut
+	c.LatencyMs += rec.LatencyMs
+	a.dirty = true
+}
+
+func (a *accessAggregates) load(path string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.path = path
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var saved []savedAccessSum
+	if err := json.Unmarshal(raw, &saved); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for _, s := range saved {
+		c := s.accessCounters
+		a.sums[s.accessKey] = &c
+	}
+	return nil
+}
+
+// save drops days past the retention and writes the rest if anything
+// changed since the last save.
+func (a *accessAggregates) save() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	oldest := time.Now().UTC().AddDate(0, 0, -accessRetentionDays).Format(time.DateOnly)
+	for key := range a.sums {
+		if key.Day < oldest {
+			delete(a.sums, key)
+			a.dirty = true
+		}
+	}
+	if a.path == "" || !a.dirty {
+		return nil
+	}
+	saved := make([]savedAccessSum, 0, len(a.sums))
+	for key, c := range a.sums {
+		saved = append(saved, savedAccessSum{key, *c})
+	}
+	raw, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(a.path), 0755); err != nil {
+		return err
+	}
+	tmp := a.path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, a.path); err != nil {
+		return err
+	}
+	a.dirty = false
+	return nil
+}
+
+// accessQuery selects and groups aggregates.
+type accessQuery struct {
+	byDay, byFile, byRegion bool
+	from, to                string // days, inclusive; "" = open
+	file, region            string // "" = all
+}
+
+func (a *accessAggregates) query(q accessQuery) []AccessAggregate {
+	groups := map[accessKey]*AccessAggregate{}
+	a.mu.Lock()
+	for key, c := range a.sums {
+		if (q.from != "" && key.Day < q.from) || (q.to != "" && key.Day > q.to) ||
+			(q.file != "" && key.File != q.file) || (q.region != "" && key.Region != q.region) {
+			continue
+		}
+		var g accessKey
+		if q.byDay {
+			g.Day = key.Day
+		}
+		if q.byFile {
+			g.File = key.File
+		}
+		if q.byRegion {
+			g.Region = key.Region
+		}
+		agg := groups[g]
+		if agg == nil {
+			agg = &AccessAggregate{Day: g.Day, File: g.File, Region: g.Region}
+			groups[g] = agg
+		}
+		agg.Requests += c.Requests
+		agg.Errors += c.Errors
+		agg.BytesIn += c.BytesIn
+		agg.BytesOut += c.BytesOut
+		agg.AvgLatencyMs += c.LatencyMs // summed here, averaged below
+	}
+	a.mu.Unlock()
+
+	out := make([]AccessAggregate, 0, len(groups))
+	for _, agg := range groups {
+		if agg.Requests > 0 {
+			agg.AvgLatencyMs /= float64(agg.Requests)
+		}
+		out = append(out, *agg)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Day != out[j].Day {
+			return out[i].Day < out[j].Day
+		}
+		if out[i].File != out[j].File {
+			return out[i].File < out[j].File
+		}
+		return out[i].Region < out[j].Region
+	})
+	return out
+}
+
+// accessAnalyticsHandler serves the aggregates:
+// GET /api/v1/analytics/access?by=day,file,region&from=2006-01-02&to=...
+// &file=...&region=.... by names the fields to group by (default day);
+// from and to bound the days, inclusive.
+func accessAnalyticsHandler(w http.ResponseWriter, r *http.Request) {
+	v := r.URL.Query()
+	q := accessQuery{file: v.Get("file"), region: v.Get("region")}
+	by := v.Get("by")
+	if by == "" {
+		by = "day"
+	}
+	for _, field := range strings.Split(by, ",") {
+		switch strings.TrimSpace(field) {
+		case "day":
+			q.byDay = true
+		case "file":
+			q.byFile = true
+		case "region":
+			q.byRegion = true
+		default:
+			apierr.Send(w, "by: unknown field "+strconv.Quote(field)+" (want day, file or region)", http.StatusBadRequest)
+			return
+		}
+	}
+	for _, bound := range []struct {
+		name string
+		dst  *string
+	}{{"from", &q.from}, {"to", &q.to}} {
+		if s := v.Get(bound.name); s != "" {
+			if _, err := time.Parse(time.DateOnly, s); err != nil {
+				apierr.Send(w, bound.name+" must be a date like 2006-01-02", http.StatusBadRequest)
+				return
+			}
+			*bound.dst = s
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(accessStats.query(q))
+}
@@ -0,0 +1,96 @@
+package central
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	rf, err := openRotatingFile(path, 200, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.close()
+	for i := 0; i < 10; i++ {
+		rf.write(AccessRecord{Time: time.Now(), File: "a.txt", Status: 200})
+	}
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		fi, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Size() == 0 || fi.Size() > 200 {
+			t.Errorf("%s is %d bytes", name, fi.Size())
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("kept more than 2 backups: %v", err)
+	}
+}
+
+func TestClusterAccessLog(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "access.log")
+	c := newTestCluster(t, 3, func(cfg *Config) {
+		cfg.AccessLog = logPath
+	})
+	if resp, _ := c.upload("a.txt", "hello world", nearLondon); resp.StatusCode != http.StatusOK {
+		t.Fatalf("upload: %d", resp.StatusCode)
+	}
+	c.get("/get/a.txt?"+nearLondon.Encode(), nil)
+	c.get("/get/a.txt?"+nearSingapore.Encode(), nil)
+	c.get("/get/missing.txt?"+nearSingapore.Encode(), nil)
+	c.get("/health", nil) // not about a file
+
+	f, err := os.Open(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var recs []AccessRecord
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var rec AccessRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			t.Fatalf("%v: %s", err, sc.Text())
+		}
+		recs = append(recs, rec)
+	}
+	if len(recs) != 4 {
+		t.Fatalf("logged %d records, want 4: %+v", len(recs), recs)
+	}
+	if up := recs[0]; up.File != "a.txt" || up.Method != http.MethodPost || up.BytesIn != 11 || up.Region != "london" {
+		t.Errorf("upload record = %+v", up)
+	}
+	if sg := recs[2]; sg.Region != "singapore" || sg.IP == "" || sg.Route == "" {
+		t.Errorf("download record = %+v", sg)
+	}
+
+	query := func(q string) []AccessAggregate {
+		t.Helper()
+		_, body := c.get("/api/v1/analytics/access"+q, nil)
+		var out []AccessAggregate
+		if err := json.Unmarshal([]byte(body), &out); err != nil {
+			t.Fatalf("%v: %s", err, body)
+		}
+		return out
+	}
+	if days := query(""); len(days) != 1 || days[0].Requests != 4 || days[0].Errors != 1 || days[0].File != "" {
+		t.Errorf("by day = %+v", days)
+	}
+	byFile := query("?by=file,region&region=singapore")
+	if len(byFile) != 2 || byFile[0].File != "a.txt" || byFile[1].File != "missing.txt" || byFile[1].Errors != 1 {
+		t.Errorf("singapore by file = %+v", byFile)
+	}
+	if none := query("?to=2000-01-01"); len(none) != 0 {
+		t.Errorf("before 2000 = %+v", none)
+	}
+	if resp, _ := c.get("/api/v1/analytics/access?by=user", nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("by=user: %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,75 @@
+package central
+
+import (
+	"crypto/subtle"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+	"sync"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/buildinfo"
+)
+
+// ---------------------------
+// Admin
+// ---------------------------
+var adminToken string
+
+// requireAdmin lets through requests bearing the admin token. Without a
+// configured token every request is refused: admin endpoints are opt-in.
+func requireAdmin(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if adminToken == "" || subtle.ConstantTimeCompare([]byte(got), []byte(adminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="dsfs admin"`)
+			apierr.Send(w, "Admin token required", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// ---------------------------
+// Debug Server
+// ---------------------------
+
+// debugHandler serves the runtime's profiles and counters:
+//
+//	go tool pprof -http=: -H "Authorization: Bearer $ADMIN_TOKEN" http://127.0.0.1:6060/debug/pprof/heap
+//	curl -H "Authorization: Bearer $ADMIN_TOKEN" http://127.0.0.1:6060/debug/vars
+//
+// It is served on its own address (Config.DebugAddr) so it can stay off
+// the public interface; profiling briefly slows the process down.
+func debugHandler() http.Handler {
+	publishVars()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	return requireAdmin(mux)
+}
+
+var publishOnce sync.Once
+
+// publishVars adds the central API's own counters to /debug/vars, next to
+// the runtime's memstats and cmdline.
+func publishVars() {
+	publishOnce.Do(func() {
+		expvar.Publish("build", expvar.Func(func() any { return buildinfo.Get() }))
+		expvar.Publish("replication", expvar.Func(func() any { return replicationBacklog() }))
+		expvar.Publish("nodes", expvar.Func(func() any { return len(topo.nodes()) }))
+	})
+}
+
+func serveDebug(addr string) {
+	fmt.Println("Debug endpoints listening on", addr)
+	if err := http.ListenAndServe(addr, debugHandler()); err != nil {
+		fmt.Println("Debug server stopped:", err)
+	}
+}
@@ -0,0 +1,94 @@
+package central
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDebugHandlerNeedsAdminToken(t *testing.T) {
+	defer func(old string) { adminToken = old }(adminToken)
+	adminToken = "s3cret"
+	ts := httptest.NewServer(debugHandler())
+	defer ts.Close()
+
+	get := func(path, token string) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest("GET", ts.URL+path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(b)
+	}
+
+	for _, token := range []string{"", "wrong"} {
+		if status, _ := get("/debug/pprof/", token); status != http.StatusUnauthorized {
+			t.Errorf("token %q: status %d, want 401", token, status)
+		}
+	}
+	if status, body := get("/debug/pprof/heap?debug=1", "s3cret"); status != http.StatusOK || !strings.Contains(body, "heap profile") {
+		t.Errorf("heap profile: status %d", status)
+	}
+	if status, body := get("/debug/vars", "s3cret"); status != http.StatusOK || !strings.Contains(body, `"replication"`) {
+		t.Errorf("vars: status %d: %s", status, body)
+	}
+}
+
+func TestSeparateAdminListener(t *testing.T) {
+	c := newTestCluster(t, 1, func(cfg *Config) {
+		cfg.AdminToken = "s3cret"
+		cfg.Listen = []string{"127.0.0.1:8000", "[::1]:8000"}
+		cfg.AdminListen = []string{"127.0.0.1:8001"}
+	})
+	admin := httptest.NewServer(adminRoutes())
+	defer admin.Close()
+
+	get := func(base, path string) int {
+		t.Helper()
+		req, _ := http.NewRequest("GET", base+path, nil)
+		req.Header.Set("Authorization", "Bearer s3cret")
+		resp, err := testClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status := get(c.central.URL, "/api/v1/admin/quarantine"); status != http.StatusNotFound {
+		t.Errorf("admin endpoint on the API listener: status %d", status)
+	}
+	if status := get(admin.URL, "/api/v1/admin/quarantine"); status != http.StatusOK {
+		t.Errorf("admin endpoint on the admin listener: status %d", status)
+	}
+	if status := get(admin.URL, "/readyz"); status != http.StatusOK {
+		t.Errorf("readyz on the admin listener: status %d", status)
+	}
+	if status := get(admin.URL, "/files"); status != http.StatusNotFound {
+		t.Errorf("file list on the admin listener: status %d", status)
+	}
+
+	cfg := defaultConfig()
+	cfg.AdminToken = "s3cret"
+	cfg.Listen = []string{"[::1]:8000", "::1:8001"}
+	cfg.AdminListen = []string{"[::1]:8000"}
+	cfg.DebugAddr = "127.0.0.1:8000"
+	errs := cfg.validate()
+	var msgs []string
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	got := strings.Join(msgs, "\n")
+	for _, want := range []string{`"::1:8001"`, "[::1]:8000 is given twice", "debug_addr must not use an API port"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("validate is missing %q:\n%s", want, got)
+		}
+	}
+}
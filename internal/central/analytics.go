@@ -0,0 +1,240 @@
+package central
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+)
+
+// ---------------------------
+// Download Analytics
+// ---------------------------
+
+// Downloads are counted per file and per client region, the region of the
+// node nearest the client, so the counts say where each file is wanted
+// whichever replica ended up serving it. A download is a GET of /get/ or
+// /download/ from the start of the file; range requests that resume one
+// part way through are not counted again.
+
+// DownloadCount is how often a file was downloaded, in total and by region.
+type DownloadCount struct {
+	Total    int64            `json:"total"`
+	ByRegion map[string]int64 `json:"by_region"`
+	Last     time.Time        `json:"last"`
+}
+
+// FileDownloads is a file's DownloadCount, in analytics listings.
+type FileDownloads struct {
+	Name string `json:"name"`
+	DownloadCount
+}
+
+type downloadAnalytics struct {
+	mu    sync.Mutex
+	path  string
+	files map[string]*DownloadCount
+	dirty bool // changed since the last save
+}
+
+var downloads = &downloadAnalytics{files: map[string]*DownloadCount{}}
+
+// countersSaveInterval is how often the download counts and access
+// aggregates are written to disk; a crash loses at most that much.
+const countersSaveInterval = time.Minute
+
+func (a *downloadAnalytics) load(path string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.path = path
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, &a.files)
+}
+
+// save writes the counts if they changed since the last save.
+func (a *downloadAnalytics) save() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.path == "" || !a.dirty {
+		return nil
+	}
+	raw, err := json.Marshal(a.files)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(a.path), 0755); err != nil {
+		return err
+	}
+	tmp := a.path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, a.path); err != nil {
+		return err
+	}
+	a.dirty = false
+	return nil
+}
+
+func (a *downloadAnalytics) record(name, region string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	c := a.files[name]
+	if c == nil {
+		c = &DownloadCount{ByRegion: map[string]int64{}}
+		a.files[name] = c
+	}
+	c.Total++
+	c.ByRegion[region]++
+	c.Last = time.Now().UTC()
+	a.dirty = true
+}
+
+// totals returns every file's total download count.
+func (a *downloadAnalytics) totals() map[string]int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	out := make(map[string]int64, len(a.files))
+	for name, c := range a.files {
+		out[name] = c.Total
+	}
+	return out
+}
+
+// get returns a copy of name's counts.
+func (a *downloadAnalytics) get(name string) (DownloadCount, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	c := a.files[name]
+	if c == nil {
+		return DownloadCount{}, false
+	}
+	return copyCount(c), true
+}
+
+// forget drops a deleted file's counts.
+func (a *downloadAnalytics) forget(name string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.files[name]; ok {
+		delete(a.files, name)
+		a.dirty = true
+	}
+}
+
+// top returns the limit most downloaded files, counting only downloads
+// from region unless it is empty.
+func (a *downloadAnalytics) top(limit int, region string) []FileDownloads {
+	a.mu.Lock()
+	out := []FileDownloads{}
+	for name, c := range a.files {
+		if region != "" && c.ByRegion[region] == 0 {
+			continue
+		}
+		out = append(out, FileDownloads{Name: name, DownloadCount: copyCount(c)})
+	}
+	a.mu.Unlock()
+
+	count := func(f FileDownloads) int64 {
+		if region != "" {
+			return f.ByRegion[region]
+		}
+		return f.Total
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if ci, cj := count(out[i]), count(out[j]); ci != cj {
+			return ci > cj
+		}
+		return out[i].Name < out[j].Name
+	})
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
+func copyCount(c *DownloadCount) DownloadCount {
+	cp := *c
+	cp.ByRegion = make(map[string]int64, len(c.ByRegion))
+	for r, n := range c.ByRegion {
+		cp.ByRegion[r] = n
+	}
+	return cp
+}
+
+// clientRegion is the region of the node nearest the client.
+func clientRegion(c clientRef) string {
+	ranked := rankStorages(c)
+	if len(ranked) == 0 {
+		return "unknown"
+	}
+	return nodeRegion(ranked[0].StorageServer)
+}
+
+// nodeRegion is the region a node reported, or its label for nodes that
+// did not.
+func nodeRegion(s StorageServer) string {
+	if info, _ := identities.get(s.ID); info.Region != "" {
+		return info.Region
+	}
+	return s.Label
+}
+
+// countDownload counts r as a download of name, unless it resumes one.
+func countDownload(r *http.Request, name string, client clientRef) {
+	if r.Method != http.MethodGet {
+		return
+	}
+	if rng := r.Header.Get("Range"); rng != "" && !strings.HasPrefix(rng, "bytes=0-") {
+		return
+	}
+	downloads.record(name, clientRegion(client))
+}
+
+// saveCountersLoop writes the download counts and access aggregates to
+// disk every interval.
+func saveCountersLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := downloads.save(); err != nil {
+			fmt.Println("Cannot save download counts:", err)
+		}
+		if err := accessStats.save(); err != nil {
+			fmt.Println("Cannot save access aggregates:", err)
+		}
+	}
+}
+
+// analyticsHandler lists the most downloaded files:
+// GET /api/v1/analytics/downloads, with ?region= to rank by the downloads
+// from one region and ?limit= (default 20).
+func analyticsHandler(w http.ResponseWriter, r *http.Request) {
+	limit := 20
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, _ := strconv.Atoi(v) // a number, by requestRules
+		if n > 1000 {
+			apierr.Send(w, "limit must be 1-1000", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(downloads.top(limit, r.URL.Query().Get("region")))
+}
@@ -0,0 +1,63 @@
+package central
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestClusterDownloadAnalytics(t *testing.T) {
+	c := newTestCluster(t, 3, nil)
+	for _, name := range []string{"hot.txt", "cold.txt"} {
+		if resp, _ := c.upload(name, "contents of "+name, nearLondon); resp.StatusCode != http.StatusOK {
+			t.Fatalf("upload %s: %d", name, resp.StatusCode)
+		}
+	}
+
+	c.get("/get/hot.txt?"+nearLondon.Encode(), nil)
+	c.get("/download/hot.txt?"+nearLondon.Encode(), nil)
+	c.get("/get/hot.txt?"+nearSingapore.Encode(), nil)
+	c.get("/get/cold.txt?"+nearSingapore.Encode(), nil)
+	// Resuming a download is not another download.
+	c.get("/download/hot.txt?"+nearLondon.Encode(), http.Header{"Range": {"bytes=5-"}})
+
+	top := func(query string) []FileDownloads {
+		t.Helper()
+		_, body := c.get("/api/v1/analytics/downloads"+query, nil)
+		var out []FileDownloads
+		if err := json.Unmarshal([]byte(body), &out); err != nil {
+			t.Fatalf("analytics: %v: %s", err, body)
+		}
+		return out
+	}
+	all := top("")
+	if len(all) != 2 || all[0].Name != "hot.txt" || all[0].Total != 3 ||
+		all[0].ByRegion["london"] != 2 || all[0].ByRegion["singapore"] != 1 {
+		t.Errorf("top = %+v", all)
+	}
+	sg := top("?region=singapore&limit=1")
+	if len(sg) != 1 || sg[0].Name != "cold.txt" { // tied with hot.txt, by name
+		t.Errorf("singapore top = %+v", sg)
+	}
+	if ldn := top("?region=london"); len(ldn) != 1 || ldn[0].Name != "hot.txt" {
+		t.Errorf("london top = %+v", ldn)
+	}
+
+	_, body := c.get("/api/v1/files/hot.txt", nil)
+	var st FileStat
+	json.Unmarshal([]byte(body), &st)
+	if st.Downloads == nil || st.Downloads.Total != 3 {
+		t.Errorf("file info downloads = %+v", st.Downloads)
+	}
+
+	if _, body := c.get("/cluster", nil); !strings.Contains(body, "Most Downloaded") || !strings.Contains(body, "hot.txt") {
+		t.Error("dashboard does not list the most downloaded files")
+	}
+
+	// A deleted file's counts go with it.
+	c.delete("hot.txt")
+	if all := top(""); len(all) != 1 || all[0].Name != "cold.txt" {
+		t.Errorf("after delete: %+v", all)
+	}
+}
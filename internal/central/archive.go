@@ -0,0 +1,351 @@
+package central
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+)
+
+// ---------------------------
+// Archives
+// ---------------------------
+
+// maxArchiveFiles bounds how many files one archive request may name.
+const maxArchiveFiles = 1000
+
+// archiveSource is where one file of an archive can be read from: the
+// nodes ?read= allows, nearest first, starting at the first found holding
+// it, then the central copy if allowed and present.
+type archiveSource struct {
+	name    string
+	nodes   []StorageServer
+	central bool
+}
+
+// archiveHandler streams a zip of the files named in ?files=a,b,c (or
+// repeated, as a form of checkboxes sends them), built
+// while it is sent: each file is fetched from its nearest replica (see
+// readPreference) and written as it arrives, so nothing is buffered. A
+// missing file fails the request before anything is sent; a file that
+// cannot be fetched once streaming has started is left out and listed in
+// ERRORS.txt at the end of the archive.
+func archiveHandler(w http.ResponseWriter, r *http.Request) {
+	names, err := parseArchiveFiles(strings.Join(r.URL.Query()["files"], ","))
+	if err != nil {
+		apierr.Send(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	client, err := locateClient(r)
+	if err != nil {
+		apierr.Send(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pref, err := parseReadPreference(r)
+	if err != nil {
+		apierr.Send(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for _, name := range names {
+		if refusePrivate(w, r, name) {
+			return
+		}
+	}
+	sources := resolveArchive(r.Context(), names, pref, client)
+	var missing []string
+	for _, src := range sources {
+		if len(src.nodes) == 0 && !src.central {
+			missing = append(missing, src.name)
+		}
+	}
+	if missing != nil {
+		apierr.Send(w, "Not found: "+strings.Join(missing, ", "), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="files.zip"`)
+	w.Header().Set("Cache-Control", "no-store")
+	zw := zip.NewWriter(w)
+	var failed []string
+	for _, src := range sources {
+		if err := writeArchiveEntry(r.Context(), zw, src); err != nil {
+			fmt.Println("Archive: skipping", src.name+":", err)
+			failed = append(failed, src.name+": "+err.Error())
+		}
+	}
+	if failed != nil {
+		if f, err := zw.Create("ERRORS.txt"); err == nil {
+			io.WriteString(f, "These files could not be fetched:\n"+strings.Join(failed, "\n")+"\n")
+		}
+	}
+	zw.Close()
+}
+
+// parseArchiveFiles splits ?files=, dropping empty names and repeats.
+func parseArchiveFiles(v string) ([]string, error) {
+	var names []string
+	seen := map[string]bool{}
+	for _, name := range strings.Split(v, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	switch {
+	case len(names) == 0:
+		return nil, fmt.Errorf("files required, e.g. ?files=a.txt,b.txt")
+	case len(names) > maxArchiveFiles:
+		return nil, fmt.Errorf("at most %d files per archive", maxArchiveFiles)
+	}
+	return names, nil
+}
+
+// resolveArchive finds a source for every file, in parallel.
+func resolveArchive(ctx context.Context, names []string, pref readPreference, client clientRef) []archiveSource {
+	candidates := pref.candidates(client)
+	sources := make([]archiveSource, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			src := archiveSource{name: name, central: pref.usesCentral() && hasCentralCopy(name)}
+			for j, n := range candidates {
+				if _, ok := headReplica(ctx, n.StorageServer, name); ok {
+					for _, rest := range candidates[j:] {
+						src.nodes = append(src.nodes, rest.StorageServer)
+					}
+					break
+				}
+			}
+			sources[i] = src
+		}(i, name)
+	}
+	wg.Wait()
+	return sources
+}
+
+// writeArchiveEntry copies one file into the archive from the first of its
+// sources that answers. Once the entry is started there is no going back:
+// an error part way through leaves the entry truncated.
+func writeArchiveEntry(ctx context.Context, zw *zip.Writer, src archiveSource) error {
+	file, err := openArchiveSource(ctx, src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	f, err := zw.CreateHeader(&zip.FileHeader{Name: src.name, Method: zip.Deflate, Modified: file.modified})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, file)
+	return err
+}
+
+// archiveFile is an archived file being read from where it was found.
+type archiveFile struct {
+	io.ReadCloser
+	size     int64 // -1 if the node did not say
+	modified time.Time
+}
+
+func openArchiveSource(ctx context.Context, src archiveSource) (archiveFile, error) {
+	if quarantined.covers(src.name) {
+		return archiveFile{}, errQuarantined
+	}
+	var lastErr error
+	for _, s := range src.nodes {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, nodeFileURL(s, src.name), nil)
+		if err != nil {
+			return archiveFile{}, err
+		}
+		resp, err := nodeClient.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			lastErr = &statusError{Status: resp.StatusCode}
+			continue
+		}
+		modified, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+		return archiveFile{resp.Body, resp.ContentLength, modified}, nil
+	}
+	if src.central {
+		f, err := os.Open(filepath.Join(uploadDir, src.name))
+		if err != nil {
+			return archiveFile{}, err
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return archiveFile{}, err
+		}
+		return archiveFile{f, fi.Size(), fi.ModTime()}, nil
+	}
+	return archiveFile{}, fmt.Errorf("no replica answered: %w", lastErr)
+}
+
+// ---------------------------
+// Export
+// ---------------------------
+
+// exportHandler streams a tar.gz of every file whose name starts with
+// ?prefix= (all files without one) for bulk export. The files are found
+// in the listings of the nodes ?read= allows and read from the nearest one
+// holding each, as they are written, so nothing is buffered; nodes that
+// could not be listed are named in X-Unreachable-Nodes. Files that cannot
+// be fetched are listed in ERRORS.txt at the end of the archive.
+func exportHandler(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+	client, err := locateClient(r)
+	if err != nil {
+		apierr.Send(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pref, err := parseReadPreference(r)
+	if err != nil {
+		apierr.Send(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sources, unreachable := listExport(r.Context(), prefix, pref, client)
+	readable := sources[:0]
+	for _, src := range sources {
+		if mayRead(r, src.name) {
+			readable = append(readable, src)
+		}
+	}
+	sources = readable
+	if unreachable != nil {
+		w.Header().Set("X-Unreachable-Nodes", strings.Join(unreachable, ","))
+	}
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", `attachment; filename="export.tar.gz"`)
+	w.Header().Set("Cache-Control", "no-store")
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	var failed []string
+	for _, src := range sources {
+		err := writeExportEntry(r.Context(), tw, src)
+		if errors.Is(err, errEntryTruncated) {
+			// The archive cannot be repaired from here: end it, and let
+			// the missing gzip trailer tell the client.
+			fmt.Println("Export aborted at", src.name+":", err)
+			return
+		}
+		if err != nil {
+			fmt.Println("Export: skipping", src.name+":", err)
+			failed = append(failed, src.name+": "+err.Error())
+		}
+	}
+	if failed != nil {
+		msg := "These files could not be fetched:\n" + strings.Join(failed, "\n") + "\n"
+		tw.WriteHeader(&tar.Header{Name: "ERRORS.txt", Mode: 0644, Size: int64(len(msg)), ModTime: time.Now()})
+		io.WriteString(tw, msg)
+	}
+	tw.Close()
+	gz.Close()
+}
+
+var errEntryTruncated = errors.New("entry truncated")
+
+// listExport finds the files to export and where they are, sorted by name.
+func listExport(ctx context.Context, prefix string, pref readPreference, client clientRef) ([]archiveSource, []string) {
+	candidates := pref.candidates(client)
+	holders := make([]map[string]bool, len(candidates))
+	var unreachable []string
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i, n := range candidates {
+		wg.Add(1)
+		go func(i int, s StorageServer) {
+			defer wg.Done()
+			list, err := fetchNodeFiles(ctx, s)
+			has := map[string]bool{}
+			for _, rf := range list {
+				if strings.HasPrefix(rf.Name, prefix) {
+					has[rf.Name] = true
+				}
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				unreachable = append(unreachable, s.ID)
+			}
+			holders[i] = has
+		}(i, n.StorageServer)
+	}
+	wg.Wait()
+	sort.Strings(unreachable)
+
+	byName := map[string]*archiveSource{}
+	add := func(name string) *archiveSource {
+		if byName[name] == nil {
+			byName[name] = &archiveSource{name: name}
+		}
+		return byName[name]
+	}
+	for i, n := range candidates {
+		for name := range holders[i] {
+			src := add(name)
+			src.nodes = append(src.nodes, n.StorageServer)
+		}
+	}
+	if pref.usesCentral() {
+		entries, _ := os.ReadDir(uploadDir)
+		for _, e := range entries {
+			// Dot files are uploads still streaming in (see payload.receive).
+			if e.Type().IsRegular() && !strings.HasPrefix(e.Name(), ".") && strings.HasPrefix(e.Name(), prefix) {
+				add(e.Name()).central = true
+			}
+		}
+	}
+
+	sources := make([]archiveSource, 0, len(byName))
+	for _, src := range byName {
+		sources = append(sources, *src)
+	}
+	sort.Slice(sources, func(i, j int) bool { return sources[i].name < sources[j].name })
+	return sources, unreachable
+}
+
+// writeExportEntry copies one file into the tar. A tar header carries the
+// size, so a source that does not say is passed over for the next one;
+// errEntryTruncated means the entry was started but not finished.
+func writeExportEntry(ctx context.Context, tw *tar.Writer, src archiveSource) error {
+	file, err := openArchiveSource(ctx, src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if file.size < 0 {
+		return errors.New("size unknown")
+	}
+	hdr := &tar.Header{Name: src.name, Mode: 0644, Size: file.size, ModTime: file.modified}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if n, err := io.Copy(tw, file); err != nil || n != file.size {
+		return fmt.Errorf("%w after %d of %d bytes: %v", errEntryTruncated, n, file.size, err)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package central
+
+import (
+	"embed"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	texttemplate "text/template"
+)
+
+// ---------------------------
+// Assets
+// ---------------------------
+
+// The templates and static files are built into the binary, so the central
+// API runs from any directory. Config.TemplateDir and Config.StaticDir name
+// optional directories whose files replace the built-in ones of the same
+// name, to customize pages (and notification emails, the *.txt templates)
+// without rebuilding.
+//
+//go:embed templates/*.html templates/*.txt static
+var embedded embed.FS
+
+// parseTemplates parses the built-in templates, then dir's *.html on top.
+func parseTemplates(dir string) (*template.Template, error) {
+	t, err := template.New("").Funcs(templateFuncs).ParseFS(embedded, "templates/*.html")
+	if err != nil || dir == "" {
+		return t, err
+	}
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.html")); len(files) > 0 {
+		return t.ParseFiles(files...)
+	}
+	return t, nil
+}
+
+// parseMailTemplates parses the built-in email templates, then dir's *.txt
+// on top.
+func parseMailTemplates(dir string) (*texttemplate.Template, error) {
+	t, err := texttemplate.New("").ParseFS(embedded, "templates/*.txt")
+	if err != nil || dir == "" {
+		return t, err
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.txt")); len(files) > 0 {
+		return t.ParseFiles(files...)
+	}
+	return t, nil
+}
+
+// overlayFS serves files from dir where it has them, from base otherwise.
+type overlayFS struct {
+	dir  string
+	base fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	if o.dir != "" {
+		if f, err := os.DirFS(o.dir).Open(name); err == nil {
+			return f, nil
+		}
+	}
+	return o.base.Open(name)
+}
+
+var staticDir string
+
+// staticHandler serves /static/.
+func staticHandler() http.Handler {
+	base, _ := fs.Sub(embedded, "static")
+	return http.StripPrefix("/static/", http.FileServer(http.FS(overlayFS{staticDir, base})))
+}
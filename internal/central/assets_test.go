@@ -0,0 +1,58 @@
+package central
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAssetOverrides(t *testing.T) {
+	templateDir, staticDir := t.TempDir(), t.TempDir()
+	os.WriteFile(filepath.Join(templateDir, "upload.html"), []byte("custom upload page"), 0644)
+	os.WriteFile(filepath.Join(staticDir, "geo.js"), []byte("// custom"), 0644)
+
+	c := newTestCluster(t, 1, func(cfg *Config) {
+		cfg.TemplateDir = templateDir
+		cfg.StaticDir = staticDir
+	})
+	if _, body := c.get("/", nil); body != "custom upload page" {
+		t.Errorf("home page = %q, want the override", body)
+	}
+	// Templates not overridden are the built-in ones.
+	if _, body := c.get("/cluster", nil); !strings.Contains(body, "<html") {
+		t.Errorf("cluster page = %q, want the built-in template", body)
+	}
+	if _, body := c.get("/static/geo.js", nil); body != "// custom" {
+		t.Errorf("geo.js = %q, want the override", body)
+	}
+
+	c = newTestCluster(t, 1, nil)
+	if resp, body := c.get("/static/geo.js", nil); resp.StatusCode != http.StatusOK || !strings.Contains(body, "geolocation") {
+		t.Errorf("built-in geo.js: status %d", resp.StatusCode)
+	}
+}
+
+func TestBranding(t *testing.T) {
+	c := newTestCluster(t, 1, func(cfg *Config) {
+		cfg.Branding.Title = "Acme Files"
+		cfg.Branding.LogoURL = "https://acme.example/logo.png"
+		cfg.Branding.PrimaryColor = "#aa0000"
+		cfg.Branding.FooterText = "Internal use only"
+	})
+	for _, path := range []string{"/", "/files", "/cluster"} {
+		_, body := c.get(path, nil)
+		for _, want := range []string{"Acme Files", "https://acme.example/logo.png", "--brand-primary: #aa0000", "Internal use only", "/static/theme.css"} {
+			if !strings.Contains(body, want) {
+				t.Errorf("%s does not contain %q", path, want)
+			}
+		}
+	}
+
+	cfg := defaultConfig()
+	cfg.Branding.AccentColor = "red; background: url(x)"
+	if errs := cfg.validate(); len(errs) != 1 {
+		t.Errorf("accent color with a declaration in it: %v, want 1 error", errs)
+	}
+}
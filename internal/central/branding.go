@@ -0,0 +1,48 @@
+package central
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// ---------------------------
+// Branding
+// ---------------------------
+
+// Branding is what the pages show of the deployment: every page has the
+// title and logo in its header, the footer text at the bottom, and the
+// colors for its links and buttons. Beyond that, templates and
+// /static/theme.css can be overridden (see Config.TemplateDir).
+type Branding struct {
+	Title        string `json:"title"`
+	LogoURL      string `json:"logo_url"`
+	PrimaryColor string `json:"primary_color"` // links and buttons
+	AccentColor  string `json:"accent_color"`  // buttons under the pointer
+	FooterText   string `json:"footer_text"`
+}
+
+var defaultBranding = Branding{
+	Title:        "Distributed File Storage",
+	PrimaryColor: "#007bff",
+	AccentColor:  "#0056b3",
+}
+
+var branding = defaultBranding
+
+// cssColor is a hex color or a color name; nothing that could end the
+// declaration it is put in.
+var cssColor = regexp.MustCompile(`^(#[0-9a-fA-F]{3,8}|[a-zA-Z]+)$`)
+
+func (b Branding) validate() []error {
+	var errs []error
+	for key, c := range map[string]string{"primary_color": b.PrimaryColor, "accent_color": b.AccentColor} {
+		if !cssColor.MatchString(c) {
+			errs = append(errs, fmt.Errorf("branding.%s %q is not a CSS color (#rrggbb or a name)", key, c))
+		}
+	}
+	return errs
+}
+
+func currentBranding() Branding {
+	return branding
+}
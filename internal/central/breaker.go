@@ -0,0 +1,174 @@
+package central
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ---------------------------
+// Circuit Breakers
+// ---------------------------
+
+// Each node has a circuit breaker. After breakerThreshold failed calls in a
+// row (timeouts, connection errors, 5xx) it opens and calls to the node fail
+// at once instead of each paying the full timeout. After breakerCooldown, or
+// as soon as a health probe gets through, it goes half-open and lets a
+// single call through as a trial: success closes it, failure opens it again.
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+var errCircuitOpen = errors.New("circuit open, node skipped")
+
+// BreakerStatus is a node's breaker as reported by the nodes API.
+type BreakerStatus struct {
+	State    string     `json:"state"`
+	Failures int        `json:"consecutive_failures"`
+	OpenedAt *time.Time `json:"opened_at,omitempty"`
+}
+
+type breaker struct {
+	state    string
+	failures int
+	openedAt time.Time
+	trial    bool // a half-open trial call is in flight
+}
+
+type breakerSet struct {
+	mu        sync.Mutex
+	nodes     map[string]*breaker
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+}
+
+var breakers = newBreakerSet(5, 30*time.Second)
+
+func newBreakerSet(threshold int, cooldown time.Duration) *breakerSet {
+	return &breakerSet{nodes: map[string]*breaker{}, threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+func (bs *breakerSet) getLocked(nodeID string) *breaker {
+	b, ok := bs.nodes[nodeID]
+	if !ok {
+		b = &breaker{state: breakerClosed}
+		bs.nodes[nodeID] = b
+	}
+	return b
+}
+
+// allow reports whether a call to the node may go ahead.
+func (bs *breakerSet) allow(nodeID string) bool {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	b := bs.getLocked(nodeID)
+	if b.state == breakerOpen && bs.now().Sub(b.openedAt) >= bs.cooldown {
+		b.state = breakerHalfOpen
+	}
+	switch b.state {
+	case breakerOpen:
+		return false
+	case breakerHalfOpen:
+		if b.trial {
+			return false
+		}
+		b.trial = true
+	}
+	return true
+}
+
+// report records the outcome of a call that allow let through.
+func (bs *breakerSet) report(nodeID string, err error) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	b := bs.getLocked(nodeID)
+	b.trial = false
+	if err == nil {
+		if b.state != breakerClosed {
+			fmt.Println("Circuit for node", nodeID, "closed")
+		}
+		b.state, b.failures = breakerClosed, 0
+		return
+	}
+	b.failures++
+	if b.state == breakerHalfOpen || (b.state == breakerClosed && b.failures >= bs.threshold) {
+		fmt.Println("Circuit for node", nodeID, "opened after", b.failures, "failures:", err)
+		b.state, b.openedAt = breakerOpen, bs.now()
+	}
+}
+
+// release ends a call that says nothing about the node (the caller gave
+// up), so a half-open breaker can let the next trial through.
+func (bs *breakerSet) release(nodeID string) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	bs.getLocked(nodeID).trial = false
+}
+
+// probed is called when a health probe reaches the node: an open breaker
+// goes half-open right away rather than waiting out the cooldown.
+func (bs *breakerSet) probed(nodeID string) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	if b, ok := bs.nodes[nodeID]; ok && b.state == breakerOpen {
+		b.state = breakerHalfOpen
+	}
+}
+
+func (bs *breakerSet) get(nodeID string) BreakerStatus {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	b, ok := bs.nodes[nodeID]
+	if !ok {
+		return BreakerStatus{State: breakerClosed}
+	}
+	st := BreakerStatus{State: b.state, Failures: b.failures}
+	if b.state != breakerClosed {
+		opened := b.openedAt.UTC()
+		st.OpenedAt = &opened
+	}
+	return st
+}
+
+// callNode makes one logical call of kind op to a node: through its circuit
+// breaker, retried per the retry policy, with every attempt counted in the
+// node's stats.
+func callNode(ctx context.Context, s StorageServer, op string, call func(ctx context.Context) error) error {
+	return callNodeWith(retries, ctx, s, op, call)
+}
+
+func callNodeWith(p retryPolicy, ctx context.Context, s StorageServer, op string, call func(ctx context.Context) error) error {
+	return p.do(ctx, func(attemptCtx context.Context) error {
+		return attemptNode(ctx, attemptCtx, s, op, call)
+	})
+}
+
+// attemptNode makes a single attempt of a call under attemptCtx, a context
+// derived from the caller's ctx.
+func attemptNode(ctx, attemptCtx context.Context, s StorageServer, op string, call func(ctx context.Context) error) error {
+	if !breakers.allow(s.ID) {
+		return fmt.Errorf("%s: %w", s.ID, errCircuitOpen)
+	}
+	start := time.Now()
+	err := call(attemptCtx)
+	nodeStats.record(s.ID, op, time.Since(start), err)
+	switch {
+	case ctx.Err() != nil:
+		breakers.release(s.ID)
+	case err != nil && !retryable(err):
+		// The node answered, just not with what we wanted.
+		breakers.report(s.ID, nil)
+	default:
+		breakers.report(s.ID, err)
+	}
+	return err
+}
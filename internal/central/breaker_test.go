@@ -0,0 +1,85 @@
+package central
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBreakerStates(t *testing.T) {
+	now := time.Now()
+	bs := newBreakerSet(3, time.Minute)
+	bs.now = func() time.Time { return now }
+	boom := errors.New("timeout")
+
+	for i := 0; i < 3; i++ {
+		if !bs.allow("n1") {
+			t.Fatalf("call %d refused while closed", i+1)
+		}
+		bs.report("n1", boom)
+	}
+	if st := bs.get("n1"); st.State != breakerOpen || st.Failures != 3 {
+		t.Fatalf("after 3 failures: %+v, want open", st)
+	}
+	if bs.allow("n1") {
+		t.Error("open breaker let a call through")
+	}
+
+	// After the cooldown exactly one trial goes through; its failure opens
+	// the breaker again.
+	now = now.Add(time.Minute)
+	if !bs.allow("n1") || bs.allow("n1") {
+		t.Fatal("half-open breaker must let exactly one trial through")
+	}
+	bs.report("n1", boom)
+	if bs.allow("n1") {
+		t.Error("failed trial did not reopen the breaker")
+	}
+
+	// A successful probe skips the cooldown, and a successful trial closes.
+	bs.probed("n1")
+	if !bs.allow("n1") {
+		t.Fatal("probed breaker refused the trial")
+	}
+	bs.report("n1", nil)
+	if st := bs.get("n1"); st.State != breakerClosed || st.Failures != 0 {
+		t.Errorf("after a good trial: %+v, want closed", st)
+	}
+
+	// A released trial (caller gave up) neither opens nor closes.
+	for i := 0; i < 3; i++ {
+		bs.report("n1", boom)
+	}
+	bs.probed("n1")
+	bs.allow("n1")
+	bs.release("n1")
+	if st := bs.get("n1"); st.State != breakerHalfOpen || !bs.allow("n1") {
+		t.Errorf("after a released trial: %+v, want half-open with the trial free", st)
+	}
+}
+
+func TestClusterBreakerFailsFast(t *testing.T) {
+	c := newTestCluster(t, 2, func(cfg *Config) {
+		cfg.NodeTimeout = Duration{50 * time.Millisecond}
+		cfg.RetryAttempts = 1
+		cfg.BreakerThreshold = 2
+	})
+	c.delay("ny", 500*time.Millisecond)
+
+	for i := 0; i < 2; i++ {
+		c.get("/files", http.Header{"Accept": {"application/json"}})
+	}
+	if st := breakers.get("ny"); st.State != breakerOpen {
+		t.Fatalf("ny breaker = %+v, want open after two timeouts", st)
+	}
+
+	resp, job := c.upload("doc.txt", "contents", nearLondon)
+	if resp.StatusCode != http.StatusOK || job.Replicas["sg"].Status != uploadDone {
+		t.Fatalf("upload: status %d, job %+v", resp.StatusCode, job)
+	}
+	if r := job.Replicas["ny"]; r.Status != uploadFailed || !strings.Contains(r.Error, errCircuitOpen.Error()) {
+		t.Errorf("ny replica = %+v, want skipped by the open circuit", r)
+	}
+}
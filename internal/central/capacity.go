@@ -0,0 +1,81 @@
+package central
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ---------------------------
+// Node Capacity
+// ---------------------------
+
+// A node that refuses an upload with 507 (out of disk space, or over its
+// quota) is taken out of placement until a health probe shows it has room
+// again, so later uploads go straight to nodes that can take them instead of
+// bouncing off it first.
+
+// QuotaStatus is a node's quota and usage, as reported in its /info.
+type QuotaStatus struct {
+	MaxBytes  int64 `json:"max_bytes,omitempty"`
+	MaxFiles  int64 `json:"max_files,omitempty"`
+	UsedBytes int64 `json:"used_bytes"`
+	UsedFiles int64 `json:"used_files"`
+	Full      bool  `json:"full"`
+}
+
+// FullStatus is why and since when a node is out of placement.
+type FullStatus struct {
+	Reason string    `json:"reason"`
+	Since  time.Time `json:"since"`
+}
+
+type capacityTracker struct {
+	mu   sync.Mutex
+	full map[string]FullStatus
+}
+
+var capacity = &capacityTracker{full: map[string]FullStatus{}}
+
+// markFull takes a node out of placement.
+func (c *capacityTracker) markFull(nodeID, reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.full[nodeID]; !ok {
+		fmt.Println("Node", nodeID, "is full, not placing new files on it:", reason)
+		c.full[nodeID] = FullStatus{Reason: reason, Since: time.Now().UTC()}
+	}
+}
+
+// observe updates a node's state from its /info: a node reporting its quota
+// as full is kept out, any other answer puts it back into placement.
+func (c *capacityTracker) observe(nodeID string, q *QuotaStatus) {
+	if q != nil && q.Full {
+		c.markFull(nodeID, fmt.Sprintf("quota exceeded: %d bytes in %d files stored", q.UsedBytes, q.UsedFiles))
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.full[nodeID]; ok {
+		fmt.Println("Node", nodeID, "has room again")
+		delete(c.full, nodeID)
+	}
+}
+
+func (c *capacityTracker) get(nodeID string) (FullStatus, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st, ok := c.full[nodeID]
+	return st, ok
+}
+
+// withRoom drops the nodes that are full from ranked.
+func withRoom(ranked []rankedNode) []rankedNode {
+	out := ranked[:0:0]
+	for _, r := range ranked {
+		if _, full := capacity.get(r.ID); !full {
+			out = append(out, r)
+		}
+	}
+	return out
+}
@@ -0,0 +1,165 @@
+package central
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/checksum"
+)
+
+// ---------------------------
+// Checksum Algorithms
+// ---------------------------
+
+// Files are checked by SHA-256 unless Config.ChecksumAlgorithm picks
+// BLAKE3 or xxHash64, faster on large files (see package checksum). The
+// choice is negotiated: it is used only once every node has said in /info
+// that it supports it, and until then new files get SHA-256. Each upload
+// tells its nodes which algorithm to hash it with (X-Checksum-Algorithm),
+// and probes set each node's own algorithm, for files it has no checksum
+// for, to the same.
+//
+// A checksum names its algorithm, and the one each file was stored with
+// is recorded here (DataDir/checksum-algorithms.json, SHA-256 files left
+// out), so changing the setting never makes old files look corrupt: the
+// central copy is hashed as its replicas were.
+
+type algorithmRegistry struct {
+	mu   sync.Mutex
+	path string
+	algs map[string]string // by file, for those not SHA-256
+}
+
+var fileAlgorithms = &algorithmRegistry{algs: map[string]string{}}
+
+func (reg *algorithmRegistry) load(path string) error {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	reg.path = path
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, &reg.algs)
+}
+
+func (reg *algorithmRegistry) saveLocked() error {
+	if reg.path == "" {
+		return nil
+	}
+	raw, err := json.MarshalIndent(reg.algs, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(reg.path), 0755); err != nil {
+		return err
+	}
+	tmp := reg.path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, reg.path)
+}
+
+// of returns the algorithm name was stored with.
+func (reg *algorithmRegistry) of(name string) string {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if alg, ok := reg.algs[name]; ok {
+		return alg
+	}
+	return checksum.SHA256
+}
+
+// set records the algorithm name was stored with.
+func (reg *algorithmRegistry) set(name, alg string) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if reg.algs[name] == alg || (alg == checksum.SHA256 && reg.algs[name] == "") {
+		return
+	}
+	if alg == checksum.SHA256 {
+		delete(reg.algs, name)
+	} else {
+		reg.algs[name] = alg
+	}
+	if err := reg.saveLocked(); err != nil {
+		fmt.Println("Cannot save checksum algorithms:", err)
+	}
+}
+
+func (reg *algorithmRegistry) forget(name string) {
+	reg.set(name, checksum.SHA256)
+}
+
+// negotiatedChecksum returns the algorithm new files are hashed with: the
+// configured one if every node supports it, SHA-256 otherwise. Nodes not
+// probed yet, and nodes from before there was a choice, support only
+// SHA-256.
+func negotiatedChecksum() string {
+	alg := currentPolicy().checksumAlgorithm
+	if alg == checksum.SHA256 {
+		return alg
+	}
+	for _, s := range topo.nodes() {
+		info, _ := identities.get(s.ID)
+		if !slices.Contains(info.ChecksumAlgorithms, alg) {
+			return checksum.SHA256
+		}
+	}
+	return alg
+}
+
+// checksumDrifted reports whether a node, by its /info, hashes files it
+// has no checksum for with another algorithm than the cluster's, and
+// could be told to switch.
+func checksumDrifted(info NodeInfo) (string, bool) {
+	want := negotiatedChecksum()
+	return want, len(info.ChecksumAlgorithms) > 0 && info.ChecksumAlgorithm != want
+}
+
+// pushChecksumAlgorithm sets a node's algorithm, authenticated like
+// registration.
+func pushChecksumAlgorithm(ctx context.Context, s StorageServer, alg string) error {
+	b, _ := json.Marshal(map[string]string{"algorithm": alg})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.URL+"/api/v1/checksum", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if registrationToken != "" {
+		req.Header.Set("Authorization", "Bearer "+registrationToken)
+	}
+	resp, err := probeClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return &statusError{Status: resp.StatusCode}
+	}
+	return nil
+}
+
+// fileChecksum returns the checksum of a file by alg, as the storage
+// nodes report it.
+func fileChecksum(path, alg string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return checksum.Of(alg, f)
+}
@@ -0,0 +1,53 @@
+package central
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/checksum"
+)
+
+func TestChecksumAlgorithmNegotiated(t *testing.T) {
+	c := newTestCluster(t, 3, func(cfg *Config) { cfg.ChecksumAlgorithm = checksum.BLAKE3 })
+
+	// Until every node has said it supports BLAKE3, files get SHA-256: one
+	// node reports as from before there was a choice.
+	old := c.node("ny")
+	info, _ := identities.get(old.ID)
+	info.ChecksumAlgorithm, info.ChecksumAlgorithms = "", nil
+	identities.observe(old.StorageServer, info)
+	c.upload("before.txt", "before", nearLondon)
+
+	// Its next probe shows it does and tells the nodes to hash with BLAKE3,
+	// which the probes after see.
+	probeNode(old.StorageServer)
+	for range 2 {
+		for _, n := range c.nodes {
+			probeNode(n.StorageServer)
+		}
+	}
+	c.upload("after.txt", "after", nearLondon)
+
+	files := c.listing()
+	if f := files["before.txt"]; f.Algorithm != checksum.SHA256 || strings.Contains(f.Checksum, ":") {
+		t.Errorf("before.txt = %s %s, want bare sha256", f.Algorithm, f.Checksum)
+	}
+	if f := files["after.txt"]; f.Algorithm != checksum.BLAKE3 || !strings.HasPrefix(f.Checksum, "blake3:") {
+		t.Errorf("after.txt = %s %s, want blake3", f.Algorithm, f.Checksum)
+	}
+	for _, n := range c.nodes {
+		if info, _ := identities.get(n.ID); info.ChecksumAlgorithm != checksum.BLAKE3 {
+			t.Errorf("%s hashes with %s, want blake3", n.ID, info.ChecksumAlgorithm)
+		}
+	}
+
+	// Both algorithms verify, and a corrupt BLAKE3 replica is caught.
+	if report := c.fsck(false); len(report.Issues) != 0 {
+		t.Errorf("fsck issues = %v", issueKeys(report))
+	}
+	holder := c.holders("after.txt")[0]
+	c.node(holder).backend.Put("after.txt", strings.NewReader("rotten"))
+	if got := issueKeys(c.fsck(false)); len(got) != 1 || got[0] != "after.txt/"+holder+":"+fsckChecksumMismatch {
+		t.Errorf("fsck issues = %v, want the %s replica", got, holder)
+	}
+}
@@ -0,0 +1,108 @@
+package central
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// ---------------------------
+// Node HTTP Client
+// ---------------------------
+
+// All calls to storage nodes share one transport, so connections to each
+// node are kept alive and reused instead of paying a TCP (and TLS) handshake
+// across regions for every upload, listing and probe. nodeClient has no
+// overall timeout: calls are bounded by their context (see deadline.go).
+// Nodes on this host that give a Unix socket are dialed through it.
+var (
+	nodeTransport = newNodeTransport(defaultConfig(), nil)
+	nodeClient    = &http.Client{Transport: nodeTransport}
+	probeClient   = &http.Client{Transport: nodeTransport, Timeout: 3 * time.Second}
+)
+
+func newNodeTransport(cfg Config, roots *x509.CertPool) *http.Transport {
+	dialer := &net.Dialer{Timeout: 5 * time.Second, KeepAlive: 30 * time.Second}
+	return &http.Transport{
+		Proxy: func(r *http.Request) (*url.URL, error) {
+			if nodeSocket(hostPort(r.URL)) != "" {
+				return nil, nil
+			}
+			return http.ProxyFromEnvironment(r)
+		},
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if path := nodeSocket(addr); path != "" {
+				return dialer.DialContext(ctx, "unix", path)
+			}
+			return dialer.DialContext(ctx, network, addr)
+		},
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          cfg.MaxIdleConnsPerNode * 8,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerNode,
+		IdleConnTimeout:       cfg.IdleConnTimeout.Duration,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ExpectContinueTimeout: time.Second,
+		TLSClientConfig: &tls.Config{
+			MinVersion:         tls.VersionTLS12,
+			RootCAs:            roots,
+			InsecureSkipVerify: cfg.NodeTLSInsecure,
+		},
+	}
+}
+
+// nodeSocket returns the Unix socket of the node whose URL is at addr
+// (host:port), or "" to dial addr over TCP.
+func nodeSocket(addr string) string {
+	for _, s := range topo.nodes() {
+		if s.Socket == "" {
+			continue
+		}
+		if u, err := url.Parse(s.URL); err == nil && hostPort(u) == addr {
+			return s.Socket
+		}
+	}
+	return ""
+}
+
+// hostPort is the host:port a request to u connects to.
+func hostPort(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// setupNodeClient rebuilds the shared transport from the config.
+func setupNodeClient(cfg Config) error {
+	var roots *x509.CertPool
+	if cfg.NodeCAFile != "" {
+		pem, err := os.ReadFile(cfg.NodeCAFile)
+		if err != nil {
+			return fmt.Errorf("node_ca_file: %w", err)
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("node_ca_file %s: no certificates found", cfg.NodeCAFile)
+		}
+	}
+	if cfg.NodeTLSInsecure {
+		fmt.Println("WARNING: node TLS certificates are not verified (node_tls_insecure)")
+	}
+
+	old := nodeTransport
+	nodeTransport = newNodeTransport(cfg, roots)
+	nodeClient = &http.Client{Transport: nodeTransport}
+	probeClient = &http.Client{Transport: nodeTransport, Timeout: 3 * time.Second}
+	old.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,138 @@
+package central
+
+import (
+	"context"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/listen"
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/storage"
+)
+
+func listServer(start func(*httptest.Server)) (*httptest.Server, *atomic.Int32) {
+	var conns atomic.Int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("[]"))
+	}))
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	start(srv)
+	return srv, &conns
+}
+
+func TestNodeClientReusesConnections(t *testing.T) {
+	if err := setupNodeClient(defaultConfig()); err != nil {
+		t.Fatal(err)
+	}
+	srv, conns := listServer((*httptest.Server).Start)
+	defer srv.Close()
+
+	for i := 0; i < 10; i++ {
+		if _, err := listNodeFiles(context.Background(), StorageServer{URL: srv.URL}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := conns.Load(); n != 1 {
+		t.Errorf("10 sequential calls opened %d connections, want 1", n)
+	}
+}
+
+func TestNodeClientCAFile(t *testing.T) {
+	srv, _ := listServer((*httptest.Server).StartTLS)
+	defer srv.Close()
+	node := StorageServer{URL: srv.URL}
+
+	if err := setupNodeClient(defaultConfig()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listNodeFiles(context.Background(), node); err == nil {
+		t.Fatal("self-signed node accepted without its CA")
+	}
+
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	block := &pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}
+	if err := os.WriteFile(ca, pem.EncodeToMemory(block), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := defaultConfig()
+	cfg.NodeCAFile = ca
+	if err := setupNodeClient(cfg); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { setupNodeClient(defaultConfig()) })
+	if _, err := listNodeFiles(context.Background(), node); err != nil {
+		t.Errorf("with node_ca_file: %v", err)
+	}
+
+	cfg.NodeCAFile = filepath.Join(t.TempDir(), "missing.pem")
+	if err := setupNodeClient(cfg); err == nil {
+		t.Error("missing CA file accepted")
+	}
+}
+
+func TestNodeOverUnixSocket(t *testing.T) {
+	c := newTestCluster(t, 1, func(cfg *Config) { cfg.ReplicationFactor = 2 })
+
+	// A node on the same host, reachable only through its socket: the
+	// host in its URL does not resolve.
+	dir := t.TempDir()
+	sock := filepath.Join(dir, "node.sock")
+	scfg := storage.DefaultConfig("0", "london")
+	scfg.IdentityPath = filepath.Join(dir, "node.json")
+	scfg.QuarantinePath = filepath.Join(dir, "quarantine.json")
+	scfg.PrivatePath = filepath.Join(dir, "private.json")
+	scfg.TrashPath = filepath.Join(dir, "trash.json")
+	scfg.LocksPath = filepath.Join(dir, "locks.json")
+	scfg.CentralURL = c.central.URL
+	scfg.AdvertiseURL = "http://ldn.invalid:9003"
+	scfg.AdvertiseSocket = sock
+	scfg.NodeName = "ldn"
+	scfg.RegistrationToken = testRegistrationToken
+	scfg.Lat, scfg.Lon = 51.5074, -0.1278
+	backend, err := storage.NewLocalBackend(filepath.Join(dir, "files"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := storage.NewServer(scfg, backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lns, err := listen.Open([]string{"unix:" + sock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: s.Handler()}
+	go listen.Serve(srv, lns)
+	t.Cleanup(func() { srv.Close() })
+
+	stop := make(chan struct{})
+	close(stop)
+	s.RegistrationLoop(stop) // registers once
+	if n, ok := topo.get("ldn"); !ok || n.Socket != sock {
+		t.Fatalf("registered as %+v", n)
+	}
+
+	if resp, _ := c.upload("a.txt", "over the socket", nearLondon); resp.StatusCode != http.StatusOK {
+		t.Fatalf("upload: %d", resp.StatusCode)
+	}
+	c.waitForJobs()
+	if _, err := backend.Stat("a.txt"); err != nil {
+		t.Fatalf("not on the socket node: %v", err)
+	}
+	if resp, body := c.get("/download/a.txt?"+nearLondon.Encode(), nil); resp.StatusCode != http.StatusOK || body != "over the socket" {
+		t.Errorf("download: %d %q", resp.StatusCode, body)
+	}
+
+	if errs := validateStorage(StorageServer{ID: "x", URL: "http://x", Socket: "node.sock"}); len(errs) != 1 {
+		t.Errorf("relative socket path: %v", errs)
+	}
+}
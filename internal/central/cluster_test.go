@@ -0,0 +1,846 @@
+package central
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"maps"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/buildinfo"
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/storage"
+)
+
+func TestClusterUploadReplicatesEverywhere(t *testing.T) {
+	c := newTestCluster(t, 3, nil)
+
+	resp, job := c.upload("report.txt", "quarterly numbers", nearLondon)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("upload: status %d", resp.StatusCode)
+	}
+	if job.Status != uploadDone || len(job.Replicas) != 3 {
+		t.Fatalf("job = %+v", job)
+	}
+	if got := c.holders("report.txt"); !slices.Equal(got, []string{"sg", "ny", "ldn"}) {
+		t.Errorf("holders = %v", got)
+	}
+
+	f, ok := c.listing()["report.txt"]
+	if !ok {
+		t.Fatal("report.txt missing from listing")
+	}
+	if !f.Consistent || len(f.Replica) != 3 || f.Size != int64(len("quarterly numbers")) {
+		t.Errorf("listing = %+v", f)
+	}
+}
+
+func TestClusterDelete(t *testing.T) {
+	c := newTestCluster(t, 3, nil)
+	c.upload("old.txt", "stale", nearLondon)
+
+	if resp := c.delete("old.txt"); resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("delete: status %d", resp.StatusCode)
+	}
+	if got := c.holders("old.txt"); len(got) != 0 {
+		t.Errorf("still held by %v", got)
+	}
+	if _, ok := c.listing()["old.txt"]; ok {
+		t.Error("old.txt still listed")
+	}
+}
+
+func TestClusterListSince(t *testing.T) {
+	c := newTestCluster(t, 3, nil)
+	c.upload("a.txt", "a", nearLondon)
+	resp, _ := c.get("/files", http.Header{"Accept": {"application/json"}})
+	seq := resp.Header.Get("X-Change-Seq")
+
+	c.upload("b.txt", "b", nearLondon)
+	c.delete("a.txt")
+
+	// No Accept header: incremental listings are always JSON.
+	resp, body := c.get("/files?since="+seq, nil)
+	var files []FileListing
+	if err := json.Unmarshal([]byte(body), &files); err != nil {
+		t.Fatalf("since listing: %v: %s", err, body)
+	}
+	if len(files) != 2 || files[0].Name != "b.txt" || !files[0].Replica["ny"] || files[1].Name != "a.txt" || !files[1].Deleted {
+		t.Errorf("since %s = %+v", seq, files)
+	}
+
+	resp, body = c.get("/files?since="+resp.Header.Get("X-Change-Seq"), nil)
+	if strings.TrimSpace(body) != "[]" {
+		t.Errorf("nothing changed: %s", body)
+	}
+	if resp, _ = c.get("/files?since=1", nil); resp.StatusCode != http.StatusGone {
+		t.Errorf("since before the log: status %d, want 410", resp.StatusCode)
+	}
+}
+
+func TestClusterGetRedirectsToNearestHealthyReplica(t *testing.T) {
+	c := newTestCluster(t, 3, nil)
+	c.upload("map.png", "pixels", nearLondon)
+
+	resp, _ := c.get("/get/map.png?"+nearNewYork.Encode(), nil)
+	if resp.StatusCode != http.StatusFound || resp.Header.Get("X-Storage-Node") != "ny" {
+		t.Fatalf("get near New York: status %d node %q", resp.StatusCode, resp.Header.Get("X-Storage-Node"))
+	}
+	if loc := resp.Header.Get("Location"); !strings.HasPrefix(loc, c.node("ny").URL+"/files/map.png") {
+		t.Errorf("Location = %s", loc)
+	}
+
+	c.stop("ny")
+	resp, _ = c.get("/get/map.png?"+nearNewYork.Encode(), nil)
+	if got := resp.Header.Get("X-Storage-Node"); got != "ldn" {
+		t.Errorf("with ny down, served from %q, want ldn", got)
+	}
+
+	resp, _ = c.get("/get/missing.png", nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("missing file: status %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestClusterErrorResponses(t *testing.T) {
+	c := newTestCluster(t, 1, nil)
+	req, _ := http.NewRequest("POST", c.central.URL+"/delete", nil)
+	req.Header.Set(apierr.RequestIDHeader, "req-42")
+	resp, err := testClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	e := apierr.FromResponse(resp)
+	want := apierr.Error{Status: 400, Code: "bad_request", Message: "filename required", RequestID: "req-42"}
+	if *e != want || resp.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("delete without a name = %+v (%s), want %+v as JSON", *e, resp.Header.Get("Content-Type"), want)
+	}
+}
+
+func TestClusterNearestView(t *testing.T) {
+	c := newTestCluster(t, 3, nil)
+	c.upload("photo.jpg", "jpeg bytes", nearLondon)
+
+	resp, body := c.get("/nearest-view?filename=photo.jpg&"+nearSingapore.Encode(), nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d: %s", resp.StatusCode, body)
+	}
+	if !strings.Contains(body, c.node("sg").URL) {
+		t.Errorf("nearest view does not point at the Singapore node:\n%s", body)
+	}
+}
+
+func TestClusterReadPreference(t *testing.T) {
+	c := newTestCluster(t, 3, func(cfg *Config) {
+		cfg.Placement = "nearest"
+		cfg.ReplicationFactor = 1
+	})
+	c.upload("eu.txt", "stays in london", nearLondon)
+
+	get := func(read string) *http.Response {
+		t.Helper()
+		q := url.Values{"read": {read}, "lat": nearNewYork["lat"], "lon": nearNewYork["lon"]}
+		resp, _ := c.get("/get/eu.txt?"+q.Encode(), nil)
+		return resp
+	}
+	if resp := get("region=london"); resp.StatusCode != http.StatusFound || resp.Header.Get("X-Storage-Node") != "ldn" {
+		t.Errorf("region=london: status %d node %q", resp.StatusCode, resp.Header.Get("X-Storage-Node"))
+	}
+	if resp := get("region=new-york"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("region=new-york: status %d, want 404", resp.StatusCode)
+	}
+	if resp := get("primary"); resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != "/files/eu.txt" {
+		t.Errorf("primary: status %d Location %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	if resp := get("fastest"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unknown preference: status %d, want 400", resp.StatusCode)
+	}
+
+	c.stop("ldn")
+	if resp := get("nearest"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("nearest with ldn down: status %d, want 404", resp.StatusCode)
+	}
+	resp, body := c.get("/download/eu.txt?read=any", nil)
+	if resp.StatusCode != http.StatusOK || body != "stays in london" {
+		t.Errorf("any with ldn down: status %d body %q", resp.StatusCode, body)
+	}
+
+	_, body = c.get("/files?read=region=new-york", http.Header{"Accept": {"application/json"}})
+	var files []FileListing
+	json.Unmarshal([]byte(body), &files)
+	if len(files) != 1 || len(files[0].Replica) != 1 || files[0].Replica["ny"] {
+		t.Errorf("listing in new-york = %+v", files)
+	}
+}
+
+func TestClusterLatencyDistance(t *testing.T) {
+	c := newTestCluster(t, 3, func(cfg *Config) {
+		cfg.DistanceModel = "latency"
+		cfg.Features = map[string]bool{featureLatencyRouting: true}
+	})
+	c.upload("map.png", "pixels", nearLondon)
+
+	// Without samples, geography decides.
+	resp, _ := c.get("/get/map.png?"+nearSingapore.Encode(), nil)
+	if got := resp.Header.Get("X-Storage-Node"); got != "sg" {
+		t.Errorf("no samples: served from %q, want sg", got)
+	}
+
+	// This client's network finds London fastest, wherever it says it is.
+	body := strings.NewReader(`{"samples": {"sg": 300, "ny": 180, "ldn": 12, "nowhere": 1}}`)
+	resp, err := http.Post(c.central.URL+"/api/v1/latency", "application/json", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ack struct {
+		Accepted int `json:"accepted"`
+	}
+	json.NewDecoder(resp.Body).Decode(&ack)
+	resp.Body.Close()
+	if ack.Accepted != 3 {
+		t.Errorf("accepted %d samples, want 3", ack.Accepted)
+	}
+	resp, _ = c.get("/get/map.png?"+nearSingapore.Encode(), nil)
+	if got := resp.Header.Get("X-Storage-Node"); got != "ldn" {
+		t.Errorf("with samples: served from %q, want ldn", got)
+	}
+}
+
+func TestClusterTopology(t *testing.T) {
+	c := newTestCluster(t, 3, nil)
+	c.stop("ldn")
+
+	_, body := c.get("/api/v1/cluster/topology", nil)
+	var topo Topology
+	if err := json.Unmarshal([]byte(body), &topo); err != nil {
+		t.Fatalf("%v: %s", err, body)
+	}
+	if len(topo.Nodes) != 3 || len(topo.Links) != 3 {
+		t.Fatalf("%d nodes and %d links, want 3 and 3", len(topo.Nodes), len(topo.Links))
+	}
+	for _, l := range topo.Links {
+		measured := l.LatencyMs > 0
+		if want := l.From != "ldn" && l.To != "ldn"; measured != want || l.DistanceKm <= 0 {
+			t.Errorf("link %s-%s: %.1f ms, %.0f km", l.From, l.To, l.LatencyMs, l.DistanceKm)
+		}
+	}
+
+	if resp, body := c.get("/cluster", nil); resp.StatusCode != http.StatusOK || !strings.Contains(body, "<svg") {
+		t.Errorf("map page: status %d", resp.StatusCode)
+	}
+	c.upload("map.png", "pixels", nearLondon)
+	if resp, body := c.get("/nearest-view?filename=map.png&"+nearNewYork.Encode(), nil); resp.StatusCode != http.StatusOK || !strings.Contains(body, `class="node selected"`) {
+		t.Errorf("nearest view: status %d\n%s", resp.StatusCode, body)
+	}
+}
+
+func TestClusterStatus(t *testing.T) {
+	c := newTestCluster(t, 3, nil)
+	c.upload("a.txt", "hello", nearLondon)
+	c.upload("b.txt", "world!", nearLondon)
+	c.stop("ldn")
+
+	_, body := c.get("/api/v1/cluster/status", nil)
+	var st ClusterStatus
+	if err := json.Unmarshal([]byte(body), &st); err != nil {
+		t.Fatalf("%v: %s", err, body)
+	}
+	if st.Status != clusterDegraded || st.Nodes.Total != 3 || st.Nodes.Healthy != 2 {
+		t.Errorf("status %s with %d of %d nodes healthy", st.Status, st.Nodes.Healthy, st.Nodes.Total)
+	}
+	if st.Files.Count != 2 || st.Files.Bytes != 11 {
+		t.Errorf("files = %+v, want 2 files of 11 bytes", st.Files)
+	}
+	if st.Version.Central.Version == "" || st.Replication.InFlight != 0 {
+		t.Errorf("version %+v, replication %+v", st.Version, st.Replication)
+	}
+}
+
+func TestClusterProbes(t *testing.T) {
+	c := newTestCluster(t, 2, nil)
+	if resp, _ := c.get("/healthz", nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("healthz: status %d", resp.StatusCode)
+	}
+	if resp, body := c.get("/readyz", nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("readyz: status %d: %s", resp.StatusCode, body)
+	}
+
+	c.stop("sg")
+	c.stop("ny")
+	resp, body := c.get("/readyz", nil)
+	if resp.StatusCode != http.StatusServiceUnavailable || !strings.Contains(body, "no storage server is reachable") {
+		t.Errorf("readyz with every node down: status %d: %s", resp.StatusCode, body)
+	}
+	if resp, _ := c.get("/healthz", nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("healthz with every node down: status %d", resp.StatusCode)
+	}
+}
+
+func TestClusterVersions(t *testing.T) {
+	c := newTestCluster(t, 2, nil)
+	var v struct {
+		Version string                    `json:"version"`
+		Nodes   map[string]buildinfo.Info `json:"nodes"`
+		Mixed   []string                  `json:"mixed"`
+	}
+	_, body := c.get("/version", nil)
+	if err := json.Unmarshal([]byte(body), &v); err != nil {
+		t.Fatalf("%v: %s", err, body)
+	}
+	if v.Version != buildinfo.Version || v.Nodes["sg"].Version != buildinfo.Version || v.Mixed != nil {
+		t.Errorf("one build everywhere: %s", body)
+	}
+
+	// ny is upgraded ahead of the rest.
+	ny := c.node("ny").StorageServer
+	info, _ := identities.get("ny")
+	info.Version, info.Commit = "v2.0.0", "0123456789abcdef"
+	identities.observe(ny, info)
+	_, body = c.get("/version", nil)
+	json.Unmarshal([]byte(body), &v)
+	if len(v.Mixed) != 2 || v.Mixed[1] != "v2.0.0 (0123456)" {
+		t.Errorf("mixed = %v", v.Mixed)
+	}
+	if _, body := c.get("/cluster", nil); !strings.Contains(body, "Mixed versions") {
+		t.Error("map page does not warn about mixed versions")
+	}
+}
+
+func TestClusterReload(t *testing.T) {
+	c := newTestCluster(t, 3, func(cfg *Config) { cfg.AdminToken = "s3cret" })
+
+	// Same nodes, but nearest-only placement, a quota for sg and a new port.
+	cfg := running
+	cfg.Placement = "nearest"
+	cfg.ReplicationFactor = 1
+	cfg.NodeLimits = map[string]NodeLimits{"sg": {QuotaFiles: 5}}
+	cfg.Port = "8080"
+	path := filepath.Join(t.TempDir(), "central.json")
+	raw, _ := json.Marshal(cfg)
+	os.WriteFile(path, raw, 0644)
+	t.Setenv("CONFIG_FILE", path)
+
+	reload := func(token string) (*http.Response, string) {
+		t.Helper()
+		req, _ := http.NewRequest("POST", c.central.URL+"/api/v1/admin/reload", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := testClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp, string(b)
+	}
+	if resp, _ := reload("wrong"); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("reload with a bad token: status %d", resp.StatusCode)
+	}
+	resp, body := reload("s3cret")
+	var report ReloadReport
+	if err := json.Unmarshal([]byte(body), &report); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("reload: status %d: %s", resp.StatusCode, body)
+	}
+	want := []string{"node_limits", "placement", "replication_factor"}
+	if !slices.Equal(report.Applied, want) || !slices.Equal(report.RestartNeeded, []string{"port"}) || report.LimitErrors != nil {
+		t.Errorf("report = %+v", report)
+	}
+	if running.Port == "8080" {
+		t.Error("port changed without a restart")
+	}
+
+	c.upload("one.txt", "x", nearLondon)
+	if got := c.holders("one.txt"); !slices.Equal(got, []string{"ldn"}) {
+		t.Errorf("holders after reload = %v, want [ldn]", got)
+	}
+	resp, err := http.Get(c.node("sg").URL + "/api/v1/limits")
+	if err != nil {
+		t.Fatal(err)
+	}
+	limits, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(limits), `"quota_files":5`) {
+		t.Errorf("sg limits = %s", limits)
+	}
+
+	// A config that does not validate changes nothing.
+	os.WriteFile(path, []byte(`{"placement": "random"}`), 0644)
+	if resp, _ := reload("s3cret"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("invalid config: status %d, want 400", resp.StatusCode)
+	}
+	if _, ok := currentPolicy().placement.(nearestN); !ok {
+		t.Errorf("placement = %T after a rejected reload", currentPolicy().placement)
+	}
+}
+
+func TestClusterPlacement(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		placement string
+		factor    int
+		from      url.Values
+		want      []string
+	}{
+		{"default replicates everywhere", "", 0, nearLondon, []string{"sg", "ny", "ldn", "syd", "sao"}},
+		{"nearest one", "nearest", 1, nearNewYork, []string{"ny"}},
+		{"nearest two", "nearest", 2, nearSingapore, []string{"sg", "syd"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newTestCluster(t, 5, func(cfg *Config) {
+				cfg.Placement = tc.placement
+				cfg.ReplicationFactor = tc.factor
+			})
+			c.upload("f.txt", "x", tc.from)
+			if got := c.holders("f.txt"); !slices.Equal(got, tc.want) {
+				t.Errorf("holders = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestClusterZonePlacement(t *testing.T) {
+	zones := map[string]string{"sg": "apac", "syd": "apac", "ny": "americas", "sao": "americas", "ldn": "europe"}
+	for _, tc := range []struct {
+		name    string
+		factor  int
+		maxZone int
+		want    int
+	}{
+		{"one per zone", 0, 0, 3},
+		{"spills over", 4, 0, 4},
+		{"capped", 4, 1, 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newTestCluster(t, 5, func(cfg *Config) {
+				cfg.Placement = "zone"
+				cfg.ReplicationFactor = tc.factor
+				cfg.MaxReplicasPerZone = tc.maxZone
+				for i := range cfg.Storages {
+					cfg.Storages[i].Zone = zones[cfg.Storages[i].ID]
+				}
+			})
+			c.upload("f.txt", "x", nearLondon)
+			got := c.holders("f.txt")
+			covered := map[string]bool{}
+			for _, id := range got {
+				covered[zones[id]] = true
+			}
+			if len(got) != tc.want || len(covered) != 3 {
+				t.Errorf("holders = %v, want %d nodes covering all 3 zones", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestClusterHashPlacementIsStable(t *testing.T) {
+	c := newTestCluster(t, 5, func(cfg *Config) {
+		cfg.Placement = "hash"
+		cfg.ReplicationFactor = 2
+		cfg.Features = map[string]bool{featureConsistentHashing: true}
+	})
+	c.upload("a.txt", "x", nearLondon)
+	c.upload("b.txt", "x", nearSingapore)
+
+	a := c.holders("a.txt")
+	if len(a) != 2 {
+		t.Fatalf("a.txt on %v, want 2 nodes", a)
+	}
+	// Where a client uploads from must not matter.
+	c.upload("a.txt", "y", nearSingapore)
+	if got := c.holders("a.txt"); !slices.Equal(got, a) {
+		t.Errorf("re-upload moved a.txt from %v to %v", a, got)
+	}
+}
+
+func TestClusterQuorumReplication(t *testing.T) {
+	c := newTestCluster(t, 3, func(cfg *Config) { cfg.Replication = "quorum" })
+
+	if resp, _ := c.upload("q.txt", "x", nearLondon); resp.StatusCode != http.StatusOK {
+		t.Errorf("all nodes up: status %d", resp.StatusCode)
+	}
+
+	c.stop("ny")
+	if resp, _ := c.upload("q2.txt", "x", nearLondon); resp.StatusCode != http.StatusOK {
+		t.Errorf("one node down: status %d", resp.StatusCode)
+	}
+
+	c.stop("ldn")
+	if resp, _ := c.upload("q3.txt", "x", nearLondon); resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("two nodes down: status %d, want 502", resp.StatusCode)
+	}
+}
+
+func TestClusterWriteConcern(t *testing.T) {
+	c := newTestCluster(t, 3, nil)
+	c.stop("ny")
+
+	upload := func(name, w string) (*http.Response, uploadResult) {
+		t.Helper()
+		return c.upload(name, "x", url.Values{"w": {w}, "lat": nearLondon["lat"], "lon": nearLondon["lon"]})
+	}
+	for _, tc := range []struct {
+		w    string
+		want int
+	}{
+		{"1", http.StatusOK},
+		{"quorum", http.StatusOK},
+		{"all", http.StatusBadGateway},
+		{"2", http.StatusBadRequest},
+	} {
+		resp, job := upload("w"+tc.w+".txt", tc.w)
+		if resp.StatusCode != tc.want {
+			t.Errorf("w=%s: status %d, want %d", tc.w, resp.StatusCode, tc.want)
+			continue
+		}
+		if tc.want == http.StatusOK && (resp.Header.Get("X-Write-Concern") != tc.w || job.WriteConcern != tc.w) {
+			t.Errorf("w=%s echoed as %q / %q", tc.w, resp.Header.Get("X-Write-Concern"), job.WriteConcern)
+		}
+	}
+}
+
+func TestClusterUploadWithFaultyNodes(t *testing.T) {
+	c := newTestCluster(t, 3, nil)
+	c.inject("ny", storage.FaultConfig{ErrorRate: 1})
+	c.inject("ldn", storage.FaultConfig{DiskFull: true})
+
+	resp, job := c.upload("doc.txt", "contents", nearLondon)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("upload: status %d", resp.StatusCode)
+	}
+	for id, want := range map[string]string{"sg": uploadDone, "ny": uploadFailed, "ldn": uploadFailed} {
+		if got := job.Replicas[id].Status; got != want {
+			t.Errorf("replica %s: %s, want %s", id, got, want)
+		}
+	}
+	if got := c.holders("doc.txt"); !slices.Equal(got, []string{"sg"}) {
+		t.Errorf("holders = %v, want [sg]", got)
+	}
+
+	// Once healed, the nodes serve again.
+	c.inject("ny", storage.FaultConfig{})
+	resp, _ = c.get("/get/doc.txt?"+nearNewYork.Encode(), nil)
+	if got := resp.Header.Get("X-Storage-Node"); got != "sg" {
+		t.Errorf("served from %q, want sg (the only replica)", got)
+	}
+}
+
+func TestClusterFullNodeHandsReplicaToSpare(t *testing.T) {
+	c := newTestCluster(t, 3, func(cfg *Config) { cfg.ReplicationFactor = 2 })
+	c.inject("ldn", storage.FaultConfig{DiskFull: true})
+
+	// From London the targets are ldn and ny; ldn is full, so sg, the next
+	// nearest node, gets its replica.
+	resp, job := c.upload("doc.txt", "contents", nearLondon)
+	if resp.StatusCode != http.StatusOK || job.Status != uploadDone {
+		t.Fatalf("upload: status %d, job %+v", resp.StatusCode, job)
+	}
+	if r := job.Replicas["ldn"]; r.Status != uploadFailed || !strings.Contains(r.Error, "507") {
+		t.Errorf("ldn replica = %+v, want failed with 507", r)
+	}
+	if got := c.holders("doc.txt"); !slices.Equal(got, []string{"sg", "ny"}) {
+		t.Errorf("holders = %v, want [sg ny]", got)
+	}
+}
+
+func TestClusterNodeOverQuotaLeavesPlacement(t *testing.T) {
+	c := newTestCluster(t, 3, func(cfg *Config) { cfg.ReplicationFactor = 2 })
+	ldn := c.node("ldn")
+	ldn.cfg.QuotaFiles = 1
+	c.inject("ldn", storage.FaultConfig{})
+
+	c.upload("a.txt", "x", nearLondon)
+	_, job := c.upload("b.txt", "x", nearLondon)
+	if r := job.Replicas["ldn"]; r.Status != uploadFailed || !strings.Contains(r.Error, "quota exceeded") {
+		t.Errorf("ldn replica of b.txt = %+v, want quota exceeded", r)
+	}
+	if got := c.holders("b.txt"); !slices.Equal(got, []string{"sg", "ny"}) {
+		t.Errorf("b.txt holders = %v, want [sg ny]", got)
+	}
+
+	// The probe confirms ldn is full; new uploads skip it altogether.
+	probeNode(ldn.StorageServer)
+	_, job = c.upload("c.txt", "x", nearLondon)
+	if _, tried := job.Replicas["ldn"]; tried || job.Status != uploadDone {
+		t.Errorf("c.txt job = %+v, want done without trying ldn", job)
+	}
+
+	// Once it has room, it is placed on again.
+	ldn.backend.Delete("a.txt")
+	probeNode(ldn.StorageServer)
+	c.upload("d.txt", "x", nearLondon)
+	if got := c.holders("d.txt"); !slices.Equal(got, []string{"ny", "ldn"}) {
+		t.Errorf("d.txt holders = %v, want [ny ldn]", got)
+	}
+}
+
+func TestClusterRoutesAroundDegradedNodes(t *testing.T) {
+	c := newTestCluster(t, 3, nil)
+
+	// ny stays up for probes but fails every upload for a while.
+	c.inject("ny", storage.FaultConfig{ErrorRate: 1})
+	for i := 0; i < statsMinCalls; i++ {
+		c.upload("warmup-"+strconv.Itoa(i)+".txt", "x", nearLondon)
+	}
+	c.inject("ny", storage.FaultConfig{})
+	// The failures opened ny's circuit; a health probe lets it back in.
+	if st := breakers.get("ny"); st.State != breakerOpen {
+		t.Fatalf("ny breaker = %+v, want open", st)
+	}
+	probeNode(c.node("ny").StorageServer)
+
+	c.upload("map.png", "pixels", nearLondon)
+	if got := c.holders("map.png"); len(got) != 3 {
+		t.Fatalf("holders = %v, want all three", got)
+	}
+	resp, _ := c.get("/get/map.png?"+nearNewYork.Encode(), nil)
+	if got := resp.Header.Get("X-Storage-Node"); got != "ldn" {
+		t.Errorf("served from %q, want ldn while ny is degraded", got)
+	}
+
+	if s := nodeStats.get("ny"); s.Score >= degradedScore || s.Ops[opUpload].Errors < statsMinCalls {
+		t.Errorf("ny stats = %+v", s)
+	}
+	if s := nodeStats.get("ldn"); s.Score < degradedScore || s.Ops[opUpload].OK != statsMinCalls+1 {
+		t.Errorf("ldn stats = %+v", s)
+	}
+}
+
+func TestClusterHungNodeTimesOut(t *testing.T) {
+	c := newTestCluster(t, 3, func(cfg *Config) {
+		cfg.NodeTimeout = Duration{100 * time.Millisecond}
+	})
+	c.delay("ny", time.Second)
+
+	start := time.Now()
+	resp, job := c.upload("doc.txt", "contents", nearLondon)
+	if resp.StatusCode != http.StatusOK || job.Status != uploadDone {
+		t.Fatalf("upload: status %d, job %+v", resp.StatusCode, job)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("upload took %s with a hung node", elapsed)
+	}
+	if r := job.Replicas["ny"]; r.Status != uploadFailed || !strings.Contains(r.Error, "deadline exceeded") {
+		t.Errorf("ny replica = %+v, want a timeout", r)
+	}
+	// The listing still answers, naming the node it could not reach.
+	resp, _ = c.get("/files", http.Header{"Accept": {"application/json"}})
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("X-Unreachable-Nodes") != "ny" {
+		t.Errorf("listing: status %d, unreachable %q", resp.StatusCode, resp.Header.Get("X-Unreachable-Nodes"))
+	}
+}
+
+func TestClusterUploadDeadlineGivesPartialSuccess(t *testing.T) {
+	c := newTestCluster(t, 3, func(cfg *Config) {
+		cfg.UploadTimeout = Duration{300 * time.Millisecond}
+	})
+	c.delay("ny", time.Second)
+
+	// Replicas are written in parallel: ldn and sg make it, the deadline
+	// trips while waiting for ny.
+	resp, job := c.upload("doc.txt", "contents", nearLondon)
+	if resp.StatusCode != http.StatusOK || job.Status != uploadPartial {
+		t.Fatalf("upload: status %d, job %+v", resp.StatusCode, job)
+	}
+	for id, want := range map[string]string{"ldn": uploadDone, "ny": uploadFailed, "sg": uploadDone} {
+		if got := job.Replicas[id].Status; got != want {
+			t.Errorf("replica %s: %s, want %s", id, got, want)
+		}
+	}
+
+	// Without any replica written, the deadline fails the upload.
+	c.delay("ldn", time.Second)
+	c.delay("sg", time.Second)
+	resp, _ = c.upload("other.txt", "contents", nearLondon)
+	if resp.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("upload with every node hung: status %d, want 504", resp.StatusCode)
+	}
+}
+
+func TestClusterFeatureFlags(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Placement = "hash"
+	cfg.Features = map[string]bool{"warp_drive": true}
+	if errs := cfg.validate(); len(errs) != 2 {
+		t.Errorf("hash placement without its flag, unknown flag: %v, want 2 errors", errs)
+	}
+
+	c := newTestCluster(t, 3, func(cfg *Config) {
+		cfg.Features = parseFeatures("-client_write_concern")
+	})
+	query := url.Values{"w": {"all"}}
+	maps.Copy(query, nearLondon)
+	resp, _ := c.upload("doc.txt", "contents", query)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("upload choosing its write concern: status %d, want 400", resp.StatusCode)
+	}
+
+	_, body := c.get("/api/v1/features", nil)
+	var flags []struct {
+		Name    string `json:"name"`
+		Enabled bool   `json:"enabled"`
+	}
+	if err := json.Unmarshal([]byte(body), &flags); err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]bool{}
+	for _, f := range flags {
+		got[f.Name] = f.Enabled
+	}
+	want := map[string]bool{featureConsistentHashing: false, featureLatencyRouting: false, featureClientWriteConcern: false, featureDirectRepair: true}
+	if !maps.Equal(got, want) {
+		t.Errorf("features = %v, want %v", got, want)
+	}
+}
+
+func TestClusterMultiFileUpload(t *testing.T) {
+	c := newTestCluster(t, 3, nil)
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, name := range []string{"a.txt", "b.txt"} {
+		fw, _ := mw.CreateFormFile("file", name)
+		io.WriteString(fw, "contents of "+name)
+	}
+	mw.Close()
+
+	query := url.Values{"upload_id": {"batch"}}
+	maps.Copy(query, nearLondon)
+	req, _ := http.NewRequest("POST", c.central.URL+"/upload?"+query.Encode(), &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Accept", "application/json")
+	resp, err := testClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var jobs []struct {
+		ID       string `json:"id"`
+		Filename string `json:"filename"`
+		Status   string `json:"status"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&jobs); err != nil {
+		t.Fatalf("status %d: %v", resp.StatusCode, err)
+	}
+	if len(jobs) != 2 || jobs[0].ID != "batch" || jobs[1].ID != "batch-2" || jobs[1].Filename != "b.txt" {
+		t.Fatalf("jobs = %+v", jobs)
+	}
+	for _, j := range jobs {
+		if j.Status != uploadDone {
+			t.Errorf("%s: %s", j.Filename, j.Status)
+		}
+		if got := c.holders(j.Filename); len(got) != 3 {
+			t.Errorf("%s on %v", j.Filename, got)
+		}
+	}
+	if _, body := c.get("/api/v1/uploads/batch-2/progress", nil); !strings.Contains(body, `"b.txt"`) {
+		t.Errorf("progress of the second file: %s", body)
+	}
+}
+
+func TestClusterArchive(t *testing.T) {
+	c := newTestCluster(t, 3, nil)
+	c.upload("a.txt", "alpha", nearLondon)
+	c.upload("b.txt", "bravo", nearLondon)
+
+	resp, body := c.get("/api/v1/archive?files=a.txt,b.txt,a.txt", nil)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/zip" {
+		t.Fatalf("archive: status %d, type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	zr, err := zip.NewReader(strings.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, f := range zr.File {
+		rc, _ := f.Open()
+		b, _ := io.ReadAll(rc)
+		rc.Close()
+		got[f.Name] = string(b)
+	}
+	if want := map[string]string{"a.txt": "alpha", "b.txt": "bravo"}; !maps.Equal(got, want) {
+		t.Errorf("archive holds %v, want %v", got, want)
+	}
+
+	if resp, _ := c.get("/api/v1/archive?files=a.txt,nope.txt", nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("archive with a missing file: status %d, want 404", resp.StatusCode)
+	}
+	if resp, _ := c.get("/api/v1/archive?files=../etc/passwd", nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("archive of a path: status %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestClusterExport(t *testing.T) {
+	c := newTestCluster(t, 3, func(cfg *Config) { cfg.ReplicationFactor = 1 })
+	c.upload("report-q1.txt", "first quarter", nearLondon)
+	c.upload("report-q2.txt", "second quarter", nearSingapore)
+	c.upload("notes.txt", "unrelated", nearLondon)
+
+	resp, body := c.get("/api/v1/export?prefix=report-", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("export: status %d: %s", resp.StatusCode, body)
+	}
+	gz, err := gzip.NewReader(strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	got := map[string]string{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(tr)
+		got[hdr.Name] = string(b)
+	}
+	if want := map[string]string{"report-q1.txt": "first quarter", "report-q2.txt": "second quarter"}; !maps.Equal(got, want) {
+		t.Errorf("export holds %v, want %v", got, want)
+	}
+}
+
+func TestClusterNodeCallsNeedAToken(t *testing.T) {
+	c := newTestCluster(t, 1, nil)
+	register := func(token string) int {
+		t.Helper()
+		req, _ := http.NewRequest("POST", c.central.URL+"/api/v1/nodes/register",
+			strings.NewReader(`{"id":"rogue","node_uuid":"x","url":"http://rogue.invalid"}`))
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := testClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status := register("wrong"); status != http.StatusUnauthorized {
+		t.Errorf("register with the wrong token: %d", status)
+	}
+	defer func(tok string) { registrationToken = tok }(registrationToken)
+	registrationToken = ""
+	if status := register(""); status != http.StatusUnauthorized {
+		t.Errorf("register with no token configured: %d", status)
+	}
+	if _, ok := topo.get("rogue"); ok {
+		t.Error("rogue node registered")
+	}
+
+	cfg := defaultConfig()
+	cfg.RegistrationToken = ""
+	if !runSelfTest(cfg).Failed() {
+		t.Error("self-test passed without a registration token")
+	}
+}
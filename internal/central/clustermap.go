@@ -0,0 +1,280 @@
+package central
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/buildinfo"
+)
+
+// ---------------------------
+// Cluster Map
+// ---------------------------
+
+// TopologyNode is a node as the topology map shows it.
+type TopologyNode struct {
+	ID            string       `json:"id"`
+	Label         string       `json:"label"`
+	URL           string       `json:"url"`
+	Zone          string       `json:"zone,omitempty"`
+	Region        string       `json:"region,omitempty"`
+	Lat           float64      `json:"lat"`
+	Lon           float64      `json:"lon"`
+	Healthy       bool         `json:"healthy"`
+	Identity      string       `json:"identity"`
+	Version       string       `json:"version"`
+	CapacityBytes int64        `json:"capacity_bytes,omitempty"`
+	Quota         *QuotaStatus `json:"quota,omitempty"`
+	Full          *FullStatus  `json:"full,omitempty"`
+	CentralRTTMs  float64      `json:"central_rtt_ms,omitempty"`
+}
+
+// TopologyLink is a pair of nodes, with the round trip between them if the
+// nodes could measure it.
+type TopologyLink struct {
+	From       string  `json:"from"`
+	To         string  `json:"to"`
+	DistanceKm float64 `json:"distance_km"`
+	LatencyMs  float64 `json:"latency_ms,omitempty"`
+}
+
+// Topology is the answer to GET /api/v1/cluster/topology.
+type Topology struct {
+	Nodes           []TopologyNode `json:"nodes"`
+	Links           []TopologyLink `json:"links"`
+	LatencyMeasured time.Time      `json:"latency_measured,omitzero"`
+}
+
+// Inter-node latencies are measured by the nodes themselves (see the
+// storage nodes' /api/v1/ping-peers) at most once per peerLatencyMaxAge.
+var peerLatencyMaxAge = time.Minute
+
+type peerLatencyCache struct {
+	mu  sync.Mutex
+	at  time.Time
+	rtt map[string]map[string]float64 // from node ID, to node ID
+}
+
+var peerLatency = &peerLatencyCache{}
+
+// get returns the inter-node round trips, measuring them again when they
+// are older than peerLatencyMaxAge.
+func (c *peerLatencyCache) get(ctx context.Context, nodes []StorageServer) (map[string]map[string]float64, time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.rtt != nil && time.Since(c.at) < peerLatencyMaxAge {
+		return c.rtt, c.at
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	peers := map[string]string{}
+	for _, s := range nodes {
+		peers[s.ID] = s.URL
+	}
+	rtt := map[string]map[string]float64{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, s := range nodes {
+		if !health.isHealthy(s.ID) {
+			continue
+		}
+		wg.Add(1)
+		go func(s StorageServer) {
+			defer wg.Done()
+			others := map[string]string{}
+			for id, u := range peers {
+				if id != s.ID {
+					others[id] = u
+				}
+			}
+			got, err := pingPeers(ctx, s, others)
+			if err != nil {
+				fmt.Println("Peer latency from", s.ID, "failed:", err)
+				return
+			}
+			mu.Lock()
+			rtt[s.ID] = got
+			mu.Unlock()
+		}(s)
+	}
+	wg.Wait()
+	c.rtt, c.at = rtt, time.Now().UTC()
+	return c.rtt, c.at
+}
+
+// pingPeers asks s for its round trip to each of peers.
+func pingPeers(ctx context.Context, s StorageServer, peers map[string]string) (map[string]float64, error) {
+	b, _ := json.Marshal(map[string]interface{}{"peers": peers})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL+"/api/v1/ping-peers", bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if registrationToken != "" {
+		req.Header.Set("Authorization", "Bearer "+registrationToken)
+	}
+	resp, err := nodeClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &statusError{Status: resp.StatusCode}
+	}
+	var out map[string]float64
+	return out, json.NewDecoder(resp.Body).Decode(&out)
+}
+
+// clusterTopology gathers the nodes and the links between every pair.
+func clusterTopology(ctx context.Context) Topology {
+	nodes := topo.nodes()
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+	central, _ := latencies.snapshot()
+	rtt, measured := peerLatency.get(ctx, nodes)
+
+	t := Topology{Nodes: []TopologyNode{}, Links: []TopologyLink{}, LatencyMeasured: measured}
+	for _, s := range nodes {
+		info, identity := identities.get(s.ID)
+		n := TopologyNode{
+			ID:            s.ID,
+			Label:         s.Label,
+			URL:           s.URL,
+			Zone:          s.Zone,
+			Region:        info.Region,
+			Lat:           s.Lat,
+			Lon:           s.Lon,
+			Healthy:       health.isHealthy(s.ID),
+			Identity:      identity,
+			Version:       nodeBuild(s.ID).String(),
+			CapacityBytes: s.Capacity,
+			Quota:         info.Quota,
+			CentralRTTMs:  central[s.ID],
+		}
+		if st, ok := capacity.get(s.ID); ok {
+			n.Full = &st
+		}
+		t.Nodes = append(t.Nodes, n)
+	}
+	for i, a := range nodes {
+		for _, b := range nodes[i+1:] {
+			l := TopologyLink{From: a.ID, To: b.ID, DistanceKm: haversineKm(a.Lat, a.Lon, b.Lat, b.Lon)}
+			// Each side measured its own round trip; use what there is.
+			ab, okA := rtt[a.ID][b.ID]
+			ba, okB := rtt[b.ID][a.ID]
+			switch {
+			case okA && okB:
+				l.LatencyMs = (ab + ba) / 2
+			case okA:
+				l.LatencyMs = ab
+			case okB:
+				l.LatencyMs = ba
+			}
+			t.Links = append(t.Links, l)
+		}
+	}
+	return t
+}
+
+// topologyHandler serves the cluster topology as JSON.
+func topologyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(clusterTopology(r.Context()))
+}
+
+// topologyPageHandler draws the cluster topology on a map.
+func topologyPageHandler(w http.ResponseWriter, r *http.Request) {
+	t := clusterTopology(r.Context())
+	m := newMapView()
+	at := map[string]mapPoint{}
+	for _, n := range t.Nodes {
+		p := m.point(n.Lat, n.Lon)
+		p.Label = n.ID
+		p.Title = fmt.Sprintf("%s (%s)", n.Label, n.URL)
+		p.Class = "node"
+		if !n.Healthy {
+			p.Class += " down"
+		} else if n.Full != nil {
+			p.Class += " full"
+		}
+		at[n.ID] = p
+		m.Points = append(m.Points, p)
+	}
+	for _, l := range t.Links {
+		text := fmt.Sprintf("%.0f km", l.DistanceKm)
+		if l.LatencyMs > 0 {
+			text = fmt.Sprintf("%.0f ms", l.LatencyMs)
+		}
+		m.line(at[l.From], at[l.To], text, "link")
+	}
+
+	data := struct {
+		Topology
+		Map           mapView
+		Central       string
+		MixedVersions []string
+		TopDownloads  []FileDownloads
+	}{t, m, buildinfo.Get().String(), mixedVersions(), downloads.top(10, "")}
+	if err := templates.ExecuteTemplate(w, "topology.html", data); err != nil {
+		apierr.Send(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// ---------------------------
+// Map View
+// ---------------------------
+
+// mapView is an equirectangular world map for the "map" template: points
+// and the lines between them, already projected to SVG coordinates.
+type mapView struct {
+	Width, Height float64
+	Grid          []mapLine // every 30 degrees
+	Points        []mapPoint
+	Lines         []mapLine
+}
+
+type mapPoint struct {
+	X, Y  float64
+	Label string
+	Title string
+	Class string
+}
+
+type mapLine struct {
+	X1, Y1, X2, Y2 float64
+	LX, LY         float64 // where the label goes
+	Label          string
+	Class          string
+}
+
+func newMapView() mapView {
+	m := mapView{Width: 720, Height: 360}
+	for lon := -150.0; lon < 180; lon += 30 {
+		a, b := m.point(90, lon), m.point(-90, lon)
+		m.Grid = append(m.Grid, mapLine{X1: a.X, Y1: a.Y, X2: b.X, Y2: b.Y})
+	}
+	for lat := -60.0; lat < 90; lat += 30 {
+		a, b := m.point(lat, -180), m.point(lat, 180)
+		m.Grid = append(m.Grid, mapLine{X1: a.X, Y1: a.Y, X2: b.X, Y2: b.Y})
+	}
+	return m
+}
+
+func (m *mapView) point(lat, lon float64) mapPoint {
+	return mapPoint{X: (lon + 180) / 360 * m.Width, Y: (90 - lat) / 180 * m.Height}
+}
+
+func (m *mapView) line(a, b mapPoint, label, class string) {
+	m.Lines = append(m.Lines, mapLine{
+		X1: a.X, Y1: a.Y, X2: b.X, Y2: b.Y,
+		LX: (a.X + b.X) / 2, LY: (a.Y + b.Y) / 2,
+		Label: label, Class: class,
+	})
+}
@@ -0,0 +1,922 @@
+package central
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/checksum"
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/cron"
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/listen"
+)
+
+// Duration is a time.Duration that reads from JSON strings like "10s".
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"10s\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// ---------------------------
+// Configuration
+// ---------------------------
+type Config struct {
+	Port      string          `json:"port"`
+	UploadDir string          `json:"upload_dir"`
+	DataDir   string          `json:"data_dir"`
+	Storages  []StorageServer `json:"storages"`
+
+	// Listen lists the addresses to serve on, like "127.0.0.1:8000",
+	// "[::1]:8000" or "unix:/run/dsfs/central.sock" for a reverse proxy on
+	// the same host; empty listens on Port on every interface. With
+	// AdminListen set, the /api/v1/admin/ endpoints are served only there.
+	Listen      []string `json:"listen"`
+	AdminListen []string `json:"admin_listen"`
+
+	// TemplateDir and StaticDir override the built-in pages and static
+	// files with the same names; empty uses the built-in ones as they are.
+	TemplateDir string   `json:"template_dir"`
+	StaticDir   string   `json:"static_dir"`
+	Branding    Branding `json:"branding"`
+
+	// ImageCacheBytes bounds the resized images kept under DataDir/images
+	// (see imageHandler); 0 turns the cache off.
+	ImageCacheBytes int64 `json:"image_cache_bytes"`
+
+	// Transcoder is the ffmpeg binary that cuts uploaded videos into HLS
+	// (see hlsHandler), in segments of HLSSegmentLength; empty turns it off.
+	Transcoder       string   `json:"transcoder"`
+	HLSSegmentLength Duration `json:"hls_segment_length"`
+
+	// SearchIndex is where file contents are indexed for /search: "memory"
+	// or an Elasticsearch index URL; empty turns search off.
+	SearchIndex string `json:"search_index"`
+
+	// QuarantineReports is how many distinct reporters (signed-in users, or
+	// else client addresses) must report a file (POST
+	// /api/v1/files/{name}/report) to quarantine it; 0 turns reports off.
+	QuarantineReports int `json:"quarantine_reports"`
+
+	// AccessLog is where a JSON line goes for every access to a file (see
+	// logAccess): "" for nowhere, "-" for stdout, an http(s) URL to POST
+	// batches to, or a file rotated at AccessLogMaxBytes (0 = never) with
+	// AccessLogBackups old files kept.
+	AccessLog         string `json:"access_log"`
+	AccessLogMaxBytes int64  `json:"access_log_max_bytes"`
+	AccessLogBackups  int    `json:"access_log_backups"`
+
+	HealthCheckInterval Duration `json:"health_check_interval"`
+
+	// SigningKey is shared with the storage nodes; when set, node file URLs
+	// are signed and expire after SignedURLTTL.
+	SigningKey   string   `json:"signing_key"`
+	SignedURLTTL Duration `json:"signed_url_ttl"`
+
+	// RegistrationToken is shared with the storage nodes: they present it
+	// to register and call back, and it is sent with every call that
+	// manages them. It is required.
+	RegistrationToken string `json:"registration_token"`
+
+	// AdminToken guards the admin endpoints; they are off without it.
+	// DebugAddr, when set, serves pprof profiles and expvar counters there,
+	// e.g. "127.0.0.1:6060", behind the admin token.
+	AdminToken string `json:"admin_token"`
+	DebugAddr  string `json:"debug_addr"`
+
+	// DiscoverySRV is an SRV name to discover storage nodes from, refreshed
+	// every DiscoveryInterval. Discovered nodes are merged with Storages.
+	DiscoverySRV      string   `json:"discovery_srv"`
+	DiscoveryInterval Duration `json:"discovery_interval"`
+
+	// Features turns feature flags on or off by name (see featureFlags);
+	// flags not listed keep their default.
+	Features map[string]bool `json:"features"`
+
+	// NodeLimits are quotas (and free space floors) for storage nodes, by
+	// node ID. The central API pushes them to the nodes and again whenever a
+	// node reports a different quota, e.g. after a restart.
+	NodeLimits map[string]NodeLimits `json:"node_limits"`
+
+	// ReplicationFactor is how many nodes each upload is copied to, nearest
+	// to the uploader first; 0 means every node.
+	ReplicationFactor int `json:"replication_factor"`
+
+	// Placement selects the PlacementStrategy: "default" (every node, or the
+	// nearest ReplicationFactor), "all", "nearest", "hash" or "zone".
+	Placement string `json:"placement"`
+
+	// MaxReplicasPerZone caps how many replicas "zone" placement puts in
+	// one zone (0 = no cap). With 1, losing a zone never costs more than
+	// one copy of a file, at the price of fewer copies than
+	// ReplicationFactor when there are fewer zones than that.
+	MaxReplicasPerZone int `json:"max_replicas_per_zone"`
+
+	// DistanceModel is how nearness is judged for routing and placement:
+	// "geo" (great-circle distance from the client's location) or
+	// "latency" (round trip times measured by clients and the central
+	// API, falling back to geography where there are none).
+	DistanceModel string `json:"distance_model"`
+
+	// Replication is how uploads to the default bucket are copied: "sync"
+	// (every target before replying), "async" (queued, replies at once) or
+	// "quorum" (replies once a majority has it). Buckets can override it.
+	// Async replication runs on ReplicationWorkers goroutines fed by a
+	// priority queue of ReplicationQueueSize uploads and repairs.
+	Replication          string                  `json:"replication"`
+	ReplicationWorkers   int                     `json:"replication_workers"`
+	ReplicationQueueSize int                     `json:"replication_queue_size"`
+	Buckets              map[string]BucketConfig `json:"buckets"`
+
+	// GeoResolver locates clients by IP: "heuristic" (the built-in test
+	// mapping), "maxmind" (GeoIP2 web service), "http" (any JSON API, GeoURL
+	// with an {ip} placeholder) or "static" (CIDR ranges in GeoMappingFile).
+	GeoResolver       string `json:"geo_resolver"`
+	GeoURL            string `json:"geo_url"`
+	GeoLatField       string `json:"geo_lat_field"`
+	GeoLonField       string `json:"geo_lon_field"`
+	GeoMappingFile    string `json:"geo_mapping_file"`
+	MaxMindAccountID  string `json:"maxmind_account_id"`
+	MaxMindLicenseKey string `json:"maxmind_license_key"`
+
+	// ConfigStore ("etcd" or "consul") holds a ClusterConfig document at
+	// ConfigStoreKey that overrides Storages and ReplicationFactor and is
+	// watched for changes.
+	ConfigStore      string `json:"config_store"`
+	ConfigStoreURL   string `json:"config_store_url"`
+	ConfigStoreKey   string `json:"config_store_key"`
+	ConfigStoreToken string `json:"config_store_token"`
+
+	// Requests slower than SlowRequestThreshold or moving more than
+	// LargePayloadBytes are logged with a timing breakdown; 0 disables
+	// either check.
+	SlowRequestThreshold Duration `json:"slow_request_threshold"`
+	LargePayloadBytes    int64    `json:"large_payload_bytes"`
+
+	// NodeTimeout bounds every call to a storage node; UploadTimeout bounds
+	// replicating one upload to all its targets. Replicas not written by
+	// then are reported as a partial success.
+	NodeTimeout   Duration `json:"node_timeout"`
+	UploadTimeout Duration `json:"upload_timeout"`
+
+	// Failed node calls are tried up to RetryAttempts times in total,
+	// waiting a random time up to RetryBaseDelay*2^n (capped at
+	// RetryMaxDelay) in between.
+	RetryAttempts  int      `json:"retry_attempts"`
+	RetryBaseDelay Duration `json:"retry_base_delay"`
+	RetryMaxDelay  Duration `json:"retry_max_delay"`
+
+	// A node's circuit breaker opens after BreakerThreshold consecutive
+	// failed calls; calls to it then fail fast until BreakerCooldown has
+	// passed or a health probe gets through.
+	BreakerThreshold int      `json:"breaker_threshold"`
+	BreakerCooldown  Duration `json:"breaker_cooldown"`
+
+	// Connections to storage nodes are pooled: up to MaxIdleConnsPerNode
+	// are kept open per node for IdleConnTimeout. NodeCAFile adds a CA for
+	// nodes served over HTTPS with a private certificate; NodeTLSInsecure
+	// skips verification altogether (testing only).
+	MaxIdleConnsPerNode int      `json:"max_idle_conns_per_node"`
+	IdleConnTimeout     Duration `json:"idle_conn_timeout"`
+	NodeCAFile          string   `json:"node_ca_file"`
+	NodeTLSInsecure     bool     `json:"node_tls_insecure"`
+
+	// Files on storage nodes the central API has no record of are looked
+	// for every GCInterval (0 = only on POST /api/v1/admin/gc) and reported,
+	// deleted or adopted per GCPolicy. Files younger than GCGracePeriod are
+	// left alone.
+	GCInterval    Duration `json:"gc_interval"`
+	GCPolicy      string   `json:"gc_policy"`
+	GCGracePeriod Duration `json:"gc_grace_period"`
+
+	// Every PrefetchInterval (0 = off) the download counts decide where
+	// files live (see runPrefetch): a file downloaded PrefetchHotDownloads
+	// times since the last run is copied to every region without a
+	// replica, up to PrefetchNodeBudget bytes of such extra copies per node
+	// (0 = as long as the node has room), and one nobody downloaded for
+	// ColdAfter (0 = never) is trimmed to ColdReplicas replicas.
+	PrefetchInterval     Duration `json:"prefetch_interval"`
+	PrefetchHotDownloads int64    `json:"prefetch_hot_downloads"`
+	PrefetchNodeBudget   int64    `json:"prefetch_node_budget_bytes"`
+	ColdAfter            Duration `json:"cold_after"`
+	ColdReplicas         int      `json:"cold_replicas"`
+
+	// Scheduled bulk traffic (prefetch runs, queued repairs) runs only in
+	// MaintenanceWindows, daily "HH:MM-HH:MM" spans in MaintenanceTimezone
+	// (the host's local time if empty; no windows = any time). All bulk
+	// transfers together send at most MaintenanceBytesPerSec (0 =
+	// unlimited; 50 Mbps is 6250000).
+	MaintenanceWindows     []string `json:"maintenance_windows"`
+	MaintenanceTimezone    string   `json:"maintenance_timezone"`
+	MaintenanceBytesPerSec int64    `json:"maintenance_bytes_per_sec"`
+
+	// Users can sign in to the web UI, which is open to anyone without
+	// them or OIDC. A browser stays signed in for SessionTTL, its session kept in
+	// SessionStore: "memory" (lost on restart) or "file"
+	// (DataDir/sessions.json). Session cookies are Secure over TLS, and
+	// always with SecureCookies, for TLS ended at a proxy.
+	Users         []User   `json:"users"`
+	SessionStore  string   `json:"session_store"`
+	SessionTTL    Duration `json:"session_ttl"`
+	SecureCookies bool     `json:"secure_cookies"`
+
+	// OIDC lets users sign in with an OpenID Connect provider as well.
+	OIDC OIDCConfig `json:"oidc"`
+
+	// LDAP checks passwords against a directory such as Active Directory,
+	// for names without a password of their own under Users.
+	LDAP LDAPConfig `json:"ldap"`
+
+	// ShortLinkTTL is how long a short link (/s/{code}) lasts when its
+	// maker gives no expiry.
+	ShortLinkTTL Duration `json:"short_link_ttl"`
+
+	// PublicURL is this site as users reach it (https://files.example.com),
+	// for links that leave it, such as QR codes. Empty means the Host the
+	// request came in on.
+	PublicURL string `json:"public_url"`
+
+	// SMTP is the mail server email notifications go through; without one
+	// none is sent. ReplicationAlertAfter is how long a replica may keep
+	// failing before replication.failing is published, and
+	// QuotaWarnPercent how full of its quota a node gets before
+	// quota.near_limit is.
+	SMTP                  SMTPConfig `json:"smtp"`
+	ReplicationAlertAfter Duration   `json:"replication_alert_after"`
+	QuotaWarnPercent      int        `json:"quota_warn_percent"`
+
+	// TrashRetention is how long a deleted file stays in the trash, where
+	// it can be restored, before it is deleted from every node. 0 deletes
+	// at once.
+	TrashRetention Duration `json:"trash_retention"`
+
+	// RetentionRules say how long files are kept, by bucket and name
+	// prefix (see retention.go). They are evaluated every
+	// RetentionInterval (0: only on demand), and only reported on until
+	// RetentionEnforce is set.
+	RetentionRules    []RetentionRule `json:"retention_rules"`
+	RetentionInterval Duration        `json:"retention_interval"`
+	RetentionEnforce  bool            `json:"retention_enforce"`
+
+	// ChecksumAlgorithm is what new files are checked by: sha256, blake3
+	// or xxh64, once every node supports it (see checksum.go).
+	ChecksumAlgorithm string `json:"checksum_algorithm"`
+
+	// Files of StripeAbove bytes or more (0 = only uploads asking with
+	// ?stripe=1) are striped: cut into StripeSize pieces placed round-robin
+	// across the nodes, one copy each, instead of replicated (see
+	// stripes.go). Downloads fetch up to StripeParallel stripes at once.
+	StripeAbove    int64 `json:"stripe_above"`
+	StripeSize     int64 `json:"stripe_size"`
+	StripeParallel int   `json:"stripe_parallel"`
+
+	// PostUploadTasks run on every upload once it is stored, in the
+	// background (see pipeline.go): verify, thumbnail and cloud_copy, which
+	// PUTs the file under CloudCopyURL. PipelineWorkers bounds how many
+	// run at once, indexing for search among them.
+	PostUploadTasks []string `json:"post_upload_tasks"`
+	PipelineWorkers int      `json:"pipeline_workers"`
+	CloudCopyURL    string   `json:"cloud_copy_url"`
+
+	// Schedules sets when the recurring tasks run (see schedule.go), by
+	// name: gc, prefetch, retention, trash_purge, anti_entropy, scrub and
+	// usage. A schedule is a crontab line ("0 3 * * *"), a shorthand
+	// ("@daily") or "@every <duration>"; "" runs the task only on demand.
+	// Tasks left out keep their default: gc, prefetch and retention every
+	// GCInterval, PrefetchInterval and RetentionInterval, trash_purge every
+	// 10 minutes, usage hourly, and the rest on demand.
+	Schedules map[string]string `json:"schedules"`
+
+	// Retries of an upload or delete that carry the Idempotency-Key of an
+	// earlier request get its response for IdempotencyWindow (0 = the
+	// header is ignored) instead of running again (see idempotency.go).
+	IdempotencyWindow Duration `json:"idempotency_window"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		Port:      "8000",
+		UploadDir: "uploads",
+		DataDir:   "data",
+		Storages:  append([]StorageServer(nil), defaultStorages...),
+		Branding:  defaultBranding,
+
+		ImageCacheBytes:  256 << 20,
+		HLSSegmentLength: Duration{6 * time.Second},
+
+		QuarantineReports: 3,
+		AccessLogMaxBytes: 100 << 20,
+		AccessLogBackups:  5,
+
+		HealthCheckInterval: Duration{10 * time.Second},
+		SignedURLTTL:        Duration{15 * time.Minute},
+		DiscoveryInterval:   Duration{30 * time.Second},
+
+		ReplicationWorkers:   4,
+		ReplicationQueueSize: 100,
+
+		SlowRequestThreshold: Duration{5 * time.Second},
+		LargePayloadBytes:    100 << 20,
+
+		NodeTimeout:   Duration{30 * time.Second},
+		UploadTimeout: Duration{2 * time.Minute},
+
+		RetryAttempts:  3,
+		RetryBaseDelay: Duration{100 * time.Millisecond},
+		RetryMaxDelay:  Duration{2 * time.Second},
+
+		BreakerThreshold: 5,
+		BreakerCooldown:  Duration{30 * time.Second},
+
+		MaxIdleConnsPerNode: 32,
+		IdleConnTimeout:     Duration{90 * time.Second},
+
+		GCPolicy:      gcReport,
+		GCGracePeriod: Duration{time.Hour},
+
+		PrefetchHotDownloads: 10,
+		ColdAfter:            Duration{30 * 24 * time.Hour},
+		ColdReplicas:         1,
+
+		SessionStore: "memory",
+		SessionTTL:   Duration{12 * time.Hour},
+		ShortLinkTTL: Duration{7 * 24 * time.Hour},
+
+		ReplicationAlertAfter: Duration{30 * time.Minute},
+		QuotaWarnPercent:      90,
+
+		TrashRetention:    Duration{7 * 24 * time.Hour},
+		RetentionInterval: Duration{time.Hour},
+
+		ChecksumAlgorithm: checksum.Default,
+
+		StripeSize:     64 << 20,
+		StripeParallel: 4,
+
+		PostUploadTasks: []string{taskVerify, taskThumbnail},
+		PipelineWorkers: 2,
+
+		IdempotencyWindow: Duration{24 * time.Hour},
+	}
+}
+
+// loadConfig starts from the built-in defaults, overlays the JSON file named
+// by CONFIG_FILE (if any) and finally applies environment overrides.
+func loadConfig() (Config, error) {
+	cfg := defaultConfig()
+
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return cfg, fmt.Errorf("read config %s: %w", path, err)
+		}
+		if err := json.Unmarshal(raw, &cfg); err != nil {
+			return cfg, fmt.Errorf("parse config %s: %w", path, err)
+		}
+	}
+
+	if port := os.Getenv("PORT"); port != "" {
+		cfg.Port = port
+	}
+	if v := os.Getenv("LISTEN"); v != "" {
+		cfg.Listen = listen.Split(v)
+	}
+	if v := os.Getenv("ADMIN_LISTEN"); v != "" {
+		cfg.AdminListen = listen.Split(v)
+	}
+	if dir := os.Getenv("TEMPLATE_DIR"); dir != "" {
+		cfg.TemplateDir = dir
+	}
+	if v := os.Getenv("ACCESS_LOG"); v != "" {
+		cfg.AccessLog = v
+	}
+	if v := os.Getenv("SEARCH_INDEX"); v != "" {
+		cfg.SearchIndex = v
+	}
+	if dir := os.Getenv("STATIC_DIR"); dir != "" {
+		cfg.StaticDir = dir
+	}
+	for env, field := range map[string]*string{
+		"BRAND_TITLE":         &cfg.Branding.Title,
+		"BRAND_LOGO_URL":      &cfg.Branding.LogoURL,
+		"BRAND_PRIMARY_COLOR": &cfg.Branding.PrimaryColor,
+		"BRAND_ACCENT_COLOR":  &cfg.Branding.AccentColor,
+		"BRAND_FOOTER":        &cfg.Branding.FooterText,
+	} {
+		if v := os.Getenv(env); v != "" {
+			*field = v
+		}
+	}
+	if dir := os.Getenv("UPLOAD_DIR"); dir != "" {
+		cfg.UploadDir = dir
+	}
+	if dir := os.Getenv("DATA_DIR"); dir != "" {
+		cfg.DataDir = dir
+	}
+	if key := os.Getenv("SIGNING_KEY"); key != "" {
+		cfg.SigningKey = key
+	}
+	if token := os.Getenv("REGISTRATION_TOKEN"); token != "" {
+		cfg.RegistrationToken = token
+	}
+	if token := os.Getenv("ADMIN_TOKEN"); token != "" {
+		cfg.AdminToken = token
+	}
+	if addr := os.Getenv("DEBUG_ADDR"); addr != "" {
+		cfg.DebugAddr = addr
+	}
+	if v := os.Getenv("FEATURES"); v != "" {
+		if cfg.Features == nil {
+			cfg.Features = map[string]bool{}
+		}
+		for name, on := range parseFeatures(v) {
+			cfg.Features[name] = on
+		}
+	}
+	if name := os.Getenv("DISCOVERY_SRV"); name != "" {
+		cfg.DiscoverySRV = name
+	}
+	if name := os.Getenv("PLACEMENT"); name != "" {
+		cfg.Placement = name
+	}
+	if name := os.Getenv("DISTANCE_MODEL"); name != "" {
+		cfg.DistanceModel = name
+	}
+	if mode := os.Getenv("REPLICATION"); mode != "" {
+		cfg.Replication = mode
+	}
+	if name := os.Getenv("GEO_RESOLVER"); name != "" {
+		cfg.GeoResolver = name
+	}
+	if u := os.Getenv("GEO_URL"); u != "" {
+		cfg.GeoURL = u
+	}
+	if path := os.Getenv("GEO_MAPPING_FILE"); path != "" {
+		cfg.GeoMappingFile = path
+	}
+	if id := os.Getenv("MAXMIND_ACCOUNT_ID"); id != "" {
+		cfg.MaxMindAccountID = id
+	}
+	if key := os.Getenv("MAXMIND_LICENSE_KEY"); key != "" {
+		cfg.MaxMindLicenseKey = key
+	}
+
+	if v := os.Getenv("SLOW_REQUEST_THRESHOLD"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return cfg, fmt.Errorf("SLOW_REQUEST_THRESHOLD: %w", err)
+		}
+		cfg.SlowRequestThreshold.Duration = d
+	}
+	for name, d := range map[string]*Duration{
+		"NODE_TIMEOUT":       &cfg.NodeTimeout,
+		"UPLOAD_TIMEOUT":     &cfg.UploadTimeout,
+		"GC_INTERVAL":        &cfg.GCInterval,
+		"GC_GRACE_PERIOD":    &cfg.GCGracePeriod,
+		"PREFETCH_INTERVAL":  &cfg.PrefetchInterval,
+		"COLD_AFTER":         &cfg.ColdAfter,
+		"TRASH_RETENTION":    &cfg.TrashRetention,
+		"RETENTION_INTERVAL": &cfg.RetentionInterval,
+		"IDEMPOTENCY_WINDOW": &cfg.IdempotencyWindow,
+	} {
+		if v := os.Getenv(name); v != "" {
+			parsed, err := time.ParseDuration(v)
+			if err != nil {
+				return cfg, fmt.Errorf("%s: %w", name, err)
+			}
+			d.Duration = parsed
+		}
+	}
+	if v := os.Getenv("RETRY_ATTEMPTS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return cfg, fmt.Errorf("RETRY_ATTEMPTS: %w", err)
+		}
+		cfg.RetryAttempts = n
+	}
+	if v := os.Getenv("MAX_REPLICAS_PER_ZONE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return cfg, fmt.Errorf("MAX_REPLICAS_PER_ZONE: %w", err)
+		}
+		cfg.MaxReplicasPerZone = n
+	}
+	if v := os.Getenv("QUARANTINE_REPORTS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return cfg, fmt.Errorf("QUARANTINE_REPORTS: %w", err)
+		}
+		cfg.QuarantineReports = n
+	}
+	if v := os.Getenv("MAINTENANCE_WINDOWS"); v != "" {
+		cfg.MaintenanceWindows = strings.Split(v, ",")
+	}
+	if tz := os.Getenv("MAINTENANCE_TIMEZONE"); tz != "" {
+		cfg.MaintenanceTimezone = tz
+	}
+	if v := os.Getenv("MAINTENANCE_BYTES_PER_SEC"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return cfg, fmt.Errorf("MAINTENANCE_BYTES_PER_SEC: %w", err)
+		}
+		cfg.MaintenanceBytesPerSec = n
+	}
+	if v := os.Getenv("LARGE_PAYLOAD_BYTES"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return cfg, fmt.Errorf("LARGE_PAYLOAD_BYTES: %w", err)
+		}
+		cfg.LargePayloadBytes = n
+	}
+
+	if v := os.Getenv("STRIPE_ABOVE"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return cfg, fmt.Errorf("STRIPE_ABOVE: %w", err)
+		}
+		cfg.StripeAbove = n
+	}
+	if v := os.Getenv("STRIPE_SIZE"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return cfg, fmt.Errorf("STRIPE_SIZE: %w", err)
+		}
+		cfg.StripeSize = n
+	}
+
+	if v := os.Getenv("POST_UPLOAD_TASKS"); v != "" {
+		cfg.PostUploadTasks = strings.Split(v, ",")
+	}
+	if v := os.Getenv("PIPELINE_WORKERS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return cfg, fmt.Errorf("PIPELINE_WORKERS: %w", err)
+		}
+		cfg.PipelineWorkers = n
+	}
+	if u := os.Getenv("CLOUD_COPY_URL"); u != "" {
+		cfg.CloudCopyURL = u
+	}
+	// SCHEDULES=name=spec;name=spec, e.g. "scrub=0 3 * * 0;usage=@daily".
+	if v := os.Getenv("SCHEDULES"); v != "" {
+		if cfg.Schedules == nil {
+			cfg.Schedules = map[string]string{}
+		}
+		for _, pair := range strings.Split(v, ";") {
+			name, spec, ok := strings.Cut(pair, "=")
+			if !ok {
+				return cfg, fmt.Errorf("SCHEDULES: %q is not name=schedule", pair)
+			}
+			cfg.Schedules[strings.TrimSpace(name)] = strings.TrimSpace(spec)
+		}
+	}
+
+	if policy := os.Getenv("GC_POLICY"); policy != "" {
+		cfg.GCPolicy = policy
+	}
+	if alg := os.Getenv("CHECKSUM_ALGORITHM"); alg != "" {
+		cfg.ChecksumAlgorithm = alg
+	}
+
+	if path := os.Getenv("NODE_CA_FILE"); path != "" {
+		cfg.NodeCAFile = path
+	}
+
+	if kind := os.Getenv("CONFIG_STORE"); kind != "" {
+		cfg.ConfigStore = kind
+	}
+	if endpoint := os.Getenv("CONFIG_STORE_URL"); endpoint != "" {
+		cfg.ConfigStoreURL = endpoint
+	}
+	if key := os.Getenv("CONFIG_STORE_KEY"); key != "" {
+		cfg.ConfigStoreKey = key
+	}
+	if token := os.Getenv("CONFIG_STORE_TOKEN"); token != "" {
+		cfg.ConfigStoreToken = token
+	}
+
+	for env, dst := range map[string]*string{
+		"OIDC_ISSUER":        &cfg.OIDC.Issuer,
+		"OIDC_CLIENT_ID":     &cfg.OIDC.ClientID,
+		"OIDC_CLIENT_SECRET": &cfg.OIDC.ClientSecret,
+		"OIDC_REDIRECT_URL":  &cfg.OIDC.RedirectURL,
+		"LDAP_URL":           &cfg.LDAP.URL,
+		"LDAP_BIND_DN":       &cfg.LDAP.BindDN,
+		"LDAP_BIND_PASSWORD": &cfg.LDAP.BindPassword,
+		"LDAP_BASE_DN":       &cfg.LDAP.BaseDN,
+		"SMTP_ADDR":          &cfg.SMTP.Addr,
+		"SMTP_FROM":          &cfg.SMTP.From,
+		"SMTP_USERNAME":      &cfg.SMTP.Username,
+		"SMTP_PASSWORD":      &cfg.SMTP.Password,
+	} {
+		if v := os.Getenv(env); v != "" {
+			*dst = v
+		}
+	}
+	if store := os.Getenv("SESSION_STORE"); store != "" {
+		cfg.SessionStore = store
+	}
+	if u := os.Getenv("PUBLIC_URL"); u != "" {
+		cfg.PublicURL = u
+	}
+	if v := os.Getenv("RETENTION_ENFORCE"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid RETENTION_ENFORCE %q", v)
+		}
+		cfg.RetentionEnforce = b
+	}
+	if v := os.Getenv("SECURE_COOKIES"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid SECURE_COOKIES %q", v)
+		}
+		cfg.SecureCookies = b
+	}
+
+	cfg.normalizeStorages()
+	return cfg, nil
+}
+
+// normalizeStorages fills in defaults: nodes without an explicit ID fall back
+// to their host:port, which is stable as long as the node is not moved.
+func (c *Config) normalizeStorages() {
+	for i := range c.Storages {
+		s := &c.Storages[i]
+		if s.ID == "" {
+			if u, err := url.Parse(s.URL); err == nil {
+				s.ID = u.Host
+			}
+		}
+		if s.Label == "" {
+			s.Label = s.ID
+		}
+	}
+}
+
+// validate returns every problem found rather than stopping at the first one,
+// so the startup report shows the whole picture.
+func (c Config) validate() []error {
+	var errs []error
+
+	if p, err := strconv.Atoi(c.Port); err != nil || p < 1 || p > 65535 {
+		errs = append(errs, fmt.Errorf("port %q is not a valid TCP port", c.Port))
+	}
+	seen := map[string]bool{}
+	for _, addr := range slices.Concat(c.Listen, c.AdminListen) {
+		if err := listen.Check(addr); err != nil {
+			errs = append(errs, err)
+		} else if seen[addr] {
+			errs = append(errs, fmt.Errorf("listen address %s is given twice", addr))
+		}
+		seen[addr] = true
+	}
+	if c.UploadDir == "" {
+		errs = append(errs, fmt.Errorf("upload_dir must not be empty"))
+	}
+	if c.DataDir == "" {
+		errs = append(errs, fmt.Errorf("data_dir must not be empty"))
+	}
+	if c.HealthCheckInterval.Duration < time.Second {
+		errs = append(errs, fmt.Errorf("health_check_interval must be at least 1s"))
+	}
+	if c.DiscoverySRV != "" && c.DiscoveryInterval.Duration < time.Second {
+		errs = append(errs, fmt.Errorf("discovery_interval must be at least 1s"))
+	}
+	if c.DebugAddr != "" {
+		if c.AdminToken == "" {
+			errs = append(errs, fmt.Errorf("debug_addr needs admin_token"))
+		}
+		if _, port, err := net.SplitHostPort(c.DebugAddr); err != nil {
+			errs = append(errs, fmt.Errorf("debug_addr %q: %w", c.DebugAddr, err))
+		} else if c.listensOn(port) {
+			errs = append(errs, fmt.Errorf("debug_addr must not use an API port (%s)", port))
+		}
+	}
+	if c.SigningKey != "" && c.SignedURLTTL.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("signed_url_ttl must be positive when signing_key is set"))
+	}
+
+	if c.ReplicationFactor < 0 {
+		errs = append(errs, fmt.Errorf("replication_factor must not be negative"))
+	}
+	if _, err := newPlacementStrategy(c.Placement); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := newDistanceModel(c.DistanceModel); err != nil {
+		errs = append(errs, err)
+	}
+	errs = append(errs, c.validateFeatures()...)
+	errs = append(errs, c.Branding.validate()...)
+	if c.Transcoder != "" && c.HLSSegmentLength.Duration < time.Second {
+		errs = append(errs, fmt.Errorf("hls_segment_length must be at least 1s"))
+	}
+	if _, err := newSearchIndex(c.SearchIndex); err != nil {
+		errs = append(errs, err)
+	}
+	if c.AccessLogMaxBytes < 0 || c.AccessLogBackups < 0 {
+		errs = append(errs, fmt.Errorf("access_log_max_bytes and access_log_backups must not be negative"))
+	}
+	if c.QuarantineReports < 0 {
+		errs = append(errs, fmt.Errorf("quarantine_reports must not be negative"))
+	}
+	if c.ImageCacheBytes < 0 {
+		errs = append(errs, fmt.Errorf("image_cache_bytes must not be negative"))
+	}
+	for id, l := range c.NodeLimits {
+		if l.QuotaBytes < 0 || l.QuotaFiles < 0 || l.MinFreeBytes < 0 {
+			errs = append(errs, fmt.Errorf("node_limits[%s]: limits must not be negative", id))
+		}
+	}
+	if c.MaxReplicasPerZone < 0 {
+		errs = append(errs, fmt.Errorf("max_replicas_per_zone must not be negative"))
+	}
+	if !validReplication(c.Replication) {
+		errs = append(errs, fmt.Errorf("unknown replication %q (want sync, async or quorum)", c.Replication))
+	}
+	for name, b := range c.Buckets {
+		if !validBucket.MatchString(name) {
+			errs = append(errs, fmt.Errorf("buckets: invalid name %q", name))
+		}
+		if !validReplication(b.Replication) {
+			errs = append(errs, fmt.Errorf("buckets[%s]: unknown replication %q (want sync, async or quorum)", name, b.Replication))
+		}
+		if b.Retention.Duration < 0 {
+			errs = append(errs, fmt.Errorf("buckets[%s]: retention must not be negative", name))
+		}
+	}
+	if c.ReplicationWorkers < 1 || c.ReplicationQueueSize < 1 {
+		errs = append(errs, fmt.Errorf("replication_workers and replication_queue_size must be at least 1"))
+	}
+	if c.NodeTimeout.Duration <= 0 || c.UploadTimeout.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("node_timeout and upload_timeout must be positive"))
+	}
+	if c.RetryAttempts < 1 {
+		errs = append(errs, fmt.Errorf("retry_attempts must be at least 1"))
+	}
+	if c.RetryBaseDelay.Duration < 0 || c.RetryMaxDelay.Duration < c.RetryBaseDelay.Duration {
+		errs = append(errs, fmt.Errorf("retry_base_delay must not be negative or above retry_max_delay"))
+	}
+	if c.BreakerThreshold < 1 || c.BreakerCooldown.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("breaker_threshold must be at least 1 and breaker_cooldown positive"))
+	}
+	if c.MaxIdleConnsPerNode < 1 || c.IdleConnTimeout.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("max_idle_conns_per_node must be at least 1 and idle_conn_timeout positive"))
+	}
+	if !validGCPolicy(c.GCPolicy) {
+		errs = append(errs, fmt.Errorf("unknown gc_policy %q (want report, delete or adopt)", c.GCPolicy))
+	}
+	if c.GCInterval.Duration < 0 || c.GCGracePeriod.Duration < 0 {
+		errs = append(errs, fmt.Errorf("gc_interval and gc_grace_period must not be negative"))
+	}
+	if c.PrefetchInterval.Duration < 0 || c.ColdAfter.Duration < 0 || c.PrefetchNodeBudget < 0 {
+		errs = append(errs, fmt.Errorf("prefetch_interval, cold_after and prefetch_node_budget_bytes must not be negative"))
+	}
+	if c.PrefetchHotDownloads < 1 || c.ColdReplicas < 1 {
+		errs = append(errs, fmt.Errorf("prefetch_hot_downloads and cold_replicas must be at least 1"))
+	}
+	if _, err := newMaintenancePolicy(c); err != nil {
+		errs = append(errs, err)
+	}
+	if c.MaintenanceBytesPerSec < 0 {
+		errs = append(errs, fmt.Errorf("maintenance_bytes_per_sec must not be negative"))
+	}
+	if c.SlowRequestThreshold.Duration < 0 || c.LargePayloadBytes < 0 {
+		errs = append(errs, fmt.Errorf("slow_request_threshold and large_payload_bytes must not be negative"))
+	}
+	if c.SessionStore != "memory" && c.SessionStore != "file" {
+		errs = append(errs, fmt.Errorf("unknown session_store %q (want memory or file)", c.SessionStore))
+	}
+	if c.SessionTTL.Duration < time.Minute {
+		errs = append(errs, fmt.Errorf("session_ttl must be at least 1m"))
+	}
+	if c.ShortLinkTTL.Duration < time.Minute {
+		errs = append(errs, fmt.Errorf("short_link_ttl must be at least 1m"))
+	}
+	if c.PublicURL != "" {
+		if u, err := url.Parse(c.PublicURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("public_url %q must be an http(s) URL", c.PublicURL))
+		}
+	}
+	errs = append(errs, validateUsers(c.Users)...)
+	errs = append(errs, c.OIDC.validate()...)
+	errs = append(errs, c.LDAP.validate()...)
+	errs = append(errs, c.SMTP.validate()...)
+	if c.ReplicationAlertAfter.Duration < time.Minute {
+		errs = append(errs, fmt.Errorf("replication_alert_after must be at least 1m"))
+	}
+	if c.QuotaWarnPercent < 1 || c.QuotaWarnPercent > 100 {
+		errs = append(errs, fmt.Errorf("quota_warn_percent must be 1-100"))
+	}
+	if c.TrashRetention.Duration < 0 {
+		errs = append(errs, fmt.Errorf("trash_retention must not be negative"))
+	}
+	if c.RetentionInterval.Duration < 0 {
+		errs = append(errs, fmt.Errorf("retention_interval must not be negative"))
+	}
+	if c.IdempotencyWindow.Duration < 0 {
+		errs = append(errs, fmt.Errorf("idempotency_window must not be negative"))
+	}
+	errs = append(errs, validateRetentionRules(c.RetentionRules, c.Buckets)...)
+	if c.StripeAbove < 0 || c.StripeSize < 1 || c.StripeParallel < 1 {
+		errs = append(errs, fmt.Errorf("stripe_above must not be negative, and stripe_size and stripe_parallel must be at least 1"))
+	}
+	for _, task := range c.PostUploadTasks {
+		if !validTask(task) {
+			errs = append(errs, fmt.Errorf("unknown post_upload_tasks entry %q (want verify, thumbnail or cloud_copy)", task))
+		}
+	}
+	if c.PipelineWorkers < 1 {
+		errs = append(errs, fmt.Errorf("pipeline_workers must be at least 1"))
+	}
+	if slices.Contains(c.PostUploadTasks, taskCloudCopy) && c.CloudCopyURL == "" {
+		errs = append(errs, fmt.Errorf("cloud_copy_url is required for the cloud_copy task"))
+	}
+	if c.CloudCopyURL != "" {
+		if u, err := url.Parse(c.CloudCopyURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("cloud_copy_url %q must be an http(s) URL", c.CloudCopyURL))
+		}
+	}
+	for name, spec := range c.Schedules {
+		if _, ok := scheduledTasks[name]; !ok {
+			errs = append(errs, fmt.Errorf("schedules: unknown task %q", name))
+			continue
+		}
+		if spec == "" {
+			continue
+		}
+		if _, err := cron.Parse(spec); err != nil {
+			errs = append(errs, fmt.Errorf("schedules: %s: %w", name, err))
+		}
+	}
+	if !checksum.Supported(c.ChecksumAlgorithm) {
+		errs = append(errs, fmt.Errorf("unknown checksum_algorithm %q (want %s)", c.ChecksumAlgorithm, strings.Join(checksum.Algorithms, ", ")))
+	}
+	if c.ConfigStore != "" {
+		if _, err := newConfigStore(c.ConfigStore, c.ConfigStoreURL, ""); err != nil {
+			errs = append(errs, err)
+		}
+		if c.ConfigStoreURL == "" || c.ConfigStoreKey == "" {
+			errs = append(errs, fmt.Errorf("config_store_url and config_store_key are required with config_store"))
+		}
+	}
+	return append(errs, c.validateStorages()...)
+}
+
+// listenAddrs returns the addresses the API serves on.
+func (c Config) listenAddrs() []string {
+	if len(c.Listen) > 0 {
+		return c.Listen
+	}
+	return []string{":" + c.Port}
+}
+
+// listensOn reports whether an API or admin listener uses port.
+func (c Config) listensOn(port string) bool {
+	for _, addr := range slices.Concat(c.listenAddrs(), c.AdminListen) {
+		if _, p, err := net.SplitHostPort(addr); err == nil && p == port {
+			return true
+		}
+	}
+	return false
+}
+
+func (c Config) validateStorages() []error {
+	var errs []error
+	seen := map[string]bool{}
+	seenIDs := map[string]bool{}
+	for i, s := range c.Storages {
+		for _, err := range validateStorage(s) {
+			errs = append(errs, fmt.Errorf("storages[%d]: %w", i, err))
+		}
+		if s.ID != "" && seenIDs[s.ID] {
+			errs = append(errs, fmt.Errorf("storages[%d]: duplicate id %q", i, s.ID))
+		}
+		seenIDs[s.ID] = true
+		if seen[s.URL] {
+			errs = append(errs, fmt.Errorf("storages[%d]: duplicate url %q", i, s.URL))
+		}
+		seen[s.URL] = true
+	}
+	return errs
+}
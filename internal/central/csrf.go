@@ -0,0 +1,87 @@
+package central
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+)
+
+// ---------------------------
+// CSRF Protection
+// ---------------------------
+
+// The HTML forms that change state (upload, delete) carry a token that
+// must match the browser's csrf_token cookie, so another site cannot post
+// them on a visitor's behalf: it can neither read the cookie nor make the
+// browser send it (SameSite=Strict). The token goes in the X-CSRF-Token
+// header (the upload page's script), a csrf_token form field (delete), or
+// the csrf_token query parameter (the upload form without script, whose
+// multipart body is streamed and not parsed up front).
+//
+// Scripts and other non-browser clients send no cookies and none of the
+// Origin and Sec-Fetch-Site headers browsers add; no other site can make
+// such a request, so they need no token.
+
+const csrfCookie = "csrf_token"
+
+// csrfToken returns the browser's token, issuing one if it has none. Pages
+// with forms call it before writing the body.
+func csrfToken(w http.ResponseWriter, r *http.Request) string {
+	if c, err := r.Cookie(csrfCookie); err == nil && len(c.Value) == 43 {
+		return c.Value
+	}
+	b := make([]byte, 32)
+	rand.Read(b)
+	token := base64.RawURLEncoding.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return token
+}
+
+// fromBrowser reports whether r may have been sent by a browser, and so
+// could have been forged by another site.
+func fromBrowser(r *http.Request) bool {
+	return r.Header.Get("Origin") != "" || r.Header.Get("Sec-Fetch-Site") != "" || len(r.Cookies()) > 0
+}
+
+// requireCSRF rejects browser requests that change state without the
+// token of the browser's cookie.
+func requireCSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !fromBrowser(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		got := r.Header.Get("X-CSRF-Token")
+		if got == "" {
+			got = r.URL.Query().Get(csrfCookie)
+		}
+		if got == "" {
+			// Only url-encoded bodies are parsed: a multipart upload is
+			// left for the handler to stream.
+			r.ParseForm()
+			got = r.PostForm.Get(csrfCookie)
+		}
+		c, err := r.Cookie(csrfCookie)
+		if err != nil || got == "" || subtle.ConstantTimeCompare([]byte(got), []byte(c.Value)) != 1 {
+			apierr.Send(w, "Invalid or missing CSRF token; reload the page and try again", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,74 @@
+package central
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestCSRF(t *testing.T) {
+	c := newTestCluster(t, 1, nil)
+	c.upload("a.txt", "keep me", nearLondon)
+
+	resp, page := c.get("/", nil)
+	m := regexp.MustCompile(`data-csrf="([^"]+)"`).FindStringSubmatch(page)
+	cookies := resp.Cookies()
+	if m == nil || len(cookies) != 1 || cookies[0].Value != m[1] || cookies[0].SameSite != http.SameSiteStrictMode {
+		t.Fatalf("upload page token %v, cookies %v", m, cookies)
+	}
+	token := m[1]
+
+	del := func(origin string, cookie bool, form url.Values) int {
+		t.Helper()
+		req, _ := http.NewRequest("POST", c.central.URL+"/delete", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Origin", origin)
+		if cookie {
+			req.AddCookie(&http.Cookie{Name: csrfCookie, Value: token})
+		}
+		resp, err := testClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if got := del("https://evil.example", false, url.Values{"filename": {"a.txt"}, "csrf_token": {token}}); got != http.StatusForbidden {
+		t.Errorf("cross-site post without the cookie: %d", got)
+	}
+	if got := del(c.central.URL, true, url.Values{"filename": {"a.txt"}}); got != http.StatusForbidden {
+		t.Errorf("post without a token: %d", got)
+	}
+	if resp, _ := c.get("/delete?filename=a.txt", nil); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET /delete: %d", resp.StatusCode)
+	}
+	if len(c.holders("a.txt")) != 1 {
+		t.Fatal("a.txt deleted by a rejected request")
+	}
+	if got := del(c.central.URL, true, url.Values{"filename": {"a.txt"}, "csrf_token": {token}}); got != http.StatusSeeOther {
+		t.Errorf("post with the token: %d", got)
+	}
+
+	// The upload page's script sends the token in a header.
+	upload := func(header string) int {
+		t.Helper()
+		req, _ := http.NewRequest("POST", c.central.URL+"/upload?"+nearLondon.Encode(), strings.NewReader(""))
+		req.Header.Set("Sec-Fetch-Site", "same-origin")
+		req.Header.Set("X-CSRF-Token", header)
+		req.AddCookie(&http.Cookie{Name: csrfCookie, Value: token})
+		resp, err := testClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if got := upload(""); got != http.StatusForbidden {
+		t.Errorf("upload without the header: %d", got)
+	}
+	if got := upload(token); got == http.StatusForbidden {
+		t.Errorf("upload with the header: %d", got)
+	}
+}
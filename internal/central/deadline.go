@@ -0,0 +1,30 @@
+package central
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ---------------------------
+// Deadlines
+// ---------------------------
+
+// Every call to a storage node runs under the caller's context (usually the
+// client's request) and is cut off after nodeTimeout, so one hung node
+// cannot stall a request. Replication as a whole must finish within
+// uploadTimeout; whatever has not been copied by then is reported as a
+// partial success instead of holding the client.
+var (
+	nodeTimeout   = 30 * time.Second
+	uploadTimeout = 2 * time.Minute
+)
+
+// errPartialReplication means the upload deadline tripped after some but not
+// all replicas were written. The upload is still acknowledged.
+var errPartialReplication = errors.New("upload deadline exceeded, file is only partially replicated")
+
+// withNodeTimeout bounds a single storage node call.
+func withNodeTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, nodeTimeout)
+}
@@ -0,0 +1,339 @@
+package central
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/checksum"
+)
+
+// ---------------------------
+// Direct Uploads
+// ---------------------------
+
+// A direct upload goes from the client straight to one storage node, on a
+// URL the central API signs for that file, node and a nonce, so a large
+// file does not make the extra hop through the central API. The URL is
+// good for one upload until it expires (signedURLTTL). Once the file is
+// stored the node reports it, and the central API copies it from that
+// node, as its own copy, to the other replica targets, tracked by the
+// upload job handed out with the URL.
+
+// uploadScope starts what is signed for a direct upload URL, as on the
+// nodes.
+const uploadScope = "upload\n"
+
+// DirectUpload is a minted upload URL.
+type DirectUpload struct {
+	UploadID string    `json:"upload_id"` // the job, for /api/v1/uploads/{id}/progress
+	File     string    `json:"file"`
+	Node     string    `json:"node"`
+	URL      string    `json:"url"` // POST the file here, as for /upload
+	Expires  time.Time `json:"expires"`
+}
+
+// pendingUpload is a direct upload URL not yet used.
+type pendingUpload struct {
+	file     string
+	node     string
+	nodeUUID string // as signed
+	bucket   string
+	client   clientRef
+	job      *uploadJob
+	expires  time.Time
+}
+
+type directUploadRegistry struct {
+	mu      sync.Mutex
+	pending map[string]pendingUpload // by nonce
+}
+
+var directUploads = &directUploadRegistry{pending: map[string]pendingUpload{}}
+
+// add records a minted URL.
+func (reg *directUploadRegistry) add(nonce string, p pendingUpload) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.expireLocked()
+	reg.pending[nonce] = p
+}
+
+// take hands out the pending upload for nonce, once, if it is for file on
+// the node with nodeUUID.
+func (reg *directUploadRegistry) take(nonce, file, nodeUUID string) (pendingUpload, bool) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.expireLocked()
+	p, ok := reg.pending[nonce]
+	if !ok || p.file != file || p.nodeUUID != nodeUUID {
+		return pendingUpload{}, false
+	}
+	delete(reg.pending, nonce)
+	return p, true
+}
+
+// expireLocked fails the jobs of the URLs that expired unused.
+func (reg *directUploadRegistry) expireLocked() {
+	now := time.Now()
+	for n, p := range reg.pending {
+		if now.After(p.expires) {
+			p.job.finish(errors.New("upload URL expired unused"))
+			delete(reg.pending, n)
+		}
+	}
+}
+
+func signUpload(nodeUUID, file, nonce string, expires int64) string {
+	mac := hmac.New(sha256.New, signingKey)
+	mac.Write([]byte(uploadScope + nodeUUID + "\n" + file + "\n" + nonce + "\n" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// directUploadHandler mints an upload URL: POST /api/v1/uploads/direct
+// ?name=<file>, with bucket and folder as for /upload, and node= to pick
+// the node (the nearest healthy one with room otherwise).
+func directUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if len(signingKey) == 0 {
+		apierr.Send(w, "Direct uploads need a signing key", http.StatusNotFound)
+		return
+	}
+	client, err := locateClient(r)
+	if err != nil {
+		apierr.Send(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q := r.URL.Query()
+	name := q.Get("name")
+	if name == "" {
+		apierr.Send(w, "name is required", http.StatusBadRequest)
+		return
+	}
+	filename, err := storedName(r, name, q.Get("folder"))
+	if err != nil {
+		apierr.Send(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err := errLocked(filename); err != nil {
+		apierr.Send(w, err.Error(), http.StatusLocked)
+		return
+	}
+	bucket := q.Get("bucket")
+	if f, ok := teams.folder(q.Get("folder")); ok && bucket == "" {
+		bucket = f.Bucket
+	}
+	if _, ok := replicatorFor(bucket); !ok {
+		apierr.Send(w, "Unknown bucket "+bucket, http.StatusBadRequest)
+		return
+	}
+
+	var s StorageServer
+	for _, n := range spareNodes(nil, client) {
+		if id := q.Get("node"); id == "" || id == n.ID {
+			s = n
+			break
+		}
+	}
+	if s.ID == "" {
+		apierr.Send(w, "No healthy storage node with room to upload to", http.StatusServiceUnavailable)
+		return
+	}
+	info, _ := identities.get(s.ID)
+	if info.ID == "" {
+		apierr.Send(w, "Node "+s.ID+" has not been identified yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		apierr.Send(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	nonce := hex.EncodeToString(b[:])
+	job, err := uploadJobs.create("", -1)
+	if err != nil {
+		apierr.Send(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// A job with a file name counts as in flight, so the garbage collector
+	// leaves the file alone on the node until it is reported.
+	job.mu.Lock()
+	job.Filename = filename
+	job.Bucket = bucket
+	job.user = signedInUser(r)
+	job.mu.Unlock()
+
+	expires := time.Now().Add(signedURLTTL).Truncate(time.Second)
+	directUploads.add(nonce, pendingUpload{file: filename, node: s.ID, nodeUUID: info.ID, bucket: bucket, client: client, job: job, expires: expires})
+	v := url.Values{}
+	v.Set("name", filename)
+	v.Set("nonce", nonce)
+	v.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	v.Set("sig", signUpload(info.ID, filename, nonce, expires.Unix()))
+
+	fmt.Println("Direct upload of", filename, "to", s.ID, "as job", job.ID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(DirectUpload{
+		UploadID: job.ID,
+		File:     filename,
+		Node:     s.ID,
+		URL:      s.URL + "/upload/direct?" + v.Encode(),
+		Expires:  expires.UTC(),
+	})
+}
+
+// directCompleteHandler is called by a node that stored a direct upload:
+// POST /api/v1/uploads/direct/complete. Like the other node callbacks it
+// needs the registration token. The file is copied and replicated in the
+// background; the upload job says how that goes.
+func directCompleteHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkRegistrationToken(r) {
+		apierr.Send(w, "Invalid registration token", http.StatusUnauthorized)
+		return
+	}
+	var req struct {
+		ID       string `json:"id"`
+		NodeUUID string `json:"node_uuid"`
+		File     string `json:"file"`
+		Nonce    string `json:"nonce"`
+		Size     int64  `json:"size"`
+		Checksum string `json:"checksum"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		apierr.Send(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	p, ok := directUploads.take(req.Nonce, req.File, req.NodeUUID)
+	if !ok {
+		apierr.Send(w, "No upload URL pending for this node and file", http.StatusNotFound)
+		return
+	}
+	s, ok := topo.get(p.node)
+	if !ok {
+		p.job.finish(errors.New("node " + p.node + " is no longer registered"))
+		apierr.Send(w, "Node not registered", http.StatusNotFound)
+		return
+	}
+
+	goBackground(func() { completeDirectUpload(p, s, req.Size, req.Checksum) })
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"upload_id": p.job.ID})
+}
+
+// completeDirectUpload copies a direct upload from origin, which counts as
+// its first replica, into the upload dir and to the rest of its targets at
+// once, as storeUpload does for a file streaming in from a client. Should
+// the file have been written again on origin since it reported size and
+// sum, the copy taken is the newer one.
+func completeDirectUpload(p pendingUpload, origin StorageServer, size int64, sum string) {
+	job := p.job
+	job.mu.Lock()
+	job.BytesTotal = size
+	job.replaces = false
+	if _, err := os.Stat(filepath.Join(uploadDir, p.file)); err == nil {
+		job.replaces = true
+	}
+	job.mu.Unlock()
+	rp := job.startReplica(origin.ID, size)
+	rp.sent.Store(size)
+	job.finishReplica(rp, nil)
+
+	var targets []StorageServer
+	for _, s := range replicaTargets(p.file, p.client) {
+		if s.ID != origin.ID {
+			targets = append(targets, s)
+		}
+	}
+	if n := int(replicationFactor.Load()) - 1; len(targets) > n {
+		targets = targets[:max(n, 0)]
+	}
+	replicator, _ := replicatorFor(p.bucket)
+	oldParts, _ := manifests.forget(p.file)
+	var stream []StorageServer
+	if streams(replicator) {
+		stream = targets
+	}
+	pl := newPayload(filepath.Join(uploadDir, p.file), size, stream)
+	pl.alg = checksum.Algorithm(sum)
+	pl.spares = spareNodes(append(targets, origin), p.client)
+	job.setStatus(uploadReplicating)
+	ctx, cancel := pl.afterReceive(context.Background(), uploadTimeout)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		if len(targets) == 0 {
+			<-pl.done
+			job.finish(pl.err)
+			done <- pl.err
+			return
+		}
+		err := replicator.Replicate(ctx, job, p.file, pl, targets)
+		pl.releaseUntaken()
+		done <- err
+	}()
+
+	var body io.ReadCloser
+	err := callNode(ctx, origin, opDownload, func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, nodeFileURL(origin, p.file), nil)
+		if err != nil {
+			return err
+		}
+		resp, err := nodeClient.Do(req)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return &statusError{Status: resp.StatusCode}
+		}
+		body = resp.Body
+		return nil
+	})
+	if err == nil {
+		err = pl.receive(&countingReader{r: body, n: &job.received})
+		body.Close()
+	} else {
+		pl.receive(errReader{err})
+	}
+	if err != nil {
+		<-done
+		if oldParts.File != "" {
+			manifests.set(oldParts)
+		}
+		fmt.Println("Direct upload", p.file, "from", origin.ID, "not taken in:", err)
+		job.finish(err)
+		return
+	}
+	recordUpload(p.file, pl.size, pl.sum)
+	if err := <-done; err != nil && !errors.Is(err, errPartialReplication) {
+		fmt.Println("Direct upload", p.file, "not replicated:", err)
+	}
+	if len(oldParts.Parts) > 0 {
+		deleteParts(context.Background(), p.file, oldParts.Parts)
+	}
+	lockForBucket(context.Background(), p.file, p.bucket)
+	retentionFiles.uploaded(p.file, p.bucket)
+	queueTranscode(p.file)
+	job.setProcessing(queuePostUpload(p.file))
+	fmt.Println("Direct upload", p.file, "taken in from", origin.ID)
+}
+
+// errReader fails every read, to end a payload that gets no body.
+type errReader struct{ err error }
+
+func (e errReader) Read([]byte) (int, error) { return 0, e.err }
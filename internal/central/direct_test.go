@@ -0,0 +1,97 @@
+package central
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/storage"
+)
+
+func TestDirectUpload(t *testing.T) {
+	c := newTestCluster(t, 3, func(cfg *Config) {
+		cfg.SigningKey = "s3cret"
+		cfg.ReplicationFactor = 2
+	})
+	for _, n := range c.nodes {
+		cfg := n.cfg
+		cfg.SigningKey = []byte("s3cret")
+		cfg.CentralURL = c.central.URL
+		s, err := storage.NewServer(cfg, n.backend)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := s.Handler()
+		n.handler.Store(&h)
+	}
+
+	resp, err := testClient.Post(c.central.URL+"/api/v1/uploads/direct?name=big.bin&"+nearLondon.Encode(), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var d DirectUpload
+	json.NewDecoder(resp.Body).Decode(&d)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || d.Node != "ldn" {
+		t.Fatalf("mint = %d %+v, want a URL on ldn", resp.StatusCode, d)
+	}
+
+	post := func(u, name string) *http.Response {
+		t.Helper()
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		fw, _ := mw.CreateFormFile("file", name)
+		io.WriteString(fw, "straight to the node")
+		mw.Close()
+		resp, err := testClient.Post(u, mw.FormDataContentType(), &body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	if resp := post(d.URL, "other.bin"); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("upload of another name = %d, want 403", resp.StatusCode)
+	}
+	if resp := post(d.URL, "big.bin"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("direct upload = %d", resp.StatusCode)
+	}
+	if resp := post(d.URL, "big.bin"); resp.StatusCode != http.StatusConflict {
+		t.Errorf("second use of the URL = %d, want 409", resp.StatusCode)
+	}
+
+	var job uploadResult
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		_, body := c.get("/api/v1/uploads/"+d.UploadID+"/progress", nil)
+		json.Unmarshal([]byte(body), &job)
+		if job.Status != uploadReplicating && job.Status != uploadReceiving {
+			break
+		}
+	}
+	if job.Status != uploadDone || len(job.Replicas) != 2 {
+		t.Fatalf("job = %+v, want done with 2 replicas", job)
+	}
+	if b, err := os.ReadFile(filepath.Join(uploadDir, "big.bin")); string(b) != "straight to the node" {
+		t.Errorf("central copy = %q %v", b, err)
+	}
+	if got := c.holders("big.bin"); len(got) != 2 || !slices.Contains(got, "ldn") {
+		t.Errorf("held by %v, want ldn and one more", got)
+	}
+
+	// A node cannot complete an upload it was not sent.
+	req, _ := http.NewRequest("POST", c.central.URL+"/api/v1/uploads/direct/complete",
+		bytes.NewReader([]byte(`{"id":"ldn","node_uuid":"x","file":"big.bin","nonce":"n"}`)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+testRegistrationToken)
+	resp, _ = testClient.Do(req)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown completion = %d, want 404", resp.StatusCode)
+	}
+}
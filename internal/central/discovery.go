@@ -0,0 +1,85 @@
+package central
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ---------------------------
+// DNS SRV Discovery
+// ---------------------------
+
+// discoverSRV resolves an SRV name (e.g. "_storage._tcp.example.com") into
+// storage nodes. Each target is asked for /info to learn its name and
+// coordinates. Targets that don't answer keep their previous entry, if any,
+// so a node that is briefly down doesn't vanish from the topology.
+func discoverSRV(name string, previous []StorageServer) ([]StorageServer, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, addrs, err := net.DefaultResolver.LookupSRV(ctx, "", "", name)
+	if err != nil {
+		return nil, err
+	}
+
+	scheme := "http"
+	if strings.HasPrefix(name, "_https.") {
+		scheme = "https"
+	}
+
+	byURL := map[string]StorageServer{}
+	for _, s := range previous {
+		byURL[s.URL] = s
+	}
+
+	var nodes []StorageServer
+	for _, a := range addrs {
+		host := strings.TrimSuffix(a.Target, ".")
+		u := scheme + "://" + net.JoinHostPort(host, strconv.Itoa(int(a.Port)))
+
+		info, err := fetchNodeInfo(probeClient, StorageServer{URL: u})
+		if err != nil {
+			if prev, ok := byURL[u]; ok {
+				nodes = append(nodes, prev)
+			} else {
+				fmt.Println("Discovered node", u, "is not answering:", err)
+			}
+			continue
+		}
+
+		id := info.Name
+		if id == "" {
+			id = net.JoinHostPort(host, strconv.Itoa(int(a.Port)))
+		}
+		nodes = append(nodes, StorageServer{
+			ID:    id,
+			Label: id,
+			URL:   u,
+			Lat:   info.Lat,
+			Lon:   info.Lon,
+		})
+	}
+	return nodes, nil
+}
+
+// discoveryLoop refreshes the discovered nodes periodically. Lookup failures
+// keep the last known set instead of emptying the topology.
+func discoveryLoop(name string, interval time.Duration) {
+	var current []StorageServer
+	for {
+		nodes, err := discoverSRV(name, current)
+		if err != nil {
+			fmt.Println("SRV lookup", name, "failed:", err)
+		} else {
+			if len(nodes) != len(current) {
+				fmt.Printf("SRV %s: %d storage nodes\n", name, len(nodes))
+			}
+			current = nodes
+			topo.setDiscovered(nodes)
+		}
+		time.Sleep(interval)
+	}
+}
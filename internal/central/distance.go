@@ -0,0 +1,297 @@
+package central
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+)
+
+// ---------------------------
+// Distance Models
+// ---------------------------
+
+// clientRef is what routing knows about a client: where it is and which
+// network it is on. Local is set for clients on the central API's own
+// network that did not say where they are.
+type clientRef struct {
+	Lat, Lon float64
+	Network  string // "" when the IP is unknown
+	Local    bool
+}
+
+// locateClient resolves the client of r (see clientLocation).
+func locateClient(r *http.Request) (clientRef, error) {
+	lat, lon, err := clientLocation(r)
+	if err != nil {
+		return clientRef{}, err
+	}
+	c := clientRef{Lat: lat, Lon: lon}
+	if ip := net.ParseIP(getClientIP(r)); ip != nil {
+		c.Network = clientNetwork(ip)
+		q := r.URL.Query()
+		explicit := q.Get("lat") != "" || q.Get("lon") != "" || r.Header.Get("X-Geolocation") != ""
+		c.Local = !explicit && (ip.IsLoopback() || ip.IsPrivate())
+	}
+	return c, nil
+}
+
+// clientNetwork groups clients that share a route to the nodes: the /24 of
+// an IPv4 address, the /48 of an IPv6 one.
+func clientNetwork(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String() + "/24"
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String() + "/48"
+}
+
+// DistanceModel scores how far a node is from a client; routing and
+// placement prefer lower scores. Unit labels the scores for display.
+type DistanceModel interface {
+	Distance(c clientRef, s StorageServer) float64
+	Unit() string
+}
+
+func newDistanceModel(name string) (DistanceModel, error) {
+	switch name {
+	case "", "geo":
+		return geoDistance{}, nil
+	case "latency":
+		return latencyDistance{}, nil
+	}
+	return nil, fmt.Errorf("unknown distance_model %q (want geo or latency)", name)
+}
+
+// geoDistance is the great-circle distance in km.
+type geoDistance struct{}
+
+func (geoDistance) Distance(c clientRef, s StorageServer) float64 {
+	return haversineKm(c.Lat, c.Lon, s.Lat, s.Lon)
+}
+
+func (geoDistance) Unit() string { return "km" }
+
+// latencyDistance is the round trip time in ms: the median of recent
+// samples the client's network reported for the node, else (for clients
+// next to the central API) what the central API measures to it, else an
+// estimate from the geographic distance.
+type latencyDistance struct{}
+
+func (latencyDistance) Distance(c clientRef, s StorageServer) float64 {
+	if ms, ok := latencies.fromClient(c.Network, s.ID); ok {
+		return ms
+	}
+	if c.Local {
+		if ms, ok := latencies.fromCentral(s.ID); ok {
+			return ms
+		}
+	}
+	return estimatedRTT(haversineKm(c.Lat, c.Lon, s.Lat, s.Lon))
+}
+
+func (latencyDistance) Unit() string { return "ms" }
+
+// estimatedRTT guesses the round trip over km of fiber: light covers about
+// 100 km per ms there and back, routes are rarely straight, and every
+// request pays some fixed cost.
+func estimatedRTT(km float64) float64 {
+	return 5 + 1.5*km/100
+}
+
+// ---------------------------
+// Latency Samples
+// ---------------------------
+
+// Samples older than latencyTTL are ignored; each pair keeps its last
+// latencyKeep, and at most latencyNetworks client networks are tracked.
+var (
+	latencyTTL      = 10 * time.Minute
+	latencyKeep     = 16
+	latencyNetworks = 10000
+)
+
+type rttSamples struct {
+	ms   []float64
+	last time.Time
+}
+
+func (r *rttSamples) add(ms float64) {
+	r.ms = append(r.ms, ms)
+	if len(r.ms) > latencyKeep {
+		r.ms = r.ms[len(r.ms)-latencyKeep:]
+	}
+	r.last = time.Now()
+}
+
+func (r *rttSamples) median() (float64, bool) {
+	if r == nil || len(r.ms) == 0 || time.Since(r.last) > latencyTTL {
+		return 0, false
+	}
+	sorted := append([]float64(nil), r.ms...)
+	sort.Float64s(sorted)
+	return sorted[len(sorted)/2], true
+}
+
+type latencyMatrix struct {
+	mu      sync.Mutex
+	central map[string]*rttSamples            // by node ID
+	clients map[string]map[string]*rttSamples // by client network, then node ID
+}
+
+var latencies = newLatencyMatrix()
+
+func newLatencyMatrix() *latencyMatrix {
+	return &latencyMatrix{central: map[string]*rttSamples{}, clients: map[string]map[string]*rttSamples{}}
+}
+
+// pingNode times a round trip to s's /ping. Run right after another probe
+// it reuses that connection, so it measures the network and not a
+// handshake.
+func pingNode(s StorageServer) (time.Duration, error) {
+	start := time.Now()
+	resp, err := probeClient.Get(s.URL + "/ping")
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, &statusError{Status: resp.StatusCode}
+	}
+	return time.Since(start), nil
+}
+
+func (m *latencyMatrix) observeCentral(nodeID string, rtt time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.central[nodeID] == nil {
+		m.central[nodeID] = &rttSamples{}
+	}
+	m.central[nodeID].add(float64(rtt) / float64(time.Millisecond))
+}
+
+func (m *latencyMatrix) observeClient(network, nodeID string, ms float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	nodes := m.clients[network]
+	if nodes == nil {
+		if len(m.clients) >= latencyNetworks {
+			m.evictOldestLocked()
+		}
+		nodes = map[string]*rttSamples{}
+		m.clients[network] = nodes
+	}
+	if nodes[nodeID] == nil {
+		nodes[nodeID] = &rttSamples{}
+	}
+	nodes[nodeID].add(ms)
+}
+
+// evictOldestLocked forgets the client network heard from least recently.
+func (m *latencyMatrix) evictOldestLocked() {
+	var oldest string
+	var at time.Time
+	for network, nodes := range m.clients {
+		var last time.Time
+		for _, r := range nodes {
+			if r.last.After(last) {
+				last = r.last
+			}
+		}
+		if oldest == "" || last.Before(at) {
+			oldest, at = network, last
+		}
+	}
+	delete(m.clients, oldest)
+}
+
+func (m *latencyMatrix) fromCentral(nodeID string) (float64, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.central[nodeID].median()
+}
+
+func (m *latencyMatrix) fromClient(network, nodeID string) (float64, bool) {
+	if network == "" {
+		return 0, false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.clients[network][nodeID].median()
+}
+
+// snapshot returns the recent medians, by node ID, from the central API and
+// from every client network.
+func (m *latencyMatrix) snapshot() (map[string]float64, map[string]map[string]float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	central := map[string]float64{}
+	for id, r := range m.central {
+		if ms, ok := r.median(); ok {
+			central[id] = ms
+		}
+	}
+	clients := map[string]map[string]float64{}
+	for network, nodes := range m.clients {
+		for id, r := range nodes {
+			if ms, ok := r.median(); ok {
+				if clients[network] == nil {
+					clients[network] = map[string]float64{}
+				}
+				clients[network][id] = ms
+			}
+		}
+	}
+	return central, clients
+}
+
+// latencyHandler takes round trip times a client measured to the nodes'
+// /ping, POST /api/v1/latency {"samples": {"<node id>": <ms>, ...}}, and
+// serves the matrix of recent medians, in ms, on GET.
+func latencyHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		central, clients := latencies.snapshot()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Central map[string]float64            `json:"central"`
+			Clients map[string]map[string]float64 `json:"clients"`
+		}{central, clients})
+	case http.MethodPost:
+		var req struct {
+			Samples map[string]float64 `json:"samples"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+			apierr.Send(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		ip := net.ParseIP(getClientIP(r))
+		if ip == nil {
+			apierr.Send(w, "Cannot tell the client's network", http.StatusBadRequest)
+			return
+		}
+		network := clientNetwork(ip)
+		known := map[string]bool{}
+		for _, s := range topo.nodes() {
+			known[s.ID] = true
+		}
+		accepted := 0
+		for id, ms := range req.Samples {
+			// A minute is not a latency, it is an outage.
+			if known[id] && ms > 0 && ms < 60000 {
+				latencies.observeClient(network, id, ms)
+				accepted++
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"network": network, "accepted": accepted})
+	default:
+		apierr.Send(w, "Use GET or POST", http.StatusMethodNotAllowed)
+	}
+}
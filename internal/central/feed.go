@@ -0,0 +1,296 @@
+package central
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/checksum"
+)
+
+// ---------------------------
+// Change Feed
+// ---------------------------
+
+// Every change to the central copies is appended to a feed with
+// consecutive IDs, so downstream replication and indexing can tail it from
+// where they left off and see every change exactly in order. The feed is
+// kept as JSON lines in the data dir, synced on every append, and survives
+// restarts; the most recent events are also kept in memory for tailing.
+const (
+	changeUpload = "upload"
+	changeDelete = "delete"
+)
+
+// ChangeEvent is one entry of the change feed.
+type ChangeEvent struct {
+	ID       int64     `json:"id"`
+	Time     time.Time `json:"time"`
+	Type     string    `json:"type"`
+	Name     string    `json:"name"`
+	Size     int64     `json:"size,omitempty"`
+	Checksum string    `json:"checksum,omitempty"`
+}
+
+// Events kept in memory; older ones are read back from the file.
+var feedMemory = 4096
+
+// errFeedTruncated means events before the ones asked for are gone, which
+// only happens to a feed kept in memory.
+var errFeedTruncated = errors.New("events before this offset are no longer kept")
+
+type changeFeed struct {
+	mu     sync.Mutex
+	file   *os.File // nil when the feed is kept in memory only
+	path   string
+	lastID int64
+	recent []ChangeEvent
+	wake   chan struct{} // closed on every append
+	err    error         // of the last write to file, nil once one succeeds
+}
+
+var feed = newChangeFeed()
+
+func newChangeFeed() *changeFeed {
+	return &changeFeed{wake: make(chan struct{})}
+}
+
+// open loads the feed kept at path and appends to it from now on. A last
+// line cut short by a crash is dropped.
+func (f *changeFeed) open(path string) error {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	var good int64
+	var last int64
+	var recent []ChangeEvent
+	err = scanFeed(file, func(ev ChangeEvent, end int64) bool {
+		good, last = end, ev.ID
+		recent = append(recent, ev)
+		if len(recent) > feedMemory {
+			recent = recent[1:]
+		}
+		return true
+	})
+	if err == nil {
+		err = file.Truncate(good)
+	}
+	if err == nil {
+		_, err = file.Seek(good, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.file, f.path, f.lastID, f.recent = file, path, last, recent
+	return nil
+}
+
+// scanFeed calls fn with every complete event in r and the offset just past
+// it, until fn returns false.
+func scanFeed(r io.Reader, fn func(ev ChangeEvent, end int64) bool) error {
+	br := bufio.NewReader(r)
+	var off int64
+	for {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			return nil // a partial last line is not an event yet
+		}
+		if err != nil {
+			return err
+		}
+		off += int64(len(line))
+		var ev ChangeEvent
+		if err := json.Unmarshal(bytes.TrimSpace(line), &ev); err != nil {
+			return fmt.Errorf("bad event at offset %d: %w", off-int64(len(line)), err)
+		}
+		if !fn(ev, off) {
+			return nil
+		}
+	}
+}
+
+// append numbers ev and adds it to the feed.
+func (f *changeFeed) append(ev ChangeEvent) (ChangeEvent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ev.ID = f.lastID + 1
+	ev.Time = time.Now().UTC()
+	if f.file != nil {
+		b, _ := json.Marshal(ev)
+		_, err := f.file.Write(append(b, '\n'))
+		if err == nil {
+			err = f.file.Sync()
+		}
+		if f.err = err; err != nil {
+			return ev, err
+		}
+	}
+	f.lastID = ev.ID
+	f.recent = append(f.recent, ev)
+	if len(f.recent) > feedMemory {
+		f.recent = f.recent[len(f.recent)-feedMemory:]
+	}
+	close(f.wake)
+	f.wake = make(chan struct{})
+	return ev, nil
+}
+
+// read returns up to limit events starting at ID from, and a channel that
+// is closed when the next event is appended.
+func (f *changeFeed) read(from int64, limit int) ([]ChangeEvent, <-chan struct{}, error) {
+	f.mu.Lock()
+	wake := f.wake
+	inMemory := len(f.recent) == 0 || from >= f.recent[0].ID
+	if inMemory || f.file == nil {
+		defer f.mu.Unlock()
+		if !inMemory {
+			return nil, wake, errFeedTruncated
+		}
+		var out []ChangeEvent
+		for _, ev := range f.recent {
+			if ev.ID >= from && len(out) < limit {
+				out = append(out, ev)
+			}
+		}
+		return out, wake, nil
+	}
+	path := f.path
+	f.mu.Unlock()
+
+	// Appends only ever add whole lines past what is read here.
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, wake, err
+	}
+	defer file.Close()
+	var out []ChangeEvent
+	err = scanFeed(file, func(ev ChangeEvent, _ int64) bool {
+		if ev.ID >= from {
+			out = append(out, ev)
+		}
+		return len(out) < limit
+	})
+	return out, wake, err
+}
+
+// check reports whether the feed can still be written: a feed kept in a
+// file needs the file, and the last write to it must have worked.
+func (f *changeFeed) check() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	if f.err != nil {
+		return f.err
+	}
+	_, err := f.file.Stat()
+	return err
+}
+
+func (f *changeFeed) last() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.lastID
+}
+
+// recordUpload and recordDelete note a change to the central copies, for
+// ?since= listings and the change feed.
+func recordUpload(name string, size int64, sum string) {
+	discardTrashed(name)
+	fileAlgorithms.set(name, checksum.Algorithm(sum))
+	fileChanges.Record(name)
+	indexFile(name)
+	if _, err := feed.append(ChangeEvent{Type: changeUpload, Name: name, Size: size, Checksum: sum}); err != nil {
+		fmt.Println("Change feed append failed:", err)
+	}
+}
+
+func recordDelete(name string) {
+	fileChanges.Remove(name)
+	goBackground(func() { unindexFile(name) })
+	downloads.forget(name)
+	retentionFiles.forget(name)
+	fileAlgorithms.forget(name)
+	if _, err := feed.append(ChangeEvent{Type: changeDelete, Name: name}); err != nil {
+		fmt.Println("Change feed append failed:", err)
+	}
+}
+
+// changesHandler tails the change feed: GET /api/v1/changes?from=<id>
+// returns the events from that ID on (at most limit), and next, the ID to
+// ask from next time. With wait=<duration> (at most a minute) it holds the
+// request until there is at least one event.
+func changesHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	from, limit := int64(1), 1000
+	var wait time.Duration
+	var err error
+	if v := q.Get("from"); v != "" {
+		if from, err = strconv.ParseInt(v, 10, 64); err != nil || from < 1 {
+			apierr.Send(w, "Invalid from", http.StatusBadRequest)
+			return
+		}
+	}
+	// limit and wait are checked by requestRules.
+	if v := q.Get("limit"); v != "" {
+		limit, _ = strconv.Atoi(v)
+	}
+	if v := q.Get("wait"); v != "" {
+		wait, _ = time.ParseDuration(v)
+	}
+
+	deadline := time.NewTimer(wait)
+	defer deadline.Stop()
+	var events []ChangeEvent
+	for {
+		var wake <-chan struct{}
+		events, wake, err = feed.read(from, limit)
+		if err != nil || len(events) > 0 || wait == 0 {
+			break
+		}
+		select {
+		case <-wake:
+			continue
+		case <-deadline.C:
+		case <-r.Context().Done():
+		}
+		break
+	}
+	switch {
+	case errors.Is(err, errFeedTruncated):
+		apierr.Send(w, err.Error(), http.StatusGone)
+		return
+	case err != nil:
+		apierr.Send(w, "Cannot read change feed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	next := from
+	if len(events) > 0 {
+		next = events[len(events)-1].ID + 1
+	}
+	if events == nil {
+		events = []ChangeEvent{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Events []ChangeEvent `json:"events"`
+		Next   int64         `json:"next"`
+		LastID int64         `json:"last_id"`
+	}{events, next, feed.last()})
+}
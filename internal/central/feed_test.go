@@ -0,0 +1,162 @@
+package central
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/storage"
+)
+
+func TestChangeFeedSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "changes.jsonl")
+	f := newChangeFeed()
+	if err := f.open(path); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		f.append(ChangeEvent{Type: changeUpload, Name: name})
+	}
+	// A crash in the middle of an append.
+	out, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	out.WriteString(`{"id":4,"type":"up`)
+	out.Close()
+
+	defer func(n int) { feedMemory = n }(feedMemory)
+	feedMemory = 2
+	f = newChangeFeed()
+	if err := f.open(path); err != nil {
+		t.Fatal(err)
+	}
+	if f.last() != 3 {
+		t.Fatalf("last = %d after reopening, want 3", f.last())
+	}
+	if ev, _ := f.append(ChangeEvent{Type: changeDelete, Name: "a"}); ev.ID != 4 {
+		t.Errorf("next ID = %d, want 4", ev.ID)
+	}
+
+	// From 1 is past what is kept in memory, so it comes from the file.
+	events, _, err := f.read(1, 10)
+	if err != nil || len(events) != 4 {
+		t.Fatalf("read = %v, %v", events, err)
+	}
+	for i, ev := range events {
+		if ev.ID != int64(i+1) {
+			t.Errorf("event %d has ID %d", i, ev.ID)
+		}
+	}
+	if events[3].Type != changeDelete || events[3].Name != "a" {
+		t.Errorf("last event = %+v", events[3])
+	}
+}
+
+func TestClusterChangeFeed(t *testing.T) {
+	c := newTestCluster(t, 1, nil)
+	c.upload("a.txt", "a", nearLondon)
+	c.delete("a.txt")
+
+	type page struct {
+		Events []ChangeEvent `json:"events"`
+		Next   int64         `json:"next"`
+	}
+	var p page
+	_, body := c.get("/api/v1/changes?from=1", nil)
+	json.Unmarshal([]byte(body), &p)
+	if len(p.Events) != 2 || p.Events[0].Type != changeUpload || p.Events[0].Checksum != "ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb" ||
+		p.Events[1].Type != changeDelete || p.Next != 3 {
+		t.Fatalf("changes = %s", body)
+	}
+
+	// A waiting tail gets the next change as soon as it is made.
+	got := make(chan page, 1)
+	go func() {
+		resp, err := testClient.Get(c.central.URL + "/api/v1/changes?wait=5s&from=" + strconv.FormatInt(p.Next, 10))
+		if err != nil {
+			got <- page{}
+			return
+		}
+		defer resp.Body.Close()
+		var p page
+		json.NewDecoder(resp.Body).Decode(&p)
+		got <- p
+	}()
+	time.Sleep(50 * time.Millisecond)
+	c.upload("b.txt", "b", nearLondon)
+	select {
+	case p := <-got:
+		if len(p.Events) != 1 || p.Events[0].Name != "b.txt" || p.Events[0].ID != 3 {
+			t.Errorf("tail = %+v", p)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("waiting tail did not return")
+	}
+}
+
+// A node following the feed copies new files from its peers and drops
+// deleted ones, starting from wherever its cursor says.
+func TestClusterNodeFollowsFeed(t *testing.T) {
+	c := newTestCluster(t, 2, nil)
+	if resp, _ := c.upload("early.txt", "before the follower", nearLondon); resp.StatusCode != 200 {
+		t.Fatalf("upload: %d", resp.StatusCode)
+	}
+
+	dir := t.TempDir()
+	cfg := storage.DefaultConfig("0", "london")
+	cfg.IdentityPath = filepath.Join(dir, "node.json")
+	cfg.FeedCursorPath = filepath.Join(dir, "cursor.json")
+	cfg.CentralURL = c.central.URL
+	cfg.FollowFeed = true
+	backend, err := storage.NewLocalBackend(filepath.Join(dir, "files"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := storage.NewServer(cfg, backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		s.FollowLoop(stop)
+		close(done)
+	}()
+	defer func() { close(stop); <-done }()
+
+	has := func(name string) bool {
+		_, err := backend.Stat(name)
+		return err == nil
+	}
+	waitFor := func(what string, ok func() bool) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); !ok(); time.Sleep(10 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatal("timed out waiting for", what)
+			}
+		}
+	}
+
+	waitFor("early.txt", func() bool { return has("early.txt") })
+	c.upload("late.txt", "after it started", nearLondon)
+	waitFor("late.txt", func() bool { return has("late.txt") })
+	rc, _, err := backend.Get("late.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(b) != "after it started" {
+		t.Fatalf("late.txt = %q", b)
+	}
+
+	c.delete("early.txt")
+	waitFor("early.txt to go", func() bool { return !has("early.txt") })
+
+	want := `{"next":` + strconv.FormatInt(feed.last()+1, 10) + `}`
+	waitFor("the cursor to be saved", func() bool {
+		cursor, _ := os.ReadFile(cfg.FeedCursorPath)
+		return string(cursor) == want
+	})
+}
@@ -0,0 +1,246 @@
+package central
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/checksum"
+)
+
+// ---------------------------
+// File Info
+// ---------------------------
+
+// RemoteFile mirrors an entry of a storage node's /files listing.
+type RemoteFile struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mod_time"`
+	Checksum string    `json:"checksum"`
+	MimeType string    `json:"mime_type"`
+}
+
+// FileListing is one row of the central listing, aggregated across replicas.
+type FileListing struct {
+	Name       string            `json:"name"`
+	Path       string            `json:"path,omitempty"` // /u/<user>/<file> in a home
+	Size       int64             `json:"size"`
+	ModTime    time.Time         `json:"mod_time"`
+	Checksum   string            `json:"checksum,omitempty"`
+	Algorithm  string            `json:"checksum_algorithm,omitempty"` // the file was stored with
+	MimeType   string            `json:"mime_type,omitempty"`
+	Consistent bool              `json:"consistent"`
+	Replica    map[string]bool   `json:"replicas"`
+	Checksums  map[string]string `json:"replica_checksums"`
+	ReplicaURL map[string]string `json:"-"`
+	Composite  string            `json:"composite,omitempty"` // the manifest's kind, if stored as parts
+	Parts      int               `json:"parts,omitempty"`
+
+	Quarantined bool `json:"quarantined,omitempty"`
+	Private     bool `json:"private,omitempty"`
+
+	// Set in listings asked for ?since=, as in the nodes' listings.
+	Seq     int64 `json:"seq,omitempty"`
+	Deleted bool  `json:"deleted,omitempty"`
+}
+
+// fetchNodeFiles lists a node's files, retrying transient failures.
+func fetchNodeFiles(ctx context.Context, s StorageServer) ([]RemoteFile, error) {
+	var list []RemoteFile
+	err := callNode(ctx, s, opList, func(ctx context.Context) error {
+		var err error
+		list, err = listNodeFiles(ctx, s)
+		return err
+	})
+	return list, err
+}
+
+func listNodeFiles(ctx context.Context, s StorageServer) ([]RemoteFile, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL+"/files", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := nodeClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &statusError{Status: resp.StatusCode}
+	}
+	var list []RemoteFile
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// majorityChecksum picks the checksum most replicas agree on and reports
+// whether all of them agree.
+func majorityChecksum(sums map[string]string) (string, bool) {
+	counts := map[string]int{}
+	best := ""
+	for _, sum := range sums {
+		counts[sum]++
+		if counts[sum] > counts[best] || (counts[sum] == counts[best] && sum < best) {
+			best = sum
+		}
+	}
+	return best, len(counts) <= 1
+}
+
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func shortSum(sum string) string {
+	if len(sum) > 12 {
+		return sum[:12]
+	}
+	return sum
+}
+
+// ReplicaStat is one node's view of a file in a stat response.
+type ReplicaStat struct {
+	Node     string `json:"node"`
+	URL      string `json:"url"`
+	Exists   bool   `json:"exists"`
+	Size     int64  `json:"size,omitempty"`
+	Checksum string `json:"checksum,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// FileStat describes a file across the central copy and all replicas.
+type FileStat struct {
+	Name       string        `json:"name"`
+	Exists     bool          `json:"exists"`
+	Size       int64         `json:"size"`
+	ModTime    time.Time     `json:"mod_time,omitzero"`
+	Checksum   string        `json:"checksum,omitempty"`
+	Algorithm  string        `json:"checksum_algorithm,omitempty"` // the file was stored with
+	MimeType   string        `json:"mime_type,omitempty"`
+	Consistent bool          `json:"consistent"`
+	Replicas   []ReplicaStat `json:"replicas"`
+
+	Downloads *DownloadCount `json:"downloads,omitempty"`
+}
+
+// statFile HEADs every node in parallel; no file bytes are transferred.
+func statFile(ctx context.Context, filename string) FileStat {
+	st := FileStat{Name: filename}
+	if c, ok := downloads.get(filename); ok {
+		st.Downloads = &c
+	}
+	if fi, err := os.Stat(filepath.Join(uploadDir, filename)); err == nil && !fi.IsDir() {
+		st.Exists = true
+		st.Size = fi.Size()
+		st.ModTime = fi.ModTime().UTC()
+	}
+
+	nodes := topo.nodes()
+	st.Replicas = make([]ReplicaStat, len(nodes))
+	var wg sync.WaitGroup
+	for i, s := range nodes {
+		wg.Add(1)
+		go func(i int, s StorageServer) {
+			defer wg.Done()
+			rs := ReplicaStat{Node: s.ID, URL: s.URL}
+			h, ok := headReplica(ctx, s, filename)
+			switch {
+			case ok:
+				rs.Exists = true
+				rs.Checksum = h.Get("X-Checksum")
+				if rs.Checksum == "" {
+					rs.Checksum = h.Get("X-Checksum-Sha256")
+				}
+				rs.Size, _ = strconv.ParseInt(h.Get("Content-Length"), 10, 64)
+			case h == nil:
+				rs.Error = "unreachable"
+			}
+			st.Replicas[i] = rs
+		}(i, s)
+	}
+	wg.Wait()
+
+	sums := map[string]string{}
+	for _, rs := range st.Replicas {
+		if !rs.Exists {
+			continue
+		}
+		sums[rs.Node] = rs.Checksum
+		if !st.Exists {
+			st.Exists = true
+			st.Size = rs.Size
+		}
+	}
+	st.Checksum, st.Consistent = majorityChecksum(sums)
+	if st.Exists {
+		st.Algorithm = fileAlgorithms.of(filename)
+		st.MimeType = mime.TypeByExtension(filepath.Ext(filename))
+	}
+	return st
+}
+
+// Stat a file across the cluster as JSON
+func statHandler(w http.ResponseWriter, r *http.Request) {
+	st := statFile(r.Context(), filepath.Base(r.PathValue("name")))
+
+	w.Header().Set("Content-Type", "application/json")
+	if !st.Exists {
+		w.WriteHeader(http.StatusNotFound)
+	}
+	json.NewEncoder(w).Encode(st)
+}
+
+// headFileHandler answers HEAD /files/{name} with the cluster-wide view in
+// headers, so clients can check a file without a JSON round trip.
+func headFileHandler(w http.ResponseWriter, r *http.Request) {
+	st := statFile(r.Context(), filepath.Base(r.PathValue("name")))
+	if !st.Exists {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var replicas []string
+	for _, rs := range st.Replicas {
+		if rs.Exists {
+			replicas = append(replicas, rs.Node)
+		}
+	}
+
+	h := w.Header()
+	h.Set("Content-Length", strconv.FormatInt(st.Size, 10))
+	if st.MimeType != "" {
+		h.Set("Content-Type", st.MimeType)
+	}
+	if !st.ModTime.IsZero() {
+		h.Set("Last-Modified", st.ModTime.Format(http.TimeFormat))
+	}
+	if st.Checksum != "" {
+		h.Set("ETag", `"`+st.Checksum+`"`)
+		h.Set("X-Checksum", st.Checksum)
+		if checksum.Algorithm(st.Checksum) == checksum.SHA256 {
+			h.Set("X-Checksum-Sha256", st.Checksum)
+		}
+	}
+	h.Set("X-Replicas", strings.Join(replicas, ","))
+	w.WriteHeader(http.StatusOK)
+}
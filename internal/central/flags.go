@@ -0,0 +1,113 @@
+package central
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// ---------------------------
+// Feature Flags
+// ---------------------------
+
+// Risky behaviors ship behind a flag so each deployment can turn them on
+// when it is ready for them. Flags are set in Config.Features, or FEATURES
+// ("a,b,-c" turns a and b on and c off), change with a config reload, and
+// are listed at GET /api/v1/features.
+
+type featureFlag struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Default     bool   `json:"default"`
+}
+
+const (
+	featureConsistentHashing  = "consistent_hashing"
+	featureLatencyRouting     = "latency_routing"
+	featureClientWriteConcern = "client_write_concern"
+	featureDirectRepair       = "direct_repair"
+)
+
+var featureFlags = []featureFlag{
+	{featureConsistentHashing, `allows placement "hash"; switching to it moves where new copies of existing files go`, false},
+	{featureLatencyRouting, `allows distance_model "latency", which trusts round trip times clients report`, false},
+	{featureClientWriteConcern, "lets uploads pick their write concern with ?w=", true},
+	{featureDirectRepair, "has repairs and rebalancing copy files from node to node, rather than send the central copy", true},
+}
+
+// resolveFeatures returns every flag's state: its default unless set.
+func resolveFeatures(set map[string]bool) map[string]bool {
+	out := map[string]bool{}
+	for _, f := range featureFlags {
+		out[f.Name] = f.Default
+		if v, ok := set[f.Name]; ok {
+			out[f.Name] = v
+		}
+	}
+	return out
+}
+
+// parseFeatures reads FEATURES: flag names separated by commas, each
+// turned on, or off with a leading "-".
+func parseFeatures(v string) map[string]bool {
+	out := map[string]bool{}
+	for _, name := range strings.Split(v, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		on := !strings.HasPrefix(name, "-")
+		out[strings.TrimPrefix(name, "-")] = on
+	}
+	return out
+}
+
+// validateFeatures checks the flag names, and that the config does not use
+// a behavior whose flag is off.
+func (c Config) validateFeatures() []error {
+	var errs []error
+	known := map[string]bool{}
+	for _, f := range featureFlags {
+		known[f.Name] = true
+	}
+	var unknown []string
+	for name := range c.Features {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		errs = append(errs, fmt.Errorf("features: unknown flag %q", name))
+	}
+
+	on := resolveFeatures(c.Features)
+	if c.Placement == "hash" && !on[featureConsistentHashing] {
+		errs = append(errs, fmt.Errorf(`placement "hash" needs the %s feature flag`, featureConsistentHashing))
+	}
+	if c.DistanceModel == "latency" && !on[featureLatencyRouting] {
+		errs = append(errs, fmt.Errorf(`distance_model "latency" needs the %s feature flag`, featureLatencyRouting))
+	}
+	return errs
+}
+
+func featureEnabled(name string) bool {
+	return currentPolicy().features[name]
+}
+
+// flagStatus is one flag in the features API.
+type flagStatus struct {
+	featureFlag
+	Enabled bool `json:"enabled"`
+}
+
+func featuresHandler(w http.ResponseWriter, r *http.Request) {
+	out := []flagStatus{}
+	for _, f := range featureFlags {
+		out = append(out, flagStatus{f, featureEnabled(f.Name)})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
@@ -0,0 +1,424 @@
+package central
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/checksum"
+)
+
+// ---------------------------
+// Cluster Fsck
+// ---------------------------
+
+// fsck audits every file in the cluster against the central copies, which
+// are the source of truth:
+//
+//   - missing_replicas: fewer replicas than the replication factor asks for
+//     (every node when it is unset), or cold_replicas for cold files (see
+//     runPrefetch)
+//   - checksum_mismatch: a replica whose content differs from the central copy
+//   - missing_central: replicas of a file the central API has no copy of
+//   - missing_part: a part of a composite file not on its node (see
+//     checkManifest, which audits composite files instead of checkFile)
+//
+// With repair, missing and mismatched replicas are rewritten from the central
+// copy and missing central copies are restored from the newest replica.
+// Unreachable nodes are listed and not held against the files they may hold.
+const (
+	fsckMissingReplicas  = "missing_replicas"
+	fsckChecksumMismatch = "checksum_mismatch"
+	fsckMissingCentral   = "missing_central"
+	fsckMissingPart      = "missing_part"
+)
+
+var fsckMu sync.Mutex // one fsck at a time
+
+// FsckIssue is one problem found, and whether repair fixed it.
+type FsckIssue struct {
+	File     string `json:"file"`
+	Node     string `json:"node,omitempty"`
+	Problem  string `json:"problem"`
+	Detail   string `json:"detail,omitempty"`
+	Repaired bool   `json:"repaired,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// FsckReport is the outcome of one fsck run.
+type FsckReport struct {
+	Started      time.Time   `json:"started"`
+	Finished     time.Time   `json:"finished"`
+	Repair       bool        `json:"repair"`
+	Files        int         `json:"files"`
+	Healthy      int         `json:"healthy"`
+	WantReplicas int         `json:"want_replicas"`
+	Nodes        int         `json:"nodes"`
+	Unreachable  []string    `json:"unreachable,omitempty"`
+	Issues       []FsckIssue `json:"issues"`
+}
+
+// Unrepaired counts the issues still standing.
+func (r *FsckReport) Unrepaired() int {
+	n := 0
+	for _, is := range r.Issues {
+		if !is.Repaired {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *FsckReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d file(s) checked on %d node(s), %d healthy, %d issue(s)",
+		r.Files, r.Nodes, r.Healthy, len(r.Issues))
+	if r.Repair {
+		fmt.Fprintf(&b, ", %d repaired", len(r.Issues)-r.Unrepaired())
+	}
+	b.WriteString("\n")
+	if len(r.Unreachable) > 0 {
+		fmt.Fprintf(&b, "unreachable: %s\n", strings.Join(r.Unreachable, ", "))
+	}
+	if len(r.Issues) == 0 {
+		return b.String()
+	}
+	tw := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FILE\tNODE\tPROBLEM\tDETAIL\tSTATUS")
+	for _, is := range r.Issues {
+		status := "found"
+		switch {
+		case is.Repaired:
+			status = "repaired"
+		case is.Error != "":
+			status = "failed: " + is.Error
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", is.File, is.Node, is.Problem, is.Detail, status)
+	}
+	tw.Flush()
+	return b.String()
+}
+
+// pushFile writes the central copy of filename to s.
+func pushFile(ctx context.Context, s StorageServer, filename string) error {
+	return pushFileThrough(ctx, s, filename, nil)
+}
+
+// pushFileThrough is pushFile reading the file through wrap, if not nil.
+func pushFileThrough(ctx context.Context, s StorageServer, filename string, wrap func(context.Context, io.Reader) io.Reader) error {
+	return callNode(ctx, s, opUpload, func(ctx context.Context) error {
+		f, err := os.Open(filepath.Join(uploadDir, filename))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		var file io.Reader = f
+		if wrap != nil {
+			file = wrap(ctx, f)
+		}
+		status, body, err := forwardFileTo(ctx, s.URL, filename, fileAlgorithms.of(filename), file, fi.Size(), fi.Size(), nil)
+		if err == nil && status != http.StatusOK {
+			err = &statusError{Status: status, Body: body}
+		}
+		return err
+	})
+}
+
+// errNoPeerCopy means no other node had a replica of a file to copy.
+var errNoPeerCopy = errors.New("no other node has a matching replica")
+
+// copyFromPeer has s copy filename straight from another node whose
+// replica matches the central copy (POST /replicate on s), so the bytes
+// go node to node and never through the central API. Peers nearest to s
+// are tried first. The caller books the copy in the maintenance bandwidth
+// budget; s is told to keep to rate, the rate it was booked at.
+func copyFromPeer(ctx context.Context, s StorageServer, filename string, rate int64) error {
+	want, err := centralChecksum(filename)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(filepath.Join(uploadDir, filename))
+	if err != nil {
+		return err
+	}
+	err = errNoPeerCopy
+	for _, n := range preferHealthy(rankStorages(clientRef{Lat: s.Lat, Lon: s.Lon})) {
+		if n.ID == s.ID || !health.isHealthy(n.ID) {
+			continue
+		}
+		if h, ok := headReplica(ctx, n.StorageServer, filename); !ok || h.Get("X-Checksum") != want {
+			continue
+		}
+		q := url.Values{}
+		q.Set("filename", filename)
+		q.Set("from", n.URL)
+		q.Set("checksum", want)
+		q.Set("size", strconv.FormatInt(fi.Size(), 10))
+		if rate > 0 {
+			q.Set("rate", strconv.FormatInt(rate, 10))
+		}
+		t := transfers.start(filename, s.ID, n.ID, fi.Size())
+		stop := followCopy(ctx, s, filename, t)
+		err = postReplicate(ctx, s, q)
+		stop()
+		transfers.finish(t)
+		if err == nil {
+			fmt.Println("Copied", filename, "from", n.ID, "to", s.ID)
+			return nil
+		}
+		// A node without /replicate will not have it for the next peer.
+		var se *statusError
+		if errors.As(err, &se) && (se.Status == http.StatusNotFound || se.Status == http.StatusMethodNotAllowed) {
+			return err
+		}
+	}
+	return err
+}
+
+// postReplicate sends s a /replicate request, authenticated like
+// registration.
+func postReplicate(ctx context.Context, s StorageServer, q url.Values) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL+"/replicate?"+q.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	if registrationToken != "" {
+		req.Header.Set("Authorization", "Bearer "+registrationToken)
+	}
+	resp, err := nodeClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return &statusError{Status: resp.StatusCode, Body: string(body)}
+	}
+	return nil
+}
+
+func runFsck(ctx context.Context, repair bool) FsckReport {
+	fsckMu.Lock()
+	defer fsckMu.Unlock()
+
+	report := FsckReport{Started: time.Now().UTC(), Repair: repair, Issues: []FsckIssue{}}
+	nodes := topo.nodes()
+	report.Nodes = len(nodes)
+	report.WantReplicas = int(replicationFactor.Load())
+	if report.WantReplicas == 0 || report.WantReplicas > len(nodes) {
+		report.WantReplicas = len(nodes)
+	}
+
+	// Every reachable node's inventory: name -> node -> file.
+	central := loadCentralInventory()
+	inventory := map[string]map[string]RemoteFile{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, s := range nodes {
+		wg.Add(1)
+		go func(s StorageServer) {
+			defer wg.Done()
+			list, err := syncNodeFiles(ctx, s, central)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				report.Unreachable = append(report.Unreachable, s.ID)
+				return
+			}
+			for _, f := range list {
+				if inventory[f.Name] == nil {
+					inventory[f.Name] = map[string]RemoteFile{}
+				}
+				inventory[f.Name][s.ID] = f
+			}
+		}(s)
+	}
+	wg.Wait()
+	sort.Strings(report.Unreachable)
+	unreachable := map[string]bool{}
+	for _, id := range report.Unreachable {
+		unreachable[id] = true
+	}
+
+	inFlight := inFlightUploads()
+	names := knownFiles()
+	for name := range inventory {
+		names[name] = true
+	}
+	for _, name := range manifests.files() {
+		names[name] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		if !inFlight[name] && !trash.has(name) && !isPartName(name) {
+			sorted = append(sorted, name)
+		}
+	}
+	sort.Strings(sorted)
+
+	for _, name := range sorted {
+		report.Files++
+		want := report.WantReplicas
+		if (prefetch.isCold(name) || retentionFiles.isArchived(name)) && prefetchCfg.coldReplicas < want {
+			want = prefetchCfg.coldReplicas // trimmed on purpose
+		}
+		var issues []FsckIssue
+		if m, ok := manifests.get(name); ok {
+			issues = checkManifest(ctx, m, inventory, nodes, unreachable, repair)
+		} else {
+			issues = checkFile(ctx, name, inventory[name], nodes, unreachable, want, repair)
+		}
+		if len(issues) == 0 {
+			report.Healthy++
+		}
+		report.Issues = append(report.Issues, issues...)
+	}
+	report.Finished = time.Now().UTC()
+	return report
+}
+
+// checkFile audits one file given the replicas found on each reachable
+// node, repairing what it can if asked.
+func checkFile(ctx context.Context, name string, replicas map[string]RemoteFile, nodes []StorageServer,
+	unreachable map[string]bool, want int, repair bool) []FsckIssue {
+	var issues []FsckIssue
+	add := func(is FsckIssue, repairErr error) {
+		if repair {
+			if repairErr != nil {
+				is.Error = repairErr.Error()
+			} else {
+				is.Repaired = true
+			}
+		}
+		fmt.Println("fsck:", is.File, is.Node, is.Problem, is.Detail, is.Error)
+		issues = append(issues, is)
+	}
+
+	central, err := centralChecksum(name)
+	if err != nil {
+		is := FsckIssue{File: name, Problem: fsckMissingCentral, Detail: fmt.Sprintf("%d replica(s)", len(replicas))}
+		if !repair {
+			add(is, nil)
+			return issues
+		}
+		// Restore the central copy from the newest replica.
+		var newest StorageServer
+		var newestTime time.Time
+		for _, s := range nodes {
+			if f, ok := replicas[s.ID]; ok && (newest.ID == "" || f.ModTime.After(newestTime)) {
+				newest, newestTime = s, f.ModTime
+			}
+		}
+		if err := adoptFile(ctx, newest, name); err != nil {
+			add(is, err)
+			return issues
+		}
+		add(is, nil)
+		if central, err = centralChecksum(name); err != nil {
+			return issues
+		}
+	}
+
+	for _, s := range nodes {
+		f, ok := replicas[s.ID]
+		if !ok || f.Checksum == central {
+			continue
+		}
+		// A replica hashed by another algorithm is compared by that one.
+		if alg := checksum.Algorithm(f.Checksum); alg != checksum.Algorithm(central) {
+			if sum, err := fileChecksum(filepath.Join(uploadDir, name), alg); err == nil && sum == f.Checksum {
+				continue
+			}
+		}
+		is := FsckIssue{File: name, Node: s.ID, Problem: fsckChecksumMismatch,
+			Detail: fmt.Sprintf("%s, want %s", shortSum(f.Checksum), shortSum(central))}
+		var err error
+		if repair {
+			err = bulkPush(ctx, s, name)
+		}
+		add(is, err)
+	}
+
+	// A mismatched replica is reported above, not again as a missing one.
+	// Nodes that could not be listed may well hold the file; only count it
+	// as under-replicated when even they would not make up the numbers.
+	have := len(replicas)
+	if have >= want && len(issues) == 0 {
+		replicaFailures.clearFile(name)
+	}
+	if have+len(unreachable) >= want {
+		return issues
+	}
+	is := FsckIssue{File: name, Problem: fsckMissingReplicas, Detail: fmt.Sprintf("%d of %d", have, want)}
+	if !repair {
+		add(is, nil)
+		return issues
+	}
+	// Top up on reachable nodes without a replica, healthiest first.
+	var ranked []rankedNode
+	for _, s := range nodes {
+		if _, has := replicas[s.ID]; !has && !unreachable[s.ID] && health.isHealthy(s.ID) {
+			ranked = append(ranked, rankedNode{StorageServer: s})
+		}
+	}
+	var lastErr error
+	for _, r := range preferHealthy(withRoom(ranked)) {
+		if have >= want {
+			break
+		}
+		if lastErr = bulkPush(ctx, r.StorageServer, name); lastErr == nil {
+			have++
+		}
+	}
+	if have < want {
+		if lastErr == nil {
+			lastErr = fmt.Errorf("no node left to place a replica on")
+		}
+		add(is, fmt.Errorf("%d of %d replicas after repair: %w", have, want, lastErr))
+		return issues
+	}
+	add(is, nil)
+	return issues
+}
+
+// antiEntropyTask is the scheduled fsck, repairing what it finds; issues
+// it could not repair fail the run.
+func antiEntropyTask(ctx context.Context) (string, error) {
+	r := runFsck(ctx, true)
+	summary := fmt.Sprintf("%d file(s) checked on %d node(s), %d issue(s), %d repaired",
+		r.Files, r.Nodes, len(r.Issues), len(r.Issues)-r.Unrepaired())
+	if n := r.Unrepaired(); n > 0 {
+		return summary, fmt.Errorf("%d issue(s) not repaired", n)
+	}
+	return summary, nil
+}
+
+// fsckHandler audits the cluster: GET for a report, POST with ?repair=1 to
+// also fix what it finds.
+func fsckHandler(w http.ResponseWriter, r *http.Request) {
+	repair, _ := strconv.ParseBool(r.URL.Query().Get("repair"))
+	if repair && r.Method != http.MethodPost {
+		apierr.Send(w, "Use POST to repair", http.StatusMethodNotAllowed)
+		return
+	}
+	report := runFsck(r.Context(), repair)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
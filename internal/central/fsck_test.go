@@ -0,0 +1,257 @@
+package central
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func (c *testCluster) fsck(repair bool) FsckReport {
+	c.t.Helper()
+	req, _ := http.NewRequest("GET", c.central.URL+"/api/v1/admin/fsck", nil)
+	if repair {
+		req, _ = http.NewRequest("POST", c.central.URL+"/api/v1/admin/fsck?repair=1", nil)
+	}
+	req.Header.Set("Authorization", "Bearer s3cret")
+	resp, err := testClient.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var report FsckReport
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		c.t.Fatal(err)
+	}
+	return report
+}
+
+func issueKeys(r FsckReport) []string {
+	var keys []string
+	for _, is := range r.Issues {
+		keys = append(keys, is.File+"/"+is.Node+":"+is.Problem)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func TestClusterFsck(t *testing.T) {
+	c := newTestCluster(t, 3, nil)
+	c.upload("good.txt", "good", nearLondon)
+	c.upload("thin.txt", "thin", nearLondon)
+	c.node("sg").backend.Delete("thin.txt")
+	c.upload("bad.txt", "bad", nearLondon)
+	c.node("ny").backend.Put("bad.txt", strings.NewReader("rotten"))
+	c.node("ldn").backend.Put("lost.txt", strings.NewReader("lost"))
+
+	report := c.fsck(false)
+	want := []string{
+		"bad.txt/ny:" + fsckChecksumMismatch,
+		"lost.txt/:" + fsckMissingCentral,
+		"thin.txt/:" + fsckMissingReplicas,
+	}
+	if got := issueKeys(report); !slices.Equal(got, want) {
+		t.Errorf("issues = %v, want %v", got, want)
+	}
+	if report.Files != 4 || report.Healthy != 1 || report.Unrepaired() != len(want) {
+		t.Errorf("report = %d files, %d healthy, %d unrepaired", report.Files, report.Healthy, report.Unrepaired())
+	}
+
+	// lost.txt has no central copy, so it is only audited (and topped up)
+	// once that is restored.
+	report = c.fsck(true)
+	if report.Unrepaired() != 0 {
+		t.Errorf("after repair:\n%s", &report)
+	}
+	if b, _ := os.ReadFile(filepath.Join(uploadDir, "lost.txt")); string(b) != "lost" {
+		t.Errorf("restored lost.txt = %q", b)
+	}
+	for _, name := range []string{"thin.txt", "lost.txt", "bad.txt"} {
+		if got := c.holders(name); len(got) != 3 {
+			t.Errorf("%s holders after repair = %v", name, got)
+		}
+	}
+	if report = c.fsck(false); len(report.Issues) != 0 {
+		t.Errorf("issues left after repair:\n%s", &report)
+	}
+
+	req, _ := http.NewRequest("GET", c.central.URL+"/api/v1/admin/fsck?repair=1", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	resp, _ := testClient.Do(req)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET repair: status %d, want 405", resp.StatusCode)
+	}
+	resp, _ = testClient.Post(c.central.URL+"/api/v1/admin/fsck?repair=1", "", nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("repair without the admin token: status %d, want 401", resp.StatusCode)
+	}
+}
+
+func TestClusterDirectRepair(t *testing.T) {
+	c := newTestCluster(t, 3, nil)
+	c.upload("thin.txt", "copied from a peer", nearLondon)
+	c.node("sg").backend.Delete("thin.txt")
+
+	var mu sync.Mutex
+	var seen []string
+	for _, n := range c.nodes {
+		inner := *n.handler.Load()
+		id := n.ID
+		var h http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPost || r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/files/") {
+				mu.Lock()
+				seen = append(seen, id+" "+r.Method+" "+r.URL.Path)
+				mu.Unlock()
+			}
+			inner.ServeHTTP(w, r)
+		})
+		n.handler.Store(&h)
+	}
+
+	if report := c.fsck(true); report.Unrepaired() != 0 {
+		t.Fatalf("repair:\n%s", &report)
+	}
+	if got := c.holders("thin.txt"); len(got) != 3 {
+		t.Errorf("holders after repair = %v", got)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !slices.Contains(seen, "sg POST /replicate") || slices.Contains(seen, "sg POST /upload") {
+		t.Errorf("sg was not repaired from a peer: %v", seen)
+	}
+	if !slices.Contains(seen, "ldn GET /files/thin.txt") && !slices.Contains(seen, "ny GET /files/thin.txt") {
+		t.Errorf("no peer served the copy: %v", seen)
+	}
+}
+
+func TestClusterRepairBooksBudgetOnce(t *testing.T) {
+	c := newTestCluster(t, 1, func(cfg *Config) { cfg.MaintenanceBytesPerSec = 1000 })
+	c.upload("lone.txt", strings.Repeat("x", 100), nearLondon)
+	c.node("sg").backend.Delete("lone.txt")
+
+	// No peer has a copy, so the central copy is sent: 100 bytes at
+	// 1000 a second, booked once though a peer copy was looked for first.
+	before := time.Now()
+	if err := bulkPush(context.Background(), c.node("sg").StorageServer, "lone.txt"); err != nil {
+		t.Fatal(err)
+	}
+	maintenance.rate.mu.Lock()
+	booked := maintenance.rate.booked.Sub(before)
+	maintenance.rate.mu.Unlock()
+	if booked > 150*time.Millisecond {
+		t.Errorf("budget booked %v ahead, want about 100ms", booked)
+	}
+	if got := c.holders("lone.txt"); len(got) != 1 {
+		t.Errorf("holders after repair = %v", got)
+	}
+}
+
+func TestClusterRepairProgress(t *testing.T) {
+	c := newTestCluster(t, 3, func(cfg *Config) {
+		cfg.AdminToken = "s3cret"
+		cfg.MaintenanceBytesPerSec = 100 // the copy takes a second
+	})
+	defer func(d time.Duration) { transferPollInterval = d }(transferPollInterval)
+	transferPollInterval = 10 * time.Millisecond
+	content := strings.Repeat("x", 100)
+	c.upload("large.bin", content, nearLondon)
+	c.node("sg").backend.Delete("large.bin")
+
+	done := make(chan FsckReport)
+	go func() { done <- c.fsck(true) }()
+
+	list := func() []TransferProgress {
+		t.Helper()
+		req, _ := http.NewRequest("GET", c.central.URL+"/api/v1/admin/replication/transfers", nil)
+		req.Header.Set("Authorization", "Bearer s3cret")
+		resp, err := testClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var l []TransferProgress
+		json.NewDecoder(resp.Body).Decode(&l)
+		return l
+	}
+	var seen []TransferProgress
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if seen = list(); len(seen) == 1 && seen[0].BytesCopied > 0 {
+			break
+		}
+	}
+	if len(seen) != 1 || seen[0].File != "large.bin" || seen[0].Node != "sg" || seen[0].From == "central" ||
+		seen[0].BytesTotal != 100 || seen[0].BytesCopied == 0 {
+		t.Errorf("transfers = %+v", seen)
+	}
+	if st := clusterStatus(); len(st.Replication.Transfers) != len(seen) {
+		t.Errorf("cluster status transfers = %+v", st.Replication.Transfers)
+	}
+
+	if report := <-done; report.Unrepaired() != 0 {
+		t.Fatalf("repair:\n%s", &report)
+	}
+	if l := list(); len(l) != 0 {
+		t.Errorf("transfers after the repair = %+v", l)
+	}
+}
+
+func TestClusterRepairRequestFromNode(t *testing.T) {
+	c := newTestCluster(t, 3, nil)
+	c.upload("rot.txt", "good", nearLondon)
+	c.node("sg").backend.Put("rot.txt", strings.NewReader("evil"))
+
+	resp, err := testClient.Get(c.node("sg").URL + "/info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var info NodeInfo
+	json.NewDecoder(resp.Body).Decode(&info)
+	resp.Body.Close()
+
+	body := `{"id":"sg","node_uuid":"` + info.ID + `","file":"rot.txt"}`
+	req, _ := http.NewRequest("POST", c.central.URL+"/api/v1/nodes/repair", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+testRegistrationToken)
+	resp, err = testClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("repair: status %d, want 202", resp.StatusCode)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rc, _, err := c.node("sg").backend.Get("rot.txt")
+		if err == nil {
+			b, _ := io.ReadAll(rc)
+			rc.Close()
+			if string(b) == "good" {
+				break
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("corrupt replica was not rewritten")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	req, _ = http.NewRequest("POST", c.central.URL+"/api/v1/nodes/repair",
+		strings.NewReader(`{"id":"sg","node_uuid":"`+info.ID+`","file":"nowhere.txt"}`))
+	req.Header.Set("Authorization", "Bearer "+testRegistrationToken)
+	resp, _ = testClient.Do(req)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("repair without a central copy: status %d, want 404", resp.StatusCode)
+	}
+}
@@ -0,0 +1,259 @@
+package central
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+)
+
+// ---------------------------
+// Orphan Garbage Collection
+// ---------------------------
+
+// An orphan is a file on a storage node that the central API has no record
+// of: a delete that failed on that node, an upload whose central copy was
+// lost, or a file put on the node behind the API's back. The collector
+// compares every node's inventory with the central copies and handles
+// orphans per gcPolicy:
+//
+//   - report: list them only
+//   - delete: remove them from the node
+//   - adopt:  copy the newest one back to the central API, which makes it
+//     a known file again (its other copies become ordinary replicas)
+//
+// Files younger than gcGracePeriod and files of uploads still in flight are
+// left alone, so an upload racing the collector is never mistaken for one.
+const (
+	gcReport = "report"
+	gcDelete = "delete"
+	gcAdopt  = "adopt"
+)
+
+var gcMu sync.Mutex // one collection at a time
+
+func validGCPolicy(p string) bool {
+	return p == gcReport || p == gcDelete || p == gcAdopt
+}
+
+// Orphan is one orphaned replica and what the collector did about it.
+type Orphan struct {
+	Node    string    `json:"node"`
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Action  string    `json:"action"` // reported, deleted, adopted, kept
+	Error   string    `json:"error,omitempty"`
+}
+
+// GCReport is the outcome of one collection.
+type GCReport struct {
+	Started     time.Time `json:"started"`
+	Finished    time.Time `json:"finished"`
+	Policy      string    `json:"policy"`
+	Nodes       int       `json:"nodes_scanned"`
+	Unreachable []string  `json:"unreachable,omitempty"`
+	Orphans     []Orphan  `json:"orphans"`
+}
+
+// knownFiles returns the names the central API has a copy of, plus those of
+// uploads still in flight, those in the trash and the parts of composite
+// files.
+func knownFiles() map[string]bool {
+	known := inFlightUploads()
+	for _, name := range trash.names() {
+		known[name] = true
+	}
+	for _, name := range manifests.partNames(manifests.files()) {
+		known[name] = true
+	}
+	entries, _ := os.ReadDir(uploadDir)
+	for _, e := range entries {
+		if !e.IsDir() && !strings.HasPrefix(e.Name(), ".") {
+			known[e.Name()] = true
+		}
+	}
+	return known
+}
+
+// inFlightUploads returns the names of uploads that have not finished.
+func inFlightUploads() map[string]bool {
+	names := map[string]bool{}
+	uploadJobs.mu.Lock()
+	defer uploadJobs.mu.Unlock()
+	for _, j := range uploadJobs.jobs {
+		j.mu.Lock()
+		if j.Finished == nil && j.Filename != "" {
+			names[j.Filename] = true
+		}
+		j.mu.Unlock()
+	}
+	return names
+}
+
+// collectGarbage runs one collection with the given policy.
+func collectGarbage(ctx context.Context, policy string) GCReport {
+	gcMu.Lock()
+	defer gcMu.Unlock()
+
+	report := GCReport{Started: time.Now().UTC(), Policy: policy, Orphans: []Orphan{}}
+	known := knownFiles()
+	central := loadCentralInventory()
+	cutoff := time.Now().Add(-currentPolicy().gcGracePeriod)
+
+	type found struct {
+		node StorageServer
+		file RemoteFile
+	}
+	var orphans []found
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	nodes := topo.nodes()
+	report.Nodes = len(nodes)
+	for _, s := range nodes {
+		wg.Add(1)
+		go func(s StorageServer) {
+			defer wg.Done()
+			list, err := syncNodeFiles(ctx, s, central)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				fmt.Println("GC: cannot list", s.ID, ":", err)
+				report.Unreachable = append(report.Unreachable, s.ID)
+				return
+			}
+			for _, f := range list {
+				if !known[f.Name] && f.ModTime.Before(cutoff) {
+					orphans = append(orphans, found{s, f})
+				}
+			}
+		}(s)
+	}
+	wg.Wait()
+	sort.Strings(report.Unreachable)
+
+	// Newest copy of each name first, so adopt takes the latest version.
+	sort.Slice(orphans, func(i, j int) bool {
+		if orphans[i].file.Name != orphans[j].file.Name {
+			return orphans[i].file.Name < orphans[j].file.Name
+		}
+		return orphans[i].file.ModTime.After(orphans[j].file.ModTime)
+	})
+
+	adopted := map[string]bool{}
+	for _, o := range orphans {
+		entry := Orphan{Node: o.node.ID, Name: o.file.Name, Size: o.file.Size, ModTime: o.file.ModTime, Action: "reported"}
+		var err error
+		switch policy {
+		case gcDelete:
+			if _, locked := objectLocks.locked(o.file.Name); locked {
+				entry.Action = "kept"
+				break
+			}
+			err = callNode(ctx, o.node, opDelete, func(ctx context.Context) error {
+				return deleteFromNode(ctx, o.node, o.file.Name)
+			})
+			entry.Action = "deleted"
+		case gcAdopt:
+			entry.Action = "kept"
+			// A stray part is no file of its own to adopt.
+			if !adopted[o.file.Name] && !isPartName(o.file.Name) {
+				if err = adoptFile(ctx, o.node, o.file.Name); err == nil {
+					adopted[o.file.Name] = true
+					entry.Action = "adopted"
+				}
+			}
+		}
+		if err != nil {
+			entry.Action = "failed"
+			entry.Error = err.Error()
+		}
+		fmt.Println("GC:", entry.Action, "orphan", o.file.Name, "on", o.node.ID)
+		report.Orphans = append(report.Orphans, entry)
+	}
+	report.Finished = time.Now().UTC()
+	return report
+}
+
+// adoptFile copies filename from s into the upload dir.
+func adoptFile(ctx context.Context, s StorageServer, filename string) error {
+	dst := filepath.Join(uploadDir, filepath.Base(filename))
+	if _, err := os.Stat(dst); err == nil {
+		return nil // uploaded again since the listing
+	}
+	return callNode(ctx, s, opDownload, func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, nodeFileURL(s, filename), nil)
+		if err != nil {
+			return err
+		}
+		resp, err := nodeClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return &statusError{Status: resp.StatusCode}
+		}
+		tmp, err := os.CreateTemp(uploadDir, ".adopt-*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		if _, err := io.Copy(tmp, resp.Body); err != nil {
+			tmp.Close()
+			return err
+		}
+		if err := tmp.Chmod(0644); err != nil {
+			tmp.Close()
+			return err
+		}
+		if err := tmp.Close(); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp.Name(), dst); err != nil {
+			return err
+		}
+		fi, _ := os.Stat(dst)
+		sum, _ := centralChecksum(filepath.Base(filename))
+		if fi != nil {
+			recordUpload(filepath.Base(filename), fi.Size(), sum)
+		}
+		return nil
+	})
+}
+
+// gcTask is the scheduled collection, by the configured policy.
+func gcTask(ctx context.Context) (string, error) {
+	report := collectGarbage(ctx, currentPolicy().gcPolicy)
+	summary := fmt.Sprintf("%d orphan(s) found (%s), %d node(s) unreachable",
+		len(report.Orphans), report.Policy, len(report.Unreachable))
+	if len(report.Orphans) > 0 || len(report.Unreachable) > 0 {
+		fmt.Println("GC:", summary)
+	}
+	return summary, nil
+}
+
+// gcHandler runs a collection on demand: POST /api/v1/admin/gc, with ?policy= to
+// override the configured policy (e.g. policy=report for a dry run).
+func gcHandler(w http.ResponseWriter, r *http.Request) {
+	policy := currentPolicy().gcPolicy
+	if p := r.URL.Query().Get("policy"); p != "" {
+		if !validGCPolicy(p) {
+			apierr.Send(w, "unknown policy "+p+" (want report, delete or adopt)", http.StatusBadRequest)
+			return
+		}
+		policy = p
+	}
+	report := collectGarbage(r.Context(), policy)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,96 @@
+package central
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func (c *testCluster) gc(policy string) GCReport {
+	c.t.Helper()
+	req, _ := http.NewRequest("POST", c.central.URL+"/api/v1/admin/gc?policy="+policy, nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	resp, err := testClient.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var report GCReport
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		c.t.Fatal(err)
+	}
+	return report
+}
+
+// orphanKeys returns "node/name:action" for every orphan in r.
+func orphanKeys(r GCReport) []string {
+	var keys []string
+	for _, o := range r.Orphans {
+		keys = append(keys, o.Node+"/"+o.Name+":"+o.Action)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func TestClusterGarbageCollection(t *testing.T) {
+	c := newTestCluster(t, 2, func(cfg *Config) { cfg.GCGracePeriod = Duration{0} })
+	c.upload("known.txt", "x", nearLondon)
+	c.node("sg").backend.Put("stray.txt", strings.NewReader("old"))
+	time.Sleep(10 * time.Millisecond)
+	c.node("ny").backend.Put("stray.txt", strings.NewReader("newer"))
+	c.node("ny").backend.Put("junk.txt", strings.NewReader("junk"))
+
+	report := c.gc(gcReport)
+	want := []string{"ny/junk.txt:reported", "ny/stray.txt:reported", "sg/stray.txt:reported"}
+	if got := orphanKeys(report); !slices.Equal(got, want) {
+		t.Errorf("report = %v, want %v", got, want)
+	}
+
+	// Adopting takes the newest copy and keeps the rest as replicas.
+	report = c.gc(gcAdopt)
+	want = []string{"ny/junk.txt:adopted", "ny/stray.txt:adopted", "sg/stray.txt:kept"}
+	if got := orphanKeys(report); !slices.Equal(got, want) {
+		t.Errorf("adopt = %v, want %v", got, want)
+	}
+	if b, _ := os.ReadFile(filepath.Join(uploadDir, "stray.txt")); string(b) != "newer" {
+		t.Errorf("adopted stray.txt = %q, want the newest copy", b)
+	}
+	if report = c.gc(gcDelete); len(report.Orphans) != 0 {
+		t.Errorf("orphans left after adopting: %v", orphanKeys(report))
+	}
+
+	c.node("sg").backend.Put("stray2.txt", strings.NewReader("x"))
+	report = c.gc(gcDelete)
+	if got := orphanKeys(report); !slices.Equal(got, []string{"sg/stray2.txt:deleted"}) {
+		t.Errorf("delete = %v", got)
+	}
+	if got := c.holders("stray2.txt"); len(got) != 0 {
+		t.Errorf("stray2.txt still on %v", got)
+	}
+}
+
+func TestClusterGarbageCollectionGracePeriod(t *testing.T) {
+	c := newTestCluster(t, 1, nil)
+	c.node("sg").backend.Put("fresh.txt", strings.NewReader("x"))
+	if report := c.gc(gcDelete); len(report.Orphans) != 0 {
+		t.Errorf("fresh file collected: %v", orphanKeys(report))
+	}
+
+	resp, _ := testClient.Post(c.central.URL+"/api/v1/admin/gc?policy=report", "", nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("without the admin token: status %d, want 401", resp.StatusCode)
+	}
+	req, _ := http.NewRequest("POST", c.central.URL+"/api/v1/admin/gc?policy=shred", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	resp, _ = testClient.Do(req)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unknown policy: status %d, want 400", resp.StatusCode)
+	}
+}
@@ -0,0 +1,439 @@
+package central
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+)
+
+// ---------------------------
+// User Data Export and Erasure
+// ---------------------------
+
+// For data subject requests, an admin can export everything the cluster
+// keeps about one user, and erase it. A user's data is the files in their
+// home (see homes.go), live or in the trash, and what the registries keep
+// under their name: notification settings and inbox, the short links they
+// made, their sessions, their group memberships, and their name as who
+// deleted or locked a file.
+//
+// Erasure deletes the home's files for good, bypassing the trash, except
+// those under an object lock, which it reports as retained. It then lists
+// every node again and reports any of the home's files still there, so
+// that the report shows the erasure happened rather than that it was
+// asked for. The access log and the user's account in the config file are
+// not rewritten: the report says so, for the admin to deal with.
+
+// erasedUser stands in for an erased user's name where a record outlives
+// them.
+const erasedUser = "(erased user)"
+
+// UserDataFile is one of a user's files, in an export's metadata.
+type UserDataFile struct {
+	Name    string `json:"name"` // stored name
+	File    string `json:"file"` // within the home
+	Trashed bool   `json:"trashed,omitempty"`
+}
+
+// UserData is the metadata an export holds, as metadata.json.
+type UserData struct {
+	User          string         `json:"user"`
+	Exported      time.Time      `json:"exported"`
+	Account       *User          `json:"account,omitempty"` // without the password hash
+	Notifications notifyRecord   `json:"notifications"`
+	ShortLinks    []ShortLink    `json:"short_links"`
+	Trash         []TrashEntry   `json:"trash"`
+	Locks         []ObjectLock   `json:"locks"`
+	Groups        []string       `json:"groups"`
+	Files         []UserDataFile `json:"files"`
+	Unreachable   []string       `json:"unreachable_nodes,omitempty"`
+}
+
+// ErasureReport is what erasing a user did.
+type ErasureReport struct {
+	User     string            `json:"user"`
+	Started  time.Time         `json:"started"`
+	Finished time.Time         `json:"finished"`
+	Erased   []string          `json:"erased"`             // stored names, gone everywhere
+	Retained map[string]string `json:"retained,omitempty"` // stored name: the lock keeping it
+	Metadata []string          `json:"metadata"`           // what was removed or pseudonymized
+	// Remaining are the erased files some node still lists, by node ID,
+	// and Unreachable the nodes that could not be listed to check.
+	Remaining    map[string][]string          `json:"remaining,omitempty"`
+	Unreachable  []string                     `json:"unreachable_nodes,omitempty"`
+	DeleteErrors map[string]map[string]string `json:"delete_errors,omitempty"`  // by file, then node ID
+	Variants     map[string][]string          `json:"image_variants,omitempty"` // cached resized copies removed, by file
+	Verified     bool                         `json:"verified"`
+	NotErased    []string                     `json:"not_erased"`
+}
+
+// userDataFor collects the metadata kept about user, with files, the
+// files in their home.
+func userDataFor(user string, files []UserDataFile) UserData {
+	prefix := homeName(user, "")
+	ud := UserData{
+		User:          user,
+		Exported:      time.Now().UTC(),
+		Notifications: notifyPrefs.get(user),
+		ShortLinks:    []ShortLink{},
+		Trash:         []TrashEntry{},
+		Locks:         []ObjectLock{},
+		Groups:        teams.groupsOf(user),
+		Files:         files,
+	}
+	if u, ok := users[user]; ok {
+		u.PasswordHash = ""
+		ud.Account = &u
+	}
+	ud.Notifications.Inbox = notifyPrefs.inbox(user)
+	for _, l := range shortLinks.list("") {
+		if l.CreatedBy == user || strings.HasPrefix(l.Name, prefix) {
+			ud.ShortLinks = append(ud.ShortLinks, l)
+		}
+	}
+	for _, e := range trash.list() {
+		if e.DeletedBy == user || strings.HasPrefix(e.Name, prefix) {
+			ud.Trash = append(ud.Trash, e)
+		}
+	}
+	for _, name := range lockedNames() {
+		l, _ := objectLocks.get(name)
+		if l.UpdatedBy == user || strings.HasPrefix(name, prefix) {
+			ud.Locks = append(ud.Locks, l)
+		}
+	}
+	if ud.Groups == nil {
+		ud.Groups = []string{}
+	}
+	return ud
+}
+
+// lockedNames returns every file with a lock record, active or not, sorted.
+func lockedNames() []string {
+	objectLocks.mu.Lock()
+	names := make([]string, 0, len(objectLocks.locks))
+	for name := range objectLocks.locks {
+		names = append(names, name)
+	}
+	objectLocks.mu.Unlock()
+	sort.Strings(names)
+	return names
+}
+
+// homeFiles lists the files in user's home, live and trashed, wherever
+// they are, and the nodes that could not be listed.
+func homeFiles(ctx context.Context, user string) ([]string, []string) {
+	prefix := homeName(user, "")
+	seen := map[string]bool{}
+	held, unreachable := listHomeReplicas(ctx, prefix)
+	for _, names := range held {
+		for _, name := range names {
+			seen[name] = true
+		}
+	}
+	entries, _ := os.ReadDir(uploadDir)
+	for _, e := range entries {
+		if e.Type().IsRegular() && !strings.HasPrefix(e.Name(), ".") && strings.HasPrefix(e.Name(), prefix) {
+			seen[e.Name()] = true
+		}
+	}
+	for _, name := range trash.names() {
+		if strings.HasPrefix(name, prefix) {
+			seen[name] = true
+		}
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, unreachable
+}
+
+// listHomeReplicas lists every node and returns the names starting with
+// prefix by node ID, and the nodes that could not be listed.
+func listHomeReplicas(ctx context.Context, prefix string) (map[string][]string, []string) {
+	held := map[string][]string{}
+	var unreachable []string
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, s := range topo.nodes() {
+		wg.Add(1)
+		go func(s StorageServer) {
+			defer wg.Done()
+			list, err := fetchNodeFiles(ctx, s)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				unreachable = append(unreachable, s.ID)
+				return
+			}
+			for _, rf := range list {
+				if strings.HasPrefix(rf.Name, prefix) {
+					held[s.ID] = append(held[s.ID], rf.Name)
+				}
+			}
+		}(s)
+	}
+	wg.Wait()
+	sort.Strings(unreachable)
+	return held, unreachable
+}
+
+// userExportHandler streams a tar.gz of everything kept about a user:
+// GET /api/v1/admin/users/{name}/export. metadata.json comes first, then
+// the home's files by stored name, read as exportHandler reads them, and
+// the trashed ones under trash/. Files that cannot be fetched are listed
+// in ERRORS.txt at the end.
+func userExportHandler(w http.ResponseWriter, r *http.Request) {
+	user := r.PathValue("name")
+	client, err := locateClient(r)
+	if err != nil {
+		apierr.Send(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pref, err := parseReadPreference(r)
+	if err != nil {
+		apierr.Send(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	prefix := homeName(user, "")
+	listed, unreachable := listExport(r.Context(), prefix, pref, client)
+	var sources []archiveSource
+	var files []UserDataFile
+	for _, src := range listed {
+		// The nodes answer for trashed replicas as if they were gone.
+		if !trash.has(src.name) {
+			sources = append(sources, src)
+			files = append(files, UserDataFile{Name: src.name, File: strings.TrimPrefix(src.name, prefix)})
+		}
+	}
+	var trashed []string
+	for _, name := range trash.names() {
+		if strings.HasPrefix(name, prefix) {
+			trashed = append(trashed, name)
+			files = append(files, UserDataFile{Name: name, File: strings.TrimPrefix(name, prefix), Trashed: true})
+		}
+	}
+	if files == nil {
+		files = []UserDataFile{}
+	}
+	ud := userDataFor(user, files)
+	ud.Unreachable = unreachable
+	meta, err := json.MarshalIndent(ud, "", "  ")
+	if err != nil {
+		apierr.Send(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if unreachable != nil {
+		w.Header().Set("X-Unreachable-Nodes", strings.Join(unreachable, ","))
+	}
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", `attachment; filename="user-export.tar.gz"`)
+	w.Header().Set("Cache-Control", "no-store")
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	tw.WriteHeader(&tar.Header{Name: "metadata.json", Mode: 0644, Size: int64(len(meta)), ModTime: ud.Exported})
+	tw.Write(meta)
+	var failed []string
+	for _, src := range sources {
+		err := writeExportEntry(r.Context(), tw, src)
+		if errors.Is(err, errEntryTruncated) {
+			fmt.Println("User export aborted at", src.name+":", err)
+			return
+		}
+		if err != nil {
+			failed = append(failed, src.name+": "+err.Error())
+		}
+	}
+	for _, name := range trashed {
+		if err := writeTrashedEntry(tw, name); err != nil {
+			if errors.Is(err, errEntryTruncated) {
+				fmt.Println("User export aborted at", name+":", err)
+				return
+			}
+			failed = append(failed, "trash/"+name+": "+err.Error())
+		}
+	}
+	if failed != nil {
+		msg := "These files could not be fetched:\n" + strings.Join(failed, "\n") + "\n"
+		tw.WriteHeader(&tar.Header{Name: "ERRORS.txt", Mode: 0644, Size: int64(len(msg)), ModTime: time.Now()})
+		io.WriteString(tw, msg)
+	}
+	tw.Close()
+	gz.Close()
+	fmt.Println("Exported the data of user", user+":", len(files), "files")
+}
+
+// writeTrashedEntry copies a trashed central copy into the tar, under
+// trash/.
+func writeTrashedEntry(tw *tar.Writer, name string) error {
+	f, err := os.Open(filepath.Join(trashDir, name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: "trash/" + name, Mode: 0644, Size: fi.Size(), ModTime: fi.ModTime()}); err != nil {
+		return err
+	}
+	if n, err := io.Copy(tw, f); err != nil || n != fi.Size() {
+		return fmt.Errorf("%w after %d of %d bytes: %v", errEntryTruncated, n, fi.Size(), err)
+	}
+	return nil
+}
+
+// eraseUser erases user's files and metadata everywhere, then checks that
+// the files are gone from every node.
+func eraseUser(ctx context.Context, user string) ErasureReport {
+	rep := ErasureReport{User: user, Started: time.Now().UTC(), Erased: []string{}, Metadata: []string{}}
+	prefix := homeName(user, "")
+	noted := func(format string, args ...any) {
+		rep.Metadata = append(rep.Metadata, fmt.Sprintf(format, args...))
+	}
+
+	names, _ := homeFiles(ctx, user)
+	for _, name := range names {
+		if l, ok := objectLocks.locked(name); ok {
+			if rep.Retained == nil {
+				rep.Retained = map[string]string{}
+			}
+			rep.Retained[name] = l.reason()
+			continue
+		}
+		var errs map[string]string
+		if trash.has(name) {
+			errs = deleteReplicas(ctx, name)
+			os.Remove(filepath.Join(trashDir, name))
+			trash.remove(name)
+			recordDelete(name)
+		} else {
+			errs = deleteEverywhere(ctx, name)
+		}
+		if len(errs) > 0 {
+			if rep.DeleteErrors == nil {
+				rep.DeleteErrors = map[string]map[string]string{}
+			}
+			rep.DeleteErrors[name] = errs
+		}
+		privateFiles.set(name, false)
+		if err := objectLocks.remove(name); err != nil {
+			fmt.Println("Cannot save object locks:", err)
+		}
+		rep.Erased = append(rep.Erased, name)
+	}
+	syncTrash(ctx)
+	syncPrivate(ctx)
+
+	variants, unknown := purgeVariants(func(source string) bool {
+		return strings.HasPrefix(source, prefix) && rep.Retained[source] == ""
+	})
+	if len(variants) > 0 {
+		rep.Variants = variants
+	}
+	if unknown > 0 {
+		noted("%d cached image variants of unknown source", unknown)
+	}
+	if notifyPrefs.forget(user) {
+		noted("notification settings and inbox")
+	}
+	if n := shortLinks.removeIf(func(l ShortLink) bool {
+		return l.CreatedBy == user || (strings.HasPrefix(l.Name, prefix) && rep.Retained[l.Name] == "")
+	}); n > 0 {
+		noted("%d short links", n)
+	}
+	if n, err := sessions.deleteUser(user); err != nil {
+		noted("sessions: %v", err)
+	} else if n > 0 {
+		noted("%d sessions", n)
+	}
+	if n := impersonations.revokeUser(user); n > 0 {
+		noted("%d impersonation tokens", n)
+	}
+	if groups, err := teams.removeMember(user); err != nil {
+		noted("group memberships: %v", err)
+	} else if groups != nil {
+		noted("membership of groups %s", strings.Join(groups, ", "))
+	}
+	if n := trash.relabel(user, erasedUser); n > 0 {
+		noted("name pseudonymized on %d trash entries", n)
+	}
+	if n, err := objectLocks.relabel(user, erasedUser); err != nil {
+		noted("object locks: %v", err)
+	} else if n > 0 {
+		noted("name pseudonymized on %d object locks", n)
+	}
+
+	// Check: nothing erased is left on any node, or here.
+	held, unreachable := listHomeReplicas(ctx, prefix)
+	for id, names := range held {
+		for _, name := range names {
+			if rep.Retained[name] != "" {
+				continue
+			}
+			if rep.Remaining == nil {
+				rep.Remaining = map[string][]string{}
+			}
+			rep.Remaining[id] = append(rep.Remaining[id], name)
+		}
+	}
+	for _, dir := range []string{uploadDir, trashDir} {
+		entries, _ := os.ReadDir(dir)
+		for _, e := range entries {
+			if strings.HasPrefix(e.Name(), prefix) && rep.Retained[e.Name()] == "" {
+				if rep.Remaining == nil {
+					rep.Remaining = map[string][]string{}
+				}
+				rep.Remaining["central"] = append(rep.Remaining["central"], e.Name())
+			}
+		}
+	}
+	for key, source := range cachedVariants() {
+		if strings.HasPrefix(source, prefix) && rep.Retained[source] == "" {
+			if rep.Remaining == nil {
+				rep.Remaining = map[string][]string{}
+			}
+			rep.Remaining["central"] = append(rep.Remaining["central"], "images/"+key)
+		}
+	}
+	rep.Unreachable = unreachable
+	rep.Verified = rep.Remaining == nil && unreachable == nil
+	rep.NotErased = []string{"access log lines naming the user"}
+	if _, ok := users[user]; ok {
+		rep.NotErased = append(rep.NotErased, "the account in the config file (remove it there, then reload)")
+	}
+	rep.Finished = time.Now().UTC()
+	fmt.Printf("Erased the data of user %s: %d files, %d retained, verified %v\n", user, len(rep.Erased), len(rep.Retained), rep.Verified)
+	return rep
+}
+
+// userEraseHandler erases a user's data and answers with the report:
+// POST /api/v1/admin/users/{name}/erase. It answers 502 if the erasure
+// could not be verified on every node.
+func userEraseHandler(w http.ResponseWriter, r *http.Request) {
+	rep := eraseUser(r.Context(), r.PathValue("name"))
+	w.Header().Set("Content-Type", "application/json")
+	if !rep.Verified {
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	json.NewEncoder(w).Encode(rep)
+}
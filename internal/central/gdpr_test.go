@@ -0,0 +1,158 @@
+package central
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUserExportAndErasure(t *testing.T) {
+	hash, _ := hashPassword("pw")
+	c := newTestCluster(t, 2, func(cfg *Config) {
+		cfg.AdminToken = "s3cret"
+		cfg.TrashRetention = Duration{time.Hour}
+		cfg.Users = []User{{Name: "alice", PasswordHash: hash, Email: "alice@example.com"}, {Name: "bob", PasswordHash: hash}}
+	})
+	home := func(file string) string { return homeName("alice", file) }
+	upload := func(user, name, content string) {
+		t.Helper()
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		fw, _ := mw.CreateFormFile("file", name)
+		io.WriteString(fw, content)
+		mw.Close()
+		req, _ := http.NewRequest("POST", c.central.URL+"/upload?"+nearLondon.Encode(), &body)
+		req.SetBasicAuth(user, "pw")
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		resp, err := testClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s uploading %s: %d", user, name, resp.StatusCode)
+		}
+	}
+	upload("alice", "cv.txt", "alice's cv")
+	upload("alice", "old.txt", "binned")
+	upload("alice", "evidence.txt", "held")
+	upload("bob", "cv.txt", "bob's cv")
+	c.waitForJobs()
+	if err := trashFile(context.Background(), home("old.txt"), "alice"); err != nil {
+		t.Fatal(err)
+	}
+	objectLocks.set(ObjectLock{Name: home("evidence.txt"), LegalHold: true, UpdatedBy: "root", Updated: time.Now()})
+	syncLocks(context.Background())
+	shortLinks.add(ShortLink{Name: homeName("bob", "cv.txt"), CreatedBy: "alice", Expires: time.Now().Add(time.Hour)})
+	notifyPrefs.deliver("alice", Notification{Subject: "bob shared a file"})
+	sessions.put("k", session{User: "alice", Expires: time.Now().Add(time.Hour)})
+	teams.Groups["staff"] = Group{Name: "staff", Members: []string{"alice", "bob"}}
+	aliceThumb := variantKey(home("cv.txt"), "v1", thumbnailSpec)
+	bobThumb := variantKey(homeName("bob", "cv.txt"), "v1", thumbnailSpec)
+	storeVariant(aliceThumb, home("cv.txt"), "image/png", []byte("alice's face"))
+	storeVariant(bobThumb, homeName("bob", "cv.txt"), "image/png", []byte("bob's face"))
+
+	admin := func(method, path string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, c.central.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer s3cret")
+		resp, err := testClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// The export holds the metadata and every file, trashed ones too.
+	resp := admin("GET", "/api/v1/admin/users/alice/export?"+nearLondon.Encode())
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("export: %d", resp.StatusCode)
+	}
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(tr)
+		got[hdr.Name] = string(b)
+	}
+	resp.Body.Close()
+	for name, want := range map[string]string{
+		home("cv.txt"):             "alice's cv",
+		home("evidence.txt"):       "held",
+		"trash/" + home("old.txt"): "binned",
+	} {
+		if got[name] != want {
+			t.Errorf("export %s = %q, want %q", name, got[name], want)
+		}
+	}
+	if _, ok := got[homeName("bob", "cv.txt")]; ok {
+		t.Error("export holds bob's file")
+	}
+	var ud UserData
+	if err := json.Unmarshal([]byte(got["metadata.json"]), &ud); err != nil {
+		t.Fatalf("metadata.json: %v", err)
+	}
+	if len(ud.Files) != 3 || len(ud.ShortLinks) != 1 || len(ud.Notifications.Inbox) != 1 || len(ud.Trash) != 1 || strings.Join(ud.Groups, ",") != "staff" {
+		t.Errorf("metadata = %s", got["metadata.json"])
+	}
+	if ud.Account == nil || ud.Account.Email != "alice@example.com" || strings.Contains(got["metadata.json"], hash) {
+		t.Errorf("account in metadata = %+v", ud.Account)
+	}
+
+	// Erasure removes it all but the held file, and checks the nodes.
+	resp = admin("POST", "/api/v1/admin/users/alice/erase")
+	var rep ErasureReport
+	json.NewDecoder(resp.Body).Decode(&rep)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !rep.Verified {
+		t.Fatalf("erase: %d %+v", resp.StatusCode, rep)
+	}
+	if len(rep.Erased) != 2 || rep.Retained[home("evidence.txt")] == "" {
+		t.Errorf("erased %v, retained %v", rep.Erased, rep.Retained)
+	}
+	for _, name := range []string{home("cv.txt"), home("old.txt")} {
+		if got := c.holders(name); len(got) != 0 {
+			t.Errorf("%s still held by %v", name, got)
+		}
+	}
+	if got := c.holders(homeName("bob", "cv.txt")); len(got) != 2 {
+		t.Errorf("bob's file held by %v", got)
+	}
+	if trash.has(home("old.txt")) {
+		t.Error("old.txt still in the trash")
+	}
+	if _, ok := sessions.get("k"); ok {
+		t.Error("alice's session survived")
+	}
+	if len(shortLinks.list("alice")) != 0 || len(notifyPrefs.inbox("alice")) != 0 || len(teams.groupsOf("alice")) != 0 {
+		t.Errorf("metadata left: %v", rep.Metadata)
+	}
+	if got := rep.Variants[home("cv.txt")]; len(got) != 1 || got[0] != aliceThumb {
+		t.Errorf("variants erased: %v", rep.Variants)
+	}
+	if cached := cachedVariants(); cached[aliceThumb] != "" || cached[bobThumb] == "" {
+		t.Errorf("variant cache after the erasure: %v", cached)
+	}
+	if l, _ := objectLocks.get(home("evidence.txt")); l.UpdatedBy != "root" {
+		t.Errorf("lock = %+v", l)
+	}
+}
@@ -0,0 +1,274 @@
+package central
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ---------------------------
+// Geolocation Providers
+// ---------------------------
+
+// GeoResolver maps a client IP to coordinates.
+type GeoResolver interface {
+	Locate(ctx context.Context, ip string) (lat, lon float64, err error)
+}
+
+// geo is installed by the self-test once the configured resolver is ready.
+var geo GeoResolver = heuristicGeo{}
+
+func newGeoResolver(cfg Config) (GeoResolver, error) {
+	switch cfg.GeoResolver {
+	case "", "heuristic":
+		return heuristicGeo{}, nil
+	case "maxmind":
+		if cfg.MaxMindAccountID == "" || cfg.MaxMindLicenseKey == "" {
+			return nil, errors.New("maxmind_account_id and maxmind_license_key are required with geo_resolver maxmind")
+		}
+		endpoint := cfg.GeoURL
+		if endpoint == "" {
+			endpoint = "https://geoip.maxmind.com/geoip/v2.1/city/"
+		}
+		return newCachedGeo(&maxmindGeo{endpoint: endpoint, accountID: cfg.MaxMindAccountID, licenseKey: cfg.MaxMindLicenseKey}), nil
+	case "http":
+		if !strings.Contains(cfg.GeoURL, "{ip}") {
+			return nil, errors.New("geo_url must contain {ip} with geo_resolver http")
+		}
+		return newCachedGeo(&httpGeo{urlTemplate: cfg.GeoURL, latField: cfg.GeoLatField, lonField: cfg.GeoLonField}), nil
+	case "static":
+		return loadStaticGeo(cfg.GeoMappingFile)
+	}
+	return nil, fmt.Errorf("unknown geo_resolver %q (want heuristic, maxmind, http or static)", cfg.GeoResolver)
+}
+
+// heuristicGeo is the built-in test mapping (see approximateLocation).
+type heuristicGeo struct{}
+
+func (heuristicGeo) Locate(ctx context.Context, ip string) (float64, float64, error) {
+	lat, lon := approximateLocation(ip)
+	return lat, lon, nil
+}
+
+var geoClient = &http.Client{Timeout: 3 * time.Second}
+
+// getGeoJSON sends req and decodes the JSON response into a generic map.
+func getGeoJSON(req *http.Request) (map[string]interface{}, error) {
+	resp, err := geoClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	var doc map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// ---------------------------
+// MaxMind GeoIP2 / GeoLite2 web service
+// ---------------------------
+type maxmindGeo struct {
+	endpoint   string
+	accountID  string
+	licenseKey string
+}
+
+func (m *maxmindGeo) Locate(ctx context.Context, ip string) (float64, float64, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", strings.TrimSuffix(m.endpoint, "/")+"/"+url.PathEscape(ip), nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	req.SetBasicAuth(m.accountID, m.licenseKey)
+	req.Header.Set("Accept", "application/json")
+	doc, err := getGeoJSON(req)
+	if err != nil {
+		return 0, 0, fmt.Errorf("maxmind: %w", err)
+	}
+	return coordsFrom(doc, "location.latitude", "location.longitude")
+}
+
+// ---------------------------
+// Generic HTTP geo API (ip-api.com, ipinfo-style services, ...)
+// ---------------------------
+type httpGeo struct {
+	urlTemplate string // contains {ip}
+	latField    string // dotted path into the JSON response, default "lat"
+	lonField    string
+}
+
+func (h *httpGeo) Locate(ctx context.Context, ip string) (float64, float64, error) {
+	u := strings.ReplaceAll(h.urlTemplate, "{ip}", url.PathEscape(ip))
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	doc, err := getGeoJSON(req)
+	if err != nil {
+		return 0, 0, fmt.Errorf("geo api: %w", err)
+	}
+	latField, lonField := h.latField, h.lonField
+	if latField == "" {
+		latField = "lat"
+	}
+	if lonField == "" {
+		lonField = "lon"
+	}
+	return coordsFrom(doc, latField, lonField)
+}
+
+// coordsFrom reads two numbers out of a decoded JSON document by dotted path.
+// Numbers encoded as strings are accepted too.
+func coordsFrom(doc map[string]interface{}, latPath, lonPath string) (float64, float64, error) {
+	lat, err := jsonNumber(doc, latPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	lon, err := jsonNumber(doc, lonPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	return lat, lon, nil
+}
+
+func jsonNumber(doc map[string]interface{}, path string) (float64, error) {
+	var v interface{} = doc
+	for _, key := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return 0, fmt.Errorf("no %s in response", path)
+		}
+		v = m[key]
+	}
+	switch n := v.(type) {
+	case float64:
+		return n, nil
+	case string:
+		return strconv.ParseFloat(n, 64)
+	}
+	return 0, fmt.Errorf("no %s in response", path)
+}
+
+// cachedGeo remembers lookups from remote resolvers, which are slow and
+// usually rate limited or billed per query.
+type cachedGeo struct {
+	next GeoResolver
+
+	mu      sync.Mutex
+	entries map[string]geoEntry
+}
+
+type geoEntry struct {
+	lat, lon float64
+	expires  time.Time
+}
+
+const (
+	geoCacheTTL  = time.Hour
+	geoCacheSize = 10000
+)
+
+func newCachedGeo(next GeoResolver) *cachedGeo {
+	return &cachedGeo{next: next, entries: map[string]geoEntry{}}
+}
+
+func (c *cachedGeo) Locate(ctx context.Context, ip string) (float64, float64, error) {
+	c.mu.Lock()
+	e, ok := c.entries[ip]
+	c.mu.Unlock()
+	if ok && time.Now().Before(e.expires) {
+		return e.lat, e.lon, nil
+	}
+
+	lat, lon, err := c.next.Locate(ctx, ip)
+	if err != nil {
+		return 0, 0, err
+	}
+	c.mu.Lock()
+	if len(c.entries) >= geoCacheSize {
+		c.entries = map[string]geoEntry{}
+	}
+	c.entries[ip] = geoEntry{lat: lat, lon: lon, expires: time.Now().Add(geoCacheTTL)}
+	c.mu.Unlock()
+	return lat, lon, nil
+}
+
+// ---------------------------
+// Static mapping file
+// ---------------------------
+
+// staticGeo maps CIDR ranges to coordinates, e.g. office and VPN networks.
+// The file is a JSON list:
+//
+//	[{"cidr": "10.1.0.0/16", "lat": 1.35, "lon": 103.82}, ...]
+//
+// The most specific matching range wins.
+type staticGeo struct {
+	ranges []geoRange
+}
+
+type geoRange struct {
+	CIDR string  `json:"cidr"`
+	Lat  float64 `json:"lat"`
+	Lon  float64 `json:"lon"`
+
+	net *net.IPNet
+}
+
+func loadStaticGeo(path string) (*staticGeo, error) {
+	if path == "" {
+		return nil, errors.New("geo_mapping_file is required with geo_resolver static")
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var ranges []geoRange
+	if err := json.Unmarshal(raw, &ranges); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for i := range ranges {
+		_, n, err := net.ParseCIDR(ranges[i].CIDR)
+		if err != nil {
+			return nil, fmt.Errorf("%s: entry %d: %w", path, i, err)
+		}
+		if _, _, err := parseLatLon(fmt.Sprint(ranges[i].Lat), fmt.Sprint(ranges[i].Lon)); err != nil {
+			return nil, fmt.Errorf("%s: entry %d: %w", path, i, err)
+		}
+		ranges[i].net = n
+	}
+	return &staticGeo{ranges: ranges}, nil
+}
+
+func (s *staticGeo) Locate(ctx context.Context, ip string) (float64, float64, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return 0, 0, fmt.Errorf("invalid IP %q", ip)
+	}
+	best := -1
+	var match geoRange
+	for _, r := range s.ranges {
+		if ones, _ := r.net.Mask.Size(); r.net.Contains(addr) && ones > best {
+			best, match = ones, r
+		}
+	}
+	if best < 0 {
+		return 0, 0, fmt.Errorf("no mapping for %s", ip)
+	}
+	return match.Lat, match.Lon, nil
+}
@@ -0,0 +1,350 @@
+package central
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/changes"
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/storage"
+)
+
+// testCluster is a central API plus storage nodes, all in-process on
+// ephemeral ports with their own temp dirs. The central API keeps its state
+// in package variables, so tests using a cluster must not run in parallel.
+type testCluster struct {
+	t       *testing.T
+	central *httptest.Server
+	nodes   []*testNode
+}
+
+type testNode struct {
+	StorageServer
+	srv     *httptest.Server
+	dir     string
+	cfg     storage.Config
+	backend storage.Backend
+	handler atomic.Pointer[http.Handler] // swapped by inject
+}
+
+// Node sites, in the order nodes are created.
+var testSites = []struct {
+	id, region string
+	lat, lon   float64
+}{
+	{"sg", "singapore", 1.3521, 103.8198},
+	{"ny", "new-york", 40.7128, -74.0060},
+	{"ldn", "london", 51.5074, -0.1278},
+	{"syd", "sydney", -33.8688, 151.2093},
+	{"sao", "sao-paulo", -23.5505, -46.6333},
+}
+
+// Client locations next to each site.
+var (
+	nearSingapore = url.Values{"lat": {"1.29"}, "lon": {"103.85"}}
+	nearNewYork   = url.Values{"lat": {"40.73"}, "lon": {"-73.99"}}
+	nearLondon    = url.Values{"lat": {"51.50"}, "lon": {"-0.12"}}
+)
+
+// resetState forgets everything a previous cluster left in package state,
+// once the work it handed off to the background is done with it.
+func resetState() {
+	background.Wait()
+	topo = &topology{registered: map[string]StorageServer{}}
+	health = &healthTracker{nodes: map[string]nodeHealth{}}
+	nodeStats = newStatsTracker()
+	breakers = newBreakerSet(5, 30*time.Second)
+	capacity = &capacityTracker{full: map[string]FullStatus{}}
+	centralSums.m = map[string]centralSum{}
+	fileChanges = changes.New(100000)
+	feed = newChangeFeed()
+	latencies = newLatencyMatrix()
+	peerLatency = &peerLatencyCache{}
+	identities = &identityRegistry{
+		records: map[string]identityRecord{},
+		status:  map[string]string{},
+		info:    map[string]NodeInfo{},
+	}
+	uploadJobs = &uploadJobRegistry{jobs: map[string]*uploadJob{}}
+	directUploads = &directUploadRegistry{pending: map[string]pendingUpload{}}
+	policy.Store(nil)
+	bucketReplicators = map[string]Replicator{}
+	signingKey = nil
+	hlsStatus = map[string]*HLSStatus{}
+	downloads = &downloadAnalytics{files: map[string]*DownloadCount{}}
+	prefetch = newPrefetchState()
+	accessStats = &accessAggregates{sums: map[accessKey]*accessCounters{}}
+	quarantined = &quarantineRegistry{entries: map[string]QuarantineEntry{}, reports: map[string]map[string]bool{}}
+	privateFiles = &privateRegistry{names: map[string]bool{}}
+	shortLinks = &shortLinkRegistry{links: map[string]ShortLink{}}
+	notifyPrefs = &notifyRegistry{users: map[string]*notifyRecord{}}
+	trash = &trashRegistry{entries: map[string]TrashEntry{}}
+	objectLocks = &lockRegistry{locks: map[string]ObjectLock{}}
+	retentionFiles = &retentionRegistry{Buckets: map[string]string{}, Archived: map[string]time.Time{}}
+	fileAlgorithms = &algorithmRegistry{algs: map[string]string{}}
+	manifests = &manifestRegistry{manifests: map[string]Manifest{}}
+	pipeline = &pipelineQueue{}
+	scheduler = newTaskScheduler()
+	idempotencyKeys = &idempotencyCache{keys: map[string]*idempotentResponse{}}
+	impersonations = newImpersonations()
+	transfers = &transferRegistry{m: map[*TransferProgress]bool{}}
+	quotaWatch.warned = map[string]bool{}
+	replicaFailures = &failureTracker{failing: map[replicaFailureKey]*trackedFailure{}}
+	teams = &teamRegistry{Groups: map[string]Group{}, Folders: map[string]Folder{}}
+}
+
+// testRegistrationToken is shared by the test nodes and central API: the
+// nodes refuse the central API's calls without one.
+const testRegistrationToken = "node-tok"
+
+// newTestCluster starts n storage nodes (at most len(testSites)) and a
+// central API configured with them. configure, if not nil, can adjust the
+// central config before it is applied.
+func newTestCluster(t *testing.T, n int, configure func(*Config)) *testCluster {
+	t.Helper()
+	resetState()
+	c := &testCluster{t: t}
+
+	cfg := defaultConfig()
+	cfg.Storages = nil
+	for i := 0; i < n; i++ {
+		site := testSites[i]
+		dir := t.TempDir()
+
+		scfg := storage.DefaultConfig("0", site.region)
+		scfg.IdentityPath = filepath.Join(dir, "node.json")
+		scfg.QuarantinePath = filepath.Join(dir, "quarantine.json")
+		scfg.PrivatePath = filepath.Join(dir, "private.json")
+		scfg.TrashPath = filepath.Join(dir, "trash.json")
+		scfg.LocksPath = filepath.Join(dir, "locks.json")
+		scfg.ChecksumPath = filepath.Join(dir, "checksums.json")
+		scfg.RegistrationToken = testRegistrationToken
+		backend, err := storage.NewLocalBackend(filepath.Join(dir, "files"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := storage.NewServer(scfg, backend)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		node := &testNode{dir: dir, cfg: scfg, backend: backend}
+		h := s.Handler()
+		node.handler.Store(&h)
+		node.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			(*node.handler.Load()).ServeHTTP(w, r)
+		}))
+		t.Cleanup(node.srv.Close)
+		node.StorageServer = StorageServer{ID: site.id, Label: site.region, URL: node.srv.URL, Lat: site.lat, Lon: site.lon}
+		c.nodes = append(c.nodes, node)
+		cfg.Storages = append(cfg.Storages, node.StorageServer)
+	}
+
+	dir := t.TempDir()
+	cfg.UploadDir = filepath.Join(dir, "uploads")
+	cfg.DataDir = filepath.Join(dir, "data")
+	cfg.TrashRetention = Duration{} // deletes are for good unless a test wants the trash
+	cfg.PostUploadTasks = nil       // and so is post-upload processing
+	cfg.AdminToken = "s3cret"       // for the admin endpoints the helpers call
+	cfg.RegistrationToken = testRegistrationToken
+	if configure != nil {
+		configure(&cfg)
+	}
+
+	report := runSelfTest(cfg)
+	if report.Failed() {
+		t.Fatalf("self-test failed:\n%s", report)
+	}
+	if err := apply(cfg); err != nil {
+		t.Fatal(err)
+	}
+	c.central = httptest.NewServer(routes())
+	t.Cleanup(c.central.Close)
+	t.Cleanup(c.waitForJobs)
+	return c
+}
+
+// waitForJobs waits for replicas still being written in the background
+// (quorum stragglers, async workers), post-upload jobs and scheduled
+// tasks, so they do not spill over into the next test's package state.
+func (c *testCluster) waitForJobs() {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		pending := 0
+		uploadJobs.mu.Lock()
+		for _, j := range uploadJobs.jobs {
+			j.mu.Lock()
+			if j.Finished == nil {
+				pending++
+			}
+			j.mu.Unlock()
+		}
+		uploadJobs.mu.Unlock()
+		pending += pipeline.pending()
+		scheduler.mu.Lock()
+		pending += len(scheduler.running)
+		scheduler.mu.Unlock()
+		if pending == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.t.Error("upload jobs still running after 10s")
+}
+
+// node returns the node with the given ID.
+func (c *testCluster) node(id string) *testNode {
+	for _, n := range c.nodes {
+		if n.ID == id {
+			return n
+		}
+	}
+	c.t.Fatalf("no node %q", id)
+	return nil
+}
+
+// stop takes a node offline and lets the central API notice.
+func (c *testCluster) stop(id string) {
+	n := c.node(id)
+	n.srv.Close()
+	probeNode(n.StorageServer)
+}
+
+// inject makes a node misbehave from now on. Its files and identity are
+// kept; a zero FaultConfig heals it.
+func (c *testCluster) inject(id string, faults storage.FaultConfig) {
+	c.t.Helper()
+	n := c.node(id)
+	cfg := n.cfg
+	cfg.Faults = faults
+	s, err := storage.NewServer(cfg, n.backend)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	h := s.Handler()
+	n.handler.Store(&h)
+}
+
+// delay makes node id wait d before answering each request, on top of
+// whatever handler it runs. A node that gives up on a request the central
+// API abandoned would be friendlier than a real hung node, so the wait is
+// not cut short; keep d short enough for the servers to shut down.
+func (c *testCluster) delay(id string, d time.Duration) {
+	n := c.node(id)
+	inner := *n.handler.Load()
+	var h http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(d)
+		inner.ServeHTTP(w, r)
+	})
+	n.handler.Store(&h)
+}
+
+// client does not follow redirects, so tests can inspect them.
+var testClient = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+}
+
+// uploadResult is the part of an upload job's JSON the tests look at.
+type uploadResult struct {
+	ID           string `json:"id"`
+	Status       string `json:"status"`
+	Error        string `json:"error"`
+	WriteConcern string `json:"write_concern"`
+	Replicas     map[string]struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+	} `json:"replicas"`
+}
+
+// upload posts a file to the central API as the JSON client would. query
+// carries the client location and any other upload parameters.
+func (c *testCluster) upload(name, content string, query url.Values) (*http.Response, uploadResult) {
+	c.t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("file", name)
+	io.WriteString(fw, content)
+	mw.Close()
+
+	req, _ := http.NewRequest("POST", c.central.URL+"/upload?"+query.Encode(), &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Accept", "application/json")
+	resp, err := testClient.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var job uploadResult
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&job); err != nil {
+			c.t.Fatal(err)
+		}
+	}
+	return resp, job
+}
+
+// delete deletes name through the central API, as the file list's form
+// does.
+func (c *testCluster) delete(name string) *http.Response {
+	c.t.Helper()
+	resp, err := testClient.PostForm(c.central.URL+"/delete", url.Values{"filename": {name}})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+// get requests path on the central API and returns the response, with the
+// body already read into the returned string.
+func (c *testCluster) get(path string, header http.Header) (*http.Response, string) {
+	c.t.Helper()
+	req, _ := http.NewRequest("GET", c.central.URL+path, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := testClient.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return resp, string(b)
+}
+
+// listing returns the central API's JSON file listing.
+func (c *testCluster) listing() map[string]FileListing {
+	c.t.Helper()
+	_, body := c.get("/files", http.Header{"Accept": {"application/json"}})
+	var files []FileListing
+	if err := json.Unmarshal([]byte(body), &files); err != nil {
+		c.t.Fatalf("listing: %v: %s", err, body)
+	}
+	out := map[string]FileListing{}
+	for _, f := range files {
+		out[f.Name] = f
+	}
+	return out
+}
+
+// holders returns the IDs of the nodes that have name on disk, in node
+// order. It looks at the backends directly, so it works for stopped or
+// delayed nodes too.
+func (c *testCluster) holders(name string) []string {
+	var ids []string
+	for _, n := range c.nodes {
+		if _, err := n.backend.Stat(name); err == nil {
+			ids = append(ids, n.ID)
+		}
+	}
+	return ids
+}
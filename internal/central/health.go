@@ -0,0 +1,171 @@
+package central
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ---------------------------
+// Node Health
+// ---------------------------
+type nodeHealth struct {
+	Healthy   bool      `json:"healthy"`
+	LastCheck time.Time `json:"last_check"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+type healthTracker struct {
+	mu    sync.RWMutex
+	nodes map[string]nodeHealth
+}
+
+var health = &healthTracker{nodes: map[string]nodeHealth{}}
+
+func (h *healthTracker) set(nodeID string, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	nh := nodeHealth{Healthy: err == nil, LastCheck: time.Now().UTC()}
+	if err != nil {
+		nh.LastError = err.Error()
+	}
+	h.nodes[nodeID] = nh
+}
+
+func (h *healthTracker) get(nodeID string) nodeHealth {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.nodes[nodeID]
+}
+
+// isHealthy treats nodes that have never been probed as healthy so a missed
+// first probe doesn't blackhole a node until the next interval.
+func (h *healthTracker) isHealthy(nodeID string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	nh, ok := h.nodes[nodeID]
+	return !ok || nh.Healthy
+}
+
+// probeNode refreshes a node's health. A node answering with a different
+// identity than the pinned one is considered unhealthy: it is not the node
+// whose replicas we recorded.
+func probeNode(s StorageServer) error {
+	info, err := fetchNodeInfo(probeClient, s)
+	if err == nil {
+		breakers.probed(s.ID)
+		capacity.observe(s.ID, info.Quota)
+		observeQuota(s.ID, info.Quota)
+		_, err = identities.observe(s, info)
+	}
+	if err == nil {
+		if l, drifted := limitsDrifted(s.ID, info.Quota); drifted {
+			ctx, cancel := context.WithTimeout(context.Background(), probeClient.Timeout)
+			if perr := pushNodeLimits(ctx, s, l); perr != nil {
+				fmt.Println("Cannot set limits on", s.ID, ":", perr)
+			}
+			cancel()
+		}
+		if quarantineDrifted(info.Quarantine) {
+			ctx, cancel := context.WithTimeout(context.Background(), probeClient.Timeout)
+			if perr := pushQuarantine(ctx, s, withParts(quarantined.names())); perr != nil {
+				fmt.Println("Cannot send quarantine list to", s.ID, ":", perr)
+			}
+			cancel()
+		}
+		if privateDrifted(info.Private) {
+			ctx, cancel := context.WithTimeout(context.Background(), probeClient.Timeout)
+			if perr := pushNameList(ctx, s, "/api/v1/private", withParts(privateFiles.list())); perr != nil {
+				fmt.Println("Cannot send private file list to", s.ID, ":", perr)
+			}
+			cancel()
+		}
+		if trashDrifted(info.Trash) {
+			ctx, cancel := context.WithTimeout(context.Background(), probeClient.Timeout)
+			if perr := pushNameList(ctx, s, "/api/v1/trash", withParts(trash.names())); perr != nil {
+				fmt.Println("Cannot send trash list to", s.ID, ":", perr)
+			}
+			cancel()
+		}
+		if alg, drifted := checksumDrifted(info); drifted {
+			ctx, cancel := context.WithTimeout(context.Background(), probeClient.Timeout)
+			if perr := pushChecksumAlgorithm(ctx, s, alg); perr != nil {
+				fmt.Println("Cannot set checksum algorithm on", s.ID, ":", perr)
+			}
+			cancel()
+		}
+		if locksDrifted(info.Locked) {
+			ctx, cancel := context.WithTimeout(context.Background(), probeClient.Timeout)
+			if perr := pushNameList(ctx, s, "/api/v1/locks", objectLocks.names(time.Now())); perr != nil {
+				fmt.Println("Cannot send locked file list to", s.ID, ":", perr)
+			}
+			cancel()
+		}
+	}
+	if err == nil {
+		if rtt, perr := pingNode(s); perr == nil {
+			latencies.observeCentral(s.ID, rtt)
+		}
+	}
+	health.set(s.ID, err)
+	return err
+}
+
+// monitorNodes probes every storage node on a fixed interval.
+func monitorNodes(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		var wg sync.WaitGroup
+		for _, s := range topo.nodes() {
+			wg.Add(1)
+			go func(s StorageServer) {
+				defer wg.Done()
+				wasHealthy := health.isHealthy(s.ID)
+				err := probeNode(s)
+				if err != nil && wasHealthy {
+					fmt.Println("Node", s.ID, "is down:", err)
+					publish(eventNodeDown, nil, nodeDownNotice{s.ID, s.URL, err.Error()})
+				} else if err == nil && !wasHealthy {
+					fmt.Println("Node", s.ID, "is back up")
+				}
+			}(s)
+		}
+		wg.Wait()
+	}
+}
+
+// hasReplica checks whether a node currently holds the file.
+func hasReplica(ctx context.Context, s StorageServer, filename string) bool {
+	_, ok := headReplica(ctx, s, filename)
+	return ok
+}
+
+// headReplica fetches the node's headers for filename (ETag, size, ...).
+// The header is nil when the node could not be reached at all.
+func headReplica(ctx context.Context, s StorageServer, filename string) (http.Header, bool) {
+	if !breakers.allow(s.ID) {
+		return nil, false
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, nodeFileURL(s, filename), nil)
+	if err != nil {
+		return nil, false
+	}
+	resp, err := probeClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			breakers.release(s.ID)
+		} else {
+			breakers.report(s.ID, err)
+		}
+		return nil, false
+	}
+	resp.Body.Close()
+	breakers.report(s.ID, nil)
+	return resp.Header, resp.StatusCode == http.StatusOK
+}
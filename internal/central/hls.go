@@ -0,0 +1,357 @@
+package central
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+)
+
+// ---------------------------
+// HLS
+// ---------------------------
+
+// With a transcoder configured (Config.Transcoder), every uploaded video is
+// cut into an HLS playlist and segments in the background. They are stored
+// as regular files named after the video, "<video>.hls.index.m3u8" and
+// "<video>.hls.seg000.ts" and so on, on the nodes that hold the video, so a
+// player streams from wherever the video itself would be read. They are
+// held back and deleted with the video, like the parts of a composite file:
+//
+//	GET /hls/{video}/index.m3u8    the playlist
+//	GET /hls/{video}/{segment}     a redirect to the nearest copy
+//	GET /api/v1/hls/{video}        how the transcode went
+const (
+	hlsPlaylist = "index.m3u8"
+	hlsInfix    = ".hls."
+)
+
+// Transcoder cuts the video at input into an HLS playlist, index.m3u8, and
+// its segments in outDir, referring to them by bare file name.
+type Transcoder interface {
+	Transcode(ctx context.Context, input, outDir string, segment time.Duration) error
+}
+
+// ffmpegTranscoder runs ffmpeg, re-encoding to H.264 and AAC, which every
+// HLS player plays.
+type ffmpegTranscoder struct {
+	path string
+}
+
+func (f ffmpegTranscoder) Transcode(ctx context.Context, input, outDir string, segment time.Duration) error {
+	cmd := exec.CommandContext(ctx, f.path,
+		"-hide_banner", "-loglevel", "error", "-i", input,
+		"-c:v", "libx264", "-c:a", "aac",
+		"-f", "hls", "-hls_time", strconv.Itoa(int(segment.Seconds())), "-hls_playlist_type", "vod",
+		"-hls_segment_filename", filepath.Join(outDir, "seg%03d.ts"),
+		filepath.Join(outDir, hlsPlaylist))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %w: %s", f.path, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func newTranscoder(cfg Config) Transcoder {
+	if cfg.Transcoder == "" {
+		return nil
+	}
+	return ffmpegTranscoder{path: cfg.Transcoder}
+}
+
+const (
+	hlsQueued  = "queued"
+	hlsRunning = "running"
+	hlsDone    = "done"
+	hlsFailed  = "failed"
+)
+
+// HLSStatus is how a video's transcode went.
+type HLSStatus struct {
+	Video    string     `json:"video"`
+	Status   string     `json:"status"`
+	Segments []string   `json:"segments,omitempty"`
+	Error    string     `json:"error,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+var (
+	transcoder       Transcoder
+	hlsSegmentLength = 6 * time.Second
+	hlsTimeout       = time.Hour
+	hlsSlots         = make(chan struct{}, 1) // transcodes run one at a time
+	hlsMu            sync.Mutex
+	hlsStatus        = map[string]*HLSStatus{}
+)
+
+// videoExts are recognized even where the system has no MIME table, as in
+// the Alpine image.
+var videoExts = map[string]bool{".mp4": true, ".m4v": true, ".mov": true, ".mkv": true, ".webm": true, ".avi": true}
+
+// isVideo reports whether name looks like a video, by its extension.
+func isVideo(name string) bool {
+	if strings.Contains(name, hlsInfix) {
+		return false
+	}
+	ext := strings.ToLower(filepath.Ext(name))
+	return videoExts[ext] || strings.HasPrefix(mime.TypeByExtension(ext), "video/")
+}
+
+func hlsName(video, part string) string {
+	return video + hlsInfix + part
+}
+
+// hlsVideo returns the video that name is an HLS file of, if it is one. Such a
+// file is private or quarantined whenever its video is.
+func hlsVideo(name string) (string, bool) {
+	i := strings.Index(name, hlsInfix)
+	if i <= 0 || !isVideo(name[:i]) {
+		return "", false
+	}
+	return name[:i], true
+}
+
+// hlsFiles returns the HLS files stored for video: its playlist, first,
+// and the segments the central copy of the playlist lists. It is nil for a
+// video without a playlist, and for anything that is not a video.
+func hlsFiles(video string) []string {
+	if !isVideo(video) {
+		return nil
+	}
+	playlist := hlsName(video, hlsPlaylist)
+	f, err := os.Open(filepath.Join(uploadDir, playlist))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	names := []string{playlist}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line != "" && !strings.HasPrefix(line, "#") && line == filepath.Base(line) {
+			names = append(names, hlsName(video, line))
+		}
+	}
+	return names
+}
+
+// deleteHLS removes video's HLS files from the nodes and their central
+// copies, and returns the errors by node ID. The playlist goes last, and
+// stays if a segment could not be deleted everywhere: it is how the
+// segments are found again on the next try.
+func deleteHLS(ctx context.Context, video string) map[string]string {
+	names := hlsFiles(video)
+	var errs map[string]string
+	for i := len(names) - 1; i >= 0; i-- {
+		name := names[i]
+		if nerrs := deleteReplicas(ctx, name); len(nerrs) > 0 {
+			if errs == nil {
+				errs = map[string]string{}
+			}
+			for id, e := range nerrs {
+				errs[id] = name + ": " + e
+			}
+			continue
+		}
+		if i == 0 && errs != nil {
+			break
+		}
+		if err := os.Remove(filepath.Join(uploadDir, name)); err == nil {
+			recordDelete(name)
+		}
+	}
+	if errs == nil {
+		hlsMu.Lock()
+		delete(hlsStatus, video)
+		hlsMu.Unlock()
+	}
+	return errs
+}
+
+// queueTranscode starts cutting video into HLS in the background, if a
+// transcoder is configured and video is one.
+func queueTranscode(video string) {
+	if transcoder == nil || !isVideo(video) {
+		return
+	}
+	hlsMu.Lock()
+	hlsStatus[video] = &HLSStatus{Video: video, Status: hlsQueued}
+	hlsMu.Unlock()
+	goBackground(func() {
+		hlsSlots <- struct{}{}
+		defer func() { <-hlsSlots }()
+		setHLSStatus(video, hlsRunning, nil, nil)
+		segments, err := transcodeVideo(video)
+		if err != nil {
+			fmt.Println("HLS transcode of", video, "failed:", err)
+		}
+		setHLSStatus(video, hlsDone, segments, err)
+	})
+}
+
+func setHLSStatus(video, status string, segments []string, err error) {
+	hlsMu.Lock()
+	defer hlsMu.Unlock()
+	st := &HLSStatus{Video: video, Status: status, Segments: segments}
+	if status == hlsDone {
+		now := time.Now().UTC()
+		st.Finished = &now
+		if err != nil {
+			st.Status = hlsFailed
+			st.Error = err.Error()
+		}
+	}
+	hlsStatus[video] = st
+}
+
+// transcodeVideo cuts the central copy of video into HLS, then stores the
+// playlist and segments like uploads: a central copy, and a replica on
+// every node holding the video.
+func transcodeVideo(video string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), hlsTimeout)
+	defer cancel()
+
+	out, err := os.MkdirTemp(uploadDir, ".hls-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(out)
+	if err := transcoder.Transcode(ctx, filepath.Join(uploadDir, video), out, hlsSegmentLength); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(out)
+	if err != nil {
+		return nil, err
+	}
+	var parts []string
+	for _, e := range entries {
+		if e.Type().IsRegular() {
+			parts = append(parts, e.Name())
+		}
+	}
+	if !slices.Contains(parts, hlsPlaylist) {
+		return nil, fmt.Errorf("transcoder wrote no %s", hlsPlaylist)
+	}
+
+	var holders []StorageServer
+	for _, s := range topo.nodes() {
+		if hasReplica(ctx, s, video) {
+			holders = append(holders, s)
+		}
+	}
+	var segments []string
+	for _, part := range parts {
+		name := hlsName(video, part)
+		if err := os.Rename(filepath.Join(out, part), filepath.Join(uploadDir, name)); err != nil {
+			return nil, err
+		}
+		sum, err := fileChecksum(filepath.Join(uploadDir, name), negotiatedChecksum())
+		if err != nil {
+			return nil, err
+		}
+		fi, _ := os.Stat(filepath.Join(uploadDir, name))
+		recordUpload(name, fi.Size(), sum)
+		for _, s := range holders {
+			if err := pushFile(ctx, s, name); err != nil {
+				return nil, fmt.Errorf("store %s on %s: %w", name, s.ID, err)
+			}
+		}
+		if part != hlsPlaylist {
+			segments = append(segments, part)
+		}
+	}
+	sort.Strings(segments)
+	return segments, nil
+}
+
+// hlsStatusHandler reports a video's transcode: GET /api/v1/hls/{video}.
+func hlsStatusHandler(w http.ResponseWriter, r *http.Request) {
+	hlsMu.Lock()
+	st, ok := hlsStatus[r.PathValue("video")]
+	var out HLSStatus
+	if ok {
+		out = *st
+	}
+	hlsMu.Unlock()
+	if !ok {
+		apierr.Send(w, "No transcode for this video", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// hlsHandler serves a video's playlist and redirects its segments to the
+// nearest healthy node holding them. The playlist's segment URLs carry the
+// client location the playlist was asked for with, so the segments are
+// routed for the same place.
+func hlsHandler(w http.ResponseWriter, r *http.Request) {
+	video, part := r.PathValue("video"), r.PathValue("part")
+	if part != filepath.Base(part) || video != filepath.Base(video) {
+		apierr.Send(w, "Invalid name", http.StatusBadRequest)
+		return
+	}
+	if refuseQuarantined(w, video) {
+		return
+	}
+	if refusePrivate(w, r, video) {
+		return
+	}
+	client, err := locateClient(r)
+	if err != nil {
+		apierr.Send(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	name := hlsName(video, part)
+
+	if part != hlsPlaylist {
+		s, ok := nearestReplica(r.Context(), client, name)
+		switch {
+		case ok:
+			w.Header().Set("X-Storage-Node", s.ID)
+			http.Redirect(w, r, nodeFileURL(s, name), http.StatusFound)
+		case hasCentralCopy(name):
+			http.Redirect(w, r, "/files/"+url.PathEscape(name), http.StatusFound)
+		default:
+			apierr.Send(w, "Segment not found", http.StatusNotFound)
+		}
+		return
+	}
+
+	playlist, err := openNearestCopy(r.Context(), name, client)
+	if err != nil {
+		apierr.Send(w, "Playlist not found (see /api/v1/hls/"+video+")", http.StatusNotFound)
+		return
+	}
+	defer playlist.Close()
+	query := ""
+	if lat, lon := r.URL.Query().Get("lat"), r.URL.Query().Get("lon"); lat != "" && lon != "" {
+		query = "?" + url.Values{"lat": {lat}, "lon": {lon}}.Encode()
+	}
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Cache-Control", "no-store")
+	sc := bufio.NewScanner(playlist)
+	for sc.Scan() {
+		line := sc.Text()
+		if line != "" && !strings.HasPrefix(line, "#") {
+			line = url.PathEscape(line) + query
+		}
+		fmt.Fprintln(w, line)
+	}
+}
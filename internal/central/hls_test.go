@@ -0,0 +1,141 @@
+package central
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeTranscoder cuts every video into two segments.
+type fakeTranscoder struct{}
+
+func (fakeTranscoder) Transcode(ctx context.Context, input, outDir string, segment time.Duration) error {
+	playlist := "#EXTM3U\n#EXTINF:6.0,\nseg000.ts\n#EXTINF:4.0,\nseg001.ts\n#EXT-X-ENDLIST\n"
+	os.WriteFile(filepath.Join(outDir, "seg000.ts"), []byte("segment 0"), 0644)
+	os.WriteFile(filepath.Join(outDir, "seg001.ts"), []byte("segment 1"), 0644)
+	return os.WriteFile(filepath.Join(outDir, hlsPlaylist), []byte(playlist), 0644)
+}
+
+// waitForTranscode waits for video's transcode to finish.
+func (c *testCluster) waitForTranscode(video string) {
+	c.t.Helper()
+	var st HLSStatus
+	for deadline := time.Now().Add(5 * time.Second); st.Status != hlsDone; {
+		if time.Now().After(deadline) {
+			c.t.Fatalf("transcode did not finish: %+v", st)
+		}
+		time.Sleep(10 * time.Millisecond)
+		_, body := c.get("/api/v1/hls/"+video, nil)
+		json.Unmarshal([]byte(body), &st)
+		if st.Status == hlsFailed {
+			c.t.Fatalf("transcode failed: %s", st.Error)
+		}
+	}
+}
+
+func TestClusterHLS(t *testing.T) {
+	c := newTestCluster(t, 3, func(cfg *Config) { cfg.ReplicationFactor = 1 })
+	transcoder = fakeTranscoder{}
+	c.upload("clip.mp4", "moving pictures", nearLondon)
+	c.upload("notes.txt", "not a video", nearLondon)
+
+	c.waitForTranscode("clip.mp4")
+	if resp, _ := c.get("/api/v1/hls/notes.txt", nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("notes.txt was transcoded: status %d", resp.StatusCode)
+	}
+	// Segments go where the video went.
+	if got := c.holders("clip.mp4.hls.seg001.ts"); len(got) != 1 || got[0] != "ldn" {
+		t.Errorf("segment on %v, want [ldn]", got)
+	}
+
+	_, playlist := c.get("/hls/clip.mp4/index.m3u8?lat=51.50&lon=-0.12", nil)
+	if !strings.Contains(playlist, "\nseg000.ts?lat=51.50&lon=-0.12\n") {
+		t.Errorf("playlist:\n%s", playlist)
+	}
+	resp, _ := c.get("/hls/clip.mp4/seg000.ts?lat=51.50&lon=-0.12", nil)
+	if resp.StatusCode != http.StatusFound || resp.Header.Get("X-Storage-Node") != "ldn" {
+		t.Errorf("segment: status %d from %q, want a redirect to ldn", resp.StatusCode, resp.Header.Get("X-Storage-Node"))
+	}
+
+	// The stream goes with the video.
+	if resp := c.delete("clip.mp4"); resp.StatusCode >= 400 {
+		t.Fatalf("delete: %d", resp.StatusCode)
+	}
+	for _, part := range []string{hlsPlaylist, "seg000.ts", "seg001.ts"} {
+		name := hlsName("clip.mp4", part)
+		if got := c.holders(name); len(got) != 0 || hasCentralCopy(name) {
+			t.Errorf("%s left on %v after the video was deleted", name, got)
+		}
+	}
+	if resp, _ := c.get("/api/v1/hls/clip.mp4", nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("transcode status after delete: %d", resp.StatusCode)
+	}
+}
+
+func TestClusterHLSTrash(t *testing.T) {
+	c := newTestCluster(t, 2, func(cfg *Config) { cfg.TrashRetention = Duration{time.Hour} })
+	transcoder = fakeTranscoder{}
+	c.upload("clip.mp4", "moving pictures", nearLondon)
+	c.waitForTranscode("clip.mp4")
+	segment := hlsName("clip.mp4", "seg000.ts")
+
+	// A trashed video's stream is held back with it...
+	c.delete("clip.mp4")
+	for _, n := range c.nodes {
+		resp, err := http.Get(n.URL + "/files/" + segment)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s serves a trashed video's segment: %d", n.ID, resp.StatusCode)
+		}
+	}
+
+	// ...and purged with it.
+	req, _ := http.NewRequest("DELETE", c.central.URL+"/api/v1/trash/clip.mp4", nil)
+	resp, err := testClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("purge: %d", resp.StatusCode)
+	}
+	if got := c.holders(segment); len(got) != 0 || hasCentralCopy(segment) {
+		t.Errorf("%s left on %v after the purge", segment, got)
+	}
+}
+
+func TestClusterHLSFollowsTheVideo(t *testing.T) {
+	c := newTestCluster(t, 1, nil)
+	transcoder = fakeTranscoder{}
+	c.upload("clip.mp4", "moving pictures", nearLondon)
+	c.waitForTranscode("clip.mp4")
+	playlist, segment := hlsName("clip.mp4", hlsPlaylist), hlsName("clip.mp4", "seg000.ts")
+
+	// A private video's playlist and segments are private too...
+	privateFiles.set("clip.mp4", true)
+	for _, name := range []string{playlist, segment} {
+		if resp, _ := c.get("/files/"+name, nil); resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("anonymous %s of a private video: %d", name, resp.StatusCode)
+		}
+	}
+	privateFiles.set("clip.mp4", false)
+
+	// ...and a quarantined video's are held back.
+	quarantineFile(context.Background(), "clip.mp4", "failed scan", "admin")
+	for _, name := range []string{playlist, segment} {
+		if resp, _ := c.get("/files/"+name, nil); resp.StatusCode != http.StatusUnavailableForLegalReasons {
+			t.Errorf("%s of a quarantined video: %d", name, resp.StatusCode)
+		}
+	}
+	if resp, _ := c.get("/hls/clip.mp4/seg000.ts?"+nearLondon.Encode(), nil); resp.StatusCode != http.StatusUnavailableForLegalReasons {
+		t.Errorf("stream of a quarantined video: %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,147 @@
+package central
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ---------------------------
+// Home Directories
+// ---------------------------
+
+// Once sign-in is required, every user has a home: what they upload is
+// stored as /u/<user>/<file>, so two users' report.pdf do not collide, and
+// the listing shows each user their own files and the shared ones (those
+// stored before homes, outside any). Only the owner deletes a file in a
+// home, and only an admin deletes another's or a shared one.
+//
+// Files and nodes keep a flat namespace, so the home is part of the stored
+// name: "u~<user>~<file>", the user escaped so that no name is a prefix of
+// another's home. /u/<user>/<file> downloads it by its path. Team folders
+// (see teams.go) are kept the same way.
+
+const homeMarker = "u~"
+
+// homeName returns the stored name of file in user's home.
+func homeName(user, file string) string {
+	return homeMarker + escapeHomeUser(user) + "~" + file
+}
+
+// escapeHomeUser escapes the characters a user name cannot keep in a file
+// name or a home prefix.
+func escapeHomeUser(user string) string {
+	var b strings.Builder
+	for i := 0; i < len(user); i++ {
+		c := user[i]
+		if c == '~' || c == '%' || c == '/' || c == '\\' || c < 0x20 {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// splitHomeName returns the owner and the file of a stored name, or ok
+// false for a shared file.
+func splitHomeName(name string) (owner, file string, ok bool) {
+	return splitMarked(name, homeMarker)
+}
+
+func splitMarked(name, marker string) (dir, file string, ok bool) {
+	rest, found := strings.CutPrefix(name, marker)
+	if !found {
+		return "", "", false
+	}
+	dir, file, found = strings.Cut(rest, "~")
+	if !found || dir == "" || file == "" {
+		return "", "", false
+	}
+	return dir, file, true
+}
+
+// homePath is how a stored name in a home or a team folder is shown:
+// /u/<user>/<file> or /t/<folder>/<file>. It is "" for a shared file.
+func homePath(name string) string {
+	if user, file, ok := splitHomeName(name); ok {
+		return "/u/" + user + "/" + file
+	}
+	if folder, file, ok := splitFolderName(name); ok {
+		return "/t/" + folder + "/" + file
+	}
+	return ""
+}
+
+// homesEnabled reports whether uploads go to the uploader's home.
+func homesEnabled() bool {
+	return loginRequired()
+}
+
+// signedIn returns the identity requireLogin let through.
+func signedIn(r *http.Request) identity {
+	id, _ := r.Context().Value(identityKey{}).(identity)
+	return id
+}
+
+// storedName is what an upload of file by r's user is stored as: in
+// folder if one is given, else in their home. Uploading needs the editor
+// role, on the folder or of the user.
+func storedName(r *http.Request, file, folder string) (string, error) {
+	id := signedIn(r)
+	if folder != "" {
+		if _, ok := teams.folder(folder); !ok {
+			return "", fmt.Errorf("no such folder %s", folder)
+		}
+		if homesEnabled() && roleRank[teams.folderRole(id.Name, folder)] < roleRank[roleEditor] && roleRank[id.Role] < roleRank[roleAdmin] {
+			return "", fmt.Errorf("you may not upload into %s", folder)
+		}
+		return folderName(folder, file), nil
+	}
+	if !homesEnabled() {
+		if strings.HasPrefix(file, homeMarker) || strings.HasPrefix(file, folderMarker) {
+			return "", fmt.Errorf("names starting with %q or %q are kept for homes and folders", homeMarker, folderMarker)
+		}
+		if isPartName(file) {
+			return "", fmt.Errorf("names starting with %q are kept for the parts of composite files", partMarker)
+		}
+		return file, nil
+	}
+	if id.Name == "" {
+		return "", fmt.Errorf("sign in to upload")
+	}
+	if roleRank[id.Role] < roleRank[roleEditor] {
+		return "", fmt.Errorf("your account may not upload (needs the editor role)")
+	}
+	return homeName(id.Name, file), nil
+}
+
+// canSee reports whether id's listing shows the stored name.
+func canSee(id identity, name string) bool {
+	if !homesEnabled() || roleRank[id.Role] >= roleRank[roleAdmin] {
+		return true
+	}
+	if role, ok := folderAccess(id, name); ok {
+		return role != ""
+	}
+	owner, _, ok := splitHomeName(name)
+	return !ok || owner == escapeHomeUser(id.Name)
+}
+
+// canDelete reports whether id may delete the stored name.
+func canDelete(id identity, name string) bool {
+	if !homesEnabled() || roleRank[id.Role] >= roleRank[roleAdmin] {
+		return true
+	}
+	if role, ok := folderAccess(id, name); ok {
+		return roleRank[role] >= roleRank[roleEditor]
+	}
+	owner, _, ok := splitHomeName(name)
+	return ok && owner == escapeHomeUser(id.Name) && roleRank[id.Role] >= roleRank[roleEditor]
+}
+
+// homeFileHandler downloads a file by its home path: GET /u/{user}/{file}.
+func homeFileHandler(w http.ResponseWriter, r *http.Request) {
+	r.SetPathValue("filename", homeName(r.PathValue("user"), r.PathValue("file")))
+	downloadHandler(w, r)
+}
@@ -0,0 +1,122 @@
+package central
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHomeNames(t *testing.T) {
+	for _, user := range []string{"alice", "a~b", "a%7Eb", "ann@example.com"} {
+		name := homeName(user, "report.pdf")
+		owner, file, ok := splitHomeName(name)
+		if !ok || owner != escapeHomeUser(user) || file != "report.pdf" {
+			t.Errorf("%s: %q splits into %q %q %v", user, name, owner, file, ok)
+		}
+	}
+	// No user's home is a prefix of another's.
+	if strings.HasPrefix(homeName("a~b", "x"), homeMarker+escapeHomeUser("a")+"~") {
+		t.Error("a~b's home is inside a's")
+	}
+	if _, _, ok := splitHomeName("report.pdf"); ok {
+		t.Error("a shared file has an owner")
+	}
+}
+
+func TestHomes(t *testing.T) {
+	hash, _ := hashPassword("pw")
+	c := newTestCluster(t, 1, func(cfg *Config) {
+		cfg.Users = []User{{Name: "alice", PasswordHash: hash}, {Name: "bob", PasswordHash: hash}, {Name: "root", PasswordHash: hash, Role: roleAdmin}}
+	})
+	// A file from before homes is shared.
+	os.WriteFile(filepath.Join(uploadDir, "shared.txt"), []byte("old"), 0644)
+
+	do := func(user, method, path string, body io.Reader, contentType string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, c.central.URL+path, body)
+		req.SetBasicAuth(user, "pw")
+		req.Header.Set("Accept", "application/json")
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		resp, err := testClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	upload := func(user, name, content string) {
+		t.Helper()
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		fw, _ := mw.CreateFormFile("file", name)
+		io.WriteString(fw, content)
+		mw.Close()
+		resp := do(user, "POST", "/upload?"+nearLondon.Encode(), &body, mw.FormDataContentType())
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s uploading %s: %d", user, name, resp.StatusCode)
+		}
+	}
+	list := func(user string) map[string]string {
+		t.Helper()
+		resp := do(user, "GET", "/files", nil, "")
+		defer resp.Body.Close()
+		var files []FileListing
+		json.NewDecoder(resp.Body).Decode(&files)
+		out := map[string]string{}
+		for _, f := range files {
+			out[f.Name] = f.Path
+		}
+		return out
+	}
+	del := func(user, name string) int {
+		t.Helper()
+		resp := do(user, "POST", "/delete", strings.NewReader(url.Values{"filename": {name}}.Encode()), "application/x-www-form-urlencoded")
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	upload("alice", "report.pdf", "alice's")
+	upload("bob", "report.pdf", "bob's")
+	upload("bob", homeName("alice", "sneaky"), "bob's")
+	c.waitForJobs()
+
+	alices := list("alice")
+	if len(alices) != 2 || alices["u~alice~report.pdf"] != "/u/alice/report.pdf" || alices["shared.txt"] != "" {
+		t.Errorf("alice sees %v", alices)
+	}
+	if bobs := list("bob"); len(bobs) != 3 || bobs["u~bob~u~alice~sneaky"] != "/u/bob/u~alice~sneaky" {
+		t.Errorf("bob sees %v", bobs)
+	}
+	if all := list("root"); len(all) != 4 {
+		t.Errorf("the admin sees %v", all)
+	}
+
+	if resp, body := c.get("/u/alice/report.pdf?"+nearLondon.Encode(), nil); resp.StatusCode != http.StatusOK || body != "alice's" {
+		t.Errorf("home path download: %d %q", resp.StatusCode, body)
+	}
+
+	if code := del("bob", "u~alice~report.pdf"); code != http.StatusForbidden {
+		t.Errorf("bob deleting alice's file: %d", code)
+	}
+	if code := del("bob", "shared.txt"); code != http.StatusForbidden {
+		t.Errorf("bob deleting a shared file: %d", code)
+	}
+	if code := del("alice", "u~alice~report.pdf"); code != http.StatusSeeOther {
+		t.Errorf("alice deleting her file: %d", code)
+	}
+	if code := del("root", "u~bob~report.pdf"); code != http.StatusSeeOther {
+		t.Errorf("the admin deleting bob's file: %d", code)
+	}
+	if _, err := os.Stat(filepath.Join(uploadDir, "u~bob~report.pdf")); !os.IsNotExist(err) {
+		t.Errorf("bob's file is still there: %v", err)
+	}
+}
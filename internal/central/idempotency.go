@@ -0,0 +1,334 @@
+package central
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+)
+
+// ---------------------------
+// Idempotency Keys
+// ---------------------------
+
+// A client that retries an upload or a delete, say after a dropped
+// connection, sends the same Idempotency-Key header each time; the first
+// request to get through runs, and the others are answered with its
+// response instead of doing the work again. Keys are per user and
+// remembered, with the response, for idempotencyWindow (0 turns them
+// off) in memory: a restart forgets them. Only successful responses are
+// remembered; a request that failed is run again on retry. A key used
+// again while its request is still running gets 409, and one used for a
+// different request, another file included, 422. The cache is bounded:
+// past maxIdempotencyKeysPerUser keys for one user, maxIdempotencyKeys in
+// all or maxIdempotencyBytes of responses, the oldest are forgotten first.
+
+const idempotencyHeader = "Idempotency-Key"
+
+// Keys are at most this long.
+const maxIdempotencyKey = 255
+
+// Responses larger than this are not remembered.
+const maxIdempotentBody = 1 << 20
+
+var idempotencyWindow = 24 * time.Hour
+
+// Bounds on the cache; variables so tests can lower them.
+var (
+	maxIdempotencyKeysPerUser = 1000
+	maxIdempotencyKeys        = 10000
+	maxIdempotencyBytes       = 64 << 20
+)
+
+// idempotentResponse is a request under a key, and once done its response.
+type idempotentResponse struct {
+	user    string
+	request string // what the key was used for
+	seq     uint64 // claim order, for eviction
+	done    bool
+	bodySum string // of the request body, once it has been read
+	status  int
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+type idempotencyCache struct {
+	mu    sync.Mutex
+	keys  map[string]*idempotentResponse
+	seq   uint64
+	bytes int // of the remembered responses
+}
+
+var idempotencyKeys = &idempotencyCache{keys: map[string]*idempotentResponse{}}
+
+func (c *idempotencyCache) removeLocked(key string) {
+	c.bytes -= len(c.keys[key].body)
+	delete(c.keys, key)
+}
+
+func (c *idempotencyCache) expireLocked(now time.Time) {
+	for k, e := range c.keys {
+		if e.done && now.After(e.expires) {
+			c.removeLocked(k)
+		}
+	}
+}
+
+// evictLocked forgets the oldest finished entries until there is room for
+// keys more of user's and bytes more of responses. It reports false if
+// only requests still running are left to forget.
+func (c *idempotencyCache) evictLocked(user string, keys, bytes int) bool {
+	for {
+		mine := 0
+		var oldest, oldestMine *idempotentResponse
+		var oldestKey, oldestMineKey string
+		for k, e := range c.keys {
+			if e.user == user {
+				mine++
+			}
+			if !e.done {
+				continue
+			}
+			if oldest == nil || e.seq < oldest.seq {
+				oldest, oldestKey = e, k
+			}
+			if e.user == user && (oldestMine == nil || e.seq < oldestMine.seq) {
+				oldestMine, oldestMineKey = e, k
+			}
+		}
+		victim := ""
+		switch {
+		case mine+keys > maxIdempotencyKeysPerUser:
+			if oldestMine == nil {
+				return false
+			}
+			victim = oldestMineKey
+		case len(c.keys)+keys > maxIdempotencyKeys || c.bytes+bytes > maxIdempotencyBytes:
+			if oldest == nil {
+				return false
+			}
+			victim = oldestKey
+		default:
+			return true
+		}
+		c.removeLocked(victim)
+	}
+}
+
+// claim returns a copy of the entry for user's key, or records request
+// under it and returns nil for the caller to run it. full reports that
+// there was no room for the key.
+func (c *idempotencyCache) claim(user, key, request string) (e *idempotentResponse, full bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expireLocked(time.Now())
+	key = user + "\n" + key
+	if e, ok := c.keys[key]; ok {
+		cp := *e
+		return &cp, false
+	}
+	if !c.evictLocked(user, 1, 0) {
+		return nil, true
+	}
+	c.seq++
+	c.keys[key] = &idempotentResponse{user: user, request: request, seq: c.seq}
+	return nil, false
+}
+
+// finish remembers the response to user's key, and the digest of the
+// request body, or forgets the key if there is nothing worth remembering
+// or no room for it.
+func (c *idempotencyCache) finish(user, key, bodySum string, iw *idempotentWriter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key = user + "\n" + key
+	e := c.keys[key]
+	if iw == nil || iw.status >= 400 || iw.overflow || !c.evictLocked(user, 0, iw.body.Len()) {
+		delete(c.keys, key)
+		return
+	}
+	e.done, e.bodySum = true, bodySum
+	e.status, e.header, e.body = iw.status, iw.header, iw.body.Bytes()
+	e.expires = time.Now().Add(idempotencyWindow)
+	c.bytes += len(e.body)
+}
+
+// bodyDigest hashes a request body as it is read: a multipart body by its
+// parts' names, file names and contents, so a retry framing the same files
+// with another boundary matches; any other body byte for byte.
+type bodyDigest struct {
+	pw  *io.PipeWriter
+	sum chan string
+}
+
+// digestBody starts hashing what is read of r's body.
+func digestBody(r *http.Request) *bodyDigest {
+	boundary := ""
+	if mt, params, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil && strings.HasPrefix(mt, "multipart/") {
+		boundary = params["boundary"]
+	}
+	pr, pw := io.Pipe()
+	d := &bodyDigest{pw: pw, sum: make(chan string, 1)}
+	go func() {
+		h := sha256.New()
+		if boundary == "" {
+			io.Copy(h, pr)
+		} else if err := hashParts(h, multipart.NewReader(pr, boundary)); err != nil {
+			fmt.Fprintf(h, "malformed: %v", err)
+		}
+		io.Copy(io.Discard, pr) // whatever follows, or did not parse
+		d.sum <- hex.EncodeToString(h.Sum(nil))
+	}()
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.TeeReader(r.Body, pw), r.Body}
+	return d
+}
+
+func hashParts(h hash.Hash, mr *multipart.Reader) error {
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		part := sha256.New()
+		if _, err := io.Copy(part, p); err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%q %q %x\n", p.FormName(), p.FileName(), part.Sum(nil))
+	}
+}
+
+// finish reads the rest of body, the request's, and returns the digest.
+func (d *bodyDigest) finish(body io.Reader) string {
+	io.Copy(io.Discard, body)
+	d.pw.Close()
+	return <-d.sum
+}
+
+// abandon stops hashing.
+func (d *bodyDigest) abandon() {
+	d.pw.CloseWithError(io.ErrUnexpectedEOF)
+}
+
+// idempotentWriter passes a response through and keeps a copy.
+type idempotentWriter struct {
+	http.ResponseWriter
+	status   int
+	header   http.Header
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (w *idempotentWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+		w.header = w.ResponseWriter.Header().Clone()
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *idempotentWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.overflow && w.body.Len()+len(b) <= maxIdempotentBody {
+		w.body.Write(b)
+	} else {
+		w.overflow = true
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach Flush and friends.
+func (w *idempotentWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// idempotent serves retries of a request with an Idempotency-Key from the
+// response to the first. Requests without one go straight through.
+func idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyHeader)
+		if key == "" || idempotencyWindow <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKey {
+			apierr.Send(w, idempotencyHeader+" must be at most "+strconv.Itoa(maxIdempotencyKey)+" characters", http.StatusBadRequest)
+			return
+		}
+		// A delete names its file in the form. Only url-encoded bodies are
+		// parsed: an upload's multipart body is left for the handler.
+		r.ParseForm()
+		request := r.Method + " " + r.URL.Path + "?" + r.URL.RawQuery + "\n" + r.PostForm.Encode()
+		user := signedInUser(r)
+
+		e, full := idempotencyKeys.claim(user, key, request)
+		if full {
+			busy := apierr.New(http.StatusTooManyRequests, "idempotency_keys_exhausted", "Too many requests with an "+idempotencyHeader+" are still running")
+			busy.Retryable = true
+			apierr.Write(w, busy)
+			return
+		}
+		if e != nil {
+			reused := e.request != request
+			if !reused && e.done {
+				reused = digestBody(r).finish(r.Body) != e.bodySum
+			}
+			switch {
+			case reused:
+				apierr.SendCode(w, http.StatusUnprocessableEntity, "idempotency_key_reused", "This "+idempotencyHeader+" was used for a different request")
+			case !e.done:
+				// Asking again once it is done gets its response.
+				busy := apierr.New(http.StatusConflict, "idempotency_key_in_use", "A request with this "+idempotencyHeader+" is still running")
+				busy.Retryable = true
+				apierr.Write(w, busy)
+			default:
+				for k, v := range e.header {
+					if k != apierr.RequestIDHeader { // the retry keeps its own
+						w.Header()[k] = v
+					}
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(e.status)
+				w.Write(e.body)
+			}
+			return
+		}
+
+		var iw *idempotentWriter
+		var bodySum string
+		digest := digestBody(r)
+		defer func() { // iw stays nil on a panic
+			if iw == nil || iw.status >= 400 {
+				digest.abandon()
+			} else {
+				bodySum = digest.finish(r.Body)
+			}
+			idempotencyKeys.finish(user, key, bodySum, iw)
+		}()
+		rec := &idempotentWriter{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.WriteHeader(http.StatusOK)
+		}
+		iw = rec
+	})
+}
@@ -0,0 +1,137 @@
+package central
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestIdempotentDelete(t *testing.T) {
+	c := newTestCluster(t, 2, nil)
+	if resp, _ := c.upload("a.txt", "hello", nearLondon); resp.StatusCode != http.StatusOK {
+		t.Fatalf("upload = %d", resp.StatusCode)
+	}
+	c.waitForJobs()
+
+	del := func(name, key string) (*http.Response, string) {
+		t.Helper()
+		req, _ := http.NewRequest("POST", c.central.URL+"/delete", strings.NewReader(url.Values{"filename": {name}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set(idempotencyHeader, key)
+		resp, err := testClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp, string(b)
+	}
+
+	first, body := del("a.txt", "k1")
+	if first.StatusCode >= 400 {
+		t.Fatalf("delete = %d %s", first.StatusCode, body)
+	}
+	retry, again := del("a.txt", "k1")
+	if retry.StatusCode != first.StatusCode || again != body || retry.Header.Get("Idempotent-Replayed") != "true" {
+		t.Errorf("retry = %d %q replayed=%q, want the first response (%d %q) replayed",
+			retry.StatusCode, again, retry.Header.Get("Idempotent-Replayed"), first.StatusCode, body)
+	}
+	if resp, _ := del("b.txt", "k1"); resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("key reused for another file = %d, want 422", resp.StatusCode)
+	}
+	if resp, _ := del("a.txt", "k2"); resp.Header.Get("Idempotent-Replayed") != "" {
+		t.Error("a new key was answered from the cache")
+	}
+}
+
+func TestIdempotentUpload(t *testing.T) {
+	c := newTestCluster(t, 1, nil)
+	upload := func(content, key string) *http.Response {
+		t.Helper()
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body) // a new boundary each time
+		fw, _ := mw.CreateFormFile("file", "a.txt")
+		io.WriteString(fw, content)
+		mw.Close()
+		req, _ := http.NewRequest("POST", c.central.URL+"/upload?"+nearLondon.Encode(), &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set(idempotencyHeader, key)
+		resp, err := testClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	if resp := upload("first draft", "k1"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("upload = %d", resp.StatusCode)
+	}
+	if resp := upload("first draft", "k1"); resp.StatusCode != http.StatusOK || resp.Header.Get("Idempotent-Replayed") != "true" {
+		t.Errorf("retry = %d replayed=%q", resp.StatusCode, resp.Header.Get("Idempotent-Replayed"))
+	}
+	if resp := upload("second draft", "k1"); resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("key reused for another file = %d, want 422", resp.StatusCode)
+	}
+	c.waitForJobs()
+}
+
+func TestIdempotencyCacheBounds(t *testing.T) {
+	defer func(perUser, all, size int) {
+		maxIdempotencyKeysPerUser, maxIdempotencyKeys, maxIdempotencyBytes = perUser, all, size
+	}(maxIdempotencyKeysPerUser, maxIdempotencyKeys, maxIdempotencyBytes)
+	maxIdempotencyKeysPerUser, maxIdempotencyKeys, maxIdempotencyBytes = 2, 3, 10
+
+	cache := &idempotencyCache{keys: map[string]*idempotentResponse{}}
+	run := func(user, key, response string) {
+		t.Helper()
+		if e, full := cache.claim(user, key, "POST /delete"); e != nil || full {
+			t.Fatalf("%s's %s: taken %v, full %v", user, key, e != nil, full)
+		}
+		iw := &idempotentWriter{status: http.StatusOK}
+		iw.body.WriteString(response)
+		cache.finish(user, key, "", iw)
+	}
+	has := func(user, key string) bool {
+		e, ok := cache.keys[user+"\n"+key]
+		return ok && e.done
+	}
+
+	run("alice", "k1", "a")
+	run("alice", "k2", "b")
+	run("alice", "k3", "c") // alice is at her limit: k1 goes
+	if has("alice", "k1") || !has("alice", "k2") || !has("alice", "k3") {
+		t.Errorf("alice's keys: %q", keyNames(cache))
+	}
+	run("bob", "k1", "123456789") // too large to keep all three: alice's oldest goes
+	if has("alice", "k2") || !has("alice", "k3") || !has("bob", "k1") {
+		t.Errorf("keys after a large response: %q", keyNames(cache))
+	}
+	if cache.bytes != 10 {
+		t.Errorf("bytes = %d, want 10", cache.bytes)
+	}
+
+	// Keys still running are never dropped.
+	cache = &idempotencyCache{keys: map[string]*idempotentResponse{}}
+	cache.claim("carol", "k1", "POST /upload")
+	cache.claim("carol", "k2", "POST /upload")
+	if _, full := cache.claim("carol", "k3", "POST /upload"); !full {
+		t.Error("a third running key for carol was taken")
+	}
+}
+
+func keyNames(c *idempotencyCache) []string {
+	var names []string
+	for k := range c.keys {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,230 @@
+package central
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ---------------------------
+// Node Identity
+// ---------------------------
+
+// NodeInfo mirrors the storage node's /info response.
+type NodeInfo struct {
+	ID        string    `json:"id"`
+	Region    string    `json:"region"`
+	Version   string    `json:"version"`
+	Commit    string    `json:"commit,omitempty"`
+	BuildTime string    `json:"build_time,omitempty"`
+	Created   time.Time `json:"created"`
+	Started   time.Time `json:"started"`
+	Name      string    `json:"name,omitempty"`
+	Lat       float64   `json:"lat,omitempty"`
+	Lon       float64   `json:"lon,omitempty"`
+
+	Quota      *QuotaStatus `json:"quota,omitempty"`
+	Quarantine string       `json:"quarantine,omitempty"` // digest, see quarantineDigest
+	Private    string       `json:"private,omitempty"`    // digest of the private file list
+	Trash      string       `json:"trash,omitempty"`      // digest of the trash list
+	Locked     string       `json:"locked,omitempty"`     // digest of the locked file list
+
+	ChecksumAlgorithm  string   `json:"checksum_algorithm,omitempty"`  // for files without a checksum
+	ChecksumAlgorithms []string `json:"checksum_algorithms,omitempty"` // supported; none: SHA-256 only
+}
+
+// identityRecord pins the node UUID first seen for a configured node ID.
+type identityRecord struct {
+	UUID      string    `json:"uuid"`
+	URL       string    `json:"url"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+const (
+	identityOK       = "ok"
+	identityNew      = "new"
+	identityMoved    = "moved"
+	identityMismatch = "mismatch"
+	identityUnknown  = "unknown"
+)
+
+var errIdentityMismatch = errors.New("node identity mismatch")
+
+type identityRegistry struct {
+	mu      sync.Mutex
+	path    string
+	records map[string]identityRecord // keyed by StorageServer.ID
+	status  map[string]string
+	info    map[string]NodeInfo
+}
+
+var identities = &identityRegistry{
+	records: map[string]identityRecord{},
+	status:  map[string]string{},
+	info:    map[string]NodeInfo{},
+}
+
+func (reg *identityRegistry) load(path string) error {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	reg.path = path
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, &reg.records)
+}
+
+func (reg *identityRegistry) saveLocked() error {
+	if reg.path == "" {
+		return nil
+	}
+	raw, err := json.MarshalIndent(reg.records, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(reg.path), 0755); err != nil {
+		return err
+	}
+	tmp := reg.path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, reg.path)
+}
+
+// observe compares the identity reported by a node against the pinned one.
+// A different UUID at a known node ID means a fresh node (or an impostor) is
+// answering at that address; the pin is kept so the mismatch stays visible
+// until an operator removes the record.
+func (reg *identityRegistry) observe(s StorageServer, info NodeInfo) (string, error) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	now := time.Now().UTC()
+	reg.info[s.ID] = info
+
+	for id, rec := range reg.records {
+		if id != s.ID && rec.UUID == info.ID {
+			reg.status[s.ID] = identityMismatch
+			return identityMismatch, fmt.Errorf("%w: %s at %s reports UUID %s which is pinned to node %s",
+				errIdentityMismatch, s.ID, s.URL, info.ID, id)
+		}
+	}
+
+	rec, ok := reg.records[s.ID]
+	switch {
+	case !ok:
+		reg.records[s.ID] = identityRecord{UUID: info.ID, URL: s.URL, FirstSeen: now, LastSeen: now}
+		reg.status[s.ID] = identityNew
+	case rec.UUID != info.ID:
+		reg.status[s.ID] = identityMismatch
+		return identityMismatch, fmt.Errorf("%w: %s at %s reports UUID %s, expected %s (remove it from %s to accept)",
+			errIdentityMismatch, s.ID, s.URL, info.ID, rec.UUID, reg.path)
+	case rec.URL != s.URL:
+		fmt.Printf("Node %s (%s) moved from %s to %s\n", s.ID, info.ID, rec.URL, s.URL)
+		rec.URL = s.URL
+		rec.LastSeen = now
+		reg.records[s.ID] = rec
+		reg.status[s.ID] = identityMoved
+	default:
+		rec.LastSeen = now
+		reg.records[s.ID] = rec
+		reg.status[s.ID] = identityOK
+	}
+
+	if err := reg.saveLocked(); err != nil {
+		fmt.Println("Cannot save node identities:", err)
+	}
+	return reg.status[s.ID], nil
+}
+
+func (reg *identityRegistry) get(nodeID string) (NodeInfo, string) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	status, ok := reg.status[nodeID]
+	if !ok {
+		status = identityUnknown
+	}
+	return reg.info[nodeID], status
+}
+
+// fetchNodeInfo asks a storage node for its identity.
+func fetchNodeInfo(client *http.Client, s StorageServer) (NodeInfo, error) {
+	var info NodeInfo
+
+	resp, err := client.Get(s.URL + "/info")
+	if err != nil {
+		return info, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return info, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return info, fmt.Errorf("bad /info response: %w", err)
+	}
+	if info.ID == "" {
+		return info, errors.New("node reported an empty id")
+	}
+	return info, nil
+}
+
+// ---------------------------
+// Nodes API
+// ---------------------------
+
+// nodeStatus is one node in the nodes API.
+type nodeStatus struct {
+	StorageServer
+	NodeUUID string        `json:"node_uuid,omitempty"`
+	Region   string        `json:"region,omitempty"`
+	Version  string        `json:"version,omitempty"`
+	Commit   string        `json:"commit,omitempty"`
+	Identity string        `json:"identity"`
+	Health   nodeHealth    `json:"health"`
+	Stats    NodeStats     `json:"stats"`
+	Score    float64       `json:"score"`
+	Breaker  BreakerStatus `json:"breaker"`
+	Full     *FullStatus   `json:"full,omitempty"`
+	Quota    *QuotaStatus  `json:"quota,omitempty"`
+}
+
+func nodesHandler(w http.ResponseWriter, r *http.Request) {
+	out := []nodeStatus{}
+	for _, s := range topo.nodes() {
+		info, status := identities.get(s.ID)
+		var full *FullStatus
+		if st, ok := capacity.get(s.ID); ok {
+			full = &st
+		}
+		out = append(out, nodeStatus{
+			StorageServer: s,
+			NodeUUID:      info.ID,
+			Region:        info.Region,
+			Version:       info.Version,
+			Commit:        info.Commit,
+			Identity:      status,
+			Health:        health.get(s.ID),
+			Stats:         nodeStats.get(s.ID),
+			Score:         healthScore(s.ID),
+			Breaker:       breakers.get(s.ID),
+			Full:          full,
+			Quota:         info.Quota,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
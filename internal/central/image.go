@@ -0,0 +1,448 @@
+package central
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif" // decoded, re-encoded as PNG
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+)
+
+// ---------------------------
+// Image Variants
+// ---------------------------
+
+// GET /image/{filename}?w=&h=&fit= serves a resized copy of an image, so
+// thumbnails do not cost the full original. fit is
+//
+//   - contain (the default): as large as fits in w x h, keeping the aspect
+//     ratio and never enlarging
+//   - cover: fills w x h exactly, cropping the overflow around the center
+//   - fill: stretched to w x h
+//
+// With only w or h, the other follows the aspect ratio. Variants are cached
+// under the data dir, keyed by the version of the original they came from,
+// and the cache is pruned oldest first beyond Config.ImageCacheBytes. Each
+// variant records the image it came from, so erasing a user's data can
+// purge the variants of their images too.
+const (
+	fitContain = "contain"
+	fitCover   = "cover"
+	fitFill    = "fill"
+)
+
+const (
+	maxVariantSide = 4096
+	maxImagePixels = 50_000_000 // of originals; larger ones are not decoded
+)
+
+var (
+	imageCacheDir   string
+	imageCacheBytes int64
+	imageCacheMu    sync.Mutex // serializes pruning
+)
+
+type variantSpec struct {
+	w, h int
+	fit  string
+}
+
+func parseVariantSpec(r *http.Request) (variantSpec, error) {
+	q := r.URL.Query()
+	spec := variantSpec{fit: q.Get("fit")}
+	if spec.fit == "" {
+		spec.fit = fitContain
+	}
+	if spec.fit != fitContain && spec.fit != fitCover && spec.fit != fitFill {
+		return spec, fmt.Errorf("unknown fit %q (want contain, cover or fill)", spec.fit)
+	}
+	for _, d := range []struct {
+		key string
+		v   *int
+	}{{"w", &spec.w}, {"h", &spec.h}} {
+		v := q.Get(d.key)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxVariantSide {
+			return spec, fmt.Errorf("%s must be 1-%d", d.key, maxVariantSide)
+		}
+		*d.v = n
+	}
+	if spec.w == 0 && spec.h == 0 {
+		return spec, errors.New("w or h required")
+	}
+	return spec, nil
+}
+
+// size works out the variant's dimensions for a source of sw x sh.
+func (s variantSpec) size(sw, sh int) (int, int) {
+	w, h := s.w, s.h
+	switch {
+	case w == 0:
+		w = max(1, sw*h/sh)
+	case h == 0:
+		h = max(1, sh*w/sw)
+	}
+	if s.fit != fitContain {
+		return w, h
+	}
+	// Fit inside w x h, no larger than the original.
+	scale := min(float64(w)/float64(sw), float64(h)/float64(sh), 1)
+	return max(1, int(float64(sw)*scale+0.5)), max(1, int(float64(sh)*scale+0.5))
+}
+
+func imageHandler(w http.ResponseWriter, r *http.Request) {
+	filename := r.PathValue("filename")
+	if refuseQuarantined(w, filename) {
+		return
+	}
+	if refusePrivate(w, r, filename) {
+		return
+	}
+	spec, err := parseVariantSpec(r)
+	if err != nil {
+		apierr.Send(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	client, err := locateClient(r)
+	if err != nil {
+		apierr.Send(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	src, version, ok := imageSource(r, filename, client)
+	if !ok {
+		apierr.Send(w, "File not found", http.StatusNotFound)
+		return
+	}
+	key := variantKey(filename, version, spec)
+	if serveCachedVariant(w, r, key) {
+		return
+	}
+
+	body, err := src()
+	if err != nil {
+		apierr.Send(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer body.Close()
+	out, contentType, err := makeVariant(body, spec)
+	if err != nil {
+		apierr.Send(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+	storeVariant(key, filename, contentType, out)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", `"`+key+`"`)
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(out))
+}
+
+// imageSource finds filename, the central copy first, then the nearest
+// replica. version identifies its content, for the cache key; src opens it.
+func imageSource(r *http.Request, filename string, client clientRef) (src func() (io.ReadCloser, error), version string, ok bool) {
+	if hasCentralCopy(filename) {
+		path := filepath.Join(uploadDir, filepath.Base(filename))
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, "", false
+		}
+		version = centralVersion(fi)
+		return func() (io.ReadCloser, error) { return os.Open(path) }, version, true
+	}
+	for _, n := range preferHealthy(rankStorages(client)) {
+		if !health.isHealthy(n.ID) {
+			continue
+		}
+		h, found := headReplica(r.Context(), n.StorageServer, filename)
+		if !found {
+			continue
+		}
+		s := n.StorageServer
+		return func() (io.ReadCloser, error) {
+			req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, nodeFileURL(s, filename), nil)
+			if err != nil {
+				return nil, err
+			}
+			resp, err := nodeClient.Do(req)
+			if err != nil {
+				return nil, err
+			}
+			if resp.StatusCode != http.StatusOK {
+				resp.Body.Close()
+				return nil, &statusError{Status: resp.StatusCode}
+			}
+			return resp.Body, nil
+		}, h.Get("ETag") + h.Get("Last-Modified"), true
+	}
+	return nil, "", false
+}
+
+// centralVersion identifies the content of a central copy, for cache keys.
+func centralVersion(fi os.FileInfo) string {
+	return fmt.Sprintf("%d-%d", fi.Size(), fi.ModTime().UnixNano())
+}
+
+// thumbnailSpec is the variant the thumbnail task renders ahead of the
+// first request for it: /image/{file}?w=256&h=256.
+var thumbnailSpec = variantSpec{w: 256, h: 256, fit: fitContain}
+
+// imageExts are what the thumbnail task renders; makeVariant decodes them.
+var imageExts = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".gif": true}
+
+// thumbnailTask caches the thumbnail variant of the central copy of an
+// image. Anything else, and a file without a central copy, is left alone.
+func thumbnailTask(_ context.Context, file string) error {
+	if imageCacheBytes == 0 || !imageExts[strings.ToLower(filepath.Ext(file))] {
+		return nil
+	}
+	f, err := os.Open(filepath.Join(uploadDir, file))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	out, contentType, err := makeVariant(f, thumbnailSpec)
+	if err != nil {
+		return err
+	}
+	storeVariant(variantKey(file, centralVersion(fi), thumbnailSpec), file, contentType, out)
+	return nil
+}
+
+func variantKey(filename, version string, spec variantSpec) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%d\x00%d\x00%s", filename, version, spec.w, spec.h, spec.fit)))
+	return hex.EncodeToString(sum[:16])
+}
+
+// makeVariant decodes an image and encodes the resized copy: JPEG stays
+// JPEG, anything else becomes PNG.
+func makeVariant(body io.Reader, spec variantSpec) ([]byte, string, error) {
+	var head bytes.Buffer
+	cfg, format, err := image.DecodeConfig(io.TeeReader(body, &head))
+	if err != nil {
+		return nil, "", fmt.Errorf("not an image: %w", err)
+	}
+	if cfg.Width*cfg.Height > maxImagePixels {
+		return nil, "", fmt.Errorf("image too large to resize (%dx%d)", cfg.Width, cfg.Height)
+	}
+	src, _, err := image.Decode(io.MultiReader(&head, body))
+	if err != nil {
+		return nil, "", err
+	}
+
+	b := src.Bounds()
+	w, h := spec.size(b.Dx(), b.Dy())
+	if spec.fit == fitCover {
+		b = coverCrop(b, w, h)
+	}
+	dst := resizeBox(src, b, w, h)
+
+	var out bytes.Buffer
+	if format == "jpeg" {
+		err = jpeg.Encode(&out, dst, &jpeg.Options{Quality: 85})
+		return out.Bytes(), "image/jpeg", err
+	}
+	err = png.Encode(&out, dst)
+	return out.Bytes(), "image/png", err
+}
+
+// coverCrop returns the centered part of b with the aspect ratio of w x h.
+func coverCrop(b image.Rectangle, w, h int) image.Rectangle {
+	cw, ch := b.Dx(), b.Dy()
+	if cw*h > ch*w {
+		cw = max(1, ch*w/h)
+	} else {
+		ch = max(1, cw*h/w)
+	}
+	x := b.Min.X + (b.Dx()-cw)/2
+	y := b.Min.Y + (b.Dy()-ch)/2
+	return image.Rect(x, y, x+cw, y+ch)
+}
+
+// resizeBox scales the part r of src to w x h, each output pixel the
+// average of the source pixels it covers (or the nearest one when
+// enlarging): plain, but free of the aliasing of point sampling.
+func resizeBox(src image.Image, r image.Rectangle, w, h int) *image.RGBA {
+	in := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(in, in.Bounds(), src, r.Min, draw.Src)
+	out := image.NewRGBA(image.Rect(0, 0, w, h))
+	sw, sh := r.Dx(), r.Dy()
+	for y := 0; y < h; y++ {
+		y0, y1 := y*sh/h, max((y+1)*sh/h, y*sh/h+1)
+		for x := 0; x < w; x++ {
+			x0, x1 := x*sw/w, max((x+1)*sw/w, x*sw/w+1)
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := in.Pix[sy*in.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					sum[0] += int(p[0])
+					sum[1] += int(p[1])
+					sum[2] += int(p[2])
+					sum[3] += int(p[3])
+				}
+			}
+			n := (y1 - y0) * (x1 - x0)
+			o := out.Pix[y*out.Stride+x*4:]
+			for i := range 4 {
+				o[i] = uint8(sum[i] / n)
+			}
+		}
+	}
+	return out
+}
+
+// serveCachedVariant serves the variant key from the cache, if it is there.
+func serveCachedVariant(w http.ResponseWriter, r *http.Request, key string) bool {
+	if imageCacheBytes == 0 {
+		return false
+	}
+	path := filepath.Join(imageCacheDir, key)
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	contentType := "image/png"
+	if ct, err := os.ReadFile(path + ".type"); err == nil {
+		contentType = string(ct)
+	}
+	now := time.Now()
+	os.Chtimes(path, now, now) // recently used: pruned last
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", `"`+key+`"`)
+	w.Header().Set("X-Cache", "hit")
+	http.ServeContent(w, r, "", time.Time{}, f)
+	return true
+}
+
+// storeVariant caches a variant of source, then prunes the cache back to
+// its limit.
+func storeVariant(key, source, contentType string, data []byte) {
+	if imageCacheBytes == 0 {
+		return
+	}
+	if err := os.MkdirAll(imageCacheDir, 0755); err != nil {
+		fmt.Println("Image cache:", err)
+		return
+	}
+	path := filepath.Join(imageCacheDir, key)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		fmt.Println("Image cache:", err)
+		return
+	}
+	os.WriteFile(path+".type", []byte(contentType), 0644)
+	os.WriteFile(path+".src", []byte(source), 0644)
+	os.Rename(tmp, path)
+	pruneImageCache()
+}
+
+// pruneImageCache removes the least recently used variants until the
+// cache fits in imageCacheBytes.
+func pruneImageCache() {
+	imageCacheMu.Lock()
+	defer imageCacheMu.Unlock()
+	entries, err := os.ReadDir(imageCacheDir)
+	if err != nil {
+		return
+	}
+	type variant struct {
+		path string
+		size int64
+		used time.Time
+	}
+	var variants []variant
+	var total int64
+	for _, e := range entries {
+		if filepath.Ext(e.Name()) != "" {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+		variants = append(variants, variant{filepath.Join(imageCacheDir, e.Name()), fi.Size(), fi.ModTime()})
+		total += fi.Size()
+	}
+	sort.Slice(variants, func(i, j int) bool { return variants[i].used.Before(variants[j].used) })
+	for _, v := range variants {
+		if total <= imageCacheBytes {
+			break
+		}
+		os.Remove(v.path)
+		os.Remove(v.path + ".type")
+		os.Remove(v.path + ".src")
+		total -= v.size
+	}
+}
+
+// cachedVariants returns the image each cached variant came from, by key:
+// "" for variants cached before their sources were recorded.
+func cachedVariants() map[string]string {
+	entries, err := os.ReadDir(imageCacheDir)
+	if err != nil {
+		return nil
+	}
+	out := map[string]string{}
+	for _, e := range entries {
+		if filepath.Ext(e.Name()) != "" {
+			continue
+		}
+		src, _ := os.ReadFile(filepath.Join(imageCacheDir, e.Name()+".src"))
+		out[e.Name()] = string(src)
+	}
+	return out
+}
+
+// purgeVariants removes the cached variants of the images match picks, and
+// returns their keys by image. Variants of unknown source go too, as they
+// could be any image's; unknown counts them.
+func purgeVariants(match func(source string) bool) (purged map[string][]string, unknown int) {
+	imageCacheMu.Lock()
+	defer imageCacheMu.Unlock()
+	purged = map[string][]string{}
+	for key, src := range cachedVariants() {
+		if src != "" && !match(src) {
+			continue
+		}
+		path := filepath.Join(imageCacheDir, key)
+		os.Remove(path)
+		os.Remove(path + ".type")
+		os.Remove(path + ".src")
+		if src == "" {
+			unknown++
+			continue
+		}
+		purged[src] = append(purged[src], key)
+	}
+	for _, keys := range purged {
+		sort.Strings(keys)
+	}
+	return purged, unknown
+}
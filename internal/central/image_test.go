@@ -0,0 +1,67 @@
+package central
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestVariantSize(t *testing.T) {
+	tests := []struct {
+		spec   variantSpec
+		sw, sh int
+		w, h   int
+	}{
+		{variantSpec{w: 100, h: 100, fit: fitContain}, 400, 200, 100, 50},
+		{variantSpec{w: 100, fit: fitContain}, 400, 200, 100, 50},
+		{variantSpec{h: 100, fit: fitContain}, 400, 200, 200, 100},
+		{variantSpec{w: 1000, h: 1000, fit: fitContain}, 400, 200, 400, 200}, // never enlarged
+		{variantSpec{w: 100, h: 100, fit: fitCover}, 400, 200, 100, 100},
+		{variantSpec{w: 100, h: 30, fit: fitFill}, 400, 200, 100, 30},
+	}
+	for _, tt := range tests {
+		if w, h := tt.spec.size(tt.sw, tt.sh); w != tt.w || h != tt.h {
+			t.Errorf("%+v of %dx%d = %dx%d, want %dx%d", tt.spec, tt.sw, tt.sh, w, h, tt.w, tt.h)
+		}
+	}
+}
+
+func TestClusterImageVariants(t *testing.T) {
+	c := newTestCluster(t, 2, nil)
+	img := image.NewRGBA(image.Rect(0, 0, 400, 200))
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 400; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 0, 255})
+		}
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	c.upload("photo.png", buf.String(), nearLondon)
+	c.upload("notes.txt", "not an image", nearLondon)
+
+	for _, cache := range []string{"", "hit"} {
+		resp, body := c.get("/image/photo.png?w=100&h=100&fit=cover", nil)
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("X-Cache") != cache {
+			t.Fatalf("variant: status %d, cache %q, want %q", resp.StatusCode, resp.Header.Get("X-Cache"), cache)
+		}
+		cfg, format, err := image.DecodeConfig(strings.NewReader(body))
+		if err != nil || format != "png" || cfg.Width != 100 || cfg.Height != 100 {
+			t.Errorf("variant is %s %dx%d (%v), want png 100x100", format, cfg.Width, cfg.Height, err)
+		}
+	}
+
+	for path, want := range map[string]int{
+		"/image/notes.txt?w=100":       http.StatusUnsupportedMediaType,
+		"/image/photo.png":             http.StatusBadRequest,
+		"/image/photo.png?w=100&fit=x": http.StatusBadRequest,
+		"/image/missing.png?w=100":     http.StatusNotFound,
+	} {
+		if resp, _ := c.get(path, nil); resp.StatusCode != want {
+			t.Errorf("%s: status %d, want %d", path, resp.StatusCode, want)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package central
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+)
+
+// ---------------------------
+// Impersonation
+// ---------------------------
+
+// For support, an admin can act as a user without sharing their password:
+// POST /api/v1/admin/impersonate hands out a short-lived token that signs
+// requests in as the user (Authorization: Bearer imp_...). It takes an
+// admin signed in as themselves, not the admin token, so the token names
+// the admin who asked for it, and why; each request made with it is
+// logged under both names, and its access records carry the admin in
+// impersonated_by. Only configured users below the admin role can be
+// impersonated. Tokens live in memory: a restart revokes them all.
+
+const impersonationPrefix = "imp_"
+
+const (
+	defaultImpersonationTTL = time.Hour
+	maxImpersonationTTL     = 8 * time.Hour
+)
+
+// Impersonation is one token, as admins see it; the token itself is only
+// shown when it is made.
+type Impersonation struct {
+	ID       string    `json:"id"`
+	User     string    `json:"user"`
+	Admin    string    `json:"admin"`
+	Reason   string    `json:"reason"`
+	Created  time.Time `json:"created"`
+	Expires  time.Time `json:"expires"`
+	Requests int64     `json:"requests"`
+}
+
+// impersonationRegistry keeps tokens by their sessionKey, like sessions.
+type impersonationRegistry struct {
+	mu sync.Mutex
+	m  map[string]*Impersonation
+}
+
+var impersonations = newImpersonations()
+
+func newImpersonations() *impersonationRegistry {
+	return &impersonationRegistry{m: map[string]*Impersonation{}}
+}
+
+// start makes a token for admin to act as user until ttl is up.
+func (reg *impersonationRegistry) start(user, admin, reason string, ttl time.Duration) (string, Impersonation) {
+	b := make([]byte, 32)
+	rand.Read(b)
+	token := impersonationPrefix + base64.RawURLEncoding.EncodeToString(b)
+	id := make([]byte, 6)
+	rand.Read(id)
+	now := time.Now().UTC()
+	imp := &Impersonation{
+		ID:      hex.EncodeToString(id),
+		User:    user,
+		Admin:   admin,
+		Reason:  reason,
+		Created: now,
+		Expires: now.Add(ttl),
+	}
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.pruneLocked()
+	reg.m[sessionKey(token)] = imp
+	return token, *imp
+}
+
+// get returns the live impersonation token stands for.
+func (reg *impersonationRegistry) get(token string) (Impersonation, bool) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	imp, ok := reg.m[sessionKey(token)]
+	if !ok || time.Now().After(imp.Expires) {
+		return Impersonation{}, false
+	}
+	return *imp, true
+}
+
+// used counts a request made with token.
+func (reg *impersonationRegistry) used(token string) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if imp, ok := reg.m[sessionKey(token)]; ok {
+		imp.Requests++
+	}
+}
+
+// list returns the live impersonations, oldest first.
+func (reg *impersonationRegistry) list() []Impersonation {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.pruneLocked()
+	out := []Impersonation{}
+	for _, imp := range reg.m {
+		out = append(out, *imp)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Created.Before(out[j].Created) })
+	return out
+}
+
+// revoke ends the impersonation with id, reporting whether there was one.
+func (reg *impersonationRegistry) revoke(id string) (Impersonation, bool) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	for k, imp := range reg.m {
+		if imp.ID == id {
+			delete(reg.m, k)
+			return *imp, true
+		}
+	}
+	return Impersonation{}, false
+}
+
+// revokeUser ends every impersonation of user, returning how many.
+func (reg *impersonationRegistry) revokeUser(user string) int {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	n := 0
+	for k, imp := range reg.m {
+		if imp.User == user {
+			delete(reg.m, k)
+			n++
+		}
+	}
+	return n
+}
+
+func (reg *impersonationRegistry) pruneLocked() {
+	now := time.Now()
+	for k, imp := range reg.m {
+		if now.After(imp.Expires) {
+			delete(reg.m, k)
+		}
+	}
+}
+
+// impersonationToken returns the impersonation token r bears, or "".
+func impersonationToken(r *http.Request) string {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || !strings.HasPrefix(token, impersonationPrefix) {
+		return ""
+	}
+	return token
+}
+
+// impersonating returns the impersonation r is made under, and the role
+// the config now gives its user. Like a session, it follows the config: it
+// ends when the user is removed, and stops working should the user become
+// an admin.
+func impersonating(r *http.Request) (Impersonation, string, bool) {
+	token := impersonationToken(r)
+	if token == "" {
+		return Impersonation{}, "", false
+	}
+	imp, ok := impersonations.get(token)
+	if !ok {
+		return Impersonation{}, "", false
+	}
+	u, ok := users[imp.User]
+	if !ok || u.role() == roleAdmin {
+		return Impersonation{}, "", false
+	}
+	return imp, u.role(), true
+}
+
+// auditImpersonation logs a request let through under an impersonation.
+func auditImpersonation(r *http.Request, id identity) {
+	impersonations.used(impersonationToken(r))
+	fmt.Printf("Impersonation: %s as %s: %s %s (request %s)\n",
+		id.ImpersonatedBy, id.Name, r.Method, r.URL.RequestURI(), r.Header.Get(apierr.RequestIDHeader))
+}
+
+// impersonateHandler makes a token for the signed-in admin to act as a
+// user: POST /api/v1/admin/impersonate with {"user", "reason", "ttl"}. A
+// reason is required, for the log.
+func impersonateHandler(w http.ResponseWriter, r *http.Request) {
+	admin := signedIn(r)
+	if admin.Name == "" || admin.Role != roleAdmin || admin.ImpersonatedBy != "" {
+		apierr.Send(w, "Impersonation takes an admin signed in as themselves", http.StatusForbidden)
+		return
+	}
+	var req struct {
+		User   string   `json:"user"`
+		Reason string   `json:"reason"`
+		TTL    Duration `json:"ttl"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<10)).Decode(&req); err != nil {
+		apierr.Send(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	switch {
+	case req.Reason == "":
+		apierr.Send(w, "reason is required", http.StatusBadRequest)
+		return
+	case req.TTL.Duration < 0 || req.TTL.Duration > maxImpersonationTTL:
+		apierr.Send(w, fmt.Sprintf("ttl must be at most %s", maxImpersonationTTL), http.StatusBadRequest)
+		return
+	}
+	u, ok := users[req.User]
+	if !ok {
+		apierr.Send(w, "No such user", http.StatusNotFound)
+		return
+	}
+	if u.role() == roleAdmin {
+		apierr.Send(w, "Admins cannot be impersonated", http.StatusForbidden)
+		return
+	}
+	ttl := req.TTL.Duration
+	if ttl == 0 {
+		ttl = defaultImpersonationTTL
+	}
+	token, imp := impersonations.start(req.User, admin.Name, req.Reason, ttl)
+	fmt.Printf("Impersonation %s started: %s as %s until %s: %s\n",
+		imp.ID, imp.Admin, imp.User, imp.Expires.Format(time.RFC3339), imp.Reason)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		Impersonation
+		Token string `json:"token"`
+	}{imp, token})
+}
+
+// impersonationsHandler lists the live impersonations:
+// GET /api/v1/admin/impersonations.
+func impersonationsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(impersonations.list())
+}
+
+// impersonationRevokeHandler ends an impersonation before it expires:
+// DELETE /api/v1/admin/impersonations/{id}.
+func impersonationRevokeHandler(w http.ResponseWriter, r *http.Request) {
+	imp, ok := impersonations.revoke(r.PathValue("id"))
+	if !ok {
+		apierr.Send(w, "No such impersonation", http.StatusNotFound)
+		return
+	}
+	fmt.Printf("Impersonation %s revoked: %s as %s, %d requests\n", imp.ID, imp.Admin, imp.User, imp.Requests)
+	w.WriteHeader(http.StatusNoContent)
+}
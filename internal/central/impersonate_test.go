@@ -0,0 +1,153 @@
+package central
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestImpersonation(t *testing.T) {
+	hash, _ := hashPassword("pw")
+	logPath := filepath.Join(t.TempDir(), "access.log")
+	c := newTestCluster(t, 2, func(cfg *Config) {
+		cfg.AdminToken = "s3cret"
+		cfg.AccessLog = logPath
+		cfg.Users = []User{{Name: "alice", PasswordHash: hash}, {Name: "vera", PasswordHash: hash, Role: roleViewer}, {Name: "root", PasswordHash: hash, Role: roleAdmin}}
+	})
+	admin := func(method, path, body string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, c.central.URL+path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer s3cret")
+		resp, err := testClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	upload := func(token, name string) int {
+		t.Helper()
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		fw, _ := mw.CreateFormFile("file", name)
+		io.WriteString(fw, "support test")
+		mw.Close()
+		req, _ := http.NewRequest("POST", c.central.URL+"/upload?"+nearLondon.Encode(), &body)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		resp, err := testClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	impersonate := func(as, body string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest("POST", c.central.URL+"/api/v1/admin/impersonate", strings.NewReader(body))
+		if as == "token" {
+			req.Header.Set("Authorization", "Bearer s3cret")
+		} else {
+			req.SetBasicAuth(as, "pw")
+		}
+		resp, err := testClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// The admin token names nobody, and alice is no admin.
+	for as, want := range map[string]int{"token": http.StatusUnauthorized, "alice": http.StatusForbidden} {
+		resp := impersonate(as, `{"user":"alice","reason":"x"}`)
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("impersonating as %s: %d, want %d", as, resp.StatusCode, want)
+		}
+	}
+	for body, want := range map[string]int{
+		`{"user":"alice"}`:                         http.StatusBadRequest,
+		`{"user":"alice","reason":"x","ttl":"9h"}`: http.StatusBadRequest,
+		`{"user":"nobody","reason":"x"}`:           http.StatusNotFound,
+		`{"user":"root","reason":"x"}`:             http.StatusForbidden,
+	} {
+		resp := impersonate("root", body)
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("%s: %d, want %d", body, resp.StatusCode, want)
+		}
+	}
+
+	// The audited admin is who signed in, whatever the body claims.
+	resp := impersonate("root", `{"user":"alice","admin":"carol","reason":"ticket 1234"}`)
+	var got struct {
+		Impersonation
+		Token string `json:"token"`
+	}
+	json.NewDecoder(resp.Body).Decode(&got)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || !strings.HasPrefix(got.Token, impersonationPrefix) || got.User != "alice" {
+		t.Fatalf("impersonate: %d %+v", resp.StatusCode, got)
+	}
+
+	if status := upload(got.Token, "report.txt"); status != http.StatusOK {
+		t.Fatalf("upload as alice: %d", status)
+	}
+	if status := upload(impersonationPrefix+"forged", "report.txt"); status != http.StatusUnauthorized {
+		t.Errorf("upload with an unknown token: %d", status)
+	}
+
+	resp = admin("GET", "/api/v1/admin/impersonations", "")
+	var list []Impersonation
+	json.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	if len(list) != 1 || list[0].ID != got.ID || list[0].Admin != "root" || list[0].Requests != 1 {
+		t.Errorf("impersonations: %+v", list)
+	}
+
+	f, err := os.Open(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tagged bool
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var rec AccessRecord
+		json.Unmarshal(sc.Bytes(), &rec)
+		if rec.File == homeName("alice", "report.txt") {
+			tagged = rec.User == "alice" && rec.ImpersonatedBy == "root"
+		}
+	}
+	f.Close()
+	if !tagged {
+		t.Error("the upload's access record does not name alice impersonated by root")
+	}
+
+	// A token acts with the role the config gives its user.
+	resp = impersonate("root", `{"user":"vera","reason":"ticket 1235"}`)
+	var viewer struct {
+		Token string `json:"token"`
+	}
+	json.NewDecoder(resp.Body).Decode(&viewer)
+	resp.Body.Close()
+	if status := upload(viewer.Token, "viewer.txt"); status != http.StatusForbidden {
+		t.Errorf("upload as vera the viewer: %d", status)
+	}
+
+	resp = admin("DELETE", "/api/v1/admin/impersonations/"+got.ID, "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("revoke: %d", resp.StatusCode)
+	}
+	if status := upload(got.Token, "again.txt"); status != http.StatusUnauthorized {
+		t.Errorf("upload with a revoked token: %d", status)
+	}
+}
@@ -0,0 +1,240 @@
+package central
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ---------------------------
+// Cluster Config Store
+// ---------------------------
+
+// ClusterConfig is the shared document kept in etcd or Consul. Every central
+// API instance watches it, so edits take effect cluster-wide immediately.
+type ClusterConfig struct {
+	Storages          []StorageServer `json:"storages"`
+	ReplicationFactor int             `json:"replication_factor"`
+}
+
+// configStore streams the raw value of a key: apply is called with the
+// current value and again on every change, until ctx ends or the watch
+// breaks (the caller reconnects).
+type configStore interface {
+	Watch(ctx context.Context, key string, apply func([]byte)) error
+}
+
+func newConfigStore(kind, endpoint, token string) (configStore, error) {
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	switch kind {
+	case "etcd":
+		return &etcdStore{endpoint: endpoint, token: token}, nil
+	case "consul":
+		return &consulStore{endpoint: endpoint, token: token}, nil
+	}
+	return nil, fmt.Errorf("unknown config store %q (want etcd or consul)", kind)
+}
+
+// streamClient has no overall timeout: watches are long-lived requests.
+var streamClient = &http.Client{}
+
+// ---------------------------
+// Consul (blocking queries on the KV HTTP API)
+// ---------------------------
+type consulStore struct {
+	endpoint string
+	token    string
+}
+
+func (c *consulStore) Watch(ctx context.Context, key string, apply func([]byte)) error {
+	index := "0"
+	for {
+		q := url.Values{"raw": {""}, "index": {index}, "wait": {"5m"}}
+		req, err := http.NewRequestWithContext(ctx, "GET", c.endpoint+"/v1/kv/"+key+"?"+q.Encode(), nil)
+		if err != nil {
+			return err
+		}
+		if c.token != "" {
+			req.Header.Set("X-Consul-Token", c.token)
+		}
+
+		resp, err := streamClient.Do(req)
+		if err != nil {
+			return err
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		newIndex := resp.Header.Get("X-Consul-Index")
+		switch resp.StatusCode {
+		case http.StatusOK:
+			if newIndex != index {
+				apply(body)
+			}
+		case http.StatusNotFound:
+			// Key not created yet; keep waiting on the index.
+		default:
+			return fmt.Errorf("consul: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+		}
+
+		// Consul docs: reset if the index goes backwards.
+		if n, _ := strconv.ParseUint(newIndex, 10, 64); n == 0 {
+			index = "0"
+		} else if o, _ := strconv.ParseUint(index, 10, 64); n < o {
+			index = "0"
+		} else {
+			index = newIndex
+		}
+	}
+}
+
+// ---------------------------
+// etcd (v3 JSON gRPC gateway)
+// ---------------------------
+type etcdStore struct {
+	endpoint string
+	token    string
+}
+
+func (e *etcdStore) post(ctx context.Context, path string, body interface{}) (*http.Response, error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", e.endpoint+path, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.token != "" {
+		req.Header.Set("Authorization", e.token)
+	}
+	resp, err := streamClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("etcd %s: %s: %s", path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+func (e *etcdStore) Watch(ctx context.Context, key string, apply func([]byte)) error {
+	b64Key := base64.StdEncoding.EncodeToString([]byte(key))
+
+	resp, err := e.post(ctx, "/v3/kv/range", map[string]string{"key": b64Key})
+	if err != nil {
+		return err
+	}
+	var rng struct {
+		Header struct {
+			Revision string `json:"revision"`
+		} `json:"header"`
+		Kvs []struct {
+			Value []byte `json:"value"`
+		} `json:"kvs"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&rng)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("etcd range: %w", err)
+	}
+	if len(rng.Kvs) > 0 {
+		apply(rng.Kvs[0].Value)
+	}
+
+	rev, _ := strconv.ParseInt(rng.Header.Revision, 10, 64)
+	resp, err = e.post(ctx, "/v3/watch", map[string]interface{}{
+		"create_request": map[string]interface{}{
+			"key":            b64Key,
+			"start_revision": strconv.FormatInt(rev+1, 10),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// The gateway streams one JSON object per watch response.
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var msg struct {
+			Result struct {
+				Events []struct {
+					Type string `json:"type"`
+					Kv   struct {
+						Value []byte `json:"value"`
+					} `json:"kv"`
+				} `json:"events"`
+			} `json:"result"`
+			Error *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := dec.Decode(&msg); err != nil {
+			return fmt.Errorf("etcd watch: %w", err)
+		}
+		if msg.Error != nil {
+			return errors.New("etcd watch: " + msg.Error.Message)
+		}
+		for _, ev := range msg.Result.Events {
+			if ev.Type != "DELETE" {
+				apply(ev.Kv.Value)
+			}
+		}
+	}
+}
+
+// ---------------------------
+// Applying updates
+// ---------------------------
+
+// applyClusterConfig validates a document from the store and swaps it in.
+// Invalid documents are rejected and the previous topology stays in place.
+func applyClusterConfig(raw []byte) error {
+	var cc ClusterConfig
+	if err := json.Unmarshal(raw, &cc); err != nil {
+		return fmt.Errorf("parse cluster config: %w", err)
+	}
+	cfg := Config{Storages: cc.Storages, ReplicationFactor: cc.ReplicationFactor}
+	cfg.normalizeStorages()
+	if errs := cfg.validateStorages(); len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	if cc.ReplicationFactor < 0 {
+		return fmt.Errorf("replication_factor must not be negative")
+	}
+
+	topo.setStatic(cfg.Storages)
+	replicationFactor.Store(int32(cc.ReplicationFactor))
+	fmt.Printf("Applied cluster config: %d storage nodes, replication factor %d\n", len(cfg.Storages), cc.ReplicationFactor)
+	return nil
+}
+
+// watchClusterConfig keeps the watch running, reconnecting with a short
+// backoff whenever it breaks.
+func watchClusterConfig(store configStore, key string) {
+	for {
+		err := store.Watch(context.Background(), key, func(raw []byte) {
+			if err := applyClusterConfig(raw); err != nil {
+				fmt.Println("Rejected cluster config from store:", err)
+			}
+		})
+		fmt.Println("Config store watch ended:", err)
+		time.Sleep(5 * time.Second)
+	}
+}
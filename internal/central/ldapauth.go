@@ -0,0 +1,245 @@
+package central
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/ldap"
+)
+
+// ---------------------------
+// LDAP / Active Directory Login
+// ---------------------------
+
+// Passwords can be checked against a directory as well as the configured
+// users: a name with no password of its own under Users is tried against
+// LDAP. The
+// user is found with UserFilter under BaseDN, as BindDN when a service
+// account is configured, or else bound as UserDN directly (for AD,
+// "%s@corp.example.com") and looked up as themselves. Their groups
+// (GroupAttribute of their entry, memberOf by default) map to a role
+// through Roles; a configured user of the same name keeps their configured
+// role.
+
+// LDAPConfig configures the directory; it is off without URL.
+type LDAPConfig struct {
+	URL            string            `json:"url"` // ldaps://ad.example.com, or ldap:// with StartTLS
+	StartTLS       bool              `json:"start_tls"`
+	CAFile         string            `json:"ca_file"` // for a private CA
+	BindDN         string            `json:"bind_dn"` // service account to look users up with
+	BindPassword   string            `json:"bind_password"`
+	UserDN         string            `json:"user_dn"` // without a service account: the user's bind DN, %s = name
+	BaseDN         string            `json:"base_dn"`
+	UserFilter     string            `json:"user_filter"`     // %s = name; default (sAMAccountName=%s)
+	GroupAttribute string            `json:"group_attribute"` // default memberOf
+	Roles          map[string]string `json:"roles"`           // group DN -> role
+	DefaultRole    string            `json:"default_role"`    // "" = refuse users no group maps to
+	Timeout        Duration          `json:"timeout"`         // default 5s
+}
+
+func (c LDAPConfig) validate() []error {
+	if c.URL == "" {
+		return nil
+	}
+	var errs []error
+	if u, err := url.Parse(c.URL); err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("ldap.url %q must be ldap:// or ldaps://", c.URL))
+	}
+	if c.BindDN == "" && c.UserDN == "" {
+		errs = append(errs, fmt.Errorf("ldap needs bind_dn (a service account) or user_dn"))
+	}
+	if c.BindDN != "" && c.BaseDN == "" {
+		errs = append(errs, fmt.Errorf("ldap.base_dn is required with ldap.bind_dn"))
+	}
+	if c.UserDN != "" && strings.Count(c.UserDN, "%s") != 1 {
+		errs = append(errs, fmt.Errorf("ldap.user_dn %q must contain %%s once", c.UserDN))
+	}
+	if c.UserFilter != "" && strings.Count(c.UserFilter, "%s") != 1 {
+		errs = append(errs, fmt.Errorf("ldap.user_filter %q must contain %%s once", c.UserFilter))
+	}
+	for group, role := range c.Roles {
+		if roleRank[role] == 0 {
+			errs = append(errs, fmt.Errorf("ldap.roles[%q]: unknown role %q (want viewer, editor or admin)", group, role))
+		}
+	}
+	if c.DefaultRole != "" && roleRank[c.DefaultRole] == 0 {
+		errs = append(errs, fmt.Errorf("ldap.default_role: unknown role %q (want viewer, editor or admin)", c.DefaultRole))
+	}
+	return errs
+}
+
+// directory checks a password and returns the user's groups.
+type directory interface {
+	authenticate(name, password string) (groups []string, err error)
+}
+
+var errDirectoryRefused = errors.New("wrong name or password")
+
+type ldapDirectory struct {
+	cfg LDAPConfig
+	tls *tls.Config
+}
+
+func (d *ldapDirectory) authenticate(name, password string) ([]string, error) {
+	c, err := ldap.Dial(d.cfg.URL, d.tls, d.cfg.StartTLS, d.cfg.Timeout.Duration)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	dn := ""
+	if d.cfg.BindDN != "" {
+		if err := c.Bind(d.cfg.BindDN, d.cfg.BindPassword); err != nil {
+			return nil, fmt.Errorf("service account bind: %w", err)
+		}
+	} else {
+		dn = fmt.Sprintf(d.cfg.UserDN, ldap.EscapeDN(name))
+		if err := c.Bind(dn, password); err != nil {
+			if ldap.IsInvalidCredentials(err) {
+				return nil, errDirectoryRefused
+			}
+			return nil, err
+		}
+	}
+	if d.cfg.BaseDN == "" {
+		return nil, nil // nowhere to look groups up
+	}
+
+	filter := fmt.Sprintf(d.cfg.UserFilter, ldap.EscapeFilter(name))
+	entries, err := c.Search(d.cfg.BaseDN, filter, []string{d.cfg.GroupAttribute}, 2)
+	if err != nil && len(entries) < 2 {
+		return nil, err
+	}
+	if len(entries) != 1 {
+		if dn != "" {
+			return nil, nil // bound, but the entry is not visible: no groups
+		}
+		return nil, errDirectoryRefused // unknown or ambiguous name
+	}
+	if dn == "" {
+		if err := c.Bind(entries[0].DN, password); err != nil {
+			if ldap.IsInvalidCredentials(err) {
+				return nil, errDirectoryRefused
+			}
+			return nil, err
+		}
+	}
+	return entries[0].Get(d.cfg.GroupAttribute), nil
+}
+
+// ldapCacheTTL is how long a checked name and password are trusted:
+// scripts send basic auth with every request, and each check is a round
+// trip to the directory.
+const ldapCacheTTL = time.Minute
+
+type ldapBackend struct {
+	cfg LDAPConfig
+	dir directory
+
+	mu    sync.Mutex
+	cache map[[32]byte]ldapCached // by hash of name and password
+}
+
+type ldapCached struct {
+	id      identity
+	expires time.Time
+}
+
+// ldapAuth is the directory, nil when none is configured.
+var ldapAuth *ldapBackend
+
+func newLDAPBackend(cfg LDAPConfig) (*ldapBackend, error) {
+	if cfg.URL == "" {
+		return nil, nil
+	}
+	if strings.HasPrefix(cfg.URL, "ldap:") && !cfg.StartTLS {
+		fmt.Println("WARNING: ldap.url is plain ldap:// without start_tls; passwords cross the network in the clear")
+	}
+	if cfg.UserFilter == "" {
+		cfg.UserFilter = "(sAMAccountName=%s)"
+	}
+	if cfg.GroupAttribute == "" {
+		cfg.GroupAttribute = "memberOf"
+	}
+	if cfg.Timeout.Duration <= 0 {
+		cfg.Timeout.Duration = 5 * time.Second
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("ldap.ca_file: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("ldap.ca_file %s: no certificates found", cfg.CAFile)
+		}
+	}
+	return &ldapBackend{cfg: cfg, dir: &ldapDirectory{cfg: cfg, tls: tlsConfig}, cache: map[[32]byte]ldapCached{}}, nil
+}
+
+// login checks name and password against the directory.
+func (b *ldapBackend) login(name, password string) (identity, error) {
+	if name == "" || password == "" {
+		return identity{}, errDirectoryRefused
+	}
+	key := sha256.Sum256([]byte(name + "\x00" + password))
+	b.mu.Lock()
+	cached, ok := b.cache[key]
+	b.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.id, nil
+	}
+
+	groups, err := b.dir.authenticate(name, password)
+	if err != nil {
+		return identity{}, err
+	}
+	id := identity{Name: name, Role: b.role(name, groups)}
+	if id.Role == "" {
+		return identity{}, fmt.Errorf("%s has no access here", name)
+	}
+
+	b.mu.Lock()
+	now := time.Now()
+	for k, c := range b.cache {
+		if now.After(c.expires) {
+			delete(b.cache, k)
+		}
+	}
+	b.cache[key] = ldapCached{id: id, expires: now.Add(ldapCacheTTL)}
+	b.mu.Unlock()
+	return id, nil
+}
+
+func (b *ldapBackend) role(name string, groups []string) string {
+	if u, ok := users[name]; ok {
+		return u.role()
+	}
+	role := b.cfg.DefaultRole
+	for _, g := range groups {
+		for group, r := range b.cfg.Roles {
+			// DNs differ in case and spacing between servers and configs.
+			if strings.EqualFold(normalizeDN(g), normalizeDN(group)) && roleRank[r] > roleRank[role] {
+				role = r
+			}
+		}
+	}
+	return role
+}
+
+func normalizeDN(dn string) string {
+	parts := strings.Split(dn, ",")
+	for i, p := range parts {
+		parts[i] = strings.TrimSpace(p)
+	}
+	return strings.Join(parts, ",")
+}
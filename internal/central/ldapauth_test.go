@@ -0,0 +1,89 @@
+package central
+
+import (
+	"net/http"
+	"testing"
+)
+
+// stubDirectory knows passwords and groups by name.
+type stubDirectory struct {
+	passwords map[string]string
+	groups    map[string][]string
+	calls     int
+}
+
+func (d *stubDirectory) authenticate(name, password string) ([]string, error) {
+	d.calls++
+	if want, ok := d.passwords[name]; !ok || want != password {
+		return nil, errDirectoryRefused
+	}
+	return d.groups[name], nil
+}
+
+func TestLDAPLogin(t *testing.T) {
+	hash, _ := hashPassword("local")
+	c := newTestCluster(t, 1, func(cfg *Config) {
+		cfg.Users = []User{{Name: "admin", PasswordHash: hash}, {Name: "carol", Role: roleViewer}}
+		cfg.LDAP = LDAPConfig{
+			URL:    "ldaps://ad.example.com",
+			BindDN: "cn=svc,dc=example,dc=com", BindPassword: "x", BaseDN: "dc=example,dc=com",
+			Roles: map[string]string{
+				"CN=Staff,OU=Groups,DC=example,DC=com":     roleViewer,
+				"CN=Uploaders,OU=Groups,DC=example,DC=com": roleEditor,
+			},
+		}
+	})
+	dir := &stubDirectory{
+		passwords: map[string]string{"ann": "annpass", "bob": "bobpass", "carol": "carolpass", "dave": "davepass", "admin": "adpass"},
+		groups: map[string][]string{
+			"ann":   {"cn=staff, ou=groups, dc=example, dc=com", "cn=uploaders,ou=groups,dc=example,dc=com"},
+			"bob":   {"cn=staff,ou=groups,dc=example,dc=com"},
+			"carol": {"cn=uploaders,ou=groups,dc=example,dc=com"},
+		},
+	}
+	ldapAuth.dir = dir
+
+	for _, tc := range []struct {
+		name, password, role string
+	}{
+		{"ann", "annpass", roleEditor}, // the highest of her groups
+		{"bob", "bobpass", roleViewer},
+		{"carol", "carolpass", roleViewer}, // configured role wins over groups
+		{"dave", "davepass", ""},           // in no mapped group
+		{"ann", "wrong", ""},
+		{"admin", "adpass", ""}, // has a local password: never the directory
+		{"admin", "local", roleEditor},
+	} {
+		id, _, err := passwordLogin(tc.name, tc.password)
+		if tc.role == "" {
+			if err == nil {
+				t.Errorf("%s/%s signed in as %+v", tc.name, tc.password, id)
+			}
+		} else if err != nil || id.Role != tc.role {
+			t.Errorf("%s/%s: %+v %v, want %s", tc.name, tc.password, id, err, tc.role)
+		}
+	}
+
+	// Basic auth goes to the directory once a minute, not every request.
+	dir.calls = 0
+	ldapAuth.cache = map[[32]byte]ldapCached{}
+	for i := 0; i < 3; i++ {
+		req, _ := http.NewRequest("GET", c.central.URL+"/files", nil)
+		req.Header.Set("Accept", "application/json")
+		req.SetBasicAuth("bob", "bobpass")
+		if resp, err := testClient.Do(req); err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("basic auth: %v %v", resp, err)
+		}
+	}
+	if dir.calls != 1 {
+		t.Errorf("%d directory lookups for 3 requests", dir.calls)
+	}
+	if resp, _ := c.upload("a.txt", "hello", nearLondon); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("anonymous upload: %d", resp.StatusCode)
+	}
+
+	bad := LDAPConfig{URL: "http://ad", UserDN: "uid=%s,%s", Roles: map[string]string{"cn=x": "root"}}
+	if errs := bad.validate(); len(errs) != 3 {
+		t.Errorf("validate = %v", errs)
+	}
+}
@@ -0,0 +1,110 @@
+package central
+
+import (
+	"bytes"
+	"fmt"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// ---------------------------
+// Email
+// ---------------------------
+
+// Notifications are written from the mail_*.txt templates: a "Subject:"
+// line, a blank line, then the body. The email channel sends them through
+// the SMTP server in Config.SMTP; the in-UI and webhook channels carry the
+// same subject and body.
+
+// SMTPConfig is the server notifications are sent through.
+type SMTPConfig struct {
+	Addr     string `json:"addr"` // host:port; STARTTLS is used when offered
+	From     string `json:"from"`
+	Username string `json:"username"` // PLAIN auth, over TLS only
+	Password string `json:"password"`
+}
+
+func (c SMTPConfig) validate() []error {
+	if c.Addr == "" {
+		return nil
+	}
+	var errs []error
+	if !strings.Contains(c.Addr, ":") {
+		errs = append(errs, fmt.Errorf("smtp.addr %q must be host:port", c.Addr))
+	}
+	if !validEmail(c.From) {
+		errs = append(errs, fmt.Errorf("smtp.from %q must be an email address", c.From))
+	}
+	return errs
+}
+
+// validEmail reports whether s is a bare address, like bob@example.com.
+func validEmail(s string) bool {
+	a, err := mail.ParseAddress(s)
+	return err == nil && a.Name == "" && a.Address == s
+}
+
+// mailSender sends one email.
+type mailSender interface {
+	send(to string, msg []byte) error
+}
+
+type smtpSender struct {
+	cfg SMTPConfig
+}
+
+func (s smtpSender) send(to string, msg []byte) error {
+	var auth smtp.Auth
+	if s.cfg.Username != "" {
+		host, _, _ := strings.Cut(s.cfg.Addr, ":")
+		auth = smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, host)
+	}
+	return smtp.SendMail(s.cfg.Addr, auth, s.cfg.From, []string{to}, msg)
+}
+
+var (
+	mailer        mailSender // nil: no email is sent
+	mailFrom      string
+	mailTemplates *texttemplate.Template
+)
+
+// renderNotice renders template name with data into a subject and body.
+func renderNotice(name string, data any) (subject, body string, err error) {
+	var text bytes.Buffer
+	if err := mailTemplates.ExecuteTemplate(&text, name, data); err != nil {
+		return "", "", err
+	}
+	head, body, _ := strings.Cut(text.String(), "\n\n")
+	subject = strings.Map(func(r rune) rune {
+		if r < ' ' {
+			return ' ' // a file name's newline must not start a header
+		}
+		return r
+	}, strings.TrimSpace(strings.TrimPrefix(head, "Subject:")))
+	return subject, body, nil
+}
+
+// sendMail sends an email to the address, in the background.
+func sendMail(to, subject, body string) {
+	m := mailer
+	if m == nil || to == "" {
+		return
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", mailFrom)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	go func() {
+		if err := m.send(to, msg.Bytes()); err != nil {
+			fmt.Println("Cannot email", to+":", err)
+			return
+		}
+		fmt.Println("Emailed", to+":", subject)
+	}()
+}
@@ -0,0 +1,1249 @@
+package central
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/changes"
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/listen"
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/validate"
+)
+
+// ---------------------------
+// Template Loader
+// ---------------------------
+// Parsed during the startup self-test so a bad template directory is reported
+// instead of panicking at init.
+var templates *template.Template
+
+var templateFuncs = template.FuncMap{
+	"humanBytes": humanBytes,
+	"shortSum":   shortSum,
+	"brand":      currentBranding,
+	"unread":     func(user string) int { return notifyPrefs.unread(user) },
+}
+
+var uploadDir = "uploads"
+
+// ---------------------------
+// Storage Servers
+// ---------------------------
+// ID is the stable key used for replica tracking and templates; Label is the
+// human readable name shown in the UI.
+type StorageServer struct {
+	ID    string  `json:"id"`
+	Label string  `json:"label"`
+	URL   string  `json:"url"`
+	Lat   float64 `json:"lat"`
+	Lon   float64 `json:"lon"`
+
+	// Zone groups nodes that share a failure domain (e.g. a datacenter),
+	// for zone-aware placement.
+	Zone string `json:"zone,omitempty"`
+
+	// Capacity is reported by self-registering nodes (0 = unknown).
+	Capacity int64 `json:"capacity_bytes,omitempty"`
+
+	// Socket is a Unix socket the central API reaches a node on the same
+	// host through, in place of the URL's host and port. Clients are still
+	// sent to URL.
+	Socket string `json:"socket,omitempty"`
+}
+
+var defaultStorages = []StorageServer{
+	{ID: "sg", Label: "Singapore", URL: "http://68.183.231.211:9001", Lat: 1.3521, Lon: 103.8198},
+	{ID: "ny", Label: "New York", URL: "http://167.71.177.212:9002", Lat: 40.7128, Lon: -74.0060},
+	{ID: "ldn", Label: "London", URL: "http://159.65.48.116:9003", Lat: 51.5074, Lon: -0.1278},
+}
+
+// ---------------------------
+// Helpers
+// ---------------------------
+
+// background counts the goroutines goBackground starts: work a request
+// hands off without waiting for it, like dropping a deleted file from the
+// search index. Tests wait for it before swapping the package state that
+// work reads.
+var background sync.WaitGroup
+
+func goBackground(f func()) {
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		f()
+	}()
+}
+
+// forwardFileTo posts the file read from file to a storage node, to be
+// hashed with alg, adding the
+// file bytes sent to sent (if non-nil) as the request body is consumed. A
+// negative size means the length is not known yet (the file is still
+// streaming in) and the request is sent chunked; expected, if not negative,
+// is then announced to the node for its free space check instead.
+func forwardFileTo(ctx context.Context, url, filename, alg string, file io.Reader, size, expected int64, sent *atomic.Int64) (int, string, error) {
+	// Build the multipart envelope around the file instead of copying the
+	// file into it, so only the payload is counted as replicated bytes.
+	envelope := &bytes.Buffer{}
+	writer := multipart.NewWriter(envelope)
+	if _, err := writer.CreateFormFile("file", filename); err != nil {
+		return 0, "", err
+	}
+	headLen := envelope.Len()
+	writer.Close()
+	head, tail := envelope.Bytes()[:headLen], envelope.Bytes()[headLen:]
+
+	if sent != nil {
+		file = &countingReader{r: file, n: sent}
+	}
+	body := io.MultiReader(bytes.NewReader(head), file, bytes.NewReader(tail))
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url+"/upload", body)
+	if err != nil {
+		return 0, "", err
+	}
+	req.ContentLength = -1
+	if size >= 0 {
+		req.ContentLength = int64(len(head)) + size + int64(len(tail))
+		expected = size
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	if registrationToken != "" {
+		req.Header.Set("Authorization", "Bearer "+registrationToken)
+	}
+	if alg != "" {
+		req.Header.Set("X-Checksum-Algorithm", alg)
+	}
+	if expected >= 0 {
+		req.Header.Set("X-File-Size", strconv.FormatInt(expected, 10))
+	}
+
+	resp, err := nodeClient.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(b), nil
+}
+
+func haversineKm(lat1, lon1, lat2, lon2 float64) float64 {
+	const R = 6371.0 // Earth radius km
+	dLat := (lat2 - lat1) * math.Pi / 180.0
+	dLon := (lon2 - lon1) * math.Pi / 180.0
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*math.Pi/180.0)*math.Cos(lat2*math.Pi/180.0)*
+			math.Sin(dLon/2)*math.Sin(dLon/2)
+	c := 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+	return R * c
+}
+
+func getClientIP(r *http.Request) string {
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		parts := strings.Split(xff, ",")
+		return strings.TrimSpace(parts[0])
+	}
+	if xr := r.Header.Get("X-Real-Ip"); xr != "" {
+		return strings.TrimSpace(xr)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Approximate location for testing
+func approximateLocation(ip string) (float64, float64) {
+	if strings.HasPrefix(ip, "127.") {
+		return 1.3521, 103.8198 // SG
+	}
+	if strings.HasPrefix(ip, "192.168.1.") {
+		return 40.7128, -74.0060 // NY
+	}
+	return 51.5074, -0.1278 // London
+}
+
+// clientLocation resolves where the client is. Explicit lat/lon query
+// parameters win, then the X-Geolocation header ("lat,lon") that the web UI
+// fills from the browser's Geolocation API, then the configured GeoResolver.
+func clientLocation(r *http.Request) (float64, float64, error) {
+	q := r.URL.Query()
+	if q.Get("lat") != "" || q.Get("lon") != "" {
+		return parseLatLon(q.Get("lat"), q.Get("lon"))
+	}
+	if h := r.Header.Get("X-Geolocation"); h != "" {
+		parts := strings.Split(h, ",")
+		if len(parts) != 2 {
+			return 0, 0, fmt.Errorf("X-Geolocation must be \"lat,lon\"")
+		}
+		return parseLatLon(parts[0], parts[1])
+	}
+	ip := getClientIP(r)
+	lat, lon, err := geo.Locate(r.Context(), ip)
+	if err != nil {
+		// Never fail a request over geolocation; the test heuristic always
+		// has an answer.
+		fmt.Println("Geolocation failed for", ip+":", err)
+		lat, lon = approximateLocation(ip)
+	}
+	return lat, lon, nil
+}
+
+func parseLatLon(latStr, lonStr string) (float64, float64, error) {
+	lat, err := strconv.ParseFloat(strings.TrimSpace(latStr), 64)
+	if err != nil || lat < -90 || lat > 90 {
+		return 0, 0, fmt.Errorf("invalid latitude %q", latStr)
+	}
+	lon, err := strconv.ParseFloat(strings.TrimSpace(lonStr), 64)
+	if err != nil || lon < -180 || lon > 180 {
+		return 0, 0, fmt.Errorf("invalid longitude %q", lonStr)
+	}
+	return lat, lon, nil
+}
+
+func getNearestStorage(c clientRef) StorageServer {
+	model := currentPolicy().distance
+	var nearest StorageServer
+	minDist := math.MaxFloat64
+
+	for _, s := range topo.nodes() {
+		d := model.Distance(c, s)
+		if d < minDist {
+			minDist = d
+			nearest = s
+		}
+	}
+	return nearest
+}
+
+// ---------------------------
+// Handlers
+// ---------------------------
+func nearestViewHandler(w http.ResponseWriter, r *http.Request) {
+	filename := r.URL.Query().Get("filename")
+	if filename == "" {
+		apierr.Send(w, "filename required", http.StatusBadRequest)
+		return
+	}
+	if refuseQuarantined(w, filename) {
+		return
+	}
+	if refusePrivate(w, r, filename) {
+		return
+	}
+
+	client, err := locateClient(r)
+	if err != nil {
+		apierr.Send(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	type DistanceInfo struct {
+		ID         string
+		Label      string
+		URL        string
+		Lat, Lon   float64
+		Distance   float64
+		Healthy    bool
+		HasReplica bool
+		IsNearest  bool
+		Selected   bool
+	}
+
+	var distances []DistanceInfo
+	for _, n := range rankStorages(client) {
+		distances = append(distances, DistanceInfo{
+			ID:       n.ID,
+			Label:    n.Label,
+			URL:      n.URL,
+			Lat:      n.Lat,
+			Lon:      n.Lon,
+			Distance: n.Distance,
+		})
+	}
+
+	// Walk nodes from nearest to farthest and serve from the first healthy
+	// one that actually holds the file.
+	var selected *DistanceInfo
+	for i := range distances {
+		d := &distances[i]
+		d.IsNearest = i == 0
+		d.Healthy = health.isHealthy(d.ID)
+		if !d.Healthy {
+			continue
+		}
+		d.HasReplica = hasReplica(r.Context(), StorageServer{ID: d.ID, URL: d.URL}, filename)
+		if d.HasReplica && selected == nil {
+			d.Selected = true
+			selected = d
+		}
+	}
+	if selected == nil {
+		apierr.Send(w, "No healthy storage server holds "+filename, http.StatusServiceUnavailable)
+		return
+	}
+
+	// Draw the client, every node and how far each one is.
+	m := newMapView()
+	you := m.point(client.Lat, client.Lon)
+	you.Label, you.Title, you.Class = "you", "Your location", "client"
+	for _, d := range distances {
+		p := m.point(d.Lat, d.Lon)
+		p.Label, p.Class = d.ID, "node"
+		link := "link"
+		switch {
+		case d.Selected:
+			p.Title = d.Label + ": serving this file"
+			p.Class += " selected"
+			link += " selected"
+		case !d.Healthy:
+			p.Title = d.Label + ": down"
+			p.Class += " down"
+		case !d.HasReplica:
+			p.Title = d.Label + ": missing this file"
+			p.Class += " missing"
+		default:
+			p.Title = d.Label + ": has a replica"
+		}
+		m.line(you, p, fmt.Sprintf("%.0f %s", d.Distance, currentPolicy().distance.Unit()), link)
+		m.Points = append(m.Points, p)
+	}
+	m.Points = append(m.Points, you)
+
+	data := struct {
+		Filename    string
+		Previewable bool
+		PreviewURL  string
+		Selected    DistanceInfo
+		Nearest     DistanceInfo
+		Fallback    bool
+		Map         mapView
+	}{
+		Filename:    filename,
+		Previewable: previewable(filename),
+		PreviewURL:  nodeFileURL(StorageServer{ID: selected.ID, URL: selected.URL}, filename),
+		Selected:    *selected,
+		Nearest:     distances[0],
+		Fallback:    !distances[0].Selected,
+		Map:         m,
+	}
+
+	if err := templates.ExecuteTemplate(w, "nearest.html", data); err != nil {
+		apierr.Send(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func uploadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apierr.Send(w, "Use POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	client, err := locateClient(r)
+	if err != nil {
+		apierr.Send(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	jobID := r.URL.Query().Get("upload_id")
+	if jobID == "" {
+		jobID = r.Header.Get("X-Upload-ID")
+	}
+	job, err := uploadJobs.create(jobID, r.ContentLength)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, errJobExists) {
+			status = http.StatusConflict
+		}
+		apierr.Send(w, err.Error(), status)
+		return
+	}
+	w.Header().Set("X-Upload-ID", job.ID)
+	body := &jobCounter{ReadCloser: r.Body}
+	body.job.Store(job)
+	r.Body = body
+
+	fail := func(msg string, status int) {
+		job.finish(errors.New(msg))
+		apierr.Send(w, msg, status)
+	}
+
+	// Files are streamed, never parsed into memory or a temp file: bucket
+	// and folder come from the query or form fields ahead of the file
+	// parts. Each
+	// file gets its own job; with a client-chosen upload id, the second
+	// file's is "<id>-2", and so on.
+	mr, err := r.MultipartReader()
+	if err != nil {
+		fail("Parse error: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	bucket := r.URL.Query().Get("bucket")
+	folder := r.URL.Query().Get("folder")
+	var results []uploadOutcome
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fail("Parse error: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if part.FormName() == "bucket" {
+			b, _ := io.ReadAll(io.LimitReader(part, 1024))
+			bucket = string(b)
+		}
+		if part.FormName() == "folder" {
+			b, _ := io.ReadAll(io.LimitReader(part, 1024))
+			folder = string(b)
+		}
+		if part.FormName() != "file" {
+			continue
+		}
+		if len(results) > 0 {
+			id := ""
+			if jobID != "" {
+				id = fmt.Sprintf("%s-%d", jobID, len(results)+1)
+			}
+			if job, err = uploadJobs.create(id, r.ContentLength-body.n); err != nil {
+				apierr.Send(w, err.Error(), http.StatusConflict)
+				return
+			}
+			body.job.Store(job)
+		}
+		status, err := storeUpload(w, r, job, part, bucket, folder, client)
+		part.Close()
+		if status == http.StatusBadRequest {
+			// The request itself is wrong, or its body broke off: nothing
+			// after this file can be trusted.
+			apierr.Send(w, err.Error(), status)
+			return
+		}
+		results = append(results, uploadOutcome{job, status, err})
+	}
+	if len(results) == 0 {
+		fail("Missing file", http.StatusBadRequest)
+		return
+	}
+
+	wantJSON := strings.Contains(r.Header.Get("Accept"), "application/json")
+	if len(results) == 1 {
+		if err := results[0].err; err != nil {
+			apierr.Send(w, err.Error(), results[0].status)
+			return
+		}
+		if wantJSON {
+			b, _ := job.snapshot()
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(b)
+			return
+		}
+		http.Redirect(w, r, "/files", http.StatusSeeOther)
+		return
+	}
+
+	// Several files: each one's job says how it went.
+	if wantJSON {
+		out := make([]json.RawMessage, len(results))
+		for i, res := range results {
+			out[i], _ = res.job.snapshot()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(out)
+		return
+	}
+	var failed []string
+	status := 0
+	for _, res := range results {
+		if res.err != nil {
+			failed = append(failed, res.job.Filename+": "+res.err.Error())
+			if status == 0 {
+				status = res.status
+			}
+		}
+	}
+	if failed != nil {
+		apierr.Send(w, strings.Join(failed, "\n"), status)
+		return
+	}
+	http.Redirect(w, r, "/files", http.StatusSeeOther)
+}
+
+// uploadOutcome is how one file of an upload request went: status and err
+// are what the request would answer if it held only that file.
+type uploadOutcome struct {
+	job    *uploadJob
+	status int
+	err    error
+}
+
+// storeUpload receives one file part and replicates it, tracking both in
+// job. A 400 status means the request is at fault and should be abandoned.
+func storeUpload(w http.ResponseWriter, r *http.Request, job *uploadJob, part *multipart.Part, bucket, folder string, client clientRef) (int, error) {
+	fail := func(msg string, status int) (int, error) {
+		err := errors.New(msg)
+		job.finish(err)
+		return status, err
+	}
+
+	trace := traceFrom(r.Context())
+	name := filepath.Base(part.FileName())
+	filename, nameErr := storedName(r, name, folder)
+	var received int64
+	defer func() { accessNoteFrom(r.Context()).file(filename, received) }()
+	if err := validate.Name(name); err != nil {
+		// The one parameter requestRules cannot see: it is in the body.
+		return fail("file name: "+err.Error(), http.StatusBadRequest)
+	}
+	if nameErr != nil {
+		return fail(nameErr.Error(), http.StatusForbidden)
+	}
+	if err := errLocked(filename); err != nil {
+		return fail(err.Error(), http.StatusLocked)
+	}
+	if f, ok := teams.folder(folder); ok && bucket == "" {
+		bucket = f.Bucket
+	}
+	replicator, ok := replicatorFor(bucket)
+	if !ok {
+		return fail("Unknown bucket "+bucket, http.StatusBadRequest)
+	}
+	var concern writeConcern
+	if v := r.URL.Query().Get("w"); v != "" {
+		if !featureEnabled(featureClientWriteConcern) {
+			return fail("Choosing the write concern is disabled (feature "+featureClientWriteConcern+")", http.StatusBadRequest)
+		}
+		var err error
+		if concern, err = parseWriteConcern(v); err != nil {
+			return fail(err.Error(), http.StatusBadRequest)
+		}
+		replicator = concern
+		w.Header().Set("X-Write-Concern", string(concern))
+	}
+	striped := r.URL.Query().Get("stripe") == "1"
+	if striped && concern != "" {
+		return fail("Striped files are not replicated: w= does not apply", http.StatusBadRequest)
+	}
+	if concern == "" && stripeAbove > 0 && job.BytesTotal >= stripeAbove {
+		striped = true
+	}
+	if striped {
+		replicator = stripeReplicator{}
+	}
+	_, statErr := os.Stat(filepath.Join(uploadDir, filename))
+	job.mu.Lock()
+	job.Filename = filename
+	job.Bucket = bucket
+	job.WriteConcern = string(concern)
+	job.Striped = striped
+	job.trace = trace
+	job.replaces = statErr == nil
+	job.user = signedInUser(r)
+	job.mu.Unlock()
+
+	// Replicate while the file arrives: the payload tees it to disk here and
+	// to every target, and the upload deadline starts once it is all in.
+	targets := replicaTargets(filename, client)
+	if striped {
+		targets = spareNodes(nil, client) // every healthy node
+	}
+	// Until the new file is in, downloads read the old central copy rather
+	// than its parts; those the new file does not reuse are deleted after.
+	oldParts, _ := manifests.forget(filename)
+	var stream []StorageServer
+	if streams(replicator) {
+		stream = targets
+	}
+	p := newPayload(filepath.Join(uploadDir, filename), job.BytesTotal, stream)
+	p.alg = negotiatedChecksum()
+	p.spares = spareNodes(targets, client)
+	job.setStatus(uploadReplicating)
+	ctx, cancel := p.afterReceive(r.Context(), uploadTimeout)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		err := replicator.Replicate(ctx, job, filename, p, targets)
+		p.releaseUntaken()
+		done <- err
+	}()
+
+	rerr := p.receive(part)
+	received = p.size
+	if rerr != nil {
+		trace.phase("receive")
+		<-done
+		if oldParts.File != "" {
+			manifests.set(oldParts)
+		}
+		return fail("Read error: "+rerr.Error(), http.StatusBadRequest)
+	}
+	trace.phase("receive")
+	recordUpload(filename, p.size, p.sum)
+	err := <-done
+	trace.phase("replicate")
+	cur, _ := manifests.get(filename)
+	if stale := staleParts(oldParts, cur); len(stale) > 0 {
+		deleteParts(r.Context(), filename, stale)
+	}
+	if errors.Is(err, errPartialReplication) {
+		// Stored here and on some nodes: acknowledge, and let the job
+		// (status "partial") say which replicas are missing.
+		fmt.Println("Upload", filename, "only partially replicated:", job.errorText())
+		err = nil
+	}
+	if err != nil {
+		status := http.StatusBadGateway
+		switch {
+		case errors.Is(err, errReplicationBusy):
+			status = http.StatusServiceUnavailable
+		case errors.Is(err, context.DeadlineExceeded):
+			status = http.StatusGatewayTimeout
+		}
+		return status, err
+	}
+	lockForBucket(r.Context(), filename, bucket)
+	retentionFiles.uploaded(filename, bucket)
+	queueTranscode(filename)
+	job.setProcessing(queuePostUpload(filename))
+	return http.StatusOK, nil
+}
+
+func deleteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apierr.Send(w, "Use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	filename := r.FormValue("filename")
+	if filename == "" {
+		apierr.Send(w, "filename required", http.StatusBadRequest)
+		return
+	}
+	if !canDelete(signedIn(r), filename) {
+		apierr.Send(w, "You may only delete files in your own home", http.StatusForbidden)
+		return
+	}
+	if refuseLocked(w, filename) {
+		return
+	}
+
+	if trashRetention > 0 {
+		if err := trashFile(r.Context(), filename, signedInUser(r)); err != nil {
+			apierr.Send(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	} else {
+		deleteEverywhere(r.Context(), filename)
+	}
+	http.Redirect(w, r, "/files", http.StatusSeeOther)
+}
+
+// deleteEverywhere removes filename's central copy and its replicas on
+// every node for good, logging the nodes it could not delete from.
+func deleteEverywhere(ctx context.Context, filename string) map[string]string {
+	if err := os.Remove(filepath.Join(uploadDir, filename)); err == nil {
+		recordDelete(filename)
+	}
+	return deleteReplicas(ctx, filename)
+}
+
+// deleteReplicas removes filename from every node, its parts too if it is
+// a composite file and its HLS files if it is a video, and returns the
+// errors by node ID.
+func deleteReplicas(ctx context.Context, filename string) map[string]string {
+	var mu sync.Mutex
+	var errs map[string]string
+	if m, ok := manifests.get(filename); ok {
+		if errs = deleteParts(ctx, filename, m.Parts); errs == nil {
+			manifests.forget(filename)
+		}
+	}
+	if herrs := deleteHLS(ctx, filename); len(herrs) > 0 {
+		if errs == nil {
+			errs = map[string]string{}
+		}
+		for id, e := range herrs {
+			errs[id] = e
+		}
+	}
+	var wg sync.WaitGroup
+	for _, s := range topo.nodes() {
+		wg.Add(1)
+		go func(s StorageServer) {
+			defer wg.Done()
+			start := time.Now()
+			err := callNode(ctx, s, opDelete, func(ctx context.Context) error {
+				return deleteFromNode(ctx, s, filename)
+			})
+			if err != nil {
+				fmt.Println("Delete error from", s.URL, ":", err)
+				mu.Lock()
+				if errs == nil {
+					errs = map[string]string{}
+				}
+				errs[s.ID] = err.Error()
+				mu.Unlock()
+			}
+			traceFrom(ctx).node(s.ID, time.Since(start), err)
+		}(s)
+	}
+	wg.Wait()
+	return errs
+}
+
+// deleteFromNode removes filename from one node. A replica that is already
+// gone (or never was there) counts as deleted, which also makes a retry
+// after a lost response safe.
+func deleteFromNode(ctx context.Context, s StorageServer, filename string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL+"/delete?filename="+url.QueryEscape(filename), nil)
+	if err != nil {
+		return err
+	}
+	if registrationToken != "" {
+		req.Header.Set("Authorization", "Bearer "+registrationToken)
+	}
+	resp, err := nodeClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return &statusError{Status: resp.StatusCode}
+	}
+	return nil
+}
+
+// ---------------------------
+// File Listing
+// ---------------------------
+// fileChanges numbers the changes to the central copies for ?since=
+// listings.
+var fileChanges = changes.New(100000)
+
+// listFilesHandler lists the central copies and where their replicas are.
+// With ?since=<seq> it lists, as JSON, only the files changed after seq,
+// deletions included; X-Change-Seq carries the seq to ask from next time.
+// ?read= limits the replicas looked at: none for primary, only those in the
+// region for region=X.
+func listFilesHandler(w http.ResponseWriter, r *http.Request) {
+	pref, err := parseReadPreference(r)
+	if err != nil {
+		apierr.Send(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("X-Change-Seq", strconv.FormatInt(fileChanges.Seq(), 10))
+	// seqOf holds the changed files when listing ?since=, and is nil otherwise.
+	var changed []changes.Change
+	var seqOf map[string]int64
+	if v := r.URL.Query().Get("since"); v != "" {
+		since, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			apierr.Send(w, "Invalid since", http.StatusBadRequest)
+			return
+		}
+		if changed, err = fileChanges.Since(since); err != nil {
+			apierr.Send(w, err.Error(), http.StatusGone)
+			return
+		}
+		seqOf = map[string]int64{}
+		for _, c := range changed {
+			seqOf[c.Name] = c.Seq
+		}
+	}
+
+	files, _ := ioutil.ReadDir(uploadDir)
+
+	var nodes []StorageServer
+	for _, s := range topo.nodes() {
+		if pref.mode == readPrimary || (pref.mode == readRegion && !inRegion(s, pref.region)) {
+			continue
+		}
+		nodes = append(nodes, s)
+	}
+	out := []FileListing{}
+	allStorage := map[string]map[string]RemoteFile{}
+
+	var unreachable []string
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, s := range nodes {
+		wg.Add(1)
+		go func(s StorageServer) {
+			defer wg.Done()
+			list, err := fetchNodeFiles(r.Context(), s)
+			byName := map[string]RemoteFile{}
+			for _, rf := range list {
+				byName[rf.Name] = rf
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				fmt.Println("List error from", s.URL, ":", err)
+				unreachable = append(unreachable, s.ID)
+			}
+			allStorage[s.ID] = byName
+		}(s)
+	}
+	wg.Wait()
+	sort.Strings(unreachable)
+
+	for _, f := range files {
+		// Dot files are uploads still streaming in (see payload.receive).
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		if seqOf != nil && seqOf[f.Name()] == 0 {
+			continue
+		}
+		private := privateFiles.has(f.Name())
+		if !canSee(signedIn(r), f.Name()) || (private && !canReadPrivate(signedIn(r), f.Name())) {
+			continue
+		}
+		fl := FileListing{
+			Name:       f.Name(),
+			Path:       homePath(f.Name()),
+			Size:       f.Size(),
+			ModTime:    f.ModTime().UTC(),
+			Algorithm:  fileAlgorithms.of(f.Name()),
+			Replica:    map[string]bool{},
+			Checksums:  map[string]string{},
+			ReplicaURL: map[string]string{},
+		}
+		for _, s := range nodes {
+			rf, ok := allStorage[s.ID][f.Name()]
+			fl.Replica[s.ID] = ok
+			if !ok {
+				continue
+			}
+			fl.Checksums[s.ID] = rf.Checksum
+			fl.ReplicaURL[s.ID] = nodeFileURL(s, rf.Name)
+			if fl.MimeType == "" {
+				fl.MimeType = rf.MimeType
+			}
+		}
+		fl.Checksum, fl.Consistent = majorityChecksum(fl.Checksums)
+		if m, ok := manifests.get(f.Name()); ok {
+			fl.Composite, fl.Parts = m.Kind, len(m.Parts)
+			fl.Checksum, fl.Consistent = m.Checksum, partsIntact(m, allStorage)
+		}
+		fl.Seq = seqOf[f.Name()]
+		fl.Quarantined = quarantined.covers(f.Name())
+		fl.Private = private
+		out = append(out, fl)
+	}
+	for _, c := range changed {
+		if c.Deleted && canSee(signedIn(r), c.Name) {
+			out = append(out, FileListing{Name: c.Name, Seq: c.Seq, Deleted: true})
+		}
+	}
+	// The listing is still served when nodes time out; say which ones are
+	// missing from it.
+	if len(unreachable) > 0 {
+		w.Header().Set("X-Unreachable-Nodes", strings.Join(unreachable, ","))
+	}
+
+	if seqOf != nil || strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(out)
+		return
+	}
+
+	client, err := locateClient(r)
+	if err != nil {
+		apierr.Send(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	nearest := getNearestStorage(client)
+
+	data := struct {
+		Files         []FileListing
+		Storages      []StorageServer
+		NearestServer StorageServer
+		CSRFToken     string
+		User          string
+	}{
+		Files:         out,
+		Storages:      nodes,
+		NearestServer: nearest,
+		CSRFToken:     csrfToken(w, r),
+		User:          signedInUser(r),
+	}
+
+	templates.ExecuteTemplate(w, "list.html", data)
+}
+
+// ---------------------------
+// Home Page
+// ---------------------------
+func homePage(w http.ResponseWriter, r *http.Request) {
+	data := struct{ CSRFToken, User string }{csrfToken(w, r), signedInUser(r)}
+	templates.ExecuteTemplate(w, "upload.html", data)
+}
+
+// ---------------------------
+// Main
+// ---------------------------
+// Main runs the central API: dsfs central [-config file.json] [-port 8000]
+// [-listen addr,...]. Flags override CONFIG_FILE, PORT and LISTEN.
+// dsfs central -hash-password hashes a user's password for the config.
+func Main(args []string) {
+	flags := flag.NewFlagSet("central", flag.ExitOnError)
+	configFile := flags.String("config", "", "JSON config file (default $CONFIG_FILE)")
+	portFlag := flags.String("port", "", "port to listen on (default $PORT, then 8000)")
+	listenFlag := flags.String("listen", "", "addresses to listen on, comma-separated, e.g. 127.0.0.1:8000,[::1]:8000 (default $LISTEN, then :port)")
+	hashFlag := flags.Bool("hash-password", false, "read a password from stdin, print its hash for users[].password_hash and exit")
+	flags.Parse(args)
+	if *hashFlag {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		password := strings.TrimRight(line, "\r\n")
+		if password == "" {
+			log.Fatalf("No password on stdin: %v", err)
+		}
+		hash, err := hashPassword(password)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(hash)
+		return
+	}
+	if *configFile != "" {
+		os.Setenv("CONFIG_FILE", *configFile)
+	}
+	if *portFlag != "" {
+		os.Setenv("PORT", *portFlag)
+	}
+	if *listenFlag != "" {
+		os.Setenv("LISTEN", *listenFlag)
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatalf("Config error: %v", err)
+	}
+
+	report := runSelfTest(cfg)
+	fmt.Print(report)
+	if report.Failed() {
+		log.Fatal("Startup self-test failed, refusing to start")
+	}
+
+	if err := apply(cfg); err != nil {
+		log.Fatalf("Config error: %v", err)
+	}
+
+	warnMixedVersions()
+	if cfg.DebugAddr != "" {
+		go serveDebug(cfg.DebugAddr)
+	}
+	go reloadOnSIGHUP()
+	if _, ok := searchIndex.(*memoryIndex); ok {
+		go indexExisting()
+	}
+	pipeline.start()
+	go monitorNodes(cfg.HealthCheckInterval.Duration)
+	go saveCountersLoop(countersSaveInterval)
+	go replicationAlertLoop(time.Minute)
+	if cfg.DiscoverySRV != "" {
+		go discoveryLoop(cfg.DiscoverySRV, cfg.DiscoveryInterval.Duration)
+	}
+	go scheduler.loop()
+	if cfg.ConfigStore != "" {
+		store, _ := newConfigStore(cfg.ConfigStore, cfg.ConfigStoreURL, cfg.ConfigStoreToken)
+		go watchClusterConfig(store, cfg.ConfigStoreKey)
+	}
+
+	// Open every listener before serving any, so a bad or busy address
+	// stops the startup.
+	lns, err := listen.Open(cfg.listenAddrs())
+	if err != nil {
+		log.Fatalf("Cannot listen: %v", err)
+	}
+	var adminLns []net.Listener
+	if len(cfg.AdminListen) > 0 {
+		if adminLns, err = listen.Open(cfg.AdminListen); err != nil {
+			log.Fatalf("Cannot listen for the admin API: %v", err)
+		}
+		fmt.Println("Central admin API listening on", listen.Addrs(adminLns))
+		go func() {
+			log.Fatal(listen.Serve(&http.Server{Handler: adminRoutes()}, adminLns))
+		}()
+	}
+	fmt.Println("Central API listening on", listen.Addrs(lns))
+	log.Fatal(listen.Serve(&http.Server{Handler: routes()}, lns))
+}
+
+// apply installs a config that passed the self-test into the state the
+// handlers read.
+func apply(cfg Config) error {
+	uploadDir = cfg.UploadDir
+	staticDir = cfg.StaticDir
+	branding = cfg.Branding
+	imageCacheDir = filepath.Join(cfg.DataDir, "images")
+	imageCacheBytes = cfg.ImageCacheBytes
+	quarantineReports = cfg.QuarantineReports
+	sink, err := newAccessSink(cfg.AccessLog, cfg.AccessLogMaxBytes, cfg.AccessLogBackups)
+	if err != nil {
+		return fmt.Errorf("access log: %w", err)
+	}
+	if accessLog != nil {
+		accessLog.close()
+	}
+	accessLog = sink
+	m, err := newMaintenancePolicy(cfg)
+	if err != nil {
+		return err
+	}
+	maintenance = m
+	store, err := newSessionStore(cfg)
+	if err != nil {
+		return fmt.Errorf("sessions: %w", err)
+	}
+	sessions = store
+	sessionTTL = cfg.SessionTTL.Duration
+	shortLinkTTL = cfg.ShortLinkTTL.Duration
+	publicURL = strings.TrimSuffix(cfg.PublicURL, "/")
+	mailer, mailFrom = nil, cfg.SMTP.From
+	if cfg.SMTP.Addr != "" {
+		mailer = smtpSender{cfg.SMTP}
+	}
+	replicationAlertAfter = cfg.ReplicationAlertAfter.Duration
+	quotaWarnPercent = cfg.QuotaWarnPercent
+	trashDir = filepath.Join(cfg.DataDir, "trash")
+	trashRetention = cfg.TrashRetention.Duration
+	bucketRetention = map[string]time.Duration{}
+	for name, b := range cfg.Buckets {
+		bucketRetention[name] = b.Retention.Duration
+	}
+	secureCookies = cfg.SecureCookies
+	users = map[string]User{}
+	for _, u := range cfg.Users {
+		users[u.Name] = u
+	}
+	oidc = newOIDCProvider(cfg.OIDC)
+	directory, err := newLDAPBackend(cfg.LDAP)
+	if err != nil {
+		return err
+	}
+	ldapAuth = directory
+	prefetchCfg = prefetchSettings{
+		hotDownloads: cfg.PrefetchHotDownloads,
+		nodeBudget:   cfg.PrefetchNodeBudget,
+		coldAfter:    cfg.ColdAfter.Duration,
+		coldReplicas: cfg.ColdReplicas,
+	}
+	transcoder = newTranscoder(cfg)
+	searchIndex, _ = newSearchIndex(cfg.SearchIndex)
+	hlsSegmentLength = cfg.HLSSegmentLength.Duration
+	stripeAbove, stripeSize, stripeParallel = cfg.StripeAbove, cfg.StripeSize, cfg.StripeParallel
+	postUploadTasks, pipelineWorkers, cloudCopyURL = cfg.PostUploadTasks, cfg.PipelineWorkers, cfg.CloudCopyURL
+	scheduler.configure(schedulesFor(cfg), time.Now())
+	idempotencyWindow = cfg.IdempotencyWindow.Duration
+	running = cfg
+	topo.setStatic(cfg.Storages)
+	replicationFactor.Store(int32(cfg.ReplicationFactor))
+	p, err := newPolicy(cfg)
+	if err != nil {
+		return err
+	}
+	policy.Store(p)
+	if err := setupReplicators(cfg); err != nil {
+		return err
+	}
+	registrationToken = cfg.RegistrationToken
+	adminToken = cfg.AdminToken
+	separateAdmin = len(cfg.AdminListen) > 0
+	slowRequestThreshold = cfg.SlowRequestThreshold.Duration
+	largePayloadBytes = cfg.LargePayloadBytes
+	nodeTimeout = cfg.NodeTimeout.Duration
+	breakers = newBreakerSet(cfg.BreakerThreshold, cfg.BreakerCooldown.Duration)
+	retries = retryPolicy{
+		Attempts:  cfg.RetryAttempts,
+		BaseDelay: cfg.RetryBaseDelay.Duration,
+		MaxDelay:  cfg.RetryMaxDelay.Duration,
+	}
+	uploadTimeout = cfg.UploadTimeout.Duration
+	if cfg.SigningKey != "" {
+		signingKey = []byte(cfg.SigningKey)
+		signedURLTTL = cfg.SignedURLTTL.Duration
+	}
+	return nil
+}
+
+// requestRules are the checks of query and form parameters every route
+// shares (see validate.Mux); route wildcards must be file names. What is
+// left for the handlers is what the values mean: whether a bucket exists,
+// a file may be seen, ...
+var requestRules = validate.Rules{
+	"filename":  validate.Name,
+	"name":      validate.Name,
+	"file":      validate.Name,
+	"files":     validate.Names,
+	"bucket":    validate.Match(validBucket, "a bucket name of lowercase letters, digits, . and -"),
+	"folder":    validate.Match(validBucket, "a folder name of lowercase letters, digits, . and -"),
+	"upload_id": validate.Match(validJobID, "1-64 characters of [A-Za-z0-9_-]"),
+	"lat":       validate.Float(-90, 90),
+	"lon":       validate.Float(-180, 180),
+	"limit":     validate.Int(1, 10000),
+	"since":     validate.Int(0, math.MaxInt64),
+	"wait":      validate.Duration(time.Minute),
+	"stripe":    validate.Bool,
+	"repair":    validate.Bool,
+	"dry_run":   validate.Bool,
+}
+
+// routes returns the central API's handlers. The admin endpoints are among
+// them unless admin listeners are configured; those serve adminRoutes.
+func routes() http.Handler {
+	mux := validate.NewMux(requestRules)
+	os.MkdirAll(uploadDir, 0755)
+	mux.Handle("/files/", http.StripPrefix("/files/", guardQuarantined(guardPrivate(http.FileServer(http.Dir(uploadDir))))))
+
+	mux.Handle("GET /static/", staticHandler())
+
+	mux.Handle("/", requireLogin(http.HandlerFunc(homePage)))
+	mux.Handle("/login", requireCSRF(http.HandlerFunc(loginHandler)))
+	mux.Handle("POST /logout", requireCSRF(http.HandlerFunc(logoutHandler)))
+	mux.HandleFunc("GET /login/oidc", oidcLoginHandler)
+	mux.HandleFunc("GET /login/oidc/callback", oidcCallbackHandler)
+	mux.HandleFunc("GET /healthz", healthzHandler)
+	mux.HandleFunc("GET /readyz", readyzHandler)
+	mux.HandleFunc("GET /version", versionHandler)
+	mux.HandleFunc("GET /api/v1/openapi.json", openAPIHandler)
+	mux.Handle("/upload", requireLogin(requireCSRF(idempotent(http.HandlerFunc(uploadHandler)))))
+	mux.Handle("/delete", requireLogin(requireCSRF(idempotent(http.HandlerFunc(deleteHandler)))))
+	mux.Handle("/files", requireLogin(http.HandlerFunc(listFilesHandler)))
+	mux.Handle("/nearest-view", requireLogin(http.HandlerFunc(nearestViewHandler)))
+	mux.HandleFunc("GET /get/{filename}", getHandler)
+	mux.HandleFunc("GET /download/{filename}", downloadHandler)
+	mux.HandleFunc("GET /u/{user}/{file}", homeFileHandler)
+	mux.HandleFunc("GET /t/{folder}/{file}", folderFileHandler)
+	mux.HandleFunc("GET /s/{code}", shortLinkHandler)
+	mux.HandleFunc("GET /qr/{filename}", qrHandler)
+	mux.Handle("/api/v1/links", requireLogin(requireCSRF(http.HandlerFunc(linksHandler))))
+	mux.Handle("DELETE /api/v1/links/{code}", requireLogin(requireCSRF(http.HandlerFunc(linkRevokeHandler))))
+	mux.Handle("/api/v1/me/notifications", requireLogin(requireCSRF(http.HandlerFunc(notificationsHandler))))
+	mux.Handle("/api/v1/me/subscriptions", requireLogin(requireCSRF(http.HandlerFunc(subscriptionsHandler))))
+	mux.Handle("DELETE /api/v1/me/subscriptions/{id}", requireLogin(requireCSRF(http.HandlerFunc(unsubscribeHandler))))
+	mux.Handle("GET /api/v1/me/inbox", requireLogin(http.HandlerFunc(inboxHandler)))
+	mux.Handle("POST /api/v1/me/inbox/read", requireLogin(requireCSRF(http.HandlerFunc(inboxReadHandler))))
+	mux.Handle("GET /api/v1/trash", requireLogin(http.HandlerFunc(trashHandler)))
+	mux.Handle("POST /api/v1/trash/{name}/restore", requireLogin(requireCSRF(http.HandlerFunc(trashRestoreHandler))))
+	mux.Handle("DELETE /api/v1/trash/{name}", requireLogin(requireCSRF(http.HandlerFunc(trashPurgeHandler))))
+	mux.HandleFunc("GET /api/v1/archive", archiveHandler)
+	mux.HandleFunc("GET /api/v1/export", exportHandler)
+	mux.HandleFunc("GET /image/{filename}", imageHandler)
+	mux.HandleFunc("GET /preview/{filename}", previewHandler)
+	mux.Handle("GET /search", requireLogin(http.HandlerFunc(searchHandler)))
+	mux.HandleFunc("GET /hls/{video}/{part}", hlsHandler)
+	mux.HandleFunc("GET /api/v1/hls/{video}", hlsStatusHandler)
+	mux.HandleFunc("HEAD /files/{name}", headFileHandler)
+	mux.HandleFunc("GET /api/v1/files/{name}", statHandler)
+	mux.HandleFunc("GET /api/v1/files/{name}/verify", verifyHandler)
+	mux.HandleFunc("GET /api/v1/files/{name}/manifest", manifestHandler)
+	mux.HandleFunc("POST /api/v1/files/{name}/report", reportHandler)
+	mux.Handle("POST /api/v1/files/{name}/visibility", requireLogin(requireCSRF(http.HandlerFunc(visibilityHandler))))
+	mux.Handle("GET /api/v1/files/{name}/lock", requireLogin(http.HandlerFunc(lockHandler)))
+	mux.Handle("PUT /api/v1/files/{name}/lock", requireLogin(requireCSRF(http.HandlerFunc(lockHandler))))
+	mux.HandleFunc("GET /api/v1/uploads/{id}/progress", uploadProgressHandler)
+	mux.Handle("GET /api/v1/jobs", requireLogin(http.HandlerFunc(pipelineJobsHandler)))
+	mux.Handle("GET /api/v1/jobs/{id}", requireLogin(http.HandlerFunc(pipelineJobHandler)))
+	mux.Handle("POST /api/v1/uploads/direct", requireLogin(requireCSRF(idempotent(http.HandlerFunc(directUploadHandler)))))
+	mux.HandleFunc("POST /api/v1/uploads/direct/complete", directCompleteHandler)
+	mux.HandleFunc("/api/v1/nodes", nodesHandler)
+	mux.HandleFunc("POST /api/v1/nodes/register", registerNodeHandler)
+	mux.HandleFunc("POST /api/v1/nodes/deregister", deregisterNodeHandler)
+	mux.HandleFunc("POST /api/v1/nodes/repair", repairReplicaHandler)
+	mux.HandleFunc("GET /api/v1/changes", changesHandler)
+	mux.HandleFunc("/api/v1/latency", latencyHandler)
+	mux.HandleFunc("GET /api/v1/cluster/topology", topologyHandler)
+	mux.HandleFunc("GET /api/v1/cluster/status", clusterStatusHandler)
+	mux.HandleFunc("GET /api/v1/features", featuresHandler)
+	mux.HandleFunc("GET /api/v1/analytics/downloads", analyticsHandler)
+	mux.HandleFunc("GET /api/v1/analytics/access", accessAnalyticsHandler)
+	mux.HandleFunc("GET /api/v1/prefetch", prefetchHandler)
+	mux.HandleFunc("GET /api/v1/maintenance", maintenanceHandler)
+	mux.HandleFunc("GET /cluster", topologyPageHandler)
+	if separateAdmin {
+		mux.HandleFunc("/api/v1/admin/", func(w http.ResponseWriter, r *http.Request) {
+			apierr.Send(w, "Admin endpoints are served on the admin listener", http.StatusNotFound)
+		})
+	} else {
+		adminEndpoints(mux)
+	}
+	return apierr.RequestIDs(logSlowRequests(logAccess(mux)))
+}
+
+// separateAdmin is set when the admin endpoints have listeners of their
+// own (Config.AdminListen), and so are left out of routes.
+var separateAdmin bool
+
+// adminRoutes returns the handlers for the admin listeners: the admin
+// endpoints, and the probes and version for whatever watches that address.
+func adminRoutes() http.Handler {
+	mux := validate.NewMux(requestRules)
+	mux.HandleFunc("GET /healthz", healthzHandler)
+	mux.HandleFunc("GET /readyz", readyzHandler)
+	mux.HandleFunc("GET /version", versionHandler)
+	adminEndpoints(mux)
+	return apierr.RequestIDs(logSlowRequests(logAccess(mux)))
+}
+
+// adminEndpoints adds the /api/v1/admin/ handlers to mux.
+func adminEndpoints(mux *validate.Mux) {
+	mux.Handle("POST /api/v1/admin/reload", requireAdmin(http.HandlerFunc(reloadHandler)))
+	mux.Handle("POST /api/v1/admin/prefetch", requireAdmin(http.HandlerFunc(prefetchRunHandler)))
+	mux.Handle("POST /api/v1/admin/jobs/{id}/retry", requireAdmin(http.HandlerFunc(pipelineRetryHandler)))
+	mux.Handle("GET /api/v1/admin/schedules", requireAdmin(http.HandlerFunc(schedulesHandler)))
+	mux.Handle("POST /api/v1/admin/schedules/{name}/run", requireAdmin(http.HandlerFunc(scheduleRunHandler)))
+	mux.Handle("POST /api/v1/admin/schedules/{name}/enable", requireAdmin(scheduleEnableHandler(true)))
+	mux.Handle("POST /api/v1/admin/schedules/{name}/disable", requireAdmin(scheduleEnableHandler(false)))
+	mux.Handle("GET /api/v1/admin/usage", requireAdmin(http.HandlerFunc(usageHandler)))
+	mux.Handle("POST /api/v1/admin/gc", requireAdmin(http.HandlerFunc(gcHandler)))
+	mux.Handle("/api/v1/admin/fsck", requireAdmin(http.HandlerFunc(fsckHandler)))
+	mux.Handle("GET /api/v1/admin/retention", requireAdmin(http.HandlerFunc(retentionHandler)))
+	mux.Handle("POST /api/v1/admin/retention", requireAdmin(http.HandlerFunc(retentionRunHandler)))
+	mux.Handle("GET /api/v1/admin/replication/queue", requireAdmin(http.HandlerFunc(replicationQueueHandler)))
+	mux.Handle("GET /api/v1/admin/replication/transfers", requireAdmin(http.HandlerFunc(transfersHandler)))
+	mux.Handle("DELETE /api/v1/admin/replication/queue", requireAdmin(http.HandlerFunc(replicationPurgeHandler)))
+	mux.Handle("POST /api/v1/admin/replication/queue/{id}", requireAdmin(http.HandlerFunc(replicationMoveHandler)))
+	mux.Handle("DELETE /api/v1/admin/replication/queue/{id}", requireAdmin(http.HandlerFunc(replicationDropHandler)))
+	mux.Handle("GET /api/v1/admin/quarantine", requireAdmin(http.HandlerFunc(quarantineListHandler)))
+	mux.Handle("POST /api/v1/admin/quarantine", requireAdmin(http.HandlerFunc(quarantineAddHandler)))
+	mux.Handle("POST /api/v1/admin/quarantine/{name}/release", requireAdmin(http.HandlerFunc(quarantineReleaseHandler)))
+	mux.Handle("DELETE /api/v1/admin/quarantine/{name}", requireAdmin(http.HandlerFunc(quarantinePurgeHandler)))
+	mux.Handle("POST /api/v1/admin/impersonate", requireRole(roleAdmin, requireCSRF(http.HandlerFunc(impersonateHandler))))
+	mux.Handle("GET /api/v1/admin/impersonations", requireAdmin(http.HandlerFunc(impersonationsHandler)))
+	mux.Handle("DELETE /api/v1/admin/impersonations/{id}", requireAdmin(http.HandlerFunc(impersonationRevokeHandler)))
+	mux.Handle("GET /api/v1/admin/users/{name}/export", requireAdmin(http.HandlerFunc(userExportHandler)))
+	mux.Handle("POST /api/v1/admin/users/{name}/erase", requireAdmin(http.HandlerFunc(userEraseHandler)))
+	mux.Handle("GET /api/v1/admin/groups", requireAdmin(http.HandlerFunc(groupsHandler)))
+	mux.Handle("PUT /api/v1/admin/groups/{name}", requireAdmin(http.HandlerFunc(groupPutHandler)))
+	mux.Handle("DELETE /api/v1/admin/groups/{name}", requireAdmin(http.HandlerFunc(groupDeleteHandler)))
+	mux.Handle("GET /api/v1/admin/folders", requireAdmin(http.HandlerFunc(foldersHandler)))
+	mux.Handle("PUT /api/v1/admin/folders/{name}", requireAdmin(http.HandlerFunc(folderPutHandler)))
+	mux.Handle("DELETE /api/v1/admin/folders/{name}", requireAdmin(http.HandlerFunc(folderDeleteHandler)))
+}
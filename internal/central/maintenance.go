@@ -0,0 +1,278 @@
+package central
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ---------------------------
+// Maintenance Windows
+// ---------------------------
+
+// Bulk traffic (repairs and prefetch moves) should not compete with users.
+// Scheduled bulk work runs only inside the maintenance windows, if any are
+// configured: the prefetch loop skips its runs outside them, and the
+// replication queue holds its repair tasks back until one opens. Runs an
+// admin starts by hand go ahead at any time. Every bulk transfer, whoever
+// started it, shares one bandwidth budget.
+
+// maintenanceWindow is a daily time span, as offsets from midnight; one
+// with end before start wraps past midnight.
+type maintenanceWindow struct {
+	start, end time.Duration
+}
+
+// parseWindow parses "HH:MM-HH:MM".
+func parseWindow(s string) (maintenanceWindow, error) {
+	from, to, ok := strings.Cut(strings.TrimSpace(s), "-")
+	if !ok {
+		return maintenanceWindow{}, fmt.Errorf("maintenance window %q: want HH:MM-HH:MM", s)
+	}
+	var w maintenanceWindow
+	for _, part := range []struct {
+		text string
+		dst  *time.Duration
+	}{{from, &w.start}, {to, &w.end}} {
+		t, err := time.Parse("15:04", strings.TrimSpace(part.text))
+		if err != nil {
+			return maintenanceWindow{}, fmt.Errorf("maintenance window %q: want HH:MM-HH:MM", s)
+		}
+		*part.dst = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	}
+	if w.start == w.end {
+		return maintenanceWindow{}, fmt.Errorf("maintenance window %q is empty", s)
+	}
+	return w, nil
+}
+
+func (w maintenanceWindow) contains(offset time.Duration) bool {
+	if w.start < w.end {
+		return offset >= w.start && offset < w.end
+	}
+	return offset >= w.start || offset < w.end
+}
+
+func (w maintenanceWindow) String() string {
+	hm := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+	}
+	return hm(w.start) + "-" + hm(w.end)
+}
+
+type maintenancePolicy struct {
+	windows []maintenanceWindow // none = any time
+	loc     *time.Location
+	rate    *byteRate // nil = unlimited
+}
+
+var maintenance = &maintenancePolicy{loc: time.Local}
+
+func newMaintenancePolicy(cfg Config) (*maintenancePolicy, error) {
+	m := &maintenancePolicy{loc: time.Local}
+	if cfg.MaintenanceTimezone != "" {
+		loc, err := time.LoadLocation(cfg.MaintenanceTimezone)
+		if err != nil {
+			return nil, fmt.Errorf("maintenance_timezone: %w", err)
+		}
+		m.loc = loc
+	}
+	for _, s := range cfg.MaintenanceWindows {
+		w, err := parseWindow(s)
+		if err != nil {
+			return nil, err
+		}
+		m.windows = append(m.windows, w)
+	}
+	if cfg.MaintenanceBytesPerSec > 0 {
+		m.rate = &byteRate{perSec: cfg.MaintenanceBytesPerSec}
+	}
+	return m, nil
+}
+
+// open reports whether bulk work may run at t.
+func (m *maintenancePolicy) open(t time.Time) bool {
+	if len(m.windows) == 0 {
+		return true
+	}
+	t = t.In(m.loc)
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second
+	for _, w := range m.windows {
+		if w.contains(offset) {
+			return true
+		}
+	}
+	return false
+}
+
+// nextOpen returns when bulk work may next run: t itself if a window is
+// open, else the start of the next window.
+func (m *maintenancePolicy) nextOpen(t time.Time) time.Time {
+	if m.open(t) {
+		return t
+	}
+	local := t.In(m.loc)
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, m.loc)
+	var next time.Time
+	for day := 0; day <= 1; day++ {
+		for _, w := range m.windows {
+			start := midnight.AddDate(0, 0, day).Add(w.start)
+			if start.After(t) && (next.IsZero() || start.Before(next)) {
+				next = start
+			}
+		}
+	}
+	return next
+}
+
+// throttle wraps a bulk transfer's reader in the bandwidth budget.
+func (m *maintenancePolicy) throttle(ctx context.Context, r io.Reader) io.Reader {
+	if m.rate == nil {
+		return r
+	}
+	return &throttledReader{ctx: ctx, r: r, rate: m.rate}
+}
+
+// book takes n bytes out of the bandwidth budget for a bulk transfer the
+// central API does not send itself, once the transfers booked before it
+// are through. It returns the rate the transfer should keep to, 0 if
+// there is no budget.
+func (m *maintenancePolicy) book(ctx context.Context, n int64) (int64, error) {
+	if m.rate == nil {
+		return 0, nil
+	}
+	return m.rate.perSec, m.rate.wait(ctx, int(n))
+}
+
+// byteRate spreads the bytes of all bulk transfers over time so they add
+// up to perSec: each read books the time its bytes take at that rate, and
+// waits for the reads booked before it.
+type byteRate struct {
+	mu     sync.Mutex
+	perSec int64
+	booked time.Time // when the bytes booked so far have gone out
+}
+
+func (b *byteRate) wait(ctx context.Context, n int) error {
+	b.mu.Lock()
+	now := time.Now()
+	if b.booked.Before(now) {
+		b.booked = now
+	}
+	start := b.booked
+	b.booked = b.booked.Add(time.Duration(float64(n) / float64(b.perSec) * float64(time.Second)))
+	b.mu.Unlock()
+
+	if d := start.Sub(now); d > 0 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+type throttledReader struct {
+	ctx  context.Context
+	r    io.Reader
+	rate *byteRate
+}
+
+// throttleChunk caps each read, so one transfer cannot book seconds of
+// the budget at once.
+const throttleChunk = 32 << 10
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleChunk {
+		p = p[:throttleChunk]
+	}
+	n, err := t.r.Read(p)
+	if n > 0 {
+		if werr := t.rate.wait(t.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// bulkPush is pushFile for repair and rebalancing traffic, within the
+// maintenance bandwidth budget. With direct_repair on, s first copies the
+// file from a peer (see copyFromPeer); the central copy is sent when no
+// peer could give it. Either way the file is booked in the budget once.
+func bulkPush(ctx context.Context, s StorageServer, filename string) error {
+	var size int64
+	if fi, serr := os.Stat(filepath.Join(uploadDir, filename)); serr == nil {
+		size = fi.Size()
+	}
+	err := errNoPeerCopy
+	var booked int64
+	if featureEnabled(featureDirectRepair) {
+		rate, berr := maintenance.book(ctx, size)
+		if berr != nil {
+			replicaFailures.record(filename, s.ID, berr)
+			return berr
+		}
+		err = copyFromPeer(ctx, s, filename, rate)
+		booked = rate
+	}
+	if err != nil {
+		t := transfers.start(filename, s.ID, "central", size)
+		err = pushFileThrough(ctx, s, filename, countTransfer(t, booked))
+		transfers.finish(t)
+	}
+	replicaFailures.record(filename, s.ID, err)
+	return err
+}
+
+// waitForMaintenance waits until bulk work may run.
+func waitForMaintenance(ctx context.Context) error {
+	now := time.Now()
+	next := maintenance.nextOpen(now)
+	if !next.After(now) {
+		return nil
+	}
+	t := time.NewTimer(next.Sub(now))
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// MaintenanceStatus is what GET /api/v1/maintenance reports.
+type MaintenanceStatus struct {
+	Open        bool      `json:"open"`
+	Windows     []string  `json:"windows"`
+	Timezone    string    `json:"timezone"`
+	NextOpen    time.Time `json:"next_open"`
+	BytesPerSec int64     `json:"bytes_per_sec,omitempty"` // 0 = unlimited
+}
+
+// maintenanceHandler reports whether bulk work may run now, and when it
+// next may: GET /api/v1/maintenance.
+func maintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	m := maintenance
+	now := time.Now()
+	st := MaintenanceStatus{Open: m.open(now), Windows: []string{}, Timezone: m.loc.String(), NextOpen: m.nextOpen(now)}
+	for _, win := range m.windows {
+		st.Windows = append(st.Windows, win.String())
+	}
+	if m.rate != nil {
+		st.BytesPerSec = m.rate.perSec
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(st)
+}
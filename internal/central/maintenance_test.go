@@ -0,0 +1,77 @@
+package central
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestMaintenanceWindows(t *testing.T) {
+	m, err := newMaintenancePolicy(Config{MaintenanceWindows: []string{"02:00-06:00", "22:30-00:30"}, MaintenanceTimezone: "UTC"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(hhmm string) time.Time {
+		tm, _ := time.Parse("2006-01-02 15:04", "2026-03-10 "+hhmm)
+		return tm
+	}
+	for hhmm, want := range map[string]bool{
+		"01:59": false, "02:00": true, "05:59": true, "06:00": false,
+		"22:29": false, "23:00": true, "00:00": true, "00:30": false,
+	} {
+		if got := m.open(at(hhmm)); got != want {
+			t.Errorf("open at %s = %v, want %v", hhmm, got, want)
+		}
+	}
+	if next := m.nextOpen(at("07:00")); !next.Equal(at("22:30")) {
+		t.Errorf("next open after 07:00 = %v", next)
+	}
+	if next := m.nextOpen(at("01:00")); !next.Equal(at("02:00")) {
+		t.Errorf("next open after 01:00 = %v", next)
+	}
+	if next := m.nextOpen(at("03:00")); !next.Equal(at("03:00")) {
+		t.Errorf("next open inside a window = %v", next)
+	}
+
+	for _, bad := range []string{"2-6", "02:00", "25:00-03:00", "04:00-04:00"} {
+		if _, err := newMaintenancePolicy(Config{MaintenanceWindows: []string{bad}}); err == nil {
+			t.Errorf("window %q accepted", bad)
+		}
+	}
+}
+
+func TestMaintenanceThrottle(t *testing.T) {
+	m, _ := newMaintenancePolicy(Config{MaintenanceBytesPerSec: 1 << 20})
+	start := time.Now()
+	n, err := io.Copy(io.Discard, m.throttle(context.Background(), bytes.NewReader(make([]byte, 512<<10))))
+	if err != nil || n != 512<<10 {
+		t.Fatalf("copied %d: %v", n, err)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("512 KB at 1 MB/s took %v", elapsed)
+	}
+}
+
+func TestQueueHoldsRepairsOutsideWindow(t *testing.T) {
+	prev := maintenance
+	t.Cleanup(func() { maintenance = prev })
+	now := time.Now()
+	later := now.Add(2 * time.Hour).UTC().Format("15:04")
+	end := now.Add(3 * time.Hour).UTC().Format("15:04")
+	maintenance, _ = newMaintenancePolicy(Config{MaintenanceWindows: []string{later + "-" + end}, MaintenanceTimezone: "UTC"})
+
+	q := newReplicationQueue(10)
+	q.push(&replicationTask{priority: priorityRepair, filename: "r.txt"})
+	q.push(&replicationTask{priority: priorityUpload, filename: "u.txt"})
+	if task := q.next(now); task == nil || task.filename != "u.txt" {
+		t.Fatalf("next = %+v, want the upload", task)
+	}
+	if task := q.next(now); task != nil {
+		t.Fatalf("repair ran outside the window: %+v", task)
+	}
+	if task := q.next(now.Add(2*time.Hour + 30*time.Minute)); task == nil || task.filename != "r.txt" {
+		t.Errorf("repair did not run in the window: %+v", task)
+	}
+}
@@ -0,0 +1,615 @@
+package central
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/checksum"
+)
+
+// ---------------------------
+// Composite Files
+// ---------------------------
+
+// A composite file is stored on the nodes as parts rather than as whole
+// replicas, and its manifest lists the parts: where each one is, which
+// bytes of the file it holds and its checksum. How the parts were made is
+// the manifest's kind (striped: see stripes.go); reading, checking and
+// deleting them is the same for every kind and lives here.
+//
+// The central copy stays the source of truth. Each part is an ordinary
+// file on its node, named partName(file, index), and the manifests are
+// kept in DataDir/manifests.json. A part that is missing, corrupt or on a
+// node that is down is read from the central copy instead, and fsck puts
+// it back.
+const partMarker = "p~"
+
+// Manifest kinds.
+const (
+	manifestStriped = "striped"
+)
+
+// Part is one piece of a composite file. Node is empty while the part
+// could not be placed anywhere.
+type Part struct {
+	Index    int    `json:"index"`
+	Offset   int64  `json:"offset"`
+	Size     int64  `json:"size"`
+	Node     string `json:"node,omitempty"`
+	Checksum string `json:"checksum"`
+}
+
+// Manifest says what a composite file is made of. Its parts cover the
+// file in order, with no gaps.
+type Manifest struct {
+	File     string    `json:"file"`
+	Kind     string    `json:"kind"`
+	Size     int64     `json:"size"`
+	PartSize int64     `json:"part_size"`
+	Checksum string    `json:"checksum"`
+	Created  time.Time `json:"created"`
+	Parts    []Part    `json:"parts"`
+}
+
+// newManifest cuts a file of size bytes into parts of partSize, the last
+// one shorter; an empty file has one empty part. The parts are not placed
+// yet and have no checksums.
+func newManifest(kind, file string, size, partSize int64, sum string) Manifest {
+	m := Manifest{File: file, Kind: kind, Size: size, PartSize: partSize, Checksum: sum, Created: time.Now().UTC()}
+	n := int((size + partSize - 1) / partSize)
+	m.Parts = make([]Part, max(n, 1))
+	for i := range m.Parts {
+		pt := &m.Parts[i]
+		pt.Index, pt.Offset = i, int64(i)*partSize
+		pt.Size = min(partSize, size-pt.Offset)
+	}
+	return m
+}
+
+// validate checks that m's parts cover its file in order.
+func (m Manifest) validate() error {
+	if len(m.Parts) == 0 {
+		return fmt.Errorf("manifest of %s has no parts", m.File)
+	}
+	var next int64
+	for i, pt := range m.Parts {
+		if pt.Index != i || pt.Offset != next || pt.Size < 0 {
+			return fmt.Errorf("manifest of %s: part %d does not follow on", m.File, i)
+		}
+		next += pt.Size
+	}
+	if next != m.Size {
+		return fmt.Errorf("manifest of %s: parts add up to %d bytes, want %d", m.File, next, m.Size)
+	}
+	return nil
+}
+
+// partName is the name part index of file is stored under on its node.
+func partName(file string, index int) string {
+	return fmt.Sprintf("%s%05d~%s", partMarker, index, file)
+}
+
+// isPartName reports whether a node's file is a part.
+func isPartName(name string) bool {
+	return strings.HasPrefix(name, partMarker)
+}
+
+type manifestRegistry struct {
+	mu        sync.Mutex
+	path      string
+	manifests map[string]Manifest
+}
+
+var manifests = &manifestRegistry{manifests: map[string]Manifest{}}
+
+func (reg *manifestRegistry) load(path string) error {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	reg.path = path
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw, &reg.manifests); err != nil {
+		return err
+	}
+	for _, m := range reg.manifests {
+		if err := m.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (reg *manifestRegistry) saveLocked() error {
+	if reg.path == "" {
+		return nil
+	}
+	raw, err := json.MarshalIndent(reg.manifests, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(reg.path), 0755); err != nil {
+		return err
+	}
+	tmp := reg.path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, reg.path)
+}
+
+func (reg *manifestRegistry) get(name string) (Manifest, bool) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	m, ok := reg.manifests[name]
+	return m, ok
+}
+
+func (reg *manifestRegistry) set(m Manifest) error {
+	if err := m.validate(); err != nil {
+		return err
+	}
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.manifests[m.File] = m
+	if err := reg.saveLocked(); err != nil {
+		fmt.Println("Cannot save manifests:", err)
+	}
+	return nil
+}
+
+// forget drops name's manifest and returns it.
+func (reg *manifestRegistry) forget(name string) (Manifest, bool) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	m, ok := reg.manifests[name]
+	if !ok {
+		return m, false
+	}
+	delete(reg.manifests, name)
+	if err := reg.saveLocked(); err != nil {
+		fmt.Println("Cannot save manifests:", err)
+	}
+	return m, true
+}
+
+// place records that a part of name is now on node.
+func (reg *manifestRegistry) place(name string, index int, node string) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	m, ok := reg.manifests[name]
+	if !ok || index >= len(m.Parts) {
+		return
+	}
+	m.Parts[index].Node = node
+	if err := reg.saveLocked(); err != nil {
+		fmt.Println("Cannot save manifests:", err)
+	}
+}
+
+// files returns the names of the composite files.
+func (reg *manifestRegistry) files() []string {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	out := make([]string, 0, len(reg.manifests))
+	for name := range reg.manifests {
+		out = append(out, name)
+	}
+	return out
+}
+
+// partNames returns the node file names of the parts of the named files,
+// those composite.
+func (reg *manifestRegistry) partNames(names []string) []string {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	var out []string
+	for _, name := range names {
+		for _, pt := range reg.manifests[name].Parts {
+			out = append(out, partName(name, pt.Index))
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// withParts adds the parts of the named files, and the HLS files of the
+// named videos, to a list of names sent to the nodes (quarantined, private,
+// trashed), so that they are held back wherever their file is.
+func withParts(names []string) []string {
+	parts := manifests.partNames(names)
+	for _, name := range names {
+		parts = append(parts, hlsFiles(name)...)
+	}
+	if len(parts) == 0 {
+		return names
+	}
+	out := append(append([]string{}, names...), parts...)
+	sort.Strings(out)
+	return out
+}
+
+// partsIntact reports whether every part of m on a node in listed (by
+// node, then name) is there with the manifest's checksum.
+func partsIntact(m Manifest, listed map[string]map[string]RemoteFile) bool {
+	for _, pt := range m.Parts {
+		files, ok := listed[pt.Node]
+		if !ok {
+			continue
+		}
+		if f, ok := files[partName(m.File, pt.Index)]; !ok || f.Checksum != pt.Checksum {
+			return false
+		}
+	}
+	return true
+}
+
+// staleParts returns the parts of old that cur does not reuse: those are
+// left on their nodes by a new upload of the file.
+func staleParts(old, cur Manifest) []Part {
+	var stale []Part
+	for _, pt := range old.Parts {
+		if pt.Index >= len(cur.Parts) || cur.Parts[pt.Index].Node != pt.Node {
+			stale = append(stale, pt)
+		}
+	}
+	return stale
+}
+
+// deleteParts removes parts of file from their nodes and returns the
+// errors by node ID.
+func deleteParts(ctx context.Context, file string, parts []Part) map[string]string {
+	var mu sync.Mutex
+	var errs map[string]string
+	var wg sync.WaitGroup
+	for _, pt := range parts {
+		s, ok := topo.get(pt.Node)
+		if !ok {
+			continue
+		}
+		wg.Add(1)
+		go func(s StorageServer, name string) {
+			defer wg.Done()
+			err := callNode(ctx, s, opDelete, func(ctx context.Context) error {
+				return deleteFromNode(ctx, s, name)
+			})
+			if err != nil {
+				fmt.Println("Delete error from", s.URL, ":", err)
+				mu.Lock()
+				if errs == nil {
+					errs = map[string]string{}
+				}
+				errs[s.ID] = err.Error()
+				mu.Unlock()
+			}
+		}(s, partName(file, pt.Index))
+	}
+	wg.Wait()
+	return errs
+}
+
+// sendPart writes a part of file, read from r, to s and returns its
+// checksum by alg, computed on the way. sent counts the bytes written; r
+// is rewound for every attempt.
+func sendPart(ctx context.Context, s StorageServer, file, alg string, pt Part, r io.ReadSeeker, sent *atomic.Int64) (string, error) {
+	var sum string
+	err := callNode(ctx, s, opUpload, func(ctx context.Context) error {
+		h, err := checksum.New(alg)
+		if err != nil {
+			return err
+		}
+		if sent != nil {
+			sent.Store(0)
+		}
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		status, body, err := forwardFileTo(ctx, s.URL, partName(file, pt.Index), alg, io.TeeReader(r, h), pt.Size, pt.Size, sent)
+		if err == nil && status != http.StatusOK {
+			err = &statusError{Status: status, Body: body}
+		}
+		sum = checksum.Format(alg, h)
+		return err
+	})
+	return sum, err
+}
+
+// pushPart writes a part of filename to s from the central copy.
+func pushPart(ctx context.Context, s StorageServer, filename string, pt Part) error {
+	f, err := os.Open(filepath.Join(uploadDir, filename))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = sendPart(ctx, s, filename, checksum.Algorithm(pt.Checksum), pt, io.NewSectionReader(f, pt.Offset, pt.Size), nil)
+	return err
+}
+
+// ---------------------------
+// Composite Download
+// ---------------------------
+
+// serveManifest sends a composite file assembled from its parts, fetching
+// up to stripeParallel of them at once and writing them in order. Each
+// part is checked against the manifest; one that cannot be fetched or does
+// not match is read from the central copy.
+func serveManifest(w http.ResponseWriter, r *http.Request, m Manifest) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	results := make([]chan []byte, len(m.Parts))
+	for i := range results {
+		results[i] = make(chan []byte, 1)
+	}
+	sem := make(chan struct{}, stripeParallel)
+	go func() {
+		for i, pt := range m.Parts {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			go func(i int, pt Part) {
+				b, err := readPart(ctx, m.File, pt)
+				if err != nil {
+					fmt.Println("Part", pt.Index, "of", m.File, ":", err)
+				}
+				results[i] <- b
+			}(i, pt)
+		}
+	}()
+
+	for i, pt := range m.Parts {
+		var b []byte
+		select {
+		case b = <-results[i]:
+		case <-ctx.Done():
+			return
+		}
+		if b == nil {
+			if i == 0 {
+				apierr.Send(w, "Part "+strconv.Itoa(pt.Index)+" is unavailable", http.StatusBadGateway)
+			}
+			return // a short body tells the client the download failed
+		}
+		if i == 0 {
+			h := w.Header()
+			h.Set("Content-Length", strconv.FormatInt(m.Size, 10))
+			if ct := mime.TypeByExtension(filepath.Ext(m.File)); ct != "" {
+				h.Set("Content-Type", ct)
+			}
+			h.Set("X-Checksum", m.Checksum)
+			h.Set("X-Manifest-Kind", m.Kind)
+			h.Set("X-Parts", strconv.Itoa(len(m.Parts)))
+		}
+		if _, err := w.Write(b); err != nil {
+			return
+		}
+		<-sem
+	}
+}
+
+// readPart fetches a part from its node, falling back to the central copy.
+// It returns nil and the last error if neither has it intact.
+func readPart(ctx context.Context, file string, pt Part) ([]byte, error) {
+	var err error
+	if s, ok := topo.get(pt.Node); ok && health.isHealthy(s.ID) {
+		var b []byte
+		start := time.Now()
+		err = callNode(ctx, s, opDownload, func(ctx context.Context) error {
+			var ferr error
+			b, ferr = fetchPart(ctx, s, file, pt)
+			return ferr
+		})
+		traceFrom(ctx).node(s.ID, time.Since(start), err)
+		if err == nil {
+			return b, nil
+		}
+	} else {
+		err = fmt.Errorf("not placed on a healthy node")
+	}
+
+	f, ferr := os.Open(filepath.Join(uploadDir, file))
+	if ferr != nil {
+		return nil, err
+	}
+	defer f.Close()
+	b := make([]byte, pt.Size)
+	if _, ferr := f.ReadAt(b, pt.Offset); ferr != nil && !(errors.Is(ferr, io.EOF) && pt.Size == 0) {
+		return nil, ferr
+	}
+	return b, nil
+}
+
+// fetchPart downloads a part from s and checks its size and checksum.
+func fetchPart(ctx context.Context, s StorageServer, file string, pt Part) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, nodeFileURL(s, partName(file, pt.Index)), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := nodeClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &statusError{Status: resp.StatusCode}
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, pt.Size))
+	if _, err := io.Copy(buf, io.LimitReader(resp.Body, pt.Size+1)); err != nil {
+		return nil, err
+	}
+	if int64(buf.Len()) != pt.Size {
+		return nil, fmt.Errorf("part is %d bytes, want %d", buf.Len(), pt.Size)
+	}
+	if sum, _ := checksum.Of(checksum.Algorithm(pt.Checksum), bytes.NewReader(buf.Bytes())); sum != pt.Checksum {
+		return nil, fmt.Errorf("part checksum %s, want %s", shortSum(sum), shortSum(pt.Checksum))
+	}
+	return buf.Bytes(), nil
+}
+
+// manifestHandler returns a composite file's manifest: GET
+// /api/v1/files/{name}/manifest.
+func manifestHandler(w http.ResponseWriter, r *http.Request) {
+	name := filepath.Base(r.PathValue("name"))
+	if refusePrivate(w, r, name) {
+		return
+	}
+	m, ok := manifests.get(name)
+	if !ok {
+		apierr.Send(w, "File has no manifest: it is stored whole", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m)
+}
+
+// ---------------------------
+// Composite Fsck
+// ---------------------------
+
+// checkManifest audits a composite file: each part must be on its node
+// with the manifest's checksum. Repair rewrites a missing or corrupt part
+// from the central copy, on another healthy node if its own is gone, and
+// restores a missing central copy from the parts.
+func checkManifest(ctx context.Context, m Manifest, inventory map[string]map[string]RemoteFile, nodes []StorageServer,
+	unreachable map[string]bool, repair bool) []FsckIssue {
+	var issues []FsckIssue
+	add := func(is FsckIssue, repairErr error) {
+		if repair {
+			if repairErr != nil {
+				is.Error = repairErr.Error()
+			} else {
+				is.Repaired = true
+			}
+		}
+		fmt.Println("fsck:", is.File, is.Node, is.Problem, is.Detail, is.Error)
+		issues = append(issues, is)
+	}
+
+	if !hasCentralCopy(m.File) {
+		is := FsckIssue{File: m.File, Problem: fsckMissingCentral, Detail: fmt.Sprintf("%d part(s)", len(m.Parts))}
+		var err error
+		if repair {
+			err = restoreFromParts(ctx, m)
+		}
+		add(is, err)
+		if !repair || err != nil {
+			return issues
+		}
+	}
+
+	var spare []StorageServer
+	for _, s := range nodes {
+		if !unreachable[s.ID] && health.isHealthy(s.ID) {
+			spare = append(spare, s)
+		}
+	}
+	for _, pt := range m.Parts {
+		if unreachable[pt.Node] {
+			continue // not held against the part
+		}
+		is := FsckIssue{File: m.File, Node: pt.Node, Problem: fsckMissingPart, Detail: fmt.Sprintf("part %d", pt.Index)}
+		f, ok := inventory[partName(m.File, pt.Index)][pt.Node]
+		switch {
+		case pt.Node == "" || !ok:
+		case f.Checksum != pt.Checksum:
+			is.Problem = fsckChecksumMismatch
+			is.Detail = fmt.Sprintf("part %d: %s, want %s", pt.Index, shortSum(f.Checksum), shortSum(pt.Checksum))
+		default:
+			continue
+		}
+		var err error
+		if repair {
+			err = repairPart(ctx, m.File, pt, spare)
+		}
+		add(is, err)
+	}
+	return issues
+}
+
+// repairPart rewrites a part from the central copy to its node, or to the
+// first of spare that takes it when its node is gone or unhealthy.
+func repairPart(ctx context.Context, file string, pt Part, spare []StorageServer) error {
+	var targets []StorageServer
+	if s, ok := topo.get(pt.Node); ok && health.isHealthy(s.ID) {
+		targets = append(targets, s)
+	}
+	for k := range spare {
+		if s := spare[(pt.Index+k)%len(spare)]; s.ID != pt.Node {
+			targets = append(targets, s)
+		}
+	}
+	err := errors.New("no node left to place the part on")
+	for _, s := range targets {
+		if err = pushPart(ctx, s, file, pt); err == nil {
+			if s.ID != pt.Node {
+				manifests.place(file, pt.Index, s.ID)
+			}
+			return nil
+		}
+	}
+	return err
+}
+
+// restoreFromParts rebuilds a composite file's central copy from its
+// parts, if together they still match the manifest's checksum.
+func restoreFromParts(ctx context.Context, m Manifest) error {
+	if err := os.MkdirAll(uploadDir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(uploadDir, ".restore-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+	h, err := checksum.New(checksum.Algorithm(m.Checksum))
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	w := io.MultiWriter(tmp, h)
+	for _, pt := range m.Parts {
+		b, err := readPart(ctx, m.File, pt)
+		if err != nil {
+			tmp.Close()
+			return fmt.Errorf("part %d: %w", pt.Index, err)
+		}
+		w.Write(b)
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if sum := checksum.Format(checksum.Algorithm(m.Checksum), h); sum != m.Checksum {
+		return fmt.Errorf("parts add up to %s, want %s", shortSum(sum), shortSum(m.Checksum))
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(uploadDir, m.File)); err != nil {
+		return err
+	}
+	recordUpload(m.File, m.Size, m.Checksum)
+	return nil
+}
@@ -0,0 +1,51 @@
+package central
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestNewManifest(t *testing.T) {
+	for _, tc := range []struct {
+		size, partSize int64
+		want           []int64
+	}{
+		{0, 10, []int64{0}},
+		{10, 10, []int64{10}},
+		{25, 10, []int64{10, 10, 5}},
+	} {
+		m := newManifest(manifestStriped, "f", tc.size, tc.partSize, "")
+		var sizes []int64
+		for _, pt := range m.Parts {
+			sizes = append(sizes, pt.Size)
+		}
+		if !slices.Equal(sizes, tc.want) || m.validate() != nil {
+			t.Errorf("%d in parts of %d = %v (%v), want %v", tc.size, tc.partSize, sizes, m.validate(), tc.want)
+		}
+	}
+
+	m := newManifest(manifestStriped, "f", 25, 10, "")
+	m.Parts[1].Size = 9
+	if m.validate() == nil {
+		t.Error("manifest with a gap accepted")
+	}
+	if err := manifests.set(m); err == nil {
+		t.Error("manifest with a gap recorded")
+	}
+}
+
+func TestRestoreCentralFromParts(t *testing.T) {
+	c := newTestCluster(t, 2, func(cfg *Config) { cfg.StripeSize = 3 })
+	c.upload("parts.txt", "put back together", url.Values{"stripe": {"1"}})
+	os.Remove(filepath.Join(uploadDir, "parts.txt"))
+
+	if got := issueKeys(c.fsck(true)); !slices.Equal(got, []string{"parts.txt/:" + fsckMissingCentral}) {
+		t.Errorf("fsck issues = %v", got)
+	}
+	if b, err := os.ReadFile(filepath.Join(uploadDir, "parts.txt")); string(b) != "put back together" {
+		t.Errorf("central copy = %q %v", b, err)
+	}
+}
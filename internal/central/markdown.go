@@ -0,0 +1,173 @@
+package central
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ---------------------------
+// Markdown
+// ---------------------------
+
+// renderMarkdown renders the common part of Markdown to HTML: headings,
+// paragraphs, lists, block quotes, rules, fenced and indented code, and
+// inline code, emphasis, links and images. Everything from the source is
+// escaped; only the tags generated here are markup, and links go nowhere
+// but http(s), mailto or relative URLs.
+func renderMarkdown(src string) string {
+	var b strings.Builder
+	renderMarkdownBlocks(&b, strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n"))
+	return b.String()
+}
+
+var (
+	mdHeading = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	mdRule    = regexp.MustCompile(`^\s*([-*_])(\s*([-*_])){2,}\s*$`)
+	mdBullet  = regexp.MustCompile(`^\s*[-*+]\s+(.*)$`)
+	mdOrdered = regexp.MustCompile(`^\s*\d+[.)]\s+(.*)$`)
+)
+
+func renderMarkdownBlocks(b *strings.Builder, lines []string) {
+	var para []string
+	flush := func() {
+		if para != nil {
+			b.WriteString("<p>" + renderInline(strings.Join(para, " ")) + "</p>\n")
+			para = nil
+		}
+	}
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+			flush()
+
+		case strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~"):
+			flush()
+			fence, lang := trimmed[:3], strings.TrimSpace(trimmed[3:])
+			var code []string
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), fence); i++ {
+				code = append(code, lines[i])
+			}
+			b.WriteString(`<pre><code>` + highlightCode(strings.Join(code, "\n"), lang) + "</code></pre>\n")
+
+		case strings.HasPrefix(line, "    ") || strings.HasPrefix(line, "\t"):
+			if para != nil { // a continuation line
+				para = append(para, trimmed)
+				continue
+			}
+			var code []string
+			for ; i < len(lines) && (strings.HasPrefix(lines[i], "    ") || strings.HasPrefix(lines[i], "\t") || strings.TrimSpace(lines[i]) == ""); i++ {
+				code = append(code, strings.TrimPrefix(strings.TrimPrefix(lines[i], "\t"), "    "))
+			}
+			i--
+			b.WriteString("<pre><code>" + html.EscapeString(strings.TrimRight(strings.Join(code, "\n"), "\n")) + "</code></pre>\n")
+
+		case mdHeading.MatchString(trimmed):
+			flush()
+			m := mdHeading.FindStringSubmatch(trimmed)
+			tag := "h" + string(rune('0'+len(m[1])))
+			b.WriteString("<" + tag + ">" + renderInline(m[2]) + "</" + tag + ">\n")
+
+		case mdRule.MatchString(line):
+			flush()
+			b.WriteString("<hr>\n")
+
+		case strings.HasPrefix(trimmed, ">"):
+			flush()
+			var quote []string
+			for ; i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), ">"); i++ {
+				q := strings.TrimPrefix(strings.TrimSpace(lines[i]), ">")
+				quote = append(quote, strings.TrimPrefix(q, " "))
+			}
+			i--
+			b.WriteString("<blockquote>\n")
+			renderMarkdownBlocks(b, quote)
+			b.WriteString("</blockquote>\n")
+
+		case mdBullet.MatchString(line) || mdOrdered.MatchString(line):
+			flush()
+			item, tag := mdBullet, "ul"
+			if !mdBullet.MatchString(line) {
+				item, tag = mdOrdered, "ol"
+			}
+			b.WriteString("<" + tag + ">\n")
+			for ; i < len(lines) && item.MatchString(lines[i]); i++ {
+				b.WriteString("<li>" + renderInline(item.FindStringSubmatch(lines[i])[1]) + "</li>\n")
+			}
+			i--
+			b.WriteString("</" + tag + ">\n")
+
+		default:
+			para = append(para, trimmed)
+		}
+	}
+	flush()
+}
+
+var (
+	mdImage  = regexp.MustCompile(`!\[([^\]]*)\]\(([^)\s]+)\)`)
+	mdLink   = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	mdStrong = regexp.MustCompile(`\*\*([^*]+)\*\*|__([^_]+)__`)
+	mdEm     = regexp.MustCompile(`\*([^*]+)\*|\b_([^_]+)_\b`)
+	mdHeld   = regexp.MustCompile("\x00[0-9]+\x00")
+)
+
+// renderInline renders one block's text. Code spans are cut out first so
+// nothing inside them is taken for markup.
+func renderInline(s string) string {
+	parts := strings.Split(s, "`")
+	if len(parts)%2 == 0 { // an unmatched backtick is just a backtick
+		parts[len(parts)-2] += "`" + parts[len(parts)-1]
+		parts = parts[:len(parts)-1]
+	}
+	var b strings.Builder
+	for i, part := range parts {
+		if i%2 == 1 {
+			b.WriteString("<code>" + html.EscapeString(part) + "</code>")
+			continue
+		}
+		// Images and links are swapped for placeholders while emphasis is
+		// applied, so their URLs are left alone.
+		var held []string
+		hold := func(markup string) string {
+			held = append(held, markup)
+			return "\x00" + strconv.Itoa(len(held)-1) + "\x00"
+		}
+		t := html.EscapeString(strings.ReplaceAll(part, "\x00", ""))
+		t = mdImage.ReplaceAllStringFunc(t, func(m string) string {
+			sm := mdImage.FindStringSubmatch(m)
+			if !safeURL(sm[2]) {
+				return sm[1]
+			}
+			return hold(`<img src="` + sm[2] + `" alt="` + sm[1] + `">`)
+		})
+		t = mdLink.ReplaceAllStringFunc(t, func(m string) string {
+			sm := mdLink.FindStringSubmatch(m)
+			if !safeURL(sm[2]) {
+				return sm[1]
+			}
+			return `<a href="` + hold(sm[2]) + `" rel="nofollow noopener">` + sm[1] + `</a>`
+		})
+		t = mdStrong.ReplaceAllString(t, "<strong>$1$2</strong>")
+		t = mdEm.ReplaceAllString(t, "<em>$1$2</em>")
+		t = mdHeld.ReplaceAllStringFunc(t, func(m string) string {
+			n, _ := strconv.Atoi(strings.Trim(m, "\x00"))
+			return held[n]
+		})
+		b.WriteString(t)
+	}
+	return b.String()
+}
+
+// safeURL reports whether an (escaped) URL from Markdown may be linked:
+// http(s), mailto, or relative.
+func safeURL(u string) bool {
+	lower := strings.ToLower(html.UnescapeString(u))
+	if i := strings.IndexAny(lower, ":/?#"); i < 0 || lower[i] != ':' {
+		return true // no scheme
+	}
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://") || strings.HasPrefix(lower, "mailto:")
+}
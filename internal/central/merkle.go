@@ -0,0 +1,183 @@
+package central
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/checksum"
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/merkle"
+)
+
+// ---------------------------
+// Inventory Sync
+// ---------------------------
+
+// fsck and GC need to know what every node holds. Rather than list each
+// node in full, the central copies are built into a Merkle tree (package
+// merkle) and compared with the node's tree top down: subtrees whose hashes
+// match hold exactly the central files with the central checksums, and only
+// the subtrees that differ are listed. A node in sync costs one request
+// however many files it has.
+
+// Subtrees with at most this many files on the node are listed rather than
+// descended into.
+var merkleListBelow = 256
+
+// centralSums caches the checksums of the central copies like the nodes
+// do, by size and modification time.
+var centralSums = struct {
+	sync.Mutex
+	m map[string]centralSum
+}{m: map[string]centralSum{}}
+
+type centralSum struct {
+	size    int64
+	modTime time.Time
+	sum     string
+}
+
+// centralChecksum returns the checksum of the central copy of name, by
+// the algorithm it was stored with.
+func centralChecksum(name string) (string, error) {
+	path := filepath.Join(uploadDir, name)
+	alg := fileAlgorithms.of(name)
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	centralSums.Lock()
+	c, ok := centralSums.m[name]
+	centralSums.Unlock()
+	if ok && c.size == fi.Size() && c.modTime.Equal(fi.ModTime()) && checksum.Algorithm(c.sum) == alg {
+		return c.sum, nil
+	}
+	sum, err := fileChecksum(path, alg)
+	if err != nil {
+		return "", err
+	}
+	centralSums.Lock()
+	centralSums.m[name] = centralSum{size: fi.Size(), modTime: fi.ModTime(), sum: sum}
+	centralSums.Unlock()
+	return sum, nil
+}
+
+// centralInventory is the tree over the central copies and the same files
+// as a node would list them.
+type centralInventory struct {
+	tree  *merkle.Tree
+	files map[string]RemoteFile
+}
+
+func loadCentralInventory() centralInventory {
+	inv := centralInventory{files: map[string]RemoteFile{}}
+	entries, _ := os.ReadDir(uploadDir)
+	var leaves []merkle.Entry
+	for _, e := range entries {
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+		sum, err := centralChecksum(e.Name())
+		if err != nil {
+			continue
+		}
+		inv.files[e.Name()] = RemoteFile{Name: e.Name(), Size: fi.Size(), ModTime: fi.ModTime().UTC(), Checksum: sum}
+		leaves = append(leaves, merkle.Entry{Name: e.Name(), Checksum: sum})
+	}
+	inv.tree = merkle.Build(leaves)
+	return inv
+}
+
+// under returns the central files in the subtree under prefix.
+func (inv centralInventory) under(prefix string) []RemoteFile {
+	var out []RemoteFile
+	for _, name := range inv.tree.Names(prefix) {
+		out = append(out, inv.files[name])
+	}
+	return out
+}
+
+// syncNodeFiles returns what s holds, listing only where its tree differs
+// from inv. Files in matching subtrees are taken from inv. Nodes that do
+// not serve a tree are listed in full.
+func syncNodeFiles(ctx context.Context, s StorageServer, inv centralInventory) ([]RemoteFile, error) {
+	var list []RemoteFile
+	err := callNode(ctx, s, opList, func(ctx context.Context) error {
+		list = nil
+		var walk func(prefix string) error
+		walk = func(prefix string) error {
+			remote, children, err := fetchMerkle(ctx, s, prefix)
+			if err != nil {
+				return err
+			}
+			if remote.Hash == inv.tree.Summary(prefix).Hash {
+				list = append(list, inv.under(prefix)...)
+				return nil
+			}
+			if remote.Count <= merkleListBelow {
+				files, err := fetchMerkleFiles(ctx, s, prefix)
+				list = append(list, files...)
+				return err
+			}
+			for _, c := range children {
+				if c.Hash == inv.tree.Summary(c.Prefix).Hash {
+					list = append(list, inv.under(c.Prefix)...)
+					continue
+				}
+				if err := walk(c.Prefix); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		err := walk("")
+		var se *statusError
+		if errors.As(err, &se) && se.Status == http.StatusNotFound {
+			list, err = listNodeFiles(ctx, s)
+		}
+		return err
+	})
+	return list, err
+}
+
+func fetchMerkle(ctx context.Context, s StorageServer, prefix string) (merkle.Summary, []merkle.Summary, error) {
+	var resp struct {
+		merkle.Summary
+		Children []merkle.Summary `json:"children"`
+	}
+	err := getNodeJSON(ctx, s.URL+"/api/v1/merkle?prefix="+url.QueryEscape(prefix), &resp)
+	return resp.Summary, resp.Children, err
+}
+
+func fetchMerkleFiles(ctx context.Context, s StorageServer, prefix string) ([]RemoteFile, error) {
+	var list []RemoteFile
+	err := getNodeJSON(ctx, s.URL+"/api/v1/merkle/files?prefix="+url.QueryEscape(prefix), &list)
+	return list, err
+}
+
+func getNodeJSON(ctx context.Context, u string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := nodeClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return &statusError{Status: resp.StatusCode}
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,43 @@
+package central
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSyncNodeFilesListsOnlyDifferences(t *testing.T) {
+	c := newTestCluster(t, 1, nil)
+	for i := 0; i < 40; i++ {
+		c.upload(fmt.Sprintf("f%02d.txt", i), fmt.Sprint(i), nearLondon)
+	}
+	node := c.node("sg")
+	node.backend.Put("f07.txt", strings.NewReader("rotten"))
+	node.backend.Put("stray.txt", strings.NewReader("stray"))
+
+	// Force a descent below the root.
+	defer func(n int) { merkleListBelow = n }(merkleListBelow)
+	merkleListBelow = 2
+
+	got, err := syncNodeFiles(context.Background(), node.StorageServer, loadCentralInventory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := listNodeFiles(context.Background(), node.StorageServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := func(fs []RemoteFile) []string {
+		var out []string
+		for _, f := range fs {
+			out = append(out, f.Name+"="+shortSum(f.Checksum))
+		}
+		slices.Sort(out)
+		return out
+	}
+	if !slices.Equal(key(got), key(want)) {
+		t.Errorf("synced inventory differs from the full listing:\n got %v\nwant %v", key(got), key(want))
+	}
+}
@@ -0,0 +1,792 @@
+package central
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+)
+
+// ---------------------------
+// Notifications
+// ---------------------------
+
+// Users subscribe to event types over channels: email (to their address,
+// by default the one in Config.Users), a webhook (a JSON POST to a URL of
+// theirs, never to an internal address), or ui (an inbox the web UI shows). Some events concern one user
+// (a file shared with them, their upload finishing); the others are about
+// the cluster and go to admins only. A user who never changed their
+// subscriptions gets shares and replication failures by email.
+//
+//	GET, PUT /api/v1/me/notifications      the address, subscriptions, event types
+//	GET, POST /api/v1/me/subscriptions     {"event": ..., "channel": ..., "url": ...}
+//	DELETE /api/v1/me/subscriptions/{id}
+//	GET /api/v1/me/inbox, POST /api/v1/me/inbox/read
+
+// Event types.
+const (
+	eventFileShared         = "file.shared"
+	eventUploadComplete     = "upload.complete"
+	eventQuotaNearLimit     = "quota.near_limit"
+	eventNodeDown           = "node.down"
+	eventReplicationFailing = "replication.failing"
+)
+
+type eventType struct {
+	template  string
+	adminOnly bool
+}
+
+var eventTypes = map[string]eventType{
+	eventFileShared:         {"mail_share.txt", false},
+	eventUploadComplete:     {"mail_upload.txt", false},
+	eventQuotaNearLimit:     {"mail_quota.txt", true},
+	eventNodeDown:           {"mail_node_down.txt", true},
+	eventReplicationFailing: {"mail_replication.txt", true},
+}
+
+// Channels.
+const (
+	channelEmail   = "email"
+	channelWebhook = "webhook"
+	channelUI      = "ui"
+)
+
+var channels = []string{channelEmail, channelWebhook, channelUI}
+
+// Subscription sends one event type to a user over one channel.
+type Subscription struct {
+	ID      string `json:"id"`
+	Event   string `json:"event"`
+	Channel string `json:"channel"`
+	URL     string `json:"url,omitempty"` // webhook only
+}
+
+func defaultSubscriptions() []Subscription {
+	return []Subscription{
+		{ID: "default-share", Event: eventFileShared, Channel: channelEmail},
+		{ID: "default-replication", Event: eventReplicationFailing, Channel: channelEmail},
+	}
+}
+
+// Notification is one event in a user's inbox.
+type Notification struct {
+	ID      string    `json:"id"`
+	Event   string    `json:"event"`
+	Time    time.Time `json:"time"`
+	Subject string    `json:"subject"`
+	Body    string    `json:"body"`
+	Read    bool      `json:"read"`
+}
+
+// maxInbox is how many notifications an inbox keeps, dropping the oldest.
+const maxInbox = 100
+
+var (
+	replicationAlertAfter = 30 * time.Minute
+	quotaWarnPercent      = 90
+)
+
+// ---------------------------
+// Preferences
+// ---------------------------
+
+// notifyRecord is a user's saved settings and inbox. Admin is whether they
+// were an admin when they last changed them: sign-in providers cannot list
+// their admins, so this is how cluster events find the ones not in
+// Config.Users.
+type notifyRecord struct {
+	Email         string         `json:"email,omitempty"`
+	Subscriptions []Subscription `json:"subscriptions"` // nil: the defaults
+	Inbox         []Notification `json:"inbox,omitempty"`
+	Admin         bool           `json:"admin,omitempty"`
+}
+
+type notifyRegistry struct {
+	mu    sync.Mutex
+	path  string
+	users map[string]*notifyRecord
+}
+
+var notifyPrefs = &notifyRegistry{users: map[string]*notifyRecord{}}
+
+func (reg *notifyRegistry) load(path string) error {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	reg.path = path
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, &reg.users)
+}
+
+func (reg *notifyRegistry) saveLocked() error {
+	if reg.path == "" {
+		return nil
+	}
+	raw, err := json.MarshalIndent(reg.users, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(reg.path), 0755); err != nil {
+		return err
+	}
+	tmp := reg.path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, reg.path)
+}
+
+// update changes id's record with fn and saves it.
+func (reg *notifyRegistry) update(id identity, fn func(rec *notifyRecord)) error {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	rec := reg.recordLocked(id.Name)
+	fn(rec)
+	rec.Admin = roleRank[id.Role] >= roleRank[roleAdmin]
+	reg.users[id.Name] = rec
+	return reg.saveLocked()
+}
+
+// recordLocked returns a copy of user's record, with the defaults filled
+// in, for the caller to store if it changes it.
+func (reg *notifyRegistry) recordLocked(user string) *notifyRecord {
+	rec := &notifyRecord{}
+	if old, ok := reg.users[user]; ok {
+		*rec = *old
+	}
+	if rec.Subscriptions == nil {
+		rec.Subscriptions = defaultSubscriptions()
+	}
+	return rec
+}
+
+// get returns a copy of user's settings, without the inbox.
+func (reg *notifyRegistry) get(user string) notifyRecord {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	rec := *reg.recordLocked(user)
+	if rec.Email == "" {
+		rec.Email = users[user].Email
+	}
+	rec.Subscriptions = append([]Subscription(nil), rec.Subscriptions...)
+	rec.Inbox = nil
+	return rec
+}
+
+// forget drops user's settings and inbox, reporting whether there were
+// any.
+func (reg *notifyRegistry) forget(user string) bool {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if _, ok := reg.users[user]; !ok {
+		return false
+	}
+	delete(reg.users, user)
+	if err := reg.saveLocked(); err != nil {
+		fmt.Println("Cannot save notifications:", err)
+	}
+	return true
+}
+
+// deliver puts n in user's inbox.
+func (reg *notifyRegistry) deliver(user string, n Notification) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	rec := reg.recordLocked(user)
+	rec.Inbox = append(rec.Inbox, n)
+	if len(rec.Inbox) > maxInbox {
+		rec.Inbox = rec.Inbox[len(rec.Inbox)-maxInbox:]
+	}
+	reg.users[user] = rec
+	if err := reg.saveLocked(); err != nil {
+		fmt.Println("Cannot save notifications:", err)
+	}
+}
+
+// inbox returns user's notifications, newest first.
+func (reg *notifyRegistry) inbox(user string) []Notification {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	out := []Notification{}
+	if rec, ok := reg.users[user]; ok {
+		for i := len(rec.Inbox) - 1; i >= 0; i-- {
+			out = append(out, rec.Inbox[i])
+		}
+	}
+	return out
+}
+
+// unread counts user's unread notifications, for the page header.
+func (reg *notifyRegistry) unread(user string) int {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	n := 0
+	if rec, ok := reg.users[user]; ok {
+		for _, m := range rec.Inbox {
+			if !m.Read {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// admins returns the users cluster events go to.
+func (reg *notifyRegistry) admins() []string {
+	reg.mu.Lock()
+	seen := map[string]bool{}
+	for name, rec := range reg.users {
+		if rec.Admin {
+			seen[name] = true
+		}
+	}
+	reg.mu.Unlock()
+	for _, u := range users {
+		if u.role() == roleAdmin {
+			seen[u.Name] = true
+		}
+	}
+	out := make([]string, 0, len(seen))
+	for name := range seen {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// ---------------------------
+// Delivery
+// ---------------------------
+
+// webhookClient posts webhooks. Its dialer refuses internal addresses, so
+// a name that resolved to a public address when the subscription was made
+// cannot be pointed into the cluster's network later. It uses no proxy:
+// the proxy's address is the one that would be checked.
+var webhookClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{Timeout: 5 * time.Second, Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || internalAddr(ip) {
+				return fmt.Errorf("webhook to internal address %s refused", host)
+			}
+			return nil
+		}}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+	},
+}
+
+// webhooksReachInternal lets webhooks reach internal addresses. Tests set
+// it, their receivers listening on loopback.
+var webhooksReachInternal = false
+
+// internalAddr reports whether ip is one a webhook may not reach: this
+// host, the private networks around it, and link-local addresses such as
+// cloud metadata services.
+func internalAddr(ip net.IP) bool {
+	if webhooksReachInternal {
+		return false
+	}
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast()
+}
+
+// checkWebhookHost resolves a webhook's host and refuses it if any of its
+// addresses is internal.
+func checkWebhookHost(ctx context.Context, host string) error {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return err
+	}
+	for _, a := range addrs {
+		if internalAddr(a.IP) {
+			return fmt.Errorf("%s is an internal address", a.IP)
+		}
+	}
+	return nil
+}
+
+// webhookPayload is what a webhook subscription is POSTed.
+type webhookPayload struct {
+	Event   string    `json:"event"`
+	Time    time.Time `json:"time"`
+	User    string    `json:"user"`
+	Subject string    `json:"subject"`
+	Body    string    `json:"body"`
+	Data    any       `json:"data"`
+}
+
+// publish delivers an event to the users it concerns, or for the
+// admin-only types to the admins, over the channels they subscribed to it
+// on.
+func publish(event string, to []string, data any) {
+	et := eventTypes[event]
+	if et.adminOnly {
+		to = notifyPrefs.admins()
+	}
+	if len(to) == 0 || mailTemplates == nil {
+		return
+	}
+	subject, body, err := renderNotice(et.template, data)
+	if err != nil {
+		fmt.Println("Cannot render", et.template+":", err)
+		return
+	}
+	now := time.Now().UTC()
+	for _, user := range to {
+		rec := notifyPrefs.get(user)
+		for _, s := range rec.Subscriptions {
+			if s.Event != event {
+				continue
+			}
+			switch s.Channel {
+			case channelEmail:
+				sendMail(rec.Email, subject, body)
+			case channelUI:
+				notifyPrefs.deliver(user, Notification{ID: newNotifyID(), Event: event, Time: now, Subject: subject, Body: body})
+			case channelWebhook:
+				go postWebhook(s.URL, webhookPayload{event, now, user, subject, body, data})
+			}
+		}
+	}
+}
+
+// postWebhook POSTs p to url, retrying as node calls are.
+func postWebhook(url string, p webhookPayload) {
+	raw, err := json.Marshal(p)
+	if err != nil {
+		fmt.Println("Cannot encode webhook:", err)
+		return
+	}
+	err = retries.do(context.Background(), func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(raw))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := webhookClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return &statusError{Status: resp.StatusCode}
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Println("Webhook", p.Event, "to", url, "failed:", err)
+	}
+}
+
+func newNotifyID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ---------------------------
+// API
+// ---------------------------
+
+// notifyUser returns the signed-in user, or answers 409 when there is no
+// sign-in to tie settings to.
+func notifyUser(w http.ResponseWriter, r *http.Request) (identity, bool) {
+	id := signedIn(r)
+	if id.Name == "" {
+		apierr.Send(w, "Notifications need sign-in configured", http.StatusConflict)
+		return id, false
+	}
+	return id, true
+}
+
+// eventsFor lists the event types id may subscribe to.
+func eventsFor(id identity) []string {
+	var out []string
+	for name, et := range eventTypes {
+		if !et.adminOnly || roleRank[id.Role] >= roleRank[roleAdmin] {
+			out = append(out, name)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// notificationsHandler shows (GET) the signed-in user's settings and
+// changes (PUT {"email": ...}) their address.
+func notificationsHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := notifyUser(w, r)
+	if !ok {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var req struct {
+			Email string `json:"email"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<10)).Decode(&req); err != nil {
+			apierr.Send(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Email != "" && !validEmail(req.Email) {
+			apierr.Send(w, "email must be a bare email address", http.StatusBadRequest)
+			return
+		}
+		if err := notifyPrefs.update(id, func(rec *notifyRecord) { rec.Email = req.Email }); err != nil {
+			fmt.Println("Cannot save notifications:", err)
+			apierr.Send(w, "Could not save the settings", http.StatusInternalServerError)
+			return
+		}
+	default:
+		apierr.Send(w, "Use GET or PUT", http.StatusMethodNotAllowed)
+		return
+	}
+	rec := notifyPrefs.get(id.Name)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Email         string         `json:"email"`
+		Subscriptions []Subscription `json:"subscriptions"`
+		Unread        int            `json:"unread"`
+		Events        []string       `json:"events"`
+		Channels      []string       `json:"channels"`
+	}{rec.Email, rec.Subscriptions, notifyPrefs.unread(id.Name), eventsFor(id), channels})
+}
+
+// subscriptionsHandler lists (GET) the signed-in user's subscriptions and
+// adds (POST) one.
+func subscriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := notifyUser(w, r)
+	if !ok {
+		return
+	}
+	if r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(notifyPrefs.get(id.Name).Subscriptions)
+		return
+	}
+	if r.Method != http.MethodPost {
+		apierr.Send(w, "Use GET or POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var s Subscription
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<10)).Decode(&s); err != nil {
+		apierr.Send(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	et, known := eventTypes[s.Event]
+	switch {
+	case !known:
+		apierr.Send(w, fmt.Sprintf("unknown event %q (want one of %v)", s.Event, eventsFor(id)), http.StatusBadRequest)
+		return
+	case et.adminOnly && roleRank[id.Role] < roleRank[roleAdmin]:
+		apierr.Send(w, "Only admins get "+s.Event, http.StatusForbidden)
+		return
+	case s.Channel != channelEmail && s.Channel != channelWebhook && s.Channel != channelUI:
+		apierr.Send(w, fmt.Sprintf("unknown channel %q (want email, webhook or ui)", s.Channel), http.StatusBadRequest)
+		return
+	}
+	if s.Channel == channelWebhook {
+		u, err := url.Parse(s.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			apierr.Send(w, "A webhook needs an http(s) url", http.StatusBadRequest)
+			return
+		}
+		if err := checkWebhookHost(r.Context(), u.Hostname()); err != nil {
+			apierr.Send(w, "Webhook refused: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else {
+		s.URL = ""
+	}
+
+	s.ID = newNotifyID()
+	err := notifyPrefs.update(id, func(rec *notifyRecord) {
+		for _, old := range rec.Subscriptions {
+			if old.Event == s.Event && old.Channel == s.Channel && old.URL == s.URL {
+				s = old // already subscribed
+				return
+			}
+		}
+		rec.Subscriptions = append(rec.Subscriptions, s)
+	})
+	if err != nil {
+		fmt.Println("Cannot save notifications:", err)
+		apierr.Send(w, "Could not save the subscription", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(s)
+}
+
+// unsubscribeHandler drops one: DELETE /api/v1/me/subscriptions/{id}.
+func unsubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := notifyUser(w, r)
+	if !ok {
+		return
+	}
+	subID := r.PathValue("id")
+	found := false
+	err := notifyPrefs.update(id, func(rec *notifyRecord) {
+		kept := []Subscription{}
+		for _, s := range rec.Subscriptions {
+			if s.ID == subID {
+				found = true
+				continue
+			}
+			kept = append(kept, s)
+		}
+		rec.Subscriptions = kept
+	})
+	if err != nil {
+		fmt.Println("Cannot save notifications:", err)
+		apierr.Send(w, "Could not save the subscriptions", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// inboxHandler lists the signed-in user's in-UI notifications, newest
+// first: GET /api/v1/me/inbox.
+func inboxHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := notifyUser(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(notifyPrefs.inbox(id.Name))
+}
+
+// inboxReadHandler marks them all read: POST /api/v1/me/inbox/read.
+func inboxReadHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := notifyUser(w, r)
+	if !ok {
+		return
+	}
+	err := notifyPrefs.update(id, func(rec *notifyRecord) {
+		for i := range rec.Inbox {
+			rec.Inbox[i].Read = true
+		}
+	})
+	if err != nil {
+		fmt.Println("Cannot save notifications:", err)
+		apierr.Send(w, "Could not save the inbox", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ---------------------------
+// Events
+// ---------------------------
+
+// shareNotice is the data of file.shared.
+type shareNotice struct {
+	From         string    `json:"from"`
+	File         string    `json:"file"`
+	Link         string    `json:"link"`
+	Expires      time.Time `json:"expires"`
+	MaxDownloads int       `json:"max_downloads,omitempty"`
+}
+
+// notifyShare tells the users l was shared with.
+func notifyShare(r *http.Request, l ShortLink, to []string) {
+	from := l.CreatedBy
+	if from == "" {
+		from = "Someone"
+	}
+	publish(eventFileShared, to, shareNotice{from, displayName(l.Name), absoluteURL(r, "/s/"+l.Code), l.Expires, l.MaxDownloads})
+}
+
+// uploadNotice is the data of upload.complete.
+type uploadNotice struct {
+	File     string `json:"file"` // as the uploader named it
+	Name     string `json:"name"` // as stored
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Replicas int    `json:"replicas"`
+	Written  int    `json:"written"`
+}
+
+// displayName is a stored name as its owner sees it.
+func displayName(name string) string {
+	if _, file, ok := splitHomeName(name); ok {
+		return file
+	}
+	if _, file, ok := splitFolderName(name); ok {
+		return file
+	}
+	return name
+}
+
+// nodeDownNotice is the data of node.down.
+type nodeDownNotice struct {
+	Node  string `json:"node"`
+	URL   string `json:"url"`
+	Error string `json:"error"`
+}
+
+// quotaNotice is the data of quota.near_limit.
+type quotaNotice struct {
+	Node    string `json:"node"`
+	Percent int    `json:"percent"`
+	QuotaStatus
+}
+
+// quotaWatch remembers the nodes already reported near their quota, until
+// they drop below it again.
+var quotaWatch = struct {
+	mu     sync.Mutex
+	warned map[string]bool
+}{warned: map[string]bool{}}
+
+// observeQuota publishes quota.near_limit when a node's usage first
+// reaches quotaWarnPercent of its quota, in bytes or files.
+func observeQuota(nodeID string, q *QuotaStatus) {
+	percent := 0
+	if q != nil && q.MaxBytes > 0 {
+		percent = int(q.UsedBytes * 100 / q.MaxBytes)
+	}
+	if q != nil && q.MaxFiles > 0 {
+		percent = max(percent, int(q.UsedFiles*100/q.MaxFiles))
+	}
+	near := percent >= quotaWarnPercent
+	quotaWatch.mu.Lock()
+	was := quotaWatch.warned[nodeID]
+	if near {
+		quotaWatch.warned[nodeID] = true
+	} else {
+		delete(quotaWatch.warned, nodeID)
+	}
+	quotaWatch.mu.Unlock()
+	if near && !was {
+		fmt.Printf("Node %s is at %d%% of its quota\n", nodeID, percent)
+		publish(eventQuotaNearLimit, nil, quotaNotice{nodeID, percent, *q})
+	}
+}
+
+// ReplicaFailure is a replica that could not be written.
+type ReplicaFailure struct {
+	File  string    `json:"file"`
+	Node  string    `json:"node"`
+	Since time.Time `json:"since"` // first failure since it last succeeded
+	Error string    `json:"error"` // the latest
+}
+
+type replicaFailureKey struct{ file, node string }
+
+// replicaFailures tracks failing replicas until they are written, so the
+// ones that stay failing can be reported once.
+var replicaFailures = &failureTracker{failing: map[replicaFailureKey]*trackedFailure{}}
+
+type trackedFailure struct {
+	ReplicaFailure
+	alerted bool
+}
+
+type failureTracker struct {
+	mu      sync.Mutex
+	failing map[replicaFailureKey]*trackedFailure
+}
+
+// record notes the outcome of writing file's replica to node.
+func (t *failureTracker) record(file, node string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := replicaFailureKey{file, node}
+	if err == nil {
+		delete(t.failing, key)
+		return
+	}
+	f, ok := t.failing[key]
+	if !ok {
+		f = &trackedFailure{ReplicaFailure: ReplicaFailure{File: file, Node: node, Since: time.Now()}}
+		t.failing[key] = f
+	}
+	f.Error = err.Error()
+}
+
+// clearFile forgets file's failures, once it has its replicas elsewhere or
+// is gone.
+func (t *failureTracker) clearFile(file string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key := range t.failing {
+		if key.file == file {
+			delete(t.failing, key)
+		}
+	}
+}
+
+// due returns the failures older than after not reported yet, marking
+// them reported.
+func (t *failureTracker) due(now time.Time, after time.Duration) []ReplicaFailure {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var out []ReplicaFailure
+	for key, f := range t.failing {
+		if _, err := os.Stat(filepath.Join(uploadDir, key.file)); err != nil {
+			delete(t.failing, key) // deleted since
+			continue
+		}
+		if !f.alerted && now.Sub(f.Since) >= after {
+			f.alerted = true
+			out = append(out, f.ReplicaFailure)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Since.Before(out[j].Since) })
+	return out
+}
+
+// alertReplicaFailures publishes replication.failing for replicas failing
+// for longer than replicationAlertAfter.
+func alertReplicaFailures(now time.Time) {
+	failures := replicaFailures.due(now, replicationAlertAfter)
+	if len(failures) == 0 {
+		return
+	}
+	fmt.Println(len(failures), "replica(s) failing for over", replicationAlertAfter)
+	publish(eventReplicationFailing, nil, struct {
+		After    time.Duration    `json:"after"`
+		Failures []ReplicaFailure `json:"failures"`
+	}{replicationAlertAfter, failures})
+}
+
+// replicationAlertLoop checks for lasting failures every interval.
+func replicationAlertLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		alertReplicaFailures(now)
+	}
+}
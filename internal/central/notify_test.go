@@ -0,0 +1,202 @@
+package central
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type sentMail struct {
+	to, msg string
+}
+
+type stubMailer chan sentMail
+
+func (m stubMailer) send(to string, msg []byte) error {
+	m <- sentMail{to, string(msg)}
+	return nil
+}
+
+func (m stubMailer) next(t *testing.T) sentMail {
+	t.Helper()
+	select {
+	case s := <-m:
+		return s
+	case <-time.After(5 * time.Second):
+		t.Fatal("no email sent")
+		return sentMail{}
+	}
+}
+
+func TestNotifications(t *testing.T) {
+	hash, _ := hashPassword("pw")
+	c := newTestCluster(t, 1, func(cfg *Config) {
+		cfg.PublicURL = "https://files.example.com"
+		cfg.Users = []User{
+			{Name: "alice", PasswordHash: hash, Role: roleAdmin, Email: "alice@example.com"},
+			{Name: "bob", PasswordHash: hash, Email: "bob@example.com"},
+			{Name: "carol", PasswordHash: hash, Email: "carol@example.com"},
+		}
+	})
+	sent := make(stubMailer, 10)
+	mailer = sent
+	t.Cleanup(func() { mailer = nil })
+
+	do := func(user, method, path, body string, out any) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, c.central.URL+path, strings.NewReader(body))
+		req.SetBasicAuth(user, "pw")
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := testClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if out != nil {
+			json.NewDecoder(resp.Body).Decode(out)
+		}
+		return resp
+	}
+	upload := func(user, file string) {
+		t.Helper()
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		fw, _ := mw.CreateFormFile("file", file)
+		io.WriteString(fw, "numbers")
+		mw.Close()
+		req, _ := http.NewRequest("POST", c.central.URL+"/upload?"+nearLondon.Encode(), &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		req.SetBasicAuth(user, "pw")
+		if resp, err := testClient.Do(req); err != nil || resp.StatusCode != http.StatusSeeOther {
+			t.Fatalf("upload: %v %v", resp, err)
+		}
+		c.waitForJobs()
+	}
+
+	upload("alice", "report.pdf")
+	name := homeName("alice", "report.pdf")
+
+	// Shares are emailed by default.
+	if resp := do("alice", "POST", "/api/v1/links", `{"name": "`+name+`", "share_with": ["bob"]}`, nil); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("share: %d", resp.StatusCode)
+	}
+	m := sent.next(t)
+	if m.to != "bob@example.com" || !strings.Contains(m.msg, "Subject: alice shared report.pdf with you\r\n") ||
+		!strings.Contains(m.msg, "https://files.example.com/s/") {
+		t.Errorf("share email to %s:\n%s", m.to, m.msg)
+	}
+
+	// Unless the user unsubscribed.
+	var subs []Subscription
+	do("bob", "GET", "/api/v1/me/subscriptions", "", &subs)
+	for _, s := range subs {
+		if s.Event == eventFileShared {
+			if resp := do("bob", "DELETE", "/api/v1/me/subscriptions/"+s.ID, "", nil); resp.StatusCode != http.StatusNoContent {
+				t.Fatalf("unsubscribe: %d", resp.StatusCode)
+			}
+		}
+	}
+	do("alice", "POST", "/api/v1/links", `{"name": "`+name+`", "share_with": ["bob", "carol"]}`, nil)
+	if m := sent.next(t); m.to != "carol@example.com" {
+		t.Errorf("emailed %s", m.to)
+	}
+
+	for _, tc := range []struct {
+		body string
+		want int
+	}{
+		{`{"event": "node.down", "channel": "ui"}`, http.StatusForbidden}, // admins only
+		{`{"event": "upload.complete", "channel": "pager"}`, http.StatusBadRequest},
+		{`{"event": "upload.complete", "channel": "webhook", "url": "ftp://example.com"}`, http.StatusBadRequest},
+		{`{"event": "upload.complete", "channel": "webhook", "url": "http://127.0.0.1/hook"}`, http.StatusBadRequest},
+		{`{"event": "upload.complete", "channel": "webhook", "url": "http://[::1]:8000/hook"}`, http.StatusBadRequest},
+		{`{"event": "upload.complete", "channel": "webhook", "url": "http://10.0.0.7/hook"}`, http.StatusBadRequest},
+		{`{"event": "upload.complete", "channel": "webhook", "url": "http://169.254.169.254/latest/meta-data"}`, http.StatusBadRequest},
+		{`{"event": "nope", "channel": "ui"}`, http.StatusBadRequest},
+	} {
+		if resp := do("bob", "POST", "/api/v1/me/subscriptions", tc.body, nil); resp.StatusCode != tc.want {
+			t.Errorf("subscribe %s: %d, want %d", tc.body, resp.StatusCode, tc.want)
+		}
+	}
+	if resp := do("bob", "PUT", "/api/v1/me/notifications", `{"email": "Bob <bob@example.com>\r\nBcc: x@example.com"}`, nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("bad address: %d", resp.StatusCode)
+	}
+
+	// Upload completion, in the UI and to a webhook, here on loopback.
+	defer func() { webhooksReachInternal = false }()
+	webhooksReachInternal = true
+	hooks := make(chan webhookPayload, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p webhookPayload
+		json.NewDecoder(r.Body).Decode(&p)
+		hooks <- p
+	}))
+	defer hook.Close()
+	for _, body := range []string{
+		`{"event": "upload.complete", "channel": "ui"}`,
+		`{"event": "upload.complete", "channel": "webhook", "url": "` + hook.URL + `"}`,
+	} {
+		if resp := do("bob", "POST", "/api/v1/me/subscriptions", body, nil); resp.StatusCode != http.StatusCreated {
+			t.Fatalf("subscribe %s: %d", body, resp.StatusCode)
+		}
+	}
+	upload("bob", "notes.txt")
+	var inbox []Notification
+	do("bob", "GET", "/api/v1/me/inbox", "", &inbox)
+	if len(inbox) != 1 || inbox[0].Event != eventUploadComplete || !strings.Contains(inbox[0].Subject, "notes.txt") {
+		t.Errorf("inbox: %+v", inbox)
+	}
+	select {
+	case p := <-hooks:
+		if p.Event != eventUploadComplete || p.User != "bob" {
+			t.Errorf("webhook: %+v", p)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("no webhook")
+	}
+	if n := notifyPrefs.unread("bob"); n != 1 {
+		t.Errorf("%d unread", n)
+	}
+	do("bob", "POST", "/api/v1/me/inbox/read", "", nil)
+	if n := notifyPrefs.unread("bob"); n != 0 {
+		t.Errorf("%d unread after reading", n)
+	}
+
+	// Cluster events go to the admins who subscribed, once.
+	if resp := do("alice", "POST", "/api/v1/me/subscriptions", `{"event": "quota.near_limit", "channel": "ui"}`, nil); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("subscribe to quota: %d", resp.StatusCode)
+	}
+	observeQuota("sg", &QuotaStatus{MaxBytes: 100, UsedBytes: 95})
+	observeQuota("sg", &QuotaStatus{MaxBytes: 100, UsedBytes: 96})
+	if inbox := notifyPrefs.inbox("alice"); len(inbox) != 1 || inbox[0].Subject != "Node sg is at 95% of its quota" {
+		t.Errorf("alice's inbox: %+v", inbox)
+	}
+	if inbox := notifyPrefs.inbox("bob"); len(inbox) != 1 {
+		t.Errorf("bob got a cluster event: %+v", inbox)
+	}
+
+	replicaFailures.record(name, "sg", errors.New("status 500"))
+	alertReplicaFailures(time.Now())
+	alertReplicaFailures(time.Now().Add(time.Hour))
+	m = sent.next(t)
+	if m.to != "alice@example.com" || !strings.Contains(m.msg, name+" on sg") {
+		t.Errorf("replication email to %s:\n%s", m.to, m.msg)
+	}
+	alertReplicaFailures(time.Now().Add(2 * time.Hour))
+	select {
+	case m := <-sent:
+		t.Errorf("reported twice: %s", m.msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+	replicaFailures.record(name, "sg", nil)
+	if due := replicaFailures.due(time.Now().Add(time.Hour), 0); len(due) != 0 {
+		t.Errorf("still failing after it worked: %v", due)
+	}
+}
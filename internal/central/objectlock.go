@@ -0,0 +1,302 @@
+package central
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+)
+
+// ---------------------------
+// Object Lock
+// ---------------------------
+
+// A file can be locked (write once, read many): under a retention date,
+// until which it cannot be deleted or overwritten, or under a legal hold,
+// until an admin lifts it. Retention only ever moves later, even for an
+// admin. Those who may delete a file may set its retention; only admins
+// may place or lift a hold (anyone, without sign-in). Files uploaded to a
+// bucket with a retention (BucketConfig.Retention) are locked for that
+// long from the start.
+//
+// The central API refuses to delete or replace a locked file on every
+// path, and the nodes get the list of locked files as they get the
+// quarantine list, refusing to delete or replace those replicas too. The
+// list is of the files locked now: the next probe after a retention date
+// passes sends the nodes the shorter one.
+
+// ObjectLock is one file's lock.
+type ObjectLock struct {
+	Name        string    `json:"name"`
+	RetainUntil time.Time `json:"retain_until,omitzero"`
+	LegalHold   bool      `json:"legal_hold"`
+	UpdatedBy   string    `json:"updated_by,omitempty"`
+	Updated     time.Time `json:"updated"`
+}
+
+// active reports whether the lock holds at now.
+func (l ObjectLock) active(now time.Time) bool {
+	return l.LegalHold || now.Before(l.RetainUntil)
+}
+
+// reason says what keeps the file locked.
+func (l ObjectLock) reason() string {
+	if l.LegalHold {
+		return "under legal hold"
+	}
+	return "locked until " + l.RetainUntil.Format(time.RFC3339)
+}
+
+type lockRegistry struct {
+	mu    sync.Mutex
+	path  string
+	locks map[string]ObjectLock
+}
+
+var objectLocks = &lockRegistry{locks: map[string]ObjectLock{}}
+
+// bucketRetention is how long uploads to each bucket are locked for.
+var bucketRetention = map[string]time.Duration{}
+
+func (reg *lockRegistry) load(path string) error {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	reg.path = path
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, &reg.locks)
+}
+
+func (reg *lockRegistry) saveLocked() error {
+	if reg.path == "" {
+		return nil
+	}
+	raw, err := json.MarshalIndent(reg.locks, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(reg.path), 0755); err != nil {
+		return err
+	}
+	tmp := reg.path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, reg.path)
+}
+
+func (reg *lockRegistry) get(name string) (ObjectLock, bool) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	l, ok := reg.locks[name]
+	return l, ok
+}
+
+func (reg *lockRegistry) set(l ObjectLock) error {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.locks[l.Name] = l
+	return reg.saveLocked()
+}
+
+// remove drops name's lock, which must no longer hold.
+func (reg *lockRegistry) remove(name string) error {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if _, ok := reg.locks[name]; !ok {
+		return nil
+	}
+	delete(reg.locks, name)
+	return reg.saveLocked()
+}
+
+// relabel puts to for from as the last to change every lock, and returns
+// how many it changed.
+func (reg *lockRegistry) relabel(from, to string) (int, error) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	n := 0
+	for name, l := range reg.locks {
+		if l.UpdatedBy == from {
+			l.UpdatedBy = to
+			reg.locks[name] = l
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	return n, reg.saveLocked()
+}
+
+// locked returns name's lock if it holds now.
+func (reg *lockRegistry) locked(name string) (ObjectLock, bool) {
+	l, ok := reg.get(name)
+	return l, ok && l.active(time.Now())
+}
+
+// names returns the files locked at now, sorted: the list the nodes get.
+func (reg *lockRegistry) names(now time.Time) []string {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	var names []string
+	for name, l := range reg.locks {
+		if l.active(now) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// errLocked is what modifying a locked file fails with.
+func errLocked(name string) error {
+	if l, ok := objectLocks.locked(name); ok {
+		return fmt.Errorf("File is %s", l.reason())
+	}
+	return nil
+}
+
+// refuseLocked answers a request to delete or replace a locked file, and
+// reports whether it did.
+func refuseLocked(w http.ResponseWriter, name string) bool {
+	err := errLocked(name)
+	if err == nil {
+		return false
+	}
+	apierr.Send(w, err.Error(), http.StatusLocked)
+	return true
+}
+
+// locksDrifted reports whether a node's list of locked files, by the
+// digest in its /info, differs from the cluster's.
+func locksDrifted(digest string) bool {
+	return digest != quarantineDigest(objectLocks.names(time.Now()))
+}
+
+// syncLocks sends the list of locked files to every node and returns the
+// errors by node ID.
+func syncLocks(ctx context.Context) map[string]string {
+	names := objectLocks.names(time.Now())
+	var mu sync.Mutex
+	var errs map[string]string
+	var wg sync.WaitGroup
+	for _, s := range topo.nodes() {
+		wg.Add(1)
+		go func(s StorageServer) {
+			defer wg.Done()
+			if err := pushNameList(ctx, s, "/api/v1/locks", names); err != nil {
+				mu.Lock()
+				if errs == nil {
+					errs = map[string]string{}
+				}
+				errs[s.ID] = err.Error()
+				mu.Unlock()
+			}
+		}(s)
+	}
+	wg.Wait()
+	return errs
+}
+
+// lockForBucket locks a file just uploaded to bucket for the bucket's
+// retention, if it has one.
+func lockForBucket(ctx context.Context, name, bucket string) {
+	d := bucketRetention[bucket]
+	if d <= 0 {
+		return
+	}
+	now := time.Now().UTC()
+	l, _ := objectLocks.get(name)
+	if until := now.Add(d); until.After(l.RetainUntil) {
+		l.RetainUntil = until
+	}
+	l.Name, l.UpdatedBy, l.Updated = name, "bucket "+bucket, now
+	if err := objectLocks.set(l); err != nil {
+		fmt.Println("Cannot save object locks:", err)
+	}
+	if errs := syncLocks(ctx); len(errs) > 0 {
+		fmt.Println("Cannot send locked file list:", errs)
+	}
+}
+
+// lockHandler shows or changes a file's lock: GET or PUT
+// /api/v1/files/{name}/lock, the PUT with {"retain_until": ...,
+// "legal_hold": ...}, either left out to keep it as it is.
+func lockHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if r.Method == http.MethodGet {
+		l, ok := objectLocks.get(name)
+		if !ok {
+			l = ObjectLock{Name: name}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(l)
+		return
+	}
+
+	var req struct {
+		RetainUntil *time.Time `json:"retain_until"`
+		LegalHold   *bool      `json:"legal_hold"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<10)).Decode(&req); err != nil {
+		apierr.Send(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if fi, err := os.Stat(filepath.Join(uploadDir, name)); err != nil || fi.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	id := signedIn(r)
+	if !canDelete(id, name) {
+		apierr.Send(w, "You may not lock this file", http.StatusForbidden)
+		return
+	}
+
+	now := time.Now().UTC()
+	l, _ := objectLocks.get(name)
+	if req.LegalHold != nil && *req.LegalHold != l.LegalHold {
+		if loginRequired() && roleRank[id.Role] < roleRank[roleAdmin] {
+			apierr.Send(w, "Only admins may place or lift a legal hold", http.StatusForbidden)
+			return
+		}
+		l.LegalHold = *req.LegalHold
+	}
+	if req.RetainUntil != nil {
+		switch {
+		case !req.RetainUntil.After(now):
+			apierr.Send(w, "retain_until must be in the future", http.StatusBadRequest)
+			return
+		case req.RetainUntil.Before(l.RetainUntil):
+			apierr.Send(w, "Retention can only be extended, not shortened", http.StatusConflict)
+			return
+		}
+		l.RetainUntil = req.RetainUntil.UTC()
+	}
+	l.Name, l.UpdatedBy, l.Updated = name, id.Name, now
+	if err := objectLocks.set(l); err != nil {
+		apierr.Send(w, "Cannot save the lock: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Printf("Lock on %s: retain until %s, legal hold %v\n", name, l.RetainUntil.Format(time.RFC3339), l.LegalHold)
+	errs := syncLocks(r.Context())
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		ObjectLock
+		PushErrors map[string]string `json:"push_errors,omitempty"` // by node ID
+	}{l, errs})
+}
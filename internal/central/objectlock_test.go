@@ -0,0 +1,90 @@
+package central
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestObjectLock(t *testing.T) {
+	c := newTestCluster(t, 2, func(cfg *Config) {
+		cfg.Buckets = map[string]BucketConfig{"records": {Replication: "sync", Retention: Duration{time.Hour}}}
+	})
+	c.upload("a.txt", "signed contract", nearLondon)
+	c.waitForJobs()
+
+	lock := func(body string) int {
+		t.Helper()
+		req, _ := http.NewRequest("PUT", c.central.URL+"/api/v1/files/a.txt/lock", strings.NewReader(body))
+		resp, err := testClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	until := time.Now().Add(time.Hour).UTC()
+	for _, tc := range []struct {
+		body string
+		want int
+	}{
+		{`{"retain_until": "` + until.Format(time.RFC3339) + `"}`, http.StatusOK},
+		{`{"retain_until": "` + until.Add(-time.Minute).Format(time.RFC3339) + `"}`, http.StatusConflict},
+		{`{"retain_until": "2001-01-01T00:00:00Z"}`, http.StatusBadRequest},
+		{`{"legal_hold": true}`, http.StatusOK},
+	} {
+		if got := lock(tc.body); got != tc.want {
+			t.Errorf("lock %s: %d, want %d", tc.body, got, tc.want)
+		}
+	}
+
+	// No way to delete or replace it, through the central API or a node.
+	if resp := c.delete("a.txt"); resp.StatusCode != http.StatusLocked {
+		t.Errorf("delete: %d", resp.StatusCode)
+	}
+	if resp, _ := c.upload("a.txt", "forged contract", nearLondon); resp.StatusCode != http.StatusLocked {
+		t.Errorf("overwrite: %d", resp.StatusCode)
+	}
+	if got := c.holders("a.txt"); len(got) != 2 {
+		t.Errorf("held by %v", got)
+	}
+	for _, n := range c.nodes {
+		req, _ := http.NewRequest("GET", n.URL+"/delete?filename=a.txt", nil)
+		req.Header.Set("Authorization", "Bearer "+testRegistrationToken)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusLocked {
+			t.Errorf("delete on %s: %d", n.ID, resp.StatusCode)
+		}
+	}
+	if _, body := c.get("/files/a.txt", nil); body != "signed contract" {
+		t.Errorf("a.txt = %q", body)
+	}
+
+	// The hold outlasts the retention until it is lifted.
+	later := time.Now().Add(2 * time.Hour)
+	if got := objectLocks.names(later); len(got) != 1 {
+		t.Errorf("locked after the retention, under hold: %v", got)
+	}
+	lock(`{"legal_hold": false}`)
+	if got := objectLocks.names(later); len(got) != 0 {
+		t.Errorf("locked after the retention, hold lifted: %v", got)
+	}
+
+	// A bucket's retention locks its uploads.
+	q := url.Values{"bucket": {"records"}}
+	for k, v := range nearLondon {
+		q[k] = v
+	}
+	if resp, _ := c.upload("b.txt", "ledger", q); resp.StatusCode != http.StatusOK {
+		t.Fatalf("upload to records: %d", resp.StatusCode)
+	}
+	if l, ok := objectLocks.locked("b.txt"); !ok || l.UpdatedBy != "bucket records" {
+		t.Errorf("b.txt lock = %+v %v", l, ok)
+	}
+}
@@ -0,0 +1,490 @@
+package central
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+)
+
+// ---------------------------
+// OpenID Connect Login
+// ---------------------------
+
+// Users can sign in with an OpenID Connect provider (Google, Keycloak,
+// Okta, ...) instead of a password: /login/oidc sends the browser to the
+// provider with the authorization code flow and PKCE, and
+// /login/oidc/callback trades the code for an ID token, checks it, and
+// starts a session. The provider's endpoints and signing keys come from its
+// discovery document; ID tokens must be signed with RS256, which all of
+// the above use by default.
+//
+// The user is named by one claim (UserClaim, the email by default). A
+// configured user of that name keeps their configured role; anyone else
+// gets the highest role their RolesClaim values map to in Roles, or
+// DefaultRole, or is turned away.
+
+// OIDCConfig configures the identity provider; it is off without Issuer.
+type OIDCConfig struct {
+	Name         string            `json:"name"`   // on the login button; default "single sign-on"
+	Issuer       string            `json:"issuer"` // e.g. https://accounts.google.com
+	ClientID     string            `json:"client_id"`
+	ClientSecret string            `json:"client_secret"`
+	RedirectURL  string            `json:"redirect_url"` // https://<this site>/login/oidc/callback, as registered
+	Scopes       []string          `json:"scopes"`       // default openid, email, profile
+	UserClaim    string            `json:"user_claim"`   // default "email"
+	RolesClaim   string            `json:"roles_claim"`  // default "groups"
+	Roles        map[string]string `json:"roles"`        // RolesClaim value -> role
+	DefaultRole  string            `json:"default_role"` // "" = refuse users no role maps to
+}
+
+func (c OIDCConfig) validate() []error {
+	if c.Issuer == "" {
+		return nil
+	}
+	var errs []error
+	for field, value := range map[string]string{"issuer": c.Issuer, "redirect_url": c.RedirectURL} {
+		if u, err := url.Parse(value); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("oidc.%s %q must be an absolute http(s) URL", field, value))
+		}
+	}
+	if c.ClientID == "" {
+		errs = append(errs, fmt.Errorf("oidc.client_id is required with oidc.issuer"))
+	}
+	for value, role := range c.Roles {
+		if roleRank[role] == 0 {
+			errs = append(errs, fmt.Errorf("oidc.roles[%q]: unknown role %q (want viewer, editor or admin)", value, role))
+		}
+	}
+	if c.DefaultRole != "" && roleRank[c.DefaultRole] == 0 {
+		errs = append(errs, fmt.Errorf("oidc.default_role: unknown role %q (want viewer, editor or admin)", c.DefaultRole))
+	}
+	return errs
+}
+
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcPending is a sign-in sent to the provider and not back yet.
+type oidcPending struct {
+	nonce, verifier, next string
+	expires               time.Time
+}
+
+type oidcProvider struct {
+	cfg OIDCConfig
+
+	mu          sync.Mutex
+	meta        *oidcMetadata // fetched on first use
+	keys        map[string]*rsa.PublicKey
+	keysFetched time.Time
+	pending     map[string]oidcPending // by state
+}
+
+// oidc is the identity provider, nil when none is configured.
+var oidc *oidcProvider
+
+var oidcClient = &http.Client{Timeout: 10 * time.Second}
+
+const (
+	oidcStateCookie = "oidc_state"
+	oidcPendingTTL  = 10 * time.Minute
+	oidcMaxPending  = 10000
+)
+
+func newOIDCProvider(cfg OIDCConfig) *oidcProvider {
+	if cfg.Issuer == "" {
+		return nil
+	}
+	cfg.Issuer = strings.TrimSuffix(cfg.Issuer, "/")
+	if cfg.Name == "" {
+		cfg.Name = "single sign-on"
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	} else if !slices.Contains(cfg.Scopes, "openid") {
+		cfg.Scopes = append([]string{"openid"}, cfg.Scopes...)
+	}
+	if cfg.UserClaim == "" {
+		cfg.UserClaim = "email"
+	}
+	if cfg.RolesClaim == "" {
+		cfg.RolesClaim = "groups"
+	}
+	return &oidcProvider{cfg: cfg, pending: map[string]oidcPending{}}
+}
+
+func (p *oidcProvider) getJSON(ctx context.Context, u string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := oidcClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", u, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// metadata returns the provider's discovery document.
+func (p *oidcProvider) metadata(ctx context.Context) (*oidcMetadata, error) {
+	p.mu.Lock()
+	meta := p.meta
+	p.mu.Unlock()
+	if meta != nil {
+		return meta, nil
+	}
+	meta = &oidcMetadata{}
+	if err := p.getJSON(ctx, p.cfg.Issuer+"/.well-known/openid-configuration", meta); err != nil {
+		return nil, fmt.Errorf("discovery: %w", err)
+	}
+	if strings.TrimSuffix(meta.Issuer, "/") != p.cfg.Issuer {
+		return nil, fmt.Errorf("discovery: issuer is %q, not %q", meta.Issuer, p.cfg.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, errors.New("discovery: endpoints missing")
+	}
+	p.mu.Lock()
+	p.meta = meta
+	p.mu.Unlock()
+	return meta, nil
+}
+
+// key returns the provider's signing key kid, fetching the key set again
+// (at most once a minute) when it does not know it: providers rotate keys.
+func (p *oidcProvider) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	k, ok := p.keys[kid]
+	stale := time.Since(p.keysFetched) > time.Minute
+	p.mu.Unlock()
+	if ok {
+		return k, nil
+	}
+	if !stale {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	meta, err := p.metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := p.getJSON(ctx, meta.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("signing keys: %w", err)
+	}
+	keys := map[string]*rsa.PublicKey{}
+	for _, jk := range set.Keys {
+		if jk.Kty != "RSA" || (jk.Use != "" && jk.Use != "sig") {
+			continue
+		}
+		n, err1 := base64.RawURLEncoding.DecodeString(jk.N)
+		e, err2 := base64.RawURLEncoding.DecodeString(jk.E)
+		if err1 != nil || err2 != nil || len(e) > 4 {
+			continue
+		}
+		keys[jk.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	p.mu.Lock()
+	p.keys = keys
+	p.keysFetched = time.Now()
+	p.mu.Unlock()
+	if k, ok := keys[kid]; ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// verify checks an ID token's signature and claims, and returns the
+// claims.
+func (p *oidcProvider) verify(ctx context.Context, token, nonce string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("ID token is not a JWT")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(raw, &header) != nil {
+		return nil, errors.New("ID token: bad header")
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("ID token signed with %q, want RS256", header.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("ID token: bad signature encoding")
+	}
+	key, err := p.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+		return nil, errors.New("ID token: bad signature")
+	}
+
+	var claims map[string]any
+	raw, err = base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || json.Unmarshal(raw, &claims) != nil {
+		return nil, errors.New("ID token: bad claims")
+	}
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != p.cfg.Issuer {
+		return nil, fmt.Errorf("ID token from issuer %q", iss)
+	}
+	if !slices.Contains(claimStrings(claims["aud"]), p.cfg.ClientID) {
+		return nil, errors.New("ID token is for another client")
+	}
+	const skew = time.Minute
+	exp, _ := claims["exp"].(float64)
+	if time.Now().Add(-skew).After(time.Unix(int64(exp), 0)) {
+		return nil, errors.New("ID token has expired")
+	}
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return nil, errors.New("ID token nonce does not match")
+	}
+	return claims, nil
+}
+
+// claimStrings reads a claim that is a string or a list of strings.
+func claimStrings(v any) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		var out []string
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// identify maps the claims to a user and role.
+func (p *oidcProvider) identify(claims map[string]any) (identity, error) {
+	name, _ := claims[p.cfg.UserClaim].(string)
+	if name == "" {
+		return identity{}, fmt.Errorf("the provider did not send a %q claim", p.cfg.UserClaim)
+	}
+	if p.cfg.UserClaim == "email" {
+		if verified, ok := claims["email_verified"].(bool); ok && !verified {
+			return identity{}, fmt.Errorf("%s is not verified with the provider", name)
+		}
+	}
+	if u, ok := users[name]; ok {
+		return identity{Name: name, Role: u.role()}, nil
+	}
+	role := p.cfg.DefaultRole
+	for _, v := range claimStrings(claims[p.cfg.RolesClaim]) {
+		if r := p.cfg.Roles[v]; roleRank[r] > roleRank[role] {
+			role = r
+		}
+	}
+	if role == "" {
+		return identity{}, fmt.Errorf("%s has no access here", name)
+	}
+	return identity{Name: name, Role: role}, nil
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// oidcLoginHandler sends the browser to the provider: GET /login/oidc.
+func oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+	p := oidc
+	if p == nil {
+		http.NotFound(w, r)
+		return
+	}
+	meta, err := p.metadata(r.Context())
+	if err != nil {
+		fmt.Println("OIDC error:", err)
+		apierr.Send(w, "The identity provider cannot be reached", http.StatusBadGateway)
+		return
+	}
+
+	state, verifier := randomString(24), randomString(32)
+	pend := oidcPending{nonce: randomString(24), verifier: verifier, next: localRedirect(r.FormValue("next")), expires: time.Now().Add(oidcPendingTTL)}
+	p.mu.Lock()
+	now := time.Now()
+	for s, old := range p.pending {
+		if now.After(old.expires) {
+			delete(p.pending, s)
+		}
+	}
+	full := len(p.pending) >= oidcMaxPending
+	if !full {
+		p.pending[state] = pend
+	}
+	p.mu.Unlock()
+	if full {
+		apierr.Send(w, "Too many sign-ins in progress; try again shortly", http.StatusServiceUnavailable)
+		return
+	}
+
+	// The callback is a top-level navigation from the provider's site,
+	// which SameSite=Lax cookies are sent with.
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/login/oidc",
+		MaxAge:   int(oidcPendingTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   secureCookies || r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	challenge := sha256.Sum256([]byte(verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {p.cfg.RedirectURL},
+		"scope":                 {strings.Join(p.cfg.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {pend.nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	http.Redirect(w, r, meta.AuthorizationEndpoint+sep+q.Encode(), http.StatusFound)
+}
+
+// exchange trades an authorization code for an ID token.
+func (p *oidcProvider) exchange(ctx context.Context, code, verifier string) (string, error) {
+	meta, err := p.metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"code_verifier": {verifier},
+		"client_id":     {p.cfg.ClientID},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+	resp, err := oidcClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var out struct {
+		IDToken     string `json:"id_token"`
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", fmt.Errorf("token endpoint: %s: %w", resp.Status, err)
+	}
+	if out.Error != "" {
+		return "", fmt.Errorf("token endpoint: %s %s", out.Error, out.Description)
+	}
+	if out.IDToken == "" {
+		return "", errors.New("token endpoint sent no ID token")
+	}
+	return out.IDToken, nil
+}
+
+// oidcCallbackHandler finishes a sign-in the provider sends back:
+// GET /login/oidc/callback.
+func oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	p := oidc
+	if p == nil {
+		http.NotFound(w, r)
+		return
+	}
+	q := r.URL.Query()
+	state := q.Get("state")
+	c, err := r.Cookie(oidcStateCookie)
+	if state == "" || err != nil || c.Value != state {
+		apierr.Send(w, "Sign-in expired or started in another browser; try again", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/login/oidc", MaxAge: -1})
+	p.mu.Lock()
+	pend, ok := p.pending[state]
+	delete(p.pending, state)
+	p.mu.Unlock()
+	if !ok || time.Now().After(pend.expires) {
+		apierr.Send(w, "Sign-in expired; try again", http.StatusBadRequest)
+		return
+	}
+	if e := q.Get("error"); e != "" {
+		fmt.Println("OIDC sign-in refused by the provider:", e, q.Get("error_description"))
+		apierr.Send(w, "The identity provider refused the sign-in: "+e, http.StatusForbidden)
+		return
+	}
+
+	token, err := p.exchange(r.Context(), q.Get("code"), pend.verifier)
+	if err != nil {
+		fmt.Println("OIDC error:", err)
+		apierr.Send(w, "Sign-in failed at the identity provider", http.StatusBadGateway)
+		return
+	}
+	claims, err := p.verify(r.Context(), token, pend.nonce)
+	if err != nil {
+		fmt.Println("OIDC error:", err)
+		apierr.Send(w, "Sign-in failed: the identity provider's token was not valid", http.StatusBadGateway)
+		return
+	}
+	id, err := p.identify(claims)
+	if err != nil {
+		fmt.Println("OIDC sign-in refused:", err)
+		apierr.Send(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err := startSession(w, r, session{User: id.Name, Role: id.Role, Provider: "oidc"}); err != nil {
+		fmt.Println("Session store error:", err)
+		apierr.Send(w, "Could not start a session", http.StatusInternalServerError)
+		return
+	}
+	fmt.Println("User", id.Name, "signed in as", id.Role, "with", p.cfg.Name, "from", getClientIP(r))
+	http.Redirect(w, r, pend.next, http.StatusSeeOther)
+}
@@ -0,0 +1,170 @@
+package central
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeProvider is an OpenID Connect provider that signs in whoever the
+// test says, with the claims it says.
+type fakeProvider struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	claims map[string]any            // for the next sign-in
+	codes  map[string]map[string]any // issued codes: claims plus nonce
+	challs map[string]string         // code -> PKCE challenge
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &fakeProvider{key: key, codes: map[string]map[string]any{}, challs: map[string]string{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcMetadata{
+			Issuer:                p.URL,
+			AuthorizationEndpoint: p.URL + "/authorize",
+			TokenEndpoint:         p.URL + "/token",
+			JWKSURI:               p.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		b64 := base64.RawURLEncoding.EncodeToString
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "k1", "use": "sig",
+			"n": b64(key.N.Bytes()), "e": b64(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		claims := map[string]any{"nonce": q.Get("nonce")}
+		for k, v := range p.claims {
+			claims[k] = v
+		}
+		code := randomString(8)
+		p.codes[code] = claims
+		p.challs[code] = q.Get("code_challenge")
+		http.Redirect(w, r, q.Get("redirect_uri")+"?"+url.Values{"code": {code}, "state": {q.Get("state")}}.Encode(), http.StatusFound)
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		code := r.PostFormValue("code")
+		sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if id, secret, _ := r.BasicAuth(); id != "dsfs" || secret != "shh" || p.challs[code] != base64.RawURLEncoding.EncodeToString(sum[:]) {
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": p.sign(p.codes[code])})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+func (p *fakeProvider) sign(extra map[string]any) string {
+	claims := map[string]any{"iss": p.URL, "aud": "dsfs", "exp": time.Now().Add(time.Hour).Unix()}
+	for k, v := range extra {
+		claims[k] = v
+	}
+	enc := func(v any) string {
+		b, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signed := enc(map[string]string{"alg": "RS256", "kid": "k1"}) + "." + enc(claims)
+	digest := sha256.Sum256([]byte(signed))
+	sig, _ := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestClusterOIDCLogin(t *testing.T) {
+	idp := newFakeProvider(t)
+	c := newTestCluster(t, 1, func(cfg *Config) {
+		cfg.Users = []User{{Name: "boss@example.com", Role: "editor"}}
+		cfg.OIDC = OIDCConfig{
+			Name: "Example", Issuer: idp.URL, ClientID: "dsfs", ClientSecret: "shh",
+			RedirectURL: "http://dsfs.test/login/oidc/callback",
+			Roles:       map[string]string{"staff": "viewer", "uploaders": "editor"},
+		}
+	})
+
+	// signIn follows the redirects through the provider by hand, since the
+	// callback URL is the one registered, not the test server's.
+	signIn := func(claims map[string]any) (*http.Response, string) {
+		t.Helper()
+		idp.claims = claims
+		resp, _ := c.get("/login/oidc?next=/files", nil)
+		if resp.StatusCode != http.StatusFound {
+			t.Fatalf("/login/oidc: %d", resp.StatusCode)
+		}
+		state := resp.Cookies()[0]
+		resp, err := testClient.Get(resp.Header.Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		cb, _ := url.Parse(resp.Header.Get("Location"))
+		return c.get("/login/oidc/callback?"+cb.RawQuery, http.Header{"Cookie": {state.String()}})
+	}
+	session := func(resp *http.Response) string {
+		for _, ck := range resp.Cookies() {
+			if ck.Name == sessionCookie {
+				return ck.Value
+			}
+		}
+		t.Fatalf("no session cookie: %d", resp.StatusCode)
+		return ""
+	}
+
+	if resp, body := c.get("/login", nil); !strings.Contains(body, "Sign in with Example") || strings.Contains(body, `name="password"`) {
+		t.Errorf("login page: %d %s", resp.StatusCode, body)
+	}
+
+	// A staff member may look but not upload.
+	resp, _ := signIn(map[string]any{"email": "ann@example.com", "email_verified": true, "groups": []string{"staff"}})
+	if resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != "/files" {
+		t.Fatalf("callback: %d to %s", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	viewer := http.Header{"Cookie": {sessionCookie + "=" + session(resp)}, "Accept": {"application/json"}}
+	if resp, _ := c.get("/files", viewer); resp.StatusCode != http.StatusOK {
+		t.Errorf("viewer listing: %d", resp.StatusCode)
+	}
+	req, _ := http.NewRequest("POST", c.central.URL+"/delete?filename=x", nil)
+	req.Header = viewer
+	if resp, err := testClient.Do(req); err != nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("viewer delete: %v %v", resp, err)
+	}
+
+	// A configured user keeps their role whatever the groups say.
+	resp, _ = signIn(map[string]any{"email": "boss@example.com", "groups": []string{"staff"}})
+	if sess, ok := sessions.get(sessionKey(session(resp))); !ok || sess.Role != roleEditor {
+		t.Errorf("boss session %+v", sess)
+	}
+
+	for _, refused := range []map[string]any{
+		{"email": "eve@example.com", "groups": []string{"contractors"}},
+		{"email": "mallory@example.com", "email_verified": false, "groups": []string{"uploaders"}},
+		{"email": "ann@example.com", "groups": []string{"staff"}, "aud": "someone-else"},
+		{"email": "ann@example.com", "groups": []string{"staff"}, "exp": time.Now().Add(-time.Hour).Unix()},
+	} {
+		if resp, _ := signIn(refused); resp.StatusCode == http.StatusSeeOther {
+			t.Errorf("signed in with %v", refused)
+		}
+	}
+
+	// The callback only finishes a sign-in this browser started.
+	if resp, _ := c.get("/login/oidc/callback?code=x&state=forged", nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("forged callback: %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,553 @@
+package central
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/buildinfo"
+)
+
+// ---------------------------
+// OpenAPI
+// ---------------------------
+
+// GET /api/v1/openapi.json describes the JSON endpoints as an OpenAPI 3
+// document, for generating clients. It is built from apiOperations: path
+// parameters come from the route, and schemas from the Go types the
+// handlers encode and decode, so renaming a field changes the document
+// with it. TestOpenAPICoversRoutes fails when a route in routes() has no
+// operation here.
+
+// apiOperation documents one route.
+type apiOperation struct {
+	Route    string // the mux pattern, e.g. "GET /api/v1/files/{name}"
+	Summary  string
+	Tag      string
+	Access   string     // "" for anyone, else accessLogin, accessAdmin or accessNode
+	Query    []apiParam // query (or form) parameters
+	Body     any        // a value of the JSON request body's type
+	Status   int        // of success, 200 if not set
+	Response any        // a value of the JSON response's type; nil for none
+	Produces string     // the content type of a response that is not JSON
+}
+
+// apiParam is a query parameter; Type is a JSON schema type.
+type apiParam struct {
+	Name, Type, Description string
+}
+
+const (
+	accessLogin = "login" // a session, when sign-in is required
+	accessAdmin = "admin" // the admin token
+	accessNode  = "node"  // the node registration token
+)
+
+// Parameters several operations share.
+var (
+	locationParams = []apiParam{
+		{"lat", "number", "client latitude; the client's IP is located otherwise"},
+		{"lon", "number", "client longitude"},
+	}
+	readParam = apiParam{"read", "string", "read preference: nearest, primary, any or region=<name>"}
+)
+
+var apiOperations = []apiOperation{
+	{Route: "GET /version", Summary: "Build of the central API and every node", Tag: "cluster",
+		Response: struct {
+			buildinfo.Info
+			Nodes map[string]buildinfo.Info `json:"nodes"`
+		}{}},
+	{Route: "GET /api/v1/openapi.json", Summary: "This document", Tag: "cluster", Response: map[string]any{}},
+
+	// Files
+	{Route: "POST /upload", Summary: "Upload files (multipart, field \"file\"); with Accept: application/json, answers with the upload's progress, or a list of them for several files", Tag: "files", Access: accessLogin,
+		Query: append([]apiParam{
+			{"bucket", "string", "bucket to place the file by"},
+			{"folder", "string", "team folder to upload into"},
+			{"upload_id", "string", "ID to follow the upload under (also X-Upload-ID)"},
+			{"w", "string", "write concern: 1, quorum or all"},
+			{"stripe", "string", "1 to stripe the file across nodes"},
+		}, locationParams...),
+		Response: uploadJob{}},
+	{Route: "GET /files", Summary: "List files (with Accept: application/json)", Tag: "files", Access: accessLogin,
+		Query:    []apiParam{{"since", "integer", "only changes after this sequence number"}},
+		Response: []FileListing{}},
+	{Route: "GET /api/v1/files/{name}", Summary: "Where a file is stored", Tag: "files", Response: FileStat{}},
+	{Route: "GET /api/v1/files/{name}/verify", Summary: "Check every replica of a file", Tag: "files", Response: VerifyResult{}},
+	{Route: "GET /api/v1/files/{name}/manifest", Summary: "Parts of a composite file", Tag: "files", Response: Manifest{}},
+	{Route: "POST /api/v1/files/{name}/report", Summary: "Report a file for abuse", Tag: "files",
+		Query:  []apiParam{{"reason", "string", "why, at most 500 characters"}},
+		Status: http.StatusAccepted,
+		Response: struct {
+			Reports     int  `json:"reports,omitempty"`
+			Quarantined bool `json:"quarantined"`
+		}{}},
+	{Route: "POST /api/v1/files/{name}/visibility", Summary: "Make a file public or private", Tag: "files", Access: accessLogin,
+		Body: struct {
+			Visibility string `json:"visibility"`
+		}{},
+		Response: struct {
+			Name       string            `json:"name"`
+			Visibility string            `json:"visibility"`
+			PushErrors map[string]string `json:"push_errors,omitempty"`
+		}{}},
+	{Route: "GET /api/v1/files/{name}/lock", Summary: "Retention and legal hold of a file", Tag: "files", Access: accessLogin, Response: ObjectLock{}},
+	{Route: "PUT /api/v1/files/{name}/lock", Summary: "Extend retention or set a legal hold", Tag: "files", Access: accessLogin,
+		Body: struct {
+			RetainUntil *time.Time `json:"retain_until"`
+			LegalHold   *bool      `json:"legal_hold"`
+		}{},
+		Response: struct {
+			ObjectLock
+			PushErrors map[string]string `json:"push_errors,omitempty"`
+		}{}},
+	{Route: "GET /api/v1/archive", Summary: "Download files as one zip", Tag: "files",
+		Query:    append([]apiParam{{"files", "string", "comma-separated file names"}, readParam}, locationParams...),
+		Produces: "application/zip"},
+	{Route: "GET /api/v1/export", Summary: "Export files and their metadata as a tar.gz", Tag: "files",
+		Query:    []apiParam{{"prefix", "string", "only files whose names start with this"}},
+		Produces: "application/gzip"},
+	{Route: "GET /api/v1/hls/{video}", Summary: "Transcoding status of a video", Tag: "files", Response: HLSStatus{}},
+	{Route: "GET /api/v1/changes", Summary: "Feed of file changes", Tag: "files",
+		Query: []apiParam{
+			{"from", "integer", "first event ID to return"},
+			{"limit", "integer", "at most this many events, up to 10000"},
+			{"wait", "string", "wait up to this long (at most 1m) for an event"},
+		},
+		Response: struct {
+			Events []ChangeEvent `json:"events"`
+			Next   int64         `json:"next"`
+			LastID int64         `json:"last_id"`
+		}{}},
+
+	// Uploads and jobs
+	{Route: "GET /api/v1/uploads/{id}/progress", Summary: "Progress of an upload", Tag: "uploads", Response: uploadJob{}},
+	{Route: "POST /api/v1/uploads/direct", Summary: "Sign a URL to upload straight to a node", Tag: "uploads", Access: accessLogin,
+		Query: append([]apiParam{
+			{"name", "string", "file name"},
+			{"folder", "string", "team folder to upload into"},
+			{"bucket", "string", "bucket to place the file by"},
+			{"node", "string", "node to upload to"},
+		}, locationParams...),
+		Response: DirectUpload{}},
+	{Route: "POST /api/v1/uploads/direct/complete", Summary: "A node reports a direct upload", Tag: "uploads", Access: accessNode,
+		Body: struct {
+			ID       string `json:"id"`
+			NodeUUID string `json:"node_uuid"`
+			File     string `json:"file"`
+			Nonce    string `json:"nonce"`
+			Size     int64  `json:"size"`
+			Checksum string `json:"checksum"`
+		}{},
+		Status:   http.StatusAccepted,
+		Response: map[string]string{}},
+	{Route: "GET /api/v1/jobs", Summary: "Post-upload jobs", Tag: "uploads", Access: accessLogin,
+		Query:    []apiParam{{"file", "string", "only jobs for this file"}, {"status", "string", "only jobs in this status"}},
+		Response: []PipelineJob{}},
+	{Route: "GET /api/v1/jobs/{id}", Summary: "One post-upload job", Tag: "uploads", Access: accessLogin, Response: PipelineJob{}},
+
+	// Sharing and notifications
+	{Route: "GET /api/v1/links", Summary: "Short links", Tag: "links", Access: accessLogin, Response: []ShortLink{}},
+	{Route: "POST /api/v1/links", Summary: "Make a short link", Tag: "links", Access: accessLogin,
+		Body: struct {
+			Name         string   `json:"name"`
+			ExpiresIn    Duration `json:"expires_in"`
+			MaxDownloads int      `json:"max_downloads"`
+			ShareWith    []string `json:"share_with"`
+		}{},
+		Status: http.StatusCreated, Response: ShortLink{}},
+	{Route: "DELETE /api/v1/links/{code}", Summary: "Revoke a short link", Tag: "links", Access: accessLogin, Status: http.StatusNoContent},
+	{Route: "GET /api/v1/me/notifications", Summary: "Notification settings", Tag: "notifications", Access: accessLogin, Response: notificationSettings},
+	{Route: "PUT /api/v1/me/notifications", Summary: "Set the notification email", Tag: "notifications", Access: accessLogin,
+		Body: struct {
+			Email string `json:"email"`
+		}{},
+		Response: notificationSettings},
+	{Route: "GET /api/v1/me/subscriptions", Summary: "Subscriptions", Tag: "notifications", Access: accessLogin, Response: []Subscription{}},
+	{Route: "POST /api/v1/me/subscriptions", Summary: "Subscribe to an event", Tag: "notifications", Access: accessLogin,
+		Body: Subscription{}, Status: http.StatusCreated, Response: Subscription{}},
+	{Route: "DELETE /api/v1/me/subscriptions/{id}", Summary: "Unsubscribe", Tag: "notifications", Access: accessLogin, Status: http.StatusNoContent},
+	{Route: "GET /api/v1/me/inbox", Summary: "Notifications in the UI inbox", Tag: "notifications", Access: accessLogin, Response: []Notification{}},
+	{Route: "POST /api/v1/me/inbox/read", Summary: "Mark the inbox read", Tag: "notifications", Access: accessLogin, Status: http.StatusNoContent},
+
+	// Trash
+	{Route: "GET /api/v1/trash", Summary: "Deleted files not yet purged", Tag: "trash", Access: accessLogin, Response: []TrashEntry{}},
+	{Route: "POST /api/v1/trash/{name}/restore", Summary: "Restore a file from the trash", Tag: "trash", Access: accessLogin,
+		Response: struct {
+			TrashEntry
+			PushErrors map[string]string `json:"push_errors,omitempty"`
+		}{}},
+	{Route: "DELETE /api/v1/trash/{name}", Summary: "Purge a file from the trash", Tag: "trash", Access: accessLogin, Status: http.StatusNoContent},
+
+	// Nodes and the cluster
+	{Route: "GET /api/v1/nodes", Summary: "Storage nodes and their health", Tag: "cluster", Response: []nodeStatus{}},
+	{Route: "POST /api/v1/nodes/register", Summary: "A node joins the cluster", Tag: "cluster", Access: accessNode,
+		Body: registrationRequest{}, Response: StorageServer{}},
+	{Route: "POST /api/v1/nodes/deregister", Summary: "A node leaves the cluster", Tag: "cluster", Access: accessNode,
+		Body: registrationRequest{}, Status: http.StatusNoContent},
+	{Route: "POST /api/v1/nodes/repair", Summary: "A node asks for a good copy of a file", Tag: "cluster", Access: accessNode,
+		Body: struct {
+			ID       string `json:"id"`
+			NodeUUID string `json:"node_uuid"`
+			File     string `json:"file"`
+		}{},
+		Status: http.StatusAccepted},
+	{Route: "GET /api/v1/cluster/topology", Summary: "Nodes, zones and latencies between them", Tag: "cluster", Response: Topology{}},
+	{Route: "GET /api/v1/cluster/status", Summary: "Cluster health at a glance", Tag: "cluster", Response: ClusterStatus{}},
+	{Route: "GET /api/v1/latency", Summary: "Measured latencies to the nodes", Tag: "cluster",
+		Response: struct {
+			Central map[string]float64            `json:"central"`
+			Clients map[string]map[string]float64 `json:"clients"`
+		}{}},
+	{Route: "POST /api/v1/latency", Summary: "Report a client's latencies to the nodes", Tag: "cluster",
+		Body: struct {
+			Samples map[string]float64 `json:"samples"`
+		}{},
+		Response: struct {
+			Network  string `json:"network"`
+			Accepted int    `json:"accepted"`
+		}{}},
+	{Route: "GET /api/v1/features", Summary: "Feature flags", Tag: "cluster", Response: []flagStatus{}},
+	{Route: "GET /api/v1/maintenance", Summary: "Maintenance windows", Tag: "cluster", Response: MaintenanceStatus{}},
+	{Route: "GET /api/v1/prefetch", Summary: "Last prefetch run", Tag: "cluster", Response: PrefetchReport{}},
+
+	// Analytics
+	{Route: "GET /api/v1/analytics/downloads", Summary: "Most downloaded files", Tag: "analytics",
+		Query:    []apiParam{{"limit", "integer", "at most this many files"}, {"region", "string", "only downloads from this region"}},
+		Response: []FileDownloads{}},
+	{Route: "GET /api/v1/analytics/access", Summary: "Access log totals", Tag: "analytics",
+		Query: []apiParam{
+			{"by", "string", "comma-separated grouping: day, file, region"},
+			{"file", "string", "only this file"},
+			{"region", "string", "only this region"},
+			{"from", "string", "first day, like 2006-01-02"},
+			{"to", "string", "last day, like 2006-01-02"},
+		},
+		Response: []AccessAggregate{}},
+
+	// Admin
+	{Route: "POST /api/v1/admin/reload", Summary: "Reload the config file", Tag: "admin", Access: accessAdmin, Response: ReloadReport{}},
+	{Route: "POST /api/v1/admin/prefetch", Summary: "Run prefetch now", Tag: "admin", Access: accessAdmin, Response: PrefetchReport{}},
+	{Route: "POST /api/v1/admin/jobs/{id}/retry", Summary: "Retry a failed post-upload job", Tag: "admin", Access: accessAdmin,
+		Status: http.StatusAccepted, Response: PipelineJob{}},
+	{Route: "GET /api/v1/admin/schedules", Summary: "Scheduled tasks", Tag: "admin", Access: accessAdmin, Response: []ScheduledTask{}},
+	{Route: "POST /api/v1/admin/schedules/{name}/run", Summary: "Run a scheduled task now", Tag: "admin", Access: accessAdmin,
+		Status: http.StatusAccepted, Response: ScheduledTask{}},
+	{Route: "POST /api/v1/admin/schedules/{name}/enable", Summary: "Enable a scheduled task", Tag: "admin", Access: accessAdmin, Response: ScheduledTask{}},
+	{Route: "POST /api/v1/admin/schedules/{name}/disable", Summary: "Disable a scheduled task", Tag: "admin", Access: accessAdmin, Response: ScheduledTask{}},
+	{Route: "GET /api/v1/admin/usage", Summary: "Storage used by user and bucket", Tag: "admin", Access: accessAdmin, Response: UsageReport{}},
+	{Route: "POST /api/v1/admin/gc", Summary: "Collect garbage on the nodes", Tag: "admin", Access: accessAdmin,
+		Query:    []apiParam{{"policy", "string", "override the configured gc policy"}},
+		Response: GCReport{}},
+	{Route: "GET /api/v1/admin/fsck", Summary: "Audit every file", Tag: "admin", Access: accessAdmin, Response: FsckReport{}},
+	{Route: "POST /api/v1/admin/fsck", Summary: "Audit every file, repairing with repair=1", Tag: "admin", Access: accessAdmin,
+		Query:    []apiParam{{"repair", "string", "1 or true to repair what is found"}},
+		Response: FsckReport{}},
+	{Route: "GET /api/v1/admin/retention", Summary: "Last retention run", Tag: "admin", Access: accessAdmin, Response: RetentionReport{}},
+	{Route: "POST /api/v1/admin/retention", Summary: "Apply the retention rules now", Tag: "admin", Access: accessAdmin,
+		Query:    []apiParam{{"dry_run", "boolean", "report what would be done without doing it"}},
+		Response: RetentionReport{}},
+	{Route: "GET /api/v1/admin/replication/queue", Summary: "Queued replications", Tag: "admin", Access: accessAdmin, Response: []QueuedReplication{}},
+	{Route: "GET /api/v1/admin/replication/transfers", Summary: "Repair and rebalancing copies in flight, with the bytes copied so far", Tag: "admin", Access: accessAdmin, Response: []TransferProgress{}},
+	{Route: "DELETE /api/v1/admin/replication/queue", Summary: "Drop queued replications", Tag: "admin", Access: accessAdmin,
+		Query:    []apiParam{{"priority", "string", "only tasks of this priority"}},
+		Response: map[string]int{}},
+	{Route: "POST /api/v1/admin/replication/queue/{id}", Summary: "Reprioritize a queued replication", Tag: "admin", Access: accessAdmin,
+		Body: struct {
+			Priority string `json:"priority"`
+			Position *int   `json:"position"`
+		}{},
+		Response: []QueuedReplication{}},
+	{Route: "DELETE /api/v1/admin/replication/queue/{id}", Summary: "Drop a queued replication", Tag: "admin", Access: accessAdmin, Status: http.StatusNoContent},
+	{Route: "GET /api/v1/admin/quarantine", Summary: "Quarantined files", Tag: "admin", Access: accessAdmin, Response: []QuarantineEntry{}},
+	{Route: "POST /api/v1/admin/quarantine", Summary: "Quarantine a file", Tag: "admin", Access: accessAdmin,
+		Body: struct {
+			Name   string `json:"name"`
+			Reason string `json:"reason"`
+		}{},
+		Response: quarantineResult{}},
+	{Route: "POST /api/v1/admin/quarantine/{name}/release", Summary: "Release a file from quarantine", Tag: "admin", Access: accessAdmin, Response: quarantineResult{}},
+	{Route: "DELETE /api/v1/admin/quarantine/{name}", Summary: "Delete a quarantined file", Tag: "admin", Access: accessAdmin, Response: quarantineResult{}},
+	{Route: "POST /api/v1/admin/impersonate", Summary: "Get a token to act as a user, for support; needs an admin signed in as themselves", Tag: "admin", Access: accessLogin,
+		Body: struct {
+			User   string   `json:"user"`
+			Reason string   `json:"reason"` // why, for the log
+			TTL    Duration `json:"ttl"`    // default 1h, at most 8h
+		}{},
+		Status: http.StatusCreated,
+		Response: struct {
+			Impersonation
+			Token string `json:"token"`
+		}{}},
+	{Route: "GET /api/v1/admin/impersonations", Summary: "Impersonation tokens not yet expired", Tag: "admin", Access: accessAdmin, Response: []Impersonation{}},
+	{Route: "DELETE /api/v1/admin/impersonations/{id}", Summary: "Revoke an impersonation token", Tag: "admin", Access: accessAdmin, Status: http.StatusNoContent},
+	{Route: "GET /api/v1/admin/users/{name}/export", Summary: "Export a user's files and data", Tag: "admin", Access: accessAdmin, Produces: "application/gzip"},
+	{Route: "POST /api/v1/admin/users/{name}/erase", Summary: "Erase a user's files and data", Tag: "admin", Access: accessAdmin, Response: ErasureReport{}},
+	{Route: "GET /api/v1/admin/groups", Summary: "Groups", Tag: "admin", Access: accessAdmin, Response: []Group{}},
+	{Route: "PUT /api/v1/admin/groups/{name}", Summary: "Create or replace a group", Tag: "admin", Access: accessAdmin,
+		Body: struct {
+			Members []string `json:"members"`
+		}{},
+		Response: Group{}},
+	{Route: "DELETE /api/v1/admin/groups/{name}", Summary: "Delete a group", Tag: "admin", Access: accessAdmin, Status: http.StatusNoContent},
+	{Route: "GET /api/v1/admin/folders", Summary: "Team folders", Tag: "admin", Access: accessAdmin, Response: []Folder{}},
+	{Route: "PUT /api/v1/admin/folders/{name}", Summary: "Create or replace a team folder", Tag: "admin", Access: accessAdmin,
+		Body: struct {
+			Groups map[string]string `json:"groups"` // group: viewer or editor
+			Bucket string            `json:"bucket"`
+		}{},
+		Response: Folder{}},
+	{Route: "DELETE /api/v1/admin/folders/{name}", Summary: "Delete a team folder", Tag: "admin", Access: accessAdmin, Status: http.StatusNoContent},
+}
+
+// notificationSettings is what the notifications API answers with.
+var notificationSettings = struct {
+	Email         string         `json:"email"`
+	Subscriptions []Subscription `json:"subscriptions"`
+	Unread        int            `json:"unread"`
+	Events        []string       `json:"events"`
+	Channels      []string       `json:"channels"`
+}{}
+
+var (
+	openAPIOnce sync.Once
+	openAPIJSON []byte
+)
+
+// openAPIHandler serves the document: GET /api/v1/openapi.json.
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	openAPIOnce.Do(func() {
+		openAPIJSON, _ = json.MarshalIndent(openAPIDocument(), "", "  ")
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPIJSON)
+}
+
+var pathParam = regexp.MustCompile(`\{(\w+)\}`)
+
+// openAPIDocument builds the document from apiOperations.
+func openAPIDocument() map[string]any {
+	b := &schemaBuilder{components: map[string]any{}, names: map[reflect.Type]string{}}
+	errorSchema := b.schema(reflect.TypeOf(apierr.Error{}))
+	paths := map[string]map[string]any{}
+	for _, op := range apiOperations {
+		method, path, _ := strings.Cut(op.Route, " ")
+		method = strings.ToLower(method)
+
+		var params []any
+		for _, m := range pathParam.FindAllStringSubmatch(path, -1) {
+			params = append(params, map[string]any{"name": m[1], "in": "path", "required": true, "schema": map[string]any{"type": "string"}})
+		}
+		for _, q := range op.Query {
+			params = append(params, map[string]any{"name": q.Name, "in": "query", "description": q.Description, "schema": map[string]any{"type": q.Type}})
+		}
+
+		status := op.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		success := map[string]any{"description": http.StatusText(status)}
+		switch {
+		case op.Response != nil:
+			success["content"] = map[string]any{"application/json": map[string]any{"schema": b.schema(reflect.TypeOf(op.Response))}}
+		case op.Produces != "":
+			success["content"] = map[string]any{op.Produces: map[string]any{"schema": map[string]any{"type": "string", "format": "binary"}}}
+		}
+		o := map[string]any{
+			"operationId": operationID(method, path),
+			"summary":     op.Summary,
+			"tags":        []string{op.Tag},
+			"responses": map[string]any{
+				strconv.Itoa(status): success,
+				"default": map[string]any{
+					"description": "Error",
+					"content":     map[string]any{"application/json": map[string]any{"schema": errorSchema}},
+				},
+			},
+		}
+		if params != nil {
+			o["parameters"] = params
+		}
+		if op.Body != nil {
+			o["requestBody"] = map[string]any{
+				"required": true,
+				"content":  map[string]any{"application/json": map[string]any{"schema": b.schema(reflect.TypeOf(op.Body))}},
+			}
+		}
+		switch op.Access {
+		case accessLogin:
+			o["security"] = []any{map[string]any{"session": []string{}}, map[string]any{"impersonationToken": []string{}}}
+		case accessAdmin:
+			o["security"] = []any{map[string]any{"adminToken": []string{}}}
+		case accessNode:
+			o["security"] = []any{map[string]any{"registrationToken": []string{}}}
+		}
+		if paths[path] == nil {
+			paths[path] = map[string]any{}
+		}
+		paths[path][method] = o
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "dsfs central API",
+			"version": buildinfo.Get().String(),
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": b.components,
+			"securitySchemes": map[string]any{
+				"session":    map[string]any{"type": "apiKey", "in": "cookie", "name": sessionCookie},
+				"adminToken": map[string]any{"type": "http", "scheme": "bearer"},
+				"impersonationToken": map[string]any{"type": "http", "scheme": "bearer",
+					"description": "From POST /api/v1/admin/impersonate: acts as the user, logged with the admin's name"},
+				"registrationToken": map[string]any{"type": "http", "scheme": "bearer"},
+			},
+		},
+	}
+}
+
+// operationID names an operation by its method and path, e.g.
+// getFilesNameVerify for GET /api/v1/files/{name}/verify.
+func operationID(method, path string) string {
+	id := method
+	for _, part := range strings.FieldsFunc(strings.TrimPrefix(path, "/api/v1"), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		id += strings.ToUpper(part[:1]) + part[1:]
+	}
+	return id
+}
+
+// schemaBuilder turns Go types into JSON schemas the way encoding/json
+// would encode them. Named structs become components, referred to by
+// their name.
+type schemaBuilder struct {
+	components map[string]any
+	names      map[reflect.Type]string
+}
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+func (b *schemaBuilder) schema(t reflect.Type) map[string]any {
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t.Implements(marshalerType):
+		// The only JSON marshalers here (Duration) encode to strings.
+		return map[string]any{"type": "string"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		s := b.schema(t.Elem())
+		if _, ref := s["$ref"]; ref {
+			return map[string]any{"allOf": []any{s}, "nullable": true}
+		}
+		s["nullable"] = true
+		return s
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]any{"type": "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.object(t)
+		}
+		name, ok := b.names[t]
+		if !ok {
+			name = componentName(t, b.components)
+			b.names[t] = name
+			b.components[name] = map[string]any{} // placeholder while recursing
+			b.components[name] = b.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]any{} // interfaces: anything
+}
+
+// object is the schema of a struct's fields, those of embedded structs
+// included.
+func (b *schemaBuilder) object(t reflect.Type) map[string]any {
+	props := map[string]any{}
+	b.fields(t, props)
+	return map[string]any{"type": "object", "properties": props}
+}
+
+func (b *schemaBuilder) fields(t reflect.Type, props map[string]any) {
+	var embedded []reflect.Type
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		ft := f.Type
+		if f.Anonymous && name == "" {
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				embedded = append(embedded, ft)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if strings.Contains(opts, "string") {
+			props[name] = map[string]any{"type": "string"}
+			continue
+		}
+		props[name] = b.schema(ft)
+	}
+	// Fields of the outer struct win over embedded ones, as in encoding/json.
+	for _, et := range embedded {
+		inner := map[string]any{}
+		b.fields(et, inner)
+		for k, v := range inner {
+			if _, ok := props[k]; !ok {
+				props[k] = v
+			}
+		}
+	}
+}
+
+// componentName names t's component: its Go name, capitalized, or with the
+// package in front if another type took the name already.
+func componentName(t reflect.Type, taken map[string]any) string {
+	name := strings.ToUpper(t.Name()[:1]) + t.Name()[1:]
+	if _, ok := taken[name]; ok {
+		pkg := t.PkgPath()[strings.LastIndex(t.PkgPath(), "/")+1:]
+		name = strings.ToUpper(pkg[:1]) + pkg[1:] + name
+	}
+	return name
+}
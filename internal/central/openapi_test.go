@@ -0,0 +1,113 @@
+package central
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestOpenAPICoversRoutes reads the routes off routes() and
+// adminEndpoints() in main.go, and checks each JSON one has an operation.
+func TestOpenAPICoversRoutes(t *testing.T) {
+	f, err := parser.ParseFile(token.NewFileSet(), "main.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	documented := map[string]bool{} // by route and by path alone
+	for _, op := range apiOperations {
+		documented[op.Route] = true
+		_, path, _ := strings.Cut(op.Route, " ")
+		documented[path] = true
+	}
+	seen := map[string]bool{}
+	for _, decl := range f.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || (fn.Name.Name != "routes" && fn.Name.Name != "adminEndpoints") {
+			continue
+		}
+		ast.Inspect(fn.Body, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok || len(call.Args) == 0 {
+				return true
+			}
+			sel, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok || (sel.Sel.Name != "Handle" && sel.Sel.Name != "HandleFunc") {
+				return true
+			}
+			lit, ok := call.Args[0].(*ast.BasicLit)
+			if !ok {
+				return true
+			}
+			route, _ := strconv.Unquote(lit.Value)
+			if !strings.Contains(route, "/api/v1/") || strings.HasSuffix(route, "/") {
+				return true // pages, files and the admin catch-all
+			}
+			seen[route] = true
+			if !documented[route] {
+				t.Errorf("route %q has no apiOperation", route)
+			}
+			return true
+		})
+	}
+	for _, op := range apiOperations {
+		_, path, _ := strings.Cut(op.Route, " ")
+		if strings.HasPrefix(path, "/api/v1/") && !seen[op.Route] && !seen[path] {
+			t.Errorf("apiOperation %q has no route", op.Route)
+		}
+	}
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	c := newTestCluster(t, 1, nil)
+	resp, body := c.get("/api/v1/openapi.json", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("openapi.json = %d", resp.StatusCode)
+	}
+	var doc struct {
+		OpenAPI string `json:"openapi"`
+		Paths   map[string]map[string]struct {
+			Parameters []struct {
+				Name, In string
+			} `json:"parameters"`
+			Responses map[string]struct {
+				Content map[string]struct {
+					Schema map[string]any `json:"schema"`
+				} `json:"content"`
+			} `json:"responses"`
+		} `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]any `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal([]byte(body), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.OpenAPI != "3.0.3" {
+		t.Errorf("openapi = %q", doc.OpenAPI)
+	}
+
+	op := doc.Paths["/api/v1/files/{name}/lock"]["get"]
+	if len(op.Parameters) != 1 || op.Parameters[0].Name != "name" || op.Parameters[0].In != "path" {
+		t.Errorf("lock parameters = %+v, want the name in the path", op.Parameters)
+	}
+	ref, _ := op.Responses["200"].Content["application/json"].Schema["$ref"].(string)
+	lock, ok := doc.Components.Schemas[strings.TrimPrefix(ref, "#/components/schemas/")]
+	if ref == "" || !ok {
+		t.Fatalf("lock response refers to %q, not a component", ref)
+	}
+	for _, field := range []string{"name", "retain_until", "legal_hold"} {
+		if _, ok := lock.Properties[field]; !ok {
+			t.Errorf("ObjectLock schema has no %s: %v", field, lock.Properties)
+		}
+	}
+	if _, ok := doc.Components.Schemas["Error"].Properties["retryable"]; !ok {
+		t.Error("no Error schema with retryable")
+	}
+}
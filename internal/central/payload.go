@@ -0,0 +1,235 @@
+package central
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/checksum"
+)
+
+// ---------------------------
+// Upload Payload
+// ---------------------------
+
+var errUploadAborted = errors.New("upload aborted before the file was complete")
+
+// payload is an uploaded file on its way to the storage nodes. While the
+// upload streams in, receive tees it to the central API's copy on disk and
+// to one pipe per streamed target, so replicas are written as the bytes
+// arrive and the file is never held in memory. A replica that starts late
+// (a retry, async replication) reads the copy on disk once it is complete.
+//
+// Every pipe is read by the replica for its node or closed; a pipe nobody
+// reads would stall the upload, so whoever gives up on a node must close
+// its pipe (see take and releaseUntaken).
+type payload struct {
+	path     string
+	expected int64 // size hint for progress reporting, -1 if unknown
+
+	mu      sync.Mutex
+	readers map[string]*io.PipeReader // not yet taken
+	writers map[string]*io.PipeWriter // still being fed
+
+	spares []StorageServer // not yet handed out by nextSpare
+
+	done chan struct{} // closed when receive returns
+	size int64
+	alg  string // to hash it with; "" is SHA-256
+	sum  string // its checksum, once received
+	err  error
+}
+
+func newPayload(path string, expected int64, stream []StorageServer) *payload {
+	p := &payload{
+		path:     path,
+		expected: expected,
+		readers:  map[string]*io.PipeReader{},
+		writers:  map[string]*io.PipeWriter{},
+		done:     make(chan struct{}),
+	}
+	for _, s := range stream {
+		pr, pw := io.Pipe()
+		p.readers[s.ID] = pr
+		p.writers[s.ID] = pw
+	}
+	return p
+}
+
+// take hands out the pipe streaming the upload to a node, once. It returns
+// nil if the node is not streamed or its pipe was already taken. The caller
+// must Close it.
+func (p *payload) take(nodeID string) io.ReadCloser {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pr, ok := p.readers[nodeID]
+	if !ok {
+		return nil
+	}
+	delete(p.readers, nodeID)
+	return pr
+}
+
+// releaseUntaken closes the pipes no replica took, so receive stops
+// feeding them.
+func (p *payload) releaseUntaken() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for id, pr := range p.readers {
+		pr.CloseWithError(fmt.Errorf("no replica to %s", id))
+		delete(p.readers, id)
+	}
+}
+
+// nextSpare hands out a node to place a replica on instead of a target that
+// is out of space. Each spare is handed out once.
+func (p *payload) nextSpare() (StorageServer, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.spares) == 0 {
+		return StorageServer{}, false
+	}
+	s := p.spares[0]
+	p.spares = p.spares[1:]
+	return s, true
+}
+
+// open returns the complete copy on disk and its size, waiting for receive
+// to finish first.
+func (p *payload) open(ctx context.Context) (io.ReadCloser, int64, error) {
+	select {
+	case <-p.done:
+	case <-ctx.Done():
+		return nil, 0, ctx.Err()
+	}
+	if p.err != nil {
+		return nil, 0, fmt.Errorf("%w: %v", errUploadAborted, p.err)
+	}
+	f, err := os.Open(p.path)
+	if err != nil {
+		return nil, 0, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, fi.Size(), nil
+}
+
+// receive copies src to a temp file next to path and to every streamed
+// target, then moves the file into place. A target whose pipe was closed is
+// dropped; the others keep going. On error every pipe is closed with it.
+func (p *payload) receive(src io.Reader) (err error) {
+	defer func() {
+		p.mu.Lock()
+		for id, pw := range p.writers {
+			if err != nil {
+				pw.CloseWithError(fmt.Errorf("%w: %v", errUploadAborted, err))
+			} else {
+				pw.Close()
+			}
+			delete(p.writers, id)
+		}
+		p.mu.Unlock()
+		p.err = err
+		close(p.done)
+	}()
+
+	if err := os.MkdirAll(filepath.Dir(p.path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p.path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	h, err := checksum.New(p.alg)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 256<<10)
+	for {
+		n, rerr := src.Read(buf)
+		if n > 0 {
+			if _, err := tmp.Write(buf[:n]); err != nil {
+				tmp.Close()
+				return err
+			}
+			h.Write(buf[:n])
+			p.feed(buf[:n])
+			p.size += int64(n)
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			tmp.Close()
+			return rerr
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	p.sum = checksum.Format(p.alg, h)
+	return os.Rename(tmp.Name(), p.path)
+}
+
+// feed writes a chunk to every streamed target. Writes block until each
+// target has read the chunk, so the slowest node sets the pace; a node that
+// fails closes its pipe and drops out.
+func (p *payload) feed(chunk []byte) {
+	p.mu.Lock()
+	writers := make(map[string]*io.PipeWriter, len(p.writers))
+	for id, pw := range p.writers {
+		writers[id] = pw
+	}
+	p.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for id, pw := range writers {
+		wg.Add(1)
+		go func(id string, pw *io.PipeWriter) {
+			defer wg.Done()
+			if _, err := pw.Write(chunk); err != nil {
+				p.mu.Lock()
+				delete(p.writers, id)
+				p.mu.Unlock()
+			}
+		}(id, pw)
+	}
+	wg.Wait()
+}
+
+// afterReceive returns a context that expires d after the upload has fully
+// arrived, so time spent waiting on a slow client does not count against
+// deadlines meant for the nodes. The cause of the expiry is
+// context.DeadlineExceeded.
+func (p *payload) afterReceive(parent context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(parent)
+	go func() {
+		select {
+		case <-p.done:
+		case <-ctx.Done():
+			return
+		}
+		t := time.NewTimer(d)
+		defer t.Stop()
+		select {
+		case <-t.C:
+			cancel(context.DeadlineExceeded)
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() { cancel(context.Canceled) }
+}
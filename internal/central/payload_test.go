@@ -0,0 +1,87 @@
+package central
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPayloadTeesToDiskAndPipes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "doc.txt")
+	p := newPayload(path, -1, []StorageServer{{ID: "a"}, {ID: "b"}, {ID: "c"}})
+
+	// a and b read along; c gives up after the first byte and must not
+	// stall the others.
+	got := make(chan string, 2)
+	for _, id := range []string{"a", "b"} {
+		r := p.take(id)
+		go func() {
+			b, _ := io.ReadAll(r)
+			got <- string(b)
+		}()
+	}
+	quitter := p.take("c")
+	go func() {
+		quitter.Read(make([]byte, 1))
+		quitter.Close()
+	}()
+	if p.take("a") != nil {
+		t.Error("pipe for a handed out twice")
+	}
+
+	content := strings.Repeat("x", 1<<20)
+	if err := p.receive(strings.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if s := <-got; s != content {
+			t.Errorf("pipe got %d bytes, want %d", len(s), len(content))
+		}
+	}
+
+	f, size, err := p.open(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if size != int64(len(content)) {
+		t.Errorf("open size = %d, want %d", size, len(content))
+	}
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("upload dir has %d entries, want only the file", len(entries))
+	}
+}
+
+func TestPayloadAbortedUpload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "doc.txt")
+	p := newPayload(path, -1, []StorageServer{{ID: "a"}})
+	r := p.take("a")
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := io.ReadAll(r)
+		readErr <- err
+	}()
+
+	broken := io.MultiReader(strings.NewReader("partial"), iotestErrReader{})
+	if err := p.receive(broken); err == nil {
+		t.Fatal("receive succeeded on a broken body")
+	}
+	if err := <-readErr; !errors.Is(err, errUploadAborted) {
+		t.Errorf("pipe error = %v, want errUploadAborted", err)
+	}
+	if _, _, err := p.open(context.Background()); !errors.Is(err, errUploadAborted) {
+		t.Errorf("open error = %v, want errUploadAborted", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("partial file left at %s", path)
+	}
+}
+
+type iotestErrReader struct{}
+
+func (iotestErrReader) Read([]byte) (int, error) { return 0, errors.New("connection reset") }
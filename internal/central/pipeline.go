@@ -0,0 +1,402 @@
+package central
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+)
+
+// ---------------------------
+// Post-upload Processing
+// ---------------------------
+
+// Work an upload leaves for later runs as pipeline jobs: one task on one
+// file, in a queue kept under DataDir/pipeline.json so a restart picks up
+// where it stopped (a job cut short by it runs again). At most
+// pipelineWorkers jobs run at once; workers start when there is work and
+// stop when there is none. A failed job is not retried by itself: the
+// admin API can run it again.
+const (
+	taskVerify    = "verify"     // rehash every replica against the central copy
+	taskThumbnail = "thumbnail"  // pre-render the image's thumbnail variant
+	taskIndex     = "index"      // (re)index the text for /search
+	taskCloudCopy = "cloud_copy" // PUT the central copy under cloudCopyURL
+)
+
+const (
+	jobQueued  = "queued"
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+)
+
+// Finished jobs stay queryable for this long.
+const pipelineJobRetention = 24 * time.Hour
+
+// pipelineTaskTimeout bounds one run of a job.
+const pipelineTaskTimeout = 10 * time.Minute
+
+var (
+	postUploadTasks = []string{taskVerify, taskThumbnail}
+	pipelineWorkers = 2
+	cloudCopyURL    string
+)
+
+// pipelineTasks runs each task on a file. A file gone by the time its job
+// runs is no error: there is nothing left to do.
+var pipelineTasks = map[string]func(ctx context.Context, file string) error{
+	taskVerify:    verifyTask,
+	taskThumbnail: thumbnailTask,
+	taskIndex:     indexNow,
+	taskCloudCopy: cloudCopyTask,
+}
+
+// validTask reports whether task names a pipeline task that can be chosen
+// for uploads; indexing follows the search index instead.
+func validTask(task string) bool {
+	_, ok := pipelineTasks[task]
+	return ok && task != taskIndex
+}
+
+// PipelineJob is one task on one file.
+type PipelineJob struct {
+	ID       string     `json:"id"`
+	Task     string     `json:"task"`
+	File     string     `json:"file"`
+	Status   string     `json:"status"`
+	Attempts int        `json:"attempts"`
+	Error    string     `json:"error,omitempty"`
+	Created  time.Time  `json:"created"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+type pipelineQueue struct {
+	mu     sync.Mutex
+	path   string
+	jobs   []*PipelineJob // oldest first
+	active int            // workers running
+}
+
+var pipeline = &pipelineQueue{}
+
+func (q *pipelineQueue) load(path string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.path = path
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw, &q.jobs); err != nil {
+		return err
+	}
+	for _, j := range q.jobs {
+		if j.Status == jobRunning {
+			j.Status = jobQueued // interrupted by the restart
+		}
+	}
+	return nil
+}
+
+func (q *pipelineQueue) saveLocked() {
+	cutoff := time.Now().Add(-pipelineJobRetention)
+	q.jobs = slices.DeleteFunc(q.jobs, func(j *PipelineJob) bool {
+		return j.Finished != nil && j.Finished.Before(cutoff)
+	})
+	if q.path == "" {
+		return
+	}
+	raw, err := json.MarshalIndent(q.jobs, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(q.path), 0755)
+	}
+	if err == nil {
+		tmp := q.path + ".tmp"
+		if err = os.WriteFile(tmp, raw, 0644); err == nil {
+			err = os.Rename(tmp, q.path)
+		}
+	}
+	if err != nil {
+		fmt.Println("Cannot save the pipeline queue:", err)
+	}
+}
+
+// enqueue queues task on file and returns the job. A job for the same
+// task and file still waiting to run is returned instead of a second one.
+func (q *pipelineQueue) enqueue(task, file string) (PipelineJob, error) {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return PipelineJob{}, err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, j := range q.jobs {
+		if j.Task == task && j.File == file && j.Status == jobQueued {
+			return *j, nil
+		}
+	}
+	j := &PipelineJob{ID: hex.EncodeToString(b[:]), Task: task, File: file, Status: jobQueued, Created: time.Now().UTC()}
+	q.jobs = append(q.jobs, j)
+	q.saveLocked()
+	q.startWorkersLocked()
+	return *j, nil
+}
+
+// startWorkersLocked starts workers for the queued jobs, up to
+// pipelineWorkers in all.
+func (q *pipelineQueue) startWorkersLocked() {
+	queued := 0
+	for _, j := range q.jobs {
+		if j.Status == jobQueued {
+			queued++
+		}
+	}
+	for q.active < pipelineWorkers && queued > 0 {
+		q.active++
+		queued--
+		go q.work()
+	}
+}
+
+// start runs the jobs left queued, e.g. by a restart.
+func (q *pipelineQueue) start() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.startWorkersLocked()
+}
+
+// work runs queued jobs, oldest first, until there are none.
+func (q *pipelineQueue) work() {
+	for {
+		q.mu.Lock()
+		var j *PipelineJob
+		for _, c := range q.jobs {
+			if c.Status == jobQueued {
+				j = c
+				break
+			}
+		}
+		if j == nil {
+			q.active--
+			q.mu.Unlock()
+			return
+		}
+		now := time.Now().UTC()
+		j.Status, j.Started, j.Finished, j.Error = jobRunning, &now, nil, ""
+		j.Attempts++
+		task, file := j.Task, j.File
+		q.saveLocked()
+		q.mu.Unlock()
+
+		err := errors.New("unknown task " + task)
+		if run, ok := pipelineTasks[task]; ok {
+			ctx, cancel := context.WithTimeout(context.Background(), pipelineTaskTimeout)
+			err = run(ctx, file)
+			cancel()
+		}
+
+		q.mu.Lock()
+		done := time.Now().UTC()
+		j.Finished = &done
+		j.Status = jobDone
+		if err != nil {
+			j.Status, j.Error = jobFailed, err.Error()
+			fmt.Println("Pipeline:", task, "of", file, "failed:", err)
+		}
+		q.saveLocked()
+		q.mu.Unlock()
+	}
+}
+
+func (q *pipelineQueue) get(id string) (PipelineJob, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, j := range q.jobs {
+		if j.ID == id {
+			return *j, true
+		}
+	}
+	return PipelineJob{}, false
+}
+
+// list returns the jobs, newest first, for file and with status if not
+// empty.
+func (q *pipelineQueue) list(file, status string) []PipelineJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	out := []PipelineJob{}
+	for i := len(q.jobs) - 1; i >= 0; i-- {
+		j := q.jobs[i]
+		if (file == "" || j.File == file) && (status == "" || j.Status == status) {
+			out = append(out, *j)
+		}
+	}
+	return out
+}
+
+// pending counts the jobs queued or running.
+func (q *pipelineQueue) pending() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := 0
+	for _, j := range q.jobs {
+		if j.Status == jobQueued || j.Status == jobRunning {
+			n++
+		}
+	}
+	return n
+}
+
+// retry queues a failed job again.
+func (q *pipelineQueue) retry(id string) (PipelineJob, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, j := range q.jobs {
+		if j.ID != id {
+			continue
+		}
+		if j.Status != jobFailed {
+			return *j, fmt.Errorf("job %s is %s, only failed jobs are retried", id, j.Status)
+		}
+		j.Status, j.Error, j.Started, j.Finished = jobQueued, "", nil, nil
+		q.saveLocked()
+		q.startWorkersLocked()
+		return *j, nil
+	}
+	return PipelineJob{}, errJobNotFound
+}
+
+var errJobNotFound = errors.New("no such job")
+
+// queuePostUpload queues the configured post-upload tasks for a file just
+// stored, and returns the job IDs.
+func queuePostUpload(file string) []string {
+	var ids []string
+	for _, task := range postUploadTasks {
+		j, err := pipeline.enqueue(task, file)
+		if err != nil {
+			fmt.Println("Cannot queue", task, "of", file, ":", err)
+			continue
+		}
+		ids = append(ids, j.ID)
+	}
+	return ids
+}
+
+// verifyTask fails if a replica of file does not match the central copy
+// or could not be checked.
+func verifyTask(ctx context.Context, file string) error {
+	res, found, err := verifyFile(ctx, file)
+	if err != nil || !found || res.OK {
+		return err
+	}
+	var bad []string
+	for _, rv := range res.Replicas {
+		if rv.Status == verifyMismatch || rv.Status == verifyUnreachable {
+			bad = append(bad, rv.Node+": "+rv.Status)
+		}
+	}
+	return fmt.Errorf("replicas do not verify: %s", strings.Join(bad, ", "))
+}
+
+// cloudCopyTask PUTs the central copy of file to cloudCopyURL/<file>, an
+// S3-compatible bucket URL that takes unsigned writes or any other store
+// that takes a PUT.
+func cloudCopyTask(ctx context.Context, file string) error {
+	if cloudCopyURL == "" {
+		return errors.New("no cloud_copy_url configured")
+	}
+	f, err := os.Open(filepath.Join(uploadDir, file))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, strings.TrimSuffix(cloudCopyURL, "/")+"/"+url.PathEscape(file), f)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = fi.Size()
+	resp, err := nodeClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("cloud copy: %s", resp.Status)
+	}
+	return nil
+}
+
+// pipelineJobsHandler lists the jobs for files the signed-in user may
+// read: GET /api/v1/jobs, with ?file= and ?status= to filter.
+func pipelineJobsHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	jobs := []PipelineJob{}
+	for _, j := range pipeline.list(q.Get("file"), q.Get("status")) {
+		if mayReadJob(r, j) {
+			jobs = append(jobs, j)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(jobs)
+}
+
+// mayReadJob reports whether r's user may see j. A job names its file,
+// so it is shown only to those who may read the file.
+func mayReadJob(r *http.Request, j PipelineJob) bool {
+	return canSee(signedIn(r), j.File) && mayRead(r, j.File)
+}
+
+// pipelineJobHandler reports one job: GET /api/v1/jobs/{id}.
+func pipelineJobHandler(w http.ResponseWriter, r *http.Request) {
+	j, ok := pipeline.get(r.PathValue("id"))
+	if !ok || !mayReadJob(r, j) {
+		apierr.Send(w, "Unknown job id", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(j)
+}
+
+// pipelineRetryHandler runs a failed job again: POST
+// /api/v1/admin/jobs/{id}/retry.
+func pipelineRetryHandler(w http.ResponseWriter, r *http.Request) {
+	j, err := pipeline.retry(r.PathValue("id"))
+	if err != nil {
+		status := http.StatusConflict
+		if errors.Is(err, errJobNotFound) {
+			status = http.StatusNotFound
+		}
+		apierr.Send(w, err.Error(), status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(j)
+}
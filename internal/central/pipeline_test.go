@@ -0,0 +1,177 @@
+package central
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPostUploadPipeline(t *testing.T) {
+	var mu sync.Mutex
+	copied := map[string]string{}
+	fail := true
+	cloud := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if fail {
+			http.Error(w, "try later", http.StatusServiceUnavailable)
+			return
+		}
+		b, _ := io.ReadAll(r.Body)
+		copied[r.URL.Path] = string(b)
+	}))
+	defer cloud.Close()
+	c := newTestCluster(t, 2, func(cfg *Config) {
+		cfg.PostUploadTasks = []string{taskVerify, taskThumbnail, taskCloudCopy}
+		cfg.CloudCopyURL = cloud.URL + "/bucket"
+		cfg.SearchIndex = "memory"
+		cfg.AdminToken = "s3cret"
+	})
+
+	var pic bytes.Buffer
+	png.Encode(&pic, image.NewRGBA(image.Rect(0, 0, 600, 300)))
+	c.upload("pic.png", pic.String(), nearLondon)
+	c.upload("notes.txt", "pipeline notes", nearLondon)
+
+	jobs := func(file string) map[string]PipelineJob {
+		t.Helper()
+		var list []PipelineJob
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			_, body := c.get("/api/v1/jobs?file="+file, nil)
+			json.Unmarshal([]byte(body), &list)
+			if pipeline.pending() == 0 {
+				break
+			}
+		}
+		out := map[string]PipelineJob{}
+		for _, j := range list {
+			out[j.Task] = j
+		}
+		return out
+	}
+	got := jobs("pic.png")
+	if got[taskVerify].Status != jobDone || got[taskThumbnail].Status != jobDone || got[taskCloudCopy].Status != jobFailed {
+		t.Fatalf("pic.png jobs = %+v", got)
+	}
+	if _, ok := got[taskIndex]; !ok {
+		t.Error("pic.png not queued for indexing")
+	}
+	if j := got[taskVerify]; j.Started == nil || j.Finished == nil || !j.Started.Before(*j.Finished) {
+		t.Errorf("verify job ran from %v to %v", j.Started, j.Finished)
+	}
+	resp, _ := c.get("/image/pic.png?w=256&h=256", nil)
+	if resp.Header.Get("X-Cache") != "hit" {
+		t.Error("thumbnail not rendered ahead of the request")
+	}
+	if got := jobs("notes.txt"); got[taskIndex].Status != jobDone {
+		t.Errorf("notes.txt jobs = %+v", got)
+	}
+
+	// A failed job runs again on request.
+	mu.Lock()
+	fail = false
+	mu.Unlock()
+	id := got[taskCloudCopy].ID
+	req, _ := http.NewRequest("POST", c.central.URL+"/api/v1/admin/jobs/"+id+"/retry", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	if resp, err := testClient.Do(req); err != nil || resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("retry = %v %v", resp, err)
+	}
+	if j := jobs("pic.png")[taskCloudCopy]; j.Status != jobDone || j.Attempts != 2 {
+		t.Errorf("cloud copy after retry = %+v", j)
+	}
+	mu.Lock()
+	if copied["/bucket/pic.png"] != pic.String() {
+		t.Errorf("cloud copy = %d bytes, want %d", len(copied["/bucket/pic.png"]), pic.Len())
+	}
+	mu.Unlock()
+	if resp, _ := c.get("/api/v1/jobs/"+id, nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("job by id = %d", resp.StatusCode)
+	}
+}
+
+func TestPipelineJobsAccess(t *testing.T) {
+	hash, _ := hashPassword("pw")
+	c := newTestCluster(t, 1, func(cfg *Config) {
+		cfg.Users = []User{{Name: "alice", PasswordHash: hash}, {Name: "bob", PasswordHash: hash}}
+	})
+	get := func(user, path string) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest("GET", c.central.URL+path, nil)
+		if user != "" {
+			req.SetBasicAuth(user, "pw")
+		}
+		resp, err := testClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(b)
+	}
+	files := func(user string) []string {
+		t.Helper()
+		status, body := get(user, "/api/v1/jobs")
+		var list []PipelineJob
+		json.Unmarshal([]byte(body), &list)
+		var names []string
+		for _, j := range list {
+			names = append(names, j.File)
+		}
+		if status != http.StatusOK {
+			t.Errorf("%s's jobs: status %d", user, status)
+		}
+		return names
+	}
+
+	// A job on a file in alice's home, and one on a private file in no
+	// home: admins only.
+	diary := homeName("alice", "diary.txt")
+	job, _ := pipeline.enqueue(taskVerify, diary)
+	pipeline.enqueue(taskVerify, "board.txt")
+	privateFiles.set("board.txt", true)
+
+	if status, _ := get("", "/api/v1/jobs"); status != http.StatusUnauthorized {
+		t.Errorf("anonymous jobs: status %d", status)
+	}
+	if got := files("bob"); len(got) != 0 {
+		t.Errorf("bob sees jobs on %v, want none", got)
+	}
+	if got := files("alice"); len(got) != 1 || got[0] != diary {
+		t.Errorf("alice sees jobs on %v, want only %s", got, diary)
+	}
+	if status, _ := get("bob", "/api/v1/jobs/"+job.ID); status != http.StatusNotFound {
+		t.Errorf("bob's job by id: status %d, want 404", status)
+	}
+	if status, _ := get("alice", "/api/v1/jobs/"+job.ID); status != http.StatusOK {
+		t.Errorf("alice's job by id: status %d", status)
+	}
+}
+
+func TestPipelineResumesAfterRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pipeline.json")
+	b, _ := json.Marshal([]PipelineJob{
+		{ID: "a", Task: taskVerify, File: "gone.txt", Status: jobRunning},
+		{ID: "b", Task: taskVerify, File: "gone.txt", Status: jobFailed},
+	})
+	os.WriteFile(path, b, 0644)
+	q := &pipelineQueue{}
+	if err := q.load(path); err != nil {
+		t.Fatal(err)
+	}
+	if j, _ := q.get("a"); j.Status != jobQueued {
+		t.Errorf("interrupted job = %s, want queued", j.Status)
+	}
+	if j, _ := q.get("b"); j.Status != jobFailed {
+		t.Errorf("failed job = %s, want it left failed", j.Status)
+	}
+}
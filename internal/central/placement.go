@@ -0,0 +1,162 @@
+package central
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// ---------------------------
+// Placement Strategies
+// ---------------------------
+
+// PlacementStrategy decides which nodes a new upload is stored on. ranked is
+// every known node, nearest to the uploader first; n is the configured
+// replication factor (0 = unset, each strategy picks its own default).
+type PlacementStrategy interface {
+	Place(filename string, ranked []rankedNode, n int) []StorageServer
+}
+
+func newPlacementStrategy(name string) (PlacementStrategy, error) {
+	switch name {
+	case "", "default":
+		return defaultPlacement{}, nil
+	case "all":
+		return replicateAll{}, nil
+	case "nearest":
+		return nearestN{}, nil
+	case "hash":
+		return consistentHash{}, nil
+	case "zone":
+		return zoneAware{}, nil
+	}
+	return nil, fmt.Errorf("unknown placement %q (want default, all, nearest, hash or zone)", name)
+}
+
+// defaultPlacement is the original behavior: every node when no replication
+// factor is set, otherwise the nearest healthy ones.
+type defaultPlacement struct{}
+
+func (defaultPlacement) Place(filename string, ranked []rankedNode, n int) []StorageServer {
+	if n == 0 {
+		return replicateAll{}.Place(filename, ranked, n)
+	}
+	return nearestN{}.Place(filename, ranked, n)
+}
+
+// replicateAll copies every upload to every node, healthy or not, so a node
+// that is briefly down is not silently skipped.
+type replicateAll struct{}
+
+func (replicateAll) Place(filename string, ranked []rankedNode, n int) []StorageServer {
+	var targets []StorageServer
+	for _, r := range ranked {
+		targets = append(targets, r.StorageServer)
+	}
+	return targets
+}
+
+// nearestN picks the n nearest healthy nodes (all healthy nodes when n is 0).
+type nearestN struct{}
+
+func (nearestN) Place(filename string, ranked []rankedNode, n int) []StorageServer {
+	var targets []StorageServer
+	for _, r := range ranked {
+		if health.isHealthy(r.ID) {
+			targets = append(targets, r.StorageServer)
+		}
+	}
+	return firstN(targets, n)
+}
+
+// consistentHash places a file on the healthy nodes that follow the hash of
+// its name on a ring, ignoring location. The same name always lands on the
+// same nodes, and adding or removing a node only moves the files next to it
+// on the ring.
+type consistentHash struct{}
+
+// Virtual points per node, to even out the share each node gets.
+const ringReplicas = 64
+
+func (consistentHash) Place(filename string, ranked []rankedNode, n int) []StorageServer {
+	type point struct {
+		hash uint64
+		node StorageServer
+	}
+	var ring []point
+	for _, r := range ranked {
+		for i := 0; i < ringReplicas; i++ {
+			ring = append(ring, point{ringHash(r.ID + "#" + strconv.Itoa(i)), r.StorageServer})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+
+	h := ringHash(filename)
+	start := sort.Search(len(ring), func(i int) bool { return ring[i].hash >= h })
+
+	var targets []StorageServer
+	seen := map[string]bool{}
+	for i := 0; i < len(ring); i++ {
+		s := ring[(start+i)%len(ring)].node
+		if seen[s.ID] {
+			continue
+		}
+		seen[s.ID] = true
+		if health.isHealthy(s.ID) {
+			targets = append(targets, s)
+		}
+	}
+	return firstN(targets, n)
+}
+
+func ringHash(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
+
+// zoneAware spreads replicas across zones: the nearest healthy node of each
+// zone first, then the remaining nodes by distance, at most
+// Config.MaxReplicasPerZone to a zone. With no replication factor it places one
+// replica per zone. Nodes without a zone count as their own zone.
+type zoneAware struct{}
+
+func (zoneAware) Place(filename string, ranked []rankedNode, n int) []StorageServer {
+	maxPerZone := currentPolicy().maxReplicasPerZone // 0 = no cap
+	var spread, rest []StorageServer
+	zones := map[string]int{}
+	for _, r := range ranked {
+		if !health.isHealthy(r.ID) {
+			continue
+		}
+		zone := nodeZone(r.StorageServer)
+		zones[zone]++
+		switch {
+		case zones[zone] == 1:
+			spread = append(spread, r.StorageServer)
+		case maxPerZone == 0 || zones[zone] <= maxPerZone:
+			rest = append(rest, r.StorageServer)
+		}
+	}
+	if n == 0 {
+		return spread
+	}
+	return firstN(append(spread, rest...), n)
+}
+
+// nodeZone is the failure domain s is in.
+func nodeZone(s StorageServer) string {
+	if s.Zone == "" {
+		return "node:" + s.ID
+	}
+	return s.Zone
+}
+
+// firstN truncates nodes to n entries; n <= 0 keeps them all.
+func firstN(nodes []StorageServer, n int) []StorageServer {
+	if n > 0 && n < len(nodes) {
+		return nodes[:n]
+	}
+	return nodes
+}
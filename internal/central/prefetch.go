@@ -0,0 +1,399 @@
+package central
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+)
+
+// ---------------------------
+// Popularity Prefetch
+// ---------------------------
+
+// Placement puts a file near whoever uploaded it; the download counts show
+// where it is actually wanted. Each prefetch run sorts the central copies
+// by the downloads since the previous run and the last download:
+//
+//   - hot (prefetch_hot_downloads since the last run): copied to a node in
+//     every region without a replica, as far as the nodes' room and the
+//     per-node budget for such extra copies allow
+//   - cold (not downloaded for cold_after): trimmed to cold_replicas
+//     replicas, extra copies first; fsck expects no more than that
+//   - anything else that was trimmed before: topped up to the replication
+//     factor again
+//
+// The central copy is never touched, so a trimmed file can always be
+// served and restored.
+
+type prefetchSettings struct {
+	hotDownloads int64
+	nodeBudget   int64 // 0 = no budget
+	coldAfter    time.Duration
+	coldReplicas int
+}
+
+var prefetchCfg = prefetchSettings{hotDownloads: 10, coldReplicas: 1}
+
+// PrefetchMove is one replica a prefetch run added or removed.
+type PrefetchMove struct {
+	File  string `json:"file"`
+	Node  string `json:"node"`
+	Error string `json:"error,omitempty"`
+}
+
+// PrefetchReport is the outcome of one prefetch run.
+type PrefetchReport struct {
+	Started     time.Time        `json:"started"`
+	Finished    time.Time        `json:"finished"`
+	Hot         []string         `json:"hot"`
+	Cold        []string         `json:"cold"`
+	Prefetched  []PrefetchMove   `json:"prefetched"`
+	Trimmed     []PrefetchMove   `json:"trimmed"`
+	Restored    []PrefetchMove   `json:"restored"`
+	BudgetUsed  map[string]int64 `json:"budget_used"` // bytes of extra copies, by node
+	Unreachable []string         `json:"unreachable,omitempty"`
+}
+
+type prefetchState struct {
+	runMu sync.Mutex // one run at a time
+
+	mu     sync.Mutex
+	seen   map[string]int64            // download totals at the last run
+	extras map[string]map[string]int64 // bytes of extra copies, by node and file
+	cold   map[string]bool             // files trimmed to cold_replicas
+	last   *PrefetchReport
+}
+
+var prefetch = newPrefetchState()
+
+func newPrefetchState() *prefetchState {
+	return &prefetchState{
+		seen:   map[string]int64{},
+		extras: map[string]map[string]int64{},
+		cold:   map[string]bool{},
+	}
+}
+
+// isCold reports whether name was trimmed to cold_replicas.
+func (p *prefetchState) isCold(name string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.cold[name]
+}
+
+func (p *prefetchState) setCold(name string, cold bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if cold {
+		p.cold[name] = true
+	} else {
+		delete(p.cold, name)
+	}
+}
+
+// used returns the bytes of extra copies on a node.
+func (p *prefetchState) used(nodeID string) int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var n int64
+	for _, size := range p.extras[nodeID] {
+		n += size
+	}
+	return n
+}
+
+func (p *prefetchState) addExtra(nodeID, name string, size int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.extras[nodeID] == nil {
+		p.extras[nodeID] = map[string]int64{}
+	}
+	p.extras[nodeID][name] = size
+}
+
+func (p *prefetchState) isExtra(nodeID, name string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.extras[nodeID][name]
+	return ok
+}
+
+func (p *prefetchState) dropExtra(nodeID, name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.extras[nodeID], name)
+}
+
+// prune forgets files that are gone.
+func (p *prefetchState) prune(exists map[string]bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, files := range p.extras {
+		for name := range files {
+			if !exists[name] {
+				delete(files, name)
+			}
+		}
+	}
+	for name := range p.cold {
+		if !exists[name] {
+			delete(p.cold, name)
+		}
+	}
+}
+
+func (p *prefetchState) budgetUsed() map[string]int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := map[string]int64{}
+	for id, files := range p.extras {
+		for _, size := range files {
+			out[id] += size
+		}
+	}
+	return out
+}
+
+// runPrefetch moves replicas to where the downloads are.
+func runPrefetch(ctx context.Context) PrefetchReport {
+	prefetch.runMu.Lock()
+	defer prefetch.runMu.Unlock()
+
+	cfg := prefetchCfg
+	now := time.Now().UTC()
+	report := PrefetchReport{Started: now, Hot: []string{}, Cold: []string{},
+		Prefetched: []PrefetchMove{}, Trimmed: []PrefetchMove{}, Restored: []PrefetchMove{}}
+
+	// Who holds what, among the nodes that answer.
+	nodes := topo.nodes()
+	want := int(replicationFactor.Load())
+	if want == 0 || want > len(nodes) {
+		want = len(nodes)
+	}
+	holders := map[string]map[string]bool{} // file -> node IDs
+	var reachable []StorageServer
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, s := range nodes {
+		wg.Add(1)
+		go func(s StorageServer) {
+			defer wg.Done()
+			list, err := fetchNodeFiles(ctx, s)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				report.Unreachable = append(report.Unreachable, s.ID)
+				return
+			}
+			reachable = append(reachable, s)
+			for _, f := range list {
+				if holders[f.Name] == nil {
+					holders[f.Name] = map[string]bool{}
+				}
+				holders[f.Name][s.ID] = true
+			}
+		}(s)
+	}
+	wg.Wait()
+	sort.Strings(report.Unreachable)
+	sort.Slice(reachable, func(i, j int) bool { return reachable[i].ID < reachable[j].ID })
+
+	totals := downloads.totals()
+	prefetch.mu.Lock()
+	seen := prefetch.seen
+	prefetch.mu.Unlock()
+
+	inFlight := inFlightUploads()
+	exists := map[string]bool{}
+	entries, _ := os.ReadDir(uploadDir)
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		exists[name] = true
+		fi, err := e.Info()
+		if err != nil || inFlight[name] || quarantined.covers(name) {
+			continue
+		}
+		if holders[name] == nil {
+			holders[name] = map[string]bool{}
+		}
+		lastUse := fi.ModTime()
+		if c, ok := downloads.get(name); ok && c.Last.After(lastUse) {
+			lastUse = c.Last
+		}
+
+		switch {
+		case retentionFiles.isArchived(name):
+			// Kept trimmed by a retention rule (see retention.go).
+		case totals[name]-seen[name] >= cfg.hotDownloads:
+			report.Hot = append(report.Hot, name)
+			prefetchRegions(ctx, name, fi.Size(), holders[name], reachable, cfg, &report)
+			prefetch.setCold(name, false)
+		case cfg.coldAfter > 0 && now.Sub(lastUse) >= cfg.coldAfter:
+			report.Cold = append(report.Cold, name)
+			trimReplicas(ctx, name, holders[name], reachable, cfg.coldReplicas, &report)
+			prefetch.setCold(name, true)
+		case prefetch.isCold(name):
+			if restoreReplicas(ctx, name, holders[name], reachable, want, &report) {
+				prefetch.setCold(name, false)
+			}
+		}
+	}
+
+	prefetch.prune(exists)
+	report.BudgetUsed = prefetch.budgetUsed()
+	report.Finished = time.Now().UTC()
+	prefetch.mu.Lock()
+	prefetch.seen = totals
+	prefetch.last = &report
+	prefetch.mu.Unlock()
+	return report
+}
+
+// prefetchRegions copies a hot file to one node in every region that has
+// no replica of it, within each node's room and budget.
+func prefetchRegions(ctx context.Context, name string, size int64, has map[string]bool,
+	nodes []StorageServer, cfg prefetchSettings, report *PrefetchReport) {
+	covered := map[string]bool{}
+	for _, s := range nodes {
+		if has[s.ID] {
+			covered[nodeRegion(s)] = true
+		}
+	}
+	var ranked []rankedNode
+	for _, s := range nodes {
+		if !has[s.ID] && health.isHealthy(s.ID) {
+			ranked = append(ranked, rankedNode{StorageServer: s})
+		}
+	}
+	for _, r := range preferHealthy(withRoom(ranked)) {
+		region := nodeRegion(r.StorageServer)
+		if covered[region] {
+			continue
+		}
+		if cfg.nodeBudget > 0 && prefetch.used(r.ID)+size > cfg.nodeBudget {
+			continue
+		}
+		move := PrefetchMove{File: name, Node: r.ID}
+		if err := bulkPush(ctx, r.StorageServer, name); err != nil {
+			if outOfSpace(err) {
+				capacity.markFull(r.ID, err.Error())
+			}
+			move.Error = err.Error()
+		} else {
+			covered[region] = true
+			has[r.ID] = true
+			prefetch.addExtra(r.ID, name, size)
+		}
+		report.Prefetched = append(report.Prefetched, move)
+	}
+}
+
+// trimReplicas deletes replicas of a cold file beyond keep, extra copies
+// and those on unhealthy nodes first.
+func trimReplicas(ctx context.Context, name string, has map[string]bool, nodes []StorageServer, keep int, report *PrefetchReport) {
+	var held []StorageServer
+	for _, s := range nodes {
+		if has[s.ID] {
+			held = append(held, s)
+		}
+	}
+	if len(held) <= keep {
+		return
+	}
+	if _, locked := objectLocks.locked(name); locked {
+		return // the nodes would refuse: a locked file keeps every replica
+	}
+	// Most worth keeping first.
+	sort.SliceStable(held, func(i, j int) bool {
+		hi, hj := health.isHealthy(held[i].ID), health.isHealthy(held[j].ID)
+		if hi != hj {
+			return hi
+		}
+		return !prefetch.isExtra(held[i].ID, name) && prefetch.isExtra(held[j].ID, name)
+	})
+	for _, s := range held[keep:] {
+		move := PrefetchMove{File: name, Node: s.ID}
+		err := callNode(ctx, s, opDelete, func(ctx context.Context) error {
+			return deleteFromNode(ctx, s, name)
+		})
+		if err != nil {
+			move.Error = err.Error()
+		} else {
+			delete(has, s.ID)
+			prefetch.dropExtra(s.ID, name)
+		}
+		report.Trimmed = append(report.Trimmed, move)
+	}
+}
+
+// restoreReplicas tops a file that is no longer cold up to want replicas,
+// reporting whether it got there.
+func restoreReplicas(ctx context.Context, name string, has map[string]bool, nodes []StorageServer, want int, report *PrefetchReport) bool {
+	have := len(has)
+	var ranked []rankedNode
+	for _, s := range nodes {
+		if !has[s.ID] && health.isHealthy(s.ID) {
+			ranked = append(ranked, rankedNode{StorageServer: s})
+		}
+	}
+	for _, r := range preferHealthy(withRoom(ranked)) {
+		if have >= want {
+			break
+		}
+		move := PrefetchMove{File: name, Node: r.ID}
+		if err := bulkPush(ctx, r.StorageServer, name); err != nil {
+			move.Error = err.Error()
+		} else {
+			has[r.ID] = true
+			have++
+		}
+		report.Restored = append(report.Restored, move)
+	}
+	return have >= want
+}
+
+// prefetchTask is the scheduled run, skipped outside the maintenance
+// windows.
+func prefetchTask(ctx context.Context) (string, error) {
+	if !maintenance.open(time.Now()) {
+		return "skipped: outside the maintenance windows", nil
+	}
+	report := runPrefetch(ctx)
+	summary := fmt.Sprintf("%d hot, %d cold; %d replica(s) added, %d trimmed, %d restored",
+		len(report.Hot), len(report.Cold), len(report.Prefetched), len(report.Trimmed), len(report.Restored))
+	if len(report.Prefetched)+len(report.Trimmed)+len(report.Restored) > 0 {
+		fmt.Println("Prefetch:", summary)
+	}
+	return summary, nil
+}
+
+// prefetchHandler serves the last prefetch run: GET /api/v1/prefetch.
+func prefetchHandler(w http.ResponseWriter, r *http.Request) {
+	prefetch.mu.Lock()
+	last := prefetch.last
+	prefetch.mu.Unlock()
+	if last == nil {
+		apierr.Send(w, "No prefetch run yet", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(last)
+}
+
+// prefetchRunHandler runs the prefetcher now: POST /api/v1/admin/prefetch.
+func prefetchRunHandler(w http.ResponseWriter, r *http.Request) {
+	report := runPrefetch(r.Context())
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
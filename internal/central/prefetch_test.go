@@ -0,0 +1,71 @@
+package central
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClusterPrefetch(t *testing.T) {
+	const content = "0123456789"
+	c := newTestCluster(t, 3, func(cfg *Config) {
+		cfg.ReplicationFactor = 2
+		cfg.PrefetchHotDownloads = 3
+		cfg.PrefetchNodeBudget = int64(len(content)) // one extra copy per node
+		cfg.ColdAfter = Duration{time.Hour}
+	})
+	for _, name := range []string{"hot1.txt", "hot2.txt", "cold.txt"} {
+		if resp, _ := c.upload(name, content, nearLondon); resp.StatusCode != 200 {
+			t.Fatalf("upload %s: %d", name, resp.StatusCode)
+		}
+	}
+	if h := c.holders("hot1.txt"); len(h) != 2 {
+		t.Fatalf("hot1.txt on %v before prefetch", h)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(filepath.Join(uploadDir, "cold.txt"), old, old)
+	for i := 0; i < 3; i++ {
+		c.get("/get/hot1.txt?"+nearSingapore.Encode(), nil)
+		c.get("/get/hot2.txt?"+nearSingapore.Encode(), nil)
+	}
+
+	report := runPrefetch(context.Background())
+	if !slices.Equal(report.Hot, []string{"hot1.txt", "hot2.txt"}) || !slices.Equal(report.Cold, []string{"cold.txt"}) {
+		t.Fatalf("hot %v, cold %v", report.Hot, report.Cold)
+	}
+	// Both want the third node; its budget fits only the first.
+	if h := c.holders("hot1.txt"); len(h) != 3 {
+		t.Errorf("hot1.txt on %v, want every node", h)
+	}
+	if h := c.holders("hot2.txt"); len(h) != 2 {
+		t.Errorf("hot2.txt on %v, want no room left for it", h)
+	}
+	if h := c.holders("cold.txt"); len(h) != 1 {
+		t.Errorf("cold.txt on %v, want 1", h)
+	}
+	if fsck := runFsck(context.Background(), false); fsck.Unrepaired() != 0 {
+		t.Errorf("fsck complains about trimmed files: %+v", fsck.Issues)
+	}
+
+	// No new downloads: hot files stay where they are, and a cold file that
+	// is wanted again gets its replicas back.
+	c.get("/get/cold.txt?"+nearLondon.Encode(), nil)
+	report = runPrefetch(context.Background())
+	if len(report.Hot) != 0 || len(report.Restored) != 1 || report.Restored[0].Error != "" {
+		t.Errorf("second run: %+v", report)
+	}
+	if h := c.holders("cold.txt"); len(h) != 2 {
+		t.Errorf("cold.txt on %v after restore, want 2", h)
+	}
+	if h := c.holders("hot1.txt"); len(h) != 3 {
+		t.Errorf("hot1.txt on %v after a quiet run", h)
+	}
+
+	if _, body := c.get("/api/v1/prefetch", nil); !strings.Contains(body, `"restored"`) {
+		t.Errorf("status: %s", body)
+	}
+}
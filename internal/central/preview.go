@@ -0,0 +1,272 @@
+package central
+
+import (
+	"bytes"
+	"context"
+	"html"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+)
+
+// ---------------------------
+// Previews
+// ---------------------------
+
+// maxPreviewBytes is how much of a file a preview shows.
+const maxPreviewBytes = 256 << 10
+
+// previewLangs maps the extensions previews highlight to a language, as
+// highlightCode knows them. Markdown is rendered instead; other text is
+// shown plain.
+var previewLangs = map[string]string{
+	".go": "go", ".js": "js", ".mjs": "js", ".ts": "js", ".jsx": "js", ".tsx": "js",
+	".java": "java", ".kt": "java", ".c": "c", ".h": "c", ".cpp": "c", ".cc": "c", ".hpp": "c",
+	".cs": "java", ".rs": "rust", ".swift": "java", ".py": "python", ".rb": "ruby",
+	".sh": "sh", ".bash": "sh", ".yml": "yaml", ".yaml": "yaml", ".toml": "yaml", ".ini": "yaml",
+	".sql": "sql", ".json": "js", ".css": "c", ".html": "html", ".xml": "html",
+}
+
+var previewTextExts = map[string]bool{".txt": true, ".log": true, ".csv": true, ".tsv": true, ".conf": true, ".env": true}
+
+// previewable reports whether /preview can show filename.
+func previewable(filename string) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+	return isMarkdown(filename) || previewLangs[ext] != "" || previewTextExts[ext]
+}
+
+func isMarkdown(filename string) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+	return ext == ".md" || ext == ".markdown"
+}
+
+// previewHandler renders a text file as a page: Markdown as HTML, code
+// highlighted, other text as it is. Only the first maxPreviewBytes are
+// shown; binary files are refused.
+func previewHandler(w http.ResponseWriter, r *http.Request) {
+	filename := r.PathValue("filename")
+	if refuseQuarantined(w, filename) {
+		return
+	}
+	if refusePrivate(w, r, filename) {
+		return
+	}
+	client, err := locateClient(r)
+	if err != nil {
+		apierr.Send(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f, err := openNearestCopy(r.Context(), filename, client)
+	if err != nil {
+		apierr.Send(w, "File not found", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	src, err := io.ReadAll(io.LimitReader(f, maxPreviewBytes+1))
+	if err != nil {
+		apierr.Send(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	truncated := len(src) > maxPreviewBytes
+	if truncated {
+		src = src[:maxPreviewBytes]
+		// Do not cut a character in half.
+		for len(src) > 0 && !utf8.Valid(src[len(src)-min(len(src), utf8.UTFMax):]) {
+			src = src[:len(src)-1]
+		}
+	}
+	if bytes.IndexByte(src, 0) >= 0 || !utf8.Valid(src) {
+		apierr.Send(w, "Binary files cannot be previewed", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var body string
+	switch ext := strings.ToLower(filepath.Ext(filename)); {
+	case isMarkdown(filename):
+		body = `<div class="markdown">` + renderMarkdown(string(src)) + `</div>`
+	default:
+		body = `<pre><code>` + highlightCode(string(src), previewLangs[ext]) + `</code></pre>`
+	}
+	data := struct {
+		Filename  string
+		Body      template.HTML
+		Truncated bool
+		Limit     int64
+	}{filename, template.HTML(body), truncated, maxPreviewBytes}
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; img-src * data:; style-src 'self' 'unsafe-inline'")
+	if err := templates.ExecuteTemplate(w, "preview.html", data); err != nil {
+		apierr.Send(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// openNearestCopy opens filename from the central copy if there is one, or
+// the nearest healthy replica.
+func openNearestCopy(ctx context.Context, filename string, client clientRef) (io.ReadCloser, error) {
+	if hasCentralCopy(filename) {
+		return os.Open(filepath.Join(uploadDir, filepath.Base(filename)))
+	}
+	s, ok := nearestReplica(ctx, client, filename)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, nodeFileURL(s, filename), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := nodeClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, &statusError{Status: resp.StatusCode}
+	}
+	return resp.Body, nil
+}
+
+// ---------------------------
+// Syntax Highlighting
+// ---------------------------
+
+// codeSyntax is what highlightCode needs to know about a language.
+type codeSyntax struct {
+	lineComments []string
+	blockComment [2]string
+	quotes       string
+	keywords     map[string]bool
+}
+
+func keywords(s string) map[string]bool {
+	m := map[string]bool{}
+	for _, k := range strings.Fields(s) {
+		m[k] = true
+	}
+	return m
+}
+
+var codeSyntaxes = map[string]codeSyntax{
+	"go": {[]string{"//"}, [2]string{"/*", "*/"}, "\"'`", keywords(`break case chan const continue default defer else
+		fallthrough for func go goto if import interface map package range return select struct switch type var
+		nil true false iota`)},
+	"js": {[]string{"//"}, [2]string{"/*", "*/"}, "\"'`", keywords(`break case catch class const continue debugger
+		default delete do else export extends finally for function if import in instanceof let new return super
+		switch this throw try typeof var void while with yield async await null undefined true false interface type`)},
+	"java": {[]string{"//"}, [2]string{"/*", "*/"}, "\"'", keywords(`abstract boolean break byte case catch char class
+		const continue default do double else enum extends final finally float for if implements import instanceof
+		int interface long new package private protected public return short static super switch this throw throws
+		try void volatile while null true false fun val var func let struct using namespace`)},
+	"c": {[]string{"//"}, [2]string{"/*", "*/"}, "\"'", keywords(`auto break case char const continue default do
+		double else enum extern float for goto if inline int long register return short signed sizeof static struct
+		switch typedef union unsigned void volatile while class namespace template public private protected
+		virtual new delete true false nullptr`)},
+	"rust": {[]string{"//"}, [2]string{"/*", "*/"}, "\"", keywords(`as break const continue crate else enum extern
+		false fn for if impl in let loop match mod move mut pub ref return self Self static struct super trait true
+		type unsafe use where while async await dyn`)},
+	"python": {[]string{"#"}, [2]string{}, "\"'", keywords(`and as assert async await break class continue def del
+		elif else except False finally for from global if import in is lambda None nonlocal not or pass raise
+		return True try while with yield`)},
+	"ruby": {[]string{"#"}, [2]string{}, "\"'", keywords(`alias and begin break case class def defined do else
+		elsif end ensure false for if in module next nil not or redo rescue retry return self super then true
+		undef unless until when while yield`)},
+	"sh":   {[]string{"#"}, [2]string{}, "\"'", keywords(`if then else elif fi case esac for while until do done in function return export local`)},
+	"yaml": {[]string{"#"}, [2]string{}, "\"'", keywords(`true false null yes no on off`)},
+	"sql": {[]string{"--"}, [2]string{"/*", "*/"}, "'\"", keywords(`select from where and or not insert into values
+		update set delete create table drop alter index join left right inner outer on group by order having
+		limit as null is in like distinct union primary key SELECT FROM WHERE AND OR NOT INSERT INTO VALUES UPDATE
+		SET DELETE CREATE TABLE DROP ALTER INDEX JOIN LEFT RIGHT INNER OUTER ON GROUP BY ORDER HAVING LIMIT AS
+		NULL IS IN LIKE DISTINCT UNION PRIMARY KEY`)},
+	"html": {nil, [2]string{"<!--", "-->"}, "\"'", nil},
+}
+
+// highlightCode escapes src and marks up its comments, strings, numbers and
+// keywords with spans (classes com, str, num, kw). It knows nothing of
+// grammar, which is enough to read code by; an unknown lang gets escaping
+// only.
+func highlightCode(src, lang string) string {
+	syn, ok := codeSyntaxes[strings.ToLower(lang)]
+	if !ok {
+		return html.EscapeString(src)
+	}
+	var b strings.Builder
+	span := func(class, text string) {
+		b.WriteString(`<span class="` + class + `">` + html.EscapeString(text) + `</span>`)
+	}
+	for i := 0; i < len(src); {
+		rest := src[i:]
+		if open := syn.blockComment[0]; open != "" && strings.HasPrefix(rest, open) {
+			end := strings.Index(rest[len(open):], syn.blockComment[1])
+			n := len(rest)
+			if end >= 0 {
+				n = len(open) + end + len(syn.blockComment[1])
+			}
+			span("com", rest[:n])
+			i += n
+			continue
+		}
+		if lineComment(rest, syn.lineComments) {
+			n := strings.IndexByte(rest, '\n')
+			if n < 0 {
+				n = len(rest)
+			}
+			span("com", rest[:n])
+			i += n
+			continue
+		}
+		c := rest[0]
+		switch {
+		case strings.IndexByte(syn.quotes, c) >= 0:
+			n := 1
+			for n < len(rest) && rest[n] != c && (c == '`' || rest[n] != '\n') {
+				if rest[n] == '\\' && c != '`' {
+					n++
+				}
+				n++
+			}
+			n = min(n+1, len(rest))
+			span("str", rest[:n])
+			i += n
+		case c >= '0' && c <= '9':
+			n := 1
+			for n < len(rest) && (isWordByte(rest[n]) || rest[n] == '.') {
+				n++
+			}
+			span("num", rest[:n])
+			i += n
+		case isWordByte(c):
+			n := 1
+			for n < len(rest) && isWordByte(rest[n]) {
+				n++
+			}
+			if syn.keywords[rest[:n]] {
+				span("kw", rest[:n])
+			} else {
+				b.WriteString(html.EscapeString(rest[:n]))
+			}
+			i += n
+		default:
+			_, n := utf8.DecodeRuneInString(rest)
+			b.WriteString(html.EscapeString(rest[:n]))
+			i += n
+		}
+	}
+	return b.String()
+}
+
+func lineComment(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
@@ -0,0 +1,56 @@
+package central
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"# Title", "<h1>Title</h1>\n"},
+		{"one\ntwo\n\nthree", "<p>one two</p>\n<p>three</p>\n"},
+		{"- a\n- *b*", "<ul>\n<li>a</li>\n<li><em>b</em></li>\n</ul>\n"},
+		{"1. a\n2. **b**", "<ol>\n<li>a</li>\n<li><strong>b</strong></li>\n</ol>\n"},
+		{"> quoted", "<blockquote>\n<p>quoted</p>\n</blockquote>\n"},
+		{"use `<b>` here", "<p>use <code>&lt;b&gt;</code> here</p>\n"},
+		{"[docs](https://x.example/a_b_c)", `<p><a href="https://x.example/a_b_c" rel="nofollow noopener">docs</a></p>` + "\n"},
+		{"[click](javascript:void)", "<p>click</p>\n"},
+		{"<script>alert(1)</script>", "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>\n"},
+		{"```\n<x>\n```", "<pre><code>&lt;x&gt;</code></pre>\n"},
+		{"---", "<hr>\n"},
+	}
+	for _, tt := range tests {
+		if got := renderMarkdown(tt.in); got != tt.want {
+			t.Errorf("renderMarkdown(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestHighlightCode(t *testing.T) {
+	got := highlightCode("func f() { return \"<a>\" } // done", "go")
+	want := `<span class="kw">func</span> f() { <span class="kw">return</span> <span class="str">&#34;&lt;a&gt;&#34;</span> } <span class="com">// done</span>`
+	if got != want {
+		t.Errorf("highlightCode = %s\nwant %s", got, want)
+	}
+	if got := highlightCode("<b>", "nope"); got != "&lt;b&gt;" {
+		t.Errorf("unknown language: %s", got)
+	}
+}
+
+func TestClusterPreview(t *testing.T) {
+	c := newTestCluster(t, 2, nil)
+	c.upload("README.md", "# Hello\n\nSome *text*.", nearLondon)
+	c.upload("main.go", "package main", nearLondon)
+	c.upload("blob.bin", "\x00\x01\x02", nearLondon)
+
+	if resp, body := c.get("/preview/README.md", nil); resp.StatusCode != http.StatusOK || !strings.Contains(body, "<h1>Hello</h1>") {
+		t.Errorf("markdown preview: status %d: %s", resp.StatusCode, body)
+	}
+	if _, body := c.get("/preview/main.go", nil); !strings.Contains(body, `<span class="kw">package</span>`) {
+		t.Errorf("code preview: %s", body)
+	}
+	if resp, _ := c.get("/preview/blob.bin", nil); resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("binary preview: status %d, want 415", resp.StatusCode)
+	}
+}
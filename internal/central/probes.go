@@ -0,0 +1,79 @@
+package central
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// ---------------------------
+// Health and Readiness Probes
+// ---------------------------
+
+// /healthz answers as long as the process can serve HTTP at all; failing
+// it means "restart me". /readyz also checks what requests need, templates,
+// a reachable storage node and the metadata on disk; failing it means "send
+// traffic elsewhere for now", e.g. while every node is down, where a
+// restart would not help.
+
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte("ok\n"))
+}
+
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{}
+	ready := true
+	for name, err := range readinessChecks() {
+		checks[name] = "ok"
+		if err != nil {
+			checks[name] = err.Error()
+			ready = false
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	status := "ready"
+	if !ready {
+		status = "not ready"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(struct {
+		Status string            `json:"status"`
+		Checks map[string]string `json:"checks"`
+	}{status, checks})
+}
+
+func readinessChecks() map[string]error {
+	checks := map[string]error{"change feed": feed.check()}
+
+	if templates == nil {
+		checks["templates"] = errors.New("not loaded")
+	} else {
+		checks["templates"] = nil
+	}
+
+	if fi, err := os.Stat(uploadDir); err != nil {
+		checks["upload dir"] = err
+	} else if !fi.IsDir() {
+		checks["upload dir"] = fmt.Errorf("%s is not a directory", uploadDir)
+	} else {
+		checks["upload dir"] = nil
+	}
+
+	nodes := topo.nodes()
+	checks["storage"] = errors.New("no storage server is reachable")
+	if len(nodes) == 0 {
+		checks["storage"] = errors.New("no storage servers known")
+	}
+	for _, s := range nodes {
+		if health.isHealthy(s.ID) {
+			checks["storage"] = nil
+			break
+		}
+	}
+	return checks
+}
@@ -0,0 +1,412 @@
+package central
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+)
+
+// ---------------------------
+// Upload Progress
+// ---------------------------
+const (
+	uploadReceiving   = "receiving"
+	uploadReplicating = "replicating"
+	uploadDone        = "done"
+	uploadPartial     = "partial" // stored, but the deadline cut replication short
+	uploadFailed      = "failed"
+)
+
+// Finished jobs stay queryable for this long.
+const uploadJobRetention = time.Hour
+
+type replicaProgress struct {
+	BytesSent  int64  `json:"bytes_sent"`
+	BytesTotal int64  `json:"bytes_total"`
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+
+	sent atomic.Int64
+}
+
+type uploadJob struct {
+	mu sync.Mutex
+
+	ID            string                      `json:"id"`
+	Filename      string                      `json:"filename,omitempty"`
+	Bucket        string                      `json:"bucket,omitempty"`
+	WriteConcern  string                      `json:"write_concern,omitempty"`
+	Striped       bool                        `json:"striped,omitempty"` // Replicas are by "<node>/<part>"
+	Status        string                      `json:"status"`
+	BytesTotal    int64                       `json:"bytes_total"` // what was left of the request body when the file started
+	BytesReceived int64                       `json:"bytes_received"`
+	Replicas      map[string]*replicaProgress `json:"replicas"`
+	Error         string                      `json:"error,omitempty"`
+	Processing    []string                    `json:"processing,omitempty"` // post-upload pipeline jobs
+	Started       time.Time                   `json:"started"`
+	Finished      *time.Time                  `json:"finished,omitempty"`
+
+	received  atomic.Int64
+	trace     *requestTrace // of the upload request, if it is being traced
+	replaces  bool          // the upload overwrites an existing file
+	user      string        // who uploaded it, when signed in
+	announced bool          // upload.complete was published
+}
+
+type uploadJobRegistry struct {
+	mu   sync.Mutex
+	jobs map[string]*uploadJob
+}
+
+var uploadJobs = &uploadJobRegistry{jobs: map[string]*uploadJob{}}
+
+var validJobID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+var errJobExists = errors.New("upload id already in use")
+
+// create registers a new job. Clients may pick the ID up front (so they can
+// poll progress while the request body is still streaming); otherwise one is
+// generated.
+func (reg *uploadJobRegistry) create(id string, total int64) (*uploadJob, error) {
+	if id == "" {
+		var b [12]byte
+		if _, err := rand.Read(b[:]); err != nil {
+			return nil, err
+		}
+		id = hex.EncodeToString(b[:])
+	} else if !validJobID.MatchString(id) {
+		return nil, errors.New("upload id must be 1-64 characters of [A-Za-z0-9_-]")
+	}
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	now := time.Now().UTC()
+	for jid, j := range reg.jobs {
+		j.mu.Lock()
+		expired := j.Finished != nil && now.Sub(*j.Finished) > uploadJobRetention
+		j.mu.Unlock()
+		if expired {
+			delete(reg.jobs, jid)
+		}
+	}
+	if _, ok := reg.jobs[id]; ok {
+		return nil, errJobExists
+	}
+
+	job := &uploadJob{
+		ID:         id,
+		Status:     uploadReceiving,
+		BytesTotal: total,
+		Replicas:   map[string]*replicaProgress{},
+		Started:    now,
+	}
+	reg.jobs[id] = job
+	return job, nil
+}
+
+func (reg *uploadJobRegistry) get(id string) (*uploadJob, bool) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	j, ok := reg.jobs[id]
+	return j, ok
+}
+
+func (j *uploadJob) setStatus(status string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.Status = status
+}
+
+func (j *uploadJob) setProcessing(ids []string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.Processing = ids
+}
+
+func (j *uploadJob) startReplica(nodeID string, total int64) *replicaProgress {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	rp := &replicaProgress{BytesTotal: total, Status: "sending"}
+	j.Replicas[nodeID] = rp
+	return rp
+}
+
+func (j *uploadJob) finishReplica(rp *replicaProgress, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err != nil {
+		rp.Status = uploadFailed
+		rp.Error = err.Error()
+		return
+	}
+	rp.Status = uploadDone
+	rp.BytesTotal = rp.sent.Load()
+}
+
+func (j *uploadJob) finish(err error) {
+	j.mu.Lock()
+	now := time.Now().UTC()
+	j.Finished = &now
+	if err != nil {
+		j.Status = uploadFailed
+		j.Error = err.Error()
+	} else {
+		j.Status = uploadDone
+	}
+	user, n, announce := j.noticeLocked()
+	j.mu.Unlock()
+	if announce {
+		publish(eventUploadComplete, []string{user}, n)
+	}
+}
+
+// finishPartial ends a job whose upload deadline tripped (err) after ok of
+// total replicas were written. With at least one replica the upload still
+// counts and errPartialReplication is returned; with none it failed.
+func (j *uploadJob) finishPartial(ok, total int, err error) error {
+	if ok == 0 {
+		err = fmt.Errorf("no replica written before the upload deadline: %w", err)
+		j.finish(err)
+		return err
+	}
+
+	j.mu.Lock()
+	now := time.Now().UTC()
+	j.Finished = &now
+	j.Status = uploadPartial
+	j.Error = fmt.Sprintf("%d of %d replicas written before the upload deadline: %v", ok, total, err)
+	user, n, announce := j.noticeLocked()
+	j.mu.Unlock()
+	if announce {
+		publish(eventUploadComplete, []string{user}, n)
+	}
+	return errPartialReplication
+}
+
+// noticeLocked returns the upload.complete notice for the uploader, the
+// first time j finishes: ok is false after that, and for anonymous uploads.
+func (j *uploadJob) noticeLocked() (user string, n uploadNotice, ok bool) {
+	if j.announced || j.user == "" || j.Filename == "" {
+		return "", n, false
+	}
+	j.announced = true
+	n = uploadNotice{File: displayName(j.Filename), Name: j.Filename, Status: j.Status, Error: j.Error, Replicas: len(j.Replicas)}
+	for _, rp := range j.Replicas {
+		if rp.Status == uploadDone {
+			n.Written++
+		}
+	}
+	return j.user, n, true
+}
+
+func (j *uploadJob) errorText() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.Error
+}
+
+// snapshot returns a consistent JSON encoding of the job.
+func (j *uploadJob) snapshot() ([]byte, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.BytesReceived = j.received.Load()
+	for _, rp := range j.Replicas {
+		rp.BytesSent = rp.sent.Load()
+	}
+	return json.Marshal(j)
+}
+
+// countingReader reports every read into an atomic counter.
+type countingReader struct {
+	r io.Reader
+	n *atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+type countingReadCloser struct {
+	countingReader
+	io.Closer
+}
+
+// jobCounter counts an upload request's body into the job of the file
+// being read. Bytes ahead of the first file count toward its job; n is the
+// running total, for the reading goroutine only.
+type jobCounter struct {
+	io.ReadCloser
+	job atomic.Pointer[uploadJob]
+	n   int64
+}
+
+func (c *jobCounter) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	c.job.Load().received.Add(int64(n))
+	return n, err
+}
+
+func uploadProgressHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := uploadJobs.get(r.PathValue("id"))
+	if !ok {
+		apierr.Send(w, "Unknown upload id", http.StatusNotFound)
+		return
+	}
+	b, err := job.snapshot()
+	if err != nil {
+		apierr.Send(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(b)
+}
+
+// ---------------------------
+// Transfer Progress
+// ---------------------------
+
+// Repairs and rebalancing copy whole files to a node, which for a large
+// file takes a while. Each copy in flight is tracked with the bytes it has
+// moved so far: counted as the central copy is sent, or asked of the node
+// while it copies from a peer (see copyFromPeer). They are listed in the
+// cluster status and at GET /api/v1/admin/replication/transfers.
+
+// How often a node copying from a peer is asked how far it got.
+var transferPollInterval = time.Second
+
+// TransferProgress is one repair or rebalancing copy in flight.
+type TransferProgress struct {
+	File        string    `json:"file"`
+	Node        string    `json:"node"`
+	From        string    `json:"from"` // "central", or the ID of the peer the node copies from
+	BytesCopied int64     `json:"bytes_copied"`
+	BytesTotal  int64     `json:"bytes_total"`
+	Started     time.Time `json:"started"`
+
+	copied atomic.Int64
+}
+
+type transferRegistry struct {
+	mu sync.Mutex
+	m  map[*TransferProgress]bool
+}
+
+var transfers = &transferRegistry{m: map[*TransferProgress]bool{}}
+
+func (reg *transferRegistry) start(file, node, from string, total int64) *TransferProgress {
+	t := &TransferProgress{File: file, Node: node, From: from, BytesTotal: total, Started: time.Now().UTC()}
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.m[t] = true
+	return t
+}
+
+func (reg *transferRegistry) finish(t *TransferProgress) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	delete(reg.m, t)
+}
+
+// list returns the copies in flight, oldest first.
+func (reg *transferRegistry) list() []TransferProgress {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	out := make([]TransferProgress, 0, len(reg.m))
+	for t := range reg.m {
+		out = append(out, TransferProgress{File: t.File, Node: t.Node, From: t.From,
+			BytesCopied: t.copied.Load(), BytesTotal: t.BytesTotal, Started: t.Started})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Started.Before(out[j].Started) })
+	return out
+}
+
+// countTransfer is a pushFileThrough wrap that counts the bytes sent into
+// t, within the maintenance bandwidth budget. If the transfer was booked
+// in the budget already, booked is the rate it was booked at, and the
+// bytes keep to it instead of being booked again.
+func countTransfer(t *TransferProgress, booked int64) func(context.Context, io.Reader) io.Reader {
+	return func(ctx context.Context, r io.Reader) io.Reader {
+		t.copied.Store(0) // a retry sends the file again
+		if booked > 0 {
+			r = &throttledReader{ctx: ctx, r: r, rate: &byteRate{perSec: booked}}
+		} else {
+			r = maintenance.throttle(ctx, r)
+		}
+		return &countingReader{r: r, n: &t.copied}
+	}
+}
+
+// followCopy asks s every transferPollInterval how far its copy of
+// filename from a peer has got, into t, until the returned stop is called.
+func followCopy(ctx context.Context, s StorageServer, filename string, t *TransferProgress) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		tick := time.NewTicker(transferPollInterval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-tick.C:
+			}
+			if n, ok := nodeCopyProgress(ctx, s, filename); ok {
+				t.copied.Store(n)
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// nodeCopyProgress returns how many bytes of filename s has copied from a
+// peer so far, from its GET /api/v1/replicate.
+func nodeCopyProgress(ctx context.Context, s StorageServer, filename string) (int64, bool) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL+"/api/v1/replicate", nil)
+	if err != nil {
+		return 0, false
+	}
+	resp, err := probeClient.Do(req)
+	if err != nil {
+		return 0, false
+	}
+	defer resp.Body.Close()
+	var copies []struct {
+		Name        string `json:"name"`
+		BytesCopied int64  `json:"bytes_copied"`
+	}
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&copies) != nil {
+		return 0, false
+	}
+	for _, c := range copies {
+		if c.Name == filename {
+			return c.BytesCopied, true
+		}
+	}
+	return 0, false
+}
+
+// transfersHandler lists the repair and rebalancing copies in flight:
+// GET /api/v1/admin/replication/transfers.
+func transfersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(transfers.list())
+}
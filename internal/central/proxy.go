@@ -0,0 +1,107 @@
+package central
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"path/filepath"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+)
+
+// ---------------------------
+// Download Proxy
+// ---------------------------
+
+// downloadHandler streams a file from the nearest healthy replica through the
+// central API. Range requests are passed through so interrupted downloads can
+// resume; when the client sends If-Range with the ETag it saw earlier, we
+// prefer a replica whose current ETag still matches, so a resume hours later
+// continues against identical content even if the nearest node changed.
+// ?read= can pick other copies (see readPreference); the central copy is
+// served directly. Composite files are assembled from their parts (see
+// serveManifest).
+func downloadHandler(w http.ResponseWriter, r *http.Request) {
+	filename := r.PathValue("filename")
+	if filename == "" {
+		apierr.Send(w, "filename required", http.StatusBadRequest)
+		return
+	}
+	if refuseQuarantined(w, filename) {
+		return
+	}
+	if refusePrivate(w, r, filename) {
+		return
+	}
+
+	client, err := locateClient(r)
+	if err != nil {
+		apierr.Send(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pref, err := parseReadPreference(r)
+	if err != nil {
+		apierr.Send(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if m, ok := manifests.get(filename); ok && pref.mode != readPrimary {
+		countDownload(r, filename, client)
+		// Parts are fetched whole: ranges are served from the central copy.
+		if r.Header.Get("Range") != "" && hasCentralCopy(filename) {
+			http.ServeFile(w, r, filepath.Join(uploadDir, filepath.Base(filename)))
+			return
+		}
+		serveManifest(w, r, m)
+		return
+	}
+
+	var target StorageServer
+	found := false
+	ifRange := r.Header.Get("If-Range")
+	for _, n := range pref.candidates(client) {
+		h, ok := headReplica(r.Context(), n.StorageServer, filename)
+		if !ok {
+			continue
+		}
+		if !found {
+			target, found = n.StorageServer, true
+		}
+		if r.Header.Get("Range") == "" || ifRange == "" || h.Get("ETag") == ifRange {
+			target = n.StorageServer
+			break
+		}
+	}
+	if !found && pref.usesCentral() && hasCentralCopy(filename) {
+		countDownload(r, filename, client)
+		http.ServeFile(w, r, filepath.Join(uploadDir, filepath.Base(filename)))
+		return
+	}
+	if !found {
+		apierr.Send(w, pref.notFound(filename), http.StatusNotFound)
+		return
+	}
+
+	u, err := url.Parse(nodeFileURL(target, filename))
+	if err != nil {
+		apierr.Send(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	proxy := &httputil.ReverseProxy{
+		Transport: nodeTransport,
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.Out.URL = u
+			pr.Out.Host = u.Host
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			resp.Header.Set("X-Storage-Node", target.ID)
+			return nil
+		},
+	}
+	countDownload(r, filename, client)
+	start := time.Now()
+	proxy.ServeHTTP(w, r)
+	traceFrom(r.Context()).node(target.ID, time.Since(start), nil)
+}
@@ -0,0 +1,102 @@
+package central
+
+import (
+	"bytes"
+	"fmt"
+	"image/png"
+	"net/http"
+	"strconv"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/qr"
+)
+
+// ---------------------------
+// QR Codes
+// ---------------------------
+
+// GET /qr/{filename} serves a QR code, as a PNG, for the file's share link,
+// so a phone can pick a file up off the file list's screen. The link is
+// the file's download (its /u/ or /t/ path for a home or team file), or,
+// with ?link={code}, that short link to it. ?scale= sets the pixels per
+// module, 8 by default.
+//
+// The link is absolute, so a phone can follow it: under Config.PublicURL,
+// or else the host and scheme the request came in on.
+var publicURL string
+
+const (
+	defaultQRScale = 8
+	maxQRScale     = 32
+)
+
+// absoluteURL returns path on this site as users reach it.
+func absoluteURL(r *http.Request, path string) string {
+	if publicURL != "" {
+		return publicURL + path
+	}
+	scheme := "http"
+	if r.TLS != nil || secureCookies {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + path
+}
+
+// shareLink returns the link to name a QR code hands out.
+func shareLink(r *http.Request, name string) (string, bool) {
+	if code := r.URL.Query().Get("link"); code != "" {
+		l, ok := shortLinks.get(code)
+		if !ok || l.Name != name {
+			return "", false
+		}
+		return absoluteURL(r, "/s/"+code), true
+	}
+	path := homePath(name)
+	if path == "" {
+		path = "/get/" + name
+	}
+	return absoluteURL(r, path), true
+}
+
+func qrHandler(w http.ResponseWriter, r *http.Request) {
+	filename := r.PathValue("filename")
+	if refuseQuarantined(w, filename) {
+		return
+	}
+	if refusePrivate(w, r, filename) {
+		return
+	}
+	scale := defaultQRScale
+	if v := r.URL.Query().Get("scale"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxQRScale {
+			apierr.Send(w, fmt.Sprintf("scale must be 1-%d", maxQRScale), http.StatusBadRequest)
+			return
+		}
+		scale = n
+	}
+	if !statFile(r.Context(), filename).Exists {
+		apierr.Send(w, "File not found", http.StatusNotFound)
+		return
+	}
+	link, ok := shareLink(r, filename)
+	if !ok {
+		apierr.Send(w, "No such link for this file", http.StatusNotFound)
+		return
+	}
+
+	code, err := qr.Encode([]byte(link), qr.M)
+	if err != nil {
+		apierr.Send(w, "Link too long for a QR code", http.StatusBadRequest)
+		return
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, code.Image(scale)); err != nil {
+		apierr.Send(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.Header().Set("Cache-Control", "private, max-age=300")
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,72 @@
+package central
+
+import (
+	"image"
+	"image/png"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/qr"
+)
+
+func TestQRCode(t *testing.T) {
+	c := newTestCluster(t, 1, func(cfg *Config) {
+		cfg.PublicURL = "https://files.example.com/"
+	})
+	if resp, _ := c.upload("a.txt", "hello", nearLondon); resp.StatusCode != http.StatusOK {
+		t.Fatalf("upload: %d", resp.StatusCode)
+	}
+	c.waitForJobs()
+	l, err := shortLinks.add(ShortLink{Name: "a.txt", Expires: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// scan fetches path and checks it is the code for want.
+	scan := func(path, want string, scale int) {
+		t.Helper()
+		resp, body := c.get(path, nil)
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "image/png" {
+			t.Fatalf("GET %s: %d %s", path, resp.StatusCode, resp.Header.Get("Content-Type"))
+		}
+		got, err := png.Decode(strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		code, _ := qr.Encode([]byte(want), qr.M)
+		if !sameImage(got, code.Image(scale)) {
+			t.Errorf("GET %s: not the code for %s", path, want)
+		}
+	}
+	scan("/qr/a.txt", "https://files.example.com/get/a.txt", defaultQRScale)
+	scan("/qr/a.txt?scale=2&link="+l.Code, "https://files.example.com/s/"+l.Code, 2)
+
+	for path, want := range map[string]int{
+		"/qr/missing.txt":         http.StatusNotFound,
+		"/qr/a.txt?link=nope":     http.StatusNotFound,
+		"/qr/a.txt?scale=0":       http.StatusBadRequest,
+		"/qr/a.txt?scale=1000000": http.StatusBadRequest,
+	} {
+		if resp, _ := c.get(path, nil); resp.StatusCode != want {
+			t.Errorf("GET %s: %d, want %d", path, resp.StatusCode, want)
+		}
+	}
+}
+
+func sameImage(a, b image.Image) bool {
+	if a.Bounds() != b.Bounds() {
+		return false
+	}
+	for y := a.Bounds().Min.Y; y < a.Bounds().Max.Y; y++ {
+		for x := a.Bounds().Min.X; x < a.Bounds().Max.X; x++ {
+			r1, g1, b1, _ := a.At(x, y).RGBA()
+			r2, g2, b2, _ := b.At(x, y).RGBA()
+			if r1 != r2 || g1 != g2 || b1 != b2 {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,394 @@
+package central
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+)
+
+// ---------------------------
+// Quarantine
+// ---------------------------
+
+// A flagged file (a failed scan, enough abuse reports, an admin's call) is
+// quarantined: nothing serves it until an admin releases it, or purges it
+// from the cluster. The central API refuses it with 451 on every read path,
+// and hands the list to every node, whose own /files/ refuse it too, so a
+// signed node URL handed out earlier stops working as well. The bytes stay
+// where they are until a purge.
+
+// QuarantineEntry is one quarantined file.
+type QuarantineEntry struct {
+	Name   string    `json:"name"`
+	Reason string    `json:"reason"`
+	Source string    `json:"source"` // what flagged it: "admin", "reports", a scanner
+	Since  time.Time `json:"since"`
+}
+
+var errQuarantined = errors.New("file is quarantined")
+
+type quarantineRegistry struct {
+	mu      sync.Mutex
+	path    string
+	entries map[string]QuarantineEntry
+	reports map[string]map[string]bool // reporter IPs by file, until it is quarantined
+}
+
+var quarantined = &quarantineRegistry{
+	entries: map[string]QuarantineEntry{},
+	reports: map[string]map[string]bool{},
+}
+
+// quarantineReports is how many distinct clients must report a file before
+// it is quarantined; 0 turns reports off.
+var quarantineReports int
+
+func (reg *quarantineRegistry) load(path string) error {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	reg.path = path
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, &reg.entries)
+}
+
+func (reg *quarantineRegistry) saveLocked() error {
+	if reg.path == "" {
+		return nil
+	}
+	raw, err := json.MarshalIndent(reg.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(reg.path), 0755); err != nil {
+		return err
+	}
+	tmp := reg.path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, reg.path)
+}
+
+func (reg *quarantineRegistry) has(name string) bool {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	_, ok := reg.entries[name]
+	return ok
+}
+
+// covers reports whether name is held back by the quarantine, itself or
+// as an HLS file of a quarantined video.
+func (reg *quarantineRegistry) covers(name string) bool {
+	if reg.has(name) {
+		return true
+	}
+	video, ok := hlsVideo(name)
+	return ok && reg.has(video)
+}
+
+// list returns the entries sorted by name.
+func (reg *quarantineRegistry) list() []QuarantineEntry {
+	reg.mu.Lock()
+	out := make([]QuarantineEntry, 0, len(reg.entries))
+	for _, e := range reg.entries {
+		out = append(out, e)
+	}
+	reg.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// names returns the quarantined names, sorted: the list the nodes get.
+func (reg *quarantineRegistry) names() []string {
+	entries := reg.list()
+	names := make([]string, len(entries))
+	for i, e := range entries {
+		names[i] = e.Name
+	}
+	return names
+}
+
+// add quarantines e.Name, keeping the first entry if it already is.
+func (reg *quarantineRegistry) add(e QuarantineEntry) (QuarantineEntry, bool) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if old, ok := reg.entries[e.Name]; ok {
+		return old, false
+	}
+	reg.entries[e.Name] = e
+	delete(reg.reports, e.Name)
+	if err := reg.saveLocked(); err != nil {
+		fmt.Println("Cannot save quarantine list:", err)
+	}
+	return e, true
+}
+
+// remove takes name off the list, reporting whether it was on it.
+func (reg *quarantineRegistry) remove(name string) bool {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if _, ok := reg.entries[name]; !ok {
+		return false
+	}
+	delete(reg.entries, name)
+	delete(reg.reports, name)
+	if err := reg.saveLocked(); err != nil {
+		fmt.Println("Cannot save quarantine list:", err)
+	}
+	return true
+}
+
+// report counts a report of name from reporter and returns how many
+// distinct reporters there have been. Reports are kept in memory only.
+func (reg *quarantineRegistry) report(name, reporter string) int {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if reg.reports[name] == nil {
+		reg.reports[name] = map[string]bool{}
+	}
+	reg.reports[name][reporter] = true
+	return len(reg.reports[name])
+}
+
+// quarantineDigest identifies a quarantine list the way storage nodes do
+// in /info, so a node that missed an update (or restarted without its
+// list) is noticed by the next probe.
+func quarantineDigest(names []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	sorted := append([]string(nil), names...)
+	sort.Strings(sorted)
+	sum := sha256.Sum256([]byte(strings.Join(sorted, "\n")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// quarantineDrifted reports whether a node's quarantine list, by the digest
+// in its /info, differs from the cluster's.
+func quarantineDrifted(digest string) bool {
+	return digest != quarantineDigest(withParts(quarantined.names()))
+}
+
+// quarantineFile flags name: the hook for scanners and abuse reports. The
+// nodes are told straight away; the errors are by node ID, and those nodes
+// get the list again when they are next probed.
+func quarantineFile(ctx context.Context, name, reason, source string) (QuarantineEntry, map[string]string) {
+	e, added := quarantined.add(QuarantineEntry{Name: name, Reason: reason, Source: source, Since: time.Now().UTC()})
+	if !added {
+		return e, nil
+	}
+	fmt.Printf("Quarantined %s (%s): %s\n", name, source, reason)
+	return e, syncQuarantine(ctx)
+}
+
+// syncQuarantine sends the quarantine list to every node and returns the
+// errors by node ID.
+func syncQuarantine(ctx context.Context) map[string]string {
+	names := withParts(quarantined.names())
+	var mu sync.Mutex
+	var errs map[string]string
+	var wg sync.WaitGroup
+	for _, s := range topo.nodes() {
+		wg.Add(1)
+		go func(s StorageServer) {
+			defer wg.Done()
+			if err := pushQuarantine(ctx, s, names); err != nil {
+				mu.Lock()
+				if errs == nil {
+					errs = map[string]string{}
+				}
+				errs[s.ID] = err.Error()
+				mu.Unlock()
+			}
+		}(s)
+	}
+	wg.Wait()
+	return errs
+}
+
+// pushQuarantine replaces a node's quarantine list, authenticated like
+// registration.
+func pushQuarantine(ctx context.Context, s StorageServer, names []string) error {
+	return pushNameList(ctx, s, "/api/v1/quarantine", names)
+}
+
+// pushNameList replaces one of the lists of names a node keeps for the
+// central API, at path.
+func pushNameList(ctx context.Context, s StorageServer, path string, names []string) error {
+	b, _ := json.Marshal(names)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.URL+path, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if registrationToken != "" {
+		req.Header.Set("Authorization", "Bearer "+registrationToken)
+	}
+	resp, err := probeClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return &statusError{Status: resp.StatusCode}
+	}
+	return nil
+}
+
+// refuseQuarantined answers 451 for a quarantined file and reports whether
+// it did.
+func refuseQuarantined(w http.ResponseWriter, name string) bool {
+	if !quarantined.covers(name) {
+		return false
+	}
+	apierr.Send(w, "File is quarantined", http.StatusUnavailableForLegalReasons)
+	return true
+}
+
+// guardQuarantined wraps the central copies' file server.
+func guardQuarantined(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if refuseQuarantined(w, filepath.Base(r.URL.Path)) {
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// quarantineResult is an admin action's entry, and the nodes that could
+// not be told about it.
+type quarantineResult struct {
+	QuarantineEntry
+	PushErrors map[string]string `json:"push_errors,omitempty"` // by node ID
+}
+
+// quarantineListHandler lists the quarantined files:
+// GET /api/v1/admin/quarantine.
+func quarantineListHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(quarantined.list())
+}
+
+// quarantineAddHandler quarantines a file by hand:
+// POST /api/v1/admin/quarantine {"name": ..., "reason": ...}.
+func quarantineAddHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name   string `json:"name"`
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<10)).Decode(&req); err != nil {
+		apierr.Send(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Name == "" || req.Name != filepath.Base(req.Name) {
+		apierr.Send(w, "Invalid name", http.StatusBadRequest)
+		return
+	}
+	e, errs := quarantineFile(r.Context(), req.Name, req.Reason, "admin")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(quarantineResult{e, errs})
+}
+
+// quarantineReleaseHandler lets a file be served again:
+// POST /api/v1/admin/quarantine/{name}/release.
+func quarantineReleaseHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !quarantined.remove(name) {
+		apierr.Send(w, "Not quarantined", http.StatusNotFound)
+		return
+	}
+	fmt.Println("Released from quarantine:", name)
+	errs := syncQuarantine(r.Context())
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(quarantineResult{QuarantineEntry{Name: name}, errs})
+}
+
+// quarantinePurgeHandler deletes a quarantined file from the central API
+// and every node: DELETE /api/v1/admin/quarantine/{name}. It stays on the
+// list until the deletes are through, so nothing serves it meanwhile.
+func quarantinePurgeHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !quarantined.has(name) {
+		apierr.Send(w, "Not quarantined", http.StatusNotFound)
+		return
+	}
+	if refuseLocked(w, name) {
+		return
+	}
+	deleteEverywhere(r.Context(), name)
+	quarantined.remove(name)
+	fmt.Println("Purged from quarantine:", name)
+	errs := syncQuarantine(r.Context())
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(quarantineResult{QuarantineEntry{Name: name}, errs})
+}
+
+// reportHandler takes an abuse report: POST /api/v1/files/{name}/report,
+// with an optional reason. Once quarantineReports distinct reporters have
+// reported a file, it is quarantined.
+func reportHandler(w http.ResponseWriter, r *http.Request) {
+	if quarantineReports == 0 {
+		apierr.Send(w, "Reports are not enabled (quarantine_reports)", http.StatusNotImplemented)
+		return
+	}
+	name := r.PathValue("name")
+	if !quarantined.has(name) && !statFile(r.Context(), name).Exists {
+		http.NotFound(w, r)
+		return
+	}
+	reason := r.FormValue("reason")
+	if len(reason) > 500 {
+		reason = reason[:500]
+	}
+	n := 0
+	if !quarantined.has(name) {
+		n = quarantined.report(name, reporter(r))
+		if n >= quarantineReports {
+			if reason == "" {
+				reason = "abuse reports"
+			}
+			quarantineFile(r.Context(), name, fmt.Sprintf("%d reports, last: %s", n, reason), "reports")
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(struct {
+		Reports     int  `json:"reports,omitempty"`
+		Quarantined bool `json:"quarantined"`
+	}{n, quarantined.has(name)})
+}
+
+// reporter is who r counts as for abuse reports: the signed-in user, else
+// the address the request came from. Forwarding headers are not trusted
+// here, as a client could rotate them to report a file many times over.
+func reporter(r *http.Request) string {
+	if id, ok := authenticate(r); ok {
+		return "user " + id.Name
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
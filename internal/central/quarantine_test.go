@@ -0,0 +1,147 @@
+package central
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/storage"
+)
+
+// TestClusterQuarantine flags a file by hand and by reports, and checks
+// that neither the central API nor any node serves it until it is
+// released, and that a purge removes it everywhere.
+func TestClusterQuarantine(t *testing.T) {
+	c := newTestCluster(t, 3, func(cfg *Config) {
+		cfg.AdminToken = "s3cret"
+		cfg.QuarantineReports = 2
+	})
+	for _, name := range []string{"bad.txt", "spam.txt"} {
+		if resp, _ := c.upload(name, "contents of "+name, nearLondon); resp.StatusCode != http.StatusOK {
+			t.Fatalf("upload %s: %d", name, resp.StatusCode)
+		}
+	}
+
+	admin := func(method, path, body string) (*http.Response, string) {
+		t.Helper()
+		req, _ := http.NewRequest(method, c.central.URL+path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer s3cret")
+		resp, err := testClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp, string(b)
+	}
+	served := func(name string) map[string]int {
+		t.Helper()
+		got := map[string]int{}
+		resp, _ := c.get("/get/"+name+"?"+nearLondon.Encode(), nil)
+		got["get"] = resp.StatusCode
+		resp, _ = c.get("/files/"+name, nil)
+		got["central"] = resp.StatusCode
+		for _, n := range c.nodes {
+			resp, err := http.Get(n.URL + "/files/" + name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			got[n.ID] = resp.StatusCode
+		}
+		return got
+	}
+	all := func(got map[string]int, want int) bool {
+		for _, status := range got {
+			if status != want && !(want == http.StatusOK && status == http.StatusFound) {
+				return false
+			}
+		}
+		return true
+	}
+
+	resp, body := admin("POST", "/api/v1/admin/quarantine", `{"name": "bad.txt", "reason": "failed scan"}`)
+	if resp.StatusCode != http.StatusOK || strings.Contains(body, "push_errors") {
+		t.Fatalf("quarantine: status %d: %s", resp.StatusCode, body)
+	}
+	if got := served("bad.txt"); !all(got, http.StatusUnavailableForLegalReasons) {
+		t.Errorf("quarantined file served: %v", got)
+	}
+	if got := served("spam.txt"); !all(got, http.StatusOK) {
+		t.Errorf("other file not served: %v", got)
+	}
+	if !c.listing()["bad.txt"].Quarantined {
+		t.Error("listing does not show the quarantine")
+	}
+
+	// A node that lost its list gets it back on the next probe.
+	os.Remove(filepath.Join(c.node("ny").dir, "quarantine.json"))
+	c.inject("ny", storage.FaultConfig{})
+	if got := served("bad.txt"); got["ny"] != http.StatusOK {
+		t.Fatalf("restarted node without a list: %v", got)
+	}
+	probeNode(c.node("ny").StorageServer)
+	if got := served("bad.txt"); !all(got, http.StatusUnavailableForLegalReasons) {
+		t.Errorf("after probe: %v", got)
+	}
+
+	resp, body = admin("GET", "/api/v1/admin/quarantine", "")
+	var entries []QuarantineEntry
+	if err := json.Unmarshal([]byte(body), &entries); err != nil || len(entries) != 1 || entries[0].Reason != "failed scan" || entries[0].Source != "admin" {
+		t.Errorf("list: status %d: %s", resp.StatusCode, body)
+	}
+
+	resp, _ = admin("POST", "/api/v1/admin/quarantine/bad.txt/release", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("release: %d", resp.StatusCode)
+	}
+	if got := served("bad.txt"); !all(got, http.StatusOK) {
+		t.Errorf("released file not served: %v", got)
+	}
+
+	// Two distinct reporters quarantine a file; the same one twice does
+	// not, whatever it puts in X-Forwarded-For.
+	report := func(from, forwardedFor string) {
+		t.Helper()
+		dialer := &net.Dialer{LocalAddr: &net.TCPAddr{IP: net.ParseIP(from)}}
+		client := &http.Client{Transport: &http.Transport{DialContext: dialer.DialContext}}
+		req, _ := http.NewRequest("POST", c.central.URL+"/api/v1/files/spam.txt/report?reason=spam", nil)
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusAccepted {
+			t.Fatalf("report: %d", resp.StatusCode)
+		}
+	}
+	report("127.0.0.1", "203.0.113.1")
+	report("127.0.0.1", "203.0.113.2")
+	if quarantined.has("spam.txt") {
+		t.Fatal("quarantined after one reporter")
+	}
+	report("127.0.0.2", "203.0.113.1")
+	if got := served("spam.txt"); !all(got, http.StatusUnavailableForLegalReasons) {
+		t.Errorf("reported file served: %v", got)
+	}
+
+	resp, _ = admin("DELETE", "/api/v1/admin/quarantine/spam.txt", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("purge: %d", resp.StatusCode)
+	}
+	if h := c.holders("spam.txt"); len(h) != 0 || hasCentralCopy("spam.txt") {
+		t.Errorf("purged file still on %v", h)
+	}
+	if quarantined.has("spam.txt") {
+		t.Error("purged file still quarantined")
+	}
+	if resp, _ := admin("DELETE", "/api/v1/admin/quarantine/spam.txt", ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("second purge: %d", resp.StatusCode)
+	}
+}
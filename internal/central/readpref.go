@@ -0,0 +1,100 @@
+package central
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ---------------------------
+// Read Preference
+// ---------------------------
+
+// Downloads and listings take ?read= to say which copies they may be served
+// from:
+//
+//   - nearest (the default): the nearest healthy replica
+//   - primary: the central API's own copy, the source of truth, e.g. to read
+//     an upload back before async replication has caught up
+//   - any: the nearest replica on any node, healthy or not, then the central
+//     copy, for when being served at all matters more than being served fast
+//   - region=X: only replicas on nodes in region (or zone) X, for data
+//     residency; the read fails rather than leave the region
+const (
+	readNearest = "nearest"
+	readPrimary = "primary"
+	readAny     = "any"
+	readRegion  = "region"
+)
+
+type readPreference struct {
+	mode   string
+	region string // for readRegion
+}
+
+func parseReadPreference(r *http.Request) (readPreference, error) {
+	v := r.URL.Query().Get("read")
+	switch {
+	case v == "" || v == readNearest:
+		return readPreference{mode: readNearest}, nil
+	case v == readPrimary || v == readAny:
+		return readPreference{mode: v}, nil
+	case strings.HasPrefix(v, readRegion+"=") && len(v) > len(readRegion)+1:
+		return readPreference{mode: readRegion, region: v[len(readRegion)+1:]}, nil
+	}
+	return readPreference{}, fmt.Errorf("unknown read preference %q (want nearest, primary, any or region=<name>)", v)
+}
+
+// candidates returns the nodes p allows reading from, in the order to try
+// them.
+func (p readPreference) candidates(c clientRef) []rankedNode {
+	var out []rankedNode
+	for _, n := range preferHealthy(rankStorages(c)) {
+		switch p.mode {
+		case readPrimary:
+			continue
+		case readRegion:
+			if !inRegion(n.StorageServer, p.region) {
+				continue
+			}
+		}
+		if p.mode != readAny && !health.isHealthy(n.ID) {
+			continue
+		}
+		out = append(out, n)
+	}
+	return out
+}
+
+// usesCentral reports whether p may read the central copy.
+func (p readPreference) usesCentral() bool {
+	return p.mode == readPrimary || p.mode == readAny
+}
+
+// hasCentralCopy reports whether the central API holds filename.
+func hasCentralCopy(filename string) bool {
+	fi, err := os.Stat(filepath.Join(uploadDir, filepath.Base(filename)))
+	return err == nil && fi.Mode().IsRegular()
+}
+
+// notFound describes a read p could not place.
+func (p readPreference) notFound(filename string) string {
+	switch p.mode {
+	case readPrimary:
+		return "The central API has no copy of " + filename
+	case readRegion:
+		return "No healthy storage server in " + p.region + " holds " + filename
+	case readAny:
+		return "No copy of " + filename + " is reachable"
+	}
+	return "No healthy storage server holds " + filename
+}
+
+// inRegion reports whether s is in region: the region it reports about
+// itself, or its zone.
+func inRegion(s StorageServer, region string) bool {
+	info, _ := identities.get(s.ID)
+	return strings.EqualFold(info.Region, region) || strings.EqualFold(s.Zone, region)
+}
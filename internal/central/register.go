@@ -0,0 +1,196 @@
+package central
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+)
+
+// ---------------------------
+// Node Registration
+// ---------------------------
+var registrationToken string
+
+type registrationRequest struct {
+	ID            string  `json:"id"`
+	Label         string  `json:"label"`
+	URL           string  `json:"url"`
+	Lat           float64 `json:"lat"`
+	Lon           float64 `json:"lon"`
+	Region        string  `json:"region"`
+	Zone          string  `json:"zone"`
+	NodeUUID      string  `json:"node_uuid"`
+	Version       string  `json:"version"`
+	Commit        string  `json:"commit"`
+	BuildTime     string  `json:"build_time"`
+	CapacityBytes int64   `json:"capacity_bytes"`
+	Socket        string  `json:"socket"`
+}
+
+// checkRegistrationToken reports whether r bears the registration token.
+// Without a configured token every node call is refused: there is no
+// telling a node from anyone else, who could register to be sent uploads.
+func checkRegistrationToken(r *http.Request) bool {
+	if registrationToken == "" {
+		return false
+	}
+	got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(got), []byte(registrationToken)) == 1
+}
+
+func decodeRegistration(w http.ResponseWriter, r *http.Request) (registrationRequest, bool) {
+	var req registrationRequest
+	if !checkRegistrationToken(r) {
+		apierr.Send(w, "Invalid registration token", http.StatusUnauthorized)
+		return req, false
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		apierr.Send(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return req, false
+	}
+	if req.ID == "" || req.NodeUUID == "" {
+		apierr.Send(w, "id and node_uuid are required", http.StatusBadRequest)
+		return req, false
+	}
+	return req, true
+}
+
+// registerNodeHandler adds (or refreshes) a storage node. Nodes call it on
+// boot and periodically afterwards, so a restarted central API relearns the
+// topology within one interval.
+func registerNodeHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeRegistration(w, r)
+	if !ok {
+		return
+	}
+
+	s := StorageServer{
+		ID:       req.ID,
+		Label:    req.Label,
+		URL:      strings.TrimSuffix(req.URL, "/"),
+		Lat:      req.Lat,
+		Lon:      req.Lon,
+		Zone:     req.Zone,
+		Capacity: req.CapacityBytes,
+		Socket:   req.Socket,
+	}
+	if s.Label == "" {
+		s.Label = s.ID
+	}
+	if s.Zone == "" {
+		s.Zone = req.Region
+	}
+
+	if _, err := identities.observe(s, NodeInfo{
+		ID:        req.NodeUUID,
+		Region:    req.Region,
+		Version:   req.Version,
+		Commit:    req.Commit,
+		BuildTime: req.BuildTime,
+	}); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, errIdentityMismatch) {
+			status = http.StatusConflict
+		}
+		apierr.Send(w, err.Error(), status)
+		return
+	}
+	if err := topo.register(s); err != nil {
+		apierr.Send(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	health.set(s.ID, nil)
+
+	fmt.Println("Registered node", s.ID, "at", s.URL)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s)
+}
+
+// deregisterNodeHandler removes a registration on node shutdown. Statically
+// configured nodes stay in the topology (and will show as down).
+func deregisterNodeHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeRegistration(w, r)
+	if !ok {
+		return
+	}
+
+	if info, _ := identities.get(req.ID); info.ID != "" && info.ID != req.NodeUUID {
+		apierr.Send(w, "node_uuid does not match the registered node", http.StatusConflict)
+		return
+	}
+	if !topo.deregister(req.ID) {
+		apierr.Send(w, "Node not registered", http.StatusNotFound)
+		return
+	}
+
+	fmt.Println("Deregistered node", req.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// repairReplicaHandler is called by a storage node whose scrubber found its
+// copy of a file corrupt. The central copy is pushed over it in the
+// background, behind the uploads in the replication queue if there is one,
+// in a maintenance window; the node clears the corrupt flag once the file
+// is rewritten.
+func repairReplicaHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkRegistrationToken(r) {
+		apierr.Send(w, "Invalid registration token", http.StatusUnauthorized)
+		return
+	}
+	var req struct {
+		ID       string `json:"id"`
+		NodeUUID string `json:"node_uuid"`
+		File     string `json:"file"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		apierr.Send(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	name := filepath.Base(req.File)
+	if req.ID == "" || req.File == "" || name == "." || name == "/" {
+		apierr.Send(w, "id and file are required", http.StatusBadRequest)
+		return
+	}
+	if info, _ := identities.get(req.ID); info.ID != "" && info.ID != req.NodeUUID {
+		apierr.Send(w, "node_uuid does not match the registered node", http.StatusConflict)
+		return
+	}
+	s, ok := topo.get(req.ID)
+	if !ok {
+		apierr.Send(w, "Node not registered", http.StatusNotFound)
+		return
+	}
+	if _, err := os.Stat(filepath.Join(uploadDir, name)); err != nil {
+		apierr.Send(w, "No central copy of "+name, http.StatusNotFound)
+		return
+	}
+
+	fmt.Println("Node", s.ID, "reports", name, "corrupt, pushing the central copy")
+	if queued, err := queueRepair(name, s); queued {
+		if err != nil {
+			apierr.Send(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	goBackground(func() {
+		waitForMaintenance(context.Background())
+		ctx, cancel := context.WithTimeout(context.Background(), uploadTimeout)
+		defer cancel()
+		if err := bulkPush(ctx, s, name); err != nil {
+			fmt.Println("Repair of", name, "on", s.ID, "failed:", err)
+			return
+		}
+		fmt.Println("Repaired", name, "on", s.ID)
+	})
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,294 @@
+package central
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/checksum"
+)
+
+// ---------------------------
+// Policy
+// ---------------------------
+
+// NodeLimits are the space limits the central API keeps a storage node to
+// (the node's /api/v1/limits). Zero means no quota, or no free space floor.
+type NodeLimits struct {
+	QuotaBytes   int64 `json:"quota_bytes"`
+	QuotaFiles   int64 `json:"quota_files"`
+	MinFreeBytes int64 `json:"min_free_bytes"`
+}
+
+// policySettings are the settings that decide where files go and stay. A
+// reload swaps them as a whole; code that loads them once with
+// currentPolicy sees one consistent set for the whole request.
+type policySettings struct {
+	placement          PlacementStrategy
+	distance           DistanceModel
+	maxReplicasPerZone int // 0 = no cap
+	gcPolicy           string
+	gcGracePeriod      time.Duration
+	nodeLimits         map[string]NodeLimits // by node ID
+	features           map[string]bool       // every flag, resolved
+	retentionRules     []RetentionRule
+	retentionEnforce   bool
+	checksumAlgorithm  string
+}
+
+var policy atomic.Pointer[policySettings]
+
+// defaultPolicy is in effect until a config is applied.
+var defaultPolicy = policySettings{
+	placement:         defaultPlacement{},
+	distance:          geoDistance{},
+	gcPolicy:          gcReport,
+	gcGracePeriod:     time.Hour,
+	features:          resolveFeatures(nil),
+	checksumAlgorithm: checksum.Default,
+}
+
+func currentPolicy() *policySettings {
+	if p := policy.Load(); p != nil {
+		return p
+	}
+	return &defaultPolicy
+}
+
+func newPolicy(cfg Config) (*policySettings, error) {
+	placement, err := newPlacementStrategy(cfg.Placement)
+	if err != nil {
+		return nil, err
+	}
+	distance, err := newDistanceModel(cfg.DistanceModel)
+	if err != nil {
+		return nil, err
+	}
+	return &policySettings{
+		placement:          placement,
+		distance:           distance,
+		maxReplicasPerZone: cfg.MaxReplicasPerZone,
+		gcPolicy:           cfg.GCPolicy,
+		gcGracePeriod:      cfg.GCGracePeriod.Duration,
+		nodeLimits:         cfg.NodeLimits,
+		features:           resolveFeatures(cfg.Features),
+		retentionRules:     cfg.RetentionRules,
+		retentionEnforce:   cfg.RetentionEnforce,
+		checksumAlgorithm:  cfg.ChecksumAlgorithm,
+	}, nil
+}
+
+// ---------------------------
+// Node Limits
+// ---------------------------
+
+// limitsDrifted reports whether a node's quota, as it reported it in
+// /info, differs from what is configured for it: the node restarted with
+// its own settings, or has not been told yet.
+func limitsDrifted(nodeID string, q *QuotaStatus) (NodeLimits, bool) {
+	want, ok := currentPolicy().nodeLimits[nodeID]
+	if !ok {
+		return want, false
+	}
+	var maxBytes, maxFiles int64
+	if q != nil {
+		maxBytes, maxFiles = q.MaxBytes, q.MaxFiles
+	}
+	return want, maxBytes != want.QuotaBytes || maxFiles != want.QuotaFiles
+}
+
+// pushNodeLimits sets a node's limits, authenticated like registration.
+func pushNodeLimits(ctx context.Context, s StorageServer, l NodeLimits) error {
+	b, _ := json.Marshal(l)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.URL+"/api/v1/limits", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if registrationToken != "" {
+		req.Header.Set("Authorization", "Bearer "+registrationToken)
+	}
+	resp, err := probeClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return &statusError{Status: resp.StatusCode}
+	}
+	return nil
+}
+
+// ---------------------------
+// Config Reload
+// ---------------------------
+
+// reloadable are the settings (by JSON key) a reload applies in place.
+// Listeners, directories, background loops, the replication queue and the
+// node client are set up once, so changes to anything else are reported and
+// wait for a restart.
+var reloadable = map[string]bool{
+	"storages":              true,
+	"replication_factor":    true,
+	"placement":             true,
+	"max_replicas_per_zone": true,
+	"distance_model":        true,
+	"gc_policy":             true,
+	"gc_grace_period":       true,
+	"node_limits":           true,
+	"features":              true,
+	"retention_rules":       true,
+	"retention_enforce":     true,
+	"checksum_algorithm":    true,
+}
+
+// storeManaged are the settings a config store owns when there is one
+// (see applyClusterConfig); the file's values are not applied over it.
+var storeManaged = map[string]bool{"storages": true, "replication_factor": true}
+
+// ReloadReport is what a reload changed.
+type ReloadReport struct {
+	Applied       []string          `json:"applied"`
+	RestartNeeded []string          `json:"restart_needed,omitempty"`
+	StoreManaged  []string          `json:"store_managed,omitempty"`
+	LimitErrors   map[string]string `json:"limit_errors,omitempty"` // by node ID
+}
+
+var (
+	reloadMu sync.Mutex
+	running  Config // as applied, plus the reloads since
+)
+
+// reloadConfig reads the config again (CONFIG_FILE and the environment)
+// and applies what can change without a restart. Uploads in flight keep
+// the placement they started with. A config that does not validate is
+// rejected whole.
+func reloadConfig(ctx context.Context) (ReloadReport, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return ReloadReport{}, err
+	}
+	if errs := cfg.validate(); len(errs) > 0 {
+		return ReloadReport{}, errors.Join(errs...)
+	}
+	p, err := newPolicy(cfg)
+	if err != nil {
+		return ReloadReport{}, err
+	}
+
+	report := ReloadReport{Applied: []string{}}
+	next := cfg
+	for _, key := range changedSettings(running, cfg) {
+		switch {
+		case !reloadable[key]:
+			report.RestartNeeded = append(report.RestartNeeded, key)
+		case storeManaged[key] && cfg.ConfigStore != "":
+			report.StoreManaged = append(report.StoreManaged, key)
+		default:
+			report.Applied = append(report.Applied, key)
+		}
+	}
+	// What was not applied stays as it was.
+	if err := keepSettings(&next, running, append(report.RestartNeeded, report.StoreManaged...)); err != nil {
+		return ReloadReport{}, err
+	}
+
+	if cfg.ConfigStore == "" {
+		topo.setStatic(next.Storages)
+		replicationFactor.Store(int32(next.ReplicationFactor))
+	}
+	policy.Store(p)
+	running = next
+
+	for _, s := range topo.nodes() {
+		l, ok := p.nodeLimits[s.ID]
+		if !ok {
+			continue
+		}
+		if err := pushNodeLimits(ctx, s, l); err != nil {
+			if report.LimitErrors == nil {
+				report.LimitErrors = map[string]string{}
+			}
+			report.LimitErrors[s.ID] = err.Error()
+		}
+	}
+	fmt.Printf("Config reloaded: applied %v, restart needed for %v\n", report.Applied, report.RestartNeeded)
+	return report, nil
+}
+
+// changedSettings returns the JSON keys whose values differ between a and
+// b, sorted.
+func changedSettings(a, b Config) []string {
+	am, bm := settingsMap(a), settingsMap(b)
+	var changed []string
+	for key, v := range bm {
+		if !bytes.Equal(am[key], v) {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+func settingsMap(c Config) map[string]json.RawMessage {
+	raw, _ := json.Marshal(c)
+	m := map[string]json.RawMessage{}
+	json.Unmarshal(raw, &m)
+	return m
+}
+
+// keepSettings sets the settings named by keys in c back to old's.
+func keepSettings(c *Config, old Config, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	m, om := settingsMap(*c), settingsMap(old)
+	for _, key := range keys {
+		m[key] = om[key]
+	}
+	raw, _ := json.Marshal(m)
+	var merged Config
+	if err := json.Unmarshal(raw, &merged); err != nil {
+		return err
+	}
+	*c = merged
+	return nil
+}
+
+// reloadHandler reloads on demand: POST /api/v1/admin/reload.
+func reloadHandler(w http.ResponseWriter, r *http.Request) {
+	report, err := reloadConfig(r.Context())
+	if err != nil {
+		apierr.Send(w, "Reload rejected: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// reloadOnSIGHUP reloads whenever the process gets SIGHUP.
+func reloadOnSIGHUP() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	for range sig {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		if _, err := reloadConfig(ctx); err != nil {
+			fmt.Println("Reload rejected:", err)
+		}
+		cancel()
+	}
+}
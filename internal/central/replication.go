@@ -0,0 +1,335 @@
+package central
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+// ---------------------------
+// Replication Strategies
+// ---------------------------
+
+// Replicator copies an uploaded file to its target nodes. Replicate returns
+// once the upload can be acknowledged to the client; from then on the
+// replicator owns the job and must eventually call job.finish, even when it
+// returns an error. ctx is the upload's request context with the upload
+// deadline; work that outlives the request must not use it as is.
+//
+// Replicate is called while the file is still streaming in. Replicators
+// that copy right away get it through the payload's pipes (replicateOne
+// does that); others should read it from disk once it is complete.
+type Replicator interface {
+	Replicate(ctx context.Context, job *uploadJob, filename string, p *payload, targets []StorageServer) error
+}
+
+// streams reports whether a replicator copies while the upload arrives, and
+// so should be fed through pipes.
+func streams(r Replicator) bool {
+	switch r.(type) {
+	case *asyncReplicator, stripeReplicator:
+		return false
+	}
+	return true
+}
+
+// BucketConfig holds per-bucket settings. Uploads pick a bucket with the
+// "bucket" form field; the default bucket uses Config.Replication.
+type BucketConfig struct {
+	// Replication is "sync", "async" or "quorum".
+	Replication string `json:"replication"`
+
+	// Retention locks each file uploaded to the bucket for this long (see
+	// objectlock.go); 0 does not.
+	Retention Duration `json:"retention"`
+}
+
+var validBucket = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{0,62}$`)
+
+var (
+	defaultReplicator Replicator = syncReplicator{}
+	bucketReplicators            = map[string]Replicator{}
+	// asyncQueue is the queue shared by the async buckets, nil if none is.
+	asyncQueue *asyncReplicator
+)
+
+// errReplicationBusy means the async queue is full.
+var errReplicationBusy = errors.New("replication queue is full, try again later")
+
+// setupReplicators builds the replicator for the default bucket and every
+// configured one. Buckets using async share one queue.
+func setupReplicators(cfg Config) error {
+	var async *asyncReplicator
+	build := func(mode string) (Replicator, error) {
+		switch mode {
+		case "", "sync":
+			return syncReplicator{}, nil
+		case "quorum":
+			return quorumReplicator{}, nil
+		case "async":
+			if async == nil {
+				async = newAsyncReplicator(cfg.ReplicationWorkers, cfg.ReplicationQueueSize)
+			}
+			return async, nil
+		}
+		return nil, fmt.Errorf("unknown replication %q (want sync, async or quorum)", mode)
+	}
+
+	r, err := build(cfg.Replication)
+	if err != nil {
+		return err
+	}
+	defaultReplicator = r
+	for name, b := range cfg.Buckets {
+		r, err := build(b.Replication)
+		if err != nil {
+			return fmt.Errorf("bucket %s: %w", name, err)
+		}
+		bucketReplicators[name] = r
+	}
+	asyncQueue = async
+	return nil
+}
+
+// replicatorFor returns the replicator for bucket ("" is the default bucket).
+func replicatorFor(bucket string) (Replicator, bool) {
+	if bucket == "" {
+		return defaultReplicator, true
+	}
+	r, ok := bucketReplicators[bucket]
+	return r, ok
+}
+
+// replicateOne sends the file to a single node, recording progress on job.
+// The first attempt streams the upload as it arrives if the node has a pipe
+// in the payload; that attempt's node timeout only starts once the whole
+// file is in. Retries read the central API's copy on disk.
+func replicateOne(ctx context.Context, job *uploadJob, s StorageServer, filename string, p *payload) error {
+	rp := job.startReplica(s.ID, p.expected)
+	start := time.Now()
+	var status int
+	var body string
+	attempts := 0
+	send := func(ctx context.Context, file io.Reader, size int64) error {
+		attempts++
+		rp.sent.Store(0)
+		var err error
+		status, body, err = forwardFileTo(ctx, s.URL, filename, p.alg, file, size, p.expected, &rp.sent)
+		if err == nil && status != http.StatusOK {
+			err = &statusError{Status: status, Body: body}
+		}
+		return err
+	}
+	fromDisk := func(ctx context.Context) error {
+		f, size, err := p.open(ctx)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return send(ctx, f, size)
+	}
+
+	var err error
+	if live := p.take(s.ID); live != nil {
+		liveCtx, cancel := p.afterReceive(ctx, nodeTimeout)
+		err = attemptNode(ctx, liveCtx, s, opUpload, func(ctx context.Context) error {
+			return send(ctx, live, -1)
+		})
+		cancel()
+		live.Close()
+		if err != nil && retryable(err) && ctx.Err() == nil && retries.Attempts > 1 {
+			policy := retries
+			policy.Attempts--
+			err = callNodeWith(policy, ctx, s, opUpload, fromDisk)
+		}
+	} else {
+		err = callNode(ctx, s, opUpload, fromDisk)
+	}
+
+	job.trace.node(s.ID, time.Since(start), err)
+	job.finishReplica(rp, err)
+	if err != nil {
+		fmt.Println("Replication error to", s.URL, "after", attempts, "attempt(s):", err)
+	} else {
+		fmt.Println("Replicated to", s.URL, "Status:", status, "Body:", body)
+	}
+	if outOfSpace(err) {
+		capacity.markFull(s.ID, err.Error())
+		if spare, ok := p.nextSpare(); ok {
+			fmt.Println("Node", s.ID, "is out of space, placing the replica on", spare.ID, "instead")
+			return replicateOne(ctx, job, spare, filename, p)
+		}
+	}
+	replicaFailures.record(filename, s.ID, err)
+	return err
+}
+
+// outOfSpace reports whether a node refused a file for lack of space or
+// because it is over its quota.
+func outOfSpace(err error) bool {
+	var se *statusError
+	return errors.As(err, &se) && se.Status == http.StatusInsufficientStorage
+}
+
+// syncReplicator copies to all targets in parallel and acknowledges once
+// every one has finished. Failed replicas are reported on the job but do
+// not fail the upload. When the upload deadline trips first, that is a
+// partial success if any replica made it, and a failure otherwise.
+type syncReplicator struct{}
+
+func (syncReplicator) Replicate(ctx context.Context, job *uploadJob, filename string, p *payload, targets []StorageServer) error {
+	results := make(chan error, len(targets))
+	for _, s := range targets {
+		go func(s StorageServer) {
+			results <- replicateOne(ctx, job, s, filename, p)
+		}(s)
+	}
+	ok := 0
+	for range targets {
+		if <-results == nil {
+			ok++
+		}
+	}
+	if ctx.Err() != nil && ok < len(targets) {
+		return job.finishPartial(ok, len(targets), context.Cause(ctx))
+	}
+	job.finish(nil)
+	return nil
+}
+
+// quorumReplicator copies to all targets in parallel and acknowledges as soon
+// as a majority has the file; the rest finish in the background.
+type quorumReplicator struct{}
+
+func (quorumReplicator) Replicate(ctx context.Context, job *uploadJob, filename string, p *payload, targets []StorageServer) error {
+	return replicateUntil(ctx, job, filename, p, targets, len(targets)/2+1, "quorum")
+}
+
+// replicateUntil copies to all targets in parallel and returns as soon as
+// need of them have the file, or once that can no longer happen; the rest
+// finish in the background. what names the requirement in errors.
+func replicateUntil(ctx context.Context, job *uploadJob, filename string, p *payload, targets []StorageServer, need int, what string) error {
+	if len(targets) == 0 {
+		err := errors.New("no storage nodes to replicate to")
+		job.finish(err)
+		return err
+	}
+
+	// Replicas beyond those needed keep going after the client has its
+	// answer, so they only get the per-node timeout.
+	background := context.WithoutCancel(ctx)
+	results := make(chan error, len(targets))
+	for _, s := range targets {
+		go func(s StorageServer) {
+			results <- replicateOne(background, job, s, filename, p)
+		}(s)
+	}
+
+	ok, failed, received := 0, 0, 0
+	var err error
+wait:
+	for ok < need && failed <= len(targets)-need {
+		select {
+		case e := <-results:
+			if e != nil {
+				failed++
+			} else {
+				ok++
+			}
+			received++
+		case <-ctx.Done():
+			err = fmt.Errorf("%s not reached within the upload deadline: %d of %d replicas written, need %d: %w",
+				what, ok, len(targets), need, context.Cause(ctx))
+			break wait
+		}
+	}
+
+	if err == nil && ok < need {
+		err = fmt.Errorf("%s not reached: %d of %d replicas failed, need %d", what, failed, len(targets), need)
+	}
+	go func() {
+		for ; received < len(targets); received++ {
+			<-results
+		}
+		job.finish(err)
+	}()
+	return err
+}
+
+// writeConcern is the ?w= an upload asked for, overriding its bucket's
+// replication: "1" acknowledges once one replica is written, "quorum" once
+// a majority is, and "all" only once every target is (the upload fails if
+// any replica does). Replicas not waited for finish in the background.
+type writeConcern string
+
+const (
+	writeOne    writeConcern = "1"
+	writeQuorum writeConcern = "quorum"
+	writeAll    writeConcern = "all"
+)
+
+func parseWriteConcern(v string) (writeConcern, error) {
+	switch wc := writeConcern(v); wc {
+	case writeOne, writeQuorum, writeAll:
+		return wc, nil
+	}
+	return "", fmt.Errorf("unknown write concern %q (want 1, quorum or all)", v)
+}
+
+func (wc writeConcern) Replicate(ctx context.Context, job *uploadJob, filename string, p *payload, targets []StorageServer) error {
+	need := len(targets)
+	switch wc {
+	case writeOne:
+		need = 1
+	case writeQuorum:
+		need = len(targets)/2 + 1
+	}
+	return replicateUntil(ctx, job, filename, p, targets, need, "write concern w="+string(wc))
+}
+
+// asyncReplicator acknowledges as soon as the central API has the file and
+// replicates from a bounded priority queue served by a fixed pool of
+// workers.
+type asyncReplicator struct {
+	queue *replicationQueue
+}
+
+func newAsyncReplicator(workers, queueSize int) *asyncReplicator {
+	a := &asyncReplicator{queue: newReplicationQueue(queueSize)}
+	for i := 0; i < workers; i++ {
+		go a.worker()
+	}
+	go a.queue.wakeEvery(time.Minute)
+	return a
+}
+
+func (a *asyncReplicator) worker() {
+	for {
+		a.queue.pop().run()
+	}
+}
+
+func (a *asyncReplicator) Replicate(ctx context.Context, job *uploadJob, filename string, p *payload, targets []StorageServer) error {
+	prio := priorityUpload
+	if job.replaces {
+		prio = priorityReupload
+	}
+	task := &replicationTask{priority: prio, ctx: context.WithoutCancel(ctx), job: job, filename: filename, payload: p, targets: targets}
+	if err := a.queue.push(task); err != nil {
+		job.finish(err)
+		return err
+	}
+	return nil
+}
+
+func validReplication(mode string) bool {
+	switch mode {
+	case "", "sync", "async", "quorum":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,395 @@
+package central
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+)
+
+// ---------------------------
+// Replication Queue
+// ---------------------------
+
+// The async replication queue serves its tasks by priority class, oldest
+// first within a class: a re-upload first, since until it is replicated the
+// nodes serve the old contents; then new uploads; background repair of
+// replicas a node found corrupt last. The queue's capacity is shared by all
+// classes. Admins can list the queue, move a task to another class or
+// position, and drop tasks.
+
+type replicationPriority int
+
+const (
+	priorityReupload replicationPriority = iota // a user replaced an existing file
+	priorityUpload                              // a new file
+	priorityRepair                              // background repair
+	numPriorities
+)
+
+var priorityNames = [numPriorities]string{"reupload", "upload", "repair"}
+
+func (p replicationPriority) String() string { return priorityNames[p] }
+
+func parsePriority(s string) (replicationPriority, error) {
+	for i, name := range priorityNames {
+		if s == name {
+			return replicationPriority(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown priority %q (want reupload, upload or repair)", s)
+}
+
+// replicationTask is one queued replication: an upload's (job and payload
+// set) or a repair pushing the central copy of filename to targets.
+type replicationTask struct {
+	id       string
+	priority replicationPriority
+	queued   time.Time
+
+	ctx      context.Context
+	job      *uploadJob
+	filename string
+	payload  *payload
+	targets  []StorageServer
+}
+
+// errDequeued fails an upload whose replication an admin dropped from the
+// queue; the central copy stays, for fsck to replicate later.
+var errDequeued = errors.New("removed from the replication queue by an admin")
+
+// run replicates the task with the upload deadline, which starts when a
+// worker picks the task up, not when the long finished request came in.
+func (t *replicationTask) run() {
+	ctx, cancel := context.WithTimeout(t.ctx, uploadTimeout)
+	defer cancel()
+	if t.job != nil {
+		syncReplicator{}.Replicate(ctx, t.job, t.filename, t.payload, t.targets)
+		return
+	}
+	for _, s := range t.targets {
+		if err := bulkPush(ctx, s, t.filename); err != nil {
+			fmt.Println("Repair of", t.filename, "on", s.ID, "failed:", err)
+			continue
+		}
+		fmt.Println("Repaired", t.filename, "on", s.ID)
+	}
+}
+
+// drop ends a task that will not run.
+func (t *replicationTask) drop() {
+	if t.job != nil {
+		t.job.finish(errDequeued)
+	}
+}
+
+// QueuedReplication is a queued task, as the admin API lists it.
+type QueuedReplication struct {
+	ID       string    `json:"id"`
+	Priority string    `json:"priority"`
+	Position int       `json:"position"` // in its class, 0 = next
+	File     string    `json:"file"`
+	UploadID string    `json:"upload_id,omitempty"`
+	Targets  []string  `json:"targets"`
+	Queued   time.Time `json:"queued"`
+}
+
+type replicationQueue struct {
+	mu       sync.Mutex
+	ready    *sync.Cond
+	classes  [numPriorities][]*replicationTask
+	size     int
+	capacity int
+	nextID   int
+}
+
+func newReplicationQueue(capacity int) *replicationQueue {
+	q := &replicationQueue{capacity: capacity}
+	q.ready = sync.NewCond(&q.mu)
+	return q
+}
+
+// push queues t at the back of its class, or fails with errReplicationBusy
+// when the queue is full.
+func (q *replicationQueue) push(t *replicationTask) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.size >= q.capacity {
+		return errReplicationBusy
+	}
+	q.nextID++
+	t.id = strconv.Itoa(q.nextID)
+	t.queued = time.Now().UTC()
+	q.classes[t.priority] = append(q.classes[t.priority], t)
+	q.size++
+	q.ready.Signal()
+	return nil
+}
+
+// pop waits for a task that may run and takes the first one of the
+// highest class.
+func (q *replicationQueue) pop() *replicationTask {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for {
+		if t := q.next(time.Now()); t != nil {
+			return t
+		}
+		q.ready.Wait()
+	}
+}
+
+// next takes the task to run at now, if any; repairs wait for a
+// maintenance window. Lock held.
+func (q *replicationQueue) next(now time.Time) *replicationTask {
+	for p, tasks := range q.classes {
+		if len(tasks) == 0 || (replicationPriority(p) == priorityRepair && !maintenance.open(now)) {
+			continue
+		}
+		q.classes[p] = tasks[1:]
+		q.size--
+		return tasks[0]
+	}
+	return nil
+}
+
+// wakeEvery wakes the workers every interval, so that repairs held back
+// start once a maintenance window opens.
+func (q *replicationQueue) wakeEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		q.ready.Broadcast()
+	}
+}
+
+func (q *replicationQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.size
+}
+
+// counts returns how many tasks each class holds, by name.
+func (q *replicationQueue) counts() map[string]int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	out := map[string]int{}
+	for p, tasks := range q.classes {
+		if len(tasks) > 0 {
+			out[replicationPriority(p).String()] = len(tasks)
+		}
+	}
+	return out
+}
+
+// list returns the queue in the order it will be served.
+func (q *replicationQueue) list() []QueuedReplication {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	out := []QueuedReplication{}
+	for _, tasks := range q.classes {
+		for i, t := range tasks {
+			e := QueuedReplication{ID: t.id, Priority: t.priority.String(), Position: i,
+				File: t.filename, Targets: []string{}, Queued: t.queued}
+			if t.job != nil {
+				e.UploadID = t.job.ID
+			}
+			for _, s := range t.targets {
+				e.Targets = append(e.Targets, s.ID)
+			}
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// find returns the class and index of task id; lock held.
+func (q *replicationQueue) find(id string) (replicationPriority, int, bool) {
+	for p, tasks := range q.classes {
+		for i, t := range tasks {
+			if t.id == id {
+				return replicationPriority(p), i, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// locate is find for callers not holding the lock.
+func (q *replicationQueue) locate(id string) (replicationPriority, int, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.find(id)
+}
+
+// move puts task id into class p at position (clamped; negative = the
+// back).
+func (q *replicationQueue) move(id string, p replicationPriority, position int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	from, i, ok := q.find(id)
+	if !ok {
+		return false
+	}
+	t := q.classes[from][i]
+	q.classes[from] = append(q.classes[from][:i:i], q.classes[from][i+1:]...)
+	tasks := q.classes[p]
+	if position < 0 || position > len(tasks) {
+		position = len(tasks)
+	}
+	t.priority = p
+	q.classes[p] = append(tasks[:position:position], append([]*replicationTask{t}, tasks[position:]...)...)
+	return true
+}
+
+// remove takes task id out of the queue.
+func (q *replicationQueue) remove(id string) (*replicationTask, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	p, i, ok := q.find(id)
+	if !ok {
+		return nil, false
+	}
+	t := q.classes[p][i]
+	q.classes[p] = append(q.classes[p][:i:i], q.classes[p][i+1:]...)
+	q.size--
+	return t, true
+}
+
+// purge takes every task of class p out of the queue, or every task at
+// all if p is negative.
+func (q *replicationQueue) purge(p replicationPriority) []*replicationTask {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var out []*replicationTask
+	for c := range q.classes {
+		if p >= 0 && replicationPriority(c) != p {
+			continue
+		}
+		out = append(out, q.classes[c]...)
+		q.classes[c] = nil
+	}
+	q.size -= len(out)
+	return out
+}
+
+// queueRepair queues pushing the central copy of name to s, behind all
+// uploads. It reports false when there is no queue to put it in.
+func queueRepair(name string, s StorageServer) (bool, error) {
+	if asyncQueue == nil {
+		return false, nil
+	}
+	t := &replicationTask{priority: priorityRepair, ctx: context.Background(), filename: name, targets: []StorageServer{s}}
+	return true, asyncQueue.queue.push(t)
+}
+
+// replicationQueueFor is the async queue, or an error answered when no
+// bucket replicates asynchronously.
+func replicationQueueFor(w http.ResponseWriter) *replicationQueue {
+	if asyncQueue == nil {
+		apierr.Send(w, "No replication queue: no bucket uses async replication", http.StatusNotFound)
+		return nil
+	}
+	return asyncQueue.queue
+}
+
+// replicationQueueHandler lists the queue:
+// GET /api/v1/admin/replication/queue.
+func replicationQueueHandler(w http.ResponseWriter, r *http.Request) {
+	q := replicationQueueFor(w)
+	if q == nil {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(q.list())
+}
+
+// replicationMoveHandler reorders the queue:
+// POST /api/v1/admin/replication/queue/{id} {"priority": ..., "position": n}
+// moves a task to another class, or within its own, at position n (0 is
+// next; without one, the back).
+func replicationMoveHandler(w http.ResponseWriter, r *http.Request) {
+	q := replicationQueueFor(w)
+	if q == nil {
+		return
+	}
+	var req struct {
+		Priority string `json:"priority"`
+		Position *int   `json:"position"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<10)).Decode(&req); err != nil {
+		apierr.Send(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	id := r.PathValue("id")
+	p, _, ok := q.locate(id)
+	if !ok {
+		apierr.Send(w, "No such task", http.StatusNotFound)
+		return
+	}
+	if req.Priority != "" {
+		var err error
+		if p, err = parsePriority(req.Priority); err != nil {
+			apierr.Send(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	position := -1
+	if req.Position != nil {
+		position = *req.Position
+	}
+	if !q.move(id, p, position) {
+		apierr.Send(w, "No such task", http.StatusNotFound)
+		return
+	}
+	fmt.Println("Replication task", id, "moved to", p, "position", position)
+	replicationQueueHandler(w, r)
+}
+
+// replicationDropHandler drops one task:
+// DELETE /api/v1/admin/replication/queue/{id}.
+func replicationDropHandler(w http.ResponseWriter, r *http.Request) {
+	q := replicationQueueFor(w)
+	if q == nil {
+		return
+	}
+	t, ok := q.remove(r.PathValue("id"))
+	if !ok {
+		apierr.Send(w, "No such task", http.StatusNotFound)
+		return
+	}
+	t.drop()
+	fmt.Println("Dropped replication task", t.id, "of", t.filename)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// replicationPurgeHandler empties the queue:
+// DELETE /api/v1/admin/replication/queue, with ?priority= to drop only the
+// tasks of one class. It answers with how many were dropped.
+func replicationPurgeHandler(w http.ResponseWriter, r *http.Request) {
+	q := replicationQueueFor(w)
+	if q == nil {
+		return
+	}
+	p := replicationPriority(-1)
+	if v := r.URL.Query().Get("priority"); v != "" {
+		var err error
+		if p, err = parsePriority(v); err != nil {
+			apierr.Send(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	dropped := q.purge(p)
+	for _, t := range dropped {
+		t.drop()
+	}
+	fmt.Println("Purged", len(dropped), "replication task(s)")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"dropped": len(dropped)})
+}
@@ -0,0 +1,103 @@
+package central
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestClusterReplicationQueue(t *testing.T) {
+	c := newTestCluster(t, 3, func(cfg *Config) {
+		cfg.AdminToken = "s3cret"
+		cfg.ReplicationFactor = 2
+	})
+	// A queue without workers, so it holds still while it is inspected.
+	asyncQueue = &asyncReplicator{queue: newReplicationQueue(10)}
+	defaultReplicator = asyncQueue
+
+	admin := func(method, path, body string) (*http.Response, string) {
+		t.Helper()
+		req, _ := http.NewRequest(method, c.central.URL+path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer s3cret")
+		resp, err := testClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp, string(b)
+	}
+	queue := func() []QueuedReplication {
+		t.Helper()
+		_, body := admin("GET", "/api/v1/admin/replication/queue", "")
+		var out []QueuedReplication
+		if err := json.Unmarshal([]byte(body), &out); err != nil {
+			t.Fatalf("%v: %s", err, body)
+		}
+		return out
+	}
+	order := func(q []QueuedReplication) string {
+		var s []string
+		for _, e := range q {
+			s = append(s, e.Priority+":"+e.File)
+		}
+		return strings.Join(s, " ")
+	}
+
+	var jobs []uploadResult
+	for _, name := range []string{"a.txt", "b.txt", "a.txt"} {
+		resp, job := c.upload(name, "contents of "+name, nearLondon)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("upload %s: %d", name, resp.StatusCode)
+		}
+		jobs = append(jobs, job)
+	}
+	if queued, err := queueRepair("b.txt", c.nodes[2].StorageServer); !queued || err != nil {
+		t.Fatalf("queueRepair: %v, %v", queued, err)
+	}
+
+	q := queue()
+	if got, want := order(q), "reupload:a.txt upload:a.txt upload:b.txt repair:b.txt"; got != want {
+		t.Fatalf("queue = %s, want %s", got, want)
+	}
+	if q[0].UploadID != jobs[2].ID || len(q[0].Targets) != 2 || q[3].UploadID != "" {
+		t.Errorf("entries = %+v", q)
+	}
+	if _, body := c.get("/api/v1/cluster/status", nil); !strings.Contains(body, `"queued_by_priority":{"repair":1,"reupload":1,"upload":2}`) {
+		t.Errorf("status: %s", body)
+	}
+
+	// Reorder, drop the stale first upload of a.txt, purge the repairs.
+	if resp, body := admin("POST", "/api/v1/admin/replication/queue/"+q[2].ID, `{"priority": "reupload", "position": 0}`); resp.StatusCode != http.StatusOK {
+		t.Fatalf("move: %d %s", resp.StatusCode, body)
+	}
+	if resp, _ := admin("POST", "/api/v1/admin/replication/queue/"+q[2].ID, `{"priority": "urgent"}`); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unknown priority: %d", resp.StatusCode)
+	}
+	if resp, _ := admin("DELETE", "/api/v1/admin/replication/queue/"+q[1].ID, ""); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("drop: %d", resp.StatusCode)
+	}
+	if resp, _ := admin("DELETE", "/api/v1/admin/replication/queue/"+q[1].ID, ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("drop twice: %d", resp.StatusCode)
+	}
+	if _, body := admin("DELETE", "/api/v1/admin/replication/queue?priority=repair", ""); !strings.Contains(body, `"dropped":1`) {
+		t.Errorf("purge: %s", body)
+	}
+	if got, want := order(queue()), "reupload:b.txt reupload:a.txt"; got != want {
+		t.Errorf("queue = %s, want %s", got, want)
+	}
+	if _, body := c.get("/api/v1/uploads/"+jobs[0].ID+"/progress", nil); !strings.Contains(body, errDequeued.Error()) {
+		t.Errorf("dropped upload: %s", body)
+	}
+
+	for asyncQueue.queue.len() > 0 {
+		asyncQueue.queue.pop().run()
+	}
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if h := c.holders(name); len(h) != 2 {
+			t.Errorf("%s on %v, want 2 nodes", name, h)
+		}
+	}
+}
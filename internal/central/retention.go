@@ -0,0 +1,365 @@
+package central
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+)
+
+// ---------------------------
+// Retention Rules
+// ---------------------------
+
+// Retention rules (Config.RetentionRules) say how long files are kept, by
+// the bucket they were uploaded to and the start of their name. The first
+// rule matching a file applies to it, by the age of its central copy:
+//
+//   - keep_at_least: locked (see objectlock.go) until it is that old, so
+//     nothing can delete it sooner
+//   - archive_after: trimmed to cold_replicas replicas from then on, like
+//     a cold file, and kept there whatever its downloads
+//   - delete_after: deleted then, to the trash if there is one
+//
+// The rules are evaluated every retention_interval and on demand. Until
+// retention_enforce is set a run only reports what it would do, so new
+// rules can be checked against the files before they touch any.
+
+// RetentionRule is one rule. Bucket "" matches every bucket, the default
+// one included; Prefix "" every name.
+type RetentionRule struct {
+	Name         string   `json:"name"`
+	Bucket       string   `json:"bucket"`
+	Prefix       string   `json:"prefix"`
+	KeepAtLeast  Duration `json:"keep_at_least"`
+	ArchiveAfter Duration `json:"archive_after"`
+	DeleteAfter  Duration `json:"delete_after"`
+}
+
+func (r RetentionRule) matches(name, bucket string) bool {
+	return (r.Bucket == "" || r.Bucket == bucket) && strings.HasPrefix(name, r.Prefix)
+}
+
+func validateRetentionRules(rules []RetentionRule, buckets map[string]BucketConfig) []error {
+	var errs []error
+	seen := map[string]bool{}
+	for i, r := range rules {
+		what := fmt.Sprintf("retention_rules[%d]", i)
+		if r.Name == "" || seen[r.Name] {
+			errs = append(errs, fmt.Errorf("%s: needs a name of its own", what))
+		}
+		seen[r.Name] = true
+		if _, ok := buckets[r.Bucket]; r.Bucket != "" && !ok {
+			errs = append(errs, fmt.Errorf("%s: unknown bucket %q", what, r.Bucket))
+		}
+		keep, archive, del := r.KeepAtLeast.Duration, r.ArchiveAfter.Duration, r.DeleteAfter.Duration
+		switch {
+		case keep < 0 || archive < 0 || del < 0:
+			errs = append(errs, fmt.Errorf("%s: durations must not be negative", what))
+		case keep == 0 && archive == 0 && del == 0:
+			errs = append(errs, fmt.Errorf("%s: needs keep_at_least, archive_after or delete_after", what))
+		case del > 0 && del < keep:
+			errs = append(errs, fmt.Errorf("%s: delete_after is before keep_at_least", what))
+		case del > 0 && archive >= del:
+			errs = append(errs, fmt.Errorf("%s: archive_after must come before delete_after", what))
+		case archive > 0 && archive < keep:
+			// The nodes keep every replica of a locked file.
+			errs = append(errs, fmt.Errorf("%s: archive_after is before keep_at_least", what))
+		}
+	}
+	return errs
+}
+
+// RetentionAction is what a run did, or would do, to one file.
+type RetentionAction struct {
+	File    string `json:"file"`
+	Rule    string `json:"rule"`
+	Action  string `json:"action"` // lock, archive or delete
+	Skipped string `json:"skipped,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// RetentionReport is one run.
+type RetentionReport struct {
+	Started  time.Time         `json:"started"`
+	Finished time.Time         `json:"finished"`
+	DryRun   bool              `json:"dry_run"`
+	Files    int               `json:"files"` // matched by a rule
+	Actions  []RetentionAction `json:"actions"`
+}
+
+// retentionRegistry keeps what the rules need to know of each file
+// beyond its central copy: the bucket it was uploaded to, and whether a
+// rule has archived it.
+type retentionRegistry struct {
+	mu       sync.Mutex
+	path     string
+	Buckets  map[string]string    `json:"buckets"`
+	Archived map[string]time.Time `json:"archived"`
+
+	runMu sync.Mutex // one run at a time
+	last  *RetentionReport
+}
+
+var retentionFiles = &retentionRegistry{Buckets: map[string]string{}, Archived: map[string]time.Time{}}
+
+func (reg *retentionRegistry) load(path string) error {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	reg.path = path
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw, reg); err != nil {
+		return err
+	}
+	if reg.Buckets == nil {
+		reg.Buckets = map[string]string{}
+	}
+	if reg.Archived == nil {
+		reg.Archived = map[string]time.Time{}
+	}
+	return nil
+}
+
+func (reg *retentionRegistry) saveLocked() {
+	if reg.path == "" {
+		return
+	}
+	raw, err := json.MarshalIndent(reg, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(reg.path), 0755)
+	}
+	if err == nil {
+		tmp := reg.path + ".tmp"
+		if err = os.WriteFile(tmp, raw, 0644); err == nil {
+			err = os.Rename(tmp, reg.path)
+		}
+	}
+	if err != nil {
+		fmt.Println("Cannot save retention state:", err)
+	}
+}
+
+// uploaded notes that name was uploaded to bucket; a new version is no
+// longer archived.
+func (reg *retentionRegistry) uploaded(name, bucket string) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	_, archived := reg.Archived[name]
+	if reg.Buckets[name] == bucket && !archived {
+		return
+	}
+	if bucket == "" {
+		delete(reg.Buckets, name)
+	} else {
+		reg.Buckets[name] = bucket
+	}
+	delete(reg.Archived, name)
+	reg.saveLocked()
+}
+
+// forget drops a deleted file.
+func (reg *retentionRegistry) forget(name string) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	_, inBucket := reg.Buckets[name]
+	_, archived := reg.Archived[name]
+	if !inBucket && !archived {
+		return
+	}
+	delete(reg.Buckets, name)
+	delete(reg.Archived, name)
+	reg.saveLocked()
+}
+
+func (reg *retentionRegistry) bucket(name string) string {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	return reg.Buckets[name]
+}
+
+func (reg *retentionRegistry) isArchived(name string) bool {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	_, ok := reg.Archived[name]
+	return ok
+}
+
+func (reg *retentionRegistry) archive(name string, at time.Time) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.Archived[name] = at
+	reg.saveLocked()
+}
+
+// runRetention evaluates the rules against every central copy, applying
+// what they call for unless dryRun.
+func runRetention(ctx context.Context, dryRun bool) RetentionReport {
+	retentionFiles.runMu.Lock()
+	defer retentionFiles.runMu.Unlock()
+
+	rules := currentPolicy().retentionRules
+	now := time.Now().UTC()
+	report := RetentionReport{Started: now, DryRun: dryRun, Actions: []RetentionAction{}}
+	inFlight := inFlightUploads()
+	entries, _ := os.ReadDir(uploadDir)
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || strings.HasPrefix(name, ".") || inFlight[name] || quarantined.has(name) {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+		bucket := retentionFiles.bucket(name)
+		for _, rule := range rules {
+			if !rule.matches(name, bucket) {
+				continue
+			}
+			report.Files++
+			for _, a := range retentionActions(rule, name, fi.ModTime(), now) {
+				if !dryRun && a.Skipped == "" {
+					applyRetention(ctx, &a, rule, fi.ModTime(), now)
+				}
+				report.Actions = append(report.Actions, a)
+			}
+			break
+		}
+	}
+	report.Finished = time.Now().UTC()
+	retentionFiles.mu.Lock()
+	retentionFiles.last = &report
+	retentionFiles.mu.Unlock()
+	return report
+}
+
+// retentionActions returns what rule calls for on a file last written at
+// modTime: a lock while it is young, then archiving or deleting it.
+func retentionActions(rule RetentionRule, name string, modTime, now time.Time) []RetentionAction {
+	var out []RetentionAction
+	age := now.Sub(modTime)
+	if keep := rule.KeepAtLeast.Duration; keep > 0 && age < keep {
+		if l, _ := objectLocks.get(name); l.RetainUntil.Before(modTime.Add(keep)) {
+			out = append(out, RetentionAction{File: name, Rule: rule.Name, Action: "lock"})
+		}
+	}
+	a := RetentionAction{File: name, Rule: rule.Name}
+	switch {
+	case rule.DeleteAfter.Duration > 0 && age >= rule.DeleteAfter.Duration:
+		a.Action = "delete"
+	case rule.ArchiveAfter.Duration > 0 && age >= rule.ArchiveAfter.Duration && !retentionFiles.isArchived(name):
+		a.Action = "archive"
+	default:
+		return out
+	}
+	if l, locked := objectLocks.locked(name); locked {
+		a.Skipped = "file is " + l.reason()
+	}
+	return append(out, a)
+}
+
+// applyRetention carries out a.
+func applyRetention(ctx context.Context, a *RetentionAction, rule RetentionRule, modTime, now time.Time) {
+	by := "retention rule " + rule.Name
+	switch a.Action {
+	case "lock":
+		l, _ := objectLocks.get(a.File)
+		l.Name, l.RetainUntil, l.UpdatedBy, l.Updated = a.File, modTime.Add(rule.KeepAtLeast.Duration).UTC(), by, now
+		if err := objectLocks.set(l); err != nil {
+			a.Error = err.Error()
+			return
+		}
+		if errs := syncLocks(ctx); len(errs) > 0 {
+			fmt.Println("Cannot send locked file list:", errs)
+		}
+	case "archive":
+		var pr PrefetchReport
+		var held []StorageServer
+		has := map[string]bool{}
+		for _, s := range topo.nodes() {
+			if _, ok := headReplica(ctx, s, a.File); ok {
+				has[s.ID] = true
+				held = append(held, s)
+			}
+		}
+		trimReplicas(ctx, a.File, has, held, prefetchCfg.coldReplicas, &pr)
+		for _, m := range pr.Trimmed {
+			if m.Error != "" {
+				a.Error = m.Node + ": " + m.Error
+			}
+		}
+		prefetch.setCold(a.File, true)
+		retentionFiles.archive(a.File, now)
+	case "delete":
+		var err error
+		if trashRetention > 0 {
+			err = trashFile(ctx, a.File, by)
+		} else {
+			deleteEverywhere(ctx, a.File)
+		}
+		if err != nil {
+			a.Error = err.Error()
+		}
+	}
+	fmt.Println("Retention:", a.Action, a.File, "by rule", rule.Name)
+}
+
+// retentionTask is the scheduled run: a dry run unless the rules are
+// enforced.
+func retentionTask(ctx context.Context) (string, error) {
+	p := currentPolicy()
+	if len(p.retentionRules) == 0 {
+		return "no retention rules", nil
+	}
+	report := runRetention(ctx, !p.retentionEnforce)
+	verb := "applied"
+	if report.DryRun {
+		verb = "due (dry run)"
+	}
+	summary := fmt.Sprintf("%d action(s) %s", len(report.Actions), verb)
+	if len(report.Actions) > 0 {
+		fmt.Println("Retention:", summary)
+	}
+	return summary, nil
+}
+
+// retentionHandler shows the last run: GET /api/v1/admin/retention.
+func retentionHandler(w http.ResponseWriter, r *http.Request) {
+	retentionFiles.mu.Lock()
+	last := retentionFiles.last
+	retentionFiles.mu.Unlock()
+	if last == nil {
+		apierr.Send(w, "No retention run yet", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(last)
+}
+
+// retentionRunHandler runs the rules now: POST /api/v1/admin/retention,
+// a dry run unless retention_enforce is set or ?dry_run=false.
+func retentionRunHandler(w http.ResponseWriter, r *http.Request) {
+	dryRun := !currentPolicy().retentionEnforce
+	if v := r.URL.Query().Get("dry_run"); v != "" {
+		dryRun, _ = strconv.ParseBool(v) // checked by requestRules
+	}
+	report := runRetention(r.Context(), dryRun)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,103 @@
+package central
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRetentionRules(t *testing.T) {
+	c := newTestCluster(t, 3, func(cfg *Config) {
+		cfg.Buckets = map[string]BucketConfig{"logs": {Replication: "sync"}}
+		cfg.RetentionRules = []RetentionRule{
+			{Name: "logs", Bucket: "logs", DeleteAfter: Duration{time.Hour}},
+			{Name: "reports", Prefix: "report-", KeepAtLeast: Duration{time.Hour}, ArchiveAfter: Duration{2 * time.Hour}},
+		}
+	})
+	q := url.Values{"bucket": {"logs"}}
+	for k, v := range nearLondon {
+		q[k] = v
+	}
+	c.upload("old.log", "log", q)
+	c.upload("new.log", "log", q)
+	c.upload("unbucketed.log", "log", nearLondon)
+	c.upload("report-q1.txt", "q1", nearLondon)
+	c.upload("report-q2.txt", "q2", nearLondon)
+	c.waitForJobs()
+	age := func(name string, d time.Duration) {
+		then := time.Now().Add(-d)
+		os.Chtimes(filepath.Join(uploadDir, name), then, then)
+	}
+	age("old.log", 2*time.Hour)
+	age("unbucketed.log", 2*time.Hour)
+	age("report-q1.txt", 3*time.Hour)
+
+	summary := func(r RetentionReport) string {
+		s := ""
+		for _, a := range r.Actions {
+			s += fmt.Sprintf("%s:%s:%s%s ", a.File, a.Rule, a.Action, a.Error)
+		}
+		return s
+	}
+	want := "old.log:logs:delete report-q1.txt:reports:archive report-q2.txt:reports:lock "
+
+	// A dry run only reports.
+	if got := summary(runRetention(context.Background(), true)); got != want {
+		t.Errorf("dry run: %s, want %s", got, want)
+	}
+	if got := c.holders("old.log"); len(got) != 3 {
+		t.Errorf("dry run deleted old.log: held by %v", got)
+	}
+	if _, locked := objectLocks.locked("report-q2.txt"); locked {
+		t.Error("dry run locked report-q2.txt")
+	}
+
+	r := runRetention(context.Background(), false)
+	if got := summary(r); got != want {
+		t.Errorf("run: %s, want %s", got, want)
+	}
+	if got := c.holders("old.log"); len(got) != 0 {
+		t.Errorf("old.log still held by %v", got)
+	}
+	if got := c.holders("report-q1.txt"); len(got) != 1 {
+		t.Errorf("report-q1.txt archived onto %v, want 1 node", got)
+	}
+	if got := c.holders("unbucketed.log"); len(got) != 3 {
+		t.Errorf("unbucketed.log held by %v", got)
+	}
+	if l, locked := objectLocks.locked("report-q2.txt"); !locked || l.UpdatedBy != "retention rule reports" {
+		t.Errorf("report-q2.txt lock = %+v", l)
+	}
+	if resp := c.delete("report-q2.txt"); resp.StatusCode != 423 {
+		t.Errorf("deleting a kept file: %d", resp.StatusCode)
+	}
+
+	// Nothing is left to do.
+	if got := summary(runRetention(context.Background(), false)); got != "" {
+		t.Errorf("second run: %s", got)
+	}
+}
+
+func TestRetentionRuleValidation(t *testing.T) {
+	buckets := map[string]BucketConfig{"logs": {}}
+	for _, tc := range []struct {
+		rule RetentionRule
+		ok   bool
+	}{
+		{RetentionRule{Name: "a", Bucket: "logs", DeleteAfter: Duration{time.Hour}}, true},
+		{RetentionRule{Name: "a", Bucket: "nope", DeleteAfter: Duration{time.Hour}}, false},
+		{RetentionRule{Name: "a"}, false},
+		{RetentionRule{DeleteAfter: Duration{time.Hour}}, false},
+		{RetentionRule{Name: "a", KeepAtLeast: Duration{2 * time.Hour}, DeleteAfter: Duration{time.Hour}}, false},
+		{RetentionRule{Name: "a", ArchiveAfter: Duration{time.Hour}, DeleteAfter: Duration{time.Hour}}, false},
+		{RetentionRule{Name: "a", KeepAtLeast: Duration{2 * time.Hour}, ArchiveAfter: Duration{time.Hour}}, false},
+	} {
+		if errs := validateRetentionRules([]RetentionRule{tc.rule}, buckets); (len(errs) == 0) != tc.ok {
+			t.Errorf("%+v: %v", tc.rule, errs)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package central
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+)
+
+// ---------------------------
+// Retries
+// ---------------------------
+
+// retryPolicy retries failed node calls with capped exponential backoff and
+// full jitter: before attempt n+1 it waits a random time between 0 and
+// min(MaxDelay, BaseDelay*2^(n-1)), so nodes recovering from a blip are not
+// hit by every client in lockstep.
+//
+// Only idempotent calls are retried. Uploads write the whole file under its
+// name and the node swaps it in atomically, so sending it twice leaves the
+// same file; deletes treat "already gone" as done; listings only read.
+type retryPolicy struct {
+	Attempts  int // total attempts, including the first
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+var retries = retryPolicy{Attempts: 3, BaseDelay: 100 * time.Millisecond, MaxDelay: 2 * time.Second}
+
+// statusError is a node answering with an unexpected HTTP status. Body is
+// the node's error (see apierr), or what it said instead.
+type statusError struct {
+	Status int
+	Body   string
+}
+
+func (e *statusError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("status %d", e.Status)
+	}
+	return fmt.Sprintf("status %d: %s", e.Status, apierr.Parse(e.Status, []byte(e.Body)).Message)
+}
+
+// retryable reports whether another attempt could succeed. Statuses are
+// retried when the node is overloaded or failed internally, as
+// apierr.RetryableStatus has it.
+func retryable(err error) bool {
+	if errors.Is(err, errCircuitOpen) || errors.Is(err, errUploadAborted) {
+		return false
+	}
+	var se *statusError
+	if errors.As(err, &se) {
+		return apierr.RetryableStatus(se.Status)
+	}
+	// Network errors and attempts that hit the per-call timeout.
+	return true
+}
+
+// do runs call until it succeeds, fails with an error that is not worth
+// retrying, runs out of attempts or ctx is done. Each attempt gets its own
+// per-call timeout. The last error is returned.
+func (p retryPolicy) do(ctx context.Context, call func(ctx context.Context) error) error {
+	attempts := max(p.Attempts, 1)
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			select {
+			case <-time.After(p.backoff(i)):
+			case <-ctx.Done():
+				return err
+			}
+		}
+		attemptCtx, cancel := withNodeTimeout(ctx)
+		err = call(attemptCtx)
+		cancel()
+		if err == nil || ctx.Err() != nil || !retryable(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// backoff is the wait before retry n (1-based).
+func (p retryPolicy) backoff(n int) time.Duration {
+	d := p.MaxDelay
+	if shift := n - 1; shift < 32 && p.BaseDelay<<shift > 0 && p.BaseDelay<<shift < d {
+		d = p.BaseDelay << shift
+	}
+	if d <= 0 {
+		return 0
+	}
+	return rand.N(d + 1)
+}
@@ -0,0 +1,114 @@
+package central
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	p := retryPolicy{Attempts: 4, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+	ctx := context.Background()
+
+	calls := 0
+	err := p.do(ctx, func(context.Context) error {
+		if calls++; calls < 3 {
+			return &statusError{Status: http.StatusServiceUnavailable}
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("transient failures: err %v after %d calls, want success on the 3rd", err, calls)
+	}
+
+	calls = 0
+	err = p.do(ctx, func(context.Context) error {
+		calls++
+		return errors.New("connection refused")
+	})
+	if err == nil || calls != 4 {
+		t.Errorf("persistent failure: err %v after %d calls, want an error after 4", err, calls)
+	}
+
+	for _, status := range []int{http.StatusBadRequest, http.StatusNotFound, http.StatusInsufficientStorage} {
+		calls = 0
+		p.do(ctx, func(context.Context) error {
+			calls++
+			return &statusError{Status: status}
+		})
+		if calls != 1 {
+			t.Errorf("status %d retried %d times", status, calls-1)
+		}
+	}
+
+	// A done context stops the retries; the attempt context is bounded by
+	// the node timeout.
+	cctx, cancel := context.WithCancel(ctx)
+	calls = 0
+	err = p.do(cctx, func(actx context.Context) error {
+		calls++
+		if _, ok := actx.Deadline(); !ok {
+			t.Error("attempt has no deadline")
+		}
+		cancel()
+		return errors.New("boom")
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("cancelled: err %v after %d calls, want one call", err, calls)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	p := retryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	for n, limit := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 4: 800 * time.Millisecond, 5: time.Second, 60: time.Second} {
+		for i := 0; i < 50; i++ {
+			if d := p.backoff(n); d < 0 || d > limit {
+				t.Fatalf("backoff(%d) = %s, want within [0, %s]", n, d, limit)
+			}
+		}
+	}
+}
+
+func TestClusterRetriesFlakyNode(t *testing.T) {
+	c := newTestCluster(t, 2, func(cfg *Config) {
+		cfg.RetryBaseDelay = Duration{time.Millisecond}
+		cfg.RetryMaxDelay = Duration{10 * time.Millisecond}
+	})
+
+	// ny fails its next two requests, then recovers.
+	ny := c.node("ny")
+	inner := *ny.handler.Load()
+	var failures atomic.Int32
+	failures.Store(2)
+	var flaky http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failures.Add(-1) >= 0 {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		inner.ServeHTTP(w, r)
+	})
+	ny.handler.Store(&flaky)
+
+	resp, job := c.upload("doc.txt", "contents", nearLondon)
+	if resp.StatusCode != http.StatusOK || job.Replicas["ny"].Status != uploadDone {
+		t.Fatalf("upload: status %d, job %+v", resp.StatusCode, job)
+	}
+	if s := nodeStats.get("ny").Ops[opUpload]; s.OK != 1 || s.Errors != 2 {
+		t.Errorf("ny upload stats = %+v, want 2 failed attempts and 1 success", s)
+	}
+
+	// Deleting twice is fine: the second time the replicas are already gone.
+	failures.Store(1)
+	for i := 0; i < 2; i++ {
+		c.delete("doc.txt")
+		if got := c.holders("doc.txt"); len(got) != 0 {
+			t.Fatalf("after delete %d, still held by %v", i+1, got)
+		}
+	}
+	if s := nodeStats.get("ny").Ops[opDelete]; s.OK != 2 || s.Errors != 1 {
+		t.Errorf("ny delete stats = %+v", s)
+	}
+}
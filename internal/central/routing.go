@@ -0,0 +1,122 @@
+package central
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync/atomic"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+)
+
+// ---------------------------
+// Replica Routing
+// ---------------------------
+type rankedNode struct {
+	StorageServer
+	Distance float64
+}
+
+// rankStorages orders storage nodes from nearest to farthest from c, by the
+// configured distance model.
+func rankStorages(c clientRef) []rankedNode {
+	model := currentPolicy().distance
+	var ranked []rankedNode
+	for _, s := range topo.nodes() {
+		ranked = append(ranked, rankedNode{StorageServer: s, Distance: model.Distance(c, s)})
+	}
+	sort.Slice(ranked, func(i, j int) bool { return ranked[i].Distance < ranked[j].Distance })
+	return ranked
+}
+
+// replicationFactor is the number of nodes each upload goes to (0 = all).
+var replicationFactor atomic.Int32
+
+// replicaTargets picks the nodes an upload of filename from c is replicated
+// to, according to the configured placement strategy.
+func replicaTargets(filename string, c clientRef) []StorageServer {
+	return currentPolicy().placement.Place(filename, preferHealthy(withRoom(rankStorages(c))), int(replicationFactor.Load()))
+}
+
+// spareNodes returns the healthy nodes that are not among targets, in the
+// order replicaTargets would consider them: where a replica goes when one of
+// the targets turns out to be out of space.
+func spareNodes(targets []StorageServer, c clientRef) []StorageServer {
+	taken := map[string]bool{}
+	for _, t := range targets {
+		taken[t.ID] = true
+	}
+	var spares []StorageServer
+	for _, n := range preferHealthy(withRoom(rankStorages(c))) {
+		if !taken[n.ID] && health.isHealthy(n.ID) {
+			spares = append(spares, n.StorageServer)
+		}
+	}
+	return spares
+}
+
+// nearestReplica returns the nearest healthy node that holds filename,
+// preferring nodes that are not degraded.
+func nearestReplica(ctx context.Context, c clientRef, filename string) (StorageServer, bool) {
+	for _, n := range preferHealthy(rankStorages(c)) {
+		if health.isHealthy(n.ID) && hasReplica(ctx, n.StorageServer, filename) {
+			return n.StorageServer, true
+		}
+	}
+	return StorageServer{}, false
+}
+
+// getHandler redirects downloads to the nearest healthy replica so the
+// bytes never flow through the central API. ?read= can pick other copies
+// (see readPreference); the central copy is served from /files/.
+func getHandler(w http.ResponseWriter, r *http.Request) {
+	filename := r.PathValue("filename")
+	if filename == "" {
+		apierr.Send(w, "filename required", http.StatusBadRequest)
+		return
+	}
+
+	if refuseQuarantined(w, filename) {
+		return
+	}
+	if refusePrivate(w, r, filename) {
+		return
+	}
+
+	client, err := locateClient(r)
+	if err != nil {
+		apierr.Send(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pref, err := parseReadPreference(r)
+	if err != nil {
+		apierr.Send(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	if _, ok := manifests.get(filename); ok && pref.mode != readPrimary {
+		// No node has all of it: the proxy assembles the parts.
+		u := "/download/" + url.PathEscape(filename)
+		if r.URL.RawQuery != "" {
+			u += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, u, http.StatusFound)
+		return
+	}
+	for _, n := range pref.candidates(client) {
+		if hasReplica(r.Context(), n.StorageServer, filename) {
+			w.Header().Set("X-Storage-Node", n.ID)
+			countDownload(r, filename, client)
+			http.Redirect(w, r, nodeFileURL(n.StorageServer, filename), http.StatusFound)
+			return
+		}
+	}
+	if pref.usesCentral() && hasCentralCopy(filename) {
+		countDownload(r, filename, client)
+		http.Redirect(w, r, "/files/"+url.PathEscape(filename), http.StatusFound)
+		return
+	}
+	apierr.Send(w, pref.notFound(filename), http.StatusNotFound)
+}
@@ -0,0 +1,438 @@
+package central
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/cron"
+)
+
+// ---------------------------
+// Scheduled Tasks
+// ---------------------------
+
+// The recurring maintenance runs from one scheduler, each task on its own
+// schedule (Config.Schedules, in cron syntax). A task can be disabled and
+// enabled again, and run on demand, through the admin API; what was
+// disabled and how each task last ran are kept under
+// DataDir/schedules.json. A task still running when it is next due is not
+// started a second time.
+const (
+	scheduleGC          = "gc"
+	schedulePrefetch    = "prefetch"
+	scheduleRetention   = "retention"
+	scheduleTrashPurge  = "trash_purge"
+	scheduleAntiEntropy = "anti_entropy" // fsck with repair
+	scheduleScrub       = "scrub"        // start a scrub pass on every node
+	scheduleUsage       = "usage"
+)
+
+// scheduledTaskTimeout bounds one run of a task.
+const scheduledTaskTimeout = 6 * time.Hour
+
+// scheduledTasks runs each task and sums up how it went.
+var scheduledTasks = map[string]func(ctx context.Context) (string, error){
+	scheduleGC:          gcTask,
+	schedulePrefetch:    prefetchTask,
+	scheduleRetention:   retentionTask,
+	scheduleTrashPurge:  trashPurgeTask,
+	scheduleAntiEntropy: antiEntropyTask,
+	scheduleScrub:       scrubTask,
+	scheduleUsage:       usageTask,
+}
+
+// defaultSchedules are the schedules of the tasks cfg leaves out: gc,
+// prefetch and retention at their intervals, where set. "" is on demand
+// only.
+func defaultSchedules(cfg Config) map[string]string {
+	every := func(d Duration) string {
+		if d.Duration <= 0 {
+			return ""
+		}
+		return "@every " + d.Duration.String()
+	}
+	return map[string]string{
+		scheduleGC:          every(cfg.GCInterval),
+		schedulePrefetch:    every(cfg.PrefetchInterval),
+		scheduleRetention:   every(cfg.RetentionInterval),
+		scheduleTrashPurge:  "@every 10m",
+		scheduleAntiEntropy: "",
+		scheduleScrub:       "",
+		scheduleUsage:       "@hourly",
+	}
+}
+
+// schedulesFor returns the schedule of every task under cfg.
+func schedulesFor(cfg Config) map[string]string {
+	specs := defaultSchedules(cfg)
+	for name, spec := range cfg.Schedules {
+		specs[name] = spec
+	}
+	return specs
+}
+
+const (
+	runRunning = "running"
+	runOK      = "ok"
+	runFailed  = "failed"
+)
+
+// TaskRun is one run of a scheduled task.
+type TaskRun struct {
+	Trigger  string     `json:"trigger"` // "schedule" or "manual"
+	Status   string     `json:"status"`
+	Summary  string     `json:"summary,omitempty"`
+	Error    string     `json:"error,omitempty"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+// ScheduledTask is a task as the admin API shows it.
+type ScheduledTask struct {
+	Name     string     `json:"name"`
+	Schedule string     `json:"schedule"` // "" when only run on demand
+	Enabled  bool       `json:"enabled"`
+	Running  bool       `json:"running"`
+	Next     *time.Time `json:"next,omitempty"`
+	Runs     int        `json:"runs"`
+	Last     *TaskRun   `json:"last,omitempty"`
+}
+
+type taskScheduler struct {
+	mu        sync.Mutex
+	path      string
+	specs     map[string]string
+	schedules map[string]cron.Schedule
+	next      map[string]time.Time // of the enabled tasks with a schedule
+	running   map[string]bool
+	wake      chan struct{}
+
+	Disabled map[string]bool    `json:"disabled"`
+	Runs     map[string]int     `json:"runs"`
+	Last     map[string]TaskRun `json:"last"`
+}
+
+var scheduler = newTaskScheduler()
+
+func newTaskScheduler() *taskScheduler {
+	return &taskScheduler{
+		specs:     map[string]string{},
+		schedules: map[string]cron.Schedule{},
+		next:      map[string]time.Time{},
+		running:   map[string]bool{},
+		wake:      make(chan struct{}, 1),
+		Disabled:  map[string]bool{},
+		Runs:      map[string]int{},
+		Last:      map[string]TaskRun{},
+	}
+}
+
+func (s *taskScheduler) load(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.path = path
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw, s); err != nil {
+		return err
+	}
+	for name, run := range s.Last {
+		if run.Status == runRunning {
+			run.Status, run.Error = runFailed, "interrupted by a restart"
+			s.Last[name] = run
+		}
+	}
+	for name := range s.Disabled {
+		delete(s.next, name)
+	}
+	return nil
+}
+
+func (s *taskScheduler) saveLocked() {
+	if s.path == "" {
+		return
+	}
+	raw, err := json.MarshalIndent(s, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(s.path), 0755)
+	}
+	if err == nil {
+		tmp := s.path + ".tmp"
+		if err = os.WriteFile(tmp, raw, 0644); err == nil {
+			err = os.Rename(tmp, s.path)
+		}
+	}
+	if err != nil {
+		fmt.Println("Cannot save the schedules:", err)
+	}
+}
+
+// configure sets the schedules, validated beforehand, and works out when
+// each task is next due from now.
+func (s *taskScheduler) configure(specs map[string]string, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.specs = specs
+	s.schedules = map[string]cron.Schedule{}
+	for name, spec := range specs {
+		if sched, err := cron.Parse(spec); spec != "" && err == nil {
+			s.schedules[name] = sched
+		}
+	}
+	s.next = map[string]time.Time{}
+	for name := range s.schedules {
+		s.planLocked(name, now)
+	}
+	s.wakeUp()
+}
+
+// planLocked works out when an enabled task is next due after now.
+func (s *taskScheduler) planLocked(name string, now time.Time) {
+	delete(s.next, name)
+	sched, ok := s.schedules[name]
+	if !ok || s.Disabled[name] {
+		return
+	}
+	if at := sched.Next(now); !at.IsZero() {
+		s.next[name] = at
+	}
+}
+
+func (s *taskScheduler) wakeUp() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// runDue starts the tasks due by now and returns when the next one is due,
+// the zero time if none is.
+func (s *taskScheduler) runDue(now time.Time) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var soonest time.Time
+	for name, at := range s.next {
+		if !at.After(now) {
+			if !s.startLocked(name, "schedule") {
+				fmt.Println("Schedule:", name, "still running, skipped")
+			}
+			s.planLocked(name, now)
+			at = s.next[name]
+		}
+		if !at.IsZero() && (soonest.IsZero() || at.Before(soonest)) {
+			soonest = at
+		}
+	}
+	return soonest
+}
+
+// loop runs the tasks as they fall due.
+func (s *taskScheduler) loop() {
+	for {
+		wait := time.Hour
+		if next := s.runDue(time.Now()); !next.IsZero() {
+			wait = time.Until(next)
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case <-s.wake:
+			t.Stop()
+		}
+	}
+}
+
+// startLocked runs a task in the background, unless it is already
+// running.
+func (s *taskScheduler) startLocked(name, trigger string) bool {
+	if s.running[name] {
+		return false
+	}
+	s.running[name] = true
+	run := TaskRun{Trigger: trigger, Status: runRunning, Started: time.Now().UTC()}
+	s.Last[name] = run
+	s.Runs[name]++
+	s.saveLocked()
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), scheduledTaskTimeout)
+		summary, err := scheduledTasks[name](ctx)
+		cancel()
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		now := time.Now().UTC()
+		run.Finished, run.Summary, run.Status = &now, summary, runOK
+		if err != nil {
+			run.Status, run.Error = runFailed, err.Error()
+			fmt.Println("Schedule:", name, "failed:", err)
+		}
+		s.Last[name] = run
+		delete(s.running, name)
+		s.saveLocked()
+	}()
+	return true
+}
+
+// trigger runs a task now, outside its schedule.
+func (s *taskScheduler) trigger(name string) (ScheduledTask, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := scheduledTasks[name]; !ok {
+		return ScheduledTask{}, errUnknownTask
+	}
+	if !s.startLocked(name, "manual") {
+		return s.taskLocked(name), fmt.Errorf("%s is already running", name)
+	}
+	return s.taskLocked(name), nil
+}
+
+// setEnabled enables or disables a task's schedule; a disabled task still
+// runs on demand.
+func (s *taskScheduler) setEnabled(name string, enabled bool) (ScheduledTask, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := scheduledTasks[name]; !ok {
+		return ScheduledTask{}, errUnknownTask
+	}
+	if enabled {
+		delete(s.Disabled, name)
+	} else {
+		s.Disabled[name] = true
+	}
+	s.planLocked(name, time.Now())
+	s.saveLocked()
+	s.wakeUp()
+	return s.taskLocked(name), nil
+}
+
+var errUnknownTask = errors.New("no such scheduled task")
+
+func (s *taskScheduler) taskLocked(name string) ScheduledTask {
+	t := ScheduledTask{
+		Name:     name,
+		Schedule: s.specs[name],
+		Enabled:  !s.Disabled[name],
+		Running:  s.running[name],
+		Runs:     s.Runs[name],
+	}
+	if at, ok := s.next[name]; ok {
+		t.Next = &at
+	}
+	if run, ok := s.Last[name]; ok {
+		t.Last = &run
+	}
+	return t
+}
+
+// list returns every task, by name.
+func (s *taskScheduler) list() []ScheduledTask {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(scheduledTasks))
+	for name := range scheduledTasks {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	out := make([]ScheduledTask, 0, len(names))
+	for _, name := range names {
+		out = append(out, s.taskLocked(name))
+	}
+	return out
+}
+
+// scrubTask starts a scrub pass on every node; each reports what it
+// finds with its own scrub status.
+func scrubTask(ctx context.Context) (string, error) {
+	var started, busy int
+	var failed []string
+	for _, n := range topo.nodes() {
+		err := startScrub(ctx, n)
+		var se *statusError
+		switch {
+		case err == nil:
+			started++
+		case errors.As(err, &se) && se.Status == http.StatusConflict:
+			busy++
+		default:
+			failed = append(failed, n.ID)
+		}
+	}
+	summary := fmt.Sprintf("%d node(s) started, %d already scrubbing", started, busy)
+	if len(failed) > 0 {
+		return summary, fmt.Errorf("cannot start a scrub on %v", failed)
+	}
+	return summary, nil
+}
+
+// startScrub asks a node for a scrub pass, authenticated like
+// registration.
+func startScrub(ctx context.Context, s StorageServer) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL+"/api/v1/scrub", nil)
+	if err != nil {
+		return err
+	}
+	if registrationToken != "" {
+		req.Header.Set("Authorization", "Bearer "+registrationToken)
+	}
+	resp, err := probeClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return &statusError{Status: resp.StatusCode}
+	}
+	return nil
+}
+
+// schedulesHandler lists the tasks: GET /api/v1/admin/schedules.
+func schedulesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(scheduler.list())
+}
+
+// scheduleRunHandler runs a task now: POST
+// /api/v1/admin/schedules/{name}/run.
+func scheduleRunHandler(w http.ResponseWriter, r *http.Request) {
+	t, err := scheduler.trigger(r.PathValue("name"))
+	if errors.Is(err, errUnknownTask) {
+		apierr.Send(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		apierr.Send(w, err.Error(), http.StatusConflict)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(t)
+}
+
+// scheduleEnableHandler serves POST /api/v1/admin/schedules/{name}/enable
+// and /disable.
+func scheduleEnableHandler(enabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		t, err := scheduler.setEnabled(r.PathValue("name"), enabled)
+		if err != nil {
+			apierr.Send(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(t)
+	}
+}
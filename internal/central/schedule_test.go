@@ -0,0 +1,89 @@
+package central
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestScheduledTasks(t *testing.T) {
+	c := newTestCluster(t, 2, func(cfg *Config) {
+		cfg.AdminToken = "s3cret"
+		cfg.Schedules = map[string]string{scheduleUsage: "@every 1m"}
+	})
+	c.upload("a.txt", "twelve bytes", nearLondon)
+
+	admin := func(method, path string, want int) ScheduledTask {
+		t.Helper()
+		req, _ := http.NewRequest(method, c.central.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer s3cret")
+		resp, err := testClient.Do(req)
+		if err != nil || resp.StatusCode != want {
+			t.Fatalf("%s %s = %v %v, want %d", method, path, resp, err, want)
+		}
+		defer resp.Body.Close()
+		var task ScheduledTask
+		json.NewDecoder(resp.Body).Decode(&task)
+		return task
+	}
+	last := func(name string) TaskRun {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			for _, task := range scheduler.list() {
+				if task.Name == name && task.Last != nil && task.Last.Status != runRunning {
+					return *task.Last
+				}
+			}
+		}
+		t.Fatalf("%s did not run", name)
+		return TaskRun{}
+	}
+
+	// Due tasks start from the scheduler; usage totals what is stored.
+	scheduler.runDue(time.Now().Add(2 * time.Minute))
+	if run := last(scheduleUsage); run.Status != runOK || run.Trigger != "schedule" {
+		t.Fatalf("usage run = %+v", run)
+	}
+	var report UsageReport
+	req, _ := http.NewRequest("GET", c.central.URL+"/api/v1/admin/usage", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	if resp, err := testClient.Do(req); err == nil {
+		json.NewDecoder(resp.Body).Decode(&report)
+		resp.Body.Close()
+	}
+	if report.Total != (UsageTotals{Files: 1, Bytes: 12}) || report.Buckets[""].Files != 1 || len(report.Nodes) != 2 {
+		t.Errorf("usage = %+v", report)
+	}
+
+	// A disabled task has no next run but still runs on demand.
+	if task := admin("POST", "/api/v1/admin/schedules/usage/disable", http.StatusOK); task.Enabled || task.Next != nil {
+		t.Errorf("disabled usage = %+v", task)
+	}
+	if task := admin("POST", "/api/v1/admin/schedules/scrub/run", http.StatusAccepted); task.Runs != 1 {
+		t.Errorf("scrub = %+v", task)
+	}
+	if run := last(scheduleScrub); run.Status != runOK || run.Trigger != "manual" {
+		t.Errorf("scrub run = %+v", run)
+	}
+	if task := admin("POST", "/api/v1/admin/schedules/usage/enable", http.StatusOK); !task.Enabled || task.Next == nil {
+		t.Errorf("enabled usage = %+v", task)
+	}
+	admin("POST", "/api/v1/admin/schedules/nope/run", http.StatusNotFound)
+}
+
+func TestScheduleConfig(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Schedules = map[string]string{scheduleScrub: "0 3 * * sun", "backup": "@daily", scheduleGC: "61 * * * *"}
+	errs := cfg.validate()
+	if len(errs) != 2 {
+		t.Errorf("validate = %v, want the unknown task and the bad gc schedule", errs)
+	}
+
+	cfg.GCInterval = Duration{time.Hour}
+	cfg.Schedules = map[string]string{schedulePrefetch: "@daily"}
+	specs := schedulesFor(cfg)
+	if specs[scheduleGC] != "@every 1h0m0s" || specs[schedulePrefetch] != "@daily" || specs[scheduleScrub] != "" {
+		t.Errorf("schedules = %v", specs)
+	}
+}
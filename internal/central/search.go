@@ -0,0 +1,419 @@
+package central
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+)
+
+// ---------------------------
+// Content Search
+// ---------------------------
+
+// Uploaded text files (documents, code, Markdown, HTML) are indexed by
+// their content as they arrive, from the central copy, and searched with
+// GET /search?content=<words>: files containing every word, best matches
+// first. Config.SearchIndex picks the index:
+//
+//   - "memory": an inverted index in this process, rebuilt from the central
+//     copies at startup
+//   - an Elasticsearch index URL, e.g. http://es:9200/dsfs, shared by and
+//     surviving central API restarts
+//
+// Empty turns indexing off.
+type SearchIndex interface {
+	Index(ctx context.Context, name, text string) error
+	Remove(ctx context.Context, name string) error
+	Search(ctx context.Context, query string, limit int) ([]SearchHit, error)
+}
+
+type SearchHit struct {
+	Name    string  `json:"name"`
+	Score   float64 `json:"score"`
+	Snippet string  `json:"snippet,omitempty"`
+}
+
+// maxIndexBytes is how much of each file is indexed.
+const maxIndexBytes = 1 << 20
+
+var searchIndex SearchIndex
+
+func newSearchIndex(spec string) (SearchIndex, error) {
+	switch {
+	case spec == "":
+		return nil, nil
+	case spec == "memory":
+		return newMemoryIndex(), nil
+	case strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://"):
+		u, err := url.Parse(spec)
+		if err != nil || strings.Trim(u.Path, "/") == "" {
+			return nil, fmt.Errorf("search_index %q: want an Elasticsearch index URL, e.g. http://es:9200/dsfs", spec)
+		}
+		return &elasticIndex{base: strings.TrimRight(spec, "/"), client: &http.Client{Timeout: 10 * time.Second}}, nil
+	}
+	return nil, fmt.Errorf("unknown search_index %q (want memory or an Elasticsearch index URL)", spec)
+}
+
+// indexFile queues indexing the central copy of name (see indexNow), if
+// there is a search index.
+func indexFile(name string) {
+	if searchIndex == nil {
+		return
+	}
+	if _, err := pipeline.enqueue(taskIndex, name); err != nil {
+		fmt.Println("Cannot queue indexing", name, ":", err)
+	}
+}
+
+// indexNow indexes the central copy of name, if it is text. Anything else
+// is dropped from the index, in case name was text before.
+func indexNow(ctx context.Context, name string) error {
+	idx := searchIndex
+	if idx == nil {
+		return nil
+	}
+	if text, ok := extractText(name); ok {
+		return idx.Index(ctx, name, text)
+	}
+	return idx.Remove(ctx, name)
+}
+
+func unindexFile(name string) {
+	if searchIndex == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := searchIndex.Remove(ctx, name); err != nil {
+		fmt.Println("Removing", name, "from the index failed:", err)
+	}
+}
+
+// indexExisting indexes every central copy, for an index that starts
+// empty. It runs outside the pipeline: a job for every file would only
+// fill the queue.
+func indexExisting() {
+	entries, _ := os.ReadDir(uploadDir)
+	for _, e := range entries {
+		if e.Type().IsRegular() && !strings.HasPrefix(e.Name(), ".") {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			if err := indexNow(ctx, e.Name()); err != nil {
+				fmt.Println("Indexing", e.Name(), "failed:", err)
+			}
+			cancel()
+		}
+	}
+}
+
+var htmlTags = regexp.MustCompile(`(?s)<(script|style)\b.*?</(script|style)>|<[^>]*>`)
+
+// extractText returns the text of the central copy of name, if it is a
+// text file: UTF-8 without NULs, with the markup of HTML and XML removed.
+func extractText(name string) (string, bool) {
+	ext := strings.ToLower(filepath.Ext(name))
+	isHTML := ext == ".html" || ext == ".htm" || ext == ".xml"
+	if !previewable(name) && !isHTML && !strings.HasPrefix(mime.TypeByExtension(ext), "text/") {
+		return "", false
+	}
+	f, err := os.Open(filepath.Join(uploadDir, filepath.Base(name)))
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+	b, err := io.ReadAll(io.LimitReader(f, maxIndexBytes))
+	if err != nil {
+		return "", false
+	}
+	for len(b) > 0 && !utf8.Valid(b[len(b)-min(len(b), utf8.UTFMax):]) {
+		b = b[:len(b)-1] // cut off mid-character by the limit
+	}
+	if bytes.IndexByte(b, 0) >= 0 || !utf8.Valid(b) {
+		return "", false
+	}
+	text := string(b)
+	if isHTML {
+		text = html.UnescapeString(htmlTags.ReplaceAllString(text, " "))
+	}
+	return text, true
+}
+
+// tokenize splits text into lower-cased words.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// searchHandler answers GET /search?content=<words>[&limit=n] with the
+// hits the signed-in user may read.
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	if searchIndex == nil {
+		apierr.Send(w, "Search is not enabled (search_index)", http.StatusNotImplemented)
+		return
+	}
+	query := strings.TrimSpace(r.URL.Query().Get("content"))
+	if len(tokenize(query)) == 0 {
+		apierr.Send(w, "content required, e.g. /search?content=quarterly+report", http.StatusBadRequest)
+		return
+	}
+	limit := 20
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 1000 {
+			apierr.Send(w, "limit must be 1-1000", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	hits, err := searchIndex.Search(r.Context(), query, limit)
+	if err != nil {
+		apierr.Send(w, "Search failed: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	// Hits carry content, so they are filtered like the listing: nothing
+	// quarantined, in another user's home, or private to others.
+	kept := []SearchHit{}
+	for _, h := range hits {
+		if !quarantined.covers(h.Name) && canSee(signedIn(r), h.Name) && mayRead(r, h.Name) {
+			kept = append(kept, h)
+		}
+	}
+	hits = kept
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Query string      `json:"query"`
+		Hits  []SearchHit `json:"hits"`
+	}{query, hits})
+}
+
+// ---------------------------
+// In-Memory Index
+// ---------------------------
+
+// snippetSource is how much of each file memoryIndex keeps for snippets.
+const snippetSource = 64 << 10
+
+type memoryIndex struct {
+	mu    sync.RWMutex
+	terms map[string]map[string]int // term -> file -> occurrences
+	docs  map[string]memoryDoc
+}
+
+type memoryDoc struct {
+	terms  []string // distinct
+	length int      // in words
+	text   string   // the start, for snippets
+}
+
+func newMemoryIndex() *memoryIndex {
+	return &memoryIndex{terms: map[string]map[string]int{}, docs: map[string]memoryDoc{}}
+}
+
+func (m *memoryIndex) Index(_ context.Context, name, text string) error {
+	words := tokenize(text)
+	counts := map[string]int{}
+	for _, w := range words {
+		counts[w]++
+	}
+	doc := memoryDoc{length: len(words), text: text}
+	if len(doc.text) > snippetSource {
+		doc.text = strings.ToValidUTF8(doc.text[:snippetSource], "")
+	}
+	for t := range counts {
+		doc.terms = append(doc.terms, t)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.remove(name)
+	for t, n := range counts {
+		if m.terms[t] == nil {
+			m.terms[t] = map[string]int{}
+		}
+		m.terms[t][name] = n
+	}
+	m.docs[name] = doc
+	return nil
+}
+
+func (m *memoryIndex) Remove(_ context.Context, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.remove(name)
+	return nil
+}
+
+func (m *memoryIndex) remove(name string) {
+	for _, t := range m.docs[name].terms {
+		delete(m.terms[t], name)
+		if len(m.terms[t]) == 0 {
+			delete(m.terms, t)
+		}
+	}
+	delete(m.docs, name)
+}
+
+// Search scores the files holding every query word by TF-IDF.
+func (m *memoryIndex) Search(_ context.Context, query string, limit int) ([]SearchHit, error) {
+	words := tokenize(query)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	scores := map[string]float64{}
+	for i, w := range words {
+		files := m.terms[w]
+		idf := math.Log(1 + float64(len(m.docs))/float64(len(files)+1))
+		next := map[string]float64{}
+		for name, n := range files {
+			if _, ok := scores[name]; i > 0 && !ok {
+				continue // missing an earlier word
+			}
+			tf := float64(n) / float64(m.docs[name].length)
+			next[name] = scores[name] + tf*idf
+		}
+		scores = next
+	}
+
+	hits := make([]SearchHit, 0, len(scores))
+	for name, score := range scores {
+		hits = append(hits, SearchHit{Name: name, Score: score, Snippet: snippet(m.docs[name].text, words)})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].Name < hits[j].Name
+	})
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits, nil
+}
+
+// snippet returns the text around the first of words found in it.
+func snippet(text string, words []string) string {
+	lower := strings.ToLower(text)
+	at := -1
+	for _, w := range words {
+		if i := strings.Index(lower, w); i >= 0 && (at < 0 || i < at) {
+			at = i
+		}
+	}
+	if at < 0 || len(lower) != len(text) { // lower-casing moved the offsets
+		at = 0
+	}
+	start, end := max(0, at-60), min(len(text), at+100)
+	s := strings.Join(strings.Fields(strings.ToValidUTF8(text[start:end], "")), " ")
+	if start > 0 {
+		s = "…" + s
+	}
+	if end < len(text) {
+		s += "…"
+	}
+	return s
+}
+
+// ---------------------------
+// Elasticsearch Index
+// ---------------------------
+
+// elasticIndex keeps one document per file, {"name", "content"}, with the
+// file name as its ID, in the index at base.
+type elasticIndex struct {
+	base   string
+	client *http.Client
+}
+
+func (e *elasticIndex) do(ctx context.Context, method, path string, body any, out any) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, e.base+path, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 && !(method == http.MethodDelete && resp.StatusCode == http.StatusNotFound) {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &statusError{Status: resp.StatusCode, Body: string(msg)}
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+func (e *elasticIndex) Index(ctx context.Context, name, text string) error {
+	return e.do(ctx, http.MethodPut, "/_doc/"+url.PathEscape(name), map[string]string{"name": name, "content": text}, nil)
+}
+
+func (e *elasticIndex) Remove(ctx context.Context, name string) error {
+	return e.do(ctx, http.MethodDelete, "/_doc/"+url.PathEscape(name), nil, nil)
+}
+
+func (e *elasticIndex) Search(ctx context.Context, query string, limit int) ([]SearchHit, error) {
+	req := map[string]any{
+		"size":    limit,
+		"_source": []string{"name"},
+		"query":   map[string]any{"match": map[string]any{"content": map[string]any{"query": query, "operator": "and"}}},
+		"highlight": map[string]any{
+			"fields":    map[string]any{"content": map[string]any{"fragment_size": 160, "number_of_fragments": 1}},
+			"pre_tags":  []string{""},
+			"post_tags": []string{""},
+		},
+	}
+	var resp struct {
+		Hits struct {
+			Hits []struct {
+				Score  float64 `json:"_score"`
+				Source struct {
+					Name string `json:"name"`
+				} `json:"_source"`
+				Highlight struct {
+					Content []string `json:"content"`
+				} `json:"highlight"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := e.do(ctx, http.MethodPost, "/_search", req, &resp); err != nil {
+		return nil, err
+	}
+	var hits []SearchHit
+	for _, h := range resp.Hits.Hits {
+		hit := SearchHit{Name: h.Source.Name, Score: h.Score}
+		if len(h.Highlight.Content) > 0 {
+			hit.Snippet = h.Highlight.Content[0]
+		}
+		hits = append(hits, hit)
+	}
+	return hits, nil
+}
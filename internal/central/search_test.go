@@ -0,0 +1,139 @@
+package central
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryIndex(t *testing.T) {
+	ctx := context.Background()
+	idx := newMemoryIndex()
+	idx.Index(ctx, "q1.txt", "Quarterly report: revenue grew in the first quarter.")
+	idx.Index(ctx, "q2.txt", "Quarterly report. Revenue revenue revenue fell.")
+	idx.Index(ctx, "memo.txt", "Lunch is at noon.")
+
+	hits, _ := idx.Search(ctx, "REVENUE report", 10)
+	if len(hits) != 2 || hits[0].Name != "q2.txt" || hits[1].Name != "q1.txt" {
+		t.Fatalf("hits = %+v, want q2.txt then q1.txt", hits)
+	}
+	if !strings.Contains(hits[1].Snippet, "revenue grew") {
+		t.Errorf("snippet = %q", hits[1].Snippet)
+	}
+	if hits, _ := idx.Search(ctx, "revenue lunch", 10); len(hits) != 0 {
+		t.Errorf("files with only some of the words: %+v", hits)
+	}
+
+	idx.Index(ctx, "q2.txt", "Rewritten without the word.")
+	idx.Remove(ctx, "q1.txt")
+	if hits, _ := idx.Search(ctx, "revenue", 10); len(hits) != 0 {
+		t.Errorf("after reindexing and removing: %+v", hits)
+	}
+}
+
+func TestElasticIndex(t *testing.T) {
+	var indexed map[string]string
+	es := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPut && r.URL.Path == "/dsfs/_doc/a b.txt":
+			json.NewDecoder(r.Body).Decode(&indexed)
+		case r.Method == http.MethodPost && r.URL.Path == "/dsfs/_search":
+			b, _ := io.ReadAll(r.Body)
+			if !strings.Contains(string(b), `"operator":"and"`) {
+				t.Errorf("search request: %s", b)
+			}
+			io.WriteString(w, `{"hits":{"hits":[{"_score":1.5,"_source":{"name":"a b.txt"},"highlight":{"content":["the quick fox"]}}]}}`)
+		default:
+			t.Errorf("unexpected %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer es.Close()
+
+	idx, err := newSearchIndex(es.URL + "/dsfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := idx.Index(ctx, "a b.txt", "the quick fox"); err != nil || indexed["content"] != "the quick fox" {
+		t.Fatalf("index: %v, sent %v", err, indexed)
+	}
+	hits, err := idx.Search(ctx, "quick", 5)
+	if err != nil || len(hits) != 1 || hits[0].Name != "a b.txt" || hits[0].Snippet != "the quick fox" {
+		t.Errorf("search = %+v, %v", hits, err)
+	}
+}
+
+func TestClusterSearch(t *testing.T) {
+	c := newTestCluster(t, 2, func(cfg *Config) { cfg.SearchIndex = "memory" })
+	c.upload("notes.md", "# Meeting\n\nThe *migration* is planned for May.", nearLondon)
+	c.upload("page.html", "<html><script>var migration;</script><p>Unrelated &amp; other</p></html>", nearLondon)
+	c.upload("photo.png", "\x89PNG migration", nearLondon)
+
+	var result struct {
+		Hits []SearchHit `json:"hits"`
+	}
+	for deadline := time.Now().Add(5 * time.Second); len(result.Hits) == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("notes.md was not indexed")
+		}
+		time.Sleep(10 * time.Millisecond)
+		_, body := c.get("/search?content=migration+may", nil)
+		json.Unmarshal([]byte(body), &result)
+	}
+	if len(result.Hits) != 1 || result.Hits[0].Name != "notes.md" {
+		t.Errorf("hits = %+v, want only notes.md", result.Hits)
+	}
+	if resp, _ := c.get("/search?content=", nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("empty query: status %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestClusterSearchAccess(t *testing.T) {
+	hash, _ := hashPassword("pw")
+	c := newTestCluster(t, 1, func(cfg *Config) {
+		cfg.SearchIndex = "memory"
+		cfg.Users = []User{{Name: "alice", PasswordHash: hash}, {Name: "bob", PasswordHash: hash}}
+	})
+	search := func(user string) (int, []string) {
+		t.Helper()
+		req, _ := http.NewRequest("GET", c.central.URL+"/search?content=secret+plans", nil)
+		if user != "" {
+			req.SetBasicAuth(user, "pw")
+		}
+		resp, err := testClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var result struct {
+			Hits []SearchHit `json:"hits"`
+		}
+		json.NewDecoder(resp.Body).Decode(&result)
+		var names []string
+		for _, h := range result.Hits {
+			names = append(names, h.Name)
+		}
+		return resp.StatusCode, names
+	}
+
+	// A file in alice's home, and a private one in no home: admins only.
+	diary := homeName("alice", "diary.txt")
+	searchIndex.Index(context.Background(), diary, "my secret plans")
+	searchIndex.Index(context.Background(), "board.txt", "the board's secret plans")
+	privateFiles.set("board.txt", true)
+
+	if status, hits := search(""); status != http.StatusUnauthorized || len(hits) != 0 {
+		t.Errorf("anonymous search: %d %v", status, hits)
+	}
+	if status, hits := search("bob"); status != http.StatusOK || len(hits) != 0 {
+		t.Errorf("bob's search: %d %v, want no hits", status, hits)
+	}
+	if status, hits := search("alice"); status != http.StatusOK || len(hits) != 1 || hits[0] != diary {
+		t.Errorf("alice's search: %d %v, want only %s", status, hits, diary)
+	}
+}
@@ -0,0 +1,249 @@
+package central
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ---------------------------
+// Startup Self-Test
+// ---------------------------
+type checkResult struct {
+	Name   string
+	Status string // "OK", "WARN" or "FAIL"
+	Detail string
+}
+
+type selfTestReport struct {
+	Results []checkResult
+}
+
+func (r *selfTestReport) add(name, status, detail string) {
+	r.Results = append(r.Results, checkResult{Name: name, Status: status, Detail: detail})
+}
+
+func (r *selfTestReport) Failed() bool {
+	for _, c := range r.Results {
+		if c.Status == "FAIL" {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *selfTestReport) String() string {
+	var b strings.Builder
+	b.WriteString("Startup self-test:\n")
+	for _, c := range r.Results {
+		fmt.Fprintf(&b, "  [%-4s] %s", c.Status, c.Name)
+		if c.Detail != "" {
+			b.WriteString(": " + c.Detail)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// runSelfTest validates the configuration and everything the handlers rely
+// on. On success the parsed templates are installed for the handlers to use.
+func runSelfTest(cfg Config) *selfTestReport {
+	report := &selfTestReport{}
+
+	errs := cfg.validate()
+	if len(errs) == 0 {
+		report.add("config", "OK", fmt.Sprintf("%d storage servers", len(cfg.Storages)))
+	}
+	if len(cfg.Storages) == 0 && cfg.DiscoverySRV == "" {
+		report.add("config", "WARN", "no storage servers configured, waiting for nodes to register")
+	}
+	if cfg.RegistrationToken == "" {
+		report.add("config", "FAIL", "no registration_token (REGISTRATION_TOKEN): nodes could not register or be managed")
+	}
+	if cfg.DiscoverySRV != "" {
+		nodes, err := discoverSRV(cfg.DiscoverySRV, nil)
+		if err != nil {
+			report.add("discovery", "WARN", err.Error())
+		} else {
+			report.add("discovery", "OK", fmt.Sprintf("%s: %d nodes", cfg.DiscoverySRV, len(nodes)))
+		}
+	}
+	for _, err := range errs {
+		report.add("config", "FAIL", err.Error())
+	}
+
+	source := "built in"
+	if cfg.TemplateDir != "" {
+		source += ", overridden from " + cfg.TemplateDir
+	}
+	t, err := parseTemplates(cfg.TemplateDir)
+	if err != nil {
+		report.add("templates", "FAIL", err.Error())
+	} else {
+		for _, name := range []string{"upload.html", "list.html", "nearest.html", "login.html"} {
+			if t.Lookup(name) == nil {
+				report.add("templates", "FAIL", name+" not found")
+				err = fmt.Errorf("missing %s", name)
+			}
+		}
+		if err == nil {
+			templates = t
+			report.add("templates", "OK", source)
+		}
+	}
+
+	if t, err := parseMailTemplates(cfg.TemplateDir); err != nil {
+		report.add("mail templates", "FAIL", err.Error())
+	} else {
+		for _, et := range eventTypes {
+			if t.Lookup(et.template) == nil {
+				report.add("mail templates", "FAIL", et.template+" not found")
+				err = fmt.Errorf("missing %s", et.template)
+			}
+		}
+		if err == nil {
+			mailTemplates = t
+		}
+	}
+
+	if r, err := newGeoResolver(cfg); err != nil {
+		report.add("geolocation", "FAIL", err.Error())
+	} else {
+		geo = r
+		name := cfg.GeoResolver
+		if name == "" {
+			name = "heuristic"
+		}
+		report.add("geolocation", "OK", name)
+	}
+
+	if err := checkWritableDir(cfg.UploadDir); err != nil {
+		report.add("upload dir", "FAIL", err.Error())
+	} else {
+		report.add("upload dir", "OK", cfg.UploadDir)
+	}
+
+	if err := checkWritableDir(cfg.DataDir); err != nil {
+		report.add("data dir", "FAIL", err.Error())
+	} else {
+		report.add("data dir", "OK", cfg.DataDir)
+	}
+
+	if err := identities.load(filepath.Join(cfg.DataDir, "node-identities.json")); err != nil {
+		report.add("node identities", "FAIL", err.Error())
+	}
+
+	if err := quarantined.load(filepath.Join(cfg.DataDir, "quarantine.json")); err != nil {
+		report.add("quarantine", "FAIL", err.Error())
+	}
+
+	if err := privateFiles.load(filepath.Join(cfg.DataDir, "private.json")); err != nil {
+		report.add("private files", "FAIL", err.Error())
+	}
+
+	if err := retentionFiles.load(filepath.Join(cfg.DataDir, "retention.json")); err != nil {
+		report.add("retention", "FAIL", err.Error())
+	}
+
+	if err := fileAlgorithms.load(filepath.Join(cfg.DataDir, "checksum-algorithms.json")); err != nil {
+		report.add("checksum algorithms", "FAIL", err.Error())
+	}
+
+	if err := manifests.load(filepath.Join(cfg.DataDir, "manifests.json")); err != nil {
+		report.add("manifests", "FAIL", err.Error())
+	}
+	if err := pipeline.load(filepath.Join(cfg.DataDir, "pipeline.json")); err != nil {
+		report.add("pipeline", "FAIL", err.Error())
+	}
+	if err := scheduler.load(filepath.Join(cfg.DataDir, "schedules.json")); err != nil {
+		report.add("schedules", "FAIL", err.Error())
+	}
+
+	if err := objectLocks.load(filepath.Join(cfg.DataDir, "locks.json")); err != nil {
+		report.add("object locks", "FAIL", err.Error())
+	}
+
+	if err := trash.load(filepath.Join(cfg.DataDir, "trash.json")); err != nil {
+		report.add("trash", "FAIL", err.Error())
+	}
+
+	if err := shortLinks.load(filepath.Join(cfg.DataDir, "shortlinks.json")); err != nil {
+		report.add("short links", "FAIL", err.Error())
+	}
+
+	if err := notifyPrefs.load(filepath.Join(cfg.DataDir, "notifications.json")); err != nil {
+		report.add("notifications", "FAIL", err.Error())
+	}
+
+	if err := teams.load(filepath.Join(cfg.DataDir, "teams.json")); err != nil {
+		report.add("teams", "FAIL", err.Error())
+	}
+
+	if err := downloads.load(filepath.Join(cfg.DataDir, "downloads.json")); err != nil {
+		report.add("download counts", "FAIL", err.Error())
+	}
+
+	if err := accessStats.load(filepath.Join(cfg.DataDir, "access-aggregates.json")); err != nil {
+		report.add("access aggregates", "FAIL", err.Error())
+	}
+
+	if err := feed.open(filepath.Join(cfg.DataDir, "changes.jsonl")); err != nil {
+		report.add("change feed", "FAIL", err.Error())
+	} else {
+		report.add("change feed", "OK", fmt.Sprintf("%d events", feed.last()))
+	}
+
+	if cfg.Transcoder != "" {
+		if path, err := exec.LookPath(cfg.Transcoder); err != nil {
+			report.add("transcoder", "FAIL", err.Error())
+		} else {
+			report.add("transcoder", "OK", path)
+		}
+	}
+
+	if err := setupNodeClient(cfg); err != nil {
+		report.add("node client", "FAIL", err.Error())
+	} else {
+		report.add("node client", "OK", fmt.Sprintf("%d idle connections per node", cfg.MaxIdleConnsPerNode))
+	}
+
+	// A single unreachable node is survivable (uploads still replicate to the
+	// others), but if none answer the cluster is unusable.
+	reachable := 0
+	for _, s := range cfg.Storages {
+		info, err := fetchNodeInfo(probeClient, s)
+		if err != nil {
+			health.set(s.ID, err)
+			report.add("storage "+s.ID, "WARN", "unreachable: "+err.Error())
+			continue
+		}
+		reachable++
+		status, err := identities.observe(s, info)
+		health.set(s.ID, err)
+		if err != nil {
+			report.add("storage "+s.ID, "WARN", err.Error())
+			continue
+		}
+		report.add("storage "+s.ID, "OK", fmt.Sprintf("%s uuid=%s identity=%s", s.URL, info.ID, status))
+	}
+	if len(cfg.Storages) > 0 && reachable == 0 {
+		report.add("storage", "FAIL", "no storage server is reachable")
+	}
+
+	return report
+}
+
+func checkWritableDir(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".selftest-*")
+	if err != nil {
+		return fmt.Errorf("not writable: %w", err)
+	}
+	name := f.Name()
+	f.Close()
+	return os.Remove(name)
+}
@@ -0,0 +1,327 @@
+package central
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+)
+
+// ---------------------------
+// Sessions
+// ---------------------------
+
+// A signed-in browser holds a random session id in the session cookie
+// (HttpOnly, SameSite=Lax, Secure over TLS or with secure_cookies). The
+// store keeps what the id stands for under its SHA-256, so a leaked store
+// file gives nobody a usable cookie.
+
+const sessionCookie = "session"
+
+type session struct {
+	User     string    `json:"user"`
+	Role     string    `json:"role"`
+	Provider string    `json:"provider,omitempty"` // "" = a configured user's password
+	Expires  time.Time `json:"expires"`
+}
+
+// sessionStore keeps sessions by the hash of their id. "memory" forgets
+// them on restart; "file" keeps them in DataDir/sessions.json.
+type sessionStore interface {
+	get(key string) (session, bool)
+	put(key string, s session) error
+	delete(key string) error
+	deleteUser(user string) (int, error)
+}
+
+var (
+	sessions      sessionStore = newMemorySessions()
+	sessionTTL                 = 12 * time.Hour
+	secureCookies bool
+)
+
+func newSessionStore(cfg Config) (sessionStore, error) {
+	switch cfg.SessionStore {
+	case "", "memory":
+		return newMemorySessions(), nil
+	case "file":
+		return loadFileSessions(filepath.Join(cfg.DataDir, "sessions.json"))
+	}
+	return nil, fmt.Errorf("unknown session_store %q (want memory or file)", cfg.SessionStore)
+}
+
+type memorySessions struct {
+	mu sync.Mutex
+	m  map[string]session
+}
+
+func newMemorySessions() *memorySessions {
+	return &memorySessions{m: map[string]session{}}
+}
+
+func (s *memorySessions) get(key string) (session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.m[key]
+	if ok && time.Now().After(sess.Expires) {
+		delete(s.m, key)
+		return session{}, false
+	}
+	return sess, ok
+}
+
+func (s *memorySessions) put(key string, sess session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pruneLocked()
+	s.m[key] = sess
+	return nil
+}
+
+func (s *memorySessions) delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.m, key)
+	return nil
+}
+
+// deleteUser signs user out everywhere, returning how many sessions that
+// ended.
+func (s *memorySessions) deleteUser(user string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.deleteUserLocked(user), nil
+}
+
+func (s *memorySessions) deleteUserLocked(user string) int {
+	n := 0
+	for k, sess := range s.m {
+		if sess.User == user {
+			delete(s.m, k)
+			n++
+		}
+	}
+	return n
+}
+
+func (s *memorySessions) pruneLocked() {
+	now := time.Now()
+	for k, sess := range s.m {
+		if now.After(sess.Expires) {
+			delete(s.m, k)
+		}
+	}
+}
+
+// fileSessions is memorySessions written through to a file.
+type fileSessions struct {
+	*memorySessions
+	path string
+}
+
+func loadFileSessions(path string) (*fileSessions, error) {
+	s := &fileSessions{memorySessions: newMemorySessions(), path: path}
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, &s.m); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	s.pruneLocked()
+	return s, nil
+}
+
+func (s *fileSessions) put(key string, sess session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pruneLocked()
+	s.m[key] = sess
+	return s.saveLocked()
+}
+
+func (s *fileSessions) delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.m, key)
+	return s.saveLocked()
+}
+
+func (s *fileSessions) deleteUser(user string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.deleteUserLocked(user)
+	if n == 0 {
+		return 0, nil
+	}
+	return n, s.saveLocked()
+}
+
+func (s *fileSessions) saveLocked() error {
+	raw, err := json.MarshalIndent(s.m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func sessionKey(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
+
+// currentSession returns the session r's cookie stands for. A configured
+// user's session follows the config: it ends when the user is removed,
+// and takes up a changed role.
+func currentSession(r *http.Request) (session, bool) {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil || c.Value == "" {
+		return session{}, false
+	}
+	sess, ok := sessions.get(sessionKey(c.Value))
+	if !ok {
+		return session{}, false
+	}
+	if sess.Provider == "" {
+		u, ok := users[sess.User]
+		if !ok {
+			return session{}, false
+		}
+		sess.Role = u.role()
+	} else if (sess.Provider == "oidc" && oidc == nil) || (sess.Provider == "ldap" && ldapAuth == nil) {
+		return session{}, false // the provider has been removed from the config
+	}
+	return sess, true
+}
+
+// sessionUser returns the user r's session cookie is signed in as, or "".
+func sessionUser(r *http.Request) string {
+	sess, _ := currentSession(r)
+	return sess.User
+}
+
+// startSession signs the browser in with a fresh session id.
+func startSession(w http.ResponseWriter, r *http.Request, sess session) error {
+	b := make([]byte, 32)
+	rand.Read(b)
+	id := base64.RawURLEncoding.EncodeToString(b)
+	expires := time.Now().Add(sessionTTL)
+	sess.Expires = expires
+	if err := sessions.put(sessionKey(id), sess); err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    id,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   secureCookies || r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// endSession signs the browser out.
+func endSession(w http.ResponseWriter, r *http.Request) error {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   secureCookies || r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	if c, err := r.Cookie(sessionCookie); err == nil && c.Value != "" {
+		return sessions.delete(sessionKey(c.Value))
+	}
+	return nil
+}
+
+// localRedirect returns next if it is a path on this site, else "/", so
+// the login form cannot be used to send users elsewhere.
+func localRedirect(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+// loginHandler shows the login page (GET /login) and signs in (POST).
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+	data := struct {
+		CSRFToken string
+		Next      string
+		User      string
+		Error     string
+		Passwords bool   // some configured user has a password, or there is a directory
+		Provider  string // the identity provider's name, if there is one
+	}{
+		CSRFToken: csrfToken(w, r),
+		Next:      localRedirect(r.FormValue("next")),
+		User:      r.PostFormValue("user"),
+	}
+	data.Passwords = ldapAuth != nil
+	for _, u := range users {
+		data.Passwords = data.Passwords || u.PasswordHash != ""
+	}
+	if oidc != nil {
+		data.Provider = oidc.cfg.Name
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if !loginRequired() {
+			http.Redirect(w, r, data.Next, http.StatusSeeOther)
+			return
+		}
+	case http.MethodPost:
+		id, provider, err := passwordLogin(data.User, r.PostFormValue("password"))
+		if err == nil {
+			if err := startSession(w, r, session{User: id.Name, Role: id.Role, Provider: provider}); err != nil {
+				fmt.Println("Session store error:", err)
+				apierr.Send(w, "Could not start a session", http.StatusInternalServerError)
+				return
+			}
+			fmt.Println("User", data.User, "signed in from", getClientIP(r))
+			http.Redirect(w, r, data.Next, http.StatusSeeOther)
+			return
+		}
+		fmt.Println("Failed sign-in as", data.User, "from", getClientIP(r)+":", err)
+		data.Error = "Wrong name or password"
+		w.WriteHeader(http.StatusUnauthorized)
+	default:
+		apierr.Send(w, "Use GET or POST", http.StatusMethodNotAllowed)
+		return
+	}
+	templates.ExecuteTemplate(w, "login.html", data)
+}
+
+// logoutHandler signs out: POST /logout.
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if err := endSession(w, r); err != nil {
+		fmt.Println("Session store error:", err)
+	}
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
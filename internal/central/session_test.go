@@ -0,0 +1,119 @@
+package central
+
+import (
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPasswordHash(t *testing.T) {
+	hash, err := hashPassword("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := parsePasswordHash(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !h.matches("hunter2") || h.matches("hunter3") {
+		t.Error("hash does not tell the password apart")
+	}
+	if errs := validateUsers([]User{{Name: "a", PasswordHash: hash}, {Name: "a", PasswordHash: "hunter2"}}); len(errs) != 2 {
+		t.Errorf("validateUsers = %v", errs)
+	}
+}
+
+func TestClusterLogin(t *testing.T) {
+	hash, _ := hashPassword("hunter2")
+	dataDir := t.TempDir()
+	c := newTestCluster(t, 1, func(cfg *Config) {
+		cfg.Users = []User{{Name: "alice", PasswordHash: hash}}
+		cfg.SessionStore = "file"
+		cfg.DataDir = dataDir
+	})
+	html := http.Header{"Accept": {"text/html"}}
+
+	// Anonymous: browsers are sent to sign in, scripts asked for basic auth.
+	if resp, _ := c.get("/files", html); resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != "/login?next=%2Ffiles" {
+		t.Fatalf("anonymous /files: %d %s", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	if resp, _ := c.upload("a.txt", "hello", nearLondon); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("anonymous upload: %d", resp.StatusCode)
+	}
+	if resp, _ := c.get("/get/a.txt", nil); resp.StatusCode == http.StatusUnauthorized {
+		t.Errorf("downloads need no sign-in: %d", resp.StatusCode)
+	}
+
+	resp, page := c.get("/login?next=/files", nil)
+	token := regexp.MustCompile(`name="csrf_token" value="([^"]+)"`).FindStringSubmatch(page)
+	if token == nil {
+		t.Fatalf("login page: %d %s", resp.StatusCode, page)
+	}
+	csrf := &http.Cookie{Name: csrfCookie, Value: token[1]}
+	login := func(password, next string) *http.Response {
+		t.Helper()
+		form := url.Values{"user": {"alice"}, "password": {password}, "csrf_token": {token[1]}, "next": {next}}
+		req, _ := http.NewRequest("POST", c.central.URL+"/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(csrf)
+		resp, err := testClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	if resp := login("wrong", "/files"); resp.StatusCode != http.StatusUnauthorized || len(resp.Cookies()) != 0 {
+		t.Errorf("wrong password: %d %v", resp.StatusCode, resp.Cookies())
+	}
+	resp = login("hunter2", "https://evil.example/")
+	if resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != "/" {
+		t.Fatalf("login: %d to %s", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	var sess *http.Cookie
+	for _, ck := range resp.Cookies() {
+		if ck.Name == sessionCookie {
+			sess = ck
+		}
+	}
+	if sess == nil || !sess.HttpOnly || sess.SameSite != http.SameSiteLaxMode || time.Until(sess.Expires) < 11*time.Hour {
+		t.Fatalf("session cookie %+v", sess)
+	}
+
+	signedIn := http.Header{"Accept": {"text/html"}, "Cookie": {sess.String() + "; " + csrf.String()}}
+	if resp, body := c.get("/", signedIn); resp.StatusCode != http.StatusOK || !strings.Contains(body, "Signed in as <strong>alice</strong>") {
+		t.Errorf("home page: %d", resp.StatusCode)
+	}
+
+	// Sessions outlive a restart with the file store.
+	reloaded, err := loadFileSessions(filepath.Join(dataDir, "sessions.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions = reloaded
+	if resp, _ := c.get("/files", signedIn); resp.StatusCode != http.StatusOK {
+		t.Errorf("after reload: %d", resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest("POST", c.central.URL+"/logout", strings.NewReader(url.Values{"csrf_token": {token[1]}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header["Cookie"] = signedIn["Cookie"]
+	if resp, err := testClient.Do(req); err != nil || resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("logout: %v %v", resp, err)
+	}
+	if resp, _ := c.get("/files", signedIn); resp.StatusCode != http.StatusSeeOther {
+		t.Errorf("signed out session still works: %d", resp.StatusCode)
+	}
+
+	// Scripts sign in with basic auth.
+	req, _ = http.NewRequest("GET", c.central.URL+"/files", nil)
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth("alice", "hunter2")
+	if resp, err := testClient.Do(req); err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("basic auth: %v %v", resp, err)
+	}
+}
@@ -0,0 +1,280 @@
+package central
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+)
+
+// ---------------------------
+// Short Links
+// ---------------------------
+
+// A short link, /s/{code}, hands a file out without its name: it
+// redirects to the file's download (so /get's routing and checks apply)
+// until it expires or has been used MaxDownloads times. Links are made
+// and revoked over /api/v1/links, and kept in DataDir/shortlinks.json.
+// Private files cannot be linked: a link is for anyone who has it.
+
+// ShortLink is one link.
+type ShortLink struct {
+	Code         string    `json:"code"`
+	Name         string    `json:"name"`
+	CreatedBy    string    `json:"created_by,omitempty"`
+	Created      time.Time `json:"created"`
+	Expires      time.Time `json:"expires"`
+	MaxDownloads int       `json:"max_downloads,omitempty"` // 0 = no limit
+	Downloads    int       `json:"downloads"`
+}
+
+func (l ShortLink) usable(now time.Time) bool {
+	return now.Before(l.Expires) && (l.MaxDownloads == 0 || l.Downloads < l.MaxDownloads)
+}
+
+type shortLinkRegistry struct {
+	mu    sync.Mutex
+	path  string
+	links map[string]ShortLink
+}
+
+var shortLinks = &shortLinkRegistry{links: map[string]ShortLink{}}
+
+// shortLinkTTL is how long a link lasts when its maker does not say.
+var shortLinkTTL = 7 * 24 * time.Hour
+
+func (reg *shortLinkRegistry) load(path string) error {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	reg.path = path
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, &reg.links)
+}
+
+func (reg *shortLinkRegistry) saveLocked() error {
+	if reg.path == "" {
+		return nil
+	}
+	raw, err := json.MarshalIndent(reg.links, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(reg.path), 0755); err != nil {
+		return err
+	}
+	tmp := reg.path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, reg.path)
+}
+
+// add stores l under a fresh code, dropping the links that are used up.
+func (reg *shortLinkRegistry) add(l ShortLink) (ShortLink, error) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	now := time.Now()
+	for code, old := range reg.links {
+		if !old.usable(now) {
+			delete(reg.links, code)
+		}
+	}
+	for {
+		b := make([]byte, 6)
+		rand.Read(b)
+		l.Code = base64.RawURLEncoding.EncodeToString(b)
+		if _, taken := reg.links[l.Code]; !taken {
+			break
+		}
+	}
+	reg.links[l.Code] = l
+	return l, reg.saveLocked()
+}
+
+// use counts a download through code and returns its link, or ok false if
+// there is no such link or it is used up.
+func (reg *shortLinkRegistry) use(code string) (ShortLink, bool) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	l, ok := reg.links[code]
+	if !ok || !l.usable(time.Now()) {
+		return l, false
+	}
+	l.Downloads++
+	reg.links[code] = l
+	if err := reg.saveLocked(); err != nil {
+		fmt.Println("Cannot save short links:", err)
+	}
+	return l, true
+}
+
+func (reg *shortLinkRegistry) get(code string) (ShortLink, bool) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	l, ok := reg.links[code]
+	return l, ok
+}
+
+func (reg *shortLinkRegistry) remove(code string) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	delete(reg.links, code)
+	if err := reg.saveLocked(); err != nil {
+		fmt.Println("Cannot save short links:", err)
+	}
+}
+
+// removeIf removes the links fn picks and returns how many.
+func (reg *shortLinkRegistry) removeIf(fn func(ShortLink) bool) int {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	n := 0
+	for code, l := range reg.links {
+		if fn(l) {
+			delete(reg.links, code)
+			n++
+		}
+	}
+	if n > 0 {
+		if err := reg.saveLocked(); err != nil {
+			fmt.Println("Cannot save short links:", err)
+		}
+	}
+	return n
+}
+
+// list returns the links made by user ("" for all), newest first.
+func (reg *shortLinkRegistry) list(user string) []ShortLink {
+	reg.mu.Lock()
+	out := []ShortLink{}
+	for _, l := range reg.links {
+		if user == "" || l.CreatedBy == user {
+			out = append(out, l)
+		}
+	}
+	reg.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Created.After(out[j].Created) })
+	return out
+}
+
+// mayManageLink reports whether id may see and revoke l: its maker, an
+// admin, or anyone without sign-in configured.
+func mayManageLink(id identity, l ShortLink) bool {
+	return !loginRequired() || roleRank[id.Role] >= roleRank[roleAdmin] || l.CreatedBy == id.Name
+}
+
+// shortLinkHandler follows a link: GET /s/{code}. Anything after ? (the
+// client's location, say) is passed on to /get.
+func shortLinkHandler(w http.ResponseWriter, r *http.Request) {
+	code := r.PathValue("code")
+	l, ok := shortLinks.use(code)
+	if !ok {
+		if _, exists := shortLinks.get(code); exists {
+			apierr.Send(w, "This link has expired", http.StatusGone)
+			return
+		}
+		http.NotFound(w, r)
+		return
+	}
+	target := "/get/" + l.Name
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// linksHandler lists the caller's links (an admin's: all of them) on GET,
+// and makes one on POST /api/v1/links {"name": ..., "expires_in": "24h",
+// "max_downloads": 5, "share_with": ["bob"]}; the users in share_with are
+// emailed the link.
+func linksHandler(w http.ResponseWriter, r *http.Request) {
+	id := signedIn(r)
+	if r.Method == http.MethodGet {
+		user := id.Name
+		if !loginRequired() || roleRank[id.Role] >= roleRank[roleAdmin] {
+			user = ""
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(shortLinks.list(user))
+		return
+	}
+	if r.Method != http.MethodPost {
+		apierr.Send(w, "Use GET or POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Name         string   `json:"name"`
+		ExpiresIn    Duration `json:"expires_in"`
+		MaxDownloads int      `json:"max_downloads"`
+		ShareWith    []string `json:"share_with"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<10)).Decode(&req); err != nil {
+		apierr.Send(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.ExpiresIn.Duration == 0 {
+		req.ExpiresIn.Duration = shortLinkTTL
+	}
+	if req.ExpiresIn.Duration < 0 || req.MaxDownloads < 0 {
+		apierr.Send(w, "expires_in and max_downloads must not be negative", http.StatusBadRequest)
+		return
+	}
+	if req.Name == "" || req.Name != filepath.Base(req.Name) || !statFile(r.Context(), req.Name).Exists || !canSee(id, req.Name) {
+		apierr.Send(w, "No such file", http.StatusNotFound)
+		return
+	}
+	if privateFiles.has(req.Name) {
+		apierr.Send(w, "Private files cannot be shared by link", http.StatusConflict)
+		return
+	}
+	now := time.Now().UTC()
+	l, err := shortLinks.add(ShortLink{
+		Name:         req.Name,
+		CreatedBy:    id.Name,
+		Created:      now,
+		Expires:      now.Add(req.ExpiresIn.Duration),
+		MaxDownloads: req.MaxDownloads,
+	})
+	if err != nil {
+		fmt.Println("Cannot save short links:", err)
+		apierr.Send(w, "Could not save the link", http.StatusInternalServerError)
+		return
+	}
+	fmt.Println("Short link", l.Code, "made for", l.Name)
+	notifyShare(r, l, req.ShareWith)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/s/"+l.Code)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(l)
+}
+
+// linkRevokeHandler revokes a link: DELETE /api/v1/links/{code}.
+func linkRevokeHandler(w http.ResponseWriter, r *http.Request) {
+	code := r.PathValue("code")
+	l, ok := shortLinks.get(code)
+	if !ok || !mayManageLink(signedIn(r), l) {
+		http.NotFound(w, r)
+		return
+	}
+	shortLinks.remove(code)
+	fmt.Println("Short link", code, "revoked")
+	w.WriteHeader(http.StatusNoContent)
+}
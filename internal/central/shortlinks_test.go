@@ -0,0 +1,59 @@
+package central
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestShortLinks(t *testing.T) {
+	c := newTestCluster(t, 1, nil)
+	if resp, _ := c.upload("a.txt", "hello", nearLondon); resp.StatusCode != http.StatusOK {
+		t.Fatalf("upload: %d", resp.StatusCode)
+	}
+	c.waitForJobs()
+
+	create := func(body string) (int, ShortLink) {
+		t.Helper()
+		resp, err := testClient.Post(c.central.URL+"/api/v1/links", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var l ShortLink
+		json.NewDecoder(resp.Body).Decode(&l)
+		return resp.StatusCode, l
+	}
+	if code, _ := create(`{"name": "missing.txt"}`); code != http.StatusNotFound {
+		t.Errorf("link to a missing file: %d", code)
+	}
+	code, l := create(`{"name": "a.txt", "max_downloads": 2}`)
+	if code != http.StatusCreated || l.Code == "" {
+		t.Fatalf("create: %d %+v", code, l)
+	}
+
+	for i, want := range []int{http.StatusFound, http.StatusFound, http.StatusGone} {
+		resp, _ := c.get("/s/"+l.Code+"?"+nearLondon.Encode(), nil)
+		if resp.StatusCode != want {
+			t.Fatalf("download %d: %d, want %d", i+1, resp.StatusCode, want)
+		}
+		if want == http.StatusFound && resp.Header.Get("Location") != "/get/a.txt?"+nearLondon.Encode() {
+			t.Errorf("redirected to %s", resp.Header.Get("Location"))
+		}
+	}
+
+	_, expired := create(`{"name": "a.txt", "expires_in": "1ns"}`)
+	if resp, _ := c.get("/s/"+expired.Code, nil); resp.StatusCode != http.StatusGone {
+		t.Errorf("expired link: %d", resp.StatusCode)
+	}
+
+	_, revoked := create(`{"name": "a.txt"}`)
+	req, _ := http.NewRequest("DELETE", c.central.URL+"/api/v1/links/"+revoked.Code, nil)
+	if resp, err := testClient.Do(req); err != nil || resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("revoke: %v %v", resp, err)
+	}
+	if resp, _ := c.get("/s/"+revoked.Code, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("revoked link: %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,196 @@
+package central
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ---------------------------
+// Slow Request Log
+// ---------------------------
+
+// Requests that take longer than slowRequestThreshold, or move more than
+// largePayloadBytes in either direction, are logged with their route, the
+// nodes they touched and where the time went. Zero disables either check.
+var (
+	slowRequestThreshold time.Duration
+	largePayloadBytes    int64
+)
+
+// slowRequestLog prints a slow request report (swapped out by tests).
+var slowRequestLog = func(line string) { fmt.Println(line) }
+
+// requestTrace collects the timing breakdown of one request. Handlers mark
+// the end of each phase and record the nodes they call; all methods are
+// no-ops on a nil trace, so handlers don't have to care whether the request
+// is being traced.
+type requestTrace struct {
+	mu     sync.Mutex
+	start  time.Time
+	last   time.Time
+	phases []tracePhase
+	nodes  []traceNode
+}
+
+type tracePhase struct {
+	name string
+	d    time.Duration
+}
+
+type traceNode struct {
+	id  string
+	d   time.Duration
+	err error
+}
+
+type traceKey struct{}
+
+func traceFrom(ctx context.Context) *requestTrace {
+	t, _ := ctx.Value(traceKey{}).(*requestTrace)
+	return t
+}
+
+// phase ends the current phase, which started at the previous mark (or the
+// start of the request).
+func (t *requestTrace) phase(name string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	t.phases = append(t.phases, tracePhase{name, now.Sub(t.last)})
+	t.last = now
+}
+
+// node records a call to a storage node.
+func (t *requestTrace) node(id string, d time.Duration, err error) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.nodes = append(t.nodes, traceNode{id, d, err})
+}
+
+// traceWriter counts the response bytes and remembers the status.
+type traceWriter struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
+
+func (w *traceWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *traceWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.written += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach Flush and friends.
+func (w *traceWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// logSlowRequests wraps the API handlers with the slow request log.
+func logSlowRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if slowRequestThreshold <= 0 && largePayloadBytes <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		now := time.Now()
+		trace := &requestTrace{start: now, last: now}
+		var read atomic.Int64
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = &countingReadCloser{countingReader{r: r.Body, n: &read}, r.Body}
+		}
+		tw := &traceWriter{ResponseWriter: w}
+		r = r.WithContext(context.WithValue(r.Context(), traceKey{}, trace))
+
+		next.ServeHTTP(tw, r)
+
+		elapsed := time.Since(now)
+		slow := slowRequestThreshold > 0 && elapsed >= slowRequestThreshold
+		large := largePayloadBytes > 0 && (read.Load() >= largePayloadBytes || tw.written >= largePayloadBytes)
+		if !slow && !large {
+			return
+		}
+		slowRequestLog(trace.report(r, tw, read.Load(), elapsed, slow, large))
+	})
+}
+
+// report formats one log line, e.g.
+//
+//	Slow request: POST /upload (route /upload) 200 in 3.2s, 48.0 MB in, 312 B out; phases receive=1.1s save=20ms replicate=2.1s; nodes sg[singapore]=180ms ny[new-york]=1.9s
+func (t *requestTrace) report(r *http.Request, w *traceWriter, read int64, elapsed time.Duration, slow, large bool) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	kind := "Slow request"
+	if !slow {
+		kind = "Large request"
+	} else if large {
+		kind = "Slow large request"
+	}
+	status := w.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	route := r.Pattern
+	if route == "" {
+		route = "none"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %s %s (route %s) %d in %s, %s in, %s out",
+		kind, r.Method, r.URL.Path, route, status, elapsed.Round(time.Millisecond),
+		formatBytes(read), formatBytes(w.written))
+	if len(t.phases) > 0 {
+		b.WriteString("; phases")
+		for _, p := range t.phases {
+			fmt.Fprintf(&b, " %s=%s", p.name, p.d.Round(time.Millisecond))
+		}
+	}
+	if len(t.nodes) > 0 {
+		b.WriteString("; nodes")
+		for _, n := range t.nodes {
+			b.WriteString(" " + n.id)
+			if info, _ := identities.get(n.id); info.Region != "" {
+				b.WriteString("[" + info.Region + "]")
+			}
+			fmt.Fprintf(&b, "=%s", n.d.Round(time.Millisecond))
+			if n.err != nil {
+				fmt.Fprintf(&b, " (%v)", n.err)
+			}
+		}
+	}
+	return b.String()
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1e9:
+		return fmt.Sprintf("%.1f GB", float64(n)/1e9)
+	case n >= 1e6:
+		return fmt.Sprintf("%.1f MB", float64(n)/1e6)
+	case n >= 1e3:
+		return fmt.Sprintf("%.1f KB", float64(n)/1e3)
+	}
+	return fmt.Sprintf("%d B", n)
+}
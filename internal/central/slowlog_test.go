@@ -0,0 +1,73 @@
+package central
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func captureSlowLog(t *testing.T) func() []string {
+	var mu sync.Mutex
+	var lines []string
+	prev := slowRequestLog
+	slowRequestLog = func(line string) {
+		mu.Lock()
+		defer mu.Unlock()
+		lines = append(lines, line)
+	}
+	t.Cleanup(func() { slowRequestLog = prev })
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), lines...)
+	}
+}
+
+func TestSlowLogLargeUpload(t *testing.T) {
+	lines := captureSlowLog(t)
+	c := newTestCluster(t, 2, func(cfg *Config) {
+		cfg.LargePayloadBytes = 1000
+	})
+
+	c.upload("small.txt", "tiny", nearLondon)
+	c.upload("big.bin", strings.Repeat("x", 2000), nearLondon)
+
+	got := lines()
+	if len(got) != 1 {
+		t.Fatalf("logged %d requests, want only the large upload: %q", len(got), got)
+	}
+	for _, want := range []string{
+		"Large request: POST /upload (route /upload) 200",
+		"KB in",
+		"phases receive=",
+		" replicate=",
+		"; nodes ",
+		"sg[singapore]=",
+		"ny[new-york]=",
+	} {
+		if !strings.Contains(got[0], want) {
+			t.Errorf("log line is missing %q:\n%s", want, got[0])
+		}
+	}
+}
+
+func TestSlowLogSlowRequest(t *testing.T) {
+	lines := captureSlowLog(t)
+	c := newTestCluster(t, 2, func(cfg *Config) {
+		cfg.SlowRequestThreshold = Duration{50 * time.Millisecond}
+		cfg.LargePayloadBytes = 0
+	})
+	c.delay("ny", 100*time.Millisecond)
+
+	c.upload("doc.txt", "contents", nearLondon)
+	c.get("/api/v1/nodes", nil)
+
+	got := lines()
+	if len(got) != 1 || !strings.HasPrefix(got[0], "Slow request: POST /upload") {
+		t.Fatalf("logged %q, want only the slow upload", got)
+	}
+	if !strings.Contains(got[0], "ny[new-york]=1") {
+		t.Errorf("slow node not visible in %q", got[0])
+	}
+}
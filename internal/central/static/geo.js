@@ -0,0 +1,32 @@
+// Use the browser's position (when the user allows it) instead of the
+// server's IP-based guess: pass it as lat/lon on this page and on links.
+(function () {
+    function apply(loc) {
+        document.querySelectorAll("a[data-geo]").forEach(function (a) {
+            var u = new URL(a.href);
+            u.searchParams.set("lat", loc.lat);
+            u.searchParams.set("lon", loc.lon);
+            a.href = u.toString();
+        });
+        var params = new URLSearchParams(window.location.search);
+        if (!params.has("lat")) {
+            params.set("lat", loc.lat);
+            params.set("lon", loc.lon);
+            window.location.replace(window.location.pathname + "?" + params.toString());
+        }
+    }
+
+    var cached = sessionStorage.getItem("geo");
+    if (cached) {
+        apply(JSON.parse(cached));
+        return;
+    }
+    if (!navigator.geolocation) {
+        return;
+    }
+    navigator.geolocation.getCurrentPosition(function (pos) {
+        var loc = {lat: pos.coords.latitude.toFixed(4), lon: pos.coords.longitude.toFixed(4)};
+        sessionStorage.setItem("geo", JSON.stringify(loc));
+        apply(loc);
+    });
+})();
@@ -0,0 +1,180 @@
+package central
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// ---------------------------
+// Node Call Stats
+// ---------------------------
+
+// Calls to storage nodes are counted in a ring of fixed-width time buckets,
+// so the stats always cover roughly the last statsWindow and old failures
+// age out on their own.
+const (
+	statsBucketWidth = 10 * time.Second
+	statsBuckets     = 30
+	statsWindow      = statsBucketWidth * statsBuckets
+
+	// Below this many calls in the window a node keeps a perfect score;
+	// one early failure should not demote it.
+	statsMinCalls = 5
+	// Nodes scoring below this are tried after the others.
+	degradedScore = 0.5
+	// Average latency at which the latency half of the score is halved.
+	statsLatencyRef = time.Second
+)
+
+const (
+	opUpload   = "upload"
+	opDelete   = "delete"
+	opList     = "list"
+	opDownload = "download"
+	opVerify   = "verify"
+)
+
+type statsBucket struct {
+	start   time.Time
+	ok      int
+	errors  int
+	latency time.Duration // sum over all calls
+}
+
+type opWindow struct {
+	buckets [statsBuckets]statsBucket
+}
+
+func (w *opWindow) add(now time.Time, d time.Duration, err error) {
+	start := now.Truncate(statsBucketWidth)
+	b := &w.buckets[start.Unix()/int64(statsBucketWidth/time.Second)%statsBuckets]
+	if !b.start.Equal(start) {
+		*b = statsBucket{start: start}
+	}
+	if err != nil {
+		b.errors++
+	} else {
+		b.ok++
+	}
+	b.latency += d
+}
+
+func (w *opWindow) sum(now time.Time) statsBucket {
+	var total statsBucket
+	for _, b := range w.buckets {
+		if now.Sub(b.start) >= statsWindow {
+			continue
+		}
+		total.ok += b.ok
+		total.errors += b.errors
+		total.latency += b.latency
+	}
+	return total
+}
+
+// OpStats summarizes one kind of call to a node over the window.
+type OpStats struct {
+	OK           int     `json:"ok"`
+	Errors       int     `json:"errors"`
+	ErrorRate    float64 `json:"error_rate"`
+	AvgLatencyMs float64 `json:"avg_latency_ms"`
+}
+
+// NodeStats is a node's recent call record and the health score derived
+// from it: 1 for a node that answers quickly and never fails, towards 0 as
+// errors and latency grow.
+type NodeStats struct {
+	Window string             `json:"window"`
+	Ops    map[string]OpStats `json:"ops"`
+	Score  float64            `json:"score"`
+}
+
+type statsTracker struct {
+	mu    sync.Mutex
+	nodes map[string]map[string]*opWindow // node ID -> op -> window
+	now   func() time.Time
+}
+
+var nodeStats = newStatsTracker()
+
+func newStatsTracker() *statsTracker {
+	return &statsTracker{nodes: map[string]map[string]*opWindow{}, now: time.Now}
+}
+
+// record counts one call of kind op to a node.
+func (st *statsTracker) record(nodeID, op string, d time.Duration, err error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	ops, ok := st.nodes[nodeID]
+	if !ok {
+		ops = map[string]*opWindow{}
+		st.nodes[nodeID] = ops
+	}
+	w, ok := ops[op]
+	if !ok {
+		w = &opWindow{}
+		ops[op] = w
+	}
+	w.add(st.now(), d, err)
+}
+
+func (st *statsTracker) get(nodeID string) NodeStats {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	now := st.now()
+	out := NodeStats{Window: statsWindow.String(), Ops: map[string]OpStats{}, Score: 1}
+	var total statsBucket
+	for op, w := range st.nodes[nodeID] {
+		b := w.sum(now)
+		if b.ok+b.errors == 0 {
+			continue
+		}
+		out.Ops[op] = opStatsOf(b)
+		total.ok += b.ok
+		total.errors += b.errors
+		total.latency += b.latency
+	}
+	if calls := total.ok + total.errors; calls >= statsMinCalls {
+		s := opStatsOf(total)
+		latency := time.Duration(s.AvgLatencyMs * float64(time.Millisecond))
+		out.Score = (1 - s.ErrorRate) / (1 + float64(latency)/float64(statsLatencyRef))
+	}
+	return out
+}
+
+func opStatsOf(b statsBucket) OpStats {
+	calls := b.ok + b.errors
+	return OpStats{
+		OK:           b.ok,
+		Errors:       b.errors,
+		ErrorRate:    float64(b.errors) / float64(calls),
+		AvgLatencyMs: float64(b.latency) / float64(calls) / float64(time.Millisecond),
+	}
+}
+
+// healthScore combines the probe result with the call stats: a node that
+// fails its probe scores 0 whatever its history.
+func healthScore(nodeID string) float64 {
+	if !health.isHealthy(nodeID) {
+		return 0
+	}
+	return nodeStats.get(nodeID).Score
+}
+
+// preferHealthy moves degraded nodes behind the others, keeping the
+// distance order within each group, so placement and routing only fall
+// back to a node that keeps failing or crawling when nothing better holds
+// the file.
+func preferHealthy(ranked []rankedNode) []rankedNode {
+	degraded := map[string]bool{}
+	for _, r := range ranked {
+		degraded[r.ID] = nodeStats.get(r.ID).Score < degradedScore
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return !degraded[ranked[i].ID] && degraded[ranked[j].ID]
+	})
+	return ranked
+}
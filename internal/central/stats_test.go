@@ -0,0 +1,70 @@
+package central
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestStatsWindow(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	st := newStatsTracker()
+	st.now = func() time.Time { return now }
+
+	if s := st.get("n1"); s.Score != 1 || len(s.Ops) != 0 {
+		t.Fatalf("unknown node = %+v, want a clean record", s)
+	}
+
+	boom := errors.New("boom")
+	for i := 0; i < 4; i++ {
+		st.record("n1", opUpload, 100*time.Millisecond, boom)
+	}
+	if s := st.get("n1"); s.Score != 1 {
+		t.Errorf("score after %d calls = %v, want 1 until statsMinCalls", 4, s.Score)
+	}
+	for i := 0; i < 4; i++ {
+		st.record("n1", opList, 100*time.Millisecond, nil)
+	}
+
+	s := st.get("n1")
+	if up := s.Ops[opUpload]; up.Errors != 4 || up.ErrorRate != 1 || up.AvgLatencyMs != 100 {
+		t.Errorf("upload stats = %+v", up)
+	}
+	// Half the calls failed, averaging 100ms: 0.5 / 1.1.
+	if want := 0.5 / 1.1; s.Score < want-1e-9 || s.Score > want+1e-9 {
+		t.Errorf("score = %v, want %v", s.Score, want)
+	}
+
+	// The failures age out of the window; newer calls stay.
+	now = now.Add(statsWindow - statsBucketWidth)
+	st.record("n1", opDelete, 0, nil)
+	now = now.Add(statsBucketWidth)
+	s = st.get("n1")
+	if _, ok := s.Ops[opUpload]; ok || s.Ops[opDelete].OK != 1 {
+		t.Errorf("after the window = %+v", s)
+	}
+	if s.Score != 1 {
+		t.Errorf("score after the window = %v, want 1", s.Score)
+	}
+}
+
+func TestPreferHealthyKeepsDistanceOrder(t *testing.T) {
+	resetState()
+	for i := 0; i < statsMinCalls; i++ {
+		nodeStats.record("b", opUpload, 0, errors.New("down"))
+	}
+	ranked := []rankedNode{
+		{StorageServer: StorageServer{ID: "a"}, Distance: 1},
+		{StorageServer: StorageServer{ID: "b"}, Distance: 2},
+		{StorageServer: StorageServer{ID: "c"}, Distance: 3},
+		{StorageServer: StorageServer{ID: "d"}, Distance: 4},
+	}
+	var got []string
+	for _, r := range preferHealthy(ranked) {
+		got = append(got, r.ID)
+	}
+	if want := []string{"a", "c", "d", "b"}; !slices.Equal(got, want) {
+		t.Errorf("order = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,170 @@
+package central
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/buildinfo"
+)
+
+// ---------------------------
+// Cluster Status
+// ---------------------------
+
+// Overall cluster states: ok when every node is healthy, degraded when some
+// are not but reads and writes still have somewhere to go, down when none is.
+const (
+	clusterOK       = "ok"
+	clusterDegraded = "degraded"
+	clusterDown     = "down"
+)
+
+// ClusterStatus is a one-request summary of the cluster for external
+// monitoring, GET /api/v1/cluster/status.
+type ClusterStatus struct {
+	Status      string             `json:"status"`
+	Time        time.Time          `json:"time"`
+	Version     VersionStatus      `json:"version"`
+	Nodes       NodeCounts         `json:"nodes"`
+	NodeList    []NodeSummary      `json:"node_list"`
+	Files       FileTotals         `json:"files"`
+	Replication ReplicationBacklog `json:"replication"`
+}
+
+// VersionStatus is the central API's build and, if the nodes run other
+// builds, all of them (see mixedVersions).
+type VersionStatus struct {
+	Central buildinfo.Info `json:"central"`
+	Mixed   []string       `json:"mixed,omitempty"`
+}
+
+type NodeCounts struct {
+	Total       int `json:"total"`
+	Healthy     int `json:"healthy"`
+	Unhealthy   int `json:"unhealthy"`
+	BreakerOpen int `json:"breaker_open"`
+	Full        int `json:"full"`
+}
+
+type NodeSummary struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Zone      string    `json:"zone,omitempty"`
+	Version   string    `json:"version,omitempty"`
+	Healthy   bool      `json:"healthy"`
+	LastCheck time.Time `json:"last_check,omitempty"`
+	LastError string    `json:"last_error,omitempty"`
+	Breaker   string    `json:"breaker"`
+	Full      bool      `json:"full"`
+}
+
+// FileTotals counts the central API's copies, which every upload makes.
+type FileTotals struct {
+	Count int64 `json:"count"`
+	Bytes int64 `json:"bytes"`
+}
+
+// ReplicationBacklog is the replication work not done yet: tasks waiting
+// in the async queue, uploads still being received or replicated, and
+// recent uploads that ended with fewer replicas than wanted, and the
+// repair and rebalancing copies under way.
+type ReplicationBacklog struct {
+	Factor        int                `json:"factor"` // 0 = all nodes
+	Queued        int                `json:"queued"`
+	QueuedBy      map[string]int     `json:"queued_by_priority,omitempty"`
+	QueueCapacity int                `json:"queue_capacity"`
+	InFlight      int                `json:"in_flight"`
+	Incomplete    int                `json:"incomplete"`
+	Transfers     []TransferProgress `json:"transfers,omitempty"`
+}
+
+func clusterStatus() ClusterStatus {
+	st := ClusterStatus{
+		Time:     time.Now().UTC(),
+		Version:  VersionStatus{Central: buildinfo.Get(), Mixed: mixedVersions()},
+		NodeList: []NodeSummary{},
+	}
+
+	for _, s := range topo.nodes() {
+		h := health.get(s.ID)
+		_, full := capacity.get(s.ID)
+		n := NodeSummary{
+			ID:        s.ID,
+			URL:       s.URL,
+			Zone:      s.Zone,
+			Version:   nodeBuild(s.ID).String(),
+			Healthy:   health.isHealthy(s.ID),
+			LastCheck: h.LastCheck,
+			LastError: h.LastError,
+			Breaker:   breakers.get(s.ID).State,
+			Full:      full,
+		}
+		st.NodeList = append(st.NodeList, n)
+
+		st.Nodes.Total++
+		if n.Healthy {
+			st.Nodes.Healthy++
+		} else {
+			st.Nodes.Unhealthy++
+		}
+		if n.Breaker == breakerOpen {
+			st.Nodes.BreakerOpen++
+		}
+		if n.Full {
+			st.Nodes.Full++
+		}
+	}
+	switch {
+	case st.Nodes.Healthy == 0:
+		st.Status = clusterDown
+	case st.Nodes.Unhealthy > 0:
+		st.Status = clusterDegraded
+	default:
+		st.Status = clusterOK
+	}
+
+	entries, _ := os.ReadDir(uploadDir)
+	for _, e := range entries {
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		if fi, err := e.Info(); err == nil && fi.Mode().IsRegular() {
+			st.Files.Count++
+			st.Files.Bytes += fi.Size()
+		}
+	}
+
+	st.Replication = replicationBacklog()
+	return st
+}
+
+func replicationBacklog() ReplicationBacklog {
+	b := ReplicationBacklog{Factor: int(replicationFactor.Load()), Transfers: transfers.list()}
+	if asyncQueue != nil {
+		b.Queued = asyncQueue.queue.len()
+		b.QueuedBy = asyncQueue.queue.counts()
+		b.QueueCapacity = asyncQueue.queue.capacity
+	}
+	uploadJobs.mu.Lock()
+	defer uploadJobs.mu.Unlock()
+	for _, j := range uploadJobs.jobs {
+		j.mu.Lock()
+		switch j.Status {
+		case uploadReceiving, uploadReplicating:
+			b.InFlight++
+		case uploadPartial:
+			b.Incomplete++
+		}
+		j.mu.Unlock()
+	}
+	return b
+}
+
+func clusterStatusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(clusterStatus())
+}
@@ -0,0 +1,113 @@
+package central
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/checksum"
+)
+
+// ---------------------------
+// Striped Files
+// ---------------------------
+
+// A striped file is cut into stripeSize parts placed round-robin across
+// the healthy nodes, one copy of each (RAID-0), instead of replicated: a
+// very large file then spreads over the cluster rather than filling every
+// node, and downloads fetch several stripes at once. Uploads of
+// stripeAbove bytes or more are striped, and any upload asking ?stripe=1.
+// The stripes are the parts of a manifest of kind striped (see
+// manifest.go), read and checked like any other.
+var (
+	stripeAbove    int64 // 0: only on request
+	stripeSize     int64 = 64 << 20
+	stripeParallel       = 4 // parts moved at once, by any composite file
+)
+
+// stripeReplicator stripes a file across its targets once it is complete
+// on disk, sending up to stripeParallel stripes at a time. A stripe that
+// cannot be written to its node goes to the next target; one no target
+// takes is left to the central copy, for fsck to place later.
+type stripeReplicator struct{}
+
+func (stripeReplicator) Replicate(ctx context.Context, job *uploadJob, filename string, p *payload, targets []StorageServer) error {
+	if len(targets) == 0 {
+		err := errors.New("no storage nodes to stripe across")
+		job.finish(err)
+		return err
+	}
+	rc, size, err := p.open(ctx)
+	if err != nil {
+		job.finish(err)
+		return err
+	}
+	defer rc.Close()
+	f := rc.(io.ReaderAt) // the copy on disk
+
+	m := newManifest(manifestStriped, filename, size, stripeSize, p.sum)
+	sem := make(chan struct{}, stripeParallel)
+	var wg sync.WaitGroup
+	for i := range m.Parts {
+		pt := &m.Parts[i]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			for k := range targets {
+				s := targets[(i+k)%len(targets)]
+				sum, err := sendStripe(ctx, job, s, filename, p.alg, *pt, io.NewSectionReader(f, pt.Offset, pt.Size))
+				if err == nil {
+					pt.Node, pt.Checksum = s.ID, sum
+					return
+				}
+				if ctx.Err() != nil {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	placed := 0
+	for i := range m.Parts {
+		pt := &m.Parts[i]
+		if pt.Node != "" {
+			placed++
+		} else if pt.Checksum, err = checksum.Of(p.alg, io.NewSectionReader(f, pt.Offset, pt.Size)); err != nil {
+			fmt.Println("Cannot hash stripe", i, "of", filename, ":", err)
+		}
+	}
+	if err := manifests.set(m); err != nil {
+		job.finish(err)
+		return err
+	}
+	fmt.Printf("Striped %s: %d of %d stripe(s) placed\n", filename, placed, len(m.Parts))
+	if ctx.Err() != nil && placed < len(m.Parts) {
+		return job.finishPartial(placed, len(m.Parts), context.Cause(ctx))
+	}
+	job.finish(nil)
+	return nil
+}
+
+// sendStripe writes one stripe to s and returns its checksum. Progress is
+// recorded on job as "<node>/<index>".
+func sendStripe(ctx context.Context, job *uploadJob, s StorageServer, filename, alg string, pt Part, r io.ReadSeeker) (string, error) {
+	rp := job.startReplica(s.ID+"/"+strconv.Itoa(pt.Index), pt.Size)
+	start := time.Now()
+	sum, err := sendPart(ctx, s, filename, alg, pt, r, &rp.sent)
+	job.trace.node(s.ID, time.Since(start), err)
+	job.finishReplica(rp, err)
+	if err != nil {
+		fmt.Println("Stripe", pt.Index, "of", filename, "to", s.URL, "failed:", err)
+		if outOfSpace(err) {
+			capacity.markFull(s.ID, err.Error())
+		}
+	}
+	return sum, err
+}
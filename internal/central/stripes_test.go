@@ -0,0 +1,103 @@
+package central
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestStripedUpload(t *testing.T) {
+	c := newTestCluster(t, 3, func(cfg *Config) { cfg.StripeSize = 10 })
+	content := strings.Repeat("0123456789", 4) + "tail!"
+	q := url.Values{"stripe": {"1"}}
+	for k, v := range nearLondon {
+		q[k] = v
+	}
+	if resp, job := c.upload("big.bin", content, q); resp.StatusCode != http.StatusOK || len(job.Replicas) != 5 {
+		t.Fatalf("upload = %d with %d stripe(s), want 200 with 5", resp.StatusCode, len(job.Replicas))
+	}
+
+	_, body := c.get("/api/v1/files/big.bin/manifest", nil)
+	var m Manifest
+	if err := json.Unmarshal([]byte(body), &m); err != nil {
+		t.Fatalf("manifest: %v: %s", err, body)
+	}
+	if len(m.Parts) != 5 || m.Parts[4].Size != 5 {
+		t.Fatalf("manifest = %+v, want 5 stripes, the last of 5 bytes", m)
+	}
+	nodes := map[string]bool{}
+	for _, st := range m.Parts {
+		nodes[st.Node] = true
+		if got := c.holders(partName("big.bin", st.Index)); !slices.Equal(got, []string{st.Node}) {
+			t.Errorf("stripe %d on %v, want only %s", st.Index, got, st.Node)
+		}
+	}
+	if len(nodes) != 3 {
+		t.Errorf("stripes on %d node(s), want all 3", len(nodes))
+	}
+	if got := c.holders("big.bin"); len(got) != 0 {
+		t.Errorf("whole file replicated to %v", got)
+	}
+	if f := c.listing()["big.bin"]; f.Parts != 5 || f.Composite != manifestStriped || !f.Consistent || f.Checksum != m.Checksum {
+		t.Errorf("listing = %d stripes, consistent %v, %s", f.Parts, f.Consistent, f.Checksum)
+	}
+
+	resp, got := c.get("/download/big.bin?"+nearLondon.Encode(), nil)
+	if got != content || resp.Header.Get("X-Parts") != "5" {
+		t.Errorf("download = %q (%s stripes), want %q", got, resp.Header.Get("X-Parts"), content)
+	}
+	if report := c.fsck(false); len(report.Issues) != 0 {
+		t.Errorf("fsck issues = %v", issueKeys(report))
+	}
+
+	// A corrupt stripe is read from the central copy, and fsck rewrites it.
+	bad := m.Parts[2]
+	c.node(bad.Node).backend.Put(partName("big.bin", 2), strings.NewReader("XXXXXXXXXX"))
+	if _, got := c.get("/download/big.bin", nil); got != content {
+		t.Errorf("download with a corrupt stripe = %q", got)
+	}
+	if got := issueKeys(c.fsck(true)); !slices.Equal(got, []string{"big.bin/" + bad.Node + ":" + fsckChecksumMismatch}) {
+		t.Errorf("fsck issues = %v", got)
+	}
+	if got := issueKeys(c.fsck(false)); len(got) != 0 {
+		t.Errorf("fsck issues after repair = %v", got)
+	}
+
+	// Replaced by a file replicated as usual, its stripes go.
+	c.upload("big.bin", "small", nearLondon)
+	if _, ok := manifests.get("big.bin"); ok {
+		t.Error("manifest kept after a plain upload")
+	}
+	for _, st := range m.Parts {
+		if got := c.holders(partName("big.bin", st.Index)); len(got) != 0 {
+			t.Errorf("stripe %d left on %v", st.Index, got)
+		}
+	}
+	if _, got := c.get("/download/big.bin", nil); got != "small" {
+		t.Errorf("download = %q, want the new file", got)
+	}
+}
+
+func TestStripedDelete(t *testing.T) {
+	c := newTestCluster(t, 2, func(cfg *Config) {
+		cfg.StripeSize = 4
+		cfg.StripeAbove = 8
+	})
+	c.upload("auto.bin", "striped by size", nearLondon)
+	m, ok := manifests.get("auto.bin")
+	if !ok || len(m.Parts) != 4 {
+		t.Fatalf("manifest = %+v %v, want 4 stripes", m, ok)
+	}
+	c.delete("auto.bin")
+	if _, ok := manifests.get("auto.bin"); ok {
+		t.Error("manifest kept after delete")
+	}
+	for _, st := range m.Parts {
+		if got := c.holders(partName("auto.bin", st.Index)); len(got) != 0 {
+			t.Errorf("stripe %d left on %v", st.Index, got)
+		}
+	}
+}
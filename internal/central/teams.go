@@ -0,0 +1,344 @@
+package central
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+)
+
+// ---------------------------
+// Groups and Team Folders
+// ---------------------------
+
+// Besides their homes, users share team folders: a folder grants groups of
+// users a role on it, viewer to see its files in the listing, editor to
+// upload into it and delete from it, whatever their own role. A member of
+// several groups gets the highest role any of them has. A folder's files
+// are stored as "t~<folder>~<file>" (/t/<folder>/<file>), as homes are, and
+// a folder may name the bucket its uploads go to. Admins manage both over
+// /api/v1/admin/groups and /api/v1/admin/folders; they are kept in
+// DataDir/teams.json.
+
+const folderMarker = "t~"
+
+// Group is a named set of users.
+type Group struct {
+	Name    string   `json:"name"`
+	Members []string `json:"members"`
+}
+
+// Folder is a team folder and the role each group has on it.
+type Folder struct {
+	Name   string            `json:"name"`
+	Groups map[string]string `json:"groups"`           // group -> viewer or editor
+	Bucket string            `json:"bucket,omitempty"` // for uploads that name none
+}
+
+type teamRegistry struct {
+	mu      sync.Mutex
+	path    string
+	Groups  map[string]Group  `json:"groups"`
+	Folders map[string]Folder `json:"folders"`
+}
+
+var teams = &teamRegistry{Groups: map[string]Group{}, Folders: map[string]Folder{}}
+
+func (reg *teamRegistry) load(path string) error {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	reg.path = path
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw, reg); err != nil {
+		return err
+	}
+	if reg.Groups == nil {
+		reg.Groups = map[string]Group{}
+	}
+	if reg.Folders == nil {
+		reg.Folders = map[string]Folder{}
+	}
+	return nil
+}
+
+func (reg *teamRegistry) saveLocked() error {
+	if reg.path == "" {
+		return nil
+	}
+	raw, err := json.MarshalIndent(reg, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(reg.path), 0755); err != nil {
+		return err
+	}
+	tmp := reg.path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, reg.path)
+}
+
+// folderRole returns user's role on folder: the highest of their groups'
+// roles on it, or "" for none.
+func (reg *teamRegistry) folderRole(user, folder string) string {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	f, ok := reg.Folders[folder]
+	if !ok {
+		return ""
+	}
+	role := ""
+	for group, r := range f.Groups {
+		for _, m := range reg.Groups[group].Members {
+			if m == user && roleRank[r] > roleRank[role] {
+				role = r
+			}
+		}
+	}
+	return role
+}
+
+// groupsOf returns the groups user is a member of, sorted.
+func (reg *teamRegistry) groupsOf(user string) []string {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	var out []string
+	for name, g := range reg.Groups {
+		if slices.Contains(g.Members, user) {
+			out = append(out, name)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// removeMember takes user out of every group and returns the groups it
+// was in, sorted.
+func (reg *teamRegistry) removeMember(user string) ([]string, error) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	var out []string
+	for name, g := range reg.Groups {
+		if i := slices.Index(g.Members, user); i >= 0 {
+			g.Members = slices.Delete(slices.Clone(g.Members), i, i+1)
+			reg.Groups[name] = g
+			out = append(out, name)
+		}
+	}
+	if out == nil {
+		return nil, nil
+	}
+	sort.Strings(out)
+	return out, reg.saveLocked()
+}
+
+// folder returns the named folder.
+func (reg *teamRegistry) folder(name string) (Folder, bool) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	f, ok := reg.Folders[name]
+	return f, ok
+}
+
+// folderName returns the stored name of file in folder.
+func folderName(folder, file string) string {
+	return folderMarker + folder + "~" + file
+}
+
+// splitFolderName returns the folder and the file of a stored name, or ok
+// false for a file in no folder.
+func splitFolderName(name string) (folder, file string, ok bool) {
+	return splitMarked(name, folderMarker)
+}
+
+// folderAccess returns id's role on the folder a stored name is in, and
+// whether it is in one at all.
+func folderAccess(id identity, name string) (string, bool) {
+	folder, _, ok := splitFolderName(name)
+	if !ok {
+		return "", false
+	}
+	return teams.folderRole(id.Name, folder), true
+}
+
+// ---------------------------
+// Admin API
+// ---------------------------
+
+// groupsHandler lists the groups: GET /api/v1/admin/groups.
+func groupsHandler(w http.ResponseWriter, r *http.Request) {
+	teams.mu.Lock()
+	out := make([]Group, 0, len(teams.Groups))
+	for _, g := range teams.Groups {
+		out = append(out, g)
+	}
+	teams.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// groupPutHandler creates or replaces a group:
+// PUT /api/v1/admin/groups/{name} {"members": [...]}.
+func groupPutHandler(w http.ResponseWriter, r *http.Request) {
+	g := Group{Name: r.PathValue("name")}
+	if !validBucket.MatchString(g.Name) {
+		apierr.Send(w, "Invalid group name", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		Members []string `json:"members"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		apierr.Send(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	seen := map[string]bool{}
+	for _, m := range req.Members {
+		if m != "" && !seen[m] {
+			g.Members = append(g.Members, m)
+			seen[m] = true
+		}
+	}
+	sort.Strings(g.Members)
+
+	teams.mu.Lock()
+	teams.Groups[g.Name] = g
+	err := teams.saveLocked()
+	teams.mu.Unlock()
+	if err != nil {
+		fmt.Println("Cannot save teams:", err)
+		apierr.Send(w, "Could not save the group", http.StatusInternalServerError)
+		return
+	}
+	fmt.Println("Group", g.Name, "now has", len(g.Members), "members")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(g)
+}
+
+// groupDeleteHandler removes a group, and its roles on every folder:
+// DELETE /api/v1/admin/groups/{name}.
+func groupDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	teams.mu.Lock()
+	_, ok := teams.Groups[name]
+	if ok {
+		delete(teams.Groups, name)
+		for _, f := range teams.Folders {
+			delete(f.Groups, name)
+		}
+	}
+	err := teams.saveLocked()
+	teams.mu.Unlock()
+	if !ok {
+		apierr.Send(w, "No such group", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		fmt.Println("Cannot save teams:", err)
+	}
+	fmt.Println("Group", name, "removed")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// foldersHandler lists the team folders: GET /api/v1/admin/folders.
+func foldersHandler(w http.ResponseWriter, r *http.Request) {
+	teams.mu.Lock()
+	out := make([]Folder, 0, len(teams.Folders))
+	for _, f := range teams.Folders {
+		out = append(out, f)
+	}
+	teams.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// folderPutHandler creates a folder or changes who has it:
+// PUT /api/v1/admin/folders/{name} {"groups": {"eng": "editor"}, "bucket": ""}.
+func folderPutHandler(w http.ResponseWriter, r *http.Request) {
+	f := Folder{Name: r.PathValue("name")}
+	if !validBucket.MatchString(f.Name) {
+		apierr.Send(w, "Invalid folder name", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		Groups map[string]string `json:"groups"`
+		Bucket string            `json:"bucket"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		apierr.Send(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, ok := replicatorFor(req.Bucket); !ok {
+		apierr.Send(w, "Unknown bucket "+req.Bucket, http.StatusBadRequest)
+		return
+	}
+	f.Bucket = req.Bucket
+	f.Groups = map[string]string{}
+
+	teams.mu.Lock()
+	defer teams.mu.Unlock()
+	for group, role := range req.Groups {
+		if _, ok := teams.Groups[group]; !ok {
+			apierr.Send(w, "No such group "+group, http.StatusBadRequest)
+			return
+		}
+		if role != roleViewer && role != roleEditor {
+			apierr.Send(w, fmt.Sprintf("Group %s: unknown role %q (want viewer or editor)", group, role), http.StatusBadRequest)
+			return
+		}
+		f.Groups[group] = role
+	}
+	teams.Folders[f.Name] = f
+	if err := teams.saveLocked(); err != nil {
+		fmt.Println("Cannot save teams:", err)
+		apierr.Send(w, "Could not save the folder", http.StatusInternalServerError)
+		return
+	}
+	fmt.Println("Folder", f.Name, "now shared with", len(f.Groups), "groups")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(f)
+}
+
+// folderDeleteHandler removes a folder: DELETE /api/v1/admin/folders/{name}.
+// Its files stay, seen and deleted by admins only.
+func folderDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	teams.mu.Lock()
+	_, ok := teams.Folders[name]
+	delete(teams.Folders, name)
+	err := teams.saveLocked()
+	teams.mu.Unlock()
+	if !ok {
+		apierr.Send(w, "No such folder", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		fmt.Println("Cannot save teams:", err)
+	}
+	fmt.Println("Folder", name, "removed")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// folderFileHandler downloads a file by its folder path:
+// GET /t/{folder}/{file}.
+func folderFileHandler(w http.ResponseWriter, r *http.Request) {
+	r.SetPathValue("filename", folderName(r.PathValue("folder"), r.PathValue("file")))
+	downloadHandler(w, r)
+}
@@ -0,0 +1,148 @@
+package central
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTeamFolders(t *testing.T) {
+	hash, _ := hashPassword("pw")
+	c := newTestCluster(t, 1, func(cfg *Config) {
+		cfg.AdminToken = "s3cret"
+		cfg.Users = []User{
+			{Name: "alice", PasswordHash: hash},
+			{Name: "bob", PasswordHash: hash, Role: roleViewer},
+			{Name: "carol", PasswordHash: hash},
+		}
+	})
+
+	admin := func(method, path, body string) int {
+		t.Helper()
+		req, _ := http.NewRequest(method, c.central.URL+path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer s3cret")
+		resp, err := testClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	as := func(user string, req *http.Request) *http.Response {
+		t.Helper()
+		req.SetBasicAuth(user, "pw")
+		req.Header.Set("Accept", "application/json")
+		resp, err := testClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	upload := func(user, folder, name string) int {
+		t.Helper()
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		mw.WriteField("folder", folder)
+		fw, _ := mw.CreateFormFile("file", name)
+		io.WriteString(fw, "plans")
+		mw.Close()
+		req, _ := http.NewRequest("POST", c.central.URL+"/upload?"+nearLondon.Encode(), &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		resp := as(user, req)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	sees := func(user, name string) bool {
+		t.Helper()
+		req, _ := http.NewRequest("GET", c.central.URL+"/files", nil)
+		resp := as(user, req)
+		defer resp.Body.Close()
+		var files []FileListing
+		json.NewDecoder(resp.Body).Decode(&files)
+		for _, f := range files {
+			if f.Name == name {
+				return true
+			}
+		}
+		return false
+	}
+	del := func(user, name string) int {
+		t.Helper()
+		req, _ := http.NewRequest("POST", c.central.URL+"/delete", strings.NewReader(url.Values{"filename": {name}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		resp := as(user, req)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := admin("PUT", "/api/v1/admin/folders/design", `{"groups": {"eng": "editor"}}`); code != http.StatusBadRequest {
+		t.Errorf("folder for a missing group: %d", code)
+	}
+	for path, body := range map[string]string{
+		"/api/v1/admin/groups/eng":    `{"members": ["alice"]}`,
+		"/api/v1/admin/groups/review": `{"members": ["bob", "alice"]}`,
+	} {
+		if code := admin("PUT", path, body); code != http.StatusOK {
+			t.Fatalf("PUT %s: %d", path, code)
+		}
+	}
+	if code := admin("PUT", "/api/v1/admin/folders/design", `{"groups": {"eng": "editor", "review": "viewer"}}`); code != http.StatusOK {
+		t.Fatalf("PUT folder: %d", code)
+	}
+	if code := admin("PUT", "/api/v1/admin/folders/x", `{"groups": {"eng": "admin"}}`); code != http.StatusBadRequest {
+		t.Errorf("folder admin role: %d", code)
+	}
+
+	stored := folderName("design", "plan.txt")
+	if code := upload("alice", "design", "plan.txt"); code != http.StatusOK {
+		t.Fatalf("alice uploading to design: %d", code)
+	}
+	c.waitForJobs()
+	if code := upload("bob", "design", "b.txt"); code != http.StatusForbidden {
+		t.Errorf("viewer uploading: %d", code)
+	}
+	if code := upload("carol", "design", "c.txt"); code != http.StatusForbidden {
+		t.Errorf("non-member uploading: %d", code)
+	}
+	if code := upload("carol", "nope", "c.txt"); code != http.StatusForbidden {
+		t.Errorf("uploading to a missing folder: %d", code)
+	}
+
+	if !sees("alice", stored) || !sees("bob", stored) || sees("carol", stored) {
+		t.Errorf("listings: alice %v, bob %v, carol %v", sees("alice", stored), sees("bob", stored), sees("carol", stored))
+	}
+	if resp, body := c.get("/t/design/plan.txt?"+nearLondon.Encode(), nil); resp.StatusCode != http.StatusOK || body != "plans" {
+		t.Errorf("folder path download: %d %q", resp.StatusCode, body)
+	}
+	if code := del("bob", stored); code != http.StatusForbidden {
+		t.Errorf("viewer deleting: %d", code)
+	}
+
+	// Removing the group takes its role on the folder with it.
+	if code := admin("DELETE", "/api/v1/admin/groups/review", ""); code != http.StatusNoContent {
+		t.Fatalf("DELETE group: %d", code)
+	}
+	if sees("bob", stored) {
+		t.Error("bob still sees the folder")
+	}
+	if code := del("alice", stored); code != http.StatusSeeOther {
+		t.Errorf("editor deleting: %d", code)
+	}
+
+	// Teams survive a restart.
+	path := filepath.Join(t.TempDir(), "teams.json")
+	teams.load(path)
+	teams.mu.Lock()
+	teams.saveLocked()
+	teams.mu.Unlock()
+	reloaded := &teamRegistry{}
+	if err := reloaded.load(path); err != nil || reloaded.folderRole("alice", "design") != roleEditor {
+		t.Errorf("reloaded: %v %+v", err, reloaded)
+	}
+}
@@ -0,0 +1,121 @@
+package central
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// ---------------------------
+// Topology
+// ---------------------------
+
+// topology merges the storage nodes known from each source. Later sources
+// win on ID collisions: statically configured nodes can be overridden by
+// discovered ones (DNS), and both by nodes that registered themselves (e.g.
+// a redeployed droplet with a new IP).
+type topology struct {
+	mu         sync.RWMutex
+	static     []StorageServer
+	discovered []StorageServer
+	registered map[string]StorageServer
+}
+
+var topo = &topology{registered: map[string]StorageServer{}}
+
+func (t *topology) setStatic(nodes []StorageServer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.static = append([]StorageServer(nil), nodes...)
+}
+
+func (t *topology) setDiscovered(nodes []StorageServer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.discovered = append([]StorageServer(nil), nodes...)
+}
+
+// nodes returns a snapshot in a stable order: configured nodes first, then
+// nodes that only exist in later sources, sorted by ID.
+func (t *topology) nodes() []StorageServer {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var registered []StorageServer
+	for _, s := range t.registered {
+		registered = append(registered, s)
+	}
+	sort.Slice(registered, func(i, j int) bool { return registered[i].ID < registered[j].ID })
+
+	var out []StorageServer
+	index := map[string]int{}
+	for _, layer := range [][]StorageServer{t.static, t.discovered, registered} {
+		for _, s := range layer {
+			if i, ok := index[s.ID]; ok {
+				out[i] = s
+				continue
+			}
+			index[s.ID] = len(out)
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func (t *topology) get(id string) (StorageServer, bool) {
+	for _, s := range t.nodes() {
+		if s.ID == id {
+			return s, true
+		}
+	}
+	return StorageServer{}, false
+}
+
+func (t *topology) register(s StorageServer) error {
+	if errs := validateStorage(s); len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for id, r := range t.registered {
+		if id != s.ID && r.URL == s.URL {
+			return fmt.Errorf("url %s is already registered by node %s", s.URL, id)
+		}
+	}
+	t.registered[s.ID] = s
+	return nil
+}
+
+func (t *topology) deregister(id string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, ok := t.registered[id]
+	delete(t.registered, id)
+	return ok
+}
+
+// validateStorage checks a single node definition.
+func validateStorage(s StorageServer) []error {
+	var errs []error
+	if s.ID == "" {
+		errs = append(errs, errors.New("id must not be empty"))
+	}
+	u, err := url.Parse(s.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("url %q must be an absolute http(s) URL", s.URL))
+	}
+	if s.Socket != "" && !filepath.IsAbs(s.Socket) {
+		errs = append(errs, fmt.Errorf("socket %q must be an absolute path", s.Socket))
+	}
+	if s.Lat < -90 || s.Lat > 90 {
+		errs = append(errs, fmt.Errorf("lat %.4f out of range", s.Lat))
+	}
+	if s.Lon < -180 || s.Lon > 180 {
+		errs = append(errs, fmt.Errorf("lon %.4f out of range", s.Lon))
+	}
+	return errs
+}
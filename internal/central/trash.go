@@ -0,0 +1,376 @@
+package central
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+)
+
+// ---------------------------
+// Trash
+// ---------------------------
+
+// Deleting a file moves it to the trash rather than removing it. Its
+// central copy goes to DataDir/trash, out of the listing and /files/, and
+// the nodes get the trash list as they get the quarantine list, keeping
+// their replicas but answering for them as if they were gone. Those who
+// could delete it can restore it until its retention (Config.TrashRetention)
+// is over; then purgeTrash deletes the replicas everywhere, as deleting
+// did before. The change feed hears of the delete only then, so nodes
+// following it keep their replicas meanwhile. Uploading a file by the
+// same name empties it from the trash.
+//
+// With no retention, deleting removes the file at once.
+
+// TrashEntry is one deleted file waiting out its retention.
+type TrashEntry struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	DeletedBy string    `json:"deleted_by,omitempty"`
+	Deleted   time.Time `json:"deleted"`
+	PurgeAt   time.Time `json:"purge_at"`
+}
+
+type trashRegistry struct {
+	mu      sync.Mutex
+	path    string
+	entries map[string]TrashEntry
+}
+
+var trash = &trashRegistry{entries: map[string]TrashEntry{}}
+
+var (
+	trashDir       string        // the trashed central copies
+	trashRetention time.Duration // 0: delete at once
+)
+
+func (reg *trashRegistry) load(path string) error {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	reg.path = path
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, &reg.entries)
+}
+
+func (reg *trashRegistry) saveLocked() error {
+	if reg.path == "" {
+		return nil
+	}
+	raw, err := json.MarshalIndent(reg.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(reg.path), 0755); err != nil {
+		return err
+	}
+	tmp := reg.path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, reg.path)
+}
+
+func (reg *trashRegistry) add(e TrashEntry) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.entries[e.Name] = e
+	if err := reg.saveLocked(); err != nil {
+		fmt.Println("Cannot save trash:", err)
+	}
+}
+
+// remove takes name out of the trash, reporting whether it was there.
+func (reg *trashRegistry) remove(name string) bool {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if _, ok := reg.entries[name]; !ok {
+		return false
+	}
+	delete(reg.entries, name)
+	if err := reg.saveLocked(); err != nil {
+		fmt.Println("Cannot save trash:", err)
+	}
+	return true
+}
+
+func (reg *trashRegistry) get(name string) (TrashEntry, bool) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	e, ok := reg.entries[name]
+	return e, ok
+}
+
+func (reg *trashRegistry) has(name string) bool {
+	_, ok := reg.get(name)
+	return ok
+}
+
+// list returns the entries, by name.
+func (reg *trashRegistry) list() []TrashEntry {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	out := make([]TrashEntry, 0, len(reg.entries))
+	for _, e := range reg.entries {
+		out = append(out, e)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// names returns the trashed names, sorted: the list the nodes get.
+func (reg *trashRegistry) names() []string {
+	entries := reg.list()
+	names := make([]string, len(entries))
+	for i, e := range entries {
+		names[i] = e.Name
+	}
+	return names
+}
+
+// relabel puts to for from as the deleter of every entry, and returns
+// how many it changed.
+func (reg *trashRegistry) relabel(from, to string) int {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	n := 0
+	for name, e := range reg.entries {
+		if e.DeletedBy == from {
+			e.DeletedBy = to
+			reg.entries[name] = e
+			n++
+		}
+	}
+	if n > 0 {
+		if err := reg.saveLocked(); err != nil {
+			fmt.Println("Cannot save trash:", err)
+		}
+	}
+	return n
+}
+
+// due returns the entries whose retention is over at now.
+func (reg *trashRegistry) due(now time.Time) []TrashEntry {
+	var out []TrashEntry
+	for _, e := range reg.list() {
+		if !now.Before(e.PurgeAt) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// trashDrifted reports whether a node's trash list, by the digest in its
+// /info, differs from the cluster's.
+func trashDrifted(digest string) bool {
+	return digest != quarantineDigest(withParts(trash.names()))
+}
+
+// syncTrash sends the trash list to every node and returns the errors by
+// node ID. Nodes it misses get it when they are next probed.
+func syncTrash(ctx context.Context) map[string]string {
+	names := withParts(trash.names())
+	var mu sync.Mutex
+	var errs map[string]string
+	var wg sync.WaitGroup
+	for _, s := range topo.nodes() {
+		wg.Add(1)
+		go func(s StorageServer) {
+			defer wg.Done()
+			if err := pushNameList(ctx, s, "/api/v1/trash", names); err != nil {
+				mu.Lock()
+				if errs == nil {
+					errs = map[string]string{}
+				}
+				errs[s.ID] = err.Error()
+				mu.Unlock()
+			}
+		}(s)
+	}
+	wg.Wait()
+	return errs
+}
+
+// moveFile renames src to dst, copying across file systems.
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".move-*")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(tmp, in); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Remove(src)
+}
+
+// trashFile moves name to the trash, for by. The nodes are told before the
+// central copy moves, so no replica is served once it is gone from the
+// listing. A file without a central copy has nothing to restore from and
+// is deleted at once.
+func trashFile(ctx context.Context, name, by string) error {
+	src := filepath.Join(uploadDir, name)
+	fi, err := os.Stat(src)
+	if err != nil || fi.IsDir() {
+		deleteEverywhere(ctx, name)
+		return nil
+	}
+	if err := os.MkdirAll(trashDir, 0755); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	e := TrashEntry{Name: name, Size: fi.Size(), DeletedBy: by, Deleted: now, PurgeAt: now.Add(trashRetention)}
+	trash.add(e)
+	if errs := syncTrash(ctx); len(errs) > 0 {
+		fmt.Println("Cannot send trash list:", errs)
+	}
+	if err := moveFile(src, filepath.Join(trashDir, name)); err != nil {
+		trash.remove(name)
+		syncTrash(ctx)
+		return fmt.Errorf("cannot move %s to the trash: %w", name, err)
+	}
+	fileChanges.Remove(name)
+	goBackground(func() { unindexFile(name) })
+	fmt.Println("Moved to trash:", name, "until", e.PurgeAt.Format(time.RFC3339))
+	return nil
+}
+
+// discardTrashed drops a trashed copy of name, which a new upload by that
+// name has replaced on the nodes.
+func discardTrashed(name string) {
+	if !trash.remove(name) {
+		return
+	}
+	os.Remove(filepath.Join(trashDir, name))
+	fmt.Println("Replaced while in the trash:", name)
+	goBackground(func() { syncTrash(context.Background()) })
+}
+
+// purgeTrash deletes for good the files whose retention is over at now,
+// and returns how many it did. A file stays in the trash, to be tried
+// again, until every node has deleted its replica.
+func purgeTrash(ctx context.Context, now time.Time) int {
+	purged := 0
+	for _, e := range trash.due(now) {
+		if errs := deleteReplicas(ctx, e.Name); len(errs) > 0 {
+			fmt.Println("Trash: cannot purge", e.Name, "yet:", errs)
+			continue
+		}
+		os.Remove(filepath.Join(trashDir, e.Name))
+		trash.remove(e.Name)
+		recordDelete(e.Name)
+		fmt.Println("Purged from trash:", e.Name)
+		purged++
+	}
+	if purged > 0 {
+		syncTrash(ctx)
+	}
+	return purged
+}
+
+// trashPurgeTask is the scheduled purge of the files due.
+func trashPurgeTask(ctx context.Context) (string, error) {
+	return fmt.Sprintf("%d file(s) purged", purgeTrash(ctx, time.Now())), nil
+}
+
+// trashHandler lists the trash: GET /api/v1/trash, the files the caller
+// could restore.
+func trashHandler(w http.ResponseWriter, r *http.Request) {
+	id := signedIn(r)
+	out := []TrashEntry{}
+	for _, e := range trash.list() {
+		if canDelete(id, e.Name) {
+			out = append(out, e)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// trashRestoreHandler puts a file back: POST /api/v1/trash/{name}/restore.
+func trashRestoreHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	e, ok := trash.get(name)
+	if !ok || !canDelete(signedIn(r), name) {
+		apierr.Send(w, "Not in the trash", http.StatusNotFound)
+		return
+	}
+	dst := filepath.Join(uploadDir, name)
+	if _, err := os.Stat(dst); err == nil {
+		apierr.Send(w, "A file by that name exists", http.StatusConflict)
+		return
+	}
+	if err := moveFile(filepath.Join(trashDir, name), dst); err != nil {
+		apierr.Send(w, "Cannot restore: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	trash.remove(name)
+	errs := syncTrash(r.Context())
+	fileChanges.Record(name)
+	indexFile(name)
+	fmt.Println("Restored from trash:", name)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		TrashEntry
+		PushErrors map[string]string `json:"push_errors,omitempty"` // by node ID
+	}{e, errs})
+}
+
+// trashPurgeHandler deletes a trashed file for good without waiting out
+// its retention: DELETE /api/v1/trash/{name}.
+func trashPurgeHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	e, ok := trash.get(name)
+	if !ok || !canDelete(signedIn(r), name) {
+		apierr.Send(w, "Not in the trash", http.StatusNotFound)
+		return
+	}
+	if errs := deleteReplicas(r.Context(), name); len(errs) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadGateway)
+		json.NewEncoder(w).Encode(struct {
+			TrashEntry
+			DeleteErrors map[string]string `json:"delete_errors"` // by node ID
+		}{e, errs})
+		return
+	}
+	os.Remove(filepath.Join(trashDir, name))
+	trash.remove(name)
+	recordDelete(name)
+	syncTrash(r.Context())
+	fmt.Println("Purged from trash:", name)
+	w.WriteHeader(http.StatusNoContent)
+}
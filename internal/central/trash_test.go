@@ -0,0 +1,90 @@
+package central
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTrash(t *testing.T) {
+	c := newTestCluster(t, 3, func(cfg *Config) {
+		cfg.TrashRetention = Duration{time.Hour}
+		cfg.GCGracePeriod = Duration{}
+	})
+	c.upload("a.txt", "keep me", nearLondon)
+	c.upload("b.txt", "bin me", nearLondon)
+	c.waitForJobs()
+
+	// Deleted: gone from the listing and every node URL, replicas kept.
+	c.delete("a.txt")
+	if _, ok := c.listing()["a.txt"]; ok {
+		t.Error("a.txt still listed")
+	}
+	if got := c.holders("a.txt"); len(got) != 3 {
+		t.Errorf("replicas held by %v, want all 3 until the purge", got)
+	}
+	for _, n := range c.nodes {
+		resp, err := http.Get(n.URL + "/files/a.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s serves a trashed file: %d", n.ID, resp.StatusCode)
+		}
+	}
+	var entries []TrashEntry
+	_, body := c.get("/api/v1/trash", nil)
+	json.Unmarshal([]byte(body), &entries)
+	if len(entries) != 1 || entries[0].Name != "a.txt" || entries[0].Size != 7 {
+		t.Fatalf("trash = %s", body)
+	}
+	if r := collectGarbage(context.Background(), gcDelete); len(r.Orphans) != 0 {
+		t.Errorf("gc took trashed replicas for orphans: %+v", r.Orphans)
+	}
+
+	// Restored: listed and served again.
+	resp, err := testClient.Post(c.central.URL+"/api/v1/trash/a.txt/restore", "", nil)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("restore: %v %v", resp, err)
+	}
+	resp.Body.Close()
+	if _, ok := c.listing()["a.txt"]; !ok {
+		t.Error("a.txt not listed after restore")
+	}
+	resp, err = http.Get(c.nodes[0].URL + "/files/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(b) != "keep me" {
+		t.Errorf("node serves %q after restore", b)
+	}
+
+	// Purged once the retention is over, and only then.
+	c.delete("b.txt")
+	if n := purgeTrash(context.Background(), time.Now()); n != 0 {
+		t.Errorf("purged %d before the retention was over", n)
+	}
+	if n := purgeTrash(context.Background(), time.Now().Add(2*time.Hour)); n != 1 {
+		t.Errorf("purged %d, want 1", n)
+	}
+	if got := c.holders("b.txt"); len(got) != 0 {
+		t.Errorf("b.txt still held by %v", got)
+	}
+	if resp, err := testClient.Post(c.central.URL+"/api/v1/trash/b.txt/restore", "", nil); err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("restore after purge: %v %v", resp, err)
+	}
+
+	// A new upload by a trashed name takes it out of the trash.
+	c.delete("a.txt")
+	c.upload("a.txt", "newer", nearLondon)
+	c.waitForJobs()
+	if trash.has("a.txt") {
+		t.Error("a.txt still in the trash after a new upload")
+	}
+}
@@ -0,0 +1,124 @@
+package central
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+)
+
+// ---------------------------
+// Usage
+// ---------------------------
+
+// The usage task totals what is stored: the central copies by bucket,
+// home directory and team folder, and what each node holds, replicas and
+// stripes alike. The last report is kept in memory and served to admins.
+
+// UsageTotals counts files and their bytes.
+type UsageTotals struct {
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+func (u *UsageTotals) add(size int64) {
+	u.Files++
+	u.Bytes += size
+}
+
+// UsageReport is one aggregation.
+type UsageReport struct {
+	Generated   time.Time              `json:"generated"`
+	Total       UsageTotals            `json:"total"`
+	Buckets     map[string]UsageTotals `json:"buckets"` // "" is the default bucket
+	Owners      map[string]UsageTotals `json:"owners"`  // home directories
+	Folders     map[string]UsageTotals `json:"folders"` // team folders
+	Nodes       map[string]UsageTotals `json:"nodes"`
+	Unreachable []string               `json:"unreachable,omitempty"`
+}
+
+var usage struct {
+	mu   sync.Mutex
+	last *UsageReport
+}
+
+// aggregateUsage builds a report and keeps it as the last one.
+func aggregateUsage(ctx context.Context) UsageReport {
+	inv := loadCentralInventory()
+	report := UsageReport{
+		Generated: time.Now().UTC(),
+		Buckets:   map[string]UsageTotals{},
+		Owners:    map[string]UsageTotals{},
+		Folders:   map[string]UsageTotals{},
+		Nodes:     map[string]UsageTotals{},
+	}
+	tally := func(m map[string]UsageTotals, key string, size int64) {
+		t := m[key]
+		t.add(size)
+		m[key] = t
+	}
+	for name, f := range inv.files {
+		report.Total.add(f.Size)
+		tally(report.Buckets, retentionFiles.bucket(name), f.Size)
+		if owner, _, ok := splitHomeName(name); ok {
+			tally(report.Owners, owner, f.Size)
+		}
+		if folder, _, ok := splitMarked(name, folderMarker); ok {
+			tally(report.Folders, folder, f.Size)
+		}
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, s := range topo.nodes() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			files, err := syncNodeFiles(ctx, s, inv)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				report.Unreachable = append(report.Unreachable, s.ID)
+				return
+			}
+			var t UsageTotals
+			for _, f := range files {
+				t.add(f.Size)
+			}
+			report.Nodes[s.ID] = t
+		}()
+	}
+	wg.Wait()
+
+	usage.mu.Lock()
+	usage.last = &report
+	usage.mu.Unlock()
+	return report
+}
+
+// usageTask is the scheduled aggregation.
+func usageTask(ctx context.Context) (string, error) {
+	r := aggregateUsage(ctx)
+	summary := fmt.Sprintf("%d file(s), %d byte(s) on the central API; %d node(s) counted", r.Total.Files, r.Total.Bytes, len(r.Nodes))
+	if len(r.Unreachable) > 0 {
+		summary += fmt.Sprintf(", %d unreachable", len(r.Unreachable))
+	}
+	return summary, nil
+}
+
+// usageHandler serves the last report: GET /api/v1/admin/usage.
+func usageHandler(w http.ResponseWriter, r *http.Request) {
+	usage.mu.Lock()
+	last := usage.last
+	usage.mu.Unlock()
+	if last == nil {
+		apierr.Send(w, "No usage report yet; run the usage schedule", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(last)
+}
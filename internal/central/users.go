@@ -0,0 +1,241 @@
+package central
+
+import (
+	"context"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+)
+
+// ---------------------------
+// Users
+// ---------------------------
+
+// With users configured, or an identity provider (see oidc.go), the web UI
+// and its form endpoints need a signed-in user: browsers sign in on /login
+// and hold a session (see session.go), scripts send HTTP basic auth with a
+// configured user's name and password. Without either the UI stays open to
+// anyone, as before.
+//
+// A user's role decides what they may do: a viewer browses and downloads,
+// an editor also uploads and deletes, in their home (see homes.go), and an
+// admin deletes anywhere.
+
+const (
+	roleViewer = "viewer"
+	roleEditor = "editor"
+	roleAdmin  = "admin"
+)
+
+var roleRank = map[string]int{roleViewer: 1, roleEditor: 2, roleAdmin: 3}
+
+// User is a web UI account. PasswordHash is what hashPassword returns
+// (`dsfs central -hash-password` prints one); passwords are never stored.
+// A user without one can only sign in through the identity provider, as
+// whichever role the config gives them here. Role defaults to editor.
+type User struct {
+	Name         string `json:"name"`
+	PasswordHash string `json:"password_hash,omitempty"`
+	Role         string `json:"role,omitempty"`
+	Email        string `json:"email,omitempty"` // for notifications
+}
+
+func (u User) role() string {
+	if u.Role == "" {
+		return roleEditor
+	}
+	return u.Role
+}
+
+// users are the configured accounts, by name.
+var users = map[string]User{}
+
+// loginRequired reports whether the UI needs a signed-in user.
+func loginRequired() bool {
+	return len(users) > 0 || oidc != nil || ldapAuth != nil
+}
+
+const (
+	passwordScheme = "pbkdf2-sha256"
+	passwordIter   = 600000
+)
+
+// hashPassword returns "pbkdf2-sha256$<iterations>$<salt>$<key>", salt and
+// key in unpadded base64.
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	key, err := pbkdf2.Key(sha256.New, password, salt, passwordIter, 32)
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawStdEncoding
+	return fmt.Sprintf("%s$%d$%s$%s", passwordScheme, passwordIter, enc.EncodeToString(salt), enc.EncodeToString(key)), nil
+}
+
+type passwordHash struct {
+	iter      int
+	salt, key []byte
+}
+
+func parsePasswordHash(s string) (passwordHash, error) {
+	parts := strings.Split(s, "$")
+	if len(parts) != 4 || parts[0] != passwordScheme {
+		return passwordHash{}, fmt.Errorf("password hash is not %s$<iterations>$<salt>$<key>", passwordScheme)
+	}
+	var h passwordHash
+	var err error
+	if h.iter, err = strconv.Atoi(parts[1]); err != nil || h.iter < 1 {
+		return passwordHash{}, fmt.Errorf("password hash: bad iteration count %q", parts[1])
+	}
+	enc := base64.RawStdEncoding
+	if h.salt, err = enc.DecodeString(parts[2]); err != nil {
+		return passwordHash{}, fmt.Errorf("password hash: bad salt")
+	}
+	if h.key, err = enc.DecodeString(parts[3]); err != nil || len(h.key) == 0 {
+		return passwordHash{}, fmt.Errorf("password hash: bad key")
+	}
+	return h, nil
+}
+
+func (h passwordHash) matches(password string) bool {
+	key, err := pbkdf2.Key(sha256.New, password, h.salt, h.iter, len(h.key))
+	return err == nil && subtle.ConstantTimeCompare(key, h.key) == 1
+}
+
+// dummyHash is checked against for unknown names, so a wrong name takes as
+// long to refuse as a wrong password.
+var dummyHash, _ = parsePasswordHash(passwordScheme + "$" + strconv.Itoa(passwordIter) + "$AAAAAAAAAAAAAAAAAAAAAA$AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA")
+
+// checkPassword reports whether password is name's.
+func checkPassword(name, password string) bool {
+	u, ok := users[name]
+	if !ok {
+		dummyHash.matches(password)
+		return false
+	}
+	h, err := parsePasswordHash(u.PasswordHash)
+	if err != nil {
+		dummyHash.matches(password)
+		return false
+	}
+	return h.matches(password)
+}
+
+func validateUsers(list []User) []error {
+	var errs []error
+	seen := map[string]bool{}
+	for i, u := range list {
+		if u.Name == "" || strings.ContainsAny(u.Name, ":$") {
+			errs = append(errs, fmt.Errorf("users[%d]: name %q must be non-empty, without ':' or '$'", i, u.Name))
+		} else if seen[u.Name] {
+			errs = append(errs, fmt.Errorf("users[%d]: duplicate name %q", i, u.Name))
+		}
+		seen[u.Name] = true
+		if u.PasswordHash != "" {
+			if _, err := parsePasswordHash(u.PasswordHash); err != nil {
+				errs = append(errs, fmt.Errorf("users[%d]: %w", i, err))
+			}
+		}
+		if u.Email != "" && !validEmail(u.Email) {
+			errs = append(errs, fmt.Errorf("users[%d]: email %q must be a bare email address", i, u.Email))
+		}
+		if u.Role != "" && roleRank[u.Role] == 0 {
+			errs = append(errs, fmt.Errorf("users[%d]: unknown role %q (want viewer, editor or admin)", i, u.Role))
+		}
+	}
+	return errs
+}
+
+// identity is who a request comes from.
+type identity struct {
+	Name           string
+	Role           string
+	ImpersonatedBy string // the admin acting as Name, if any
+}
+
+type identityKey struct{}
+
+// signedInUser returns the user requireLogin let through, or "".
+func signedInUser(r *http.Request) string {
+	id, _ := r.Context().Value(identityKey{}).(identity)
+	return id.Name
+}
+
+// authenticate returns who sent r: the user an impersonation token acts
+// as, or the user of its session or its basic auth credentials.
+func authenticate(r *http.Request) (identity, bool) {
+	if imp, role, ok := impersonating(r); ok {
+		return identity{Name: imp.User, Role: role, ImpersonatedBy: imp.Admin}, true
+	}
+	if sess, ok := currentSession(r); ok {
+		return identity{Name: sess.User, Role: sess.Role}, true
+	}
+	if name, password, ok := r.BasicAuth(); ok {
+		if id, _, err := passwordLogin(name, password); err == nil {
+			return id, true
+		}
+	}
+	return identity{}, false
+}
+
+// passwordLogin checks a name and password: against the user's own
+// password if they have one, else against the directory. It returns who
+// signed in and the session provider for them.
+func passwordLogin(name, password string) (identity, string, error) {
+	if u, ok := users[name]; (ok && u.PasswordHash != "") || ldapAuth == nil {
+		if !checkPassword(name, password) {
+			return identity{}, "", errDirectoryRefused
+		}
+		return identity{Name: name, Role: u.role()}, "", nil
+	}
+	id, err := ldapAuth.login(name, password)
+	if err != nil {
+		return identity{}, "", err
+	}
+	return id, "ldap", nil
+}
+
+// requireLogin lets only signed-in users through, once sign-in is
+// configured. Browsers are sent to the login page, others asked for basic
+// auth.
+func requireLogin(next http.Handler) http.Handler {
+	return requireRole(roleViewer, next)
+}
+
+// requireRole is requireLogin for users with at least role; others get 403.
+func requireRole(role string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !loginRequired() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		id, ok := authenticate(r)
+		if !ok {
+			if r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html") {
+				http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Basic realm="dsfs", charset="UTF-8"`)
+			apierr.Send(w, "Sign in required", http.StatusUnauthorized)
+			return
+		}
+		if roleRank[id.Role] < roleRank[role] {
+			apierr.Send(w, "Your account may not do this (needs the "+role+" role)", http.StatusForbidden)
+			return
+		}
+		if id.ImpersonatedBy != "" {
+			auditImpersonation(r, id)
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, id)))
+	})
+}
@@ -0,0 +1,166 @@
+package central
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+)
+
+// ---------------------------
+// Replica Verification
+// ---------------------------
+
+// Unlike a stat, which reports the checksums nodes have cached, verify makes
+// every node read its replica in full and hash it again, so it also catches
+// content that changed on disk behind a node's back.
+const (
+	verifyMatch       = "match"
+	verifyMismatch    = "mismatch"
+	verifyMissing     = "missing"
+	verifyUnreachable = "unreachable"
+)
+
+// ReplicaVerify is one node's answer in a verify response.
+type ReplicaVerify struct {
+	Node     string `json:"node"`
+	Status   string `json:"status"`
+	Size     int64  `json:"size,omitempty"`
+	Checksum string `json:"checksum,omitempty"`
+	// Stale is the checksum the node had cached, when rehashing changed it.
+	Stale string `json:"stale_checksum,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// VerifyResult compares every replica of a file against the expected
+// checksum: the central copy's, or the replicas' majority when the central
+// API has no copy. OK is false if any replica differs or could not be
+// checked.
+type VerifyResult struct {
+	Name     string          `json:"name"`
+	Expected string          `json:"expected,omitempty"`
+	Source   string          `json:"source,omitempty"` // "central" or "majority"
+	OK       bool            `json:"ok"`
+	Replicas []ReplicaVerify `json:"replicas"`
+}
+
+// rehashReplica asks s to rehash its copy of filename. A missing replica
+// is reported as not found, with no error.
+func rehashReplica(ctx context.Context, s StorageServer, filename string) (ReplicaVerify, bool, error) {
+	var rv ReplicaVerify
+	found := false
+	err := callNode(ctx, s, opVerify, func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL+"/api/v1/files/"+url.PathEscape(filename)+"/verify", nil)
+		if err != nil {
+			return err
+		}
+		resp, err := nodeClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		switch resp.StatusCode {
+		case http.StatusOK:
+		case http.StatusNotFound:
+			return nil
+		default:
+			return &statusError{Status: resp.StatusCode}
+		}
+		var body struct {
+			Size     int64  `json:"size"`
+			Checksum string `json:"checksum"`
+			Stale    string `json:"stale_checksum"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			return err
+		}
+		rv.Size, rv.Checksum, rv.Stale = body.Size, body.Checksum, body.Stale
+		found = true
+		return nil
+	})
+	return rv, found, err
+}
+
+// verifyFile rehashes filename on every node in parallel and compares the
+// results. It returns false if no node and no central copy has the file.
+func verifyFile(ctx context.Context, filename string) (VerifyResult, bool, error) {
+	res := VerifyResult{Name: filename}
+	central, err := fileChecksum(filepath.Join(uploadDir, filename), fileAlgorithms.of(filename))
+	switch {
+	case err == nil:
+		res.Expected, res.Source = central, "central"
+	case !errors.Is(err, os.ErrNotExist):
+		return res, false, err
+	}
+
+	nodes := topo.nodes()
+	res.Replicas = make([]ReplicaVerify, len(nodes))
+	var wg sync.WaitGroup
+	for i, s := range nodes {
+		wg.Add(1)
+		go func(i int, s StorageServer) {
+			defer wg.Done()
+			rv, found, err := rehashReplica(ctx, s, filename)
+			rv.Node = s.ID
+			switch {
+			case err != nil:
+				rv.Status = verifyUnreachable
+				rv.Error = err.Error()
+			case !found:
+				rv.Status = verifyMissing
+			}
+			res.Replicas[i] = rv
+		}(i, s)
+	}
+	wg.Wait()
+
+	sums := map[string]string{}
+	for _, rv := range res.Replicas {
+		if rv.Status == "" {
+			sums[rv.Node] = rv.Checksum
+		}
+	}
+	if res.Source == "" {
+		if len(sums) == 0 {
+			return res, false, nil
+		}
+		res.Expected, _ = majorityChecksum(sums)
+		res.Source = "majority"
+	}
+
+	res.OK = true
+	for i := range res.Replicas {
+		rv := &res.Replicas[i]
+		if rv.Status == "" {
+			rv.Status = verifyMatch
+			if rv.Checksum != res.Expected {
+				rv.Status = verifyMismatch
+			}
+		}
+		// Files need not be on every node; fsck judges replica counts.
+		if rv.Status == verifyMismatch || rv.Status == verifyUnreachable {
+			res.OK = false
+		}
+	}
+	return res, true, nil
+}
+
+// Rehash a file on every replica and compare checksums as JSON
+func verifyHandler(w http.ResponseWriter, r *http.Request) {
+	res, found, err := verifyFile(r.Context(), filepath.Base(r.PathValue("name")))
+	if err != nil {
+		apierr.Send(w, "Verify failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+	}
+	json.NewEncoder(w).Encode(res)
+}
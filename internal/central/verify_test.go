@@ -0,0 +1,43 @@
+package central
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestClusterVerify(t *testing.T) {
+	c := newTestCluster(t, 3, nil)
+	c.upload("v.txt", "intact", nearLondon)
+	c.node("ny").backend.Put("v.txt", strings.NewReader("rotten"))
+
+	resp, err := testClient.Get(c.central.URL + "/api/v1/files/v.txt/verify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res VerifyResult
+	json.NewDecoder(resp.Body).Decode(&res)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || res.OK || res.Source != "central" {
+		t.Fatalf("verify: status %d, ok %v, source %q", resp.StatusCode, res.OK, res.Source)
+	}
+	for _, rv := range res.Replicas {
+		want := verifyMatch
+		if rv.Node == "ny" {
+			want = verifyMismatch
+		}
+		if rv.Status != want {
+			t.Errorf("%s: status %s, want %s", rv.Node, rv.Status, want)
+		}
+	}
+
+	resp, err = testClient.Get(c.central.URL + "/api/v1/files/nowhere.txt/verify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("verify missing file: status %d, want 404", resp.StatusCode)
+	}
+}
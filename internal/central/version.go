@@ -0,0 +1,65 @@
+package central
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/buildinfo"
+)
+
+// ---------------------------
+// Versions
+// ---------------------------
+
+// nodeBuild is the build a node reported, from /info or its registration.
+// Nodes that never reported one are "unknown".
+func nodeBuild(nodeID string) buildinfo.Info {
+	info, _ := identities.get(nodeID)
+	if info.Version == "" {
+		return buildinfo.Info{Version: "unknown"}
+	}
+	return buildinfo.Info{Version: info.Version, Commit: info.Commit, BuildTime: info.BuildTime}
+}
+
+// mixedVersions returns the distinct builds the nodes run, the central
+// API's first, when there is more than one: during a rolling upgrade that
+// is expected, but a cluster left that way behaves differently depending on
+// which node answers.
+func mixedVersions() []string {
+	builds := []string{buildinfo.Get().String()}
+	seen := map[string]bool{builds[0]: true}
+	for _, s := range topo.nodes() {
+		b := nodeBuild(s.ID)
+		if b.Version == "unknown" || seen[b.String()] {
+			continue
+		}
+		seen[b.String()] = true
+		builds = append(builds, b.String())
+	}
+	if len(builds) < 2 {
+		return nil
+	}
+	return builds
+}
+
+// versionHandler serves the central API's build and those of its nodes.
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	nodes := map[string]buildinfo.Info{}
+	for _, s := range topo.nodes() {
+		nodes[s.ID] = nodeBuild(s.ID)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		buildinfo.Info
+		Nodes map[string]buildinfo.Info `json:"nodes"`
+		Mixed []string                  `json:"mixed,omitempty"`
+	}{buildinfo.Get(), nodes, mixedVersions()})
+}
+
+// warnMixedVersions logs the builds in the cluster if they differ.
+func warnMixedVersions() {
+	if builds := mixedVersions(); builds != nil {
+		fmt.Println("WARNING: cluster runs mixed versions:", builds)
+	}
+}
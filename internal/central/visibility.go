@@ -0,0 +1,252 @@
+package central
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+)
+
+// ---------------------------
+// File Visibility
+// ---------------------------
+
+// Files are public: anyone may download them, through the central API or
+// a node URL it hands out. A file made private is served only to those
+// who may see it (see canReadPrivate): the central API refuses everyone
+// else on every read path, and the nodes, which get the list of private
+// files as they get the quarantine list, serve it only to URLs signed for
+// its private scope, which nodeFileURL hands out for it alone. So private
+// files need sign-in and a signing key configured.
+
+type privateRegistry struct {
+	mu    sync.Mutex
+	path  string
+	names map[string]bool
+}
+
+var privateFiles = &privateRegistry{names: map[string]bool{}}
+
+func (reg *privateRegistry) load(path string) error {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	reg.path = path
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var names []string
+	if err := json.Unmarshal(raw, &names); err != nil {
+		return err
+	}
+	for _, n := range names {
+		reg.names[n] = true
+	}
+	return nil
+}
+
+func (reg *privateRegistry) saveLocked() error {
+	if reg.path == "" {
+		return nil
+	}
+	raw, err := json.MarshalIndent(reg.listLocked(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(reg.path), 0755); err != nil {
+		return err
+	}
+	tmp := reg.path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, reg.path)
+}
+
+// has reports whether name is private, itself or as an HLS file of a
+// private video.
+func (reg *privateRegistry) has(name string) bool {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if reg.names[name] {
+		return true
+	}
+	video, ok := hlsVideo(name)
+	return ok && reg.names[video]
+}
+
+// set makes name private or public, reporting whether that changed it.
+func (reg *privateRegistry) set(name string, private bool) bool {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if reg.names[name] == private {
+		return false
+	}
+	if private {
+		reg.names[name] = true
+	} else {
+		delete(reg.names, name)
+	}
+	if err := reg.saveLocked(); err != nil {
+		fmt.Println("Cannot save private file list:", err)
+	}
+	return true
+}
+
+// list returns the private names, sorted: the list the nodes get.
+func (reg *privateRegistry) list() []string {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	return reg.listLocked()
+}
+
+func (reg *privateRegistry) listLocked() []string {
+	names := make([]string, 0, len(reg.names))
+	for n := range reg.names {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// privateDrifted reports whether a node's list of private files, by the
+// digest in its /info, differs from the cluster's.
+func privateDrifted(digest string) bool {
+	return digest != quarantineDigest(withParts(privateFiles.list()))
+}
+
+// syncPrivate sends the list of private files to every node and returns
+// the errors by node ID.
+func syncPrivate(ctx context.Context) map[string]string {
+	names := withParts(privateFiles.list())
+	var mu sync.Mutex
+	var errs map[string]string
+	var wg sync.WaitGroup
+	for _, s := range topo.nodes() {
+		wg.Add(1)
+		go func(s StorageServer) {
+			defer wg.Done()
+			if err := pushNameList(ctx, s, "/api/v1/private", names); err != nil {
+				mu.Lock()
+				if errs == nil {
+					errs = map[string]string{}
+				}
+				errs[s.ID] = err.Error()
+				mu.Unlock()
+			}
+		}(s)
+	}
+	wg.Wait()
+	return errs
+}
+
+// canReadPrivate reports whether id may read a private file: its owner,
+// a member of its folder's groups, or an admin. A private file in no home
+// or folder is for admins only.
+func canReadPrivate(id identity, name string) bool {
+	if roleRank[id.Role] >= roleRank[roleAdmin] {
+		return true
+	}
+	if role, ok := folderAccess(id, name); ok {
+		return role != ""
+	}
+	owner, _, ok := splitHomeName(name)
+	return ok && id.Name != "" && owner == escapeHomeUser(id.Name)
+}
+
+// mayRead reports whether r may read name.
+func mayRead(r *http.Request, name string) bool {
+	if !privateFiles.has(name) {
+		return true
+	}
+	id, ok := authenticate(r)
+	return ok && canReadPrivate(id, name)
+}
+
+// refusePrivate answers a request for a private file its sender may not
+// read, and reports whether it did: 401 asks for credentials, 403 refuses
+// a signed-in user.
+func refusePrivate(w http.ResponseWriter, r *http.Request, name string) bool {
+	if mayRead(r, name) {
+		return false
+	}
+	if _, ok := authenticate(r); ok {
+		apierr.Send(w, "File is private", http.StatusForbidden)
+		return true
+	}
+	w.Header().Set("WWW-Authenticate", `Basic realm="dsfs", charset="UTF-8"`)
+	apierr.Send(w, "File is private: sign in", http.StatusUnauthorized)
+	return true
+}
+
+// guardPrivate wraps the central copies' file server.
+func guardPrivate(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if refusePrivate(w, r, filepath.Base(r.URL.Path)) {
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// visibilityHandler makes a file public or private:
+// POST /api/v1/files/{name}/visibility with visibility=public|private, as
+// a form or JSON. Those who may delete the file may change it.
+func visibilityHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	visibility := r.FormValue("visibility")
+	if r.Header.Get("Content-Type") == "application/json" {
+		var req struct {
+			Visibility string `json:"visibility"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<10)).Decode(&req); err != nil {
+			apierr.Send(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		visibility = req.Visibility
+	}
+	if visibility != "public" && visibility != "private" {
+		apierr.Send(w, "visibility must be public or private", http.StatusBadRequest)
+		return
+	}
+	if visibility == "private" && (!loginRequired() || len(signingKey) == 0) {
+		apierr.Send(w, "Private files need sign-in and a signing_key configured", http.StatusConflict)
+		return
+	}
+	if !statFile(r.Context(), name).Exists {
+		http.NotFound(w, r)
+		return
+	}
+	if !canDelete(signedIn(r), name) {
+		apierr.Send(w, "You may not change this file's visibility", http.StatusForbidden)
+		return
+	}
+
+	var errs map[string]string
+	if privateFiles.set(name, visibility == "private") {
+		fmt.Println("File", name, "is now", visibility)
+		errs = syncPrivate(r.Context())
+	}
+	if !strings.Contains(r.Header.Get("Accept"), "application/json") && r.Header.Get("Content-Type") != "application/json" {
+		http.Redirect(w, r, "/files", http.StatusSeeOther)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Name       string            `json:"name"`
+		Visibility string            `json:"visibility"`
+		PushErrors map[string]string `json:"push_errors,omitempty"` // by node ID
+	}{name, visibility, errs})
+}
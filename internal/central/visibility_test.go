@@ -0,0 +1,108 @@
+package central
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/storage"
+)
+
+func TestPrivateFiles(t *testing.T) {
+	hash, _ := hashPassword("pw")
+	c := newTestCluster(t, 1, func(cfg *Config) {
+		cfg.SigningKey = "s3cret"
+		cfg.Users = []User{{Name: "alice", PasswordHash: hash}, {Name: "bob", PasswordHash: hash}}
+	})
+	for _, n := range c.nodes {
+		cfg := n.cfg
+		cfg.SigningKey = []byte("s3cret")
+		s, err := storage.NewServer(cfg, n.backend)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := s.Handler()
+		n.handler.Store(&h)
+	}
+
+	do := func(user, method, path, body string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		if !strings.HasPrefix(path, "http") {
+			req.URL, _ = req.URL.Parse(c.central.URL + path)
+		}
+		if user != "" {
+			req.SetBasicAuth(user, "pw")
+		}
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := testClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("file", "diary.txt")
+	io.WriteString(fw, "dear diary")
+	mw.Close()
+	req, _ := http.NewRequest("POST", c.central.URL+"/upload?"+nearLondon.Encode(), &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.SetBasicAuth("alice", "pw")
+	if resp, err := testClient.Do(req); err != nil || resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("upload: %v %v", resp, err)
+	}
+	c.waitForJobs()
+	name := homeName("alice", "diary.txt")
+	get := "/get/" + name + "?" + nearLondon.Encode()
+
+	// Public: anyone gets a node URL, and it works.
+	publicURL := do("", "GET", get, "").Header.Get("Location")
+	if resp := do("", "GET", publicURL, ""); resp.StatusCode != http.StatusOK {
+		t.Fatalf("public download: %d", resp.StatusCode)
+	}
+
+	if resp := do("bob", "POST", "/api/v1/files/"+name+"/visibility", `{"visibility": "private"}`); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("bob making alice's file private: %d", resp.StatusCode)
+	}
+	if resp := do("alice", "POST", "/api/v1/files/"+name+"/visibility", `{"visibility": "secret"}`); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unknown visibility: %d", resp.StatusCode)
+	}
+	if resp := do("alice", "POST", "/api/v1/files/"+name+"/visibility", `{"visibility": "private"}`); resp.StatusCode != http.StatusOK {
+		t.Fatalf("making it private: %d", resp.StatusCode)
+	}
+
+	for _, tc := range []struct {
+		user, path string
+		want       int
+	}{
+		{"", get, http.StatusUnauthorized},
+		{"bob", get, http.StatusForbidden},
+		{"", "/download/" + name + "?" + nearLondon.Encode(), http.StatusUnauthorized},
+		{"", "/files/" + name, http.StatusUnauthorized},
+		{"", "/u/alice/diary.txt?" + nearLondon.Encode(), http.StatusUnauthorized},
+		{"alice", "/download/" + name + "?" + nearLondon.Encode(), http.StatusOK},
+		{"", publicURL, http.StatusForbidden}, // handed out while it was public
+	} {
+		if resp := do(tc.user, "GET", tc.path, ""); resp.StatusCode != tc.want {
+			t.Errorf("%q GET %s: %d, want %d", tc.user, tc.path, resp.StatusCode, tc.want)
+		}
+	}
+	privateURL := do("alice", "GET", get, "").Header.Get("Location")
+	if resp := do("", "GET", privateURL, ""); resp.StatusCode != http.StatusOK {
+		t.Errorf("alice's node URL: %d", resp.StatusCode)
+	}
+
+	if resp := do("alice", "POST", "/api/v1/files/"+name+"/visibility", `{"visibility": "public"}`); resp.StatusCode != http.StatusOK {
+		t.Fatalf("making it public: %d", resp.StatusCode)
+	}
+	if resp := do("", "GET", do("", "GET", get, "").Header.Get("Location"), ""); resp.StatusCode != http.StatusOK {
+		t.Errorf("public again: %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,111 @@
+// Package changes numbers the changes to a set of files, so listings can
+// be asked for only what changed since a sequence number.
+//
+// Sequence numbers start from the wall clock in nanoseconds when the log is
+// created and then count up, so they keep growing across restarts without
+// being stored. Files that were already there when the log was created
+// count as changed at its first sequence number; deletions are remembered
+// as tombstones, up to a limit. A since older than what the log can answer
+// for (from before a restart, or before the oldest dropped tombstone) gets
+// ErrTooOld, and the client has to list everything again.
+package changes
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrTooOld is returned for a since the log cannot answer for.
+var ErrTooOld = errors.New("since is older than the change log; list again without it")
+
+// Change is the latest change to one file.
+type Change struct {
+	Name    string
+	Seq     int64
+	Deleted bool
+}
+
+// Log tracks the latest change to every file.
+type Log struct {
+	mu            sync.Mutex
+	seq           int64 // last sequence number handed out
+	floor         int64 // since values below this are too old
+	changed       map[string]int64
+	deleted       map[string]int64
+	maxTombstones int
+}
+
+// New returns a log that remembers up to maxTombstones deletions.
+func New(maxTombstones int) *Log {
+	now := time.Now().UnixNano()
+	return &Log{
+		seq:           now,
+		floor:         now,
+		changed:       map[string]int64{},
+		deleted:       map[string]int64{},
+		maxTombstones: maxTombstones,
+	}
+}
+
+// Seq returns the latest sequence number. Listing from it later returns
+// the changes made after this call.
+func (l *Log) Seq() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.seq
+}
+
+// Record notes that name was written and returns the change's number.
+func (l *Log) Record(name string) int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.seq++
+	l.changed[name] = l.seq
+	delete(l.deleted, name)
+	return l.seq
+}
+
+// Remove notes that name was deleted and returns the change's number.
+func (l *Log) Remove(name string) int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.seq++
+	delete(l.changed, name)
+	l.deleted[name] = l.seq
+	if len(l.deleted) > l.maxTombstones {
+		oldest, at := "", int64(0)
+		for n, s := range l.deleted {
+			if oldest == "" || s < at {
+				oldest, at = n, s
+			}
+		}
+		delete(l.deleted, oldest)
+		l.floor = at
+	}
+	return l.seq
+}
+
+// Since returns the latest change to every file changed after since, in
+// sequence order.
+func (l *Log) Since(since int64) ([]Change, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if since < l.floor {
+		return nil, ErrTooOld
+	}
+	var out []Change
+	for name, s := range l.changed {
+		if s > since {
+			out = append(out, Change{Name: name, Seq: s})
+		}
+	}
+	for name, s := range l.deleted {
+		if s > since {
+			out = append(out, Change{Name: name, Seq: s, Deleted: true})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Seq < out[j].Seq })
+	return out, nil
+}
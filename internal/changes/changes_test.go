@@ -0,0 +1,38 @@
+package changes
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSince(t *testing.T) {
+	l := New(2)
+	start := l.Seq()
+	if got, err := l.Since(start); err != nil || len(got) != 0 {
+		t.Fatalf("Since(start) = %v, %v", got, err)
+	}
+
+	a := l.Record("a")
+	b := l.Record("b")
+	l.Record("a")
+	gone := l.Remove("b")
+	got, err := l.Since(a)
+	want := []Change{{Name: "a", Seq: gone - 1}, {Name: "b", Seq: gone, Deleted: true}}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Since(a) = %v, %v; want %v", got, err, want)
+	}
+	if got, _ := l.Since(gone); len(got) != 0 {
+		t.Errorf("Since(latest) = %v", got)
+	}
+
+	if _, err := l.Since(start - 1); !errors.Is(err, ErrTooOld) {
+		t.Errorf("Since before the log started: err = %v", err)
+	}
+	// Dropping the oldest tombstone makes anything before it too old.
+	l.Remove("c")
+	l.Remove("d")
+	if _, err := l.Since(b); !errors.Is(err, ErrTooOld) {
+		t.Errorf("Since past a dropped tombstone: err = %v", err)
+	}
+}
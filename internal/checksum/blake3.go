@@ -0,0 +1,220 @@
+package checksum
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// BLAKE3 in its default hashing mode with a 32-byte output, after the
+// reference implementation at https://github.com/BLAKE3-team/BLAKE3. The
+// input is split into 1 KiB chunks, each hashed 64-byte block by block,
+// and the chunks' chaining values are merged pairwise up a binary tree,
+// keeping only the pending left subtrees on a stack.
+
+const (
+	b3BlockLen = 64
+	b3ChunkLen = 1024
+
+	b3ChunkStart = 1 << 0
+	b3ChunkEnd   = 1 << 1
+	b3Parent     = 1 << 2
+	b3Root       = 1 << 3
+)
+
+var b3IV = [8]uint32{
+	0x6A09E667, 0xBB67AE85, 0x3C6EF372, 0xA54FF53A,
+	0x510E527F, 0x9B05688C, 0x1F83D9AB, 0x5BE0CD19,
+}
+
+var b3Permutation = [16]int{2, 6, 3, 10, 7, 0, 4, 13, 1, 11, 12, 5, 9, 14, 15, 8}
+
+func b3G(s *[16]uint32, a, b, c, d int, mx, my uint32) {
+	s[a] += s[b] + mx
+	s[d] = bits.RotateLeft32(s[d]^s[a], -16)
+	s[c] += s[d]
+	s[b] = bits.RotateLeft32(s[b]^s[c], -12)
+	s[a] += s[b] + my
+	s[d] = bits.RotateLeft32(s[d]^s[a], -8)
+	s[c] += s[d]
+	s[b] = bits.RotateLeft32(s[b]^s[c], -7)
+}
+
+// b3Compress is the compression function: it returns the full 16-word
+// state, whose first 8 words are the chaining value.
+func b3Compress(cv [8]uint32, block [16]uint32, counter uint64, blockLen, flags uint32) [16]uint32 {
+	s := [16]uint32{
+		cv[0], cv[1], cv[2], cv[3], cv[4], cv[5], cv[6], cv[7],
+		b3IV[0], b3IV[1], b3IV[2], b3IV[3],
+		uint32(counter), uint32(counter >> 32), blockLen, flags,
+	}
+	m := block
+	for round := 0; round < 7; round++ {
+		b3G(&s, 0, 4, 8, 12, m[0], m[1])
+		b3G(&s, 1, 5, 9, 13, m[2], m[3])
+		b3G(&s, 2, 6, 10, 14, m[4], m[5])
+		b3G(&s, 3, 7, 11, 15, m[6], m[7])
+		b3G(&s, 0, 5, 10, 15, m[8], m[9])
+		b3G(&s, 1, 6, 11, 12, m[10], m[11])
+		b3G(&s, 2, 7, 8, 13, m[12], m[13])
+		b3G(&s, 3, 4, 9, 14, m[14], m[15])
+		if round < 6 {
+			var p [16]uint32
+			for i, j := range b3Permutation {
+				p[i] = m[j]
+			}
+			m = p
+		}
+	}
+	for i := 0; i < 8; i++ {
+		s[i] ^= s[i+8]
+		s[i+8] ^= cv[i]
+	}
+	return s
+}
+
+func b3Words(b *[b3BlockLen]byte) [16]uint32 {
+	var w [16]uint32
+	for i := range w {
+		w[i] = binary.LittleEndian.Uint32(b[4*i:])
+	}
+	return w
+}
+
+func b3First8(s [16]uint32) [8]uint32 {
+	var cv [8]uint32
+	copy(cv[:], s[:8])
+	return cv
+}
+
+// b3Output is a node of the tree not yet compressed, so that the root can
+// be compressed with the root flag.
+type b3Output struct {
+	cv       [8]uint32
+	block    [16]uint32
+	counter  uint64
+	blockLen uint32
+	flags    uint32
+}
+
+func (o b3Output) chainingValue() [8]uint32 {
+	return b3First8(b3Compress(o.cv, o.block, o.counter, o.blockLen, o.flags))
+}
+
+func (o b3Output) rootBytes() [32]byte {
+	s := b3Compress(o.cv, o.block, 0, o.blockLen, o.flags|b3Root)
+	var out [32]byte
+	for i := 0; i < 8; i++ {
+		binary.LittleEndian.PutUint32(out[4*i:], s[i])
+	}
+	return out
+}
+
+func b3ParentOutput(left, right [8]uint32) b3Output {
+	var block [16]uint32
+	copy(block[:8], left[:])
+	copy(block[8:], right[:])
+	return b3Output{cv: b3IV, block: block, blockLen: b3BlockLen, flags: b3Parent}
+}
+
+type b3Chunk struct {
+	cv         [8]uint32
+	counter    uint64
+	block      [b3BlockLen]byte
+	blockLen   int
+	compressed int // blocks compressed
+}
+
+func newB3Chunk(counter uint64) b3Chunk {
+	return b3Chunk{cv: b3IV, counter: counter}
+}
+
+func (c *b3Chunk) len() int {
+	return b3BlockLen*c.compressed + c.blockLen
+}
+
+func (c *b3Chunk) startFlag() uint32 {
+	if c.compressed == 0 {
+		return b3ChunkStart
+	}
+	return 0
+}
+
+func (c *b3Chunk) write(p []byte) {
+	for len(p) > 0 {
+		if c.blockLen == b3BlockLen {
+			s := b3Compress(c.cv, b3Words(&c.block), c.counter, b3BlockLen, c.startFlag())
+			c.cv = b3First8(s)
+			c.compressed++
+			c.block = [b3BlockLen]byte{}
+			c.blockLen = 0
+		}
+		n := copy(c.block[c.blockLen:], p)
+		c.blockLen += n
+		p = p[n:]
+	}
+}
+
+func (c *b3Chunk) output() b3Output {
+	return b3Output{
+		cv:       c.cv,
+		block:    b3Words(&c.block),
+		counter:  c.counter,
+		blockLen: uint32(c.blockLen),
+		flags:    c.startFlag() | b3ChunkEnd,
+	}
+}
+
+type blake3 struct {
+	chunk b3Chunk
+	stack [][8]uint32 // chaining values of the pending left subtrees
+}
+
+func newBLAKE3() *blake3 {
+	return &blake3{chunk: newB3Chunk(0)}
+}
+
+func (d *blake3) Reset() {
+	d.chunk = newB3Chunk(0)
+	d.stack = d.stack[:0]
+}
+
+func (d *blake3) Size() int      { return 32 }
+func (d *blake3) BlockSize() int { return b3BlockLen }
+
+func (d *blake3) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		// A full chunk is finished only once more input follows, as the
+		// last chunk may be the root.
+		if d.chunk.len() == b3ChunkLen {
+			cv := d.chunk.output().chainingValue()
+			total := d.chunk.counter + 1
+			d.push(cv, total)
+			d.chunk = newB3Chunk(total)
+		}
+		take := min(b3ChunkLen-d.chunk.len(), len(p))
+		d.chunk.write(p[:take])
+		p = p[take:]
+	}
+	return n, nil
+}
+
+// push adds a chunk's chaining value, merging every subtree it completes:
+// as many as total, the chunks so far, has trailing zero bits.
+func (d *blake3) push(cv [8]uint32, total uint64) {
+	for total&1 == 0 {
+		cv = b3ParentOutput(d.stack[len(d.stack)-1], cv).chainingValue()
+		d.stack = d.stack[:len(d.stack)-1]
+		total >>= 1
+	}
+	d.stack = append(d.stack, cv)
+}
+
+func (d *blake3) Sum(b []byte) []byte {
+	out := d.chunk.output()
+	for i := len(d.stack) - 1; i >= 0; i-- {
+		out = b3ParentOutput(d.stack[i], out.chainingValue())
+	}
+	sum := out.rootBytes()
+	return append(b, sum[:]...)
+}
@@ -0,0 +1,84 @@
+// Package checksum computes the integrity checksums files are stored and
+// compared by, with one of several algorithms: SHA-256, the default, or
+// BLAKE3 or xxHash64, which are faster on large files. xxHash64 is not a
+// cryptographic hash: it catches corruption, not tampering.
+//
+// A checksum is written as lowercase hex, prefixed with its algorithm and
+// a colon ("blake3:6437b3..."), except for SHA-256, which is bare hex as
+// it was before there was a choice. So a checksum says how to recompute
+// it, and two checksums of the same bytes by different algorithms never
+// compare equal.
+package checksum
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+)
+
+// The algorithms, by the names config files, headers and checksums use.
+const (
+	SHA256 = "sha256"
+	BLAKE3 = "blake3"
+	XXH64  = "xxh64"
+)
+
+// Default is the algorithm used when none is chosen.
+const Default = SHA256
+
+// Algorithms lists the supported algorithms, the default first.
+var Algorithms = []string{SHA256, BLAKE3, XXH64}
+
+// Supported reports whether alg is an algorithm this package computes.
+func Supported(alg string) bool {
+	switch alg {
+	case SHA256, BLAKE3, XXH64:
+		return true
+	}
+	return false
+}
+
+// New returns a hash for alg, "" meaning the default.
+func New(alg string) (hash.Hash, error) {
+	switch alg {
+	case SHA256, "":
+		return sha256.New(), nil
+	case BLAKE3:
+		return newBLAKE3(), nil
+	case XXH64:
+		return newXXH64(), nil
+	}
+	return nil, fmt.Errorf("unknown checksum algorithm %q (want one of %s)", alg, strings.Join(Algorithms, ", "))
+}
+
+// Format writes h's sum as a checksum of alg.
+func Format(alg string, h hash.Hash) string {
+	sum := hex.EncodeToString(h.Sum(nil))
+	if alg == SHA256 || alg == "" {
+		return sum
+	}
+	return alg + ":" + sum
+}
+
+// Algorithm returns the algorithm sum was computed with.
+func Algorithm(sum string) string {
+	if alg, _, ok := strings.Cut(sum, ":"); ok {
+		return alg
+	}
+	return SHA256
+}
+
+// Of reads r to the end and returns its checksum by alg.
+func Of(alg string, r io.Reader) (string, error) {
+	h, err := New(alg)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return Format(alg, h), nil
+}
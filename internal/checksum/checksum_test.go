@@ -0,0 +1,71 @@
+package checksum
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// pattern is the input the BLAKE3 test vectors use: bytes 0 to 250,
+// repeated.
+func pattern(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i % 251)
+	}
+	return b
+}
+
+func TestVectors(t *testing.T) {
+	for _, tc := range []struct {
+		in            []byte
+		blake3, xxh64 string
+	}{
+		{nil, "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262", "ef46db3751d8e999"},
+		{[]byte("abc"), "6437b3ac38465133ffb63b75273a8db548c558465d79db03fd359c6cd5bd9d85", "44bc2cf5ad770999"},
+		{pattern(1025), "d00278ae47eb27b34faecf67b4fe263f82d5412916c1ffd97c8cb7fb814b8444", "cfd73aedd2d6a39d"},
+		{pattern(102400), "bc3e3d41a1146b069abffad3c0d44860cf664390afce4d9661f7902e7943e085", "eb1adcdd9e1369a6"},
+	} {
+		for alg, want := range map[string]string{BLAKE3: "blake3:" + tc.blake3, XXH64: "xxh64:" + tc.xxh64} {
+			got, err := Of(alg, bytes.NewReader(tc.in))
+			if err != nil || got != want {
+				t.Errorf("%s of %d bytes = %s %v, want %s", alg, len(tc.in), got, err, want)
+			}
+		}
+	}
+	if got, _ := Of(SHA256, strings.NewReader("abc")); got != "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" {
+		t.Errorf("sha256 = %s", got)
+	}
+}
+
+// TestSplitWrites checks that how the input is cut into writes does not
+// change the sum, across block and chunk boundaries.
+func TestSplitWrites(t *testing.T) {
+	in := pattern(5000)
+	for _, alg := range Algorithms {
+		want, _ := Of(alg, bytes.NewReader(in))
+		for _, step := range []int{1, 7, 31, 64, 1000, 1024} {
+			h, _ := New(alg)
+			for p := in; len(p) > 0; {
+				n := min(step, len(p))
+				h.Write(p[:n])
+				p = p[n:]
+			}
+			if got := Format(alg, h); got != want {
+				t.Errorf("%s in writes of %d: %s, want %s", alg, step, got, want)
+			}
+		}
+	}
+}
+
+func TestAlgorithm(t *testing.T) {
+	for _, alg := range Algorithms {
+		sum, _ := Of(alg, strings.NewReader("x"))
+		if got := Algorithm(sum); got != alg {
+			t.Errorf("Algorithm(%s) = %s, want %s", sum, got, alg)
+		}
+	}
+	if _, err := New("md5"); err == nil {
+		t.Error("md5 accepted")
+	}
+}
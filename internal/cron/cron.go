@@ -0,0 +1,193 @@
+// Package cron parses recurring schedules and works out when they next
+// fire. A schedule is either five crontab fields,
+//
+//	minute hour day-of-month month day-of-week
+//
+// each "*", a number, a range "a-b", a list "a,b" or any of those with a
+// step "/n" (day-of-week counts Sunday as 0 or 7), or one of the
+// shorthands @hourly, @daily (@midnight), @weekly, @monthly, @yearly
+// (@annually) and "@every <duration>". As in cron, a day matches when
+// either day field matches if both are restricted.
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule says when a recurring job runs.
+type Schedule interface {
+	// Next returns the first time after t the schedule fires, in t's
+	// location; the zero time if it never does.
+	Next(t time.Time) time.Time
+}
+
+// Every fires at a fixed interval from the time it is asked about.
+type Every time.Duration
+
+func (e Every) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(e))
+}
+
+func (e Every) String() string {
+	return "@every " + time.Duration(e).String()
+}
+
+// Spec is a schedule in crontab fields.
+type Spec struct {
+	minute, hour, dom, month, dow uint64 // bit n set: n matches
+	domStar, dowStar              bool
+	text                          string
+}
+
+func (s *Spec) String() string { return s.text }
+
+var shorthands = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var monthNames = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+var dayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// Parse reads a schedule.
+func Parse(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if d, ok := strings.CutPrefix(spec, "@every "); ok {
+		v, err := time.ParseDuration(strings.TrimSpace(d))
+		if err != nil || v <= 0 {
+			return nil, fmt.Errorf("%q: @every needs a positive duration", spec)
+		}
+		return Every(v), nil
+	}
+	fields := spec
+	if s, ok := shorthands[spec]; ok {
+		fields = s
+	} else if strings.HasPrefix(spec, "@") {
+		return nil, fmt.Errorf("%q: unknown shorthand", spec)
+	}
+	f := strings.Fields(fields)
+	if len(f) != 5 {
+		return nil, fmt.Errorf("%q: want 5 fields (minute hour day-of-month month day-of-week), got %d", spec, len(f))
+	}
+	s := &Spec{text: spec, domStar: f[2] == "*", dowStar: f[4] == "*"}
+	var err error
+	for _, p := range []struct {
+		bits     *uint64
+		field    string
+		what     string
+		min, max int
+		names    []string
+	}{
+		{&s.minute, f[0], "minute", 0, 59, nil},
+		{&s.hour, f[1], "hour", 0, 23, nil},
+		{&s.dom, f[2], "day of month", 1, 31, nil},
+		{&s.month, f[3], "month", 1, 12, monthNames},
+		{&s.dow, f[4], "day of week", 0, 7, dayNames},
+	} {
+		if *p.bits, err = parseField(p.field, p.min, p.max, p.names); err != nil {
+			return nil, fmt.Errorf("%q: %s: %w", spec, p.what, err)
+		}
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1 // 7 is Sunday too
+	}
+	return s, nil
+}
+
+// parseField turns one field into a bit set. names, if any, stand for
+// min, min+1 and so on.
+func parseField(field string, min, max int, names []string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepText)
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("bad step %q", stepText)
+			}
+			step = n
+		}
+		lo, hi := min, max
+		if rng != "*" {
+			a, b, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = fieldValue(a, min, max, names); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = fieldValue(b, min, max, names); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				hi = max // "5/15" is "5-max/15"
+			}
+			if hi < lo {
+				return 0, fmt.Errorf("range %q runs backwards", rng)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func fieldValue(s string, min, max int, names []string) (int, error) {
+	for i, name := range names {
+		if strings.EqualFold(s, name) {
+			return min + i, nil
+		}
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < min || n > max {
+		return 0, fmt.Errorf("%q is not %d-%d", s, min, max)
+	}
+	return n, nil
+}
+
+func (s *Spec) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<t.Day()) != 0
+	dow := s.dow&(1<<t.Weekday()) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next skips ahead a field at a time, coarsest first, and gives up after
+// five years: a spec like "0 0 30 2 *" never fires.
+func (s *Spec) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if s.month&(1<<t.Month()) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if s.hour&(1<<t.Hour()) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if s.minute&(1<<t.Minute()) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
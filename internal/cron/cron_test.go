@@ -0,0 +1,49 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	from := time.Date(2026, 3, 14, 10, 17, 30, 0, time.UTC) // a Saturday
+	for _, tc := range []struct {
+		spec string
+		want string
+	}{
+		{"* * * * *", "2026-03-14 10:18"},
+		{"*/15 * * * *", "2026-03-14 10:30"},
+		{"5 * * * *", "2026-03-14 11:05"},
+		{"0 2 * * *", "2026-03-15 02:00"},
+		{"@daily", "2026-03-15 00:00"},
+		{"@hourly", "2026-03-14 11:00"},
+		{"30 9 * * mon-fri", "2026-03-16 09:30"},
+		{"0 0 * * 7", "2026-03-15 00:00"},
+		{"0 0 1 * *", "2026-04-01 00:00"},
+		{"0 12 13 * fri", "2026-03-20 12:00"}, // the 13th or a Friday
+		{"0 0 29 2 *", "2028-02-29 00:00"},
+		{"10,40 8-9 * jun *", "2026-06-01 08:10"},
+		{"@every 90s", "2026-03-14 10:19"},
+	} {
+		s, err := Parse(tc.spec)
+		if err != nil {
+			t.Errorf("%s: %v", tc.spec, err)
+			continue
+		}
+		if got := s.Next(from).Format("2006-01-02 15:04"); got != tc.want {
+			t.Errorf("%s: next = %s, want %s", tc.spec, got, tc.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "5-1 * * * *", "*/0 * * * *", "@often", "@every -1m", "* * * foo *"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("%q parsed", spec)
+		}
+	}
+	s, _ := Parse("0 0 30 2 *")
+	if got := s.Next(time.Now()); !got.IsZero() {
+		t.Errorf("February 30th fires at %v", got)
+	}
+}
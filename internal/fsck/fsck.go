@@ -0,0 +1,75 @@
+// Package fsck is the client side of the central API's cluster audit: it
+// asks the central API to check every file and prints the report.
+package fsck
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/central"
+)
+
+// Main runs dsfs fsck. It exits with status 1 if issues remain (after
+// repair, with -repair) and 2 if the central API could not be asked.
+func Main(args []string) {
+	flags := flag.NewFlagSet("fsck", flag.ExitOnError)
+	centralURL := flags.String("central", "http://localhost:8000", "central API URL")
+	token := flags.String("token", os.Getenv("ADMIN_TOKEN"), "the central API's admin token (default $ADMIN_TOKEN)")
+	repair := flags.Bool("repair", false, "fix missing and mismatched replicas and missing central copies")
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	timeout := flags.Duration("timeout", 30*time.Minute, "give up after this long")
+	flags.Parse(args)
+
+	report, raw, err := Run(strings.TrimSuffix(*centralURL, "/"), *token, *repair, *timeout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "fsck:", err)
+		os.Exit(2)
+	}
+	if *asJSON {
+		os.Stdout.Write(raw)
+	} else {
+		fmt.Print(report)
+	}
+	if report.Unrepaired() > 0 {
+		os.Exit(1)
+	}
+}
+
+// Run asks the central API at base for an fsck report, repairing if asked.
+// The audit is an admin endpoint, so token is the central API's admin
+// token. The raw JSON is returned alongside the decoded report.
+func Run(base, token string, repair bool, timeout time.Duration) (*central.FsckReport, []byte, error) {
+	client := &http.Client{Timeout: timeout}
+	req, err := http.NewRequest("GET", base+"/api/v1/admin/fsck", nil)
+	if repair {
+		req, err = http.NewRequest("POST", base+"/api/v1/admin/fsck?repair=1", nil)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, apierr.FromResponse(resp)
+	}
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	var report central.FsckReport
+	if err := json.Unmarshal(raw, &report); err != nil {
+		return nil, nil, fmt.Errorf("bad report: %w", err)
+	}
+	return &report, raw, nil
+}
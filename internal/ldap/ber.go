@@ -0,0 +1,200 @@
+package ldap
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The subset of BER (X.690) that LDAP messages use: definite lengths,
+// single-byte tags.
+
+// Tag classes and the constructed bit.
+const (
+	classUniversal   = 0x00
+	classApplication = 0x40
+	classContext     = 0x80
+	constructed      = 0x20
+)
+
+// Universal tags.
+const (
+	tagBoolean    = 0x01
+	tagInteger    = 0x02
+	tagOctets     = 0x04
+	tagEnumerated = 0x0a
+	tagSequence   = 0x30 // constructed
+	tagSet        = 0x31 // constructed
+)
+
+// maxPacket bounds what a server may send in one message.
+const maxPacket = 16 << 20
+
+// packet is one decoded BER element.
+type packet struct {
+	tag      byte
+	value    []byte    // content of a primitive element
+	children []*packet // elements of a constructed one
+}
+
+func (p *packet) child(i int) *packet {
+	if p == nil || i >= len(p.children) {
+		return nil
+	}
+	return p.children[i]
+}
+
+func (p *packet) str() string {
+	if p == nil {
+		return ""
+	}
+	return string(p.value)
+}
+
+func (p *packet) int() int {
+	if p == nil || len(p.value) == 0 || len(p.value) > 8 {
+		return -1
+	}
+	n := int64(int8(p.value[0])) // sign-extend
+	for _, b := range p.value[1:] {
+		n = n<<8 | int64(b)
+	}
+	return int(n)
+}
+
+// encoding
+
+func tlv(tag byte, content []byte) []byte {
+	n := len(content)
+	var out []byte
+	switch {
+	case n < 0x80:
+		out = []byte{tag, byte(n)}
+	case n <= 0xff:
+		out = []byte{tag, 0x81, byte(n)}
+	case n <= 0xffff:
+		out = []byte{tag, 0x82, byte(n >> 8), byte(n)}
+	default:
+		out = []byte{tag, 0x84, byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}
+	}
+	return append(out, content...)
+}
+
+func seq(tag byte, elems ...[]byte) []byte {
+	var content []byte
+	for _, e := range elems {
+		content = append(content, e...)
+	}
+	return tlv(tag, content)
+}
+
+func octets(tag byte, s string) []byte {
+	return tlv(tag, []byte(s))
+}
+
+func integer(tag byte, n int) []byte {
+	var b []byte
+	v := int64(n)
+	for {
+		b = append([]byte{byte(v)}, b...)
+		v >>= 8
+		if (v == 0 && b[0]&0x80 == 0) || (v == -1 && b[0]&0x80 != 0) {
+			break
+		}
+	}
+	return tlv(tag, b)
+}
+
+func boolean(v bool) []byte {
+	if v {
+		return tlv(tagBoolean, []byte{0xff})
+	}
+	return tlv(tagBoolean, []byte{0})
+}
+
+// decoding
+
+var errMalformed = errors.New("ldap: malformed BER")
+
+// readPacket reads one element from r.
+func readPacket(r *bufio.Reader) (*packet, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	n, err := readLength(r)
+	if err != nil {
+		return nil, err
+	}
+	content := make([]byte, n)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return nil, err
+	}
+	return decode(tag, content)
+}
+
+func readLength(r io.ByteReader) (int, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if b < 0x80 {
+		return int(b), nil
+	}
+	k := int(b & 0x7f)
+	if k == 0 || k > 4 {
+		return 0, errMalformed // indefinite or absurd lengths
+	}
+	n := 0
+	for i := 0; i < k; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		n = n<<8 | int(b)
+	}
+	if n > maxPacket {
+		return 0, fmt.Errorf("ldap: %d-byte message is too large", n)
+	}
+	return n, nil
+}
+
+// decode builds the element with tag and content, decoding the children
+// of a constructed one.
+func decode(tag byte, content []byte) (*packet, error) {
+	p := &packet{tag: tag}
+	if tag&constructed == 0 {
+		p.value = content
+		return p, nil
+	}
+	for len(content) > 0 {
+		if len(content) < 2 {
+			return nil, errMalformed
+		}
+		childTag := content[0]
+		r := &sliceReader{b: content[1:]}
+		n, err := readLength(r)
+		if err != nil || n > len(r.b) {
+			return nil, errMalformed
+		}
+		c, err := decode(childTag, r.b[:n])
+		if err != nil {
+			return nil, err
+		}
+		p.children = append(p.children, c)
+		content = r.b[n:]
+	}
+	return p, nil
+}
+
+type sliceReader struct{ b []byte }
+
+func (s *sliceReader) ReadByte() (byte, error) {
+	if len(s.b) == 0 {
+		return 0, errMalformed
+	}
+	c := s.b[0]
+	s.b = s.b[1:]
+	return c, nil
+}
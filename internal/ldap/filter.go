@@ -0,0 +1,172 @@
+package ldap
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// EscapeFilter escapes s for use as a value in a search filter (RFC 4515),
+// so a user name cannot change the filter's meaning.
+func EscapeFilter(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '*', '(', ')', '\\', 0:
+			fmt.Fprintf(&b, "\\%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// EscapeDN escapes s for use as an attribute value in a distinguished name
+// (RFC 4514).
+func EscapeDN(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case strings.IndexByte(`,+"\<>;=`, c) >= 0,
+			c == '#' && i == 0,
+			c == ' ' && (i == 0 || i == len(s)-1):
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == 0:
+			b.WriteString(`\00`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// Filter choices.
+const (
+	filterAnd        = classContext | constructed | 0
+	filterOr         = classContext | constructed | 1
+	filterNot        = classContext | constructed | 2
+	filterEquality   = classContext | constructed | 3
+	filterSubstrings = classContext | constructed | 4
+	filterPresent    = classContext | 7
+)
+
+// compileFilter encodes a filter in its string form: "(attr=value)" with
+// "*" wildcards, and "&", "|" and "!" around others.
+func compileFilter(s string) ([]byte, error) {
+	out, rest, err := parseFilter(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("ldap filter %q: %w", s, err)
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("ldap filter %q: trailing %q", s, rest)
+	}
+	return out, nil
+}
+
+func parseFilter(s string) ([]byte, string, error) {
+	if !strings.HasPrefix(s, "(") {
+		return nil, "", fmt.Errorf("want '(' at %q", s)
+	}
+	s = s[1:]
+	if s == "" {
+		return nil, "", fmt.Errorf("unterminated")
+	}
+	switch s[0] {
+	case '&', '|':
+		tag := byte(filterAnd)
+		if s[0] == '|' {
+			tag = filterOr
+		}
+		s = s[1:]
+		var elems [][]byte
+		for strings.HasPrefix(s, "(") {
+			f, rest, err := parseFilter(s)
+			if err != nil {
+				return nil, "", err
+			}
+			elems = append(elems, f)
+			s = rest
+		}
+		if !strings.HasPrefix(s, ")") || len(elems) == 0 {
+			return nil, "", fmt.Errorf("bad list at %q", s)
+		}
+		return seq(tag, elems...), s[1:], nil
+	case '!':
+		f, rest, err := parseFilter(s[1:])
+		if err != nil {
+			return nil, "", err
+		}
+		if !strings.HasPrefix(rest, ")") {
+			return nil, "", fmt.Errorf("bad negation at %q", rest)
+		}
+		return seq(filterNot, f), rest[1:], nil
+	}
+
+	end := strings.IndexByte(s, ')')
+	if end < 0 {
+		return nil, "", fmt.Errorf("unterminated")
+	}
+	item, rest := s[:end], s[end+1:]
+	attr, value, ok := strings.Cut(item, "=")
+	if !ok || attr == "" {
+		return nil, "", fmt.Errorf("want attr=value, got %q", item)
+	}
+	if strings.ContainsAny(attr[len(attr)-1:], "<>~:") {
+		return nil, "", fmt.Errorf("only equality, presence and substring matches are supported: %q", item)
+	}
+	if value == "*" {
+		return octets(filterPresent, attr), rest, nil
+	}
+	parts := strings.Split(value, "*")
+	if len(parts) == 1 {
+		v, err := unescapeFilter(value)
+		if err != nil {
+			return nil, "", err
+		}
+		return seq(filterEquality, octets(tagOctets, attr), octets(tagOctets, v)), rest, nil
+	}
+	var subs [][]byte
+	for i, part := range parts {
+		if part == "" {
+			continue
+		}
+		v, err := unescapeFilter(part)
+		if err != nil {
+			return nil, "", err
+		}
+		tag := byte(classContext | 1) // any
+		switch i {
+		case 0:
+			tag = classContext | 0 // initial
+		case len(parts) - 1:
+			tag = classContext | 2 // final
+		}
+		subs = append(subs, octets(tag, v))
+	}
+	return seq(filterSubstrings, octets(tagOctets, attr), seq(tagSequence, subs...)), rest, nil
+}
+
+func unescapeFilter(s string) (string, error) {
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+3 > len(s) {
+			return "", fmt.Errorf("bad escape in %q", s)
+		}
+		c, err := hex.DecodeString(s[i+1 : i+3])
+		if err != nil {
+			return "", fmt.Errorf("bad escape in %q", s)
+		}
+		b.Write(c)
+		i += 2
+	}
+	return b.String(), nil
+}
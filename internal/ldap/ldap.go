@@ -0,0 +1,269 @@
+// Package ldap is a small LDAPv3 client: enough to check a user's password
+// against a directory (OpenLDAP, Active Directory) with a simple bind, and
+// to look the user and their groups up with a search. Connections go over
+// ldaps://, or ldap:// upgraded with StartTLS, or plain ldap:// on trusted
+// networks only, since a simple bind sends the password as it is.
+package ldap
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Protocol operations.
+const (
+	opBindRequest     = classApplication | constructed | 0
+	opBindResponse    = classApplication | constructed | 1
+	opUnbindRequest   = classApplication | 2
+	opSearchRequest   = classApplication | constructed | 3
+	opSearchEntry     = classApplication | constructed | 4
+	opSearchDone      = classApplication | constructed | 5
+	opSearchReference = classApplication | constructed | 19
+	opExtendedRequest = classApplication | constructed | 23
+	opExtendedResp    = classApplication | constructed | 24
+)
+
+const startTLSOID = "1.3.6.1.4.1.1466.20037"
+
+// Result codes callers tell apart.
+const (
+	ResultSuccess            = 0
+	ResultInvalidCredentials = 49
+)
+
+// Error is a result code other than success from the server.
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("ldap: result code %d", e.Code)
+	}
+	return fmt.Sprintf("ldap: result code %d: %s", e.Code, e.Message)
+}
+
+// IsInvalidCredentials reports whether err is a bind refused for a wrong
+// name or password.
+func IsInvalidCredentials(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Code == ResultInvalidCredentials
+}
+
+// Conn is a connection to a directory server. It is not safe for
+// concurrent use.
+type Conn struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	timeout time.Duration
+	lastID  int
+}
+
+// Dial connects to an ldap:// or ldaps:// URL; the port defaults to 389 or
+// 636. With startTLS, an ldap:// connection is upgraded before use. Every
+// request must be answered within timeout.
+func Dial(rawURL string, tlsConfig *tls.Config, startTLS bool, timeout time.Duration) (*Conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("ldap: %w", err)
+	}
+	host := u.Host
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	switch u.Scheme {
+	case "ldap":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "389")
+		}
+		conn, err = dialer.Dial("tcp", host)
+	case "ldaps":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "636")
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, withServerName(tlsConfig, u.Hostname()))
+	default:
+		return nil, fmt.Errorf("ldap: URL %q must be ldap:// or ldaps://", rawURL)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &Conn{conn: conn, r: bufio.NewReader(conn), timeout: timeout}
+	if startTLS && u.Scheme == "ldap" {
+		if err := c.startTLS(withServerName(tlsConfig, u.Hostname())); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func withServerName(cfg *tls.Config, host string) *tls.Config {
+	if cfg == nil {
+		cfg = &tls.Config{}
+	}
+	cfg = cfg.Clone()
+	if cfg.ServerName == "" {
+		cfg.ServerName = host
+	}
+	return cfg
+}
+
+// Close unbinds and closes the connection.
+func (c *Conn) Close() error {
+	c.send(tlv(opUnbindRequest, nil))
+	return c.conn.Close()
+}
+
+// send writes one message with op and returns its id.
+func (c *Conn) send(op []byte) (int, error) {
+	c.lastID++
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	_, err := c.conn.Write(seq(tagSequence, integer(tagInteger, c.lastID), op))
+	return c.lastID, err
+}
+
+// receive reads the next message for id and returns its operation.
+func (c *Conn) receive(id int) (*packet, error) {
+	for {
+		msg, err := readPacket(c.r)
+		if err != nil {
+			return nil, fmt.Errorf("ldap: %w", err)
+		}
+		if msg.tag != tagSequence || len(msg.children) < 2 {
+			return nil, errMalformed
+		}
+		if msg.child(0).int() == id {
+			return msg.child(1), nil
+		}
+		// Unsolicited notifications (id 0) and strays are skipped.
+	}
+}
+
+// result checks an LDAPResult.
+func result(op *packet) error {
+	code := op.child(0).int()
+	if code == ResultSuccess {
+		return nil
+	}
+	return &Error{Code: code, Message: op.child(2).str()}
+}
+
+func (c *Conn) startTLS(cfg *tls.Config) error {
+	id, err := c.send(seq(opExtendedRequest, octets(classContext|0, startTLSOID)))
+	if err != nil {
+		return err
+	}
+	op, err := c.receive(id)
+	if err != nil {
+		return err
+	}
+	if op.tag != opExtendedResp {
+		return errMalformed
+	}
+	if err := result(op); err != nil {
+		return fmt.Errorf("StartTLS: %w", err)
+	}
+	tc := tls.Client(c.conn, cfg)
+	tc.SetDeadline(time.Now().Add(c.timeout))
+	if err := tc.Handshake(); err != nil {
+		return err
+	}
+	c.conn = tc
+	c.r = bufio.NewReader(tc)
+	return nil
+}
+
+// Bind authenticates as dn with a simple bind. An empty password is
+// refused here: servers take it as an anonymous bind and report success.
+func (c *Conn) Bind(dn, password string) error {
+	if password == "" {
+		return &Error{Code: ResultInvalidCredentials, Message: "empty password"}
+	}
+	id, err := c.send(seq(opBindRequest,
+		integer(tagInteger, 3),
+		octets(tagOctets, dn),
+		octets(classContext|0, password)))
+	if err != nil {
+		return err
+	}
+	op, err := c.receive(id)
+	if err != nil {
+		return err
+	}
+	if op.tag != opBindResponse {
+		return errMalformed
+	}
+	return result(op)
+}
+
+// Entry is a search result.
+type Entry struct {
+	DN    string
+	Attrs map[string][]string // by attribute name, lowercased
+}
+
+// Get returns the values of attr.
+func (e Entry) Get(attr string) []string {
+	return e.Attrs[strings.ToLower(attr)]
+}
+
+// Search returns the entries under base that match filter (see
+// compileFilter), with attrs, stopping at sizeLimit entries (0 = the
+// server's limit). Referrals are not followed.
+func (c *Conn) Search(base, filter string, attrs []string, sizeLimit int) ([]Entry, error) {
+	f, err := compileFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	var attrList [][]byte
+	for _, a := range attrs {
+		attrList = append(attrList, octets(tagOctets, a))
+	}
+	id, err := c.send(seq(opSearchRequest,
+		octets(tagOctets, base),
+		integer(tagEnumerated, 2), // whole subtree
+		integer(tagEnumerated, 0), // never dereference aliases
+		integer(tagInteger, sizeLimit),
+		integer(tagInteger, int(c.timeout/time.Second)),
+		boolean(false),
+		f,
+		seq(tagSequence, attrList...)))
+	if err != nil {
+		return nil, err
+	}
+
+	var out []Entry
+	for {
+		op, err := c.receive(id)
+		if err != nil {
+			return nil, err
+		}
+		switch op.tag {
+		case opSearchEntry:
+			e := Entry{DN: op.child(0).str(), Attrs: map[string][]string{}}
+			if list := op.child(1); list != nil {
+				for _, attr := range list.children {
+					name := strings.ToLower(attr.child(0).str())
+					if vals := attr.child(1); vals != nil {
+						for _, v := range vals.children {
+							e.Attrs[name] = append(e.Attrs[name], v.str())
+						}
+					}
+				}
+			}
+			out = append(out, e)
+		case opSearchReference:
+		case opSearchDone:
+			return out, result(op)
+		default:
+			return nil, errMalformed
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package ldap
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeDirectory serves binds and equality searches over entries.
+type fakeDirectory struct {
+	passwords map[string]string // by DN
+	entries   []Entry
+}
+
+func (d *fakeDirectory) serve(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go d.handle(conn)
+		}
+	}()
+	return "ldap://" + ln.Addr().String()
+}
+
+func (d *fakeDirectory) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	bound := ""
+	for {
+		msg, err := readPacket(r)
+		if err != nil {
+			return
+		}
+		id, op := msg.child(0).int(), msg.child(1)
+		reply := func(ops ...[]byte) {
+			for _, o := range ops {
+				conn.Write(seq(tagSequence, integer(tagInteger, id), o))
+			}
+		}
+		ldapResult := func(tag byte, code int) []byte {
+			return seq(tag, integer(tagEnumerated, code), octets(tagOctets, ""), octets(tagOctets, ""))
+		}
+		switch op.tag {
+		case opBindRequest:
+			dn, pw := op.child(1).str(), op.child(2).str()
+			if want, ok := d.passwords[dn]; ok && want == pw {
+				bound = dn
+				reply(ldapResult(opBindResponse, ResultSuccess))
+			} else {
+				reply(ldapResult(opBindResponse, ResultInvalidCredentials))
+			}
+		case opSearchRequest:
+			if bound == "" {
+				reply(ldapResult(opSearchDone, 50)) // insufficient access
+				continue
+			}
+			f := op.child(6)
+			var out [][]byte
+			for _, e := range d.entries {
+				if f.tag == filterEquality && strings.Contains(strings.ToLower(e.DN), strings.ToLower(f.child(0).str()+"="+f.child(1).str())) {
+					var attrs [][]byte
+					for name, vals := range e.Attrs {
+						var vs [][]byte
+						for _, v := range vals {
+							vs = append(vs, octets(tagOctets, v))
+						}
+						attrs = append(attrs, seq(tagSequence, octets(tagOctets, name), seq(tagSet, vs...)))
+					}
+					out = append(out, seq(opSearchEntry, octets(tagOctets, e.DN), seq(tagSequence, attrs...)))
+				}
+			}
+			reply(append(out, ldapResult(opSearchDone, ResultSuccess))...)
+		case opUnbindRequest:
+			return
+		}
+	}
+}
+
+func TestBindAndSearch(t *testing.T) {
+	d := &fakeDirectory{
+		passwords: map[string]string{
+			"cn=svc,dc=example,dc=com":            "svcpass",
+			"uid=ann,ou=people,dc=example,dc=com": "annpass",
+		},
+		entries: []Entry{{
+			DN:    "uid=ann,ou=people,dc=example,dc=com",
+			Attrs: map[string][]string{"memberOf": {"cn=staff,ou=groups,dc=example,dc=com", "cn=uploaders,ou=groups,dc=example,dc=com"}},
+		}},
+	}
+	c, err := Dial(d.serve(t), nil, false, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err := c.Bind("cn=svc,dc=example,dc=com", "wrong"); !IsInvalidCredentials(err) {
+		t.Errorf("wrong password: %v", err)
+	}
+	if err := c.Bind("cn=svc,dc=example,dc=com", ""); !IsInvalidCredentials(err) {
+		t.Errorf("empty password: %v", err)
+	}
+	if err := c.Bind("cn=svc,dc=example,dc=com", "svcpass"); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := c.Search("dc=example,dc=com", "(uid="+EscapeFilter("ann")+")", []string{"memberOf"}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || len(entries[0].Get("memberof")) != 2 {
+		t.Fatalf("entries = %+v", entries)
+	}
+	if err := c.Bind(entries[0].DN, "annpass"); err != nil {
+		t.Errorf("user bind: %v", err)
+	}
+	if entries, _ := c.Search("dc=example,dc=com", "(uid=bob)", nil, 2); len(entries) != 0 {
+		t.Errorf("bob found: %+v", entries)
+	}
+}
+
+func TestFilters(t *testing.T) {
+	if got := EscapeFilter("a*)(uid=*"); got != `a\2a\29\28uid=\2a` {
+		t.Errorf("EscapeFilter = %s", got)
+	}
+	if got := EscapeDN(`Smith, John+"x"`); got != `Smith\, John\+\"x\"` {
+		t.Errorf("EscapeDN = %s", got)
+	}
+
+	f, err := compileFilter(`(&(objectClass=user)(|(sAMAccountName=a\2ab)(mail=*@example.com))(!(cn=*)))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := readPacket(bufio.NewReader(bytes.NewReader(f)))
+	if err != nil || p.tag != filterAnd || len(p.children) != 3 {
+		t.Fatalf("and = %+v, %v", p, err)
+	}
+	if eq := p.child(1).child(0); eq.tag != filterEquality || eq.child(1).str() != "a*b" {
+		t.Errorf("escaped value = %q", eq.child(1).str())
+	}
+	if sub := p.child(1).child(1); sub.tag != filterSubstrings || sub.child(1).child(0).tag != classContext|2 {
+		t.Errorf("substring = %+v", sub)
+	}
+	if not := p.child(2); not.tag != filterNot || not.child(0).tag != filterPresent {
+		t.Errorf("not = %+v", not)
+	}
+	for _, bad := range []string{"uid=a", "(uid=a", "(&)", "(age>=3)", `(uid=\2)`, "(uid=a)x"} {
+		if _, err := compileFilter(bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+
+	for _, n := range []int{0, 1, 127, 128, 255, 256, -1, -129, 1 << 20} {
+		enc := integer(tagInteger, n)
+		if p, _ := readPacket(bufio.NewReader(bytes.NewReader(enc))); p.int() != n {
+			t.Errorf("integer %d decoded as %d (% x)", n, p.int(), enc)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// Package listen opens the addresses the central API and the storage nodes
+// serve on. An address is host:port; IPv6 hosts go in brackets
+// ("[::1]:8000", "[::]:8000"), and an empty host (":8000") listens on
+// every interface, IPv4 and IPv6 alike. "unix:/path/to/socket" listens on
+// a Unix domain socket instead, for a reverse proxy or the central API on
+// the same host. A service can listen on several addresses at once, each
+// serving the same handler.
+package listen
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Check reports whether addr can be listened on, as far as its form goes.
+func Check(addr string) error {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		if path == "" {
+			return fmt.Errorf("listen address %q: no socket path", addr)
+		}
+		return nil
+	}
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("listen address %q: %w", addr, err)
+	}
+	if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+		return fmt.Errorf("listen address %q: %q is not a valid TCP port", addr, port)
+	}
+	return nil
+}
+
+// Split splits a comma-separated list of addresses, as environment
+// variables and flags give them, dropping blanks.
+func Split(s string) []string {
+	var out []string
+	for _, a := range strings.Split(s, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// Open listens on every address, or on none: if one fails, those already
+// open are closed again.
+func Open(addrs []string) ([]net.Listener, error) {
+	var lns []net.Listener
+	for _, addr := range addrs {
+		ln, err := open(addr)
+		if err != nil {
+			for _, l := range lns {
+				l.Close()
+			}
+			return nil, err
+		}
+		lns = append(lns, ln)
+	}
+	return lns, nil
+}
+
+func open(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, "unix:")
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	// A socket left behind by a process that did not get to close it
+	// would block the address; anything else there is not ours to remove.
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	// Anyone on the host may connect, as they could to a loopback port;
+	// the directory's permissions can narrow that down.
+	if err := os.Chmod(path, 0666); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// Serve serves srv on every listener until one fails, returning its error,
+// or until srv is shut down, returning http.ErrServerClosed.
+func Serve(srv *http.Server, lns []net.Listener) error {
+	errc := make(chan error, len(lns))
+	for _, ln := range lns {
+		go func(ln net.Listener) { errc <- srv.Serve(ln) }(ln)
+	}
+	for range lns {
+		if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+	}
+	return http.ErrServerClosed
+}
+
+// Addrs lists the addresses lns listen on, for the startup message.
+func Addrs(lns []net.Listener) string {
+	var out []string
+	for _, ln := range lns {
+		a := ln.Addr()
+		if a.Network() == "unix" {
+			out = append(out, "unix:"+a.String())
+		} else {
+			out = append(out, a.String())
+		}
+	}
+	return strings.Join(out, ", ")
+}
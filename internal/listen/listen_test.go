@@ -0,0 +1,115 @@
+package listen
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	for addr, ok := range map[string]bool{
+		":8000":            true,
+		"0.0.0.0:8000":     true,
+		"[::]:8000":        true,
+		"[::1]:8000":       true,
+		"localhost:8000":   true,
+		"8000":             false,
+		"::1:8000":         false,
+		"[::1]:http":       false,
+		"127.0.0.1:0":      false,
+		"127.0.0.1:9999":   true,
+		"unix:/run/a.sock": true,
+		"unix:":            false,
+	} {
+		if err := Check(addr); (err == nil) != ok {
+			t.Errorf("Check(%q) = %v", addr, err)
+		}
+	}
+	if got := Split(" 127.0.0.1:1, ,[::1]:2 "); !slices.Equal(got, []string{"127.0.0.1:1", "[::1]:2"}) {
+		t.Errorf("Split = %q", got)
+	}
+}
+
+func TestServeSeveral(t *testing.T) {
+	lns, err := Open([]string{"127.0.0.1:0", "[::1]:0"})
+	if err != nil {
+		t.Skip("no IPv6 loopback:", err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	})}
+	done := make(chan error, 1)
+	go func() { done <- Serve(srv, lns) }()
+
+	for _, ln := range lns {
+		resp, err := http.Get("http://" + ln.Addr().String() + "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(b) != "ok" {
+			t.Errorf("%s answered %q", ln.Addr(), b)
+		}
+	}
+
+	srv.Shutdown(context.Background())
+	if err := <-done; !errors.Is(err, http.ErrServerClosed) {
+		t.Errorf("Serve = %v after shutdown", err)
+	}
+}
+
+func TestOpenAllOrNothing(t *testing.T) {
+	lns, err := Open([]string{"127.0.0.1:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lns[0].Close()
+	busy := lns[0].Addr().String()
+	if _, err := Open([]string{"127.0.0.1:0", busy}); err == nil {
+		t.Fatal("listening twice on", busy)
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api.sock")
+	os.WriteFile(path, nil, 0644) // not a socket: left alone
+	if _, err := Open([]string{"unix:" + path}); err == nil {
+		t.Fatal("listened over a regular file")
+	}
+	os.Remove(path)
+
+	lns, err := Open([]string{"unix:" + path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := Addrs(lns); got != "unix:"+path {
+		t.Errorf("Addrs = %q", got)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "over unix")
+	})}
+	go Serve(srv, lns)
+	defer srv.Close()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	resp, err := client.Get("http://api/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(b) != "over unix" {
+		t.Errorf("got %q", b)
+	}
+}
@@ -0,0 +1,144 @@
+// Package merkle summarises a set of files as a Merkle tree over their
+// names, so two copies of a namespace can be compared one subtree at a time
+// and only the parts that differ need to be listed.
+//
+// Files are placed by the hex SHA-256 of their name, one hex digit per
+// level, which spreads any namespace evenly over the tree. A leaf's hash
+// covers the file's name and checksum; an inner node's hash covers its
+// non-empty children in order. Both sides of a comparison therefore get
+// the same hashes for the same files, however they list them.
+package merkle
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+)
+
+// Entry is one file in a tree.
+type Entry struct {
+	Name     string
+	Checksum string
+}
+
+// Summary describes one subtree, named by the key prefix its files share.
+type Summary struct {
+	Prefix string `json:"prefix"`
+	Hash   string `json:"hash"` // empty for an empty subtree
+	Count  int    `json:"count"`
+}
+
+type leaf struct {
+	key  string // hex SHA-256 of the name
+	name string
+	hash [sha256.Size]byte
+}
+
+// Tree is a Merkle tree over a fixed set of files. Subtree hashes are
+// computed when asked for.
+type Tree struct {
+	leaves []leaf // sorted by key
+}
+
+// Build returns the tree over entries. Of entries with the same name, the
+// last one counts.
+func Build(entries []Entry) *Tree {
+	byName := make(map[string]string, len(entries))
+	for _, e := range entries {
+		byName[e.Name] = e.Checksum
+	}
+	t := &Tree{leaves: make([]leaf, 0, len(byName))}
+	for name, sum := range byName {
+		t.leaves = append(t.leaves, leaf{
+			key:  Key(name),
+			name: name,
+			hash: sha256.Sum256([]byte(name + "\x00" + sum)),
+		})
+	}
+	sort.Slice(t.leaves, func(i, j int) bool { return t.leaves[i].key < t.leaves[j].key })
+	return t
+}
+
+// Key returns where name sits in a tree: the hex SHA-256 of the name.
+// A file is under every prefix of its key.
+func Key(name string) string {
+	k := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(k[:])
+}
+
+// ValidPrefix reports whether p can name a subtree: up to 64 lowercase hex
+// digits.
+func ValidPrefix(p string) bool {
+	if len(p) > 2*sha256.Size {
+		return false
+	}
+	for _, c := range p {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// span returns the leaves under prefix.
+func (t *Tree) span(prefix string) []leaf {
+	lo := sort.Search(len(t.leaves), func(i int) bool { return t.leaves[i].key >= prefix })
+	rest := t.leaves[lo:]
+	hi := sort.Search(len(rest), func(i int) bool { return !strings.HasPrefix(rest[i].key, prefix) })
+	return rest[:hi]
+}
+
+// hashOf hashes leaves, which share their first depth key digits.
+func hashOf(leaves []leaf, depth int) [sha256.Size]byte {
+	if len(leaves) == 1 {
+		return leaves[0].hash
+	}
+	h := sha256.New()
+	for len(leaves) > 0 {
+		d := leaves[0].key[depth]
+		n := sort.Search(len(leaves), func(i int) bool { return leaves[i].key[depth] > d })
+		sub := hashOf(leaves[:n], depth+1)
+		h.Write([]byte{d})
+		h.Write(sub[:])
+		leaves = leaves[n:]
+	}
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	return sum
+}
+
+// Summary describes the subtree under prefix.
+func (t *Tree) Summary(prefix string) Summary {
+	leaves := t.span(prefix)
+	s := Summary{Prefix: prefix, Count: len(leaves)}
+	if len(leaves) > 0 {
+		sum := hashOf(leaves, len(prefix))
+		s.Hash = hex.EncodeToString(sum[:])
+	}
+	return s
+}
+
+// Children describes the non-empty subtrees one level below prefix.
+func (t *Tree) Children(prefix string) []Summary {
+	var out []Summary
+	if len(prefix) >= 2*sha256.Size {
+		return out
+	}
+	for _, d := range "0123456789abcdef" {
+		if s := t.Summary(prefix + string(d)); s.Count > 0 {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// Names returns the names of the files under prefix.
+func (t *Tree) Names(prefix string) []string {
+	leaves := t.span(prefix)
+	names := make([]string, len(leaves))
+	for i, l := range leaves {
+		names[i] = l.name
+	}
+	return names
+}
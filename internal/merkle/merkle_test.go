@@ -0,0 +1,67 @@
+package merkle
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func entries(n int) []Entry {
+	var out []Entry
+	for i := 0; i < n; i++ {
+		out = append(out, Entry{Name: fmt.Sprintf("file-%d.txt", i), Checksum: fmt.Sprint(i)})
+	}
+	return out
+}
+
+func TestSameFilesSameHashes(t *testing.T) {
+	es := entries(500)
+	a := Build(es)
+	slices.Reverse(es)
+	b := Build(es)
+	if a.Summary("") != b.Summary("") {
+		t.Errorf("root differs with listing order: %v vs %v", a.Summary(""), b.Summary(""))
+	}
+	if got := a.Summary("").Count; got != 500 {
+		t.Errorf("count = %d", got)
+	}
+	if got := Build(nil).Summary(""); got.Hash != "" || got.Count != 0 {
+		t.Errorf("empty tree = %+v", got)
+	}
+}
+
+func TestDifferenceFoundBySubtree(t *testing.T) {
+	es := entries(500)
+	a := Build(es)
+	es[42].Checksum = "changed"
+	b := Build(es)
+
+	if a.Summary("").Hash == b.Summary("").Hash {
+		t.Fatal("roots match despite a changed file")
+	}
+	// Follow the one differing child per level down to the file.
+	prefix := ""
+	for a.Summary(prefix).Count > 1 {
+		var next []string
+		for _, c := range a.Children(prefix) {
+			if b.Summary(c.Prefix) != c {
+				next = append(next, c.Prefix)
+			}
+		}
+		if len(next) != 1 {
+			t.Fatalf("under %q: %d differing children, want 1", prefix, len(next))
+		}
+		prefix = next[0]
+	}
+	if names := a.Names(prefix); !slices.Equal(names, []string{"file-42.txt"}) {
+		t.Errorf("differing subtree %q holds %v", prefix, names)
+	}
+}
+
+func TestValidPrefix(t *testing.T) {
+	for p, want := range map[string]bool{"": true, "0af": true, "0AF": false, "xy": false, Key("a"): true, Key("a") + "0": false} {
+		if ValidPrefix(p) != want {
+			t.Errorf("ValidPrefix(%q) = %v", p, !want)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package qr
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestReedSolomon(t *testing.T) {
+	// "HELLO WORLD" as a 1-M code, from the usual worked example.
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	if got := rsRemainder(data, rsDivisor(10)); !bytes.Equal(got, want) {
+		t.Errorf("ECC = %v, want %v", got, want)
+	}
+}
+
+func TestCapacity(t *testing.T) {
+	for _, tc := range []struct {
+		version int
+		level   Level
+		want    int
+	}{
+		{1, L, 19}, {1, M, 16}, {1, Q, 13}, {1, H, 9},
+		{10, M, 216},
+		{40, L, 2956}, {40, M, 2334}, {40, Q, 1666}, {40, H, 1276},
+	} {
+		if got := dataCodewords(tc.version, tc.level); got != tc.want {
+			t.Errorf("version %d level %d: %d data codewords, want %d", tc.version, tc.level, got, tc.want)
+		}
+	}
+	// Every version must leave room for data in each block.
+	for level := L; level <= H; level++ {
+		for v := 1; v <= 40; v++ {
+			if n := eccBlocks[level][v]; rawCodewords(v)/n-eccPerBlock[level][v] < 1 {
+				t.Errorf("version %d level %d: empty blocks", v, level)
+			}
+		}
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	for _, tc := range []struct {
+		data    string
+		level   Level
+		version int
+	}{
+		{"https://files.example.com/s/abc", M, 3},
+		{"", L, 1},
+		{"https://files.example.com/get/" + strings.Repeat("x", 200), Q, 13},
+		{strings.Repeat("long ", 500), L, 0},
+	} {
+		c, err := Encode([]byte(tc.data), tc.level)
+		if err != nil {
+			t.Fatalf("%q: %v", tc.data, err)
+		}
+		if tc.version != 0 && c.Version != tc.version {
+			t.Errorf("%q: version %d, want %d", tc.data, c.Version, tc.version)
+		}
+		if got := decode(t, c); got != tc.data {
+			t.Errorf("decoded %q, want %q", got, tc.data)
+		}
+	}
+	if _, err := Encode(make([]byte, 3000), L); err != ErrTooLong {
+		t.Errorf("3000 bytes: %v", err)
+	}
+}
+
+func TestImage(t *testing.T) {
+	c, _ := Encode([]byte("hi"), M)
+	img := c.Image(3)
+	if side := (21 + 2*QuietZone) * 3; img.Bounds().Dx() != side || img.Bounds().Dy() != side {
+		t.Fatalf("image is %v", img.Bounds())
+	}
+	// The top left finder's corner, after the quiet zone.
+	if img.ColorIndexAt(QuietZone*3, QuietZone*3) != 1 || img.ColorIndexAt(0, 0) != 0 {
+		t.Error("finder or quiet zone misdrawn")
+	}
+}
+
+// decode reads c back the way a scanner would once it has the modules:
+// format bits, unmask, codewords, de-interleave, check each block's ECC.
+func decode(t *testing.T, c *Code) string {
+	t.Helper()
+	format := 0
+	for i := 14; i >= 9; i-- {
+		format = format<<1 | bit(c.Dark(14-i, 8))
+	}
+	format = format<<1 | bit(c.Dark(7, 8))
+	format = format<<1 | bit(c.Dark(8, 8))
+	format = format<<1 | bit(c.Dark(8, 7))
+	for i := 5; i >= 0; i-- {
+		format = format<<1 | bit(c.Dark(8, i))
+	}
+	format ^= 0x5412
+	level := [4]Level{M, L, H, Q}[format>>13]
+	mask := format >> 10 & 7
+	if level != c.Level || mask != c.Mask {
+		t.Fatalf("format bits say level %d mask %d, want %d %d", level, mask, c.Level, c.Mask)
+	}
+
+	u := *c
+	u.dark = append([]bool(nil), c.dark...)
+	u.applyMask(mask)
+	var raw []byte
+	var cur, n int
+	for right := u.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < u.Size; vert++ {
+			y := vert
+			if (right+1)&2 == 0 {
+				y = u.Size - 1 - vert
+			}
+			for x := right; x > right-2; x-- {
+				if u.function[y*u.Size+x] {
+					continue
+				}
+				cur = cur<<1 | bit(u.Dark(x, y))
+				if n++; n%8 == 0 {
+					raw = append(raw, byte(cur))
+					cur = 0
+				}
+			}
+		}
+	}
+	raw = raw[:rawCodewords(c.Version)]
+
+	numBlocks := eccBlocks[level][c.Version]
+	eccLen := eccPerBlock[level][c.Version]
+	numShort := numBlocks - len(raw)%numBlocks
+	shortData := len(raw)/numBlocks - eccLen
+	blocks := make([][]byte, numBlocks)
+	k := 0
+	for i := 0; i < shortData+1; i++ {
+		for j := range blocks {
+			if i < shortData || j >= numShort {
+				blocks[j] = append(blocks[j], raw[k])
+				k++
+			}
+		}
+	}
+	for i := 0; i < eccLen; i++ {
+		for j := range blocks {
+			blocks[j] = append(blocks[j], raw[k])
+			k++
+		}
+	}
+	var data []byte
+	for j, b := range blocks {
+		d := b[:len(b)-eccLen]
+		if !bytes.Equal(rsRemainder(d, rsDivisor(eccLen)), b[len(d):]) {
+			t.Fatalf("block %d: ECC mismatch", j)
+		}
+		data = append(data, d...)
+	}
+
+	if data[0]>>4 != 0b0100 {
+		t.Fatalf("mode %04b", data[0]>>4)
+	}
+	width := countBits(c.Version)
+	pos := 4
+	read := func(bits int) int {
+		v := 0
+		for i := 0; i < bits; i++ {
+			v = v<<1 | int(data[pos/8]>>(7-pos%8)&1)
+			pos++
+		}
+		return v
+	}
+	length := read(width)
+	out := make([]byte, length)
+	for i := range out {
+		out[i] = byte(read(8))
+	}
+	return string(out)
+}
+
+func bit(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
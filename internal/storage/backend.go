@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Backend is where a storage node keeps file contents. Names are flat file
+// names (no directories); handlers sanitize them before calling in.
+type Backend interface {
+	Put(name string, r io.Reader) (int64, error)
+	Get(name string) (io.ReadSeekCloser, Object, error)
+	Delete(name string) error
+	List() ([]Object, error)
+	Stat(name string) (Object, error)
+}
+
+// Object is the metadata a backend keeps per file.
+type Object struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// ErrNotFound is returned by backends for missing files.
+var ErrNotFound = errors.New("file not found")
+
+// usageReporter is implemented by backends that know their capacity.
+type usageReporter interface {
+	Usage() (total, free uint64, err error)
+}
+
+// NewBackend opens the backend selected by cfg.Backend. The s3 backend
+// reads its settings from the S3_* and AWS_* environment variables.
+func NewBackend(cfg Config) (Backend, error) {
+	switch cfg.Backend {
+	case "", "local":
+		return NewLocalBackend(cfg.StoragePath)
+	case "memory":
+		return NewMemoryBackend(), nil
+	case "s3":
+		return newS3BackendFromEnv()
+	default:
+		return nil, fmt.Errorf("unknown BACKEND %q (want local, memory or s3)", cfg.Backend)
+	}
+}
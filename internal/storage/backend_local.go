@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// localBackend stores files in a directory on the node's disk.
+type localBackend struct {
+	dir string
+}
+
+const tempPrefix = ".upload-"
+
+// NewLocalBackend stores files in dir, creating it if needed.
+func NewLocalBackend(dir string) (*localBackend, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &localBackend{dir: dir}, nil
+}
+
+// Put writes to a temp file first and renames it into place, so readers
+// never see a half-written file.
+func (b *localBackend) Put(name string, r io.Reader) (int64, error) {
+	tmp, err := os.CreateTemp(b.dir, tempPrefix+"*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return n, err
+	}
+	return n, os.Rename(tmp.Name(), filepath.Join(b.dir, name))
+}
+
+func (b *localBackend) Get(name string) (io.ReadSeekCloser, Object, error) {
+	f, err := os.Open(filepath.Join(b.dir, name))
+	if err != nil {
+		return nil, Object{}, mapNotExist(err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, Object{}, err
+	}
+	if fi.IsDir() {
+		f.Close()
+		return nil, Object{}, ErrNotFound
+	}
+	return f, fileObject(fi), nil
+}
+
+func (b *localBackend) Delete(name string) error {
+	return mapNotExist(os.Remove(filepath.Join(b.dir, name)))
+}
+
+func (b *localBackend) List() ([]Object, error) {
+	entries, err := os.ReadDir(b.dir)
+	if err != nil {
+		return nil, err
+	}
+	var out []Object
+	for _, e := range entries {
+		if e.IsDir() || strings.HasPrefix(e.Name(), tempPrefix) {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue // removed since ReadDir
+		}
+		out = append(out, fileObject(fi))
+	}
+	return out, nil
+}
+
+func (b *localBackend) Stat(name string) (Object, error) {
+	fi, err := os.Stat(filepath.Join(b.dir, name))
+	if err != nil {
+		return Object{}, mapNotExist(err)
+	}
+	if fi.IsDir() {
+		return Object{}, ErrNotFound
+	}
+	return fileObject(fi), nil
+}
+
+func (b *localBackend) Usage() (total, free uint64, err error) {
+	return diskUsage(b.dir)
+}
+
+func fileObject(fi os.FileInfo) Object {
+	return Object{Name: fi.Name(), Size: fi.Size(), ModTime: fi.ModTime().UTC()}
+}
+
+func mapNotExist(err error) error {
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	}
+	return err
+}
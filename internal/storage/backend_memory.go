@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryBackend keeps files in RAM. Meant for tests and throwaway nodes.
+type memoryBackend struct {
+	mu    sync.RWMutex
+	files map[string]memoryFile
+}
+
+type memoryFile struct {
+	data    []byte
+	modTime time.Time
+}
+
+// NewMemoryBackend returns an empty in-memory backend.
+func NewMemoryBackend() *memoryBackend {
+	return &memoryBackend{files: map[string]memoryFile{}}
+}
+
+func (b *memoryBackend) Put(name string, r io.Reader) (int64, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return int64(len(data)), err
+	}
+	b.mu.Lock()
+	b.files[name] = memoryFile{data: data, modTime: time.Now().UTC()}
+	b.mu.Unlock()
+	return int64(len(data)), nil
+}
+
+type nopSeekCloser struct {
+	*bytes.Reader
+}
+
+func (nopSeekCloser) Close() error { return nil }
+
+func (b *memoryBackend) Get(name string) (io.ReadSeekCloser, Object, error) {
+	b.mu.RLock()
+	f, ok := b.files[name]
+	b.mu.RUnlock()
+	if !ok {
+		return nil, Object{}, ErrNotFound
+	}
+	// Stored slices are never mutated, so readers can share them.
+	return nopSeekCloser{bytes.NewReader(f.data)}, f.object(name), nil
+}
+
+func (b *memoryBackend) Delete(name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.files[name]; !ok {
+		return ErrNotFound
+	}
+	delete(b.files, name)
+	return nil
+}
+
+func (b *memoryBackend) List() ([]Object, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	out := make([]Object, 0, len(b.files))
+	for name, f := range b.files {
+		out = append(out, f.object(name))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+func (b *memoryBackend) Stat(name string) (Object, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	f, ok := b.files[name]
+	if !ok {
+		return Object{}, ErrNotFound
+	}
+	return f.object(name), nil
+}
+
+func (f memoryFile) object(name string) Object {
+	return Object{Name: name, Size: int64(len(f.data)), ModTime: f.modTime}
+}
@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBackends(t *testing.T) {
+	dir := t.TempDir()
+	local, err := NewLocalBackend(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A leftover from an interrupted upload must not show up in listings.
+	os.WriteFile(filepath.Join(dir, tempPrefix+"123"), []byte("partial"), 0644)
+
+	for name, b := range map[string]Backend{"local": local, "memory": NewMemoryBackend()} {
+		t.Run(name, func(t *testing.T) {
+			if n, err := b.Put("a.txt", strings.NewReader("alpha")); err != nil || n != 5 {
+				t.Fatalf("Put = %d, %v", n, err)
+			}
+
+			rc, obj, err := b.Get("a.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, _ := io.ReadAll(rc)
+			rc.Close()
+			if string(data) != "alpha" || obj.Size != 5 || obj.Name != "a.txt" {
+				t.Errorf("Get = %q, %+v", data, obj)
+			}
+
+			list, err := b.List()
+			if err != nil || len(list) != 1 || list[0].Name != "a.txt" {
+				t.Errorf("List = %+v, %v", list, err)
+			}
+
+			if err := b.Delete("a.txt"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := b.Stat("a.txt"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Stat after delete: %v, want ErrNotFound", err)
+			}
+			if err := b.Delete("a.txt"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("second Delete: %v, want ErrNotFound", err)
+			}
+			if _, _, err := b.Get("a.txt"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Get after delete: %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
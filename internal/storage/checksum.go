@@ -0,0 +1,212 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/checksum"
+)
+
+// Checksums are cached per file name and recomputed only when the file's
+// size or modification time changes. Uploads record the checksum of the
+// bytes as they were written; with scrubbing on, the cache is also kept on
+// disk (see scrub.go), so it survives restarts as the reference the
+// scrubber checks files against.
+//
+// A checksum is by the algorithm the upload asked for (X-Checksum-
+// Algorithm), written as package checksum writes it, so a file is always
+// rehashed the way it was first hashed. Files without one, and uploads not
+// asking, get the node's algorithm, which the central API sets to the
+// cluster's (see algorithmHandler).
+type checksumEntry struct {
+	size    int64
+	modTime time.Time
+	sum     string
+}
+
+type checksumCache struct {
+	sync.Mutex
+	m   map[string]checksumEntry
+	alg string // for files with no checksum recorded
+}
+
+// fileChecksum returns the checksum of the stored file.
+func (s *Server) fileChecksum(obj Object) (string, error) {
+	checksums := &s.checksums
+	checksums.Lock()
+	e, ok := checksums.m[obj.Name]
+	checksums.Unlock()
+	if ok && e.size == obj.Size && e.modTime.Equal(obj.ModTime) {
+		return e.sum, nil
+	}
+	sum, _, err := s.rehash(obj.Name)
+	return sum, err
+}
+
+// rehash reads the whole file and recomputes its checksum, ignoring (and
+// then replacing) the cached one.
+func (s *Server) rehash(name string) (string, Object, error) {
+	alg := s.checksums.algorithmFor(name)
+	rc, cur, err := s.backend.Get(name)
+	if err != nil {
+		return "", Object{}, err
+	}
+	defer rc.Close()
+	sum, err := checksum.Of(alg, rc)
+	if err != nil {
+		return "", Object{}, err
+	}
+
+	checksums := &s.checksums
+	checksums.Lock()
+	checksums.m[name] = checksumEntry{size: cur.Size, modTime: cur.ModTime, sum: sum}
+	checksums.Unlock()
+	return sum, cur, nil
+}
+
+// algorithm returns the node's algorithm, for files with no checksum
+// recorded.
+func (c *checksumCache) algorithm() string {
+	c.Lock()
+	defer c.Unlock()
+	if c.alg == "" {
+		return checksum.Default
+	}
+	return c.alg
+}
+
+func (c *checksumCache) setAlgorithm(alg string) {
+	c.Lock()
+	c.alg = alg
+	c.Unlock()
+}
+
+// algorithmFor returns the algorithm name is to be hashed with: the one
+// its recorded checksum is by, or the node's.
+func (c *checksumCache) algorithmFor(name string) string {
+	c.Lock()
+	e, ok := c.m[name]
+	c.Unlock()
+	if ok {
+		return checksum.Algorithm(e.sum)
+	}
+	return c.algorithm()
+}
+
+// cached returns the cached checksum of name, if any.
+func (c *checksumCache) cached(name string) string {
+	c.Lock()
+	defer c.Unlock()
+	return c.m[name].sum
+}
+
+// record stores the checksum of obj, computed as it was written.
+func (c *checksumCache) record(obj Object, sum string) {
+	c.Lock()
+	c.m[obj.Name] = checksumEntry{size: obj.Size, modTime: obj.ModTime, sum: sum}
+	c.Unlock()
+}
+
+// entry returns the checksum recorded for name and the size and
+// modification time it was recorded for.
+func (c *checksumCache) entry(name string) (checksumEntry, bool) {
+	c.Lock()
+	defer c.Unlock()
+	e, ok := c.m[name]
+	return e, ok
+}
+
+// savedChecksum is one line of the checksum index kept on disk.
+type savedChecksum struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Sum     string    `json:"sum,omitempty"`
+	SHA256  string    `json:"sha256,omitempty"` // written before there was a choice
+}
+
+// load reads the index written by save. A missing file is not an error.
+func (c *checksumCache) load(path string) error {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var saved map[string]savedChecksum
+	if err := json.Unmarshal(b, &saved); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	c.Lock()
+	defer c.Unlock()
+	for name, e := range saved {
+		sum := e.Sum
+		if sum == "" {
+			sum = e.SHA256
+		}
+		c.m[name] = checksumEntry{size: e.Size, modTime: e.ModTime, sum: sum}
+	}
+	return nil
+}
+
+// save writes the index to path, replacing it atomically.
+func (c *checksumCache) save(path string) error {
+	c.Lock()
+	saved := make(map[string]savedChecksum, len(c.m))
+	for name, e := range c.m {
+		saved[name] = savedChecksum{Size: e.size, ModTime: e.modTime, Sum: e.sum}
+	}
+	c.Unlock()
+	b, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (c *checksumCache) forget(name string) {
+	c.Lock()
+	delete(c.m, name)
+	c.Unlock()
+}
+
+// algorithmHandler shows or sets the node's checksum algorithm: GET or PUT
+// /api/v1/checksum, the PUT with {"algorithm": "blake3"}, from the central
+// API. Checksums already recorded keep their algorithm.
+func (s *Server) algorithmHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut {
+		if !s.checkToken(w, r) {
+			return
+		}
+		var req struct {
+			Algorithm string `json:"algorithm"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<10)).Decode(&req); err != nil {
+			apierr.Send(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !checksum.Supported(req.Algorithm) {
+			apierr.Send(w, "Unsupported checksum algorithm", http.StatusBadRequest)
+			return
+		}
+		if old := s.checksums.algorithm(); old != req.Algorithm {
+			s.checksums.setAlgorithm(req.Algorithm)
+			fmt.Println("Checksum algorithm changed:", old, "->", req.Algorithm)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Algorithm string   `json:"algorithm"`
+		Supported []string `json:"supported"`
+	}{s.checksums.algorithm(), checksum.Algorithms})
+}
@@ -0,0 +1,258 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/checksum"
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/listen"
+)
+
+// Config holds a storage node's settings.
+type Config struct {
+	Port   string
+	Region string
+
+	// Listen lists the addresses to serve on, like "0.0.0.0:9001",
+	// "[::]:9001" or "unix:/run/dsfs/sg.sock" (see AdvertiseSocket); empty
+	// listens on Port on every interface. AdminListen
+	// adds addresses that serve only what operators watch (probes, version,
+	// scrub progress, limits), so monitoring can stay on a private
+	// interface; the central API still reaches all of it on Listen.
+	Listen      []string
+	AdminListen []string
+
+	// Backend is "local" (files under StoragePath), "memory" or "s3".
+	Backend      string
+	StoragePath  string
+	IdentityPath string
+
+	// SigningKey is shared with the central API. When set, files are only
+	// served to URLs signed by the central API.
+	SigningKey []byte
+
+	// Self-registration with the central API. Disabled unless CentralURL is
+	// set.
+	CentralURL        string
+	AdvertiseURL      string
+	AdvertiseSocket   string // Unix socket a central API on this host dials instead
+	NodeName          string
+	RegistrationToken string
+	Zone              string // failure domain; "" = Region
+	Lat, Lon          float64
+	CapacityBytes     int64 // 0 = ask the backend
+	RegisterInterval  time.Duration
+
+	// MinFreeBytes is kept free on the backend: uploads that would eat
+	// into it are refused with 507.
+	MinFreeBytes int64
+
+	// Quota on what the node stores, in bytes and in files (0 = none).
+	// Uploads over it are refused with 507 "quota exceeded".
+	QuotaBytes int64
+	QuotaFiles int64
+
+	// Scrubbing re-reads every file once per ScrubInterval (0 = off) at
+	// up to ScrubRate bytes per second (0 = unthrottled), checking it
+	// against the checksum index kept at ChecksumPath.
+	ScrubInterval time.Duration
+	ScrubRate     int64
+	ChecksumPath  string
+
+	// ChecksumAlgorithm hashes files (see package checksum) until the
+	// central API sets the cluster's; "" is SHA-256.
+	ChecksumAlgorithm string
+
+	// FollowFeed makes the node tail the central API's change feed and
+	// pull new files from its peers itself (see FollowLoop), remembering
+	// its place in the feed at FeedCursorPath. It needs CentralURL.
+	FollowFeed     bool
+	FeedCursorPath string
+
+	// QuarantinePath keeps the quarantine list the central API last sent
+	// (see quarantineHandler) across restarts; "" keeps it in memory only.
+	QuarantinePath string
+
+	// PrivatePath keeps the list of private files the central API last sent
+	// (see private.go) across restarts; "" keeps it in memory only.
+	PrivatePath string
+
+	// TrashPath keeps the trash list the central API last sent (see
+	// trash.go) across restarts; "" keeps it in memory only.
+	TrashPath string
+
+	// LocksPath keeps the list of locked files the central API last sent
+	// (see objectlock.go) across restarts; "" keeps it in memory only.
+	LocksPath string
+
+	// Faults is for testing only; see FaultConfig.
+	Faults FaultConfig
+}
+
+// listenAddrs returns the addresses the node serves on.
+func (c Config) listenAddrs() []string {
+	if len(c.Listen) > 0 {
+		return c.Listen
+	}
+	return []string{":" + c.Port}
+}
+
+// Coordinates used when LAT/LON are not set, by region.
+var regionCoords = map[string][2]float64{
+	"singapore": {1.3521, 103.8198},
+	"new-york":  {40.7128, -74.0060},
+	"london":    {51.5074, -0.1278},
+}
+
+// DefaultConfig returns the settings for a node on port in region.
+func DefaultConfig(port, region string) Config {
+	coords := regionCoords[region]
+	return Config{
+		Port:             port,
+		Region:           region,
+		Backend:          "local",
+		StoragePath:      "files",
+		IdentityPath:     "node.json",
+		AdvertiseURL:     "http://localhost:" + port,
+		NodeName:         region,
+		Lat:              coords[0],
+		Lon:              coords[1],
+		RegisterInterval: 30 * time.Second,
+		ScrubInterval:    24 * time.Hour,
+		ScrubRate:        4 << 20,
+		ChecksumPath:     "checksums.json",
+		FeedCursorPath:   "feed-cursor.json",
+		QuarantinePath:   "quarantine.json",
+		PrivatePath:      "private.json",
+		TrashPath:        "trash.json",
+		LocksPath:        "locks.json",
+	}
+}
+
+// LoadConfig starts from DefaultConfig and applies environment overrides.
+func LoadConfig(port, region string) (Config, error) {
+	cfg := DefaultConfig(port, region)
+
+	cfg.Listen = listen.Split(os.Getenv("LISTEN"))
+	cfg.AdminListen = listen.Split(os.Getenv("ADMIN_LISTEN"))
+	for _, addr := range slices.Concat(cfg.Listen, cfg.AdminListen) {
+		if err := listen.Check(addr); err != nil {
+			return cfg, err
+		}
+	}
+
+	if kind := os.Getenv("BACKEND"); kind != "" {
+		cfg.Backend = kind
+	}
+	if p := os.Getenv("NODE_INFO_FILE"); p != "" {
+		cfg.IdentityPath = p
+	}
+	if key := os.Getenv("SIGNING_KEY"); key != "" {
+		cfg.SigningKey = []byte(key)
+	}
+
+	cfg.CentralURL = os.Getenv("CENTRAL_URL")
+	cfg.RegistrationToken = os.Getenv("REGISTRATION_TOKEN")
+	if u := os.Getenv("ADVERTISE_URL"); u != "" {
+		cfg.AdvertiseURL = u
+	}
+	cfg.AdvertiseSocket = os.Getenv("ADVERTISE_SOCKET")
+	if cfg.AdvertiseSocket != "" && !filepath.IsAbs(cfg.AdvertiseSocket) {
+		return cfg, fmt.Errorf("ADVERTISE_SOCKET %q must be an absolute path", cfg.AdvertiseSocket)
+	}
+	if name := os.Getenv("NODE_NAME"); name != "" {
+		cfg.NodeName = name
+	}
+	cfg.Zone = os.Getenv("ZONE")
+
+	var err error
+	if v := os.Getenv("LAT"); v != "" {
+		if cfg.Lat, err = strconv.ParseFloat(v, 64); err != nil {
+			return cfg, fmt.Errorf("invalid LAT %q", v)
+		}
+	}
+	if v := os.Getenv("LON"); v != "" {
+		if cfg.Lon, err = strconv.ParseFloat(v, 64); err != nil {
+			return cfg, fmt.Errorf("invalid LON %q", v)
+		}
+	}
+	if v := os.Getenv("CAPACITY_BYTES"); v != "" {
+		if cfg.CapacityBytes, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return cfg, fmt.Errorf("invalid CAPACITY_BYTES %q", v)
+		}
+	}
+	if p := os.Getenv("CHECKSUM_FILE"); p != "" {
+		cfg.ChecksumPath = p
+	}
+	if v := os.Getenv("CHECKSUM_ALGORITHM"); v != "" {
+		if !checksum.Supported(v) {
+			return cfg, fmt.Errorf("invalid CHECKSUM_ALGORITHM %q (want one of %s)", v, strings.Join(checksum.Algorithms, ", "))
+		}
+		cfg.ChecksumAlgorithm = v
+	}
+	if v := os.Getenv("SCRUB_INTERVAL"); v != "" {
+		if cfg.ScrubInterval, err = time.ParseDuration(v); err != nil || cfg.ScrubInterval < 0 {
+			return cfg, fmt.Errorf("invalid SCRUB_INTERVAL %q", v)
+		}
+	}
+	if v := os.Getenv("SCRUB_RATE"); v != "" {
+		if cfg.ScrubRate, err = strconv.ParseInt(v, 10, 64); err != nil || cfg.ScrubRate < 0 {
+			return cfg, fmt.Errorf("invalid SCRUB_RATE %q", v)
+		}
+	}
+	if p := os.Getenv("FEED_CURSOR_FILE"); p != "" {
+		cfg.FeedCursorPath = p
+	}
+	if p, ok := os.LookupEnv("QUARANTINE_FILE"); ok {
+		cfg.QuarantinePath = p
+	}
+	if p, ok := os.LookupEnv("PRIVATE_FILE"); ok {
+		cfg.PrivatePath = p
+	}
+	if p, ok := os.LookupEnv("TRASH_FILE"); ok {
+		cfg.TrashPath = p
+	}
+	if p, ok := os.LookupEnv("LOCKS_FILE"); ok {
+		cfg.LocksPath = p
+	}
+	if v := os.Getenv("FOLLOW_FEED"); v != "" {
+		if cfg.FollowFeed, err = strconv.ParseBool(v); err != nil {
+			return cfg, fmt.Errorf("invalid FOLLOW_FEED %q", v)
+		}
+		if cfg.FollowFeed && cfg.CentralURL == "" {
+			return cfg, fmt.Errorf("FOLLOW_FEED needs CENTRAL_URL")
+		}
+	}
+	if v := os.Getenv("MIN_FREE_BYTES"); v != "" {
+		if cfg.MinFreeBytes, err = strconv.ParseInt(v, 10, 64); err != nil || cfg.MinFreeBytes < 0 {
+			return cfg, fmt.Errorf("invalid MIN_FREE_BYTES %q", v)
+		}
+	}
+	for _, q := range []struct {
+		key string
+		dst *int64
+	}{
+		{"QUOTA_BYTES", &cfg.QuotaBytes},
+		{"QUOTA_FILES", &cfg.QuotaFiles},
+	} {
+		v := os.Getenv(q.key)
+		if v == "" {
+			continue
+		}
+		if *q.dst, err = strconv.ParseInt(v, 10, 64); err != nil || *q.dst < 0 {
+			return cfg, fmt.Errorf("invalid %s %q", q.key, v)
+		}
+	}
+	if v := os.Getenv("REGISTER_INTERVAL"); v != "" {
+		if cfg.RegisterInterval, err = time.ParseDuration(v); err != nil || cfg.RegisterInterval <= 0 {
+			return cfg, fmt.Errorf("invalid REGISTER_INTERVAL %q", v)
+		}
+	}
+	cfg.Faults, err = loadFaultConfig(os.Getenv)
+	return cfg, err
+}
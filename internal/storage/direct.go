@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+)
+
+// Direct uploads: the central API mints a signed URL for one file on this
+// node, so a large upload comes straight from the client instead of
+// through the central API. Each URL is good for one upload, to this node
+// only (its identity is signed), before it expires; once the file is
+// stored the node reports it to the central API, which copies it and
+// replicates it to the other nodes.
+
+// uploadScope starts what is signed for a direct upload URL, so a download
+// signature cannot be used to write.
+const uploadScope = "upload\n"
+
+// usedUploads remembers the nonces of direct upload URLs already used, up
+// to their expiry. A nonce is claimed for the length of the upload, so the
+// same URL cannot be used twice at once either.
+type usedUploads struct {
+	mu     sync.Mutex
+	nonces map[string]time.Time
+}
+
+// claim marks nonce used until expires; false if it already was.
+func (u *usedUploads) claim(nonce string, expires time.Time) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	now := time.Now()
+	for n, exp := range u.nonces {
+		if now.After(exp) {
+			delete(u.nonces, n)
+		}
+	}
+	if _, ok := u.nonces[nonce]; ok {
+		return false
+	}
+	if u.nonces == nil {
+		u.nonces = map[string]time.Time{}
+	}
+	u.nonces[nonce] = expires
+	return true
+}
+
+func (u *usedUploads) release(nonce string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	delete(u.nonces, nonce)
+}
+
+// directUploadReport tells the central API a direct upload is in.
+type directUploadReport struct {
+	ID       string `json:"id"`
+	NodeUUID string `json:"node_uuid"`
+	File     string `json:"file"`
+	Nonce    string `json:"nonce"`
+	Size     int64  `json:"size"`
+	Checksum string `json:"checksum"`
+}
+
+// directUploadHandler takes a file uploaded with a URL the central API
+// signed: POST /upload/direct?name=&nonce=&expires=&sig=, with the file
+// in a multipart "file" part as for /upload.
+func (s *Server) directUploadHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	key := s.cfg.SigningKey
+	if len(key) == 0 || s.cfg.CentralURL == "" {
+		apierr.Send(w, "Direct uploads need a signing key and a central API", http.StatusNotFound)
+		return
+	}
+	q := r.URL.Query()
+	name, nonce, expires := q.Get("name"), q.Get("nonce"), q.Get("expires")
+	if name == "" || nonce == "" || filepath.Base(name) != name {
+		apierr.Send(w, "name and nonce are required", http.StatusBadRequest)
+		return
+	}
+	if !validSignature(key, uploadScope+s.info.ID+"\n"+name+"\n"+nonce, expires, q.Get("sig")) {
+		apierr.Send(w, "Invalid or expired signature", http.StatusForbidden)
+		return
+	}
+	exp, _ := strconv.ParseInt(expires, 10, 64)
+	if !s.directUploads.claim(nonce, time.Unix(exp, 0)) {
+		apierr.Send(w, "Upload URL already used", http.StatusConflict)
+		return
+	}
+
+	if _, ok := s.receiveFile(w, r, name); !ok {
+		s.directUploads.release(nonce) // nothing stored: the URL may be retried
+		return
+	}
+	report := directUploadReport{ID: s.cfg.NodeName, NodeUUID: s.info.ID, File: name, Nonce: nonce}
+	if info, err := s.statFile(name); err == nil {
+		report.Size, report.Checksum = info.Size, info.Checksum
+	}
+	if err := s.postToCentral("/api/v1/uploads/direct/complete", report); err != nil {
+		// The file stays; the central API's garbage collector can adopt it.
+		fmt.Println("Direct upload", name, "not reported:", err)
+		apierr.Send(w, "Stored, but not reported to the central API: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	fmt.Println("Direct upload", name, "reported to", s.cfg.CentralURL)
+	w.Write([]byte("OK|" + name))
+}
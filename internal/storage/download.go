@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"path/filepath"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/checksum"
+)
+
+// Serve a stored file. http.ServeContent handles ranges and conditional
+// requests from the object's size and modification time. The backend's
+// reader is handed over as is: for the local backend that is the *os.File,
+// which the server copies to the connection with sendfile, so large
+// downloads never pass through a user-space buffer.
+func (s *Server) downloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		apierr.Send(w, "Use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	name := filepath.Base(r.URL.Path)
+	if name == "." || name == "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Better no answer than a wrong one: the central API falls back to
+	// another replica.
+	if s.isCorrupt(name) {
+		apierr.Send(w, "File is corrupt", http.StatusInternalServerError)
+		return
+	}
+
+	if s.isQuarantined(name) {
+		apierr.Send(w, "File is quarantined", http.StatusUnavailableForLegalReasons)
+		return
+	}
+
+	// In the trash: deleted as far as anyone downloading is concerned.
+	if s.trash.has(name) {
+		http.NotFound(w, r)
+		return
+	}
+
+	rc, obj, err := s.backend.Get(name)
+	if errors.Is(err, ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		fmt.Println("Read failed:", name, err)
+		apierr.Send(w, "Read error", http.StatusInternalServerError)
+		return
+	}
+	defer rc.Close()
+
+	// A content-derived ETag makes If-Range / If-None-Match agree across
+	// replicas of the same file, and the checksum and node ID let a HEAD
+	// check a replica. They come from the object that was opened, so they
+	// describe the bytes being sent even if the file is replaced meanwhile.
+	if sum, err := s.fileChecksum(obj); err == nil {
+		w.Header().Set("ETag", `"`+sum+`"`)
+		w.Header().Set("X-Checksum", sum)
+		if checksum.Algorithm(sum) == checksum.SHA256 {
+			w.Header().Set("X-Checksum-Sha256", sum)
+		}
+	}
+	w.Header().Set("X-Storage-Node", s.info.ID)
+	if t := mime.TypeByExtension(filepath.Ext(name)); t != "" {
+		w.Header().Set("Content-Type", t)
+	}
+	http.ServeContent(w, r, name, obj.ModTime, rc)
+}
@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+)
+
+// FaultConfig makes a node misbehave on purpose, to exercise the central
+// API's retry, failover and repair paths. The zero value injects nothing.
+// Never enable it in production.
+type FaultConfig struct {
+	ErrorRate float64       // fraction of requests answered with a 500
+	SlowRate  float64       // fraction of requests delayed by up to SlowDelay
+	SlowDelay time.Duration // upper bound of each injected delay
+	DropRate  float64       // fraction of responses cut off halfway through
+	DiskFull  bool          // every write fails with ENOSPC
+}
+
+func (f FaultConfig) enabled() bool {
+	return f.ErrorRate > 0 || f.SlowRate > 0 || f.DropRate > 0 || f.DiskFull
+}
+
+func (f FaultConfig) String() string {
+	return fmt.Sprintf("error_rate=%g slow_rate=%g slow_delay=%s drop_rate=%g disk_full=%t",
+		f.ErrorRate, f.SlowRate, f.SlowDelay, f.DropRate, f.DiskFull)
+}
+
+// loadFaultConfig reads the FAULT_* environment variables.
+func loadFaultConfig(getenv func(string) string) (FaultConfig, error) {
+	f := FaultConfig{SlowDelay: 2 * time.Second}
+	for _, v := range []struct {
+		key string
+		dst *float64
+	}{
+		{"FAULT_ERROR_RATE", &f.ErrorRate},
+		{"FAULT_SLOW_RATE", &f.SlowRate},
+		{"FAULT_DROP_RATE", &f.DropRate},
+	} {
+		s := getenv(v.key)
+		if s == "" {
+			continue
+		}
+		rate, err := strconv.ParseFloat(s, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return f, fmt.Errorf("invalid %s %q (want 0..1)", v.key, s)
+		}
+		*v.dst = rate
+	}
+	if s := getenv("FAULT_SLOW_DELAY"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			return f, fmt.Errorf("invalid FAULT_SLOW_DELAY %q", s)
+		}
+		f.SlowDelay = d
+	}
+	if s := getenv("FAULT_DISK_FULL"); s != "" {
+		full, err := strconv.ParseBool(s)
+		if err != nil {
+			return f, fmt.Errorf("invalid FAULT_DISK_FULL %q", s)
+		}
+		f.DiskFull = full
+	}
+	return f, nil
+}
+
+// injectFaults wraps the node's handler with the HTTP-level faults.
+func injectFaults(f FaultConfig, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if f.SlowRate > 0 && rand.Float64() < f.SlowRate {
+			select {
+			case <-time.After(rand.N(f.SlowDelay) + 1):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if f.ErrorRate > 0 && rand.Float64() < f.ErrorRate {
+			apierr.Send(w, "injected fault", http.StatusInternalServerError)
+			return
+		}
+		if f.DropRate > 0 && rand.Float64() < f.DropRate {
+			w = &droppingWriter{ResponseWriter: w}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// droppingWriter sends the first half of the body (per Content-Length, or
+// nothing if unknown) and then aborts the connection, so the client sees a
+// truncated response.
+type droppingWriter struct {
+	http.ResponseWriter
+	limit   int64
+	written int64
+	started bool
+}
+
+func (d *droppingWriter) WriteHeader(status int) {
+	if !d.started {
+		d.started = true
+		if n, err := strconv.ParseInt(d.Header().Get("Content-Length"), 10, 64); err == nil {
+			d.limit = n / 2
+		}
+	}
+	d.ResponseWriter.WriteHeader(status)
+}
+
+func (d *droppingWriter) Write(p []byte) (int, error) {
+	if !d.started {
+		d.WriteHeader(http.StatusOK)
+	}
+	if room := d.limit - d.written; int64(len(p)) > room {
+		if room > 0 {
+			d.ResponseWriter.Write(p[:room])
+		}
+		if f, ok := d.ResponseWriter.(http.Flusher); ok {
+			f.Flush()
+		}
+		panic(http.ErrAbortHandler)
+	}
+	n, err := d.ResponseWriter.Write(p)
+	d.written += int64(n)
+	return n, err
+}
+
+// fullDisk is a backend whose writes fail as if the disk were full.
+type fullDisk struct {
+	Backend
+}
+
+func (fullDisk) Put(name string, r io.Reader) (int64, error) {
+	return 0, fmt.Errorf("put %s: %w", name, syscall.ENOSPC)
+}
+
+func (d fullDisk) Usage() (total, free uint64, err error) {
+	if u, ok := d.Backend.(usageReporter); ok {
+		total, _, err = u.Usage()
+	}
+	return total, 0, err
+}
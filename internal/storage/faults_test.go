@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFaultInjection(t *testing.T) {
+	t.Run("errors", func(t *testing.T) {
+		cfg := DefaultConfig("9001", "singapore")
+		cfg.Faults.ErrorRate = 1
+		ts := newTestServer(t, cfg)
+		resp, _ := http.Get(ts.URL + "/info")
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusInternalServerError {
+			t.Errorf("status %d, want 500", resp.StatusCode)
+		}
+	})
+
+	t.Run("disk full", func(t *testing.T) {
+		cfg := DefaultConfig("9001", "singapore")
+		cfg.Faults.DiskFull = true
+		ts := newTestServer(t, cfg)
+		if resp := upload(t, ts.URL, "big.bin", "data"); resp.StatusCode != http.StatusInsufficientStorage {
+			t.Errorf("upload on a full disk: status %d, want 507", resp.StatusCode)
+		}
+	})
+
+	t.Run("dropped bytes", func(t *testing.T) {
+		backend := NewMemoryBackend()
+		backend.Put("f.txt", strings.NewReader("0123456789"))
+		cfg := DefaultConfig("9001", "singapore")
+		cfg.Faults.DropRate = 1
+		dropping := newTestServerOn(t, cfg, backend)
+		resp, err := http.Get(dropping.URL + "/files/f.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || err == nil || string(body) != "01234" {
+			t.Errorf("status %d body %q err %v, want the first half then an error", resp.StatusCode, body, err)
+		}
+	})
+
+	t.Run("slow", func(t *testing.T) {
+		cfg := DefaultConfig("9001", "singapore")
+		cfg.Faults.SlowRate = 1
+		cfg.Faults.SlowDelay = 50 * time.Millisecond
+		ts := newTestServer(t, cfg)
+		client := &http.Client{Timeout: time.Millisecond}
+		if resp, err := client.Get(ts.URL + "/info"); err == nil {
+			resp.Body.Close()
+			t.Error("request finished within 1ms, want it delayed")
+		}
+	})
+}
+
+func TestLoadFaultConfig(t *testing.T) {
+	env := func(m map[string]string) func(string) string {
+		return func(k string) string { return m[k] }
+	}
+
+	f, err := loadFaultConfig(env(nil))
+	if err != nil || f.enabled() {
+		t.Errorf("empty env: %+v, %v", f, err)
+	}
+
+	f, err = loadFaultConfig(env(map[string]string{"FAULT_ERROR_RATE": "0.25", "FAULT_SLOW_DELAY": "300ms", "FAULT_DISK_FULL": "true"}))
+	if err != nil || f.ErrorRate != 0.25 || f.SlowDelay != 300*time.Millisecond || !f.DiskFull {
+		t.Errorf("got %+v, %v", f, err)
+	}
+
+	for _, bad := range []map[string]string{
+		{"FAULT_ERROR_RATE": "2"},
+		{"FAULT_DROP_RATE": "lots"},
+		{"FAULT_SLOW_DELAY": "-1s"},
+		{"FAULT_DISK_FULL": "maybe"},
+	} {
+		if _, err := loadFaultConfig(env(bad)); err == nil {
+			t.Errorf("%v: no error", bad)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+)
+
+// FileInfo describes a stored file in listings.
+type FileInfo struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mod_time"`
+	Checksum string    `json:"checksum"`
+	MimeType string    `json:"mime_type"`
+
+	// Set in listings asked for ?since=: the file's latest change, and
+	// whether that was its deletion (then only Name is set as well).
+	Seq     int64 `json:"seq,omitempty"`
+	Deleted bool  `json:"deleted,omitempty"`
+}
+
+func (s *Server) statFile(name string) (FileInfo, error) {
+	obj, err := s.backend.Stat(name)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	return s.describe(obj)
+}
+
+// describe fills in the checksum and type for a stored object.
+func (s *Server) describe(obj Object) (FileInfo, error) {
+	sum, err := s.fileChecksum(obj)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	return FileInfo{
+		Name:     obj.Name,
+		Size:     obj.Size,
+		ModTime:  obj.ModTime.UTC(),
+		Checksum: sum,
+		MimeType: s.detectMimeType(obj.Name),
+	}, nil
+}
+
+// detectMimeType goes by extension first and sniffs the content otherwise.
+func (s *Server) detectMimeType(name string) string {
+	if t := mime.TypeByExtension(filepath.Ext(name)); t != "" {
+		return t
+	}
+	rc, _, err := s.backend.Get(name)
+	if err != nil {
+		return "application/octet-stream"
+	}
+	defer rc.Close()
+	buf := make([]byte, 512)
+	n, _ := io.ReadFull(rc, buf)
+	return http.DetectContentType(buf[:n])
+}
+
+// Verify a file: read it in full and recompute its checksum, so content
+// that changed on disk without its size or modification time changing
+// (bit rot, a tampered file) is caught instead of served from the cache.
+func (s *Server) verifyHandler(w http.ResponseWriter, r *http.Request) {
+	name := filepath.Base(r.PathValue("name"))
+
+	type verifyResponse struct {
+		Name     string `json:"name"`
+		Node     string `json:"node"`
+		Size     int64  `json:"size"`
+		Checksum string `json:"checksum"`
+		// Previously cached checksum, if it differs from the recomputed one.
+		Stale string `json:"stale_checksum,omitempty"`
+	}
+
+	cached := s.checksums.cached(name)
+	sum, obj, err := s.rehash(name)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		http.NotFound(w, r)
+		return
+	case err != nil:
+		apierr.Send(w, "Verify failed", http.StatusInternalServerError)
+		return
+	}
+	resp := verifyResponse{Name: name, Node: s.info.ID, Size: obj.Size, Checksum: sum}
+	if cached != "" && cached != sum {
+		fmt.Println("Checksum of", name, "changed on disk:", cached, "->", sum)
+		resp.Stale = cached
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Stat a single file as JSON
+func (s *Server) statHandler(w http.ResponseWriter, r *http.Request) {
+	name := filepath.Base(r.PathValue("name"))
+
+	type statResponse struct {
+		FileInfo
+		Exists bool   `json:"exists"`
+		Node   string `json:"node"`
+	}
+	resp := statResponse{FileInfo: FileInfo{Name: name}, Node: s.info.ID}
+
+	status := http.StatusOK
+	info, err := s.statFile(name)
+	switch {
+	case err == nil:
+		resp.FileInfo = info
+		resp.Exists = true
+	case errors.Is(err, ErrNotFound):
+		status = http.StatusNotFound
+	default:
+		apierr.Send(w, "Stat failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,373 @@
+package storage
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/checksum"
+)
+
+// Log-based replication. With Config.FollowFeed set, the node tails the
+// central API's change feed (GET /api/v1/changes) and applies every change
+// itself: deletions locally, uploads by copying the file from a peer node
+// that has it, with the central API's copy as the last resort. Copies are
+// checked against the checksum in the feed before they replace anything.
+//
+// The position in the feed is kept at Config.FeedCursorPath, so a node that
+// was down picks up where it stopped and catches up on everything it missed
+// without anyone running a repair. A following node ends up with a copy of
+// every file; files the central API already pushed to it are skipped.
+
+// feedEvent mirrors an entry of the central change feed.
+type feedEvent struct {
+	ID       int64  `json:"id"`
+	Type     string `json:"type"`
+	Name     string `json:"name"`
+	Size     int64  `json:"size"`
+	Checksum string `json:"checksum"`
+}
+
+// How long a feed request waits for new events, and the pause after a
+// failure.
+const (
+	feedWait    = 30 * time.Second
+	feedBackoff = 5 * time.Second
+)
+
+// errNoSource means no peer and not the central API had the file as the
+// feed described it; a later event will have replaced or deleted it.
+var errNoSource = errors.New("no source has this version of the file")
+
+// FollowLoop applies the central change feed until stop is closed.
+func (s *Server) FollowLoop(stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	next := loadFeedCursor(s.cfg.FeedCursorPath)
+	fmt.Println("Following the change feed of", s.cfg.CentralURL, "from event", next)
+	for ctx.Err() == nil {
+		applied, err := s.followOnce(ctx, next)
+		if applied != next {
+			next = applied
+			if err := saveFeedCursor(s.cfg.FeedCursorPath, next); err != nil {
+				fmt.Println("Cannot save feed cursor:", err)
+			}
+		}
+		if err != nil && ctx.Err() == nil {
+			fmt.Println("Change feed:", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(feedBackoff):
+			}
+		}
+	}
+}
+
+// followOnce fetches the events from next on and applies them in order. It
+// returns the ID of the first event not yet applied.
+func (s *Server) followOnce(ctx context.Context, next int64) (int64, error) {
+	var page struct {
+		Events []feedEvent `json:"events"`
+		LastID int64       `json:"last_id"`
+	}
+	base := strings.TrimSuffix(s.cfg.CentralURL, "/")
+	err := s.getCentralJSON(ctx, base+"/api/v1/changes?from="+strconv.FormatInt(next, 10)+"&wait="+feedWait.String(), &page)
+	var gone *feedStatusError
+	if errors.As(err, &gone) && gone.status == http.StatusGone {
+		// The central API no longer has the events we need; fsck has to
+		// close the gap. Carry on from the newest event.
+		last, err := s.feedLastID(ctx, base)
+		if err != nil {
+			return next, err
+		}
+		fmt.Println("Change feed no longer has event", next, "- skipping to", last)
+		return last, nil
+	}
+	if err != nil || len(page.Events) == 0 {
+		return next, err
+	}
+
+	// Only the last change to each name in the batch matters.
+	last := map[string]int64{}
+	for _, ev := range page.Events {
+		last[ev.Name] = ev.ID
+	}
+	peers, err := s.peers(ctx, base)
+	if err != nil {
+		return next, err
+	}
+	for _, ev := range page.Events {
+		if last[ev.Name] == ev.ID {
+			if err := s.applyChange(ctx, ev, peers); err != nil {
+				return next, fmt.Errorf("event %d (%s %s): %w", ev.ID, ev.Type, ev.Name, err)
+			}
+		}
+		next = ev.ID + 1
+	}
+	return next, nil
+}
+
+// feedLastID returns the ID after the latest event in the feed.
+func (s *Server) feedLastID(ctx context.Context, base string) (int64, error) {
+	var page struct {
+		LastID int64 `json:"last_id"`
+	}
+	// Asking from past the end always succeeds and returns no events.
+	u := base + "/api/v1/changes?from=" + strconv.FormatInt(math.MaxInt64, 10)
+	if err := s.getCentralJSON(ctx, u, &page); err != nil {
+		return 0, err
+	}
+	return page.LastID + 1, nil
+}
+
+// applyChange makes one change from the feed on this node.
+func (s *Server) applyChange(ctx context.Context, ev feedEvent, peers []string) error {
+	name := safeName(ev.Name)
+	if name == "" {
+		return nil
+	}
+	switch ev.Type {
+	case "delete":
+		if s.immutable(name) {
+			fmt.Println("Not deleting (from feed), locked:", name)
+			return nil
+		}
+		err := s.backend.Delete(name)
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		s.checksums.forget(name)
+		s.clearCorrupt(name)
+		s.changes.Remove(name)
+		fmt.Println("Deleted (from feed):", name)
+		return nil
+	case "upload":
+		if obj, err := s.backend.Stat(name); err == nil {
+			if sum, err := s.fileChecksum(obj); err == nil && sum == ev.Checksum {
+				return nil // pushed here already
+			}
+		}
+		if s.immutable(name) {
+			fmt.Println("Not replacing (from feed), locked:", name)
+			return nil
+		}
+		err := s.pullFile(ctx, name, ev, peers)
+		if errors.Is(err, errNoSource) {
+			fmt.Println("Feed:", name, "is gone or was replaced since event", ev.ID)
+			return nil
+		}
+		if isNoSpace(err) {
+			fmt.Println("Feed: no room for", name, ":", err)
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// pullFile copies name from the first peer whose copy matches ev, or else
+// from the central API.
+func (s *Server) pullFile(ctx context.Context, name string, ev feedEvent, peers []string) error {
+	sources := make([]string, 0, len(peers)+1)
+	for _, p := range peers {
+		sources = append(sources, p+"/files/"+url.PathEscape(name)+s.signQuery(name))
+	}
+	sources = append(sources, strings.TrimSuffix(s.cfg.CentralURL, "/")+"/files/"+url.PathEscape(name))
+	_, err := s.copyIn(ctx, name, ev.Size, ev.Checksum, sources, nil, "from feed")
+	return err
+}
+
+// copyIn copies name from the first of sources whose copy has checksum
+// want, into a temp file first so a bad copy never replaces the one here.
+// It returns the source copied from. wrap, if not nil, wraps the reads of
+// each download. how says where the copy came from, for the log.
+func (s *Server) copyIn(ctx context.Context, name string, size int64, want string, sources []string,
+	wrap func(io.Reader) io.Reader, how string) (string, error) {
+	release, _, err := s.reserveSpace(name, size)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	tmp, err := os.CreateTemp("", ".copy-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	var lastErr error
+	for _, src := range sources {
+		sum, err := fetchInto(ctx, src, tmp, checksum.Algorithm(want), wrap)
+		if err != nil {
+			var se *feedStatusError
+			if !errors.As(err, &se) || se.status != http.StatusNotFound {
+				lastErr = err
+			}
+			continue
+		}
+		if sum != want {
+			continue
+		}
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+		if _, err := s.backend.Put(name, tmp); err != nil {
+			return "", err
+		}
+		if obj, err := s.backend.Stat(name); err == nil {
+			s.checksums.record(obj, sum)
+		}
+		s.clearCorrupt(name)
+		s.changes.Record(name)
+		from := strings.SplitN(src, "/files/", 2)[0]
+		fmt.Printf("Replicated (%s): %s via %s\n", how, name, from)
+		return from, nil
+	}
+	if lastErr != nil {
+		return "", lastErr
+	}
+	return "", errNoSource
+}
+
+// fetchInto downloads src over f's contents, reading through wrap if it
+// is not nil, and returns its checksum by alg.
+func fetchInto(ctx context.Context, src string, f *os.File, alg string, wrap func(io.Reader) io.Reader) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", &feedStatusError{status: resp.StatusCode}
+	}
+	if err := f.Truncate(0); err != nil {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	h, err := checksum.New(alg)
+	if err != nil {
+		return "", err
+	}
+	var body io.Reader = resp.Body
+	if wrap != nil {
+		body = wrap(body)
+	}
+	if _, err := io.Copy(io.MultiWriter(f, h), body); err != nil {
+		return "", err
+	}
+	return checksum.Format(alg, h), nil
+}
+
+// peers returns the URLs of the other nodes the central API knows.
+func (s *Server) peers(ctx context.Context, base string) ([]string, error) {
+	var nodes []struct {
+		URL      string `json:"url"`
+		NodeUUID string `json:"node_uuid"`
+	}
+	if err := s.getCentralJSON(ctx, base+"/api/v1/nodes", &nodes); err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, n := range nodes {
+		if n.NodeUUID != s.info.ID && strings.TrimSuffix(n.URL, "/") != strings.TrimSuffix(s.cfg.AdvertiseURL, "/") {
+			out = append(out, strings.TrimSuffix(n.URL, "/"))
+		}
+	}
+	return out, nil
+}
+
+// signQuery signs a peer download the way the central API does, with the
+// key the nodes share with it.
+func (s *Server) signQuery(name string) string {
+	if len(s.cfg.SigningKey) == 0 {
+		return ""
+	}
+	expires := strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10)
+	message := name
+	if s.private.has(name) {
+		message = privateScope + name
+	}
+	mac := hex.EncodeToString(hmacSHA256(s.cfg.SigningKey, message+"\n"+expires))
+	return "?expires=" + expires + "&sig=" + mac
+}
+
+type feedStatusError struct {
+	status int
+}
+
+func (e *feedStatusError) Error() string {
+	return fmt.Sprintf("status %d", e.status)
+}
+
+// getCentralJSON decodes a GET from the central API into v.
+func (s *Server) getCentralJSON(ctx context.Context, u string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	if s.cfg.RegistrationToken != "" {
+		req.Header.Set("Authorization", "Bearer "+s.cfg.RegistrationToken)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return &feedStatusError{status: resp.StatusCode}
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// safeName returns the flat file name the feed refers to, or "" if it is
+// not one.
+func safeName(name string) string {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return ""
+	}
+	return name
+}
+
+func loadFeedCursor(path string) int64 {
+	var c struct {
+		Next int64 `json:"next"`
+	}
+	if b, err := os.ReadFile(path); err == nil && json.Unmarshal(b, &c) == nil && c.Next > 0 {
+		return c.Next
+	}
+	return 1
+}
+
+func saveFeedCursor(path string, next int64) error {
+	b, _ := json.Marshal(map[string]int64{"next": next})
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
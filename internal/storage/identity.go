@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/buildinfo"
+)
+
+// NodeInfo is this node's persistent identity. The ID is generated once and
+// survives restarts, so the central API can tell a moved node from a new one.
+type NodeInfo struct {
+	ID        string    `json:"id"`
+	Region    string    `json:"region"`
+	Version   string    `json:"version"`
+	Commit    string    `json:"commit,omitempty"`
+	BuildTime string    `json:"build_time,omitempty"`
+	Created   time.Time `json:"created"`
+	Started   time.Time `json:"started"`
+
+	// Filled in at runtime so discovered nodes can be placed on the map.
+	Name string  `json:"name,omitempty"`
+	Lat  float64 `json:"lat,omitempty"`
+	Lon  float64 `json:"lon,omitempty"`
+
+	// Quota is set in /info responses when the node has a quota.
+	Quota *QuotaStatus `json:"quota,omitempty"`
+
+	// Quarantine is the digest of the node's quarantine list, in /info.
+	Quarantine string `json:"quarantine,omitempty"`
+
+	// Private is the digest of the node's list of private files, in /info.
+	Private string `json:"private,omitempty"`
+
+	// Trash is the digest of the node's trash list, in /info.
+	Trash string `json:"trash,omitempty"`
+
+	// Locked is the digest of the node's list of locked files, in /info.
+	Locked string `json:"locked,omitempty"`
+
+	// ChecksumAlgorithm is what the node hashes files with, unless an
+	// upload asks otherwise, and ChecksumAlgorithms what it can hash them
+	// with, in /info.
+	ChecksumAlgorithm  string   `json:"checksum_algorithm,omitempty"`
+	ChecksumAlgorithms []string `json:"checksum_algorithms,omitempty"`
+}
+
+// loadOrCreateIdentity reads the identity file, generating and saving a new
+// ID on first boot.
+func loadOrCreateIdentity(path, region string) (NodeInfo, error) {
+	var info NodeInfo
+
+	raw, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(raw, &info); err != nil {
+			return info, fmt.Errorf("parse %s: %w", path, err)
+		}
+		if info.ID == "" {
+			return info, fmt.Errorf("%s has no id", path)
+		}
+	case errors.Is(err, os.ErrNotExist):
+		id, err := newUUID()
+		if err != nil {
+			return info, err
+		}
+		info = NodeInfo{ID: id, Created: time.Now().UTC()}
+		raw, _ := json.MarshalIndent(info, "", "  ")
+		if err := os.WriteFile(path, raw, 0644); err != nil {
+			return info, fmt.Errorf("write %s: %w", path, err)
+		}
+		fmt.Println("Generated node ID:", id)
+	default:
+		return info, err
+	}
+
+	info.Region = region
+	build := buildinfo.Get()
+	info.Version, info.Commit, info.BuildTime = build.Version, build.Commit, build.BuildTime
+	info.Started = time.Now().UTC()
+	return info, nil
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/listen"
+)
+
+// Main runs a storage node: dsfs storage [-port 9001] [-region singapore]
+// [-listen addr,...].
+func Main(args []string) {
+	Run("9001", "singapore", args)
+}
+
+// Run starts a storage node with the given default port and region. The
+// -port and -region flags override them, as do the PORT and REGION
+// environment variables; -listen overrides LISTEN.
+func Run(port, region string, args []string) {
+	flags := flag.NewFlagSet("storage", flag.ExitOnError)
+	portFlag := flags.String("port", envOr("PORT", port), "port to listen on")
+	regionFlag := flags.String("region", envOr("REGION", region), "region this node is deployed in")
+	listenFlag := flags.String("listen", "", "addresses to listen on, comma-separated, e.g. [::]:9001 (default $LISTEN, then :port)")
+	flags.Parse(args)
+	if *listenFlag != "" {
+		os.Setenv("LISTEN", *listenFlag)
+	}
+
+	cfg, err := LoadConfig(*portFlag, *regionFlag)
+	if err != nil {
+		log.Fatalf("Config error: %v", err)
+	}
+	backend, err := NewBackend(cfg)
+	if err != nil {
+		log.Fatalf("Failed to open storage backend: %v", err)
+	}
+	s, err := NewServer(cfg, backend)
+	if err != nil {
+		log.Fatalf("Failed to load node identity: %v", err)
+	}
+
+	// Open every listener before serving any, so a bad or busy address
+	// stops the startup.
+	lns, err := listen.Open(cfg.listenAddrs())
+	if err != nil {
+		log.Fatalf("Cannot listen: %v", err)
+	}
+	srv := &http.Server{Handler: s.Handler()}
+	var adminSrv *http.Server
+	if len(cfg.AdminListen) > 0 {
+		adminLns, err := listen.Open(cfg.AdminListen)
+		if err != nil {
+			log.Fatalf("Cannot listen for the admin endpoints: %v", err)
+		}
+		adminSrv = &http.Server{Handler: s.AdminHandler()}
+		fmt.Println("Admin endpoints listening on", listen.Addrs(adminLns))
+		go func() {
+			if err := listen.Serve(adminSrv, adminLns); !errors.Is(err, http.ErrServerClosed) {
+				log.Fatal(err)
+			}
+		}()
+	}
+	stop := make(chan struct{})
+	if cfg.CentralURL != "" {
+		go s.RegistrationLoop(stop)
+	}
+	if cfg.FollowFeed {
+		go s.FollowLoop(stop)
+	}
+	scrubbed := make(chan struct{})
+	if cfg.ScrubInterval > 0 {
+		go func() {
+			s.ScrubLoop(stop)
+			close(scrubbed)
+		}()
+	} else {
+		close(scrubbed)
+	}
+
+	// Deregister and drain in-flight requests on shutdown.
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		s.draining.Store(true)
+		close(stop)
+		if cfg.CentralURL != "" {
+			s.Deregister()
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if adminSrv != nil {
+			adminSrv.Shutdown(ctx)
+		}
+		srv.Shutdown(ctx)
+	}()
+
+	info := s.Info()
+	fmt.Printf("Storage server %s (%s) listening on %s\n", info.ID, info.Region, listen.Addrs(lns))
+	if err := listen.Serve(srv, lns); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-scrubbed // the checksum index is saved on the way out
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/merkle"
+)
+
+// The node's inventory as a Merkle tree (see package merkle), so the
+// central API can compare it with what the node should hold and list only
+// the subtrees that differ instead of every file.
+
+// inventory builds the tree over the stored files and their checksums.
+func (s *Server) inventory() (*merkle.Tree, error) {
+	objects, err := s.backend.List()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]merkle.Entry, 0, len(objects))
+	for _, obj := range objects {
+		sum, err := s.fileChecksum(obj)
+		if err != nil {
+			continue // removed since the listing
+		}
+		entries = append(entries, merkle.Entry{Name: obj.Name, Checksum: sum})
+	}
+	return merkle.Build(entries), nil
+}
+
+func merklePrefix(w http.ResponseWriter, r *http.Request) (string, bool) {
+	prefix := r.URL.Query().Get("prefix")
+	if !merkle.ValidPrefix(prefix) {
+		apierr.Send(w, "prefix must be up to 64 lowercase hex digits", http.StatusBadRequest)
+		return "", false
+	}
+	return prefix, true
+}
+
+// Summarise the subtree under ?prefix= and its children as JSON
+func (s *Server) merkleHandler(w http.ResponseWriter, r *http.Request) {
+	prefix, ok := merklePrefix(w, r)
+	if !ok {
+		return
+	}
+	tree, err := s.inventory()
+	if err != nil {
+		fmt.Println("List failed:", err)
+		apierr.Send(w, "Cannot list files", http.StatusInternalServerError)
+		return
+	}
+
+	type merkleResponse struct {
+		merkle.Summary
+		Children []merkle.Summary `json:"children"`
+	}
+	resp := merkleResponse{Summary: tree.Summary(prefix), Children: tree.Children(prefix)}
+	if resp.Children == nil {
+		resp.Children = []merkle.Summary{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// List the files under ?prefix= as JSON, like /files
+func (s *Server) merkleFilesHandler(w http.ResponseWriter, r *http.Request) {
+	prefix, ok := merklePrefix(w, r)
+	if !ok {
+		return
+	}
+	tree, err := s.inventory()
+	if err != nil {
+		fmt.Println("List failed:", err)
+		apierr.Send(w, "Cannot list files", http.StatusInternalServerError)
+		return
+	}
+
+	list := []FileInfo{}
+	for _, name := range tree.Names(prefix) {
+		info, err := s.statFile(name)
+		if err != nil {
+			continue
+		}
+		list = append(list, info)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
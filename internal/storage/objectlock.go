@@ -0,0 +1,27 @@
+package storage
+
+import "net/http"
+
+// The central API keeps the cluster's object locks: files under a
+// retention date or a legal hold, which nothing may delete or overwrite
+// until the lock is over. It sends the node the names locked now, and the
+// node refuses to delete them or to replace a replica it holds, from the
+// central API or the feed. A replica it lacks, or one the scrubber found
+// corrupt, may still be written: that repairs the file rather than
+// changing it.
+
+// locksHandler serves the node's list of locked files, and replaces it on
+// a PUT of a JSON array of names, authenticated like registration.
+func (s *Server) locksHandler(w http.ResponseWriter, r *http.Request) {
+	s.nameListHandler(w, r, &s.locked, s.cfg.LocksPath, "Locked")
+}
+
+// immutable reports whether name is locked and its replica here may not
+// be changed.
+func (s *Server) immutable(name string) bool {
+	if !s.locked.has(name) || s.isCorrupt(name) {
+		return false
+	}
+	_, err := s.backend.Stat(name)
+	return err == nil
+}
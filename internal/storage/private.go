@@ -0,0 +1,15 @@
+package storage
+
+import "net/http"
+
+// The central API also keeps the list of private files: those only its
+// signed-in users with access may read. A node serves them only to URLs
+// signed for their private scope (see requireSignature), which the central
+// API hands out to those users alone; with no signing key it does not
+// serve them at all.
+
+// privateHandler serves the node's list of private files, and replaces it
+// on a PUT of a JSON array of names, authenticated like registration.
+func (s *Server) privateHandler(w http.ResponseWriter, r *http.Request) {
+	s.nameListHandler(w, r, &s.private, s.cfg.PrivatePath, "Private")
+}
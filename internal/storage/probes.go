@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// /healthz answers as long as the process can serve HTTP at all; failing
+// it means "restart me". /readyz also checks that the backend answers and
+// that the node is not shutting down; failing it means "send traffic
+// elsewhere for now".
+
+func (s *Server) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte("ok\n"))
+}
+
+func (s *Server) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{"backend": "ok", "lifecycle": "ok"}
+	ready := true
+	// Any answer from the backend will do, "no such file" included.
+	if _, err := s.backend.Stat(".readyz"); err != nil && !errors.Is(err, ErrNotFound) {
+		checks["backend"] = err.Error()
+		ready = false
+	}
+	if s.draining.Load() {
+		checks["lifecycle"] = "shutting down"
+		ready = false
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	status := "ready"
+	if !ready {
+		status = "not ready"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(struct {
+		Status string            `json:"status"`
+		Checks map[string]string `json:"checks"`
+	}{status, checks})
+}
@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+)
+
+// The central API keeps the cluster's quarantine list: files that were
+// flagged (a failed scan, abuse reports) and must not be served until an
+// admin releases or purges them. It hands every node the whole list, and a
+// node refuses downloads of the names on it with 451, so a client with a
+// signed URL for one replica cannot get the file from there either. The
+// files stay on disk: a release has nothing to restore.
+
+type quarantineState struct {
+	mu    sync.Mutex
+	names map[string]bool
+}
+
+// load reads the list saved at path; a missing file is an empty list.
+func (q *quarantineState) load(path string) error {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var names []string
+	if err := json.Unmarshal(b, &names); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	q.set(names)
+	return nil
+}
+
+// save writes the list to path, replacing it atomically.
+func (q *quarantineState) save(path string) error {
+	b, _ := json.Marshal(q.list())
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (q *quarantineState) set(names []string) {
+	m := make(map[string]bool, len(names))
+	for _, name := range names {
+		m[name] = true
+	}
+	q.mu.Lock()
+	q.names = m
+	q.mu.Unlock()
+}
+
+func (q *quarantineState) has(name string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.names[name]
+}
+
+// list returns the quarantined names, sorted.
+func (q *quarantineState) list() []string {
+	q.mu.Lock()
+	names := make([]string, 0, len(q.names))
+	for name := range q.names {
+		names = append(names, name)
+	}
+	q.mu.Unlock()
+	sort.Strings(names)
+	return names
+}
+
+// quarantineDigest identifies a quarantine list, so the central API can
+// tell from a node's /info whether the node has the list it was given.
+// The empty list has the empty digest.
+func quarantineDigest(names []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	sorted := append([]string(nil), names...)
+	sort.Strings(sorted)
+	sum := sha256.Sum256([]byte(strings.Join(sorted, "\n")))
+	return hex.EncodeToString(sum[:8])
+}
+
+func (s *Server) isQuarantined(name string) bool {
+	return s.quarantine.has(name)
+}
+
+// quarantineHandler serves the node's quarantine list, and replaces it on
+// a PUT of a JSON array of names, authenticated like registration.
+func (s *Server) quarantineHandler(w http.ResponseWriter, r *http.Request) {
+	s.nameListHandler(w, r, &s.quarantine, s.cfg.QuarantinePath, "Quarantine")
+}
+
+// nameListHandler serves a list of names the central API keeps on the
+// node, and replaces it on a PUT, saving it at path.
+func (s *Server) nameListHandler(w http.ResponseWriter, r *http.Request, list *quarantineState, path, what string) {
+	if r.Method == http.MethodPut {
+		if !s.checkToken(w, r) {
+			return
+		}
+		var names []string
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<20)).Decode(&names); err != nil {
+			apierr.Send(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		old := quarantineDigest(list.list())
+		list.set(names)
+		if path != "" {
+			if err := list.save(path); err != nil {
+				fmt.Println("Saving", strings.ToLower(what), "list failed:", err)
+			}
+		}
+		if quarantineDigest(names) != old {
+			fmt.Printf("%s list changed: %d file(s)\n", what, len(names))
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list.list())
+}
@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+)
+
+// Self-registration with the central API, enabled by Config.CentralURL.
+
+type registration struct {
+	ID            string  `json:"id"`
+	Label         string  `json:"label"`
+	URL           string  `json:"url"`
+	Lat           float64 `json:"lat"`
+	Lon           float64 `json:"lon"`
+	Region        string  `json:"region"`
+	Zone          string  `json:"zone,omitempty"`
+	NodeUUID      string  `json:"node_uuid"`
+	Version       string  `json:"version"`
+	Commit        string  `json:"commit,omitempty"`
+	BuildTime     string  `json:"build_time,omitempty"`
+	CapacityBytes int64   `json:"capacity_bytes"`
+	Socket        string  `json:"socket,omitempty"`
+}
+
+func (s *Server) currentRegistration() registration {
+	capacity := s.cfg.CapacityBytes
+	if u, ok := s.backend.(usageReporter); ok && capacity == 0 {
+		if total, _, err := u.Usage(); err == nil {
+			capacity = int64(total)
+		}
+	}
+	return registration{
+		ID:            s.cfg.NodeName,
+		Label:         s.cfg.NodeName,
+		URL:           s.cfg.AdvertiseURL,
+		Lat:           s.cfg.Lat,
+		Lon:           s.cfg.Lon,
+		Region:        s.info.Region,
+		Zone:          s.cfg.Zone,
+		NodeUUID:      s.info.ID,
+		Version:       s.info.Version,
+		Commit:        s.info.Commit,
+		BuildTime:     s.info.BuildTime,
+		CapacityBytes: capacity,
+		Socket:        s.cfg.AdvertiseSocket,
+	}
+}
+
+// checkToken reports whether r bears the registration token, answering
+// 401 if it does not. It guards the calls only the central API makes,
+// which change the node or have it call out; with no token configured
+// there is no telling the central API from anyone else, so they are all
+// refused.
+func (s *Server) checkToken(w http.ResponseWriter, r *http.Request) bool {
+	tok := s.cfg.RegistrationToken
+	if tok == "" {
+		apierr.Send(w, "No registration token is configured", http.StatusUnauthorized)
+		return false
+	}
+	got, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(got), []byte(tok)) != 1 {
+		apierr.Send(w, "Invalid registration token", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+func (s *Server) postToCentral(path string, body interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", strings.TrimSuffix(s.cfg.CentralURL, "/")+path, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.cfg.RegistrationToken != "" {
+		req.Header.Set("Authorization", "Bearer "+s.cfg.RegistrationToken)
+	}
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		var msg bytes.Buffer
+		msg.ReadFrom(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(msg.String()))
+	}
+	return nil
+}
+
+// RegistrationLoop registers on boot and then re-registers periodically so
+// a restarted central API picks the node up again.
+func (s *Server) RegistrationLoop(stop <-chan struct{}) {
+	registered := false
+	for {
+		if err := s.postToCentral("/api/v1/nodes/register", s.currentRegistration()); err != nil {
+			fmt.Println("Registration with", s.cfg.CentralURL, "failed:", err)
+			registered = false
+		} else if !registered {
+			fmt.Println("Registered with", s.cfg.CentralURL, "as", s.cfg.NodeName, "at", s.cfg.AdvertiseURL)
+			registered = true
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(s.cfg.RegisterInterval):
+		}
+	}
+}
+
+// Deregister removes the node from the central API, on shutdown.
+func (s *Server) Deregister() {
+	err := s.postToCentral("/api/v1/nodes/deregister", registration{ID: s.cfg.NodeName, NodeUUID: s.info.ID})
+	if err != nil {
+		fmt.Println("Deregistration failed:", err)
+		return
+	}
+	fmt.Println("Deregistered from", s.cfg.CentralURL)
+}
@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+)
+
+// Direct replication. To repair or rebalance, the central API can have a
+// node copy a file from a peer itself (POST /replicate?from=<peer URL>)
+// instead of sending it the bytes: the file goes node to node, once, and
+// the central API only waits for the answer. The copy must have the
+// checksum the central API expects before it replaces anything here.
+
+// ReplicationProgress is a copy from a peer in flight, as
+// GET /api/v1/replicate lists it.
+type ReplicationProgress struct {
+	Name        string    `json:"name"`
+	From        string    `json:"from"`
+	BytesCopied int64     `json:"bytes_copied"`
+	BytesTotal  int64     `json:"bytes_total"` // as the central API declared it, 0 if it did not
+	Started     time.Time `json:"started"`
+}
+
+// copyProgress tracks the copies in flight, by file name.
+type copyProgress struct {
+	mu sync.Mutex
+	m  map[string]*copyState
+}
+
+type copyState struct {
+	from    string
+	total   int64
+	started time.Time
+	copied  atomic.Int64
+}
+
+func (c *copyProgress) start(name, from string, total int64) *copyState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.m == nil {
+		c.m = map[string]*copyState{}
+	}
+	st := &copyState{from: from, total: total, started: time.Now().UTC()}
+	c.m[name] = st
+	return st
+}
+
+func (c *copyProgress) done(name string, st *copyState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.m[name] == st {
+		delete(c.m, name)
+	}
+}
+
+func (c *copyProgress) list() []ReplicationProgress {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := []ReplicationProgress{}
+	for name, st := range c.m {
+		out = append(out, ReplicationProgress{Name: name, From: st.from, BytesCopied: st.copied.Load(), BytesTotal: st.total, Started: st.started})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// countingReader adds what it reads to n.
+type countingReader struct {
+	r io.Reader
+	n *atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// replicationsHandler lists the copies from peers in flight, for the
+// central API to follow a large one: GET /api/v1/replicate.
+func (s *Server) replicationsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.copies.list())
+}
+
+// replicateHandler copies a file from a peer:
+// POST /replicate?filename=<name>&from=<peer URL>&checksum=<sum>, with
+// ?size= to reserve room for it and ?rate= to copy at most that many
+// bytes per second. It needs the registration token, so the node cannot be
+// made to fetch arbitrary URLs; without one configured it copies nothing.
+func (s *Server) replicateHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.checkToken(w, r) {
+		return
+	}
+	q := r.URL.Query()
+	name, want := q.Get("filename"), q.Get("checksum")
+	from := strings.TrimSuffix(q.Get("from"), "/")
+	if name == "" || want == "" {
+		apierr.Send(w, "filename and checksum required", http.StatusBadRequest)
+		return
+	}
+	if u, err := url.Parse(from); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		apierr.Send(w, "from must be the URL of a node", http.StatusBadRequest)
+		return
+	}
+	size, _ := strconv.ParseInt(q.Get("size"), 10, 64) // checked by requestRules
+	rate, _ := strconv.ParseInt(q.Get("rate"), 10, 64)
+	if s.immutable(name) {
+		apierr.Send(w, "File is locked", http.StatusLocked)
+		return
+	}
+
+	progress := s.copies.start(name, from, size)
+	defer s.copies.done(name, progress)
+	wrap := func(body io.Reader) io.Reader {
+		progress.copied.Store(0) // each download starts the copy over
+		return &countingReader{r: body, n: &progress.copied}
+	}
+	if rate > 0 {
+		// Paced like a scrub pass, and given up on with the request.
+		pace := &pacer{rate: rate, start: time.Now(), stop: r.Context().Done()}
+		count := wrap
+		wrap = func(body io.Reader) io.Reader { return &pacedReader{r: count(body), pace: pace} }
+	}
+	src := from + "/files/" + url.PathEscape(name) + s.signQuery(name)
+	_, err := s.copyIn(r.Context(), name, size, want, []string{src}, wrap, "from "+from)
+	var se *feedStatusError
+	switch {
+	case err == nil:
+	case errors.Is(err, errNoSource):
+		apierr.SendCode(w, http.StatusConflict, "source_mismatch", from+" has no copy of "+name+" with checksum "+want)
+		return
+	case errors.As(err, &se):
+		apierr.Send(w, "Source answered with "+se.Error(), http.StatusBadGateway)
+		return
+	case isNoSpace(err):
+		code := ""
+		if errors.Is(err, errQuotaExceeded) {
+			code = codeQuotaExceeded
+		}
+		apierr.SendCode(w, http.StatusInsufficientStorage, code, err.Error())
+		return
+	default:
+		apierr.Send(w, "Copy failed: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Name     string `json:"name"`
+		Checksum string `json:"checksum"`
+		From     string `json:"from"`
+	}{name, want, from})
+}
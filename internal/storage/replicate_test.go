@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReplicateFromPeer(t *testing.T) {
+	src := newTestServer(t, DefaultConfig("9001", "singapore"))
+	cfg := DefaultConfig("9002", "london")
+	cfg.RegistrationToken = "tok"
+	dst := newTestServer(t, cfg)
+	if resp := upload(t, src.URL, "big.bin", "replicated bytes"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("upload: %d", resp.StatusCode)
+	}
+
+	replicate := func(token, checksum string) int {
+		t.Helper()
+		q := url.Values{"filename": {"big.bin"}, "from": {src.URL}, "checksum": {checksum}, "rate": {"1048576"}}
+		req, _ := http.NewRequest("POST", dst.URL+"/replicate?"+q.Encode(), nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status := replicate("wrong", sha("replicated bytes")); status != http.StatusUnauthorized {
+		t.Errorf("without the token: %d", status)
+	}
+	if status := replicate("tok", sha("other bytes")); status != http.StatusConflict {
+		t.Errorf("checksum mismatch: %d", status)
+	}
+	resp, err := http.Get(dst.URL + "/files/big.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("a mismatched copy was kept: %d", resp.StatusCode)
+	}
+	if status := replicate("tok", sha("replicated bytes")); status != http.StatusOK {
+		t.Fatalf("replicate: %d", status)
+	}
+
+	resp, err = http.Get(dst.URL + "/files/big.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "replicated bytes" || resp.Header.Get("X-Checksum") != sha("replicated bytes") {
+		t.Errorf("copy = %q, checksum %s", body, resp.Header.Get("X-Checksum"))
+	}
+}
+
+func TestReplicateProgress(t *testing.T) {
+	src := newTestServer(t, DefaultConfig("9001", "singapore"))
+	cfg := DefaultConfig("9002", "london")
+	cfg.RegistrationToken = "tok"
+	dst := newTestServer(t, cfg)
+	content := strings.Repeat("x", 100)
+	upload(t, src.URL, "slow.bin", content)
+
+	// At 100 bytes a second the copy takes a second, after its first read.
+	done := make(chan int)
+	go func() {
+		q := url.Values{"filename": {"slow.bin"}, "from": {src.URL}, "checksum": {sha(content)}, "size": {"100"}, "rate": {"100"}}
+		req, _ := http.NewRequest("POST", dst.URL+"/replicate?"+q.Encode(), nil)
+		req.Header.Set("Authorization", "Bearer tok")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			done <- 0
+			return
+		}
+		resp.Body.Close()
+		done <- resp.StatusCode
+	}()
+
+	list := func() []ReplicationProgress {
+		t.Helper()
+		resp, err := http.Get(dst.URL + "/api/v1/replicate")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var l []ReplicationProgress
+		json.NewDecoder(resp.Body).Decode(&l)
+		return l
+	}
+	var seen []ReplicationProgress
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if seen = list(); len(seen) == 1 && seen[0].BytesCopied > 0 {
+			break
+		}
+	}
+	if len(seen) != 1 || seen[0].Name != "slow.bin" || seen[0].From != src.URL || seen[0].BytesTotal != 100 || seen[0].BytesCopied == 0 {
+		t.Errorf("copies in flight = %+v", seen)
+	}
+	if status := <-done; status != http.StatusOK {
+		t.Fatalf("replicate: %d", status)
+	}
+	if l := list(); len(l) != 0 {
+		t.Errorf("copies in flight after the copy = %+v", l)
+	}
+}
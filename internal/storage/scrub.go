@@ -0,0 +1,298 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/checksum"
+)
+
+// Background scrubbing. Cheap disks corrupt data silently: the bytes change
+// but the size and modification time do not, so nothing short of reading
+// the file again notices. With Config.ScrubInterval set, the node re-reads
+// every stored file once per interval, throttled to Config.ScrubRate, and
+// compares it with the checksum recorded when the file was written.
+//
+// A file that no longer matches is flagged corrupt: downloads of it fail, so
+// the central API serves another replica; listings report its actual
+// checksum, so fsck sees the mismatch; and the node asks the central API to
+// push a good copy over it. The flag clears when the file is rewritten or
+// deleted.
+
+// CorruptFile is a file the scrubber found changed on disk.
+type CorruptFile struct {
+	Name     string    `json:"name"`
+	Expected string    `json:"expected"`
+	Actual   string    `json:"actual"`
+	Found    time.Time `json:"found"`
+	// RepairRequested is set once the central API has accepted a repair.
+	RepairRequested bool `json:"repair_requested"`
+}
+
+// ScrubStatus reports the scrubber's progress, at GET /api/v1/scrub.
+type ScrubStatus struct {
+	Enabled      bool          `json:"enabled"`
+	Running      bool          `json:"running"`
+	Passes       int           `json:"passes"`
+	LastStarted  time.Time     `json:"last_started,omitzero"`
+	LastFinished time.Time     `json:"last_finished,omitzero"`
+	FilesChecked int64         `json:"files_checked"` // in the current or last pass
+	BytesChecked int64         `json:"bytes_checked"`
+	Corrupt      []CorruptFile `json:"corrupt"`
+}
+
+type scrubState struct {
+	pass    sync.Mutex // held by a running pass
+	loaded  sync.Once  // the checksum index, before the first pass
+	mu      sync.Mutex
+	status  ScrubStatus
+	corrupt map[string]CorruptFile
+}
+
+func (s *Server) isCorrupt(name string) bool {
+	s.scrub.mu.Lock()
+	defer s.scrub.mu.Unlock()
+	_, ok := s.scrub.corrupt[name]
+	return ok
+}
+
+// clearCorrupt drops the flag on a file that was rewritten or deleted.
+func (s *Server) clearCorrupt(name string) {
+	s.scrub.mu.Lock()
+	defer s.scrub.mu.Unlock()
+	if _, ok := s.scrub.corrupt[name]; ok {
+		delete(s.scrub.corrupt, name)
+		fmt.Println("Corrupt file", name, "replaced")
+	}
+}
+
+// ScrubLoop loads the checksum index and scrubs all files every
+// ScrubInterval (the first pass starts right away) until stop is closed.
+// The index is saved after every pass and before returning.
+func (s *Server) ScrubLoop(stop <-chan struct{}) {
+	s.loadChecksumIndex()
+	for {
+		s.scrub.pass.Lock()
+		err := s.scrubPass(stop)
+		s.scrub.pass.Unlock()
+		if err != nil && !errors.Is(err, errScrubStopped) {
+			fmt.Println("Scrub failed:", err)
+		}
+		if err := s.checksums.save(s.cfg.ChecksumPath); err != nil {
+			fmt.Println("Cannot save checksum index:", err)
+		}
+		if errors.Is(err, errScrubStopped) {
+			return
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(s.cfg.ScrubInterval):
+		}
+	}
+}
+
+var errScrubStopped = errors.New("scrub stopped")
+
+// scrubPass checks every stored file once.
+func (s *Server) scrubPass(stop <-chan struct{}) error {
+	objects, err := s.backend.List()
+	if err != nil {
+		return err
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Name < objects[j].Name })
+
+	s.scrub.mu.Lock()
+	st := &s.scrub.status
+	st.Running = true
+	st.LastStarted = time.Now().UTC()
+	st.FilesChecked, st.BytesChecked = 0, 0
+	s.scrub.mu.Unlock()
+	defer func() {
+		s.scrub.mu.Lock()
+		st.Running = false
+		st.Passes++
+		st.LastFinished = time.Now().UTC()
+		s.scrub.mu.Unlock()
+	}()
+
+	pace := &pacer{rate: s.cfg.ScrubRate, start: time.Now(), stop: stop}
+	var bad int
+	for _, obj := range objects {
+		corrupt, err := s.scrubFile(obj.Name, pace)
+		if errors.Is(err, errScrubStopped) {
+			return err
+		}
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			fmt.Println("Scrub of", obj.Name, "failed:", err)
+			continue
+		}
+		if corrupt {
+			bad++
+		}
+		s.scrub.mu.Lock()
+		st.FilesChecked++
+		st.BytesChecked += obj.Size
+		s.scrub.mu.Unlock()
+	}
+	fmt.Printf("Scrub pass checked %d files, %d newly corrupt\n", len(objects), bad)
+	return nil
+}
+
+// scrubFile rehashes one file. A file whose size or modification time moved
+// since its checksum was recorded was rewritten, not corrupted; its new
+// checksum becomes the reference. It reports whether the file was newly
+// found corrupt.
+func (s *Server) scrubFile(name string, pace *pacer) (bool, error) {
+	alg := s.checksums.algorithmFor(name)
+	rc, obj, err := s.backend.Get(name)
+	if err != nil {
+		return false, err
+	}
+	sum, err := checksum.Of(alg, &pacedReader{r: rc, pace: pace})
+	rc.Close()
+	if err != nil {
+		return false, err
+	}
+
+	e, ok := s.checksums.entry(name)
+	if !ok || e.size != obj.Size || !e.modTime.Equal(obj.ModTime) || e.sum == sum {
+		s.checksums.record(obj, sum)
+		return false, nil
+	}
+
+	// From here on the file is listed with the checksum of what is
+	// actually on disk.
+	s.checksums.record(obj, sum)
+	s.changes.Record(name)
+	cf := CorruptFile{Name: name, Expected: e.sum, Actual: sum, Found: time.Now().UTC()}
+	fmt.Println("CORRUPT:", name, "expected", e.sum, "got", sum)
+	if s.cfg.CentralURL != "" {
+		err := s.postToCentral("/api/v1/nodes/repair", repairRequest{ID: s.cfg.NodeName, NodeUUID: s.info.ID, File: name})
+		if err != nil {
+			fmt.Println("Repair request for", name, "failed:", err)
+		} else {
+			cf.RepairRequested = true
+		}
+	}
+	s.scrub.mu.Lock()
+	s.scrub.corrupt[name] = cf
+	s.scrub.mu.Unlock()
+	return true, nil
+}
+
+// repairRequest asks the central API to push its copy of File over this
+// node's.
+type repairRequest struct {
+	ID       string `json:"id"`
+	NodeUUID string `json:"node_uuid"`
+	File     string `json:"file"`
+}
+
+// pacer spreads a pass's reads out to rate bytes per second (0 = as fast
+// as the disk goes) and aborts it when stop is closed.
+type pacer struct {
+	rate  int64
+	start time.Time
+	read  int64
+	stop  <-chan struct{}
+}
+
+func (p *pacer) wait(n int) error {
+	p.read += int64(n)
+	var d time.Duration
+	if p.rate > 0 {
+		d = time.Duration(float64(p.read)/float64(p.rate)*float64(time.Second)) - time.Since(p.start)
+	}
+	if d <= 0 {
+		select {
+		case <-p.stop:
+			return errScrubStopped
+		default:
+			return nil
+		}
+	}
+	select {
+	case <-p.stop:
+		return errScrubStopped
+	case <-time.After(d):
+		return nil
+	}
+}
+
+type pacedReader struct {
+	r    io.Reader
+	pace *pacer
+}
+
+func (r *pacedReader) Read(p []byte) (int, error) {
+	if len(p) > 64<<10 {
+		p = p[:64<<10]
+	}
+	n, err := r.r.Read(p)
+	if werr := r.pace.wait(n); werr != nil {
+		return n, werr
+	}
+	return n, err
+}
+
+// Report scrubbing progress and corrupt files as JSON
+func (s *Server) scrubStatusHandler(w http.ResponseWriter, r *http.Request) {
+	s.scrub.mu.Lock()
+	st := s.scrub.status
+	st.Enabled = s.cfg.ScrubInterval > 0
+	st.Corrupt = []CorruptFile{}
+	for _, cf := range s.scrub.corrupt {
+		st.Corrupt = append(st.Corrupt, cf)
+	}
+	s.scrub.mu.Unlock()
+	sort.Slice(st.Corrupt, func(i, j int) bool { return st.Corrupt[i].Name < st.Corrupt[j].Name })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(st)
+}
+
+// loadChecksumIndex reads the checksum index once, before the first pass
+// however it is started.
+func (s *Server) loadChecksumIndex() {
+	s.scrub.loaded.Do(func() {
+		if err := s.checksums.load(s.cfg.ChecksumPath); err != nil {
+			fmt.Println("Cannot load checksum index:", err)
+		}
+	})
+}
+
+// scrubStartHandler starts a scrub pass now, for the central API's
+// scheduler: POST /api/v1/scrub. It needs the registration token, and
+// works whether or not the node scrubs on its own.
+func (s *Server) scrubStartHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.checkToken(w, r) {
+		return
+	}
+	if !s.scrub.pass.TryLock() {
+		apierr.Send(w, "A scrub pass is already running", http.StatusConflict)
+		return
+	}
+	go func() {
+		defer s.scrub.pass.Unlock()
+		s.loadChecksumIndex()
+		if err := s.scrubPass(nil); err != nil {
+			fmt.Println("Scrub failed:", err)
+		}
+		if s.cfg.ChecksumPath == "" {
+			return
+		}
+		if err := s.checksums.save(s.cfg.ChecksumPath); err != nil {
+			fmt.Println("Cannot save checksum index:", err)
+		}
+	}()
+	w.WriteHeader(http.StatusAccepted)
+}
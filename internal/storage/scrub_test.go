@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestScrubFindsBitRot(t *testing.T) {
+	dir := t.TempDir()
+	backend, err := NewLocalBackend(filepath.Join(dir, "files"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultConfig("9001", "singapore")
+	cfg.IdentityPath = filepath.Join(dir, "node.json")
+	cfg.ChecksumPath = filepath.Join(dir, "checksums.json")
+	cfg.RegistrationToken = testToken
+	cfg.ScrubRate = 0
+	s, err := NewServer(cfg, backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+	upload(t, ts.URL, "rot.txt", "hello")
+	upload(t, ts.URL, "fine.txt", "fine")
+
+	// Flip the content but keep the size and mod time, as a bad disk would.
+	path := filepath.Join(dir, "files", "rot.txt")
+	fi, _ := os.Stat(path)
+	os.WriteFile(path, []byte("jello"), 0644)
+	os.Chtimes(path, fi.ModTime(), fi.ModTime())
+
+	// A restart must not forget what the files should hold.
+	if err := s.checksums.save(cfg.ChecksumPath); err != nil {
+		t.Fatal(err)
+	}
+	s, _ = NewServer(cfg, backend)
+	s.checksums.load(cfg.ChecksumPath)
+	ts.Config.Handler = s.Handler()
+
+	if err := s.scrubPass(nil); err != nil {
+		t.Fatal(err)
+	}
+	resp, _ := http.Get(ts.URL + "/api/v1/scrub")
+	var st ScrubStatus
+	json.NewDecoder(resp.Body).Decode(&st)
+	resp.Body.Close()
+	if st.Passes != 1 || st.FilesChecked != 2 || len(st.Corrupt) != 1 || st.Corrupt[0].Name != "rot.txt" {
+		t.Fatalf("scrub status = %+v", st)
+	}
+	if st.Corrupt[0].Expected != sha("hello") || st.Corrupt[0].Actual != sha("jello") {
+		t.Errorf("corrupt = %+v", st.Corrupt[0])
+	}
+
+	resp, _ = http.Get(ts.URL + "/files/rot.txt")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("download of corrupt file: status %d, want 500", resp.StatusCode)
+	}
+
+	// A rewrite replaces the corrupt copy.
+	upload(t, ts.URL, "rot.txt", "hello")
+	if s.isCorrupt("rot.txt") {
+		t.Error("rot.txt still flagged after being rewritten")
+	}
+	resp, _ = http.Get(ts.URL + "/files/rot.txt")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("download after rewrite: status %d", resp.StatusCode)
+	}
+}
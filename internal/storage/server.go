@@ -0,0 +1,460 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/buildinfo"
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/changes"
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/checksum"
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/validate"
+)
+
+// Deletions remembered for ?since= listings; a since older than the oldest
+// one forgotten gets 410 Gone.
+const maxTombstones = 100000
+
+// Server is one storage node: its identity, backend and HTTP handlers.
+type Server struct {
+	cfg           Config
+	backend       Backend
+	info          NodeInfo
+	checksums     checksumCache
+	reservations  spaceReservations
+	limits        limitsState
+	scrub         scrubState
+	quarantine    quarantineState
+	private       quarantineState // same shape: names the central API pushes
+	trash         quarantineState // and again
+	locked        quarantineState // files under an object lock
+	directUploads usedUploads
+	copies        copyProgress // from peers, for /replicate
+	changes       *changes.Log
+	draining      atomic.Bool // set on shutdown, fails /readyz
+}
+
+// NewServer loads (or creates) the node identity and returns a node serving
+// files from backend.
+func NewServer(cfg Config, backend Backend) (*Server, error) {
+	info, err := loadOrCreateIdentity(cfg.IdentityPath, cfg.Region)
+	if err != nil {
+		return nil, err
+	}
+	info.Name, info.Lat, info.Lon = cfg.NodeName, cfg.Lat, cfg.Lon
+	if cfg.Faults.enabled() {
+		fmt.Println("WARNING: fault injection enabled:", cfg.Faults)
+	}
+	if cfg.Faults.DiskFull {
+		backend = fullDisk{backend}
+	}
+	s := &Server{
+		cfg:       cfg,
+		backend:   backend,
+		info:      info,
+		checksums: checksumCache{m: map[string]checksumEntry{}, alg: cfg.ChecksumAlgorithm},
+		scrub:     scrubState{corrupt: map[string]CorruptFile{}},
+		limits:    limitsState{cur: cfg.limits()},
+		changes:   changes.New(maxTombstones),
+	}
+	if cfg.QuarantinePath != "" {
+		if err := s.quarantine.load(cfg.QuarantinePath); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.PrivatePath != "" {
+		if err := s.private.load(cfg.PrivatePath); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.TrashPath != "" {
+		if err := s.trash.load(cfg.TrashPath); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.LocksPath != "" {
+		if err := s.locked.load(cfg.LocksPath); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Info returns the node's identity.
+func (s *Server) Info() NodeInfo {
+	return s.info
+}
+
+// requestRules check the parameters of every route (see validate.Mux).
+var requestRules = validate.Rules{
+	"filename": validate.Name,
+	"name":     validate.Name,
+	"since":    validate.Int(0, math.MaxInt64),
+	"expires":  validate.Int(0, math.MaxInt64),
+	"size":     validate.Int(0, math.MaxInt64),
+	"rate":     validate.Int(1, math.MaxInt64),
+}
+
+// Handler returns the node's routes.
+func (s *Server) Handler() http.Handler {
+	mux := validate.NewMux(requestRules)
+	mux.HandleFunc("/upload", s.uploadHandler)                                                                  // from the central API
+	mux.HandleFunc("POST /upload/direct", s.directUploadHandler)                                                // signed client uploads
+	mux.HandleFunc("/delete", s.deleteHandler)                                                                  // from the central API
+	mux.HandleFunc("POST /replicate", s.replicateHandler)                                                       // copy from a peer
+	mux.HandleFunc("/info", s.infoHandler)                                                                      // node identity
+	mux.HandleFunc("GET /version", buildinfo.Handler)                                                           // build info
+	mux.HandleFunc("/ping", s.pingHandler)                                                                      // latency probe
+	mux.HandleFunc("GET /healthz", s.healthzHandler)                                                            // process alive
+	mux.HandleFunc("GET /readyz", s.readyzHandler)                                                              // ready for traffic
+	mux.HandleFunc("POST /api/v1/ping-peers", s.pingPeersHandler)                                               // latency to other nodes
+	mux.HandleFunc("/files", s.listFilesHandler)                                                                // JSON list
+	mux.Handle("/files/", http.StripPrefix("/files/", s.requireSignature(http.HandlerFunc(s.downloadHandler)))) // serve actual files
+	mux.HandleFunc("GET /api/v1/files/{name}", s.statHandler)                                                   // stat one file
+	mux.HandleFunc("POST /api/v1/files/{name}/verify", s.verifyHandler)                                         // rehash one file
+	mux.HandleFunc("GET /api/v1/scrub", s.scrubStatusHandler)                                                   // scrubber progress
+	mux.HandleFunc("POST /api/v1/scrub", s.scrubStartHandler)                                                   // scrub pass now
+	mux.HandleFunc("GET /api/v1/replicate", s.replicationsHandler)                                              // copies from peers in flight
+	mux.HandleFunc("/api/v1/limits", s.limitsHandler)                                                           // quota and free space floor
+	mux.HandleFunc("GET /api/v1/merkle", s.merkleHandler)                                                       // inventory subtree hashes
+	mux.HandleFunc("GET /api/v1/merkle/files", s.merkleFilesHandler)                                            // files in one subtree
+	mux.HandleFunc("/api/v1/quarantine", s.quarantineHandler)                                                   // files not to serve
+	mux.HandleFunc("/api/v1/private", s.privateHandler)                                                         // files for signed URLs only
+	mux.HandleFunc("/api/v1/trash", s.trashHandler)                                                             // deleted files not yet purged
+	mux.HandleFunc("/api/v1/locks", s.locksHandler)                                                             // files not to delete or overwrite
+	mux.HandleFunc("/api/v1/checksum", s.algorithmHandler)                                                      // checksum algorithm for new files
+
+	if s.cfg.Faults.enabled() {
+		return apierr.RequestIDs(injectFaults(s.cfg.Faults, mux))
+	}
+	return apierr.RequestIDs(mux)
+}
+
+// AdminHandler returns the routes for the admin listeners: what operators
+// and their tooling watch, without the files or the central API's calls.
+func (s *Server) AdminHandler() http.Handler {
+	mux := validate.NewMux(requestRules)
+	mux.HandleFunc("/info", s.infoHandler)
+	mux.HandleFunc("GET /version", buildinfo.Handler)
+	mux.HandleFunc("GET /healthz", s.healthzHandler)
+	mux.HandleFunc("GET /readyz", s.readyzHandler)
+	mux.HandleFunc("GET /api/v1/scrub", s.scrubStatusHandler)
+	mux.HandleFunc("GET /api/v1/limits", s.limitsHandler)
+	return apierr.RequestIDs(mux)
+}
+
+// Upload a file to storage. Only the central API stores files this way, so
+// it needs the registration token; clients upload through /upload/direct.
+func (s *Server) uploadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apierr.Send(w, "Use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.checkToken(w, r) {
+		return
+	}
+	if name, ok := s.receiveFile(w, r, ""); ok {
+		w.Write([]byte("OK|" + name))
+	}
+}
+
+// receiveFile stores the file part of an upload request and returns its
+// name as sent, or answers the request with the error and returns false. want, if
+// not empty, is the only name the file may have.
+func (s *Server) receiveFile(w http.ResponseWriter, r *http.Request, want string) (string, bool) {
+	// Stream the file part straight into the backend, which writes it to a
+	// temp file and moves it into place, so memory use does not grow with
+	// the file size.
+	mr, err := r.MultipartReader()
+	if err != nil {
+		apierr.Send(w, "Parse error: "+err.Error(), http.StatusBadRequest)
+		return "", false
+	}
+	var part *multipart.Part
+	for {
+		part, err = mr.NextPart()
+		if err == io.EOF {
+			apierr.Send(w, "Missing file", http.StatusBadRequest)
+			return "", false
+		}
+		if err != nil {
+			apierr.Send(w, "Parse error: "+err.Error(), http.StatusBadRequest)
+			return "", false
+		}
+		if part.FormName() == "file" {
+			break
+		}
+	}
+	defer part.Close()
+
+	name := filepath.Base(part.FileName())
+	if want != "" && name != want {
+		apierr.Send(w, "This upload is for "+want, http.StatusForbidden)
+		return "", false
+	}
+	if s.immutable(name) {
+		apierr.Send(w, "File is locked", http.StatusLocked)
+		return "", false
+	}
+	release, limit, err := s.reserveSpace(name, declaredSize(r))
+	if err != nil {
+		fmt.Println("Upload refused:", name, err)
+		status, code := http.StatusInternalServerError, ""
+		if isNoSpace(err) {
+			status = http.StatusInsufficientStorage
+		}
+		if errors.Is(err, errQuotaExceeded) {
+			code = codeQuotaExceeded
+		}
+		apierr.SendCode(w, status, code, err.Error())
+		return "", false
+	}
+	defer release()
+
+	// The checksum of the bytes as received is the reference the scrubber
+	// later checks the file against.
+	alg := r.Header.Get("X-Checksum-Algorithm")
+	if alg == "" {
+		alg = s.checksums.algorithm()
+	}
+	h, err := checksum.New(alg)
+	if err != nil {
+		apierr.Send(w, err.Error(), http.StatusBadRequest)
+		return "", false
+	}
+	body := &readErrRecorder{r: io.TeeReader(part, h)}
+	if _, err := s.backend.Put(name, &limitedBody{r: body, limit: limit}); err != nil {
+		if body.err != nil {
+			fmt.Println("Upload aborted:", name, body.err)
+			apierr.Send(w, "Read error: "+body.err.Error(), http.StatusBadRequest)
+			return "", false
+		}
+		fmt.Println("Write failed:", name, err)
+		if isNoSpace(err) {
+			msg, code := errInsufficientStorage.Error(), ""
+			if errors.Is(err, errQuotaExceeded) {
+				msg, code = errQuotaExceeded.Error(), codeQuotaExceeded
+			}
+			apierr.SendCode(w, http.StatusInsufficientStorage, code, "Write error: "+msg)
+			return "", false
+		}
+		apierr.Send(w, "Write error", http.StatusInternalServerError)
+		return "", false
+	}
+
+	if obj, err := s.backend.Stat(name); err == nil {
+		s.checksums.record(obj, checksum.Format(alg, h))
+	}
+	s.clearCorrupt(name)
+	s.changes.Record(name)
+	fmt.Printf("Uploaded: %s\n", name)
+	return part.FileName(), true
+}
+
+// Delete a file from storage. Like /upload it needs the registration
+// token: only the central API deletes.
+func (s *Server) deleteHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.checkToken(w, r) {
+		return
+	}
+	raw := r.URL.Query().Get("filename")
+	if raw == "" {
+		apierr.Send(w, "filename required", http.StatusBadRequest)
+		return
+	}
+
+	filename, err := url.QueryUnescape(raw)
+	if err != nil {
+		apierr.Send(w, "Invalid filename", http.StatusBadRequest)
+		return
+	}
+
+	filename = filepath.Base(filename)
+	if s.immutable(filename) {
+		apierr.Send(w, "File is locked", http.StatusLocked)
+		return
+	}
+	if err := s.backend.Delete(filename); err != nil {
+		fmt.Println("Delete failed:", filename, err)
+		if errors.Is(err, ErrNotFound) {
+			apierr.Send(w, "File not found", http.StatusNotFound)
+		} else {
+			apierr.Send(w, "Delete failed", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	s.checksums.forget(filename)
+	s.clearCorrupt(filename)
+	s.changes.Remove(filename)
+	fmt.Println("Deleted:", filename)
+	w.Write([]byte("Deleted " + filename))
+}
+
+// List all files as JSON. With ?since=<seq> only the files changed after
+// seq are listed, deletions included. X-Change-Seq carries the seq to ask
+// from next time.
+func (s *Server) listFilesHandler(w http.ResponseWriter, r *http.Request) {
+	seq := s.changes.Seq()
+	w.Header().Set("X-Change-Seq", strconv.FormatInt(seq, 10))
+	if v := r.URL.Query().Get("since"); v != "" {
+		since, _ := strconv.ParseInt(v, 10, 64) // checked by requestRules
+		s.listChanges(w, since)
+		return
+	}
+
+	objects, err := s.backend.List()
+	if err != nil {
+		fmt.Println("List failed:", err)
+		apierr.Send(w, "Cannot list files", http.StatusInternalServerError)
+		return
+	}
+
+	list := []FileInfo{}
+	for _, obj := range objects {
+		info, err := s.describe(obj)
+		if err != nil {
+			fmt.Println("Stat failed:", obj.Name, err)
+			continue
+		}
+		list = append(list, info)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+func (s *Server) listChanges(w http.ResponseWriter, since int64) {
+	changed, err := s.changes.Since(since)
+	if err != nil {
+		apierr.Send(w, err.Error(), http.StatusGone)
+		return
+	}
+	list := []FileInfo{}
+	for _, c := range changed {
+		if c.Deleted {
+			list = append(list, FileInfo{Name: c.Name, Seq: c.Seq, Deleted: true})
+			continue
+		}
+		info, err := s.statFile(c.Name)
+		if err != nil {
+			continue // deleted since; listed once that is logged
+		}
+		info.Seq = c.Seq
+		list = append(list, info)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// Report node identity as JSON
+func (s *Server) infoHandler(w http.ResponseWriter, r *http.Request) {
+	info := s.info
+	info.Quota = s.quotaStatus()
+	info.Quarantine = quarantineDigest(s.quarantine.list())
+	info.Private = quarantineDigest(s.private.list())
+	info.Trash = quarantineDigest(s.trash.list())
+	info.Locked = quarantineDigest(s.locked.list())
+	info.ChecksumAlgorithm = s.checksums.algorithm()
+	info.ChecksumAlgorithms = checksum.Algorithms
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+// pingHandler answers as fast as it can, for clients measuring the round
+// trip to this node. Nothing may cache the answer, and browsers on other
+// origins may read it and its timing.
+func (s *Server) pingHandler(w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	h.Set("Cache-Control", "no-store, no-cache, must-revalidate")
+	h.Set("Pragma", "no-cache")
+	h.Set("Access-Control-Allow-Origin", "*")
+	h.Set("Timing-Allow-Origin", "*")
+	h.Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Node string    `json:"node"`
+		Name string    `json:"name"`
+		Time time.Time `json:"time"`
+	}{s.info.ID, s.cfg.NodeName, time.Now().UTC()})
+}
+
+// pingPeersHandler measures the round trip from this node to others, for
+// the central API's topology view: POST {"peers": {"<id>": "<url>"}}
+// answers {"<id>": <ms>} for the peers that answered. Like registration it
+// needs the registration token, so the node cannot be made to call
+// arbitrary URLs.
+func (s *Server) pingPeersHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.checkToken(w, r) {
+		return
+	}
+	var req struct {
+		Peers map[string]string `json:"peers"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil || len(req.Peers) > 256 {
+		apierr.Send(w, "Invalid peer list", http.StatusBadRequest)
+		return
+	}
+
+	client := &http.Client{Timeout: 2 * time.Second}
+	out := map[string]float64{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for id, u := range req.Peers {
+		if !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+			continue
+		}
+		wg.Add(1)
+		go func(id, u string) {
+			defer wg.Done()
+			// The first round trip pays for the connection; time the second.
+			var rtt time.Duration
+			for i := 0; i < 2; i++ {
+				start := time.Now()
+				resp, err := client.Get(strings.TrimSuffix(u, "/") + "/ping")
+				if err != nil {
+					return
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusOK {
+					return
+				}
+				rtt = time.Since(start)
+			}
+			mu.Lock()
+			out[id] = float64(rtt) / float64(time.Millisecond)
+			mu.Unlock()
+		}(id, u)
+	}
+	wg.Wait()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// readErrRecorder remembers the first error reading the upload body, so a
+// client that went away is told apart from a failing disk.
+type readErrRecorder struct {
+	r   io.Reader
+	err error
+}
+
+func (e *readErrRecorder) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	if err != nil && err != io.EOF && e.err == nil {
+		e.err = err
+	}
+	return n, err
+}
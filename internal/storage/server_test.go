@@ -0,0 +1,692 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestServer(t *testing.T, cfg Config) *httptest.Server {
+	t.Helper()
+	return newTestServerOn(t, cfg, NewMemoryBackend())
+}
+
+// testToken is the registration token test servers get unless their
+// config sets one, so centralClient can store and delete.
+const testToken = "tok"
+
+// centralClient calls a test server the way the central API does: with the
+// registration token.
+var centralClient = &http.Client{Transport: tokenTransport{}}
+
+type tokenTransport struct{}
+
+func (tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Authorization") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("Authorization", "Bearer "+testToken)
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func newTestServerOn(t *testing.T, cfg Config, backend Backend) *httptest.Server {
+	t.Helper()
+	if cfg.RegistrationToken == "" {
+		cfg.RegistrationToken = testToken
+	}
+	dir := t.TempDir()
+	cfg.IdentityPath = filepath.Join(dir, "node.json")
+	cfg.QuarantinePath = filepath.Join(dir, "quarantine.json")
+	cfg.PrivatePath = filepath.Join(dir, "private.json")
+	cfg.TrashPath = filepath.Join(dir, "trash.json")
+	cfg.LocksPath = filepath.Join(dir, "locks.json")
+	s, err := NewServer(cfg, backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s.Handler())
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func upload(t *testing.T, baseURL, name, content string) *http.Response {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("file", name)
+	io.WriteString(fw, content)
+	mw.Close()
+	resp, err := centralClient.Post(baseURL+"/upload", mw.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func sha(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+func TestUploadListAndStat(t *testing.T) {
+	ts := newTestServer(t, DefaultConfig("9001", "singapore"))
+
+	if resp := upload(t, ts.URL, "notes.txt", "hello"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("upload: status %d", resp.StatusCode)
+	}
+
+	resp, err := http.Get(ts.URL + "/files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list []FileInfo
+	json.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	if len(list) != 1 || list[0].Name != "notes.txt" || list[0].Size != 5 {
+		t.Fatalf("list = %+v", list)
+	}
+	if list[0].Checksum != sha("hello") {
+		t.Errorf("checksum = %s, want %s", list[0].Checksum, sha("hello"))
+	}
+	if list[0].MimeType != "text/plain; charset=utf-8" {
+		t.Errorf("mime type = %q", list[0].MimeType)
+	}
+
+	resp, _ = http.Get(ts.URL + "/api/v1/files/notes.txt")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("stat existing: status %d", resp.StatusCode)
+	}
+	resp, _ = http.Get(ts.URL + "/api/v1/files/missing.txt")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("stat missing: status %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestUploadRequiresPostAndFile(t *testing.T) {
+	ts := newTestServer(t, DefaultConfig("9001", "singapore"))
+
+	resp, _ := http.Get(ts.URL + "/upload")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET /upload: status %d, want 405", resp.StatusCode)
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("other", "x")
+	mw.Close()
+	resp, _ = centralClient.Post(ts.URL+"/upload", mw.FormDataContentType(), &body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("upload without file: status %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestTruncatedUploadLeavesNoFile(t *testing.T) {
+	dir := t.TempDir()
+	backend, err := NewLocalBackend(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := newTestServerOn(t, DefaultConfig("9001", "singapore"), backend)
+
+	// The body ends in the middle of the file part.
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("file", "cut.bin")
+	io.WriteString(fw, strings.Repeat("x", 1<<20))
+	resp, err := centralClient.Post(ts.URL+"/upload", mw.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("truncated upload: status %d, want 400", resp.StatusCode)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("storage dir has %d entries after a truncated upload, want none", len(entries))
+	}
+}
+
+func TestServeFileHeadersAndRange(t *testing.T) {
+	ts := newTestServer(t, DefaultConfig("9001", "singapore"))
+	upload(t, ts.URL, "data.bin", "0123456789")
+
+	req, _ := http.NewRequest("GET", ts.URL+"/files/data.bin", nil)
+	req.Header.Set("Range", "bytes=2-4")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent || string(got) != "234" {
+		t.Errorf("range: status %d body %q", resp.StatusCode, got)
+	}
+	if etag := resp.Header.Get("ETag"); etag != `"`+sha("0123456789")+`"` {
+		t.Errorf("ETag = %s", etag)
+	}
+	if resp.Header.Get("X-Storage-Node") == "" {
+		t.Error("missing X-Storage-Node")
+	}
+
+	resp, _ = http.Get(ts.URL + "/files/nope.bin")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("missing file: status %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestServeFileConditionalAndHead(t *testing.T) {
+	ts := newTestServer(t, DefaultConfig("9001", "singapore"))
+	upload(t, ts.URL, "data.bin", "0123456789")
+
+	resp, err := http.Head(ts.URL + "/files/data.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	lastMod := resp.Header.Get("Last-Modified")
+	if resp.StatusCode != http.StatusOK || resp.ContentLength != 10 || lastMod == "" {
+		t.Fatalf("HEAD: status %d, length %d, Last-Modified %q", resp.StatusCode, resp.ContentLength, lastMod)
+	}
+
+	for _, h := range []http.Header{
+		{"If-None-Match": {`"` + sha("0123456789") + `"`}},
+		{"If-Modified-Since": {lastMod}},
+	} {
+		req, _ := http.NewRequest("GET", ts.URL+"/files/data.bin", nil)
+		req.Header = h
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotModified {
+			t.Errorf("GET with %v: status %d, want 304", h, resp.StatusCode)
+		}
+	}
+
+	// A stale If-Range gets the whole file rather than the range.
+	req, _ := http.NewRequest("GET", ts.URL+"/files/data.bin", nil)
+	req.Header.Set("Range", "bytes=2-4")
+	req.Header.Set("If-Range", `"stale"`)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(got) != "0123456789" {
+		t.Errorf("stale If-Range: status %d body %q", resp.StatusCode, got)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	ts := newTestServer(t, DefaultConfig("9001", "singapore"))
+	upload(t, ts.URL, "gone.txt", "bye")
+
+	for _, tc := range []struct {
+		query string
+		want  int
+	}{
+		{"", http.StatusBadRequest},
+		{"?filename=gone.txt", http.StatusOK},
+		{"?filename=gone.txt", http.StatusNotFound},
+	} {
+		resp, err := centralClient.Get(ts.URL + "/delete" + tc.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.want {
+			t.Errorf("delete%s: status %d, want %d", tc.query, resp.StatusCode, tc.want)
+		}
+	}
+}
+
+func TestSignedDownloads(t *testing.T) {
+	cfg := DefaultConfig("9001", "singapore")
+	cfg.SigningKey = []byte("secret")
+	ts := newTestServer(t, cfg)
+	upload(t, ts.URL, "private.txt", "shh")
+
+	sign := func(name string, expires int64) string {
+		exp := strconv.FormatInt(expires, 10)
+		mac := hmac.New(sha256.New, cfg.SigningKey)
+		mac.Write([]byte(name + "\n" + exp))
+		return "?expires=" + exp + "&sig=" + hex.EncodeToString(mac.Sum(nil))
+	}
+	future := time.Now().Add(time.Minute).Unix()
+	past := time.Now().Add(-time.Minute).Unix()
+
+	for _, tc := range []struct {
+		name  string
+		query string
+		want  int
+	}{
+		{"unsigned", "", http.StatusForbidden},
+		{"signed", sign("private.txt", future), http.StatusOK},
+		{"expired", sign("private.txt", past), http.StatusForbidden},
+		{"other file", sign("public.txt", future), http.StatusForbidden},
+	} {
+		resp, err := http.Get(ts.URL + "/files/private.txt" + tc.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.want {
+			t.Errorf("%s: status %d, want %d", tc.name, resp.StatusCode, tc.want)
+		}
+	}
+}
+
+func TestPrivateFiles(t *testing.T) {
+	cfg := DefaultConfig("9001", "singapore")
+	cfg.SigningKey = []byte("secret")
+	cfg.RegistrationToken = "tok"
+	ts := newTestServer(t, cfg)
+	upload(t, ts.URL, "p.txt", "shh")
+	unkeyedCfg := DefaultConfig("9002", "singapore")
+	unkeyedCfg.RegistrationToken = "tok"
+	unkeyed := newTestServer(t, unkeyedCfg)
+	upload(t, unkeyed.URL, "p.txt", "shh")
+	upload(t, unkeyed.URL, "q.txt", "hi")
+
+	for _, base := range []string{ts.URL, unkeyed.URL} {
+		req, _ := http.NewRequest("PUT", base+"/api/v1/private", strings.NewReader(`["p.txt"]`))
+		req.Header.Set("Authorization", "Bearer tok")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("PUT private list: %v %v", resp, err)
+		}
+		resp.Body.Close()
+	}
+	sign := func(message string) string {
+		exp := strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10)
+		mac := hmac.New(sha256.New, cfg.SigningKey)
+		mac.Write([]byte(message + "\n" + exp))
+		return "?expires=" + exp + "&sig=" + hex.EncodeToString(mac.Sum(nil))
+	}
+	for _, tc := range []struct {
+		name, url string
+		want      int
+	}{
+		{"public signature", ts.URL + "/files/p.txt" + sign("p.txt"), http.StatusForbidden},
+		{"private signature", ts.URL + "/files/p.txt" + sign(privateScope+"p.txt"), http.StatusOK},
+		{"no signing key", unkeyed.URL + "/files/p.txt", http.StatusForbidden},
+		{"public file, no signing key", unkeyed.URL + "/files/q.txt", http.StatusOK},
+	} {
+		resp, err := http.Get(tc.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.want {
+			t.Errorf("%s: status %d, want %d", tc.name, resp.StatusCode, tc.want)
+		}
+	}
+}
+
+func TestTrashedFiles(t *testing.T) {
+	cfg := DefaultConfig("9001", "singapore")
+	cfg.RegistrationToken = "tok"
+	ts := newTestServer(t, cfg)
+	upload(t, ts.URL, "gone.txt", "bye")
+
+	status := func() int {
+		resp, err := http.Get(ts.URL + "/files/gone.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	for _, tc := range []struct {
+		list string
+		want int
+	}{
+		{`["gone.txt"]`, http.StatusNotFound},
+		{`[]`, http.StatusOK}, // restored
+	} {
+		req, _ := http.NewRequest("PUT", ts.URL+"/api/v1/trash", strings.NewReader(tc.list))
+		req.Header.Set("Authorization", "Bearer tok")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("PUT trash list: %v %v", resp, err)
+		}
+		resp.Body.Close()
+		if got := status(); got != tc.want {
+			t.Errorf("trash %s: status %d, want %d", tc.list, got, tc.want)
+		}
+	}
+}
+
+func TestLockedFiles(t *testing.T) {
+	cfg := DefaultConfig("9001", "singapore")
+	cfg.RegistrationToken = "tok"
+	ts := newTestServer(t, cfg)
+	upload(t, ts.URL, "kept.txt", "v1")
+
+	req, _ := http.NewRequest("PUT", ts.URL+"/api/v1/locks", strings.NewReader(`["kept.txt", "missing.txt"]`))
+	req.Header.Set("Authorization", "Bearer tok")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("PUT locks: %v %v", resp, err)
+	}
+	resp.Body.Close()
+
+	if resp := upload(t, ts.URL, "kept.txt", "v2"); resp.StatusCode != http.StatusLocked {
+		t.Errorf("overwrite: %d", resp.StatusCode)
+	}
+	if resp, err := centralClient.Get(ts.URL + "/delete?filename=kept.txt"); err != nil || resp.StatusCode != http.StatusLocked {
+		t.Errorf("delete: %v %v", resp, err)
+	}
+	// A locked file's missing replica can still be written.
+	if resp := upload(t, ts.URL, "missing.txt", "repair"); resp.StatusCode != http.StatusOK {
+		t.Errorf("repair: %d", resp.StatusCode)
+	}
+}
+
+func TestIdentityPersists(t *testing.T) {
+	cfg := DefaultConfig("9001", "singapore")
+	cfg.IdentityPath = filepath.Join(t.TempDir(), "node.json")
+
+	first, err := NewServer(cfg, NewMemoryBackend())
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewServer(cfg, NewMemoryBackend())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Info().ID == "" || first.Info().ID != second.Info().ID {
+		t.Errorf("node ID changed across restarts: %q then %q", first.Info().ID, second.Info().ID)
+	}
+}
+
+func TestVerifyRehashes(t *testing.T) {
+	backend := NewMemoryBackend()
+	ts := newTestServerOn(t, DefaultConfig("9001", "singapore"), backend)
+	upload(t, ts.URL, "v.txt", "hello")
+	http.Get(ts.URL + "/files") // caches the checksum
+
+	backend.Put("v.txt", strings.NewReader("jello"))
+	resp, err := http.Post(ts.URL+"/api/v1/files/v.txt/verify", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Checksum string `json:"checksum"`
+	}
+	json.NewDecoder(resp.Body).Decode(&got)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || got.Checksum != sha("jello") {
+		t.Errorf("verify: status %d, checksum %s, want %s", resp.StatusCode, got.Checksum, sha("jello"))
+	}
+}
+
+func TestListSince(t *testing.T) {
+	ts := newTestServer(t, DefaultConfig("9001", "singapore"))
+	upload(t, ts.URL, "a.txt", "a")
+	upload(t, ts.URL, "b.txt", "b")
+
+	resp, err := http.Get(ts.URL + "/files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	seq := resp.Header.Get("X-Change-Seq")
+
+	upload(t, ts.URL, "c.txt", "c")
+	centralClient.Get(ts.URL + "/delete?filename=a.txt")
+
+	resp, err = http.Get(ts.URL + "/files?since=" + seq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list []FileInfo
+	json.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	if len(list) != 2 || list[0].Name != "c.txt" || list[0].Deleted || list[1].Name != "a.txt" || !list[1].Deleted {
+		t.Errorf("since %s = %+v", seq, list)
+	}
+	if next := resp.Header.Get("X-Change-Seq"); strconv.FormatInt(list[1].Seq, 10) != next {
+		t.Errorf("X-Change-Seq = %s, want the last change %d", next, list[1].Seq)
+	}
+
+	resp, _ = http.Get(ts.URL + "/files?since=1")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusGone {
+		t.Errorf("since before the log: status %d, want 410", resp.StatusCode)
+	}
+}
+
+func TestPing(t *testing.T) {
+	ts := newTestServer(t, DefaultConfig("9001", "singapore"))
+	resp, err := http.Get(ts.URL + "/ping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var pong struct {
+		Node string    `json:"node"`
+		Time time.Time `json:"time"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&pong); err != nil {
+		t.Fatal(err)
+	}
+	if pong.Node == "" || time.Since(pong.Time) > time.Minute {
+		t.Errorf("pong = %+v", pong)
+	}
+	if cc := resp.Header.Get("Cache-Control"); !strings.Contains(cc, "no-store") {
+		t.Errorf("Cache-Control = %q", cc)
+	}
+}
+
+func TestProbes(t *testing.T) {
+	cfg := DefaultConfig("9001", "singapore")
+	cfg.IdentityPath = filepath.Join(t.TempDir(), "node.json")
+	s, err := NewServer(cfg, NewMemoryBackend())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	status := func(path string) int {
+		t.Helper()
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if got := status("/healthz"); got != http.StatusOK {
+		t.Errorf("healthz: status %d", got)
+	}
+	if got := status("/readyz"); got != http.StatusOK {
+		t.Errorf("readyz: status %d", got)
+	}
+
+	s.draining.Store(true)
+	if got := status("/readyz"); got != http.StatusServiceUnavailable {
+		t.Errorf("readyz while draining: status %d, want 503", got)
+	}
+	if got := status("/healthz"); got != http.StatusOK {
+		t.Errorf("healthz while draining: status %d", got)
+	}
+}
+
+func TestAdminHandler(t *testing.T) {
+	cfg := DefaultConfig("9001", "singapore")
+	cfg.IdentityPath = filepath.Join(t.TempDir(), "node.json")
+	s, err := NewServer(cfg, NewMemoryBackend())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s.AdminHandler())
+	defer ts.Close()
+
+	for path, want := range map[string]int{
+		"/healthz":       http.StatusOK,
+		"/readyz":        http.StatusOK,
+		"/version":       http.StatusOK,
+		"/info":          http.StatusOK,
+		"/api/v1/scrub":  http.StatusOK,
+		"/api/v1/limits": http.StatusOK,
+		"/files":         http.StatusNotFound,
+		"/files/a.txt":   http.StatusNotFound,
+	} {
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("%s: status %d, want %d", path, resp.StatusCode, want)
+		}
+	}
+	req, _ := http.NewRequest(http.MethodPut, ts.URL+"/api/v1/limits", strings.NewReader(`{"quota_bytes": 1}`))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("PUT limits on the admin listener: status %d, want 405", resp.StatusCode)
+	}
+}
+
+func TestListenConfig(t *testing.T) {
+	t.Setenv("LISTEN", "127.0.0.1:9001, [::1]:9001")
+	t.Setenv("ADMIN_LISTEN", "127.0.0.1:9101")
+	cfg, err := LoadConfig("9001", "singapore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.listenAddrs(); len(got) != 2 || got[1] != "[::1]:9001" || len(cfg.AdminListen) != 1 {
+		t.Errorf("listen %q, admin %q", got, cfg.AdminListen)
+	}
+	t.Setenv("LISTEN", "")
+	if cfg, _ := LoadConfig("9001", "singapore"); len(cfg.listenAddrs()) != 1 || cfg.listenAddrs()[0] != ":9001" {
+		t.Errorf("default listen %q", cfg.listenAddrs())
+	}
+	t.Setenv("ADMIN_LISTEN", "::1:9101")
+	if _, err := LoadConfig("9001", "singapore"); err == nil {
+		t.Error("unbracketed IPv6 address accepted")
+	}
+}
+
+func TestQuarantineRefusesDownloads(t *testing.T) {
+	cfg := DefaultConfig("9001", "singapore")
+	cfg.RegistrationToken = "tok"
+	cfg.IdentityPath = filepath.Join(t.TempDir(), "node.json")
+	cfg.QuarantinePath = filepath.Join(t.TempDir(), "quarantine.json")
+	backend := NewMemoryBackend()
+	s, err := NewServer(cfg, backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+	upload(t, ts.URL, "bad.txt", "nope")
+
+	put := func(token, body string) int {
+		req, _ := http.NewRequest("PUT", ts.URL+"/api/v1/quarantine", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	status := func(name string) int {
+		resp, err := http.Get(ts.URL + "/files/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if got := put("wrong", `["bad.txt"]`); got != http.StatusUnauthorized {
+		t.Errorf("PUT with a bad token: %d", got)
+	}
+	if got := put("tok", `["bad.txt"]`); got != http.StatusOK {
+		t.Fatalf("PUT: %d", got)
+	}
+	if got := status("bad.txt"); got != http.StatusUnavailableForLegalReasons {
+		t.Errorf("quarantined download: %d", got)
+	}
+
+	var info NodeInfo
+	resp, _ := http.Get(ts.URL + "/info")
+	json.NewDecoder(resp.Body).Decode(&info)
+	resp.Body.Close()
+	if info.Quarantine != quarantineDigest([]string{"bad.txt"}) {
+		t.Errorf("info digest = %q", info.Quarantine)
+	}
+
+	// The list survives a restart.
+	again, err := NewServer(cfg, backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !again.isQuarantined("bad.txt") {
+		t.Error("quarantine list lost on restart")
+	}
+
+	put("tok", `[]`)
+	if got := status("bad.txt"); got != http.StatusOK {
+		t.Errorf("released download: %d", got)
+	}
+}
+
+func TestCentralCallsNeedAToken(t *testing.T) {
+	s, err := NewServer(DefaultConfig("9001", "singapore"), NewMemoryBackend())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+	for _, tc := range []struct{ method, path, body string }{
+		{"POST", "/upload", ``},
+		{"GET", "/delete?filename=a.txt", ``},
+		{"PUT", "/api/v1/quarantine", `[]`},
+		{"PUT", "/api/v1/locks", `[]`},
+		{"PUT", "/api/v1/limits", `{}`},
+		{"PUT", "/api/v1/checksum", `{"algorithm": "sha256"}`},
+		{"POST", "/api/v1/scrub", ``},
+		{"POST", "/api/v1/ping-peers", `{"peers": {}}`},
+		{"POST", "/replicate?filename=a.txt&from=http://127.0.0.1:1&checksum=x", ``},
+	} {
+		req, _ := http.NewRequest(tc.method, ts.URL+tc.path, strings.NewReader(tc.body))
+		req.Header.Set("Authorization", "Bearer ")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("%s %s without a configured token: status %d, want 401", tc.method, tc.path, resp.StatusCode)
+		}
+	}
+}
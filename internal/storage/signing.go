@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+)
+
+// privateScope starts what is signed for a private file's URL, so a URL
+// handed out while the file was public stops working once it is private.
+const privateScope = "private\n"
+
+func validSignature(key []byte, message, expires, sig string) bool {
+	exp, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		return false
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(message + "\n" + expires))
+	want := hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(want), []byte(sig))
+}
+
+// requireSignature guards file downloads. It expects the /files/ prefix to
+// have been stripped already, so r.URL.Path is the file name. A private
+// file needs a signature for its private scope, and so a signing key;
+// without one it is not served at all. That signature is good for a file
+// not (or no longer) private as well: the central API hands it out only to
+// those who may read the file.
+func (s *Server) requireSignature(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := s.cfg.SigningKey
+		q := r.URL.Query()
+		private := len(key) > 0 && validSignature(key, privateScope+r.URL.Path, q.Get("expires"), q.Get("sig"))
+		switch {
+		case len(key) == 0 && s.private.has(r.URL.Path):
+			apierr.Send(w, "File is private", http.StatusForbidden)
+			return
+		case s.private.has(r.URL.Path) && !private:
+			apierr.Send(w, "File is private: invalid or expired signature", http.StatusForbidden)
+			return
+		case len(key) > 0 && !private && !validSignature(key, r.URL.Path, q.Get("expires"), q.Get("sig")):
+			apierr.Send(w, "Invalid or expired signature", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
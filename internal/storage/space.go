@@ -0,0 +1,232 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+)
+
+// Uploads are checked against the space left on the backend before any
+// bytes are written, and the space they announce is reserved while they are
+// in flight, so concurrent uploads cannot all count on the same free bytes.
+// A node that cannot take a file answers 507 Insufficient Storage, which the
+// central API takes as a cue to place the replica on another node.
+
+// errInsufficientStorage is returned when an upload does not fit on the
+// backend, errQuotaExceeded when it would take the node over its quota.
+// Both are answered with 507, the quota under its own error code.
+var (
+	errInsufficientStorage = errors.New("insufficient storage")
+	errQuotaExceeded       = errors.New("quota exceeded")
+)
+
+const codeQuotaExceeded = "quota_exceeded"
+
+// uploadSizeHeader carries the size of the file being uploaded, which the
+// multipart request's Content-Length only bounds (and chunked requests do
+// not have).
+const uploadSizeHeader = "X-File-Size"
+
+type spaceReservations struct {
+	mu       sync.Mutex
+	reserved uint64 // bytes announced by uploads in flight
+	files    int64  // uploads in flight
+}
+
+// QuotaStatus is a node's quota and usage, reported in its /info when a
+// quota is set.
+type QuotaStatus struct {
+	MaxBytes  int64 `json:"max_bytes,omitempty"`
+	MaxFiles  int64 `json:"max_files,omitempty"`
+	UsedBytes int64 `json:"used_bytes"`
+	UsedFiles int64 `json:"used_files"`
+	Full      bool  `json:"full"`
+}
+
+// Limits are the node's space limits. They start out as Config's and the
+// central API can change them at runtime, PUT /api/v1/limits, so a quota
+// can be raised without restarting the node.
+type Limits struct {
+	QuotaBytes   int64 `json:"quota_bytes"`
+	QuotaFiles   int64 `json:"quota_files"`
+	MinFreeBytes int64 `json:"min_free_bytes"`
+}
+
+func (c Config) limits() Limits {
+	return Limits{QuotaBytes: c.QuotaBytes, QuotaFiles: c.QuotaFiles, MinFreeBytes: c.MinFreeBytes}
+}
+
+func (l Limits) quotaEnabled() bool {
+	return l.QuotaBytes > 0 || l.QuotaFiles > 0
+}
+
+type limitsState struct {
+	mu  sync.Mutex
+	cur Limits
+}
+
+func (s *Server) currentLimits() Limits {
+	s.limits.mu.Lock()
+	defer s.limits.mu.Unlock()
+	return s.limits.cur
+}
+
+// limitsHandler serves the node's limits, and replaces them on PUT. Like
+// registration, changing them needs the registration token.
+func (s *Server) limitsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut {
+		if !s.checkToken(w, r) {
+			return
+		}
+		var l Limits
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<10)).Decode(&l); err != nil {
+			apierr.Send(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if l.QuotaBytes < 0 || l.QuotaFiles < 0 || l.MinFreeBytes < 0 {
+			apierr.Send(w, "Limits must not be negative", http.StatusBadRequest)
+			return
+		}
+		s.limits.mu.Lock()
+		old := s.limits.cur
+		s.limits.cur = l
+		s.limits.mu.Unlock()
+		if old != l {
+			fmt.Printf("Limits changed: %+v\n", l)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.currentLimits())
+}
+
+// usage adds up what the backend stores, leaving out the file called skip
+// (an upload replacing it frees its space).
+func (s *Server) usage(skip string) (bytes, files int64, err error) {
+	objs, err := s.backend.List()
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, o := range objs {
+		if o.Name != skip {
+			bytes += o.Size
+			files++
+		}
+	}
+	return bytes, files, nil
+}
+
+// quotaStatus reports the node's quota and usage, or nil without a quota.
+func (s *Server) quotaStatus() *QuotaStatus {
+	l := s.currentLimits()
+	if !l.quotaEnabled() {
+		return nil
+	}
+	bytes, files, err := s.usage("")
+	if err != nil {
+		return nil
+	}
+	return &QuotaStatus{
+		MaxBytes:  l.QuotaBytes,
+		MaxFiles:  l.QuotaFiles,
+		UsedBytes: bytes,
+		UsedFiles: files,
+		Full: (l.QuotaBytes > 0 && bytes >= l.QuotaBytes) ||
+			(l.QuotaFiles > 0 && files >= l.QuotaFiles),
+	}
+}
+
+// reserveSpace holds n bytes for an upload of name (n may be 0 if the size
+// is not known, which still fails on a node that is already full or at its
+// quota). The returned release must be called once the upload is written or
+// abandoned. limit is the most the file may take under the byte quota, or
+// -1; an upload that did not announce its size can still run into it.
+// Backends that cannot report their free space are only held to the quota.
+func (s *Server) reserveSpace(name string, n int64) (release func(), limit int64, err error) {
+	var free uint64
+	u, checkFree := s.backend.(usageReporter)
+	if checkFree {
+		if _, free, err = u.Usage(); err != nil {
+			return nil, 0, err
+		}
+	}
+	l := s.currentLimits()
+	var usedBytes, usedFiles int64
+	if l.quotaEnabled() {
+		if usedBytes, usedFiles, err = s.usage(name); err != nil {
+			return nil, 0, err
+		}
+	}
+	need := max(n, 0)
+
+	rs := &s.reservations
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if checkFree {
+		avail := uint64(0)
+		if keep := rs.reserved + uint64(l.MinFreeBytes); free > keep {
+			avail = free - keep
+		}
+		if avail == 0 || uint64(need) > avail {
+			return nil, 0, fmt.Errorf("%w: need %d bytes, %d available", errInsufficientStorage, need, avail)
+		}
+	}
+	limit = -1
+	if q := l.QuotaBytes; q > 0 {
+		limit = q - usedBytes - int64(rs.reserved)
+		if limit <= 0 || need > limit {
+			return nil, 0, fmt.Errorf("%w: %d of %d bytes used, need %d", errQuotaExceeded, usedBytes+int64(rs.reserved), q, need)
+		}
+	}
+	if q := l.QuotaFiles; q > 0 && usedFiles+rs.files >= q {
+		return nil, 0, fmt.Errorf("%w: %d of %d files stored", errQuotaExceeded, usedFiles+rs.files, q)
+	}
+	rs.reserved += uint64(need)
+	rs.files++
+	return func() {
+		rs.mu.Lock()
+		rs.reserved -= uint64(need)
+		rs.files--
+		rs.mu.Unlock()
+	}, limit, nil
+}
+
+// limitedBody fails an upload once it goes over limit bytes.
+type limitedBody struct {
+	r     io.Reader
+	limit int64
+	n     int64
+}
+
+func (l *limitedBody) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if l.limit >= 0 && l.n > l.limit {
+		return n, fmt.Errorf("%w: file is over the %d bytes left", errQuotaExceeded, l.limit)
+	}
+	return n, err
+}
+
+// declaredSize is the size an upload request announces, or -1.
+func declaredSize(r *http.Request) int64 {
+	if v := r.Header.Get(uploadSizeHeader); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n >= 0 {
+			return n
+		}
+	}
+	return r.ContentLength
+}
+
+// isNoSpace reports whether an upload was refused or failed for lack of
+// space: the checks above, or a disk that filled up anyway (another
+// process, or a size that was not announced).
+func isNoSpace(err error) bool {
+	return errors.Is(err, errInsufficientStorage) || errors.Is(err, errQuotaExceeded) ||
+		errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EDQUOT)
+}
@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// sizedBackend is a memory backend that reports a fixed amount of free
+// space.
+type sizedBackend struct {
+	Backend
+	free uint64
+}
+
+func (b sizedBackend) Usage() (total, free uint64, err error) { return 1 << 30, b.free, nil }
+
+func TestReserveSpace(t *testing.T) {
+	cfg := DefaultConfig("9001", "singapore")
+	cfg.MinFreeBytes = 100
+	s := &Server{cfg: cfg, backend: sizedBackend{NewMemoryBackend(), 1000}, limits: limitsState{cur: cfg.limits()}}
+
+	release, _, err := s.reserveSpace("a", 600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 1000 free, 100 kept back and 600 reserved leave 300.
+	if _, _, err := s.reserveSpace("b", 400); !errors.Is(err, errInsufficientStorage) {
+		t.Errorf("second reservation: err %v, want insufficient storage", err)
+	}
+	if r, _, err := s.reserveSpace("b", 300); err != nil {
+		t.Errorf("reservation that fits: %v", err)
+	} else {
+		r()
+	}
+	release()
+	if r, _, err := s.reserveSpace("b", 900); err != nil {
+		t.Errorf("reservation after release: %v", err)
+	} else {
+		r()
+	}
+}
+
+func TestUploadTooLargeIs507(t *testing.T) {
+	ts := newTestServerOn(t, DefaultConfig("9001", "singapore"), sizedBackend{NewMemoryBackend(), 10})
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("file", "big.bin")
+	fw.Write(make([]byte, 64))
+	mw.Close()
+	req, _ := http.NewRequest("POST", ts.URL+"/upload", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set(uploadSizeHeader, "64")
+	resp, err := centralClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInsufficientStorage {
+		t.Errorf("status %d, want 507", resp.StatusCode)
+	}
+
+	if resp := upload(t, ts.URL, "small.txt", "hi"); resp.StatusCode != http.StatusInsufficientStorage {
+		// Without the header the request's Content-Length (well over 10
+		// bytes with the multipart envelope) is what counts.
+		t.Errorf("upload without size header: status %d, want 507", resp.StatusCode)
+	}
+}
+
+func TestQuota(t *testing.T) {
+	cfg := DefaultConfig("9001", "singapore")
+	cfg.QuotaBytes = 10
+	cfg.QuotaFiles = 2
+	cfg.RegistrationToken = "tok"
+	ts := newTestServer(t, cfg)
+
+	post := func(name, content string) int {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		fw, _ := mw.CreateFormFile("file", name)
+		io.WriteString(fw, content)
+		mw.Close()
+		req, _ := http.NewRequest("POST", ts.URL+"/upload", &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		req.Header.Set(uploadSizeHeader, strconv.Itoa(len(content)))
+		resp, err := centralClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	for _, tc := range []struct {
+		name, content string
+		want          int
+	}{
+		{"a.txt", "123456", http.StatusOK},
+		{"b.txt", "12345", http.StatusInsufficientStorage}, // 11 bytes
+		{"b.txt", "1234", http.StatusOK},
+		{"a.txt", "12", http.StatusOK},                 // replacing frees a.txt's bytes
+		{"c.txt", "1", http.StatusInsufficientStorage}, // third file
+	} {
+		if got := post(tc.name, tc.content); got != tc.want {
+			t.Errorf("upload %s (%d bytes): status %d, want %d", tc.name, len(tc.content), got, tc.want)
+		}
+	}
+
+	resp, err := http.Get(ts.URL + "/info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var info NodeInfo
+	json.NewDecoder(resp.Body).Decode(&info)
+	resp.Body.Close()
+	if q := info.Quota; q == nil || q.UsedBytes != 6 || q.UsedFiles != 2 || !q.Full {
+		t.Errorf("info quota = %+v, want 6 bytes, 2 files, full", q)
+	}
+
+	// Raised at runtime, the quota takes the third file.
+	req, _ := http.NewRequest("PUT", ts.URL+"/api/v1/limits", strings.NewReader(`{"quota_bytes": 100, "quota_files": 3}`))
+	req.Header.Set("Authorization", "Bearer tok")
+	if resp, err = http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("set limits: status %d", resp.StatusCode)
+	}
+	if got := post("c.txt", "1"); got != http.StatusOK {
+		t.Errorf("upload c.txt after raising the quota: status %d", got)
+	}
+}
+
+func TestQuotaStopsUnannouncedUpload(t *testing.T) {
+	cfg := DefaultConfig("9001", "singapore")
+	cfg.QuotaBytes = 1 << 20
+	ts := newTestServer(t, cfg)
+
+	// Chunked, with no size header: only the bytes themselves give it away.
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("file", "big.bin")
+	fw.Write(make([]byte, 2<<20))
+	mw.Close()
+	req, _ := http.NewRequest("POST", ts.URL+"/upload", io.MultiReader(&body))
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	resp, err := centralClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInsufficientStorage {
+		t.Errorf("status %d, want 507", resp.StatusCode)
+	}
+}
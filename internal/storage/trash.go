@@ -0,0 +1,15 @@
+package storage
+
+import "net/http"
+
+// The central API deletes a file in two steps: first it moves the file to
+// its trash, where the owner can restore it, and only when the retention
+// period is over does it delete the replicas. Meanwhile the node keeps its
+// replica but answers as if it were gone, for the names on the trash list
+// the central API sends.
+
+// trashHandler serves the node's trash list, and replaces it on a PUT of a
+// JSON array of names, authenticated like registration.
+func (s *Server) trashHandler(w http.ResponseWriter, r *http.Request) {
+	s.nameListHandler(w, r, &s.trash, s.cfg.TrashPath, "Trash")
+}
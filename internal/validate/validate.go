@@ -0,0 +1,210 @@
+// Package validate checks requests before their handlers see them: the
+// wildcards of the route, the values of known query and form parameters,
+// and the size a client declares for its upload against the body it sends.
+// A request that fails is answered with a 400 invalid_parameter error (see
+// apierr) naming the parameter, so handlers only deal with what valid
+// parameters mean. Whether a parameter is required, and what it refers to,
+// is still up to the handler.
+package validate
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+)
+
+// MaxNameLength is the longest file name, in bytes, that most file
+// systems take.
+const MaxNameLength = 255
+
+// maxValue bounds any parameter without a rule of its own.
+const maxValue = 4096
+
+// SizeHeader carries the size of the file being uploaded when the body is
+// larger (a multipart envelope) or its length is not known yet.
+const SizeHeader = "X-File-Size"
+
+// Name checks a flat file name: valid UTF-8 of at most MaxNameLength
+// bytes, without control characters or path separators, and not "." or
+// "..".
+func Name(s string) error {
+	switch {
+	case s == "." || s == "..":
+		return fmt.Errorf("%q is not a file name", s)
+	case len(s) > MaxNameLength:
+		return fmt.Errorf("longer than %d bytes", MaxNameLength)
+	case !utf8.ValidString(s):
+		return fmt.Errorf("not valid UTF-8")
+	case strings.ContainsAny(s, `/\`):
+		return fmt.Errorf("must not contain / or \\")
+	case strings.ContainsFunc(s, unicode.IsControl):
+		return fmt.Errorf("must not contain control characters")
+	}
+	return nil
+}
+
+// A Rule checks the value of a parameter that is present.
+type Rule func(v string) error
+
+// Rules are the rules of parameters by name.
+type Rules map[string]Rule
+
+// Names checks a comma-separated list of file names.
+func Names(v string) error {
+	for _, name := range strings.Split(v, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if err := Name(name); err != nil {
+			return fmt.Errorf("%q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Int accepts integers from min to max.
+func Int(min, max int64) Rule {
+	return func(v string) error {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < min || n > max {
+			if max == math.MaxInt64 {
+				return fmt.Errorf("must be an integer of at least %d", min)
+			}
+			return fmt.Errorf("must be an integer from %d to %d", min, max)
+		}
+		return nil
+	}
+}
+
+// Float accepts numbers from min to max.
+func Float(min, max float64) Rule {
+	return func(v string) error {
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil || f < min || f > max {
+			return fmt.Errorf("must be a number from %g to %g", min, max)
+		}
+		return nil
+	}
+}
+
+// Duration accepts durations like "30s" of at most max.
+func Duration(max time.Duration) Rule {
+	return func(v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 || d > max {
+			return fmt.Errorf("must be a duration of at most %s", max)
+		}
+		return nil
+	}
+}
+
+// Bool accepts what strconv.ParseBool does: 1, true, 0, false, ...
+func Bool(v string) error {
+	if _, err := strconv.ParseBool(v); err != nil {
+		return fmt.Errorf("must be true or false")
+	}
+	return nil
+}
+
+// Match accepts values matching re, described by want.
+func Match(re *regexp.Regexp, want string) Rule {
+	return func(v string) error {
+		if !re.MatchString(v) {
+			return fmt.Errorf("must be %s", want)
+		}
+		return nil
+	}
+}
+
+// Mux is an http.ServeMux that checks each request against rules before
+// handing it to the route's handler. Route wildcards without a rule of
+// their own must be file names (see Name).
+type Mux struct {
+	*http.ServeMux
+	rules Rules
+}
+
+func NewMux(rules Rules) *Mux {
+	return &Mux{ServeMux: http.NewServeMux(), rules: rules}
+}
+
+// Handle registers h for pattern, behind the checks.
+func (m *Mux) Handle(pattern string, h http.Handler) {
+	m.ServeMux.Handle(pattern, m.checked(h))
+}
+
+// HandleFunc registers h for pattern, behind the checks.
+func (m *Mux) HandleFunc(pattern string, h func(http.ResponseWriter, *http.Request)) {
+	m.Handle(pattern, http.HandlerFunc(h))
+}
+
+func (m *Mux) checked(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := m.check(r); err != nil {
+			apierr.SendCode(w, http.StatusBadRequest, "invalid_parameter", err.Error())
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+var wildcard = regexp.MustCompile(`\{(\w+)(?:\.\.\.)?\}`)
+
+// check returns what is wrong with r, if anything.
+func (m *Mux) check(r *http.Request) error {
+	for _, w := range wildcard.FindAllStringSubmatch(r.Pattern, -1) {
+		rule, ok := m.rules[w[1]]
+		if !ok {
+			rule = Name
+		}
+		if err := rule(r.PathValue(w[1])); err != nil {
+			return fmt.Errorf("%s: %w", w[1], err)
+		}
+	}
+
+	// Url-encoded forms count as parameters too; other bodies, multipart
+	// ones included, are left unread for the handler.
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	for name, values := range r.Form {
+		for _, v := range values {
+			if v == "" {
+				continue
+			}
+			err := m.checkValue(name, v)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+
+	if v := r.Header.Get(SizeHeader); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		switch {
+		case err != nil || n < 0:
+			return fmt.Errorf("%s must be a size in bytes", SizeHeader)
+		case r.ContentLength >= 0 && n > r.ContentLength:
+			return fmt.Errorf("%s of %d bytes is larger than the %d byte body", SizeHeader, n, r.ContentLength)
+		}
+	}
+	return nil
+}
+
+func (m *Mux) checkValue(name, v string) error {
+	if rule, ok := m.rules[name]; ok {
+		return rule(v)
+	}
+	if len(v) > maxValue {
+		return fmt.Errorf("longer than %d bytes", maxValue)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package validate
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hongkhy-kong/Distributed_mission_1/internal/apierr"
+)
+
+func TestName(t *testing.T) {
+	for name, ok := range map[string]bool{
+		"report.pdf":             true,
+		"u~alice~notes, v2.txt":  true,
+		"héllo wörld.txt":        true,
+		".hidden":                true,
+		".":                      false,
+		"..":                     false,
+		"a/b":                    false,
+		`a\b`:                    false,
+		"tab\there":              false,
+		"\xff":                   false,
+		strings.Repeat("x", 255): true,
+		strings.Repeat("x", 256): false,
+	} {
+		if err := Name(name); (err == nil) != ok {
+			t.Errorf("Name(%q) = %v, want ok %v", name, err, ok)
+		}
+	}
+}
+
+func TestMux(t *testing.T) {
+	mux := NewMux(Rules{"limit": Int(1, 10), "wait": Duration(time.Minute), "id": Int(1, 99)})
+	mux.HandleFunc("/files/{name}", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("POST /jobs/{id}", func(w http.ResponseWriter, r *http.Request) {})
+
+	for _, tc := range []struct {
+		method, target, form string
+		header               http.Header
+		want                 int
+	}{
+		{"GET", "/files/a.txt?limit=10&wait=5s", "", nil, 200},
+		{"GET", "/files/a.txt?limit=11", "", nil, 400},
+		{"GET", "/files/a.txt?wait=1h", "", nil, 400},
+		{"GET", "/files/a%2Fb", "", nil, 400},
+		{"GET", "/files/a.txt?other=" + strings.Repeat("x", 5000), "", nil, 400},
+		{"POST", "/jobs/7", "", nil, 200},
+		{"POST", "/jobs/x", "", nil, 400},
+		{"POST", "/jobs/7", "limit=0", nil, 400},
+		{"POST", "/jobs/7", "abc", http.Header{SizeHeader: {"3"}}, 200},
+		{"POST", "/jobs/7", "abc", http.Header{SizeHeader: {"4"}}, 400},
+		{"POST", "/jobs/7", "abc", http.Header{SizeHeader: {"-1"}}, 400},
+	} {
+		req := httptest.NewRequest(tc.method, tc.target, strings.NewReader(tc.form))
+		if tc.form != "" && tc.header == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		for k, v := range tc.header {
+			req.Header[k] = v
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s %s %q = %d, want %d: %s", tc.method, tc.target, tc.form, rec.Code, tc.want, rec.Body)
+			continue
+		}
+		if tc.want == 400 {
+			if e := apierr.Parse(rec.Code, rec.Body.Bytes()); e.Code != "invalid_parameter" {
+				t.Errorf("%s %s: error %+v, want invalid_parameter", tc.method, tc.target, e)
+			}
+		}
+	}
+}
+
+func TestNames(t *testing.T) {
+	if err := Names("a.txt, b.txt,,"); err != nil {
+		t.Error(err)
+	}
+	if err := Names("a.txt,../b"); err == nil {
+		t.Error("../b accepted")
+	}
+}